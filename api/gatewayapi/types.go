@@ -0,0 +1,408 @@
+// Package gatewayapi contains the subset of the Kubernetes Gateway API types
+// that the gateway controllers read and the status they write. They're
+// defined here since the Gateway API module isn't a dependency, and are
+// decoded from the unstructured resources of the dynamic client.
+package gatewayapi
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// Group is the API group of the Gateway API.
+	Group = "gateway.networking.k8s.io"
+
+	// GatewayClassResource, GatewayResource, HTTPRouteResource,
+	// TCPRouteResource and ReferenceGrantResource are the resource names of
+	// the kinds.
+	GatewayClassResource   = "gatewayclasses"
+	GatewayResource        = "gateways"
+	HTTPRouteResource      = "httproutes"
+	TCPRouteResource       = "tcproutes"
+	ReferenceGrantResource = "referencegrants"
+
+	// HTTPRouteKind and TCPRouteKind are the kinds of the routes.
+	HTTPRouteKind = "HTTPRoute"
+	TCPRouteKind  = "TCPRoute"
+)
+
+var (
+	// GroupVersion is the version of GatewayClass, Gateway, HTTPRoute and
+	// ReferenceGrant.
+	GroupVersion = schema.GroupVersion{Group: Group, Version: "v1beta1"}
+
+	// ExperimentalGroupVersion is the version of TCPRoute, which is only
+	// part of the experimental channel.
+	ExperimentalGroupVersion = schema.GroupVersion{Group: Group, Version: "v1alpha2"}
+)
+
+// Protocols of the gateway listeners.
+const (
+	HTTPProtocol  = "HTTP"
+	HTTPSProtocol = "HTTPS"
+	TCPProtocol   = "TCP"
+)
+
+// Types of the route matches.
+const (
+	PathMatchExact             = "Exact"
+	PathMatchPathPrefix        = "PathPrefix"
+	PathMatchRegularExpression = "RegularExpression"
+	MatchExact                 = "Exact"
+	MatchRegularExpression     = "RegularExpression"
+)
+
+// Values of AllowedRoutes.Namespaces.From.
+const (
+	NamespacesFromAll      = "All"
+	NamespacesFromSame     = "Same"
+	NamespacesFromSelector = "Selector"
+)
+
+// GatewayClass is a class of gateways implemented by a controller.
+type GatewayClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec GatewayClassSpec `json:"spec"`
+}
+
+// GatewayClassSpec is the spec of GatewayClass.
+type GatewayClassSpec struct {
+	ControllerName string               `json:"controllerName"`
+	ParametersRef  *ParametersReference `json:"parametersRef,omitempty"`
+}
+
+// ParametersReference references the resource configuring the gateways of
+// a class.
+type ParametersReference struct {
+	Group     string `json:"group"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Gateway is a gateway of a GatewayClass.
+type Gateway struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GatewaySpec   `json:"spec"`
+	Status GatewayStatus `json:"status,omitempty"`
+}
+
+// GatewaySpec is the spec of Gateway.
+type GatewaySpec struct {
+	GatewayClassName string     `json:"gatewayClassName"`
+	Listeners        []Listener `json:"listeners"`
+}
+
+// Listener is a listener of a gateway.
+type Listener struct {
+	Name          string            `json:"name"`
+	Hostname      string            `json:"hostname,omitempty"`
+	Port          int32             `json:"port"`
+	Protocol      string            `json:"protocol"`
+	TLS           *GatewayTLSConfig `json:"tls,omitempty"`
+	AllowedRoutes *AllowedRoutes    `json:"allowedRoutes,omitempty"`
+}
+
+// GatewayTLSConfig is the TLS configuration of a listener.
+type GatewayTLSConfig struct {
+	Mode            string                  `json:"mode,omitempty"`
+	CertificateRefs []SecretObjectReference `json:"certificateRefs,omitempty"`
+}
+
+// SecretObjectReference references a secret.
+type SecretObjectReference struct {
+	Group     string `json:"group,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// AllowedRoutes restricts the routes that can attach to a listener.
+type AllowedRoutes struct {
+	Namespaces *RouteNamespaces `json:"namespaces,omitempty"`
+}
+
+// RouteNamespaces restricts the namespaces of the routes that can attach
+// to a listener.
+type RouteNamespaces struct {
+	From     string                `json:"from,omitempty"`
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// GatewayStatus is the status of Gateway.
+type GatewayStatus struct {
+	Addresses  []GatewayAddress `json:"addresses,omitempty"`
+	Conditions Conditions       `json:"conditions,omitempty"`
+}
+
+// GatewayAddress is an address of a gateway.
+type GatewayAddress struct {
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value"`
+}
+
+// ParentReference references the gateway, and optionally the listener, a
+// route attaches to.
+type ParentReference struct {
+	Group       string `json:"group,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name"`
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// BackendRef references the backend of a route.
+type BackendRef struct {
+	Group     string `json:"group,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Port      int32  `json:"port,omitempty"`
+	Weight    *int32 `json:"weight,omitempty"`
+}
+
+// HTTPRoute routes HTTP requests to backends.
+type HTTPRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HTTPRouteSpec `json:"spec"`
+	Status RouteStatus   `json:"status,omitempty"`
+}
+
+// HTTPRouteSpec is the spec of HTTPRoute.
+type HTTPRouteSpec struct {
+	ParentRefs []ParentReference `json:"parentRefs,omitempty"`
+	Hostnames  []string          `json:"hostnames,omitempty"`
+	Rules      []HTTPRouteRule   `json:"rules,omitempty"`
+}
+
+// HTTPRouteRule is a rule of an HTTPRoute.
+type HTTPRouteRule struct {
+	Matches     []HTTPRouteMatch  `json:"matches,omitempty"`
+	Filters     []HTTPRouteFilter `json:"filters,omitempty"`
+	BackendRefs []BackendRef      `json:"backendRefs,omitempty"`
+}
+
+// HTTPRouteMatch matches HTTP requests.
+type HTTPRouteMatch struct {
+	Path        *HTTPPathMatch        `json:"path,omitempty"`
+	Headers     []HTTPHeaderMatch     `json:"headers,omitempty"`
+	QueryParams []HTTPQueryParamMatch `json:"queryParams,omitempty"`
+	Method      string                `json:"method,omitempty"`
+}
+
+// HTTPPathMatch matches the path of HTTP requests.
+type HTTPPathMatch struct {
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// HTTPHeaderMatch matches a header of HTTP requests.
+type HTTPHeaderMatch struct {
+	Type  string `json:"type,omitempty"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HTTPQueryParamMatch matches a query parameter of HTTP requests.
+type HTTPQueryParamMatch struct {
+	Type  string `json:"type,omitempty"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HTTPRouteFilter modifies the requests matched by a rule.
+type HTTPRouteFilter struct {
+	Type                  string                `json:"type"`
+	RequestHeaderModifier *HTTPHeaderFilter     `json:"requestHeaderModifier,omitempty"`
+	ExtensionRef          *LocalObjectReference `json:"extensionRef,omitempty"`
+	URLRewrite            *HTTPURLRewriteFilter `json:"urlRewrite,omitempty"`
+}
+
+// Types of the HTTP route filters.
+const (
+	FilterRequestHeaderModifier = "RequestHeaderModifier"
+	FilterURLRewrite            = "URLRewrite"
+	FilterExtensionRef          = "ExtensionRef"
+)
+
+// HTTPHeaderFilter modifies the headers of requests.
+type HTTPHeaderFilter struct {
+	Set    []HTTPHeader `json:"set,omitempty"`
+	Add    []HTTPHeader `json:"add,omitempty"`
+	Remove []string     `json:"remove,omitempty"`
+}
+
+// HTTPHeader is a header name and value.
+type HTTPHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HTTPURLRewriteFilter rewrites the URL of requests.
+type HTTPURLRewriteFilter struct {
+	Path *HTTPPathModifier `json:"path,omitempty"`
+}
+
+// HTTPPathModifier rewrites the path of requests. Only prefix replacement
+// is supported by Consul.
+type HTTPPathModifier struct {
+	Type               string `json:"type"`
+	ReplacePrefixMatch string `json:"replacePrefixMatch,omitempty"`
+}
+
+// LocalObjectReference references a resource in the route's namespace.
+type LocalObjectReference struct {
+	Group string `json:"group"`
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+}
+
+// TCPRoute routes TCP connections to backends.
+type TCPRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TCPRouteSpec `json:"spec"`
+	Status RouteStatus  `json:"status,omitempty"`
+}
+
+// TCPRouteSpec is the spec of TCPRoute.
+type TCPRouteSpec struct {
+	ParentRefs []ParentReference `json:"parentRefs,omitempty"`
+	Rules      []TCPRouteRule    `json:"rules,omitempty"`
+}
+
+// TCPRouteRule is a rule of a TCPRoute.
+type TCPRouteRule struct {
+	BackendRefs []BackendRef `json:"backendRefs,omitempty"`
+}
+
+// ReferenceGrant permits the resources of other namespaces to reference
+// the resources of its namespace, e.g. the routes of other namespaces to
+// route to its Services.
+type ReferenceGrant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ReferenceGrantSpec `json:"spec"`
+}
+
+// ReferenceGrantSpec is the spec of ReferenceGrant.
+type ReferenceGrantSpec struct {
+	From []ReferenceGrantFrom `json:"from"`
+	To   []ReferenceGrantTo   `json:"to"`
+}
+
+// ReferenceGrantFrom is a kind of resources of a namespace permitted to
+// reference the resources of the grant.
+type ReferenceGrantFrom struct {
+	Group     string `json:"group"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+}
+
+// ReferenceGrantTo is a kind of resources, or a resource if the name is
+// set, that can be referenced.
+type ReferenceGrantTo struct {
+	Group string  `json:"group"`
+	Kind  string  `json:"kind"`
+	Name  *string `json:"name,omitempty"`
+}
+
+// Permits returns true if the grant permits the resources of from to
+// reference the resource of its namespace with the group, kind and name.
+// The core group can be either empty or "core".
+func (g *ReferenceGrant) Permits(from ReferenceGrantFrom, group, kind, name string) bool {
+	fromFound := false
+	for _, f := range g.Spec.From {
+		if f.Group == from.Group && f.Kind == from.Kind && f.Namespace == from.Namespace {
+			fromFound = true
+			break
+		}
+	}
+	if !fromFound {
+		return false
+	}
+	for _, to := range g.Spec.To {
+		if coreGroup(to.Group) == coreGroup(group) && to.Kind == kind && (to.Name == nil || *to.Name == name) {
+			return true
+		}
+	}
+	return false
+}
+
+func coreGroup(group string) string {
+	if group == "core" {
+		return ""
+	}
+	return group
+}
+
+// RouteStatus is the status of the routes.
+type RouteStatus struct {
+	Parents []RouteParentStatus `json:"parents,omitempty"`
+}
+
+// RouteParentStatus is the status of a route for one of its parents.
+type RouteParentStatus struct {
+	ParentRef      ParentReference `json:"parentRef"`
+	ControllerName string          `json:"controllerName"`
+	Conditions     Conditions      `json:"conditions,omitempty"`
+}
+
+// Condition is a condition of the Gateway API status, which unlike the
+// conditions of the consul.hashicorp.com resources require a message and
+// report the generation they were computed for.
+type Condition struct {
+	Type               string      `json:"type"`
+	Status             string      `json:"status"`
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+	Reason             string      `json:"reason"`
+	Message            string      `json:"message"`
+}
+
+// Conditions are the conditions of a status.
+type Conditions []Condition
+
+// Set sets the condition of the given type. The transition time is only
+// updated if the condition's status changes. It returns false if the
+// conditions already had the same condition.
+func (c *Conditions) Set(condition Condition) bool {
+	for i := range *c {
+		existing := &(*c)[i]
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status && existing.Reason == condition.Reason &&
+			existing.Message == condition.Message && existing.ObservedGeneration == condition.ObservedGeneration {
+			return false
+		}
+		condition.LastTransitionTime = existing.LastTransitionTime
+		if existing.Status != condition.Status {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		*existing = condition
+		return true
+	}
+	condition.LastTransitionTime = metav1.Now()
+	*c = append(*c, condition)
+	return true
+}
+
+// Get returns the condition of the given type or nil if there's none.
+func (c Conditions) Get(conditionType string) *Condition {
+	for i := range c {
+		if c[i].Type == conditionType {
+			return &c[i]
+		}
+	}
+	return nil
+}
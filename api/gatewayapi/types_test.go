@@ -0,0 +1,71 @@
+package gatewayapi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConditions_Set(t *testing.T) {
+	transition := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	conditions := Conditions{{Type: "Accepted", Status: "True", Reason: "Accepted", LastTransitionTime: transition}}
+
+	// Setting the same condition doesn't change the conditions.
+	require.False(t, conditions.Set(Condition{Type: "Accepted", Status: "True", Reason: "Accepted"}))
+	require.Equal(t, transition, conditions.Get("Accepted").LastTransitionTime)
+
+	// The transition time is kept if only the message changes.
+	require.True(t, conditions.Set(Condition{Type: "Accepted", Status: "True", Reason: "Accepted", Message: "all good", ObservedGeneration: 2}))
+	require.Equal(t, "all good", conditions.Get("Accepted").Message)
+	require.Equal(t, int64(2), conditions.Get("Accepted").ObservedGeneration)
+	require.Equal(t, transition, conditions.Get("Accepted").LastTransitionTime)
+
+	// It's updated if the status changes.
+	require.True(t, conditions.Set(Condition{Type: "Accepted", Status: "False", Reason: "Invalid"}))
+	require.True(t, conditions.Get("Accepted").LastTransitionTime.After(transition.Time))
+
+	require.True(t, conditions.Set(Condition{Type: "Programmed", Status: "False", Reason: "Pending"}))
+	require.Len(t, conditions, 2)
+	require.Nil(t, conditions.Get("ResolvedRefs"))
+}
+
+func TestReferenceGrant_Permits(t *testing.T) {
+	db := "db"
+	grant := &ReferenceGrant{Spec: ReferenceGrantSpec{
+		From: []ReferenceGrantFrom{{Group: Group, Kind: HTTPRouteKind, Namespace: "frontend"}},
+		To: []ReferenceGrantTo{
+			{Group: "", Kind: "Service", Name: &db},
+			{Group: "example.com", Kind: "Bucket"},
+		},
+	}}
+	from := ReferenceGrantFrom{Group: Group, Kind: HTTPRouteKind, Namespace: "frontend"}
+	cases := map[string]struct {
+		from   ReferenceGrantFrom
+		group  string
+		kind   string
+		name   string
+		expect bool
+	}{
+		"named service":          {from: from, kind: "Service", name: "db", expect: true},
+		"named service of core":  {from: from, group: "core", kind: "Service", name: "db", expect: true},
+		"other service":          {from: from, kind: "Service", name: "web"},
+		"any resource of a kind": {from: from, group: "example.com", kind: "Bucket", name: "logs", expect: true},
+		"other namespace": {
+			from: ReferenceGrantFrom{Group: Group, Kind: HTTPRouteKind, Namespace: "other"},
+			kind: "Service",
+			name: "db",
+		},
+		"other kind": {
+			from: ReferenceGrantFrom{Group: Group, Kind: TCPRouteKind, Namespace: "frontend"},
+			kind: "Service",
+			name: "db",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.expect, grant.Permits(c.from, c.group, c.kind, c.name))
+		})
+	}
+}
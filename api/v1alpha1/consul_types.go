@@ -0,0 +1,621 @@
+package v1alpha1
+
+// This file defines the config entries of newer Consul versions that the
+// Consul API client doesn't support yet. They only have the fields that
+// the custom resources configure so that fields Consul adds when storing
+// the entries are ignored when comparing them.
+
+// ServiceIntentionsKind is the kind of the service-intentions config entry.
+const ServiceIntentionsKind = "service-intentions"
+
+// ServiceIntentionsConfigEntry is the service-intentions config entry
+// holding all the intentions of a destination service.
+type ServiceIntentionsConfigEntry struct {
+	Kind      string
+	Name      string
+	Namespace string `json:",omitempty"`
+
+	Sources []*SourceIntention
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// SourceIntention is an intention of a service-intentions config entry.
+type SourceIntention struct {
+	Name        string
+	Namespace   string                 `json:",omitempty"`
+	Action      string                 `json:",omitempty"`
+	Permissions []*IntentionPermission `json:",omitempty"`
+	Description string                 `json:",omitempty"`
+}
+
+// IntentionPermission is an L7 permission of a source intention.
+type IntentionPermission struct {
+	Action string
+	HTTP   *IntentionHTTPPermission `json:",omitempty"`
+}
+
+// IntentionHTTPPermission is the HTTP criteria of a permission.
+type IntentionHTTPPermission struct {
+	PathExact  string `json:",omitempty"`
+	PathPrefix string `json:",omitempty"`
+	PathRegex  string `json:",omitempty"`
+
+	Header []IntentionHTTPHeaderPermission `json:",omitempty"`
+
+	Methods []string `json:",omitempty"`
+}
+
+// IntentionHTTPHeaderPermission matches an HTTP request header.
+type IntentionHTTPHeaderPermission struct {
+	Name    string
+	Present bool   `json:",omitempty"`
+	Exact   string `json:",omitempty"`
+	Prefix  string `json:",omitempty"`
+	Suffix  string `json:",omitempty"`
+	Regex   string `json:",omitempty"`
+	Invert  bool   `json:",omitempty"`
+}
+
+func (e *ServiceIntentionsConfigEntry) GetKind() string        { return e.Kind }
+func (e *ServiceIntentionsConfigEntry) GetName() string        { return e.Name }
+func (e *ServiceIntentionsConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *ServiceIntentionsConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+// IngressGatewayKind is the kind of the ingress-gateway config entry.
+const IngressGatewayKind = "ingress-gateway"
+
+// IngressGatewayConfigEntry is the ingress-gateway config entry
+// configuring the listeners of an ingress gateway.
+type IngressGatewayConfigEntry struct {
+	Kind      string
+	Name      string
+	Namespace string `json:",omitempty"`
+
+	TLS       GatewayTLSConfig
+	Listeners []IngressListener
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// GatewayTLSConfig is the TLS configuration of a gateway.
+type GatewayTLSConfig struct {
+	Enabled       bool
+	TLSMinVersion string               `json:",omitempty"`
+	TLSMaxVersion string               `json:",omitempty"`
+	CipherSuites  []string             `json:",omitempty"`
+	SDS           *GatewayTLSSDSConfig `json:",omitempty"`
+}
+
+// GatewayTLSSDSConfig configures a gateway to fetch its certificate from
+// an SDS server. Requires Consul 1.11 or later.
+type GatewayTLSSDSConfig struct {
+	ClusterName  string `json:",omitempty"`
+	CertResource string `json:",omitempty"`
+}
+
+// GatewayServiceTLSConfig is the TLS configuration of a service exposed by
+// a gateway listener.
+type GatewayServiceTLSConfig struct {
+	SDS *GatewayTLSSDSConfig `json:",omitempty"`
+}
+
+// IngressListener is a listener of an ingress gateway.
+type IngressListener struct {
+	Port     int
+	Protocol string
+	Services []IngressService
+	TLS      *GatewayTLSConfig `json:",omitempty"`
+}
+
+// IngressService is a service exposed by an ingress gateway listener.
+type IngressService struct {
+	Name      string
+	Hosts     []string                 `json:",omitempty"`
+	Namespace string                   `json:",omitempty"`
+	TLS       *GatewayServiceTLSConfig `json:",omitempty"`
+}
+
+func (e *IngressGatewayConfigEntry) GetKind() string        { return e.Kind }
+func (e *IngressGatewayConfigEntry) GetName() string        { return e.Name }
+func (e *IngressGatewayConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *IngressGatewayConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+// TerminatingGatewayKind is the kind of the terminating-gateway config
+// entry.
+const TerminatingGatewayKind = "terminating-gateway"
+
+// TerminatingGatewayConfigEntry is the terminating-gateway config entry
+// linking services to a terminating gateway.
+type TerminatingGatewayConfigEntry struct {
+	Kind      string
+	Name      string
+	Namespace string `json:",omitempty"`
+
+	Services []LinkedService
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// LinkedService is a service linked to a terminating gateway.
+type LinkedService struct {
+	Name      string
+	Namespace string `json:",omitempty"`
+	CAFile    string `json:",omitempty"`
+	CertFile  string `json:",omitempty"`
+	KeyFile   string `json:",omitempty"`
+	SNI       string `json:",omitempty"`
+}
+
+func (e *TerminatingGatewayConfigEntry) GetKind() string        { return e.Kind }
+func (e *TerminatingGatewayConfigEntry) GetName() string        { return e.Name }
+func (e *TerminatingGatewayConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *TerminatingGatewayConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+const (
+	// MeshKind is the kind of the mesh config entry.
+	MeshKind = "mesh"
+
+	// MeshName is the name of the mesh config entry, of which there is
+	// only one.
+	MeshName = "mesh"
+)
+
+// MeshConfigEntry is the mesh config entry configuring the whole service
+// mesh. Its name is always "mesh".
+type MeshConfigEntry struct {
+	Kind      string
+	Namespace string `json:",omitempty"`
+
+	TransparentProxy TransparentProxyMeshConfig
+	TLS              *MeshTLSConfig     `json:",omitempty"`
+	HTTP             *MeshHTTPConfig    `json:",omitempty"`
+	Peering          *PeeringMeshConfig `json:",omitempty"`
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// TransparentProxyMeshConfig is the transparent proxy configuration of
+// the mesh.
+type TransparentProxyMeshConfig struct {
+	MeshDestinationsOnly bool
+}
+
+// MeshTLSConfig is the TLS configuration of the mesh's proxies.
+type MeshTLSConfig struct {
+	Incoming *MeshDirectionalTLSConfig `json:",omitempty"`
+	Outgoing *MeshDirectionalTLSConfig `json:",omitempty"`
+}
+
+// MeshDirectionalTLSConfig is the TLS configuration of the incoming or
+// outgoing connections of the mesh's proxies.
+type MeshDirectionalTLSConfig struct {
+	TLSMinVersion string   `json:",omitempty"`
+	TLSMaxVersion string   `json:",omitempty"`
+	CipherSuites  []string `json:",omitempty"`
+}
+
+// MeshHTTPConfig is the HTTP configuration of the mesh's proxies.
+type MeshHTTPConfig struct {
+	SanitizeXForwardedClientCert bool
+}
+
+// PeeringMeshConfig is the cluster peering configuration of the mesh.
+type PeeringMeshConfig struct {
+	PeerThroughMeshGateways bool
+}
+
+func (e *MeshConfigEntry) GetKind() string        { return e.Kind }
+func (e *MeshConfigEntry) GetName() string        { return MeshName }
+func (e *MeshConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *MeshConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+// ExportedServicesKind is the kind of the exported-services config entry.
+const ExportedServicesKind = "exported-services"
+
+// ExportedServicesConfigEntry is the exported-services config entry
+// declaring which services of a partition are exported to other
+// partitions and peers. Its name is the name of the partition.
+type ExportedServicesConfigEntry struct {
+	Kind string
+	Name string
+
+	Services []ExportedService
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// ExportedService is a service exported by an exported-services config
+// entry, and the partitions and peers consuming it.
+type ExportedService struct {
+	Name      string
+	Namespace string `json:",omitempty"`
+	Consumers []ServiceConsumer
+}
+
+// ServiceConsumer is a partition or a peer that an exported service is
+// exported to.
+type ServiceConsumer struct {
+	Partition string `json:",omitempty"`
+	Peer      string `json:",omitempty"`
+}
+
+func (e *ExportedServicesConfigEntry) GetKind() string        { return e.Kind }
+func (e *ExportedServicesConfigEntry) GetName() string        { return e.Name }
+func (e *ExportedServicesConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *ExportedServicesConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+// SamenessGroupKind is the kind of the sameness-group config entry.
+const SamenessGroupKind = "sameness-group"
+
+// SamenessGroupConfigEntry is the sameness-group config entry declaring
+// the partitions and peers whose services of the same name are treated as
+// the same service, e.g. for failover.
+type SamenessGroupConfigEntry struct {
+	Kind string
+	Name string
+
+	DefaultForFailover bool `json:",omitempty"`
+	IncludeLocal       bool `json:",omitempty"`
+	Members            []SamenessGroupMember
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// SamenessGroupMember is a partition or a peer of a sameness group.
+type SamenessGroupMember struct {
+	Partition string `json:",omitempty"`
+	Peer      string `json:",omitempty"`
+}
+
+func (e *SamenessGroupConfigEntry) GetKind() string        { return e.Kind }
+func (e *SamenessGroupConfigEntry) GetName() string        { return e.Name }
+func (e *SamenessGroupConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *SamenessGroupConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+// JWTProviderKind is the kind of the jwt-provider config entry.
+const JWTProviderKind = "jwt-provider"
+
+// JWTProviderConfigEntry is the jwt-provider config entry declaring an
+// issuer of end-user JWTs and how the proxies validate its tokens.
+type JWTProviderConfigEntry struct {
+	Kind string
+	Name string
+
+	Issuer           string               `json:",omitempty"`
+	JSONWebKeySet    *JSONWebKeySet       `json:",omitempty"`
+	Audiences        []string             `json:",omitempty"`
+	Locations        []*JWTLocation       `json:",omitempty"`
+	Forwarding       *JWTForwardingConfig `json:",omitempty"`
+	ClockSkewSeconds int                  `json:",omitempty"`
+	CacheConfig      *JWTCacheConfig      `json:",omitempty"`
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// JSONWebKeySet is the source of the keys verifying the signatures of the
+// tokens.
+type JSONWebKeySet struct {
+	Local  *LocalJWKS  `json:",omitempty"`
+	Remote *RemoteJWKS `json:",omitempty"`
+}
+
+// LocalJWKS is a key set stored in the config entry or in a file of the
+// proxies.
+type LocalJWKS struct {
+	JWKS     string `json:",omitempty"`
+	Filename string `json:",omitempty"`
+}
+
+// RemoteJWKS is a key set fetched by the proxies. CacheDuration is encoded
+// as a duration string, e.g. "5m0s".
+type RemoteJWKS struct {
+	URI                 string
+	RequestTimeoutMs    int    `json:",omitempty"`
+	CacheDuration       string `json:",omitempty"`
+	FetchAsynchronously bool   `json:",omitempty"`
+}
+
+// JWTLocation is where the proxies look for the tokens. Only one of its
+// fields is set.
+type JWTLocation struct {
+	Header     *JWTLocationHeader     `json:",omitempty"`
+	QueryParam *JWTLocationQueryParam `json:",omitempty"`
+	Cookie     *JWTLocationCookie     `json:",omitempty"`
+}
+
+// JWTLocationHeader is a header holding the tokens.
+type JWTLocationHeader struct {
+	Name        string
+	ValuePrefix string `json:",omitempty"`
+	Forward     bool   `json:",omitempty"`
+}
+
+// JWTLocationQueryParam is a query parameter holding the tokens.
+type JWTLocationQueryParam struct {
+	Name string
+}
+
+// JWTLocationCookie is a cookie holding the tokens.
+type JWTLocationCookie struct {
+	Name string
+}
+
+// JWTForwardingConfig forwards the payload of validated tokens to the
+// services in a header.
+type JWTForwardingConfig struct {
+	HeaderName              string
+	PadForwardPayloadHeader bool `json:",omitempty"`
+}
+
+// JWTCacheConfig is the cache of the validated tokens.
+type JWTCacheConfig struct {
+	Size int `json:",omitempty"`
+}
+
+func (e *JWTProviderConfigEntry) GetKind() string        { return e.Kind }
+func (e *JWTProviderConfigEntry) GetName() string        { return e.Name }
+func (e *JWTProviderConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *JWTProviderConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+// Kinds of the config entries of Consul's API gateways, which require
+// Consul 1.15 or later. They're written by the gateway controllers for the
+// Gateway API resources.
+const (
+	APIGatewayKind        = "api-gateway"
+	HTTPRouteKind         = "http-route"
+	TCPRouteKind          = "tcp-route"
+	InlineCertificateKind = "inline-certificate"
+)
+
+// APIGatewayConfigEntry is the api-gateway config entry declaring the
+// listeners of an API gateway.
+type APIGatewayConfigEntry struct {
+	Kind string
+	Name string
+	Meta map[string]string `json:",omitempty"`
+
+	Listeners []APIGatewayListener
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// APIGatewayListener is a listener of an API gateway. Its protocol is
+// "http" or "tcp".
+type APIGatewayListener struct {
+	Name     string
+	Hostname string `json:",omitempty"`
+	Port     int
+	Protocol string
+	TLS      APIGatewayTLSConfiguration
+}
+
+// APIGatewayTLSConfiguration references the certificates of a listener.
+type APIGatewayTLSConfiguration struct {
+	Certificates []ResourceReference `json:",omitempty"`
+}
+
+// ResourceReference references a config entry, e.g. the gateway of a
+// route or the certificate of a listener.
+type ResourceReference struct {
+	Kind        string
+	Name        string
+	SectionName string `json:",omitempty"`
+}
+
+// HTTPRouteConfigEntry is the http-route config entry routing the HTTP
+// requests of API gateway listeners to services.
+type HTTPRouteConfigEntry struct {
+	Kind string
+	Name string
+	Meta map[string]string `json:",omitempty"`
+
+	Parents   []ResourceReference
+	Rules     []HTTPRouteRule
+	Hostnames []string `json:",omitempty"`
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// HTTPRouteRule is a rule of an http-route config entry.
+type HTTPRouteRule struct {
+	Filters  HTTPFilters
+	Matches  []HTTPMatch
+	Services []HTTPService
+}
+
+// HTTPMatch matches HTTP requests. The match types are "exact", "prefix"
+// and "regex".
+type HTTPMatch struct {
+	Headers []HTTPHeaderMatch `json:",omitempty"`
+	Method  string            `json:",omitempty"`
+	Path    HTTPPathMatch
+	Query   []HTTPQueryMatch `json:",omitempty"`
+}
+
+// HTTPHeaderMatch matches a header of HTTP requests.
+type HTTPHeaderMatch struct {
+	Match string
+	Name  string
+	Value string
+}
+
+// HTTPPathMatch matches the path of HTTP requests.
+type HTTPPathMatch struct {
+	Match string
+	Value string
+}
+
+// HTTPQueryMatch matches a query parameter of HTTP requests.
+type HTTPQueryMatch struct {
+	Match string
+	Name  string
+	Value string
+}
+
+// HTTPFilters modify the requests matched by a rule.
+type HTTPFilters struct {
+	Headers       []HTTPHeaderFilter `json:",omitempty"`
+	URLRewrite    *URLRewrite        `json:",omitempty"`
+	TimeoutFilter *HTTPTimeoutFilter `json:",omitempty"`
+	RetryFilter   *RetryFilter       `json:",omitempty"`
+	JWT           *JWTFilter         `json:",omitempty"`
+}
+
+// HTTPHeaderFilter modifies the headers of requests.
+type HTTPHeaderFilter struct {
+	Add    map[string]string `json:",omitempty"`
+	Remove []string          `json:",omitempty"`
+	Set    map[string]string `json:",omitempty"`
+}
+
+// URLRewrite replaces the matched path prefix of requests.
+type URLRewrite struct {
+	Path string
+}
+
+// HTTPTimeoutFilter sets the timeouts of requests (Consul 1.16+). The
+// timeouts are formatted as Go durations.
+type HTTPTimeoutFilter struct {
+	RequestTimeout string `json:",omitempty"`
+	IdleTimeout    string `json:",omitempty"`
+}
+
+// RetryFilter retries failed requests (Consul 1.16+).
+type RetryFilter struct {
+	NumRetries            uint32   `json:",omitempty"`
+	RetryOn               []string `json:",omitempty"`
+	RetryOnStatusCodes    []uint32 `json:",omitempty"`
+	RetryOnConnectFailure bool     `json:",omitempty"`
+}
+
+// JWTFilter requires requests to have a JWT valid for one of the providers
+// (Consul Enterprise 1.17+).
+type JWTFilter struct {
+	Providers []JWTFilterProvider
+}
+
+// JWTFilterProvider is a jwt-provider config entry of a JWTFilter and the
+// claims its tokens must have.
+type JWTFilterProvider struct {
+	Name         string
+	VerifyClaims []JWTClaimVerification `json:",omitempty"`
+}
+
+// JWTClaimVerification requires the claim at the path of a token's payload
+// to have the value.
+type JWTClaimVerification struct {
+	Path  []string
+	Value string
+}
+
+// HTTPService is a service that an http-route rule routes requests to.
+type HTTPService struct {
+	Name   string
+	Weight int `json:",omitempty"`
+}
+
+// TCPRouteConfigEntry is the tcp-route config entry routing the connections
+// of API gateway listeners to services.
+type TCPRouteConfigEntry struct {
+	Kind string
+	Name string
+	Meta map[string]string `json:",omitempty"`
+
+	Parents  []ResourceReference
+	Services []TCPService
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// TCPService is a service that a tcp-route routes connections to.
+type TCPService struct {
+	Name string
+}
+
+// InlineCertificateConfigEntry is the inline-certificate config entry
+// holding the certificate and private key of API gateway listeners.
+type InlineCertificateConfigEntry struct {
+	Kind string
+	Name string
+	Meta map[string]string `json:",omitempty"`
+
+	Certificate string
+	PrivateKey  string
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// ServiceDefaultsConfigEntry is the service-defaults config entry of the
+// Consul service of an API gateway, configuring the local rate limits of
+// its Envoy proxies (Consul 1.16+). Its kind is api.ServiceDefaults.
+type ServiceDefaultsConfigEntry struct {
+	Kind string
+	Name string
+	Meta map[string]string `json:",omitempty"`
+
+	RateLimits *RateLimits `json:",omitempty"`
+
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// RateLimits are the rate limits of the proxies of a service.
+type RateLimits struct {
+	InstanceLevel InstanceLevelRateLimits
+}
+
+// InstanceLevelRateLimits limit the requests each proxy of a service
+// accepts. The requests matching the paths of Routes are limited by the
+// route instead.
+type InstanceLevelRateLimits struct {
+	RequestsPerSecond int                            `json:",omitempty"`
+	RequestsMaxBurst  int                            `json:",omitempty"`
+	Routes            []InstanceLevelRouteRateLimits `json:",omitempty"`
+}
+
+// InstanceLevelRouteRateLimits limit the requests matching a path. Only
+// one of the path fields is set.
+type InstanceLevelRouteRateLimits struct {
+	PathExact         string `json:",omitempty"`
+	PathPrefix        string `json:",omitempty"`
+	PathRegex         string `json:",omitempty"`
+	RequestsPerSecond int
+	RequestsMaxBurst  int `json:",omitempty"`
+}
+
+func (e *APIGatewayConfigEntry) GetKind() string        { return e.Kind }
+func (e *APIGatewayConfigEntry) GetName() string        { return e.Name }
+func (e *APIGatewayConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *APIGatewayConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+func (e *HTTPRouteConfigEntry) GetKind() string        { return e.Kind }
+func (e *HTTPRouteConfigEntry) GetName() string        { return e.Name }
+func (e *HTTPRouteConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *HTTPRouteConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+func (e *TCPRouteConfigEntry) GetKind() string        { return e.Kind }
+func (e *TCPRouteConfigEntry) GetName() string        { return e.Name }
+func (e *TCPRouteConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *TCPRouteConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+func (e *InlineCertificateConfigEntry) GetKind() string        { return e.Kind }
+func (e *InlineCertificateConfigEntry) GetName() string        { return e.Name }
+func (e *InlineCertificateConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *InlineCertificateConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
+
+func (e *ServiceDefaultsConfigEntry) GetKind() string        { return e.Kind }
+func (e *ServiceDefaultsConfigEntry) GetName() string        { return e.Name }
+func (e *ServiceDefaultsConfigEntry) GetCreateIndex() uint64 { return e.CreateIndex }
+func (e *ServiceDefaultsConfigEntry) GetModifyIndex() uint64 { return e.ModifyIndex }
@@ -0,0 +1,159 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ExportedServicesResource is the resource name of ExportedServices.
+const ExportedServicesResource = "exportedservices"
+
+// ExportedServices is the Schema for the exportedservices API. It's
+// cluster-scoped and reconciled into the exported-services config entry
+// of the partition of the resource's name, e.g. "default", which requires
+// Consul 1.11 or later.
+type ExportedServices struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ExportedServicesSpec `json:"spec,omitempty"`
+	Status Status               `json:"status,omitempty"`
+}
+
+// ExportedServicesSpec defines the desired state of ExportedServices.
+type ExportedServicesSpec struct {
+	// Services is the list of services to export.
+	Services []ExportedServiceSpec `json:"services,omitempty"`
+}
+
+// ExportedServiceSpec is a service to export and the partitions and peers
+// to export it to.
+type ExportedServiceSpec struct {
+	// Name is the name of the service to export. It can be set to "*" to
+	// export all the services of the namespace.
+	Name string `json:"name"`
+	// Namespace is the Consul namespace of the service.
+	Namespace string `json:"namespace,omitempty"`
+	// Consumers is the list of partitions and peers to export the service
+	// to.
+	Consumers []ServiceConsumerSpec `json:"consumers,omitempty"`
+}
+
+// ServiceConsumerSpec is a partition or a peer to export a service to.
+// Exactly one of its fields must be set.
+type ServiceConsumerSpec struct {
+	// Partition is the name of the admin partition to export the service
+	// to.
+	Partition string `json:"partition,omitempty"`
+	// Peer is the name of the peer to export the service to.
+	Peer string `json:"peer,omitempty"`
+}
+
+func (in *ExportedServices) ConsulKind() string {
+	return ExportedServicesKind
+}
+
+func (in *ExportedServices) ConsulName() string {
+	return in.Name
+}
+
+func (in *ExportedServices) ResourceStatus() *Status {
+	return &in.Status
+}
+
+// ConsulTarget is the partition of the resource's name, since
+// exported-services config entries are named after the partition they
+// export services from.
+func (in *ExportedServices) ConsulTarget() ConsulTarget {
+	return ConsulTarget{Partition: in.Name}
+}
+
+func (in *ExportedServices) NewConsulEntry() api.ConfigEntry {
+	return &ExportedServicesConfigEntry{}
+}
+
+// ToConsul ignores the namespace since exported-services config entries
+// are scoped to a partition.
+func (in *ExportedServices) ToConsul(string) api.ConfigEntry {
+	entry := &ExportedServicesConfigEntry{
+		Kind: in.ConsulKind(),
+		Name: in.ConsulName(),
+	}
+	for _, service := range in.Spec.Services {
+		exported := ExportedService{
+			Name:      service.Name,
+			Namespace: service.Namespace,
+		}
+		for _, consumer := range service.Consumers {
+			exported.Consumers = append(exported.Consumers, ServiceConsumer{
+				Partition: consumer.Partition,
+				Peer:      consumer.Peer,
+			})
+		}
+		entry.Services = append(entry.Services, exported)
+	}
+	return entry
+}
+
+func (in *ExportedServices) MatchesConsul(entry api.ConfigEntry) bool {
+	exportedServices, ok := entry.(*ExportedServicesConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *exportedServices
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+	if len(actual.Services) == 0 {
+		actual.Services = nil
+	}
+
+	// Consul Enterprise defaults the services' namespace to the default
+	// namespace.
+	expected := in.ToConsul("").(*ExportedServicesConfigEntry)
+	actual.Services = append([]ExportedService(nil), actual.Services...)
+	for i := range actual.Services {
+		if actual.Services[i].Namespace == "default" {
+			actual.Services[i].Namespace = ""
+		}
+		if len(actual.Services[i].Consumers) == 0 {
+			actual.Services[i].Consumers = nil
+		}
+	}
+	for i := range expected.Services {
+		if expected.Services[i].Namespace == "default" {
+			expected.Services[i].Namespace = ""
+		}
+	}
+	return reflect.DeepEqual(expected, &actual)
+}
+
+func (in *ExportedServices) Validate() error {
+	type serviceKey struct {
+		name, namespace string
+	}
+	found := make(map[serviceKey]bool)
+	for i, service := range in.Spec.Services {
+		path := fmt.Sprintf("spec.services[%d]", i)
+		if service.Name == "" {
+			return fmt.Errorf("%s.name must be set", path)
+		}
+		key := serviceKey{service.Name, service.Namespace}
+		if found[key] {
+			return fmt.Errorf("%s: service %q is listed more than once", path, service.Name)
+		}
+		found[key] = true
+
+		if len(service.Consumers) == 0 {
+			return fmt.Errorf("%s.consumers must have at least one consumer", path)
+		}
+		for j, consumer := range service.Consumers {
+			if countSet(consumer.Partition != "", consumer.Peer != "") != 1 {
+				return fmt.Errorf("%s.consumers[%d] must set exactly one of partition or peer", path, j)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExportedServices_ToConsul(t *testing.T) {
+	resource := &ExportedServices{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: ExportedServicesSpec{Services: []ExportedServiceSpec{
+			{Name: "api", Consumers: []ServiceConsumerSpec{{Partition: "web"}, {Peer: "dc2"}}},
+			{Name: "*", Namespace: "billing", Consumers: []ServiceConsumerSpec{{Peer: "dc3"}}},
+		}},
+	}
+	require.Equal(t, &ExportedServicesConfigEntry{
+		Kind: ExportedServicesKind,
+		Name: "default",
+		Services: []ExportedService{
+			{Name: "api", Consumers: []ServiceConsumer{{Partition: "web"}, {Peer: "dc2"}}},
+			{Name: "*", Namespace: "billing", Consumers: []ServiceConsumer{{Peer: "dc3"}}},
+		},
+	}, resource.ToConsul("ignored"))
+}
+
+func TestExportedServices_MatchesConsul(t *testing.T) {
+	resource := &ExportedServices{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: ExportedServicesSpec{Services: []ExportedServiceSpec{
+			{Name: "api", Consumers: []ServiceConsumerSpec{{Peer: "dc2"}}},
+		}},
+	}
+	// Consul Enterprise returns the services' default namespace.
+	require.True(t, resource.MatchesConsul(&ExportedServicesConfigEntry{
+		Kind:        ExportedServicesKind,
+		Name:        "default",
+		Services:    []ExportedService{{Name: "api", Namespace: "default", Consumers: []ServiceConsumer{{Peer: "dc2"}}}},
+		ModifyIndex: 10,
+	}))
+	require.False(t, resource.MatchesConsul(&ExportedServicesConfigEntry{
+		Kind:     ExportedServicesKind,
+		Name:     "default",
+		Services: []ExportedService{{Name: "api", Consumers: []ServiceConsumer{{Partition: "dc2"}}}},
+	}))
+	require.False(t, resource.MatchesConsul(&ExportedServicesConfigEntry{Kind: ExportedServicesKind, Name: "default"}))
+	require.False(t, resource.MatchesConsul(&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "default"}))
+}
+
+func TestExportedServices_Validate(t *testing.T) {
+	cases := map[string]struct {
+		name     string
+		services []ExportedServiceSpec
+		expErr   string
+	}{
+		"valid": {
+			name: "default",
+			services: []ExportedServiceSpec{
+				{Name: "api", Consumers: []ServiceConsumerSpec{{Partition: "web"}, {Peer: "dc2"}}},
+				{Name: "api", Namespace: "billing", Consumers: []ServiceConsumerSpec{{Peer: "dc2"}}},
+			},
+		},
+		"other partition": {
+			name:     "web",
+			services: []ExportedServiceSpec{{Name: "api", Consumers: []ServiceConsumerSpec{{Partition: "default"}}}},
+		},
+		"missing service name": {
+			name:     "default",
+			services: []ExportedServiceSpec{{Consumers: []ServiceConsumerSpec{{Peer: "dc2"}}}},
+			expErr:   "spec.services[0].name must be set",
+		},
+		"duplicate services": {
+			name: "default",
+			services: []ExportedServiceSpec{
+				{Name: "api", Consumers: []ServiceConsumerSpec{{Peer: "dc2"}}},
+				{Name: "api", Consumers: []ServiceConsumerSpec{{Peer: "dc3"}}},
+			},
+			expErr: `spec.services[1]: service "api" is listed more than once`,
+		},
+		"no consumers": {
+			name:     "default",
+			services: []ExportedServiceSpec{{Name: "api"}},
+			expErr:   "spec.services[0].consumers must have at least one consumer",
+		},
+		"partition and peer": {
+			name:     "default",
+			services: []ExportedServiceSpec{{Name: "api", Consumers: []ServiceConsumerSpec{{Partition: "web", Peer: "dc2"}}}},
+			expErr:   "spec.services[0].consumers[0] must set exactly one of partition or peer",
+		},
+		"empty consumer": {
+			name:     "default",
+			services: []ExportedServiceSpec{{Name: "api", Consumers: []ServiceConsumerSpec{{}}}},
+			expErr:   "spec.services[0].consumers[0] must set exactly one of partition or peer",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &ExportedServices{
+				ObjectMeta: metav1.ObjectMeta{Name: c.name},
+				Spec:       ExportedServicesSpec{Services: c.services},
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
+
+func TestExportedServices_ConsulTarget(t *testing.T) {
+	resource := &ExportedServices{ObjectMeta: metav1.ObjectMeta{Name: "web"}}
+	require.Equal(t, ConsulTarget{Partition: "web"}, resource.ConsulTarget())
+}
@@ -0,0 +1,128 @@
+package v1alpha1
+
+import (
+	"fmt"
+
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// GatewayClassConfigResource is the resource name of
+	// GatewayClassConfig.
+	GatewayClassConfigResource = "gatewayclassconfigs"
+
+	// GatewayClassConfigKind is the kind of GatewayClassConfig, used in the
+	// parametersRef of the GatewayClasses referencing them.
+	GatewayClassConfigKind = "GatewayClassConfig"
+)
+
+// GatewayClassConfig is the Schema for the gatewayclassconfigs API. It's
+// cluster-scoped and configures the deployments and services of the
+// gateways of the Gateway API classes that reference it in their
+// parametersRef, so that the gateways of a class are deployed the same way.
+type GatewayClassConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec GatewayClassConfigSpec `json:"spec,omitempty"`
+}
+
+// GatewayClassConfigSpec defines the desired state of GatewayClassConfig.
+type GatewayClassConfigSpec struct {
+	// Deployment configures the deployments of the gateways.
+	Deployment GatewayDeploymentSpec `json:"deployment,omitempty"`
+	// ServiceType is the type of the services of the gateways:
+	// LoadBalancer, NodePort or ClusterIP. It defaults to LoadBalancer.
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+	// NodeSelector restricts the nodes the gateway pods are scheduled on.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations are the tolerations of the gateway pods.
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Affinity is the affinity of the gateway pods, e.g. a pod
+	// anti-affinity spreading the pods of each gateway across nodes or
+	// zones.
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+	// TopologySpreadConstraints spread the gateway pods evenly across
+	// topology domains, e.g. zones, which unlike a pod anti-affinity
+	// allows more pods than domains.
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	// PriorityClassName is the priority class of the gateway pods, so
+	// that the gateways, which are on the critical path of the requests,
+	// aren't preempted by less important pods.
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// CopyAnnotations are the annotations of the gateways copied to their
+	// resources, e.g. the annotations configuring the load balancers of a
+	// cloud provider.
+	CopyAnnotations CopyAnnotationsSpec `json:"copyAnnotations,omitempty"`
+}
+
+// GatewayDeploymentSpec configures the deployments of the gateways.
+type GatewayDeploymentSpec struct {
+	// Replicas is the number of pods of each gateway. It defaults to 1.
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Resources are the compute resources of the Envoy containers.
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Annotations are the annotations of the gateway pods, e.g. the
+	// annotations having Prometheus scrape the Envoy metrics that a
+	// custom metrics adapter serves to the autoscalers.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Autoscaling scales each gateway with a HorizontalPodAutoscaler. The
+	// replicas of the deployments are then left to the autoscalers.
+	Autoscaling *GatewayAutoscalingSpec `json:"autoscaling,omitempty"`
+}
+
+// GatewayAutoscalingSpec configures the HorizontalPodAutoscalers of the
+// gateways.
+type GatewayAutoscalingSpec struct {
+	// MinReplicas is the minimum number of pods of each gateway. It
+	// defaults to 1.
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// MaxReplicas is the maximum number of pods of each gateway.
+	MaxReplicas int32 `json:"maxReplicas"`
+	// Metrics are the metrics the gateways are scaled on, e.g. the pods
+	// metrics of a custom metrics adapter. They default to an average CPU
+	// utilization of 80% of the requests of spec.deployment.resources.
+	Metrics []autoscalingv2beta1.MetricSpec `json:"metrics,omitempty"`
+}
+
+// DefaultGatewayCPUUtilization is the target average CPU utilization of
+// the gateways' autoscalers if no metrics are configured.
+const DefaultGatewayCPUUtilization int32 = 80
+
+// CopyAnnotationsSpec lists the annotations of the gateways to copy.
+type CopyAnnotationsSpec struct {
+	// Service are the annotations copied to the services of the gateways.
+	Service []string `json:"service,omitempty"`
+}
+
+func (in *GatewayClassConfig) Validate() error {
+	if replicas := in.Spec.Deployment.Replicas; replicas != nil && *replicas < 0 {
+		return fmt.Errorf("spec.deployment.replicas must be positive, got %d", *replicas)
+	}
+	if autoscaling := in.Spec.Deployment.Autoscaling; autoscaling != nil {
+		min := int32(1)
+		if autoscaling.MinReplicas != nil {
+			min = *autoscaling.MinReplicas
+		}
+		if min < 1 {
+			return fmt.Errorf("spec.deployment.autoscaling.minReplicas must be at least 1, got %d", min)
+		}
+		if autoscaling.MaxReplicas < min {
+			return fmt.Errorf("spec.deployment.autoscaling.maxReplicas must be at least minReplicas (%d), got %d",
+				min, autoscaling.MaxReplicas)
+		}
+		for i, metric := range autoscaling.Metrics {
+			if metric.Type == "" {
+				return fmt.Errorf("spec.deployment.autoscaling.metrics[%d].type must be set", i)
+			}
+		}
+	}
+	switch in.Spec.ServiceType {
+	case "", corev1.ServiceTypeLoadBalancer, corev1.ServiceTypeNodePort, corev1.ServiceTypeClusterIP:
+	default:
+		return fmt.Errorf("spec.serviceType must be one of LoadBalancer, NodePort or ClusterIP, got %q", in.Spec.ServiceType)
+	}
+	return nil
+}
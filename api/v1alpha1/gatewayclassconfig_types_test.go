@@ -0,0 +1,80 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGatewayClassConfig_Validate(t *testing.T) {
+	replicas := func(r int32) *int32 { return &r }
+	cases := map[string]struct {
+		spec   GatewayClassConfigSpec
+		expErr string
+	}{
+		"empty": {},
+		"valid": {
+			spec: GatewayClassConfigSpec{
+				Deployment:   GatewayDeploymentSpec{Replicas: replicas(3)},
+				ServiceType:  corev1.ServiceTypeNodePort,
+				NodeSelector: map[string]string{"pool": "gateways"},
+			},
+		},
+		"negative replicas": {
+			spec:   GatewayClassConfigSpec{Deployment: GatewayDeploymentSpec{Replicas: replicas(-1)}},
+			expErr: "spec.deployment.replicas must be positive, got -1",
+		},
+		"autoscaling": {
+			spec: GatewayClassConfigSpec{Deployment: GatewayDeploymentSpec{
+				Autoscaling: &GatewayAutoscalingSpec{MinReplicas: replicas(2), MaxReplicas: 5, Metrics: []autoscalingv2beta1.MetricSpec{{
+					Type: autoscalingv2beta1.PodsMetricSourceType,
+					Pods: &autoscalingv2beta1.PodsMetricSource{MetricName: "envoy_http_downstream_rq_active", TargetAverageValue: resource.MustParse("100")},
+				}}},
+			}},
+		},
+		"autoscaling without min replicas": {
+			spec: GatewayClassConfigSpec{Deployment: GatewayDeploymentSpec{Autoscaling: &GatewayAutoscalingSpec{MaxReplicas: 1}}},
+		},
+		"autoscaling with zero min replicas": {
+			spec: GatewayClassConfigSpec{Deployment: GatewayDeploymentSpec{
+				Autoscaling: &GatewayAutoscalingSpec{MinReplicas: replicas(0), MaxReplicas: 3},
+			}},
+			expErr: "spec.deployment.autoscaling.minReplicas must be at least 1, got 0",
+		},
+		"autoscaling with max replicas below min": {
+			spec: GatewayClassConfigSpec{Deployment: GatewayDeploymentSpec{
+				Autoscaling: &GatewayAutoscalingSpec{MinReplicas: replicas(3), MaxReplicas: 2},
+			}},
+			expErr: "spec.deployment.autoscaling.maxReplicas must be at least minReplicas (3), got 2",
+		},
+		"autoscaling metric without type": {
+			spec: GatewayClassConfigSpec{Deployment: GatewayDeploymentSpec{
+				Autoscaling: &GatewayAutoscalingSpec{MaxReplicas: 2, Metrics: []autoscalingv2beta1.MetricSpec{{}}},
+			}},
+			expErr: "spec.deployment.autoscaling.metrics[0].type must be set",
+		},
+		"external name service": {
+			spec:   GatewayClassConfigSpec{ServiceType: corev1.ServiceTypeExternalName},
+			expErr: `spec.serviceType must be one of LoadBalancer, NodePort or ClusterIP, got "ExternalName"`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &GatewayClassConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "default"},
+				Spec:       c.spec,
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
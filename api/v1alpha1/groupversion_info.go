@@ -0,0 +1,19 @@
+// Package v1alpha1 contains the v1alpha1 types of the consul.hashicorp.com
+// API group. The custom resources of this group are reconciled into Consul
+// config entries by the controllers in the controller package.
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// Group is the API group of the custom resources.
+	Group = "consul.hashicorp.com"
+
+	// Version is the API version of the types in this package.
+	Version = "v1alpha1"
+)
+
+// GroupVersion is the group version of the types in this package.
+var GroupVersion = schema.GroupVersion{Group: Group, Version: Version}
@@ -0,0 +1,462 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/consul-k8s/helper/fips"
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IngressGatewayResource is the resource name of IngressGateway.
+const IngressGatewayResource = "ingressgateways"
+
+// tlsVersions are the TLS versions that gateways can be limited to, in
+// order. TLS_AUTO lets Envoy pick the version.
+var tlsVersions = []string{"TLS_AUTO", "TLSv1_0", "TLSv1_1", "TLSv1_2", "TLSv1_3"}
+
+// cipherSuites are the TLS 1.2 cipher suites supported by Envoy. Consul
+// passes the cipher suites of gateways to Envoy as is, and Envoy rejects
+// the listeners with unknown ones.
+var cipherSuites = map[string]bool{
+	"ECDHE-ECDSA-AES128-GCM-SHA256": true,
+	"ECDHE-ECDSA-CHACHA20-POLY1305": true,
+	"ECDHE-RSA-AES128-GCM-SHA256":   true,
+	"ECDHE-RSA-CHACHA20-POLY1305":   true,
+	"ECDHE-ECDSA-AES128-SHA":        true,
+	"ECDHE-RSA-AES128-SHA":          true,
+	"AES128-GCM-SHA256":             true,
+	"AES128-SHA":                    true,
+	"ECDHE-ECDSA-AES256-GCM-SHA384": true,
+	"ECDHE-RSA-AES256-GCM-SHA384":   true,
+	"ECDHE-ECDSA-AES256-SHA":        true,
+	"ECDHE-RSA-AES256-SHA":          true,
+	"AES256-GCM-SHA384":             true,
+	"AES256-SHA":                    true,
+}
+
+// validHostLabel matches the labels of hostnames.
+var validHostLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// ingressProtocols are the protocols of ingress listeners.
+var ingressProtocols = map[string]bool{"tcp": true, "http": true, "http2": true, "grpc": true}
+
+// IngressGateway is the Schema for the ingressgateways API. It is
+// reconciled into the ingress-gateway config entry of the gateway service
+// with the resource's name, which requires Consul 1.8 or later.
+type IngressGateway struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IngressGatewaySpec `json:"spec,omitempty"`
+	Status Status             `json:"status,omitempty"`
+}
+
+// IngressGatewaySpec defines the desired state of IngressGateway.
+type IngressGatewaySpec struct {
+	ConsulTarget `json:",inline"`
+
+	// TLS holds the TLS configuration for this gateway.
+	TLS GatewayTLSConfigSpec `json:"tls,omitempty"`
+	// Listeners declares what ports the ingress gateway should listen on,
+	// and what services to associate to those ports.
+	Listeners []IngressListenerSpec `json:"listeners,omitempty"`
+}
+
+// GatewayTLSConfigSpec is the TLS configuration of a gateway.
+type GatewayTLSConfigSpec struct {
+	// Enabled indicates that TLS should be enabled for this gateway.
+	Enabled bool `json:"enabled,omitempty"`
+	// TLSMinVersion sets the default minimum TLS version supported, one
+	// of TLS_AUTO, TLSv1_0, TLSv1_1, TLSv1_2 or TLSv1_3.
+	TLSMinVersion string `json:"tlsMinVersion,omitempty"`
+	// TLSMaxVersion sets the default maximum TLS version supported.
+	TLSMaxVersion string `json:"tlsMaxVersion,omitempty"`
+	// CipherSuites sets the default list of TLS cipher suites to support
+	// when negotiating connections using TLS 1.2 or earlier, in the
+	// OpenSSL format of Envoy, e.g. ECDHE-RSA-AES128-GCM-SHA256. They
+	// can't be set with a tlsMinVersion of TLSv1_3, whose cipher suites
+	// aren't configurable.
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+	// SDS fetches the certificate of the gateway from an SDS server, e.g.
+	// the sds-server command serving Kubernetes TLS secrets, instead of
+	// using the certificate of the Consul CA.
+	SDS *GatewayTLSSDSConfigSpec `json:"sds,omitempty"`
+}
+
+// GatewayTLSSDSConfigSpec configures a gateway to fetch a certificate from
+// an SDS server. Envoy watches the certificate, so it's rotated without
+// restarting the gateway.
+type GatewayTLSSDSConfigSpec struct {
+	// ClusterName is the name of the cluster of the SDS server in the
+	// Envoy bootstrap of the gateway.
+	ClusterName string `json:"clusterName,omitempty"`
+	// CertResource is the name of the certificate resource of the SDS
+	// server, i.e. the name of the secret for the sds-server command.
+	CertResource string `json:"certResource,omitempty"`
+}
+
+// GatewayServiceTLSConfigSpec is the TLS configuration of a service
+// exposed by a listener.
+type GatewayServiceTLSConfigSpec struct {
+	// SDS fetches the certificate served for the hosts of the service
+	// from an SDS server.
+	SDS *GatewayTLSSDSConfigSpec `json:"sds,omitempty"`
+}
+
+// IngressListenerSpec is a listener of an ingress gateway.
+type IngressListenerSpec struct {
+	// Port declares the port on which the ingress gateway should listen
+	// for traffic.
+	Port int `json:"port"`
+	// Protocol declares what type of traffic this listener is expected to
+	// receive, one of tcp, http, http2 or grpc. Defaults to tcp.
+	Protocol string `json:"protocol,omitempty"`
+	// Services declares the set of services to which the listener
+	// forwards traffic. For "tcp" listeners, only a single service is
+	// allowed. For L7 listeners the "*" wildcard can be used to forward
+	// to all services of the namespace.
+	//
+	// Each listener has its own port, so the hosts of its services only
+	// need to be unique within the listener.
+	Services []IngressServiceSpec `json:"services,omitempty"`
+	// TLS overrides the TLS configuration of the gateway for this
+	// listener. Requires Consul 1.11 or later.
+	TLS *GatewayTLSConfigSpec `json:"tls,omitempty"`
+}
+
+// IngressServiceSpec is a service exposed by a listener.
+type IngressServiceSpec struct {
+	// Name declares the service to which traffic should be forwarded.
+	Name string `json:"name"`
+	// Hosts is a list of hostnames which should be associated to this
+	// service on the defined listener. Only allowed on L7 protocols. If
+	// unset, the service is reachable at <name>.ingress.*. Hosts are
+	// matched case-insensitively, and a wildcard must be the whole
+	// leftmost label, e.g. *.example.com. The "*" host can't be used with
+	// TLS since it isn't a valid name of the gateway's certificate.
+	Hosts []string `json:"hosts,omitempty"`
+	// Namespace is the Consul namespace of the service.
+	Namespace string `json:"namespace,omitempty"`
+	// TLS overrides the TLS configuration of the gateway for the hosts of
+	// the service.
+	TLS *GatewayServiceTLSConfigSpec `json:"tls,omitempty"`
+}
+
+func (in *IngressGateway) ConsulKind() string {
+	return IngressGatewayKind
+}
+
+func (in *IngressGateway) ConsulName() string {
+	return in.Name
+}
+
+func (in *IngressGateway) ResourceStatus() *Status {
+	return &in.Status
+}
+
+func (in *IngressGateway) ConsulTarget() ConsulTarget {
+	return in.Spec.ConsulTarget
+}
+
+func (in *IngressGateway) NewConsulEntry() api.ConfigEntry {
+	return &IngressGatewayConfigEntry{}
+}
+
+// Default sets the protocol of the listeners to Consul's default.
+func (in *IngressGateway) Default() {
+	for i := range in.Spec.Listeners {
+		if in.Spec.Listeners[i].Protocol == "" {
+			in.Spec.Listeners[i].Protocol = "tcp"
+		}
+	}
+}
+
+func (in *IngressGateway) ToConsul(namespace string) api.ConfigEntry {
+	entry := &IngressGatewayConfigEntry{
+		Kind:      in.ConsulKind(),
+		Name:      in.ConsulName(),
+		Namespace: namespace,
+		TLS:       in.Spec.TLS.toConsul(),
+	}
+	for _, listener := range in.Spec.Listeners {
+		consulListener := IngressListener{
+			Port:     listener.Port,
+			Protocol: listener.Protocol,
+		}
+		if listener.TLS != nil {
+			tls := listener.TLS.toConsul()
+			consulListener.TLS = &tls
+		}
+		for _, service := range listener.Services {
+			consulService := IngressService{
+				Name:      service.Name,
+				Hosts:     service.Hosts,
+				Namespace: service.Namespace,
+			}
+			if service.TLS != nil && service.TLS.SDS != nil {
+				consulService.TLS = &GatewayServiceTLSConfig{SDS: service.TLS.SDS.toConsul()}
+			}
+			consulListener.Services = append(consulListener.Services, consulService)
+		}
+		entry.Listeners = append(entry.Listeners, consulListener)
+	}
+	return entry
+}
+
+func (in *IngressGateway) MatchesConsul(entry api.ConfigEntry) bool {
+	ingressGateway, ok := entry.(*IngressGatewayConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *ingressGateway
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+
+	// Consul returns empty lists rather than omitting them and, in
+	// Enterprise, defaults the services' namespace to the entry's
+	// namespace.
+	expected := in.ToConsul(actual.Namespace).(*IngressGatewayConfigEntry)
+	actual.Listeners = nil
+	for _, listener := range ingressGateway.Listeners {
+		var services []IngressService
+		for _, service := range listener.Services {
+			if len(service.Hosts) == 0 {
+				service.Hosts = nil
+			}
+			services = append(services, service)
+		}
+		listener.Services = services
+		if listener.TLS != nil && len(listener.TLS.CipherSuites) == 0 {
+			tls := *listener.TLS
+			tls.CipherSuites = nil
+			listener.TLS = &tls
+		}
+		actual.Listeners = append(actual.Listeners, listener)
+	}
+	for _, listener := range expected.Listeners {
+		for i := range listener.Services {
+			if listener.Services[i].Namespace == "" {
+				listener.Services[i].Namespace = actual.Namespace
+			}
+		}
+	}
+	if len(actual.TLS.CipherSuites) == 0 {
+		actual.TLS.CipherSuites = nil
+	}
+	return reflect.DeepEqual(expected, &actual)
+}
+
+func (in *IngressGateway) Validate() error {
+	if err := in.Spec.TLS.validate("spec.tls"); err != nil {
+		return err
+	}
+
+	ports := make(map[int]bool)
+	for i, listener := range in.Spec.Listeners {
+		path := fmt.Sprintf("spec.listeners[%d]", i)
+		if listener.Port < 1 || listener.Port > 65535 {
+			return fmt.Errorf("%s.port must be between 1 and 65535, got %d", path, listener.Port)
+		}
+		if ports[listener.Port] {
+			return fmt.Errorf("%s.port %d is used by another listener", path, listener.Port)
+		}
+		ports[listener.Port] = true
+
+		protocol := listener.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		if !ingressProtocols[protocol] {
+			return fmt.Errorf("%s.protocol must be one of \"tcp\", \"http\", \"http2\" or \"grpc\", got %q", path, protocol)
+		}
+		if len(listener.Services) == 0 {
+			return fmt.Errorf("%s.services must have at least one service", path)
+		}
+		if protocol == "tcp" && len(listener.Services) > 1 {
+			return fmt.Errorf("%s.services can only have one service with protocol \"tcp\"", path)
+		}
+		tlsEnabled := in.Spec.TLS.Enabled
+		if listener.TLS != nil {
+			if err := listener.TLS.validate(path + ".tls"); err != nil {
+				return err
+			}
+			tlsEnabled = listener.TLS.Enabled
+		}
+
+		type serviceKey struct {
+			name, namespace string
+		}
+		services := make(map[serviceKey]bool)
+		hosts := make(map[string]bool)
+		for j, service := range listener.Services {
+			servicePath := fmt.Sprintf("%s.services[%d]", path, j)
+			if service.Name == "" {
+				return fmt.Errorf("%s.name must be set", servicePath)
+			}
+			key := serviceKey{service.Name, service.Namespace}
+			if services[key] {
+				return fmt.Errorf("%s: service %q is listed more than once", servicePath, service.Name)
+			}
+			services[key] = true
+
+			if service.Name == "*" && protocol == "tcp" {
+				return fmt.Errorf("%s.name cannot be \"*\" with protocol \"tcp\"", servicePath)
+			}
+			if len(service.Hosts) > 0 && protocol == "tcp" {
+				return fmt.Errorf("%s.hosts cannot be set with protocol \"tcp\"", servicePath)
+			}
+			if len(service.Hosts) > 0 && service.Name == "*" {
+				return fmt.Errorf("%s.hosts cannot be set for the \"*\" service", servicePath)
+			}
+			if service.TLS != nil && service.TLS.SDS != nil {
+				if err := service.TLS.SDS.validate(servicePath + ".tls.sds"); err != nil {
+					return err
+				}
+				if len(service.Hosts) == 0 {
+					return fmt.Errorf("%s.tls.sds requires hosts to be set, the certificate is selected by SNI", servicePath)
+				}
+			}
+			for k, host := range service.Hosts {
+				if err := validateIngressHost(host); err != nil {
+					return fmt.Errorf("%s.hosts[%d] %s", servicePath, k, err)
+				}
+				if host == "*" && tlsEnabled {
+					return fmt.Errorf("%s.hosts[%d] cannot be \"*\" with TLS enabled, it isn't a valid DNS name of the certificate", servicePath, k)
+				}
+				// Envoy rejects the route configuration of the listener if
+				// two of its virtual hosts share a domain, which it
+				// compares case-insensitively.
+				lower := strings.ToLower(host)
+				if hosts[lower] {
+					return fmt.Errorf("%s.hosts[%d] %q is used by another service of the listener", servicePath, k, host)
+				}
+				hosts[lower] = true
+			}
+		}
+	}
+	return nil
+}
+
+func (in GatewayTLSConfigSpec) validate(path string) error {
+	if in.SDS != nil {
+		if err := in.SDS.validate(path + ".sds"); err != nil {
+			return err
+		}
+	}
+	if err := validateTLSVersions(path, in.TLSMinVersion, in.TLSMaxVersion); err != nil {
+		return err
+	}
+	return validateCipherSuites(path, in.TLSMinVersion, in.CipherSuites)
+}
+
+func (in GatewayTLSConfigSpec) toConsul() GatewayTLSConfig {
+	return GatewayTLSConfig{
+		Enabled:       in.Enabled,
+		TLSMinVersion: in.TLSMinVersion,
+		TLSMaxVersion: in.TLSMaxVersion,
+		CipherSuites:  in.CipherSuites,
+		SDS:           in.SDS.toConsul(),
+	}
+}
+
+func (in *GatewayTLSSDSConfigSpec) validate(path string) error {
+	if in.ClusterName == "" {
+		return fmt.Errorf("%s.clusterName must be set", path)
+	}
+	if in.CertResource == "" {
+		return fmt.Errorf("%s.certResource must be set", path)
+	}
+	return nil
+}
+
+func (in *GatewayTLSSDSConfigSpec) toConsul() *GatewayTLSSDSConfig {
+	if in == nil {
+		return nil
+	}
+	return &GatewayTLSSDSConfig{ClusterName: in.ClusterName, CertResource: in.CertResource}
+}
+
+// validateTLSVersions returns an error if the TLS versions at path aren't
+// valid or if the minimum version is greater than the maximum version.
+func validateTLSVersions(path, minVersion, maxVersion string) error {
+	minIndex, maxIndex := -1, -1
+	for i, version := range tlsVersions {
+		if version == minVersion {
+			minIndex = i
+		}
+		if version == maxVersion {
+			maxIndex = i
+		}
+	}
+	valid := strings.Join(tlsVersions, ", ")
+	if minVersion != "" && minIndex == -1 {
+		return fmt.Errorf("%s.tlsMinVersion must be one of %s, got %q", path, valid, minVersion)
+	}
+	if maxVersion != "" && maxIndex == -1 {
+		return fmt.Errorf("%s.tlsMaxVersion must be one of %s, got %q", path, valid, maxVersion)
+	}
+	// TLS_AUTO doesn't constrain the other bound.
+	if minIndex > 0 && maxIndex > 0 && minIndex > maxIndex {
+		return fmt.Errorf("%s.tlsMinVersion %s cannot be greater than tlsMaxVersion %s", path, minVersion, maxVersion)
+	}
+	if !fips.EnvoyTLSVersionApproved(minVersion) {
+		return fmt.Errorf("%s.tlsMinVersion %s is not FIPS-approved, it must be TLSv1_2 or later", path, minVersion)
+	}
+	if !fips.EnvoyTLSVersionApproved(maxVersion) {
+		return fmt.Errorf("%s.tlsMaxVersion %s is not FIPS-approved, it must be TLSv1_2 or later", path, maxVersion)
+	}
+	return nil
+}
+
+// validateCipherSuites returns an error if the cipher suites at path
+// aren't supported by Envoy, or if they're set while only TLS 1.3, whose
+// cipher suites aren't configurable, can be negotiated.
+func validateCipherSuites(path, minVersion string, suites []string) error {
+	if len(suites) > 0 && minVersion == "TLSv1_3" {
+		return fmt.Errorf("%s.cipherSuites cannot be set with tlsMinVersion TLSv1_3", path)
+	}
+	seen := make(map[string]bool)
+	for i, suite := range suites {
+		if !cipherSuites[suite] {
+			return fmt.Errorf("%s.cipherSuites[%d] %q is not a cipher suite supported by Envoy", path, i, suite)
+		}
+		if seen[suite] {
+			return fmt.Errorf("%s.cipherSuites[%d] %q is listed more than once", path, i, suite)
+		}
+		seen[suite] = true
+	}
+	return validateFIPSCipherSuites(path, suites)
+}
+
+// validateFIPSCipherSuites returns an error if the cipher suites at path
+// aren't FIPS-approved in the FIPS build.
+func validateFIPSCipherSuites(path string, suites []string) error {
+	for i, suite := range suites {
+		if !fips.EnvoyCipherSuiteApproved(suite) {
+			return fmt.Errorf("%s.cipherSuites[%d] %q is not FIPS-approved", path, i, suite)
+		}
+	}
+	return nil
+}
+
+// validateIngressHost returns an error if host isn't a hostname or a
+// wildcard whose "*" is the whole leftmost label.
+func validateIngressHost(host string) error {
+	if host == "*" {
+		return nil
+	}
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if label == "*" && i == 0 {
+			continue
+		}
+		if !validHostLabel.MatchString(strings.ToLower(label)) {
+			return fmt.Errorf("%q is not a valid hostname; wildcards must be the whole leftmost label, e.g. \"*.example.com\"", host)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIngressGateway_ToConsul(t *testing.T) {
+	resource := &IngressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-gateway"},
+		Spec: IngressGatewaySpec{
+			TLS: GatewayTLSConfigSpec{Enabled: true, TLSMinVersion: "TLSv1_2"},
+			Listeners: []IngressListenerSpec{
+				{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{
+					{Name: "web", Hosts: []string{"web.example.com"}},
+					{Name: "api", Namespace: "ns"},
+				}},
+				{Port: 9090, Protocol: "tcp", Services: []IngressServiceSpec{{Name: "db"}}},
+			},
+		},
+	}
+	require.Equal(t, &IngressGatewayConfigEntry{
+		Kind:      IngressGatewayKind,
+		Name:      "ingress-gateway",
+		Namespace: "consul-ns",
+		TLS:       GatewayTLSConfig{Enabled: true, TLSMinVersion: "TLSv1_2"},
+		Listeners: []IngressListener{
+			{Port: 8080, Protocol: "http", Services: []IngressService{
+				{Name: "web", Hosts: []string{"web.example.com"}},
+				{Name: "api", Namespace: "ns"},
+			}},
+			{Port: 9090, Protocol: "tcp", Services: []IngressService{{Name: "db"}}},
+		},
+	}, resource.ToConsul("consul-ns"))
+}
+
+func TestIngressGateway_ToConsulSDS(t *testing.T) {
+	resource := &IngressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-gateway"},
+		Spec: IngressGatewaySpec{
+			TLS: GatewayTLSConfigSpec{Enabled: true, SDS: &GatewayTLSSDSConfigSpec{ClusterName: "sds", CertResource: "default-cert"}},
+			Listeners: []IngressListenerSpec{
+				{Port: 8443, Protocol: "http", Services: []IngressServiceSpec{
+					{Name: "web", Hosts: []string{"web.example.com"}, TLS: &GatewayServiceTLSConfigSpec{
+						SDS: &GatewayTLSSDSConfigSpec{ClusterName: "sds", CertResource: "web-cert"},
+					}},
+					{Name: "api", Hosts: []string{"api.example.com"}},
+				}},
+			},
+		},
+	}
+	entry := resource.ToConsul("").(*IngressGatewayConfigEntry)
+	require.Equal(t, &GatewayTLSSDSConfig{ClusterName: "sds", CertResource: "default-cert"}, entry.TLS.SDS)
+	require.Equal(t, &GatewayServiceTLSConfig{SDS: &GatewayTLSSDSConfig{ClusterName: "sds", CertResource: "web-cert"}},
+		entry.Listeners[0].Services[0].TLS)
+	require.Nil(t, entry.Listeners[0].Services[1].TLS)
+	require.True(t, resource.MatchesConsul(entry))
+}
+
+func TestIngressGateway_ToConsulListenerTLS(t *testing.T) {
+	resource := &IngressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-gateway"},
+		Spec: IngressGatewaySpec{
+			Listeners: []IngressListenerSpec{
+				{Port: 8443, Protocol: "http", Services: []IngressServiceSpec{{Name: "web"}}, TLS: &GatewayTLSConfigSpec{
+					Enabled:       true,
+					TLSMinVersion: "TLSv1_2",
+					CipherSuites:  []string{"ECDHE-RSA-AES128-GCM-SHA256"},
+				}},
+				{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{{Name: "api"}}},
+			},
+		},
+	}
+	entry := resource.ToConsul("").(*IngressGatewayConfigEntry)
+	require.Equal(t, &GatewayTLSConfig{
+		Enabled:       true,
+		TLSMinVersion: "TLSv1_2",
+		CipherSuites:  []string{"ECDHE-RSA-AES128-GCM-SHA256"},
+	}, entry.Listeners[0].TLS)
+	require.Nil(t, entry.Listeners[1].TLS)
+	require.True(t, resource.MatchesConsul(entry))
+
+	// Consul returns an empty list of cipher suites.
+	resource.Spec.Listeners[0].TLS.CipherSuites = nil
+	entry.Listeners[0].TLS.CipherSuites = []string{}
+	require.True(t, resource.MatchesConsul(entry))
+}
+
+func TestIngressGateway_Default(t *testing.T) {
+	resource := &IngressGateway{
+		Spec: IngressGatewaySpec{
+			Listeners: []IngressListenerSpec{
+				{Port: 8080, Protocol: "http"},
+				{Port: 9090},
+			},
+		},
+	}
+	resource.Default()
+	require.Equal(t, "http", resource.Spec.Listeners[0].Protocol)
+	require.Equal(t, "tcp", resource.Spec.Listeners[1].Protocol)
+}
+
+func TestIngressGateway_MatchesConsul(t *testing.T) {
+	resource := &IngressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-gateway"},
+		Spec: IngressGatewaySpec{
+			Listeners: []IngressListenerSpec{
+				{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{{Name: "web"}}},
+			},
+		},
+	}
+	// Consul returns empty lists and, in Enterprise, defaults the
+	// services' namespace.
+	require.True(t, resource.MatchesConsul(&IngressGatewayConfigEntry{
+		Kind:      IngressGatewayKind,
+		Name:      "ingress-gateway",
+		Namespace: "ns",
+		TLS:       GatewayTLSConfig{CipherSuites: []string{}},
+		Listeners: []IngressListener{
+			{Port: 8080, Protocol: "http", Services: []IngressService{{Name: "web", Hosts: []string{}, Namespace: "ns"}}},
+		},
+		ModifyIndex: 10,
+	}))
+	require.False(t, resource.MatchesConsul(&IngressGatewayConfigEntry{
+		Kind: IngressGatewayKind,
+		Name: "ingress-gateway",
+		Listeners: []IngressListener{
+			{Port: 8081, Protocol: "http", Services: []IngressService{{Name: "web"}}},
+		},
+	}))
+	require.False(t, resource.MatchesConsul(&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "ingress-gateway"}))
+}
+
+func TestIngressGateway_Validate(t *testing.T) {
+	cases := map[string]struct {
+		spec   IngressGatewaySpec
+		expErr string
+	}{
+		"valid": {
+			spec: IngressGatewaySpec{
+				TLS: GatewayTLSConfigSpec{Enabled: true, TLSMinVersion: "TLSv1_2", TLSMaxVersion: "TLSv1_3"},
+				Listeners: []IngressListenerSpec{
+					{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{
+						{Name: "web", Hosts: []string{"web.example.com", "*.web.example.com"}},
+						{Name: "web", Namespace: "other"},
+					}},
+					{Port: 8081, Protocol: "grpc", Services: []IngressServiceSpec{{Name: "*"}}},
+					{Port: 9090, Services: []IngressServiceSpec{{Name: "db"}}},
+				},
+			},
+		},
+		"invalid TLS version": {
+			spec:   IngressGatewaySpec{TLS: GatewayTLSConfigSpec{TLSMinVersion: "TLSv1.2"}},
+			expErr: `spec.tls.tlsMinVersion must be one of TLS_AUTO, TLSv1_0, TLSv1_1, TLSv1_2, TLSv1_3, got "TLSv1.2"`,
+		},
+		"min TLS version greater than max": {
+			spec:   IngressGatewaySpec{TLS: GatewayTLSConfigSpec{TLSMinVersion: "TLSv1_3", TLSMaxVersion: "TLSv1_2"}},
+			expErr: "spec.tls.tlsMinVersion TLSv1_3 cannot be greater than tlsMaxVersion TLSv1_2",
+		},
+		"unsupported cipher suite": {
+			spec:   IngressGatewaySpec{TLS: GatewayTLSConfigSpec{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}},
+			expErr: `spec.tls.cipherSuites[0] "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" is not a cipher suite supported by Envoy`,
+		},
+		"duplicate cipher suite": {
+			spec:   IngressGatewaySpec{TLS: GatewayTLSConfigSpec{CipherSuites: []string{"AES128-SHA", "AES128-SHA"}}},
+			expErr: `spec.tls.cipherSuites[1] "AES128-SHA" is listed more than once`,
+		},
+		"cipher suites with TLS 1.3": {
+			spec:   IngressGatewaySpec{TLS: GatewayTLSConfigSpec{TLSMinVersion: "TLSv1_3", CipherSuites: []string{"AES128-SHA"}}},
+			expErr: "spec.tls.cipherSuites cannot be set with tlsMinVersion TLSv1_3",
+		},
+		"invalid listener TLS version": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8443, Protocol: "http", Services: []IngressServiceSpec{{Name: "web"}}, TLS: &GatewayTLSConfigSpec{TLSMaxVersion: "TLSv1_4"}},
+			}},
+			expErr: `spec.listeners[0].tls.tlsMaxVersion must be one of TLS_AUTO, TLSv1_0, TLSv1_1, TLSv1_2, TLSv1_3, got "TLSv1_4"`,
+		},
+		"invalid listener cipher suite": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8443, Protocol: "http", Services: []IngressServiceSpec{{Name: "web"}}, TLS: &GatewayTLSConfigSpec{CipherSuites: []string{"RC4-SHA"}}},
+			}},
+			expErr: `spec.listeners[0].tls.cipherSuites[0] "RC4-SHA" is not a cipher suite supported by Envoy`,
+		},
+		"host \"*\" with gateway TLS": {
+			spec: IngressGatewaySpec{
+				TLS: GatewayTLSConfigSpec{Enabled: true},
+				Listeners: []IngressListenerSpec{
+					{Port: 8443, Protocol: "http", Services: []IngressServiceSpec{{Name: "web", Hosts: []string{"*"}}}},
+				},
+			},
+			expErr: `spec.listeners[0].services[0].hosts[0] cannot be "*" with TLS enabled`,
+		},
+		"host \"*\" with listener TLS": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8443, Protocol: "http", Services: []IngressServiceSpec{{Name: "web", Hosts: []string{"*"}}}, TLS: &GatewayTLSConfigSpec{Enabled: true}},
+			}},
+			expErr: `spec.listeners[0].services[0].hosts[0] cannot be "*" with TLS enabled`,
+		},
+		"host \"*\" with listener TLS disabled": {
+			spec: IngressGatewaySpec{
+				TLS: GatewayTLSConfigSpec{Enabled: true},
+				Listeners: []IngressListenerSpec{
+					{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{{Name: "web", Hosts: []string{"*"}}}, TLS: &GatewayTLSConfigSpec{}},
+				},
+			},
+		},
+		"same host on different listeners": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{{Name: "web", Hosts: []string{"example.com"}}}},
+				{Port: 8081, Protocol: "http", Services: []IngressServiceSpec{{Name: "api", Hosts: []string{"example.com"}}}},
+			}},
+		},
+		"duplicate port": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8080, Services: []IngressServiceSpec{{Name: "web"}}},
+				{Port: 8080, Services: []IngressServiceSpec{{Name: "api"}}},
+			}},
+			expErr: "spec.listeners[1].port 8080 is used by another listener",
+		},
+		"invalid port": {
+			spec:   IngressGatewaySpec{Listeners: []IngressListenerSpec{{Services: []IngressServiceSpec{{Name: "web"}}}}},
+			expErr: "spec.listeners[0].port must be between 1 and 65535, got 0",
+		},
+		"invalid protocol": {
+			spec:   IngressGatewaySpec{Listeners: []IngressListenerSpec{{Port: 8080, Protocol: "udp", Services: []IngressServiceSpec{{Name: "web"}}}}},
+			expErr: `spec.listeners[0].protocol must be one of "tcp", "http", "http2" or "grpc", got "udp"`,
+		},
+		"no services": {
+			spec:   IngressGatewaySpec{Listeners: []IngressListenerSpec{{Port: 8080}}},
+			expErr: "spec.listeners[0].services must have at least one service",
+		},
+		"multiple tcp services": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8080, Services: []IngressServiceSpec{{Name: "web"}, {Name: "api"}}},
+			}},
+			expErr: `spec.listeners[0].services can only have one service with protocol "tcp"`,
+		},
+		"tcp wildcard": {
+			spec:   IngressGatewaySpec{Listeners: []IngressListenerSpec{{Port: 8080, Services: []IngressServiceSpec{{Name: "*"}}}}},
+			expErr: `spec.listeners[0].services[0].name cannot be "*" with protocol "tcp"`,
+		},
+		"tcp hosts": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8080, Services: []IngressServiceSpec{{Name: "web", Hosts: []string{"web.example.com"}}}},
+			}},
+			expErr: `spec.listeners[0].services[0].hosts cannot be set with protocol "tcp"`,
+		},
+		"wildcard hosts": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{{Name: "*", Hosts: []string{"web.example.com"}}}},
+			}},
+			expErr: `spec.listeners[0].services[0].hosts cannot be set for the "*" service`,
+		},
+		"duplicate service": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{{Name: "web"}, {Name: "web"}}},
+			}},
+			expErr: `spec.listeners[0].services[1]: service "web" is listed more than once`,
+		},
+		"invalid host": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{{Name: "web", Hosts: []string{"web.*.com"}}}},
+			}},
+			expErr: `spec.listeners[0].services[0].hosts[0] "web.*.com" is not a valid hostname`,
+		},
+		"duplicate host": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{
+					{Name: "web", Hosts: []string{"example.com"}},
+					{Name: "api", Hosts: []string{"example.com"}},
+				}},
+			}},
+			expErr: `spec.listeners[0].services[1].hosts[0] "example.com" is used by another service of the listener`,
+		},
+		"duplicate host of another case": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8080, Protocol: "http", Services: []IngressServiceSpec{
+					{Name: "web", Hosts: []string{"*.example.com"}},
+					{Name: "api", Hosts: []string{"*.Example.com"}},
+				}},
+			}},
+			expErr: `spec.listeners[0].services[1].hosts[0] "*.Example.com" is used by another service of the listener`,
+		},
+		"SDS without cluster": {
+			spec:   IngressGatewaySpec{TLS: GatewayTLSConfigSpec{SDS: &GatewayTLSSDSConfigSpec{CertResource: "cert"}}},
+			expErr: "spec.tls.sds.clusterName must be set",
+		},
+		"service SDS without cert resource": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8443, Protocol: "http", Services: []IngressServiceSpec{
+					{Name: "web", Hosts: []string{"web.example.com"}, TLS: &GatewayServiceTLSConfigSpec{SDS: &GatewayTLSSDSConfigSpec{ClusterName: "sds"}}},
+				}},
+			}},
+			expErr: "spec.listeners[0].services[0].tls.sds.certResource must be set",
+		},
+		"service SDS without hosts": {
+			spec: IngressGatewaySpec{Listeners: []IngressListenerSpec{
+				{Port: 8443, Protocol: "http", Services: []IngressServiceSpec{
+					{Name: "web", TLS: &GatewayServiceTLSConfigSpec{SDS: &GatewayTLSSDSConfigSpec{ClusterName: "sds", CertResource: "web-cert"}}},
+				}},
+			}},
+			expErr: "spec.listeners[0].services[0].tls.sds requires hosts to be set, the certificate is selected by SNI",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &IngressGateway{
+				ObjectMeta: metav1.ObjectMeta{Name: "ingress-gateway"},
+				Spec:       c.spec,
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package v1alpha1
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// JWTProviderResource is the resource name of JWTProvider.
+const JWTProviderResource = "jwtproviders"
+
+// defaultClockSkewSeconds is the clock skew Consul sets on jwt-provider
+// config entries that don't set one.
+const defaultClockSkewSeconds = 30
+
+// JWTProvider is the Schema for the jwtproviders API. It's cluster-scoped
+// and reconciled into the jwt-provider config entry of its name, which
+// requires Consul 1.16 or later. Intentions and API gateways reference the
+// provider by name to require valid end-user JWTs.
+type JWTProvider struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   JWTProviderSpec `json:"spec,omitempty"`
+	Status Status          `json:"status,omitempty"`
+}
+
+// JWTProviderSpec defines the desired state of JWTProvider.
+type JWTProviderSpec struct {
+	// Partition is the Consul admin partition to write the jwt-provider
+	// config entry to. It defaults to the partition of the controller.
+	Partition string `json:"partition,omitempty"`
+	// Issuer is the expected iss claim of the tokens. If empty, the claim
+	// isn't checked.
+	Issuer string `json:"issuer,omitempty"`
+	// JSONWebKeySet is the source of the keys verifying the signatures of
+	// the tokens.
+	JSONWebKeySet JSONWebKeySetSpec `json:"jsonWebKeySet"`
+	// Audiences are the accepted aud claims of the tokens. If empty, the
+	// claim isn't checked.
+	Audiences []string `json:"audiences,omitempty"`
+	// Locations are where the proxies look for the tokens. They default
+	// to the Authorization header with the "Bearer " prefix.
+	Locations []JWTLocationSpec `json:"locations,omitempty"`
+	// Forwarding forwards the payload of validated tokens to the services.
+	Forwarding JWTForwardingConfigSpec `json:"forwarding,omitempty"`
+	// ClockSkewSeconds is the clock skew tolerated when checking the exp
+	// and nbf claims. It defaults to 30 seconds.
+	ClockSkewSeconds int `json:"clockSkewSeconds,omitempty"`
+	// CacheConfig is the cache of the validated tokens.
+	CacheConfig JWTCacheConfigSpec `json:"cacheConfig,omitempty"`
+}
+
+// JSONWebKeySetSpec is the source of a key set. Exactly one of its fields
+// must be set.
+type JSONWebKeySetSpec struct {
+	// Local is a key set in the config entry or in a file of the proxies.
+	Local *LocalJWKSSpec `json:"local,omitempty"`
+	// Remote is a key set fetched by the proxies.
+	Remote *RemoteJWKSSpec `json:"remote,omitempty"`
+}
+
+// LocalJWKSSpec is a local key set. Exactly one of its fields must be set.
+type LocalJWKSSpec struct {
+	// JWKS is the base64-encoded key set.
+	JWKS string `json:"jwks,omitempty"`
+	// Filename is the path of the key set on the proxies' file system.
+	Filename string `json:"filename,omitempty"`
+}
+
+// RemoteJWKSSpec is a key set fetched from a remote endpoint.
+type RemoteJWKSSpec struct {
+	// URI is the HTTP or HTTPS URL of the key set.
+	URI string `json:"uri"`
+	// RequestTimeoutMs is the timeout of the request fetching the key
+	// set, in milliseconds.
+	RequestTimeoutMs int `json:"requestTimeoutMs,omitempty"`
+	// CacheDuration is how long the key set is cached.
+	CacheDuration metav1.Duration `json:"cacheDuration,omitempty"`
+	// FetchAsynchronously fetches the key set when the proxies start
+	// instead of on the first request.
+	FetchAsynchronously bool `json:"fetchAsynchronously,omitempty"`
+}
+
+// JWTLocationSpec is where the proxies look for the tokens. Exactly one of
+// its fields must be set.
+type JWTLocationSpec struct {
+	// Header is a header holding the tokens.
+	Header *JWTLocationHeaderSpec `json:"header,omitempty"`
+	// QueryParam is a query parameter holding the tokens.
+	QueryParam *JWTLocationQueryParamSpec `json:"queryParam,omitempty"`
+	// Cookie is a cookie holding the tokens.
+	Cookie *JWTLocationCookieSpec `json:"cookie,omitempty"`
+}
+
+// JWTLocationHeaderSpec is a header holding the tokens.
+type JWTLocationHeaderSpec struct {
+	// Name is the name of the header.
+	Name string `json:"name"`
+	// ValuePrefix is the prefix of the token in the header's value, e.g.
+	// "Bearer ".
+	ValuePrefix string `json:"valuePrefix,omitempty"`
+	// Forward forwards the header to the services.
+	Forward bool `json:"forward,omitempty"`
+}
+
+// JWTLocationQueryParamSpec is a query parameter holding the tokens.
+type JWTLocationQueryParamSpec struct {
+	// Name is the name of the query parameter.
+	Name string `json:"name"`
+}
+
+// JWTLocationCookieSpec is a cookie holding the tokens.
+type JWTLocationCookieSpec struct {
+	// Name is the name of the cookie.
+	Name string `json:"name"`
+}
+
+// JWTForwardingConfigSpec forwards the payload of validated tokens to the
+// services.
+type JWTForwardingConfigSpec struct {
+	// HeaderName is the name of the header the payload is forwarded in.
+	// The payload isn't forwarded if it's empty.
+	HeaderName string `json:"headerName,omitempty"`
+	// PadForwardPayloadHeader pads the base64-encoded payload.
+	PadForwardPayloadHeader bool `json:"padForwardPayloadHeader,omitempty"`
+}
+
+// JWTCacheConfigSpec is the cache of the validated tokens.
+type JWTCacheConfigSpec struct {
+	// Size is the maximum number of cached tokens. The tokens aren't
+	// cached if it's 0.
+	Size int `json:"size,omitempty"`
+}
+
+func (in *JWTProvider) ConsulKind() string {
+	return JWTProviderKind
+}
+
+func (in *JWTProvider) ConsulName() string {
+	return in.Name
+}
+
+func (in *JWTProvider) ResourceStatus() *Status {
+	return &in.Status
+}
+
+// ConsulTarget has no namespace since jwt-provider config entries are
+// scoped to a partition.
+func (in *JWTProvider) ConsulTarget() ConsulTarget {
+	return ConsulTarget{Partition: in.Spec.Partition}
+}
+
+func (in *JWTProvider) NewConsulEntry() api.ConfigEntry {
+	return &JWTProviderConfigEntry{}
+}
+
+// ToConsul ignores the namespace since jwt-provider config entries are
+// scoped to a partition.
+func (in *JWTProvider) ToConsul(string) api.ConfigEntry {
+	spec := in.Spec
+	entry := &JWTProviderConfigEntry{
+		Kind:             in.ConsulKind(),
+		Name:             in.ConsulName(),
+		Issuer:           spec.Issuer,
+		JSONWebKeySet:    &JSONWebKeySet{},
+		Audiences:        spec.Audiences,
+		ClockSkewSeconds: spec.ClockSkewSeconds,
+	}
+	if local := spec.JSONWebKeySet.Local; local != nil {
+		entry.JSONWebKeySet.Local = &LocalJWKS{JWKS: local.JWKS, Filename: local.Filename}
+	}
+	if remote := spec.JSONWebKeySet.Remote; remote != nil {
+		entry.JSONWebKeySet.Remote = &RemoteJWKS{
+			URI:                 remote.URI,
+			RequestTimeoutMs:    remote.RequestTimeoutMs,
+			FetchAsynchronously: remote.FetchAsynchronously,
+		}
+		if remote.CacheDuration.Duration != 0 {
+			entry.JSONWebKeySet.Remote.CacheDuration = remote.CacheDuration.Duration.String()
+		}
+	}
+	for _, location := range spec.Locations {
+		l := &JWTLocation{}
+		if header := location.Header; header != nil {
+			l.Header = &JWTLocationHeader{Name: header.Name, ValuePrefix: header.ValuePrefix, Forward: header.Forward}
+		}
+		if location.QueryParam != nil {
+			l.QueryParam = &JWTLocationQueryParam{Name: location.QueryParam.Name}
+		}
+		if location.Cookie != nil {
+			l.Cookie = &JWTLocationCookie{Name: location.Cookie.Name}
+		}
+		entry.Locations = append(entry.Locations, l)
+	}
+	if spec.Forwarding.HeaderName != "" {
+		entry.Forwarding = &JWTForwardingConfig{
+			HeaderName:              spec.Forwarding.HeaderName,
+			PadForwardPayloadHeader: spec.Forwarding.PadForwardPayloadHeader,
+		}
+	}
+	if spec.CacheConfig.Size != 0 {
+		entry.CacheConfig = &JWTCacheConfig{Size: spec.CacheConfig.Size}
+	}
+	return entry
+}
+
+func (in *JWTProvider) MatchesConsul(entry api.ConfigEntry) bool {
+	provider, ok := entry.(*JWTProviderConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *provider
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+	if len(actual.Audiences) == 0 {
+		actual.Audiences = nil
+	}
+	if len(actual.Locations) == 0 {
+		actual.Locations = nil
+	}
+	if actual.CacheConfig != nil && actual.CacheConfig.Size == 0 {
+		actual.CacheConfig = nil
+	}
+
+	expected := in.ToConsul("").(*JWTProviderConfigEntry)
+	// Consul sets the default clock skew.
+	if expected.ClockSkewSeconds == 0 {
+		expected.ClockSkewSeconds = defaultClockSkewSeconds
+	}
+	if actual.ClockSkewSeconds == 0 {
+		actual.ClockSkewSeconds = defaultClockSkewSeconds
+	}
+	// Consul may format the cache duration differently, e.g. "5m" as
+	// "5m0s".
+	if keySet := actual.JSONWebKeySet; keySet != nil && keySet.Remote != nil {
+		remote := *keySet.Remote
+		if d, err := time.ParseDuration(remote.CacheDuration); err == nil {
+			remote.CacheDuration = ""
+			if d != 0 {
+				remote.CacheDuration = d.String()
+			}
+		}
+		actual.JSONWebKeySet = &JSONWebKeySet{Local: keySet.Local, Remote: &remote}
+	}
+	return reflect.DeepEqual(expected, &actual)
+}
+
+func (in *JWTProvider) Validate() error {
+	spec := in.Spec
+	keySet := spec.JSONWebKeySet
+	if countSet(keySet.Local != nil, keySet.Remote != nil) != 1 {
+		return fmt.Errorf("spec.jsonWebKeySet must set exactly one of local or remote")
+	}
+	if local := keySet.Local; local != nil {
+		if countSet(local.JWKS != "", local.Filename != "") != 1 {
+			return fmt.Errorf("spec.jsonWebKeySet.local must set exactly one of jwks or filename")
+		}
+		if local.JWKS != "" {
+			if _, err := base64.StdEncoding.DecodeString(local.JWKS); err != nil {
+				return fmt.Errorf("spec.jsonWebKeySet.local.jwks must be base64-encoded: %s", err)
+			}
+		}
+	}
+	if remote := keySet.Remote; remote != nil {
+		if u, err := url.Parse(remote.URI); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("spec.jsonWebKeySet.remote.uri must be an http or https URL, got %q", remote.URI)
+		}
+		if remote.RequestTimeoutMs < 0 {
+			return fmt.Errorf("spec.jsonWebKeySet.remote.requestTimeoutMs must be positive, got %d", remote.RequestTimeoutMs)
+		}
+		if remote.CacheDuration.Duration < 0 {
+			return fmt.Errorf("spec.jsonWebKeySet.remote.cacheDuration must be positive, got %s", remote.CacheDuration.Duration)
+		}
+	}
+	for i, location := range spec.Locations {
+		path := fmt.Sprintf("spec.locations[%d]", i)
+		if countSet(location.Header != nil, location.QueryParam != nil, location.Cookie != nil) != 1 {
+			return fmt.Errorf("%s must set exactly one of header, queryParam or cookie", path)
+		}
+		switch {
+		case location.Header != nil && location.Header.Name == "":
+			return fmt.Errorf("%s.header.name must be set", path)
+		case location.QueryParam != nil && location.QueryParam.Name == "":
+			return fmt.Errorf("%s.queryParam.name must be set", path)
+		case location.Cookie != nil && location.Cookie.Name == "":
+			return fmt.Errorf("%s.cookie.name must be set", path)
+		}
+	}
+	if spec.Forwarding.PadForwardPayloadHeader && spec.Forwarding.HeaderName == "" {
+		return fmt.Errorf("spec.forwarding.headerName must be set if spec.forwarding.padForwardPayloadHeader is set")
+	}
+	if spec.ClockSkewSeconds < 0 {
+		return fmt.Errorf("spec.clockSkewSeconds must be positive, got %d", spec.ClockSkewSeconds)
+	}
+	if spec.CacheConfig.Size < 0 {
+		return fmt.Errorf("spec.cacheConfig.size must be positive, got %d", spec.CacheConfig.Size)
+	}
+	return nil
+}
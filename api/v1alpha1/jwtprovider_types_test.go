@@ -0,0 +1,187 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestJWTProvider_ToConsul(t *testing.T) {
+	resource := &JWTProvider{
+		ObjectMeta: metav1.ObjectMeta{Name: "okta"},
+		Spec: JWTProviderSpec{
+			Partition: "ap1",
+			Issuer:    "https://okta.example.com",
+			JSONWebKeySet: JSONWebKeySetSpec{Remote: &RemoteJWKSSpec{
+				URI:                 "https://okta.example.com/keys",
+				RequestTimeoutMs:    1500,
+				CacheDuration:       metav1.Duration{Duration: 5 * time.Minute},
+				FetchAsynchronously: true,
+			}},
+			Audiences: []string{"api"},
+			Locations: []JWTLocationSpec{
+				{Header: &JWTLocationHeaderSpec{Name: "Authorization", ValuePrefix: "Bearer ", Forward: true}},
+				{QueryParam: &JWTLocationQueryParamSpec{Name: "token"}},
+				{Cookie: &JWTLocationCookieSpec{Name: "session"}},
+			},
+			Forwarding:       JWTForwardingConfigSpec{HeaderName: "x-jwt-payload"},
+			ClockSkewSeconds: 10,
+			CacheConfig:      JWTCacheConfigSpec{Size: 100},
+		},
+	}
+	require.Equal(t, &JWTProviderConfigEntry{
+		Kind:   JWTProviderKind,
+		Name:   "okta",
+		Issuer: "https://okta.example.com",
+		JSONWebKeySet: &JSONWebKeySet{Remote: &RemoteJWKS{
+			URI:                 "https://okta.example.com/keys",
+			RequestTimeoutMs:    1500,
+			CacheDuration:       "5m0s",
+			FetchAsynchronously: true,
+		}},
+		Audiences: []string{"api"},
+		Locations: []*JWTLocation{
+			{Header: &JWTLocationHeader{Name: "Authorization", ValuePrefix: "Bearer ", Forward: true}},
+			{QueryParam: &JWTLocationQueryParam{Name: "token"}},
+			{Cookie: &JWTLocationCookie{Name: "session"}},
+		},
+		Forwarding:       &JWTForwardingConfig{HeaderName: "x-jwt-payload"},
+		ClockSkewSeconds: 10,
+		CacheConfig:      &JWTCacheConfig{Size: 100},
+	}, resource.ToConsul("ignored"))
+	require.Equal(t, ConsulTarget{Partition: "ap1"}, resource.ConsulTarget())
+}
+
+func TestJWTProvider_MatchesConsul(t *testing.T) {
+	resource := &JWTProvider{
+		ObjectMeta: metav1.ObjectMeta{Name: "okta"},
+		Spec: JWTProviderSpec{
+			JSONWebKeySet: JSONWebKeySetSpec{Remote: &RemoteJWKSSpec{
+				URI:           "https://okta.example.com/keys",
+				CacheDuration: metav1.Duration{Duration: 5 * time.Minute},
+			}},
+		},
+	}
+	// Consul sets the default clock skew and may format durations
+	// differently.
+	require.True(t, resource.MatchesConsul(&JWTProviderConfigEntry{
+		Kind: JWTProviderKind,
+		Name: "okta",
+		JSONWebKeySet: &JSONWebKeySet{Remote: &RemoteJWKS{
+			URI:           "https://okta.example.com/keys",
+			CacheDuration: "300s",
+		}},
+		ClockSkewSeconds: 30,
+		CacheConfig:      &JWTCacheConfig{},
+		ModifyIndex:      10,
+	}))
+	require.False(t, resource.MatchesConsul(&JWTProviderConfigEntry{
+		Kind: JWTProviderKind,
+		Name: "okta",
+		JSONWebKeySet: &JSONWebKeySet{Remote: &RemoteJWKS{
+			URI:           "https://okta.example.com/keys",
+			CacheDuration: "10m",
+		}},
+	}))
+	require.False(t, resource.MatchesConsul(&JWTProviderConfigEntry{
+		Kind:          JWTProviderKind,
+		Name:          "okta",
+		JSONWebKeySet: &JSONWebKeySet{Local: &LocalJWKS{Filename: "/keys.json"}},
+	}))
+	require.False(t, resource.MatchesConsul(&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "okta"}))
+}
+
+func TestJWTProvider_Validate(t *testing.T) {
+	remote := JSONWebKeySetSpec{Remote: &RemoteJWKSSpec{URI: "https://okta.example.com/keys"}}
+	cases := map[string]struct {
+		spec   JWTProviderSpec
+		expErr string
+	}{
+		"valid remote": {
+			spec: JWTProviderSpec{
+				JSONWebKeySet: remote,
+				Locations:     []JWTLocationSpec{{Header: &JWTLocationHeaderSpec{Name: "Authorization"}}},
+				Forwarding:    JWTForwardingConfigSpec{HeaderName: "x-jwt-payload", PadForwardPayloadHeader: true},
+			},
+		},
+		"valid local": {
+			spec: JWTProviderSpec{JSONWebKeySet: JSONWebKeySetSpec{Local: &LocalJWKSSpec{JWKS: "e30="}}},
+		},
+		"no key set": {
+			expErr: "spec.jsonWebKeySet must set exactly one of local or remote",
+		},
+		"local and remote": {
+			spec: JWTProviderSpec{JSONWebKeySet: JSONWebKeySetSpec{
+				Local:  &LocalJWKSSpec{Filename: "/keys.json"},
+				Remote: remote.Remote,
+			}},
+			expErr: "spec.jsonWebKeySet must set exactly one of local or remote",
+		},
+		"local jwks and filename": {
+			spec:   JWTProviderSpec{JSONWebKeySet: JSONWebKeySetSpec{Local: &LocalJWKSSpec{JWKS: "e30=", Filename: "/keys.json"}}},
+			expErr: "spec.jsonWebKeySet.local must set exactly one of jwks or filename",
+		},
+		"local jwks not base64": {
+			spec:   JWTProviderSpec{JSONWebKeySet: JSONWebKeySetSpec{Local: &LocalJWKSSpec{JWKS: "{}"}}},
+			expErr: "spec.jsonWebKeySet.local.jwks must be base64-encoded",
+		},
+		"invalid remote uri": {
+			spec:   JWTProviderSpec{JSONWebKeySet: JSONWebKeySetSpec{Remote: &RemoteJWKSSpec{URI: "okta.example.com/keys"}}},
+			expErr: `spec.jsonWebKeySet.remote.uri must be an http or https URL, got "okta.example.com/keys"`,
+		},
+		"negative cache duration": {
+			spec: JWTProviderSpec{JSONWebKeySet: JSONWebKeySetSpec{Remote: &RemoteJWKSSpec{
+				URI:           "https://okta.example.com/keys",
+				CacheDuration: metav1.Duration{Duration: -time.Second},
+			}}},
+			expErr: "spec.jsonWebKeySet.remote.cacheDuration must be positive, got -1s",
+		},
+		"location with two sources": {
+			spec: JWTProviderSpec{
+				JSONWebKeySet: remote,
+				Locations: []JWTLocationSpec{{
+					Header:     &JWTLocationHeaderSpec{Name: "Authorization"},
+					QueryParam: &JWTLocationQueryParamSpec{Name: "token"},
+				}},
+			},
+			expErr: "spec.locations[0] must set exactly one of header, queryParam or cookie",
+		},
+		"location without name": {
+			spec: JWTProviderSpec{
+				JSONWebKeySet: remote,
+				Locations:     []JWTLocationSpec{{Cookie: &JWTLocationCookieSpec{}}},
+			},
+			expErr: "spec.locations[0].cookie.name must be set",
+		},
+		"padding without header": {
+			spec: JWTProviderSpec{
+				JSONWebKeySet: remote,
+				Forwarding:    JWTForwardingConfigSpec{PadForwardPayloadHeader: true},
+			},
+			expErr: "spec.forwarding.headerName must be set if spec.forwarding.padForwardPayloadHeader is set",
+		},
+		"negative clock skew": {
+			spec:   JWTProviderSpec{JSONWebKeySet: remote, ClockSkewSeconds: -1},
+			expErr: "spec.clockSkewSeconds must be positive, got -1",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &JWTProvider{
+				ObjectMeta: metav1.ObjectMeta{Name: "okta"},
+				Spec:       c.spec,
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
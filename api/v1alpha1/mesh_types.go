@@ -0,0 +1,180 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MeshResource is the resource name of Mesh.
+const MeshResource = "meshes"
+
+// Mesh is the Schema for the meshes API. It's cluster-scoped and
+// reconciled into the mesh config entry, which requires Consul 1.10 or
+// later. There can only be one mesh config entry, so the only valid name
+// is "mesh".
+type Mesh struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MeshSpec `json:"spec,omitempty"`
+	Status Status   `json:"status,omitempty"`
+}
+
+// MeshSpec defines the desired state of Mesh.
+type MeshSpec struct {
+	// Partition is the Consul admin partition to write the mesh config
+	// entry to. It defaults to the partition of the controller.
+	Partition string `json:"partition,omitempty"`
+	// TransparentProxy controls the configuration specific to proxies in
+	// transparent mode.
+	TransparentProxy TransparentProxyMeshConfigSpec `json:"transparentProxy,omitempty"`
+	// TLS controls the TLS versions and cipher suites of the mesh's
+	// proxies.
+	TLS MeshTLSConfigSpec `json:"tls,omitempty"`
+	// HTTP controls the HTTP configuration of the mesh's proxies.
+	HTTP MeshHTTPConfigSpec `json:"http,omitempty"`
+	// Peering controls the cluster peering configuration of the mesh.
+	Peering PeeringMeshConfigSpec `json:"peering,omitempty"`
+}
+
+// TransparentProxyMeshConfigSpec is the transparent proxy configuration
+// of the mesh.
+type TransparentProxyMeshConfigSpec struct {
+	// MeshDestinationsOnly determines whether sidecar proxies operating in
+	// transparent mode can proxy traffic to IP addresses not registered
+	// in Consul's mesh. If enabled, traffic will only be proxied to
+	// upstream proxies or Connect-native services.
+	MeshDestinationsOnly bool `json:"meshDestinationsOnly,omitempty"`
+}
+
+// MeshTLSConfigSpec is the TLS configuration of the mesh's proxies.
+type MeshTLSConfigSpec struct {
+	// Incoming configures the TLS of the connections to the proxies'
+	// public listeners.
+	Incoming MeshDirectionalTLSConfigSpec `json:"incoming,omitempty"`
+	// Outgoing configures the TLS of the proxies' upstream connections.
+	Outgoing MeshDirectionalTLSConfigSpec `json:"outgoing,omitempty"`
+}
+
+// MeshDirectionalTLSConfigSpec is the TLS configuration of incoming or
+// outgoing connections.
+type MeshDirectionalTLSConfigSpec struct {
+	// TLSMinVersion sets the minimum TLS version supported, one of
+	// TLS_AUTO, TLSv1_0, TLSv1_1, TLSv1_2 or TLSv1_3.
+	TLSMinVersion string `json:"tlsMinVersion,omitempty"`
+	// TLSMaxVersion sets the maximum TLS version supported.
+	TLSMaxVersion string `json:"tlsMaxVersion,omitempty"`
+	// CipherSuites sets the list of TLS cipher suites to support when
+	// negotiating connections using TLS 1.2 or earlier.
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+}
+
+// MeshHTTPConfigSpec is the HTTP configuration of the mesh's proxies.
+type MeshHTTPConfigSpec struct {
+	// SanitizeXForwardedClientCert removes the X-Forwarded-Client-Cert
+	// header of incoming requests.
+	SanitizeXForwardedClientCert bool `json:"sanitizeXForwardedClientCert,omitempty"`
+}
+
+// PeeringMeshConfigSpec is the cluster peering configuration of the mesh.
+type PeeringMeshConfigSpec struct {
+	// PeerThroughMeshGateways determines whether peering traffic between
+	// control planes should flow through mesh gateways.
+	PeerThroughMeshGateways bool `json:"peerThroughMeshGateways,omitempty"`
+}
+
+func (in *Mesh) ConsulKind() string {
+	return MeshKind
+}
+
+func (in *Mesh) ConsulName() string {
+	return MeshName
+}
+
+func (in *Mesh) ResourceStatus() *Status {
+	return &in.Status
+}
+
+// ConsulTarget has no namespace since mesh config entries are
+// global within their partition.
+func (in *Mesh) ConsulTarget() ConsulTarget {
+	return ConsulTarget{Partition: in.Spec.Partition}
+}
+
+func (in *Mesh) NewConsulEntry() api.ConfigEntry {
+	return &MeshConfigEntry{}
+}
+
+func (in *Mesh) ToConsul(namespace string) api.ConfigEntry {
+	entry := &MeshConfigEntry{
+		Kind:      in.ConsulKind(),
+		Namespace: namespace,
+		TransparentProxy: TransparentProxyMeshConfig{
+			MeshDestinationsOnly: in.Spec.TransparentProxy.MeshDestinationsOnly,
+		},
+	}
+	incoming, outgoing := in.Spec.TLS.Incoming.toConsul(), in.Spec.TLS.Outgoing.toConsul()
+	if incoming != nil || outgoing != nil {
+		entry.TLS = &MeshTLSConfig{Incoming: incoming, Outgoing: outgoing}
+	}
+	if in.Spec.HTTP.SanitizeXForwardedClientCert {
+		entry.HTTP = &MeshHTTPConfig{SanitizeXForwardedClientCert: true}
+	}
+	if in.Spec.Peering.PeerThroughMeshGateways {
+		entry.Peering = &PeeringMeshConfig{PeerThroughMeshGateways: true}
+	}
+	return entry
+}
+
+func (in MeshDirectionalTLSConfigSpec) toConsul() *MeshDirectionalTLSConfig {
+	if in.TLSMinVersion == "" && in.TLSMaxVersion == "" && len(in.CipherSuites) == 0 {
+		return nil
+	}
+	return &MeshDirectionalTLSConfig{
+		TLSMinVersion: in.TLSMinVersion,
+		TLSMaxVersion: in.TLSMaxVersion,
+		CipherSuites:  in.CipherSuites,
+	}
+}
+
+func (in *Mesh) MatchesConsul(entry api.ConfigEntry) bool {
+	mesh, ok := entry.(*MeshConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *mesh
+	actual.Namespace = ""
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+
+	// Consul returns the optional sections even if they're disabled.
+	if actual.HTTP != nil && !actual.HTTP.SanitizeXForwardedClientCert {
+		actual.HTTP = nil
+	}
+	if actual.Peering != nil && !actual.Peering.PeerThroughMeshGateways {
+		actual.Peering = nil
+	}
+	if actual.TLS != nil && actual.TLS.Incoming == nil && actual.TLS.Outgoing == nil {
+		actual.TLS = nil
+	}
+	return reflect.DeepEqual(in.ToConsul(""), &actual)
+}
+
+func (in *Mesh) Validate() error {
+	if in.Name != MeshName {
+		return fmt.Errorf("name must be %q since there can only be one mesh config entry, got %q", MeshName, in.Name)
+	}
+	if err := validateTLSVersions("spec.tls.incoming", in.Spec.TLS.Incoming.TLSMinVersion, in.Spec.TLS.Incoming.TLSMaxVersion); err != nil {
+		return err
+	}
+	if err := validateFIPSCipherSuites("spec.tls.incoming", in.Spec.TLS.Incoming.CipherSuites); err != nil {
+		return err
+	}
+	if err := validateTLSVersions("spec.tls.outgoing", in.Spec.TLS.Outgoing.TLSMinVersion, in.Spec.TLS.Outgoing.TLSMaxVersion); err != nil {
+		return err
+	}
+	return validateFIPSCipherSuites("spec.tls.outgoing", in.Spec.TLS.Outgoing.CipherSuites)
+}
@@ -0,0 +1,99 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMesh_ToConsul(t *testing.T) {
+	resource := &Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh"},
+		Spec: MeshSpec{
+			TransparentProxy: TransparentProxyMeshConfigSpec{MeshDestinationsOnly: true},
+			TLS: MeshTLSConfigSpec{
+				Incoming: MeshDirectionalTLSConfigSpec{TLSMinVersion: "TLSv1_2", CipherSuites: []string{"ECDHE-RSA-AES128-GCM-SHA256"}},
+			},
+			Peering: PeeringMeshConfigSpec{PeerThroughMeshGateways: true},
+		},
+	}
+	require.Equal(t, &MeshConfigEntry{
+		Kind:             MeshKind,
+		TransparentProxy: TransparentProxyMeshConfig{MeshDestinationsOnly: true},
+		TLS: &MeshTLSConfig{
+			Incoming: &MeshDirectionalTLSConfig{TLSMinVersion: "TLSv1_2", CipherSuites: []string{"ECDHE-RSA-AES128-GCM-SHA256"}},
+		},
+		Peering: &PeeringMeshConfig{PeerThroughMeshGateways: true},
+	}, resource.ToConsul(""))
+	require.Equal(t, MeshName, resource.ToConsul("").GetName())
+}
+
+func TestMesh_MatchesConsul(t *testing.T) {
+	resource := &Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh"},
+		Spec: MeshSpec{
+			TransparentProxy: TransparentProxyMeshConfigSpec{MeshDestinationsOnly: true},
+		},
+	}
+	// Consul returns the disabled optional sections.
+	require.True(t, resource.MatchesConsul(&MeshConfigEntry{
+		Kind:             MeshKind,
+		TransparentProxy: TransparentProxyMeshConfig{MeshDestinationsOnly: true},
+		TLS:              &MeshTLSConfig{},
+		HTTP:             &MeshHTTPConfig{},
+		Peering:          &PeeringMeshConfig{},
+		ModifyIndex:      10,
+	}))
+	require.False(t, resource.MatchesConsul(&MeshConfigEntry{Kind: MeshKind}))
+	require.False(t, resource.MatchesConsul(&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "mesh"}))
+}
+
+func TestMesh_Validate(t *testing.T) {
+	cases := map[string]struct {
+		name   string
+		spec   MeshSpec
+		expErr string
+	}{
+		"valid": {
+			name: "mesh",
+			spec: MeshSpec{TLS: MeshTLSConfigSpec{
+				Incoming: MeshDirectionalTLSConfigSpec{TLSMinVersion: "TLSv1_2"},
+				Outgoing: MeshDirectionalTLSConfigSpec{TLSMinVersion: "TLS_AUTO", TLSMaxVersion: "TLSv1_3"},
+			}},
+		},
+		"invalid name": {
+			name:   "default",
+			expErr: `name must be "mesh" since there can only be one mesh config entry, got "default"`,
+		},
+		"invalid TLS version": {
+			name:   "mesh",
+			spec:   MeshSpec{TLS: MeshTLSConfigSpec{Outgoing: MeshDirectionalTLSConfigSpec{TLSMaxVersion: "TLSv2"}}},
+			expErr: `spec.tls.outgoing.tlsMaxVersion must be one of TLS_AUTO, TLSv1_0, TLSv1_1, TLSv1_2, TLSv1_3, got "TLSv2"`,
+		},
+		"min TLS version greater than max": {
+			name: "mesh",
+			spec: MeshSpec{TLS: MeshTLSConfigSpec{
+				Incoming: MeshDirectionalTLSConfigSpec{TLSMinVersion: "TLSv1_3", TLSMaxVersion: "TLSv1_1"},
+			}},
+			expErr: "spec.tls.incoming.tlsMinVersion TLSv1_3 cannot be greater than tlsMaxVersion TLSv1_1",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &Mesh{
+				ObjectMeta: metav1.ObjectMeta{Name: c.name},
+				Spec:       c.spec,
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// PeeringAcceptorResource is the resource name of PeeringAcceptor.
+	PeeringAcceptorResource = "peeringacceptors"
+
+	// PeeringDialerResource is the resource name of PeeringDialer.
+	PeeringDialerResource = "peeringdialers"
+
+	// SecretBackendKubernetes is the backend of peering token secrets
+	// stored in Kubernetes. It's the only supported backend.
+	SecretBackendKubernetes = "kubernetes"
+)
+
+// PeeringAcceptor is the Schema for the peeringacceptors API. Its
+// controller generates a peering token for the peer with the resource's
+// name and stores it in the secret of the spec, in the resource's
+// namespace. The token is used by the PeeringDialer of the other cluster
+// to establish the peering, which requires Consul 1.13 or later.
+type PeeringAcceptor struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PeeringAcceptorSpec `json:"spec,omitempty"`
+	Status PeeringStatus       `json:"status,omitempty"`
+}
+
+// PeeringAcceptorSpec defines the desired state of PeeringAcceptor.
+type PeeringAcceptorSpec struct {
+	// Peer describes where to store the generated peering token.
+	Peer Peer `json:"peer"`
+	// ServerExternalAddresses are the addresses, as host:port, the peer
+	// dials to reach the gRPC port of the Consul servers, e.g. a load
+	// balancer in front of the servers when they aren't directly
+	// reachable. They override the addresses of the servers, or of the
+	// mesh gateways if the mesh config entry peers through mesh
+	// gateways. Requires Consul 1.14 or later.
+	ServerExternalAddresses []string `json:"serverExternalAddresses,omitempty"`
+}
+
+// PeeringDialer is the Schema for the peeringdialers API. Its controller
+// establishes the peering with the peer of the resource's name using the
+// peering token in the secret of the spec, in the resource's namespace.
+// The token is generated by the PeeringAcceptor of the other cluster.
+type PeeringDialer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PeeringDialerSpec `json:"spec,omitempty"`
+	Status PeeringStatus     `json:"status,omitempty"`
+}
+
+// PeeringDialerSpec defines the desired state of PeeringDialer.
+type PeeringDialerSpec struct {
+	// Peer describes where to read the peering token from.
+	Peer Peer `json:"peer"`
+}
+
+// Peer is the peer of a peering.
+type Peer struct {
+	// Secret is the secret holding the peering token.
+	Secret *PeerSecret `json:"secret,omitempty"`
+}
+
+// PeerSecret is the secret holding a peering token.
+type PeerSecret struct {
+	// Name is the name of the secret.
+	Name string `json:"name,omitempty"`
+	// Key is the key of the peering token in the secret.
+	Key string `json:"key,omitempty"`
+	// Backend is where the secret is stored. Only "kubernetes" is
+	// supported.
+	Backend string `json:"backend,omitempty"`
+}
+
+// PeeringStatus is the status of PeeringAcceptor and PeeringDialer.
+type PeeringStatus struct {
+	Status `json:",inline"`
+	// State is the state of the peering in Consul as of the last sync,
+	// e.g. PENDING or ACTIVE.
+	State string `json:"state,omitempty"`
+	// SecretRef is the secret holding the peering token that was last
+	// generated or used to establish the peering.
+	SecretRef *SecretRefStatus `json:"secretRef,omitempty"`
+}
+
+// SecretRefStatus is a version of the secret holding a peering token.
+type SecretRefStatus struct {
+	PeerSecret `json:",inline"`
+	// ResourceVersion is the resource version of the secret.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// PeeringResource is implemented by PeeringAcceptor and PeeringDialer.
+type PeeringResource interface {
+	metav1.Object
+
+	// PeerSecret returns the secret holding the peering token.
+	PeerSecret() *PeerSecret
+
+	// PeeringStatus returns the status of the resource.
+	PeeringStatus() *PeeringStatus
+
+	// Validate returns an error if the resource can't be reconciled.
+	Validate() error
+}
+
+func (in *PeeringAcceptor) PeerSecret() *PeerSecret {
+	return in.Spec.Peer.Secret
+}
+
+func (in *PeeringAcceptor) PeeringStatus() *PeeringStatus {
+	return &in.Status
+}
+
+func (in *PeeringAcceptor) Validate() error {
+	if err := in.Spec.Peer.validate(); err != nil {
+		return err
+	}
+	for i, addr := range in.Spec.ServerExternalAddresses {
+		host, port, err := net.SplitHostPort(addr)
+		if err == nil && host != "" {
+			var p int
+			if p, err = strconv.Atoi(port); err == nil && (p < 1 || p > 65535) {
+				err = fmt.Errorf("invalid port")
+			}
+		}
+		if err != nil || host == "" {
+			return fmt.Errorf("spec.serverExternalAddresses[%d] must be of the form <host>:<port>, got %q", i, addr)
+		}
+	}
+	return nil
+}
+
+func (in *PeeringDialer) PeerSecret() *PeerSecret {
+	return in.Spec.Peer.Secret
+}
+
+func (in *PeeringDialer) PeeringStatus() *PeeringStatus {
+	return &in.Status
+}
+
+func (in *PeeringDialer) Validate() error {
+	return in.Spec.Peer.validate()
+}
+
+func (in Peer) validate() error {
+	if in.Secret == nil {
+		return fmt.Errorf("spec.peer.secret must be set")
+	}
+	if in.Secret.Name == "" {
+		return fmt.Errorf("spec.peer.secret.name must be set")
+	}
+	if in.Secret.Key == "" {
+		return fmt.Errorf("spec.peer.secret.key must be set")
+	}
+	if in.Secret.Backend != SecretBackendKubernetes {
+		return fmt.Errorf("spec.peer.secret.backend must be %q, got %q", SecretBackendKubernetes, in.Secret.Backend)
+	}
+	return nil
+}
+
+// Matches returns true if the secret ref is the given secret.
+func (in *SecretRefStatus) Matches(secret *PeerSecret) bool {
+	return in != nil && secret != nil && in.PeerSecret == *secret
+}
@@ -0,0 +1,102 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeering_Validate(t *testing.T) {
+	cases := map[string]struct {
+		peer   Peer
+		expErr string
+	}{
+		"valid": {
+			peer: Peer{Secret: &PeerSecret{Name: "token", Key: "data", Backend: "kubernetes"}},
+		},
+		"no secret": {
+			expErr: "spec.peer.secret must be set",
+		},
+		"no secret name": {
+			peer:   Peer{Secret: &PeerSecret{Key: "data", Backend: "kubernetes"}},
+			expErr: "spec.peer.secret.name must be set",
+		},
+		"no secret key": {
+			peer:   Peer{Secret: &PeerSecret{Name: "token", Backend: "kubernetes"}},
+			expErr: "spec.peer.secret.key must be set",
+		},
+		"invalid backend": {
+			peer:   Peer{Secret: &PeerSecret{Name: "token", Key: "data", Backend: "vault"}},
+			expErr: `spec.peer.secret.backend must be "kubernetes", got "vault"`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resources := []PeeringResource{
+				&PeeringAcceptor{Spec: PeeringAcceptorSpec{Peer: c.peer}},
+				&PeeringDialer{Spec: PeeringDialerSpec{Peer: c.peer}},
+			}
+			for _, resource := range resources {
+				err := resource.Validate()
+				if c.expErr == "" {
+					require.NoError(t, err)
+				} else {
+					require.EqualError(t, err, c.expErr)
+				}
+			}
+		})
+	}
+}
+
+func TestPeeringAcceptor_ValidateServerExternalAddresses(t *testing.T) {
+	cases := map[string]struct {
+		addresses []string
+		expErr    string
+	}{
+		"none": {},
+		"valid": {
+			addresses: []string{"1.2.3.4:8502", "servers.example.com:443", "[::1]:8502"},
+		},
+		"no port": {
+			addresses: []string{"1.2.3.4"},
+			expErr:    `spec.serverExternalAddresses[0] must be of the form <host>:<port>, got "1.2.3.4"`,
+		},
+		"no host": {
+			addresses: []string{"1.2.3.4:8502", ":8502"},
+			expErr:    `spec.serverExternalAddresses[1] must be of the form <host>:<port>, got ":8502"`,
+		},
+		"invalid port": {
+			addresses: []string{"1.2.3.4:grpc"},
+			expErr:    `spec.serverExternalAddresses[0] must be of the form <host>:<port>, got "1.2.3.4:grpc"`,
+		},
+		"port out of range": {
+			addresses: []string{"1.2.3.4:70000"},
+			expErr:    `spec.serverExternalAddresses[0] must be of the form <host>:<port>, got "1.2.3.4:70000"`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			acceptor := &PeeringAcceptor{Spec: PeeringAcceptorSpec{
+				Peer:                    Peer{Secret: &PeerSecret{Name: "token", Key: "data", Backend: "kubernetes"}},
+				ServerExternalAddresses: c.addresses,
+			}}
+			err := acceptor.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
+
+func TestSecretRefStatus_Matches(t *testing.T) {
+	secret := &PeerSecret{Name: "token", Key: "data", Backend: "kubernetes"}
+	var ref *SecretRefStatus
+	require.False(t, ref.Matches(secret))
+	ref = &SecretRefStatus{PeerSecret: *secret, ResourceVersion: "1"}
+	require.True(t, ref.Matches(secret))
+	require.False(t, ref.Matches(&PeerSecret{Name: "token", Key: "other", Backend: "kubernetes"}))
+	require.False(t, ref.Matches(nil))
+}
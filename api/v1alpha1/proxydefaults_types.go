@@ -0,0 +1,123 @@
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ProxyDefaultsResource is the resource name of ProxyDefaults.
+const ProxyDefaultsResource = "proxydefaults"
+
+// ProxyDefaults is the Schema for the proxydefaults API. It's cluster-scoped
+// and reconciled into the global proxy-defaults config entry, so the only
+// valid name is "global".
+type ProxyDefaults struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ProxyDefaultsSpec `json:"spec,omitempty"`
+	Status Status            `json:"status,omitempty"`
+}
+
+// ProxyDefaultsSpec defines the desired state of ProxyDefaults.
+type ProxyDefaultsSpec struct {
+	// Partition is the Consul admin partition to write the proxy-defaults config
+	// entry to. It defaults to the partition of the controller.
+	Partition string `json:"partition,omitempty"`
+	// Config is an arbitrary map of configuration values used by Connect
+	// proxies, e.g. the Envoy escape hatches.
+	Config map[string]interface{} `json:"config,omitempty"`
+	// MeshGateway controls the default mesh gateway configuration for
+	// all services.
+	MeshGateway MeshGatewayConfig `json:"meshGateway,omitempty"`
+	// Expose controls the default expose path configuration for Envoy.
+	Expose ExposeConfig `json:"expose,omitempty"`
+}
+
+func (in *ProxyDefaults) ConsulKind() string {
+	return api.ProxyDefaults
+}
+
+func (in *ProxyDefaults) ConsulName() string {
+	return in.Name
+}
+
+func (in *ProxyDefaults) ResourceStatus() *Status {
+	return &in.Status
+}
+
+// ConsulTarget has no namespace since proxy-defaults config entries are
+// global within their partition.
+func (in *ProxyDefaults) ConsulTarget() ConsulTarget {
+	return ConsulTarget{Partition: in.Spec.Partition}
+}
+
+func (in *ProxyDefaults) ToConsul(namespace string) api.ConfigEntry {
+	return &api.ProxyConfigEntry{
+		Kind:        in.ConsulKind(),
+		Name:        in.ConsulName(),
+		Namespace:   namespace,
+		Config:      in.Spec.Config,
+		MeshGateway: in.Spec.MeshGateway.toConsul(),
+		Expose:      in.Spec.Expose.toConsul(),
+	}
+}
+
+func (in *ProxyDefaults) MatchesConsul(entry api.ConfigEntry) bool {
+	proxyDefaults, ok := entry.(*api.ProxyConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *proxyDefaults
+	actual.Namespace = ""
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+
+	// The config is compared after a JSON round trip since numbers
+	// read from Consul are float64 while Kubernetes decodes whole
+	// numbers as int64.
+	expected := in.ToConsul("").(*api.ProxyConfigEntry)
+	expectedConfig, err := normalizeJSON(expected.Config)
+	if err != nil {
+		return false
+	}
+	actualConfig, err := normalizeJSON(actual.Config)
+	if err != nil {
+		return false
+	}
+	expected.Config, actual.Config = nil, nil
+	return reflect.DeepEqual(expectedConfig, actualConfig) && reflect.DeepEqual(expected, &actual)
+}
+
+func (in *ProxyDefaults) Validate() error {
+	if in.Name != api.ProxyConfigGlobal {
+		return fmt.Errorf("name must be %q since there can only be one proxy-defaults config entry, got %q",
+			api.ProxyConfigGlobal, in.Name)
+	}
+	if err := in.Spec.MeshGateway.validate(); err != nil {
+		return fmt.Errorf("spec.%s", err)
+	}
+	if err := in.Spec.Expose.validate(); err != nil {
+		return fmt.Errorf("spec.%s", err)
+	}
+	return nil
+}
+
+// normalizeJSON returns the value decoded from its JSON encoding. Empty
+// maps are returned as nil.
+func normalizeJSON(value map[string]interface{}) (interface{}, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	err = json.Unmarshal(data, &normalized)
+	return normalized, err
+}
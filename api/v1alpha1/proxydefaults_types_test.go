@@ -0,0 +1,129 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProxyDefaults_ToConsul(t *testing.T) {
+	resource := &ProxyDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: "global"},
+		Spec: ProxyDefaultsSpec{
+			Config:      map[string]interface{}{"protocol": "http"},
+			MeshGateway: MeshGatewayConfig{Mode: "remote"},
+			Expose: ExposeConfig{
+				Checks: true,
+			},
+		},
+	}
+	require.Equal(t, &api.ProxyConfigEntry{
+		Kind:        api.ProxyDefaults,
+		Name:        "global",
+		Config:      map[string]interface{}{"protocol": "http"},
+		MeshGateway: api.MeshGatewayConfig{Mode: api.MeshGatewayModeRemote},
+		Expose:      api.ExposeConfig{Checks: true},
+	}, resource.ToConsul(""))
+}
+
+func TestProxyDefaults_MatchesConsul(t *testing.T) {
+	resource := &ProxyDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: "global"},
+		Spec: ProxyDefaultsSpec{
+			Config: map[string]interface{}{
+				"protocol":                 "http",
+				"local_connect_timeout_ms": int64(1000),
+			},
+		},
+	}
+	cases := map[string]struct {
+		entry   api.ConfigEntry
+		matches bool
+	}{
+		"same config": {
+			&api.ProxyConfigEntry{
+				Kind: api.ProxyDefaults,
+				Name: "global",
+				Config: map[string]interface{}{
+					"protocol":                 "http",
+					"local_connect_timeout_ms": float64(1000),
+				},
+				CreateIndex: 1,
+				ModifyIndex: 2,
+			},
+			true,
+		},
+		"different config": {
+			&api.ProxyConfigEntry{
+				Kind:   api.ProxyDefaults,
+				Name:   "global",
+				Config: map[string]interface{}{"protocol": "tcp"},
+			},
+			false,
+		},
+		"different mesh gateway mode": {
+			&api.ProxyConfigEntry{
+				Kind: api.ProxyDefaults,
+				Name: "global",
+				Config: map[string]interface{}{
+					"protocol":                 "http",
+					"local_connect_timeout_ms": float64(1000),
+				},
+				MeshGateway: api.MeshGatewayConfig{Mode: api.MeshGatewayModeLocal},
+			},
+			false,
+		},
+		"different kind": {
+			&api.ServiceConfigEntry{
+				Kind: api.ServiceDefaults,
+				Name: "global",
+			},
+			false,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.matches, resource.MatchesConsul(c.entry))
+		})
+	}
+}
+
+func TestProxyDefaults_Validate(t *testing.T) {
+	cases := map[string]struct {
+		name   string
+		spec   ProxyDefaultsSpec
+		expErr string
+	}{
+		"valid": {
+			name: "global",
+			spec: ProxyDefaultsSpec{MeshGateway: MeshGatewayConfig{Mode: "local"}},
+		},
+		"name is not global": {
+			name:   "foo",
+			expErr: `name must be "global" since there can only be one proxy-defaults config entry, got "foo"`,
+		},
+		"invalid mesh gateway mode": {
+			name:   "global",
+			spec:   ProxyDefaultsSpec{MeshGateway: MeshGatewayConfig{Mode: "foo"}},
+			expErr: `spec.meshGateway.mode must be one of "", "none", "local" or "remote", got "foo"`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &ProxyDefaults{
+				ObjectMeta: metav1.ObjectMeta{Name: c.name},
+				Spec:       c.spec,
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
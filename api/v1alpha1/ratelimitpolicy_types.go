@@ -0,0 +1,100 @@
+package v1alpha1
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RateLimitPolicyResource is the resource name of RateLimitPolicy.
+	RateLimitPolicyResource = "ratelimitpolicies"
+
+	// RateLimitPolicyKind is the kind of RateLimitPolicy.
+	RateLimitPolicyKind = "RateLimitPolicy"
+)
+
+// rateLimitUnits are the units of the rates of RateLimitPolicy in seconds.
+var rateLimitUnits = map[string]int32{
+	"Second": 1,
+	"Minute": 60,
+	"Hour":   3600,
+}
+
+// RateLimitPolicy is the Schema for the ratelimitpolicies API. It's
+// attached to a Gateway or an HTTPRoute of its namespace by its targetRef
+// and limits the rate of the requests the Envoy proxies of the gateways
+// accept, or of the requests matching the paths of the route's rules. It's
+// written to the RateLimits of the service-defaults config entries of the
+// gateways, which requires Consul 1.16 or later.
+type RateLimitPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RateLimitPolicySpec `json:"spec,omitempty"`
+}
+
+// RateLimitPolicySpec defines the desired state of RateLimitPolicy.
+type RateLimitPolicySpec struct {
+	// TargetRef is the Gateway or HTTPRoute the policy is attached to.
+	TargetRef PolicyTargetReference `json:"targetRef"`
+	// RequestsPerUnit is the number of requests accepted per unit. Consul
+	// limits the requests per second, so it must be a whole number of
+	// requests per second.
+	RequestsPerUnit int32 `json:"requestsPerUnit"`
+	// Unit is the unit of RequestsPerUnit: Second, Minute or Hour. It
+	// defaults to Second.
+	Unit string `json:"unit,omitempty"`
+	// Burst is the number of requests accepted at once before they're
+	// limited. It defaults to the requests per second.
+	Burst int32 `json:"burst,omitempty"`
+}
+
+// PolicyTargetReference references the resource a policy is attached to
+// in the namespace of the policy.
+type PolicyTargetReference struct {
+	// Group is the group of the resource, gateway.networking.k8s.io.
+	Group string `json:"group"`
+	// Kind is the kind of the resource, Gateway or HTTPRoute.
+	Kind string `json:"kind"`
+	// Name is the name of the resource.
+	Name string `json:"name"`
+}
+
+// RequestsPerSecond returns the rate of the policy in requests per second.
+func (in *RateLimitPolicy) RequestsPerSecond() int {
+	unit, ok := rateLimitUnits[in.Spec.Unit]
+	if !ok {
+		unit = 1
+	}
+	return int(in.Spec.RequestsPerUnit / unit)
+}
+
+func (in *RateLimitPolicy) Validate() error {
+	ref := in.Spec.TargetRef
+	if ref.Group != "gateway.networking.k8s.io" || (ref.Kind != "Gateway" && ref.Kind != "HTTPRoute") {
+		return fmt.Errorf("spec.targetRef must reference a Gateway or an HTTPRoute of the gateway.networking.k8s.io group")
+	}
+	if ref.Name == "" {
+		return fmt.Errorf("spec.targetRef.name must be set")
+	}
+	unitName := in.Spec.Unit
+	if unitName == "" {
+		unitName = "Second"
+	}
+	unit, ok := rateLimitUnits[unitName]
+	if !ok {
+		return fmt.Errorf("spec.unit must be one of Second, Minute or Hour, got %q", in.Spec.Unit)
+	}
+	if in.Spec.RequestsPerUnit < unit || in.Spec.RequestsPerUnit%unit != 0 {
+		return fmt.Errorf("spec.requestsPerUnit must be a positive multiple of %d for the unit %s, got %d",
+			unit, unitName, in.Spec.RequestsPerUnit)
+	}
+	if in.Spec.Burst < 0 {
+		return fmt.Errorf("spec.burst must be positive, got %d", in.Spec.Burst)
+	}
+	if in.Spec.Burst != 0 && int(in.Spec.Burst) < in.RequestsPerSecond() {
+		return fmt.Errorf("spec.burst must be at least the requests per second (%d), got %d", in.RequestsPerSecond(), in.Spec.Burst)
+	}
+	return nil
+}
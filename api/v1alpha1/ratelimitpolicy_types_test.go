@@ -0,0 +1,82 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitPolicy_RequestsPerSecond(t *testing.T) {
+	cases := map[string]struct {
+		spec RateLimitPolicySpec
+		exp  int
+	}{
+		"default unit": {spec: RateLimitPolicySpec{RequestsPerUnit: 10}, exp: 10},
+		"minute":       {spec: RateLimitPolicySpec{RequestsPerUnit: 600, Unit: "Minute"}, exp: 10},
+		"hour":         {spec: RateLimitPolicySpec{RequestsPerUnit: 7200, Unit: "Hour"}, exp: 2},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			policy := &RateLimitPolicy{Spec: c.spec}
+			require.Equal(t, c.exp, policy.RequestsPerSecond())
+		})
+	}
+}
+
+func TestRateLimitPolicy_Validate(t *testing.T) {
+	gateway := PolicyTargetReference{Group: "gateway.networking.k8s.io", Kind: "Gateway", Name: "gw"}
+	cases := map[string]struct {
+		spec   RateLimitPolicySpec
+		expErr string
+	}{
+		"valid": {
+			spec: RateLimitPolicySpec{TargetRef: gateway, RequestsPerUnit: 100, Burst: 200},
+		},
+		"valid route": {
+			spec: RateLimitPolicySpec{
+				TargetRef:       PolicyTargetReference{Group: "gateway.networking.k8s.io", Kind: "HTTPRoute", Name: "web"},
+				RequestsPerUnit: 120,
+				Unit:            "Minute",
+			},
+		},
+		"target of another kind": {
+			spec:   RateLimitPolicySpec{TargetRef: PolicyTargetReference{Group: "gateway.networking.k8s.io", Kind: "TCPRoute", Name: "db"}, RequestsPerUnit: 1},
+			expErr: "spec.targetRef must reference a Gateway or an HTTPRoute of the gateway.networking.k8s.io group",
+		},
+		"target without name": {
+			spec:   RateLimitPolicySpec{TargetRef: PolicyTargetReference{Group: "gateway.networking.k8s.io", Kind: "Gateway"}, RequestsPerUnit: 1},
+			expErr: "spec.targetRef.name must be set",
+		},
+		"unknown unit": {
+			spec:   RateLimitPolicySpec{TargetRef: gateway, RequestsPerUnit: 1, Unit: "Day"},
+			expErr: `spec.unit must be one of Second, Minute or Hour, got "Day"`,
+		},
+		"no requests": {
+			spec:   RateLimitPolicySpec{TargetRef: gateway},
+			expErr: "spec.requestsPerUnit must be a positive multiple of 1 for the unit Second, got 0",
+		},
+		"fraction of a request per second": {
+			spec:   RateLimitPolicySpec{TargetRef: gateway, RequestsPerUnit: 90, Unit: "Minute"},
+			expErr: "spec.requestsPerUnit must be a positive multiple of 60 for the unit Minute, got 90",
+		},
+		"negative burst": {
+			spec:   RateLimitPolicySpec{TargetRef: gateway, RequestsPerUnit: 1, Burst: -1},
+			expErr: "spec.burst must be positive, got -1",
+		},
+		"burst below the rate": {
+			spec:   RateLimitPolicySpec{TargetRef: gateway, RequestsPerUnit: 100, Burst: 10},
+			expErr: "spec.burst must be at least the requests per second (100), got 10",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := (&RateLimitPolicy{Spec: c.spec}).Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RegistrationResource is the resource name of Registration.
+	RegistrationResource = "registrations"
+
+	// ConditionGatewayLinked is the type of the condition reporting
+	// whether the terminating gateway of a Registration links its
+	// service.
+	ConditionGatewayLinked = "GatewayLinked"
+)
+
+// Registration is the Schema for the registrations API. Its controller
+// registers a service running outside of Kubernetes, e.g. a database on a
+// VM or a SaaS endpoint, in the Consul catalog on the node of its spec. The
+// controller runs the checks of the spec and reports their status in the
+// catalog, since no Consul agent runs on external nodes.
+type Registration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RegistrationSpec   `json:"spec,omitempty"`
+	Status RegistrationStatus `json:"status,omitempty"`
+}
+
+// RegistrationSpec defines the desired state of Registration.
+type RegistrationSpec struct {
+	// Node is the name of the external node the service runs on.
+	Node string `json:"node"`
+	// Address is the address of the node.
+	Address string `json:"address"`
+	// NodeMeta is the metadata of the node.
+	NodeMeta map[string]string `json:"nodeMeta,omitempty"`
+	// Service is the service to register.
+	Service RegistrationService `json:"service"`
+	// Checks are the health checks of the service.
+	Checks []RegistrationCheck `json:"checks,omitempty"`
+	// TerminatingGateway is the name of the terminating gateway that
+	// routes the traffic of the mesh to the service. The controller
+	// reports whether the gateway links the service in the GatewayLinked
+	// condition.
+	TerminatingGateway string `json:"terminatingGateway,omitempty"`
+}
+
+// RegistrationService is the service of a Registration.
+type RegistrationService struct {
+	// Name is the name of the service. It defaults to the resource's
+	// name.
+	Name string `json:"name,omitempty"`
+	// ID is the ID of the service instance. It defaults to the name of
+	// the service.
+	ID string `json:"id,omitempty"`
+	// Namespace is the Consul namespace of the service. Requires Consul
+	// Enterprise.
+	Namespace string `json:"namespace,omitempty"`
+	// Address is the address of the service. It defaults to the address
+	// of the node.
+	Address string `json:"address,omitempty"`
+	// Port is the port of the service.
+	Port int `json:"port,omitempty"`
+	// Tags are the tags of the service.
+	Tags []string `json:"tags,omitempty"`
+	// Meta is the metadata of the service.
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// RegistrationCheck is a health check of a Registration's service, run by
+// the controller. Exactly one of HTTP and TCP must be set.
+type RegistrationCheck struct {
+	// Name is the name of the check.
+	Name string `json:"name"`
+	// HTTP is the URL to send a GET request to. The check passes if the
+	// response status is 2xx, warns if it's 429 and fails otherwise.
+	HTTP string `json:"http,omitempty"`
+	// TCP is the host:port to connect to. The check passes if the
+	// connection succeeds.
+	TCP string `json:"tcp,omitempty"`
+	// Timeout is the timeout of the check. It defaults to 10s.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// RegistrationStatus is the status of Registration.
+type RegistrationStatus struct {
+	Status `json:",inline"`
+	// Node is the node the service was registered on, so that the service
+	// is deregistered from it if the spec moves it.
+	Node string `json:"node,omitempty"`
+	// ServiceID is the ID of the registered service instance.
+	ServiceID string `json:"serviceID,omitempty"`
+	// Checks are the statuses of the service's checks as of the last run.
+	Checks []CheckStatus `json:"checks,omitempty"`
+}
+
+// CheckStatus is the status of a health check.
+type CheckStatus struct {
+	// Name is the name of the check.
+	Name string `json:"name"`
+	// Status is the status of the check: passing, warning or critical.
+	Status string `json:"status"`
+	// Output is the output of the last run of the check.
+	Output string `json:"output,omitempty"`
+}
+
+// ServiceName returns the name of the registered service.
+func (in *Registration) ServiceName() string {
+	if in.Spec.Service.Name != "" {
+		return in.Spec.Service.Name
+	}
+	return in.Name
+}
+
+// ServiceID returns the ID of the registered service instance.
+func (in *Registration) ServiceID() string {
+	if in.Spec.Service.ID != "" {
+		return in.Spec.Service.ID
+	}
+	return in.ServiceName()
+}
+
+// CheckID returns the ID of the check of the service.
+func (in *Registration) CheckID(check RegistrationCheck) string {
+	return in.ServiceID() + ":" + check.Name
+}
+
+func (in *Registration) Validate() error {
+	if in.Spec.Node == "" {
+		return fmt.Errorf("spec.node must be set")
+	}
+	if in.Spec.Address == "" {
+		return fmt.Errorf("spec.address must be set")
+	}
+	if port := in.Spec.Service.Port; port < 0 || port > 65535 {
+		return fmt.Errorf("spec.service.port must be between 0 and 65535, got %d", port)
+	}
+	found := make(map[string]bool)
+	for i, check := range in.Spec.Checks {
+		path := fmt.Sprintf("spec.checks[%d]", i)
+		if check.Name == "" {
+			return fmt.Errorf("%s.name must be set", path)
+		}
+		if found[check.Name] {
+			return fmt.Errorf("%s: check %q is listed more than once", path, check.Name)
+		}
+		found[check.Name] = true
+		if countSet(check.HTTP != "", check.TCP != "") != 1 {
+			return fmt.Errorf("%s must set exactly one of http or tcp", path)
+		}
+		if check.HTTP != "" {
+			if u, err := url.Parse(check.HTTP); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("%s.http must be an http or https URL, got %q", path, check.HTTP)
+			}
+		}
+		if check.TCP != "" {
+			if _, _, err := net.SplitHostPort(check.TCP); err != nil {
+				return fmt.Errorf("%s.tcp must be of the form host:port, got %q", path, check.TCP)
+			}
+		}
+		if check.Timeout.Duration < 0 {
+			return fmt.Errorf("%s.timeout must be positive, got %s", path, check.Timeout.Duration)
+		}
+	}
+	return nil
+}
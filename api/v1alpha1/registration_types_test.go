@@ -0,0 +1,95 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRegistration_Defaults(t *testing.T) {
+	resource := &Registration{ObjectMeta: metav1.ObjectMeta{Name: "db"}}
+	require.Equal(t, "db", resource.ServiceName())
+	require.Equal(t, "db", resource.ServiceID())
+	require.Equal(t, "db:http", resource.CheckID(RegistrationCheck{Name: "http"}))
+
+	resource.Spec.Service = RegistrationService{Name: "postgres", ID: "postgres-1"}
+	require.Equal(t, "postgres", resource.ServiceName())
+	require.Equal(t, "postgres-1", resource.ServiceID())
+}
+
+func TestRegistration_Validate(t *testing.T) {
+	valid := func() RegistrationSpec {
+		return RegistrationSpec{
+			Node:    "vm-1",
+			Address: "10.0.0.1",
+			Service: RegistrationService{Port: 5432},
+			Checks: []RegistrationCheck{
+				{Name: "tcp", TCP: "10.0.0.1:5432"},
+				{Name: "http", HTTP: "http://10.0.0.1:8080/health", Timeout: metav1.Duration{Duration: time.Second}},
+			},
+		}
+	}
+	cases := map[string]struct {
+		modify func(*RegistrationSpec)
+		expErr string
+	}{
+		"valid": {
+			modify: func(*RegistrationSpec) {},
+		},
+		"no node": {
+			modify: func(spec *RegistrationSpec) { spec.Node = "" },
+			expErr: "spec.node must be set",
+		},
+		"no address": {
+			modify: func(spec *RegistrationSpec) { spec.Address = "" },
+			expErr: "spec.address must be set",
+		},
+		"invalid port": {
+			modify: func(spec *RegistrationSpec) { spec.Service.Port = 70000 },
+			expErr: "spec.service.port must be between 0 and 65535, got 70000",
+		},
+		"no check name": {
+			modify: func(spec *RegistrationSpec) { spec.Checks[1].Name = "" },
+			expErr: "spec.checks[1].name must be set",
+		},
+		"duplicate check": {
+			modify: func(spec *RegistrationSpec) { spec.Checks[1].Name = "tcp" },
+			expErr: `spec.checks[1]: check "tcp" is listed more than once`,
+		},
+		"http and tcp": {
+			modify: func(spec *RegistrationSpec) { spec.Checks[0].HTTP = "http://10.0.0.1" },
+			expErr: "spec.checks[0] must set exactly one of http or tcp",
+		},
+		"neither http nor tcp": {
+			modify: func(spec *RegistrationSpec) { spec.Checks[0].TCP = "" },
+			expErr: "spec.checks[0] must set exactly one of http or tcp",
+		},
+		"invalid http": {
+			modify: func(spec *RegistrationSpec) { spec.Checks[1].HTTP = "10.0.0.1:8080" },
+			expErr: `spec.checks[1].http must be an http or https URL, got "10.0.0.1:8080"`,
+		},
+		"invalid tcp": {
+			modify: func(spec *RegistrationSpec) { spec.Checks[0].TCP = "10.0.0.1" },
+			expErr: `spec.checks[0].tcp must be of the form host:port, got "10.0.0.1"`,
+		},
+		"negative timeout": {
+			modify: func(spec *RegistrationSpec) { spec.Checks[1].Timeout.Duration = -time.Second },
+			expErr: "spec.checks[1].timeout must be positive, got -1s",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &Registration{ObjectMeta: metav1.ObjectMeta{Name: "db"}, Spec: valid()}
+			c.modify(&resource.Spec)
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
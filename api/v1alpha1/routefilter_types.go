@@ -0,0 +1,215 @@
+package v1alpha1
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RouteTimeoutFilterResource, RouteRetryFilterResource and
+	// RouteAuthFilterResource are the resource names of the route filters.
+	RouteTimeoutFilterResource = "routetimeoutfilters"
+	RouteRetryFilterResource   = "routeretryfilters"
+	RouteAuthFilterResource    = "routeauthfilters"
+
+	// RouteTimeoutFilterKind, RouteRetryFilterKind and RouteAuthFilterKind
+	// are the kinds of the route filters, used in the extensionRef
+	// filters of HTTPRoutes referencing them.
+	RouteTimeoutFilterKind = "RouteTimeoutFilter"
+	RouteRetryFilterKind   = "RouteRetryFilter"
+	RouteAuthFilterKind    = "RouteAuthFilter"
+)
+
+// retryOnConditions are the conditions RouteRetryFilter can retry
+// requests on. They're the retry policies of Envoy.
+var retryOnConditions = map[string]bool{
+	"5xx":                true,
+	"gateway-error":      true,
+	"reset":              true,
+	"connect-failure":    true,
+	"envoy-ratelimited":  true,
+	"retriable-4xx":      true,
+	"refused-stream":     true,
+	"cancelled":          true,
+	"deadline-exceeded":  true,
+	"internal":           true,
+	"resource-exhausted": true,
+	"unavailable":        true,
+}
+
+// RouteTimeoutFilter is the Schema for the routetimeoutfilters API. It's
+// referenced by the extensionRef filters of the rules of HTTPRoutes in its
+// namespace to set the timeouts of their requests, and is written to the
+// http-route config entries of the routes, which requires Consul 1.16 or
+// later.
+type RouteTimeoutFilter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RouteTimeoutFilterSpec `json:"spec,omitempty"`
+}
+
+// RouteTimeoutFilterSpec defines the desired state of RouteTimeoutFilter.
+type RouteTimeoutFilterSpec struct {
+	// RequestTimeout is the timeout of the requests, from when the request
+	// is received to when the response is sent. It's disabled if it's 0.
+	RequestTimeout metav1.Duration `json:"requestTimeout,omitempty"`
+	// IdleTimeout is how long the requests' connections to the services
+	// can be idle.
+	IdleTimeout metav1.Duration `json:"idleTimeout,omitempty"`
+}
+
+func (in *RouteTimeoutFilter) ToConsul() *HTTPTimeoutFilter {
+	filter := &HTTPTimeoutFilter{}
+	if in.Spec.RequestTimeout.Duration != 0 {
+		filter.RequestTimeout = in.Spec.RequestTimeout.Duration.String()
+	}
+	if in.Spec.IdleTimeout.Duration != 0 {
+		filter.IdleTimeout = in.Spec.IdleTimeout.Duration.String()
+	}
+	return filter
+}
+
+func (in *RouteTimeoutFilter) Validate() error {
+	if in.Spec.RequestTimeout.Duration < 0 {
+		return fmt.Errorf("spec.requestTimeout must be positive, got %s", in.Spec.RequestTimeout.Duration)
+	}
+	if in.Spec.IdleTimeout.Duration < 0 {
+		return fmt.Errorf("spec.idleTimeout must be positive, got %s", in.Spec.IdleTimeout.Duration)
+	}
+	return nil
+}
+
+// RouteRetryFilter is the Schema for the routeretryfilters API. It's
+// referenced by the extensionRef filters of the rules of HTTPRoutes in its
+// namespace to retry their failed requests, and is written to the
+// http-route config entries of the routes, which requires Consul 1.16 or
+// later.
+type RouteRetryFilter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RouteRetryFilterSpec `json:"spec,omitempty"`
+}
+
+// RouteRetryFilterSpec defines the desired state of RouteRetryFilter.
+type RouteRetryFilterSpec struct {
+	// NumRetries is the number of times a request is retried.
+	NumRetries int32 `json:"numRetries,omitempty"`
+	// RetryOn are the conditions the requests are retried on, e.g. "5xx"
+	// or "reset". See the retry policies of Envoy for the conditions.
+	RetryOn []string `json:"retryOn,omitempty"`
+	// RetryOnStatusCodes are the response status codes the requests are
+	// retried on.
+	RetryOnStatusCodes []int32 `json:"retryOnStatusCodes,omitempty"`
+	// RetryOnConnectFailure retries the requests when the connection to
+	// the service fails.
+	RetryOnConnectFailure bool `json:"retryOnConnectFailure,omitempty"`
+}
+
+func (in *RouteRetryFilter) ToConsul() *RetryFilter {
+	filter := &RetryFilter{
+		NumRetries:            uint32(in.Spec.NumRetries),
+		RetryOn:               in.Spec.RetryOn,
+		RetryOnConnectFailure: in.Spec.RetryOnConnectFailure,
+	}
+	for _, code := range in.Spec.RetryOnStatusCodes {
+		filter.RetryOnStatusCodes = append(filter.RetryOnStatusCodes, uint32(code))
+	}
+	return filter
+}
+
+func (in *RouteRetryFilter) Validate() error {
+	if in.Spec.NumRetries < 0 {
+		return fmt.Errorf("spec.numRetries must be positive, got %d", in.Spec.NumRetries)
+	}
+	for i, condition := range in.Spec.RetryOn {
+		if !retryOnConditions[condition] {
+			return fmt.Errorf("spec.retryOn[%d]: %q isn't a supported retry condition", i, condition)
+		}
+	}
+	for i, code := range in.Spec.RetryOnStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("spec.retryOnStatusCodes[%d] must be an HTTP status code, got %d", i, code)
+		}
+	}
+	return nil
+}
+
+// RouteAuthFilter is the Schema for the routeauthfilters API. It's
+// referenced by the extensionRef filters of the rules of HTTPRoutes in its
+// namespace to require the requests to have a JWT of the jwt-provider
+// config entries of its spec, e.g. as written by JWTProvider resources. It's
+// written to the http-route config entries of the routes, which requires
+// Consul Enterprise 1.17 or later.
+type RouteAuthFilter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RouteAuthFilterSpec `json:"spec,omitempty"`
+}
+
+// RouteAuthFilterSpec defines the desired state of RouteAuthFilter.
+type RouteAuthFilterSpec struct {
+	// JWT configures the JWT authentication of the requests.
+	JWT RouteJWTRequirement `json:"jwt"`
+}
+
+// RouteJWTRequirement requires the requests to have a JWT valid for one
+// of its providers.
+type RouteJWTRequirement struct {
+	// Providers are the JWT providers the tokens can be issued by.
+	Providers []RouteJWTProvider `json:"providers"`
+}
+
+// RouteJWTProvider is a JWT provider of a RouteAuthFilter.
+type RouteJWTProvider struct {
+	// Name is the name of the jwt-provider config entry.
+	Name string `json:"name"`
+	// VerifyClaims are the claims the tokens of the provider must have.
+	VerifyClaims []RouteJWTClaimVerification `json:"verifyClaims,omitempty"`
+}
+
+// RouteJWTClaimVerification requires a claim of the tokens to have a
+// value.
+type RouteJWTClaimVerification struct {
+	// Path is the path of the claim in the token's payload, e.g.
+	// ["perms", "role"] for the claim role of the object perms.
+	Path []string `json:"path"`
+	// Value is the value the claim must have.
+	Value string `json:"value"`
+}
+
+func (in *RouteAuthFilter) ToConsul() *JWTFilter {
+	filter := &JWTFilter{}
+	for _, provider := range in.Spec.JWT.Providers {
+		consulProvider := JWTFilterProvider{Name: provider.Name}
+		for _, claim := range provider.VerifyClaims {
+			consulProvider.VerifyClaims = append(consulProvider.VerifyClaims, JWTClaimVerification{
+				Path:  claim.Path,
+				Value: claim.Value,
+			})
+		}
+		filter.Providers = append(filter.Providers, consulProvider)
+	}
+	return filter
+}
+
+func (in *RouteAuthFilter) Validate() error {
+	if len(in.Spec.JWT.Providers) == 0 {
+		return fmt.Errorf("spec.jwt.providers must have at least one provider")
+	}
+	for i, provider := range in.Spec.JWT.Providers {
+		path := fmt.Sprintf("spec.jwt.providers[%d]", i)
+		if provider.Name == "" {
+			return fmt.Errorf("%s.name must be set", path)
+		}
+		for j, claim := range provider.VerifyClaims {
+			if len(claim.Path) == 0 {
+				return fmt.Errorf("%s.verifyClaims[%d].path must be set", path, j)
+			}
+		}
+	}
+	return nil
+}
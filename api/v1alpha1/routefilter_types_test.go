@@ -0,0 +1,92 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRouteFilters_ToConsul(t *testing.T) {
+	timeout := &RouteTimeoutFilter{Spec: RouteTimeoutFilterSpec{
+		RequestTimeout: metav1.Duration{Duration: 10 * time.Second},
+	}}
+	require.Equal(t, &HTTPTimeoutFilter{RequestTimeout: "10s"}, timeout.ToConsul())
+
+	retry := &RouteRetryFilter{Spec: RouteRetryFilterSpec{
+		NumRetries:            3,
+		RetryOn:               []string{"reset"},
+		RetryOnStatusCodes:    []int32{503},
+		RetryOnConnectFailure: true,
+	}}
+	require.Equal(t, &RetryFilter{
+		NumRetries:            3,
+		RetryOn:               []string{"reset"},
+		RetryOnStatusCodes:    []uint32{503},
+		RetryOnConnectFailure: true,
+	}, retry.ToConsul())
+
+	auth := &RouteAuthFilter{Spec: RouteAuthFilterSpec{JWT: RouteJWTRequirement{Providers: []RouteJWTProvider{
+		{Name: "okta", VerifyClaims: []RouteJWTClaimVerification{{Path: []string{"perms", "role"}, Value: "admin"}}},
+		{Name: "auth0"},
+	}}}}
+	require.Equal(t, &JWTFilter{Providers: []JWTFilterProvider{
+		{Name: "okta", VerifyClaims: []JWTClaimVerification{{Path: []string{"perms", "role"}, Value: "admin"}}},
+		{Name: "auth0"},
+	}}, auth.ToConsul())
+}
+
+func TestRouteFilters_Validate(t *testing.T) {
+	cases := map[string]struct {
+		filter interface{ Validate() error }
+		expErr string
+	}{
+		"valid timeout": {
+			filter: &RouteTimeoutFilter{Spec: RouteTimeoutFilterSpec{IdleTimeout: metav1.Duration{Duration: time.Minute}}},
+		},
+		"negative timeout": {
+			filter: &RouteTimeoutFilter{Spec: RouteTimeoutFilterSpec{RequestTimeout: metav1.Duration{Duration: -time.Second}}},
+			expErr: "spec.requestTimeout must be positive, got -1s",
+		},
+		"valid retry": {
+			filter: &RouteRetryFilter{Spec: RouteRetryFilterSpec{RetryOn: []string{"5xx", "reset"}, RetryOnStatusCodes: []int32{429}}},
+		},
+		"unknown retry condition": {
+			filter: &RouteRetryFilter{Spec: RouteRetryFilterSpec{RetryOn: []string{"5xx", "always"}}},
+			expErr: `spec.retryOn[1]: "always" isn't a supported retry condition`,
+		},
+		"invalid status code": {
+			filter: &RouteRetryFilter{Spec: RouteRetryFilterSpec{RetryOnStatusCodes: []int32{5000}}},
+			expErr: "spec.retryOnStatusCodes[0] must be an HTTP status code, got 5000",
+		},
+		"valid auth": {
+			filter: &RouteAuthFilter{Spec: RouteAuthFilterSpec{JWT: RouteJWTRequirement{Providers: []RouteJWTProvider{{Name: "okta"}}}}},
+		},
+		"auth without providers": {
+			filter: &RouteAuthFilter{},
+			expErr: "spec.jwt.providers must have at least one provider",
+		},
+		"auth provider without name": {
+			filter: &RouteAuthFilter{Spec: RouteAuthFilterSpec{JWT: RouteJWTRequirement{Providers: []RouteJWTProvider{{}}}}},
+			expErr: "spec.jwt.providers[0].name must be set",
+		},
+		"claim without path": {
+			filter: &RouteAuthFilter{Spec: RouteAuthFilterSpec{JWT: RouteJWTRequirement{Providers: []RouteJWTProvider{
+				{Name: "okta", VerifyClaims: []RouteJWTClaimVerification{{Value: "admin"}}},
+			}}}},
+			expErr: "spec.jwt.providers[0].verifyClaims[0].path must be set",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := c.filter.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
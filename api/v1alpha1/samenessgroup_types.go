@@ -0,0 +1,123 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SamenessGroupResource is the resource name of SamenessGroup.
+const SamenessGroupResource = "samenessgroups"
+
+// SamenessGroup is the Schema for the samenessgroups API. It's
+// cluster-scoped and reconciled into the sameness-group config entry of its
+// name, which requires Consul 1.16 or later. Services of the same name in
+// the members of the group are treated as the same service, e.g. to fail
+// over between partitions and peers.
+type SamenessGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SamenessGroupSpec `json:"spec,omitempty"`
+	Status Status            `json:"status,omitempty"`
+}
+
+// SamenessGroupSpec defines the desired state of SamenessGroup.
+type SamenessGroupSpec struct {
+	// Partition is the Consul admin partition to write the sameness-group
+	// config entry to. It defaults to the partition of the controller.
+	Partition string `json:"partition,omitempty"`
+	// DefaultForFailover makes the group the failover target of the
+	// services of the partition that don't configure failover. Only one
+	// group of a partition can set it.
+	DefaultForFailover bool `json:"defaultForFailover,omitempty"`
+	// IncludeLocal makes the local partition the first member of the
+	// group.
+	IncludeLocal bool `json:"includeLocal,omitempty"`
+	// Members are the partitions and peers of the group, in failover
+	// order.
+	Members []SamenessGroupMemberSpec `json:"members,omitempty"`
+}
+
+// SamenessGroupMemberSpec is a partition or a peer of a sameness group.
+// Exactly one of its fields must be set.
+type SamenessGroupMemberSpec struct {
+	// Partition is the name of an admin partition of the local datacenter.
+	Partition string `json:"partition,omitempty"`
+	// Peer is the name of a cluster peer.
+	Peer string `json:"peer,omitempty"`
+}
+
+func (in *SamenessGroup) ConsulKind() string {
+	return SamenessGroupKind
+}
+
+func (in *SamenessGroup) ConsulName() string {
+	return in.Name
+}
+
+func (in *SamenessGroup) ResourceStatus() *Status {
+	return &in.Status
+}
+
+// ConsulTarget has no namespace since sameness-group config entries are
+// scoped to a partition.
+func (in *SamenessGroup) ConsulTarget() ConsulTarget {
+	return ConsulTarget{Partition: in.Spec.Partition}
+}
+
+func (in *SamenessGroup) NewConsulEntry() api.ConfigEntry {
+	return &SamenessGroupConfigEntry{}
+}
+
+// ToConsul ignores the namespace since sameness-group config entries are
+// scoped to a partition.
+func (in *SamenessGroup) ToConsul(string) api.ConfigEntry {
+	entry := &SamenessGroupConfigEntry{
+		Kind:               in.ConsulKind(),
+		Name:               in.ConsulName(),
+		DefaultForFailover: in.Spec.DefaultForFailover,
+		IncludeLocal:       in.Spec.IncludeLocal,
+	}
+	for _, member := range in.Spec.Members {
+		entry.Members = append(entry.Members, SamenessGroupMember{
+			Partition: member.Partition,
+			Peer:      member.Peer,
+		})
+	}
+	return entry
+}
+
+func (in *SamenessGroup) MatchesConsul(entry api.ConfigEntry) bool {
+	samenessGroup, ok := entry.(*SamenessGroupConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *samenessGroup
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+	if len(actual.Members) == 0 {
+		actual.Members = nil
+	}
+	return reflect.DeepEqual(in.ToConsul(""), &actual)
+}
+
+func (in *SamenessGroup) Validate() error {
+	if len(in.Spec.Members) == 0 && !in.Spec.IncludeLocal {
+		return fmt.Errorf("spec.members must have at least one member unless spec.includeLocal is set")
+	}
+	found := make(map[SamenessGroupMemberSpec]bool)
+	for i, member := range in.Spec.Members {
+		path := fmt.Sprintf("spec.members[%d]", i)
+		if countSet(member.Partition != "", member.Peer != "") != 1 {
+			return fmt.Errorf("%s must set exactly one of partition or peer", path)
+		}
+		if found[member] {
+			return fmt.Errorf("%s: member %q is listed more than once", path, member.Partition+member.Peer)
+		}
+		found[member] = true
+	}
+	return nil
+}
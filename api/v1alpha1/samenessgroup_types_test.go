@@ -0,0 +1,101 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSamenessGroup_ToConsul(t *testing.T) {
+	resource := &SamenessGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group"},
+		Spec: SamenessGroupSpec{
+			Partition:          "ap1",
+			DefaultForFailover: true,
+			IncludeLocal:       true,
+			Members:            []SamenessGroupMemberSpec{{Partition: "ap2"}, {Peer: "dc2"}},
+		},
+	}
+	require.Equal(t, &SamenessGroupConfigEntry{
+		Kind:               SamenessGroupKind,
+		Name:               "group",
+		DefaultForFailover: true,
+		IncludeLocal:       true,
+		Members:            []SamenessGroupMember{{Partition: "ap2"}, {Peer: "dc2"}},
+	}, resource.ToConsul("ignored"))
+	require.Equal(t, ConsulTarget{Partition: "ap1"}, resource.ConsulTarget())
+}
+
+func TestSamenessGroup_MatchesConsul(t *testing.T) {
+	resource := &SamenessGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group"},
+		Spec:       SamenessGroupSpec{Members: []SamenessGroupMemberSpec{{Peer: "dc2"}}},
+	}
+	require.True(t, resource.MatchesConsul(&SamenessGroupConfigEntry{
+		Kind:        SamenessGroupKind,
+		Name:        "group",
+		Members:     []SamenessGroupMember{{Peer: "dc2"}},
+		ModifyIndex: 10,
+	}))
+	// The order of the members is the failover order.
+	require.False(t, (&SamenessGroup{
+		ObjectMeta: metav1.ObjectMeta{Name: "group"},
+		Spec:       SamenessGroupSpec{Members: []SamenessGroupMemberSpec{{Peer: "dc2"}, {Peer: "dc3"}}},
+	}).MatchesConsul(&SamenessGroupConfigEntry{
+		Kind:    SamenessGroupKind,
+		Name:    "group",
+		Members: []SamenessGroupMember{{Peer: "dc3"}, {Peer: "dc2"}},
+	}))
+	require.False(t, resource.MatchesConsul(&SamenessGroupConfigEntry{Kind: SamenessGroupKind, Name: "group", IncludeLocal: true}))
+	require.False(t, resource.MatchesConsul(&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "group"}))
+}
+
+func TestSamenessGroup_Validate(t *testing.T) {
+	cases := map[string]struct {
+		spec   SamenessGroupSpec
+		expErr string
+	}{
+		"valid": {
+			spec: SamenessGroupSpec{Members: []SamenessGroupMemberSpec{{Partition: "ap1"}, {Peer: "dc2"}}},
+		},
+		"only local": {
+			spec: SamenessGroupSpec{IncludeLocal: true},
+		},
+		"no members": {
+			expErr: "spec.members must have at least one member unless spec.includeLocal is set",
+		},
+		"partition and peer": {
+			spec:   SamenessGroupSpec{Members: []SamenessGroupMemberSpec{{Partition: "ap1", Peer: "dc2"}}},
+			expErr: "spec.members[0] must set exactly one of partition or peer",
+		},
+		"empty member": {
+			spec:   SamenessGroupSpec{Members: []SamenessGroupMemberSpec{{Peer: "dc2"}, {}}},
+			expErr: "spec.members[1] must set exactly one of partition or peer",
+		},
+		"duplicate members": {
+			spec:   SamenessGroupSpec{Members: []SamenessGroupMemberSpec{{Peer: "dc2"}, {Peer: "dc2"}}},
+			expErr: `spec.members[1]: member "dc2" is listed more than once`,
+		},
+		"partition and peer of the same name": {
+			spec: SamenessGroupSpec{Members: []SamenessGroupMemberSpec{{Partition: "dc2"}, {Peer: "dc2"}}},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &SamenessGroup{
+				ObjectMeta: metav1.ObjectMeta{Name: "group"},
+				Spec:       c.spec,
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
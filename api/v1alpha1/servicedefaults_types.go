@@ -0,0 +1,92 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceDefaultsResource is the resource name of ServiceDefaults.
+const ServiceDefaultsResource = "servicedefaults"
+
+// ServiceDefaults is the Schema for the servicedefaults API. It is
+// reconciled into the service-defaults config entry of the service with
+// the resource's name.
+type ServiceDefaults struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ServiceDefaultsSpec `json:"spec,omitempty"`
+	Status Status              `json:"status,omitempty"`
+}
+
+// ServiceDefaultsSpec defines the desired state of ServiceDefaults.
+type ServiceDefaultsSpec struct {
+	ConsulTarget `json:",inline"`
+
+	// Protocol sets the protocol of the service. This is used by Connect
+	// proxies for things like observability features and to unlock usage
+	// of the service-splitter and service-router config entries.
+	Protocol string `json:"protocol,omitempty"`
+	// MeshGateway controls the default mesh gateway configuration for
+	// this service.
+	MeshGateway MeshGatewayConfig `json:"meshGateway,omitempty"`
+	// Expose controls the default expose path configuration for Envoy.
+	Expose ExposeConfig `json:"expose,omitempty"`
+}
+
+func (in *ServiceDefaults) ConsulKind() string {
+	return api.ServiceDefaults
+}
+
+func (in *ServiceDefaults) ConsulName() string {
+	return in.Name
+}
+
+func (in *ServiceDefaults) ResourceStatus() *Status {
+	return &in.Status
+}
+
+func (in *ServiceDefaults) ConsulTarget() ConsulTarget {
+	return in.Spec.ConsulTarget
+}
+
+func (in *ServiceDefaults) ToConsul(namespace string) api.ConfigEntry {
+	return &api.ServiceConfigEntry{
+		Kind:        in.ConsulKind(),
+		Name:        in.ConsulName(),
+		Namespace:   namespace,
+		Protocol:    in.Spec.Protocol,
+		MeshGateway: in.Spec.MeshGateway.toConsul(),
+		Expose:      in.Spec.Expose.toConsul(),
+	}
+}
+
+func (in *ServiceDefaults) MatchesConsul(entry api.ConfigEntry) bool {
+	serviceDefaults, ok := entry.(*api.ServiceConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *serviceDefaults
+	actual.Namespace = ""
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+	return reflect.DeepEqual(in.ToConsul(""), &actual)
+}
+
+func (in *ServiceDefaults) Validate() error {
+	switch in.Spec.Protocol {
+	case "", "tcp", "http", "http2", "grpc":
+	default:
+		return fmt.Errorf("spec.protocol must be one of \"tcp\", \"http\", \"http2\" or \"grpc\", got %q", in.Spec.Protocol)
+	}
+	if err := in.Spec.MeshGateway.validate(); err != nil {
+		return fmt.Errorf("spec.%s", err)
+	}
+	if err := in.Spec.Expose.validate(); err != nil {
+		return fmt.Errorf("spec.%s", err)
+	}
+	return nil
+}
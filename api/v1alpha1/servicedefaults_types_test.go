@@ -0,0 +1,171 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServiceDefaults_ToConsul(t *testing.T) {
+	cases := map[string]struct {
+		input    *ServiceDefaults
+		expected *api.ServiceConfigEntry
+	}{
+		"empty fields": {
+			&ServiceDefaults{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+			},
+			&api.ServiceConfigEntry{
+				Kind: api.ServiceDefaults,
+				Name: "foo",
+			},
+		},
+		"every field set": {
+			&ServiceDefaults{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec: ServiceDefaultsSpec{
+					Protocol: "http",
+					MeshGateway: MeshGatewayConfig{
+						Mode: "local",
+					},
+					Expose: ExposeConfig{
+						Checks: true,
+						Paths: []ExposePath{
+							{
+								ListenerPort:  21500,
+								Path:          "/metrics",
+								LocalPathPort: 9090,
+								Protocol:      "http",
+							},
+						},
+					},
+				},
+			},
+			&api.ServiceConfigEntry{
+				Kind:      api.ServiceDefaults,
+				Name:      "foo",
+				Namespace: "ns",
+				Protocol:  "http",
+				MeshGateway: api.MeshGatewayConfig{
+					Mode: api.MeshGatewayModeLocal,
+				},
+				Expose: api.ExposeConfig{
+					Checks: true,
+					Paths: []api.ExposePath{
+						{
+							ListenerPort:  21500,
+							Path:          "/metrics",
+							LocalPathPort: 9090,
+							Protocol:      "http",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			namespace := c.expected.Namespace
+			require.Equal(t, c.expected, c.input.ToConsul(namespace))
+		})
+	}
+}
+
+func TestServiceDefaults_MatchesConsul(t *testing.T) {
+	resource := &ServiceDefaults{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec:       ServiceDefaultsSpec{Protocol: "http"},
+	}
+	cases := map[string]struct {
+		entry   api.ConfigEntry
+		matches bool
+	}{
+		"same config": {
+			&api.ServiceConfigEntry{
+				Kind:        api.ServiceDefaults,
+				Name:        "foo",
+				Namespace:   "ns",
+				Protocol:    "http",
+				CreateIndex: 1,
+				ModifyIndex: 2,
+			},
+			true,
+		},
+		"different protocol": {
+			&api.ServiceConfigEntry{
+				Kind:     api.ServiceDefaults,
+				Name:     "foo",
+				Protocol: "tcp",
+			},
+			false,
+		},
+		"different kind": {
+			&api.ProxyConfigEntry{
+				Kind: api.ProxyDefaults,
+				Name: "foo",
+			},
+			false,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.matches, resource.MatchesConsul(c.entry))
+		})
+	}
+}
+
+func TestServiceDefaults_Validate(t *testing.T) {
+	cases := map[string]struct {
+		spec   ServiceDefaultsSpec
+		expErr string
+	}{
+		"valid": {
+			spec: ServiceDefaultsSpec{
+				Protocol:    "grpc",
+				MeshGateway: MeshGatewayConfig{Mode: "remote"},
+				Expose: ExposeConfig{
+					Paths: []ExposePath{{Path: "/health", Protocol: "http2"}},
+				},
+			},
+		},
+		"invalid protocol": {
+			spec:   ServiceDefaultsSpec{Protocol: "udp"},
+			expErr: `spec.protocol must be one of "tcp", "http", "http2" or "grpc", got "udp"`,
+		},
+		"invalid mesh gateway mode": {
+			spec:   ServiceDefaultsSpec{MeshGateway: MeshGatewayConfig{Mode: "foo"}},
+			expErr: `spec.meshGateway.mode must be one of "", "none", "local" or "remote", got "foo"`,
+		},
+		"invalid expose path": {
+			spec: ServiceDefaultsSpec{
+				Expose: ExposeConfig{Paths: []ExposePath{{Path: "health"}}},
+			},
+			expErr: `spec.expose.paths[0].path must begin with '/', got "health"`,
+		},
+		"invalid expose protocol": {
+			spec: ServiceDefaultsSpec{
+				Expose: ExposeConfig{Paths: []ExposePath{{Path: "/health", Protocol: "tcp"}}},
+			},
+			expErr: `spec.expose.paths[0].protocol must be one of "http" or "http2", got "tcp"`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &ServiceDefaults{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec:       c.spec,
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, c.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceIntentionsResource is the resource name of ServiceIntentions.
+const ServiceIntentionsResource = "serviceintentions"
+
+const (
+	// Actions of intentions and permissions.
+	IntentionActionAllow = "allow"
+	IntentionActionDeny  = "deny"
+)
+
+// intentionMethods are the HTTP methods that permissions can match.
+var intentionMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// ServiceIntentions is the Schema for the serviceintentions API. It is
+// reconciled into the service-intentions config entry of its destination
+// service, which requires Consul 1.9 or later.
+type ServiceIntentions struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ServiceIntentionsSpec `json:"spec,omitempty"`
+	Status Status                `json:"status,omitempty"`
+}
+
+// ServiceIntentionsSpec defines the desired state of ServiceIntentions.
+type ServiceIntentionsSpec struct {
+	ConsulTarget `json:",inline"`
+
+	// Destination is the service the intentions apply to.
+	Destination Destination `json:"destination,omitempty"`
+	// Sources is the list of all intention sources and the authorization
+	// granted to those sources. The order of this list does not matter,
+	// but the same source can't be listed more than once.
+	Sources []SourceIntentionSpec `json:"sources,omitempty"`
+}
+
+// Destination is the destination of intentions.
+type Destination struct {
+	// Name is the destination service of all the intentions. It can be
+	// set to "*" to match all services.
+	Name string `json:"name,omitempty"`
+}
+
+// SourceIntentionSpec is the intention of a source service.
+type SourceIntentionSpec struct {
+	// Name is the source of the intention. It can be set to "*" to match
+	// all services.
+	Name string `json:"name,omitempty"`
+	// Namespace is the Consul namespace of the source service.
+	Namespace string `json:"namespace,omitempty"`
+	// Action is required for an L4 intention, and should be set to one of
+	// "allow" or "deny" for the action that should be taken if this
+	// intention matches a request.
+	Action string `json:"action,omitempty"`
+	// Permissions is the list of all additional L7 attributes that
+	// extend the intention match criteria. Permission precedence is
+	// applied top to bottom. It can't be set with action.
+	Permissions []IntentionPermissionSpec `json:"permissions,omitempty"`
+	// Description for the intention.
+	Description string `json:"description,omitempty"`
+}
+
+// IntentionPermissionSpec is an L7 permission of an intention.
+type IntentionPermissionSpec struct {
+	// Action is one of "allow" or "deny" for the action that should be
+	// taken if this permission matches a request.
+	Action string `json:"action,omitempty"`
+	// HTTP is a set of HTTP-specific authorization criteria.
+	HTTP *IntentionHTTPPermissionSpec `json:"http,omitempty"`
+}
+
+// IntentionHTTPPermissionSpec is the HTTP criteria of a permission.
+type IntentionHTTPPermissionSpec struct {
+	// PathExact is the exact path to match on the HTTP request path.
+	PathExact string `json:"pathExact,omitempty"`
+	// PathPrefix is the path prefix to match on the HTTP request path.
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	// PathRegex is the regular expression to match on the HTTP request path.
+	PathRegex string `json:"pathRegex,omitempty"`
+	// Header is a set of criteria that can match on HTTP request headers.
+	// If more than one is configured all must match for the overall
+	// match to apply.
+	Header []IntentionHTTPHeaderPermissionSpec `json:"header,omitempty"`
+	// Methods is a list of HTTP methods for which this match applies. If
+	// unspecified all HTTP methods are matched.
+	Methods []string `json:"methods,omitempty"`
+}
+
+// IntentionHTTPHeaderPermissionSpec matches an HTTP request header.
+type IntentionHTTPHeaderPermissionSpec struct {
+	// Name is the name of the header to match.
+	Name string `json:"name"`
+	// Present matches if the header is present with any value.
+	Present bool `json:"present,omitempty"`
+	// Exact matches if the header is set to this value.
+	Exact string `json:"exact,omitempty"`
+	// Prefix matches if the header's value starts with this.
+	Prefix string `json:"prefix,omitempty"`
+	// Suffix matches if the header's value ends with this.
+	Suffix string `json:"suffix,omitempty"`
+	// Regex matches the header's value with a regular expression.
+	Regex string `json:"regex,omitempty"`
+	// Invert inverts the logic of the match.
+	Invert bool `json:"invert,omitempty"`
+}
+
+func (in *ServiceIntentions) ConsulKind() string {
+	return ServiceIntentionsKind
+}
+
+// ConsulName returns the destination service since the resource's name
+// can't be "*".
+func (in *ServiceIntentions) ConsulName() string {
+	return in.Spec.Destination.Name
+}
+
+func (in *ServiceIntentions) ResourceStatus() *Status {
+	return &in.Status
+}
+
+func (in *ServiceIntentions) ConsulTarget() ConsulTarget {
+	return in.Spec.ConsulTarget
+}
+
+func (in *ServiceIntentions) NewConsulEntry() api.ConfigEntry {
+	return &ServiceIntentionsConfigEntry{}
+}
+
+func (in *ServiceIntentions) ToConsul(namespace string) api.ConfigEntry {
+	entry := &ServiceIntentionsConfigEntry{
+		Kind:      in.ConsulKind(),
+		Name:      in.ConsulName(),
+		Namespace: namespace,
+	}
+	for _, source := range in.Spec.Sources {
+		consulSource := &SourceIntention{
+			Name:        source.Name,
+			Namespace:   source.Namespace,
+			Action:      source.Action,
+			Description: source.Description,
+		}
+		for _, permission := range source.Permissions {
+			consulPermission := &IntentionPermission{Action: permission.Action}
+			if http := permission.HTTP; http != nil {
+				consulPermission.HTTP = &IntentionHTTPPermission{
+					PathExact:  http.PathExact,
+					PathPrefix: http.PathPrefix,
+					PathRegex:  http.PathRegex,
+					Methods:    http.Methods,
+				}
+				for _, header := range http.Header {
+					consulPermission.HTTP.Header = append(consulPermission.HTTP.Header, IntentionHTTPHeaderPermission{
+						Name:    header.Name,
+						Present: header.Present,
+						Exact:   header.Exact,
+						Prefix:  header.Prefix,
+						Suffix:  header.Suffix,
+						Regex:   header.Regex,
+						Invert:  header.Invert,
+					})
+				}
+			}
+			consulSource.Permissions = append(consulSource.Permissions, consulPermission)
+		}
+		entry.Sources = append(entry.Sources, consulSource)
+	}
+	return entry
+}
+
+func (in *ServiceIntentions) MatchesConsul(entry api.ConfigEntry) bool {
+	serviceIntentions, ok := entry.(*ServiceIntentionsConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *serviceIntentions
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+	actual.Sources = nil
+	for _, source := range serviceIntentions.Sources {
+		source := *source
+		actual.Sources = append(actual.Sources, &source)
+	}
+
+	// Consul sorts the sources by precedence and, in Enterprise, defaults
+	// their namespace to the entry's namespace.
+	expected := in.ToConsul(actual.Namespace).(*ServiceIntentionsConfigEntry)
+	for _, source := range expected.Sources {
+		if source.Namespace == "" {
+			source.Namespace = actual.Namespace
+		}
+	}
+	sortSources(expected.Sources)
+	sortSources(actual.Sources)
+	return reflect.DeepEqual(expected, &actual)
+}
+
+func (in *ServiceIntentions) Validate() error {
+	if in.Spec.Destination.Name == "" {
+		return fmt.Errorf("spec.destination.name must be set")
+	}
+
+	type sourceKey struct {
+		name, namespace string
+	}
+	found := make(map[sourceKey]bool)
+	for i, source := range in.Spec.Sources {
+		path := fmt.Sprintf("spec.sources[%d]", i)
+		if source.Name == "" {
+			return fmt.Errorf("%s.name must be set", path)
+		}
+		key := sourceKey{source.Name, source.Namespace}
+		if found[key] {
+			return fmt.Errorf("%s: source %q is listed more than once", path, source.Name)
+		}
+		found[key] = true
+
+		if source.Action != "" && len(source.Permissions) > 0 {
+			return fmt.Errorf("%s cannot set both action and permissions", path)
+		}
+		if source.Action == "" && len(source.Permissions) == 0 {
+			return fmt.Errorf("%s must set either action or permissions", path)
+		}
+		if source.Action != "" {
+			if err := validateIntentionAction(source.Action); err != nil {
+				return fmt.Errorf("%s.action %s", path, err)
+			}
+		}
+		for j, permission := range source.Permissions {
+			if err := permission.validate(fmt.Sprintf("%s.permissions[%d]", path, j)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (in IntentionPermissionSpec) validate(path string) error {
+	if err := validateIntentionAction(in.Action); err != nil {
+		return fmt.Errorf("%s.action %s", path, err)
+	}
+	http := in.HTTP
+	if http == nil {
+		return fmt.Errorf("%s.http must be set", path)
+	}
+	pathParts := 0
+	if http.PathExact != "" {
+		pathParts++
+		if !strings.HasPrefix(http.PathExact, "/") {
+			return fmt.Errorf("%s.http.pathExact must begin with '/', got %q", path, http.PathExact)
+		}
+	}
+	if http.PathPrefix != "" {
+		pathParts++
+		if !strings.HasPrefix(http.PathPrefix, "/") {
+			return fmt.Errorf("%s.http.pathPrefix must begin with '/', got %q", path, http.PathPrefix)
+		}
+	}
+	if http.PathRegex != "" {
+		pathParts++
+	}
+	if pathParts > 1 {
+		return fmt.Errorf("%s.http must only set one of pathExact, pathPrefix or pathRegex", path)
+	}
+	if pathParts == 0 && len(http.Header) == 0 && len(http.Methods) == 0 {
+		return fmt.Errorf("%s.http must set at least one of pathExact, pathPrefix, pathRegex, header or methods", path)
+	}
+
+	for k, header := range http.Header {
+		if header.Name == "" {
+			return fmt.Errorf("%s.http.header[%d].name must be set", path, k)
+		}
+		if countSet(header.Present, header.Exact != "", header.Prefix != "", header.Suffix != "", header.Regex != "") > 1 {
+			return fmt.Errorf("%s.http.header[%d] must only set one of present, exact, prefix, suffix or regex", path, k)
+		}
+	}
+	found := make(map[string]bool)
+	for _, method := range http.Methods {
+		if !intentionMethods[method] {
+			return fmt.Errorf("%s.http.methods contains invalid method %q", path, method)
+		}
+		if found[method] {
+			return fmt.Errorf("%s.http.methods contains %q more than once", path, method)
+		}
+		found[method] = true
+	}
+	return nil
+}
+
+func validateIntentionAction(action string) error {
+	if action != IntentionActionAllow && action != IntentionActionDeny {
+		return fmt.Errorf("must be %q or %q, got %q", IntentionActionAllow, IntentionActionDeny, action)
+	}
+	return nil
+}
+
+// sortSources sorts the sources by namespace and name.
+func sortSources(sources []*SourceIntention) {
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Namespace != sources[j].Namespace {
+			return sources[i].Namespace < sources[j].Namespace
+		}
+		return sources[i].Name < sources[j].Name
+	})
+}
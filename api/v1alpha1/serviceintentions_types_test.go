@@ -0,0 +1,213 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServiceIntentions_ToConsul(t *testing.T) {
+	resource := &ServiceIntentions{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-intentions"},
+		Spec: ServiceIntentionsSpec{
+			Destination: Destination{Name: "web"},
+			Sources: []SourceIntentionSpec{
+				{Name: "db", Action: "deny", Description: "no callbacks"},
+				{
+					Name:      "api",
+					Namespace: "ns",
+					Permissions: []IntentionPermissionSpec{{
+						Action: "allow",
+						HTTP: &IntentionHTTPPermissionSpec{
+							PathPrefix: "/v1",
+							Header:     []IntentionHTTPHeaderPermissionSpec{{Name: "x-debug", Present: true, Invert: true}},
+							Methods:    []string{"GET"},
+						},
+					}},
+				},
+			},
+		},
+	}
+	require.Equal(t, &ServiceIntentionsConfigEntry{
+		Kind:      ServiceIntentionsKind,
+		Name:      "web",
+		Namespace: "consul-ns",
+		Sources: []*SourceIntention{
+			{Name: "db", Action: "deny", Description: "no callbacks"},
+			{
+				Name:      "api",
+				Namespace: "ns",
+				Permissions: []*IntentionPermission{{
+					Action: "allow",
+					HTTP: &IntentionHTTPPermission{
+						PathPrefix: "/v1",
+						Header:     []IntentionHTTPHeaderPermission{{Name: "x-debug", Present: true, Invert: true}},
+						Methods:    []string{"GET"},
+					},
+				}},
+			},
+		},
+	}, resource.ToConsul("consul-ns"))
+}
+
+func TestServiceIntentions_MatchesConsul(t *testing.T) {
+	resource := &ServiceIntentions{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-intentions"},
+		Spec: ServiceIntentionsSpec{
+			Destination: Destination{Name: "web"},
+			Sources: []SourceIntentionSpec{
+				{Name: "db", Action: "deny"},
+				{Name: "api", Namespace: "other", Action: "allow"},
+			},
+		},
+	}
+	// Consul sorts the sources and, in Enterprise, defaults their
+	// namespace.
+	require.True(t, resource.MatchesConsul(&ServiceIntentionsConfigEntry{
+		Kind:      ServiceIntentionsKind,
+		Name:      "web",
+		Namespace: "ns",
+		Sources: []*SourceIntention{
+			{Name: "api", Namespace: "other", Action: "allow"},
+			{Name: "db", Namespace: "ns", Action: "deny"},
+		},
+		ModifyIndex: 10,
+	}))
+	require.False(t, resource.MatchesConsul(&ServiceIntentionsConfigEntry{
+		Kind: ServiceIntentionsKind,
+		Name: "web",
+		Sources: []*SourceIntention{
+			{Name: "api", Namespace: "other", Action: "allow"},
+			{Name: "db", Action: "allow"},
+		},
+	}))
+	require.False(t, resource.MatchesConsul(&ServiceIntentionsConfigEntry{Kind: ServiceIntentionsKind, Name: "web"}))
+	require.False(t, resource.MatchesConsul(&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "web"}))
+}
+
+func TestServiceIntentions_Validate(t *testing.T) {
+	allowGet := IntentionPermissionSpec{Action: "allow", HTTP: &IntentionHTTPPermissionSpec{Methods: []string{"GET"}}}
+	cases := map[string]struct {
+		spec   ServiceIntentionsSpec
+		expErr string
+	}{
+		"valid": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "*"},
+				Sources: []SourceIntentionSpec{
+					{Name: "db", Action: "deny"},
+					{Name: "db", Namespace: "other", Action: "allow"},
+					{Name: "api", Permissions: []IntentionPermissionSpec{
+						allowGet,
+						{Action: "deny", HTTP: &IntentionHTTPPermissionSpec{
+							PathExact: "/admin",
+							Header:    []IntentionHTTPHeaderPermissionSpec{{Name: "x-admin"}},
+						}},
+					}},
+				},
+			},
+		},
+		"no destination": {
+			spec:   ServiceIntentionsSpec{Sources: []SourceIntentionSpec{{Name: "db", Action: "allow"}}},
+			expErr: "spec.destination.name must be set",
+		},
+		"source without name": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "web"},
+				Sources:     []SourceIntentionSpec{{Action: "allow"}},
+			},
+			expErr: "spec.sources[0].name must be set",
+		},
+		"duplicate source": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "web"},
+				Sources:     []SourceIntentionSpec{{Name: "db", Action: "allow"}, {Name: "db", Action: "deny"}},
+			},
+			expErr: `spec.sources[1]: source "db" is listed more than once`,
+		},
+		"action and permissions": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "web"},
+				Sources:     []SourceIntentionSpec{{Name: "db", Action: "allow", Permissions: []IntentionPermissionSpec{allowGet}}},
+			},
+			expErr: "spec.sources[0] cannot set both action and permissions",
+		},
+		"neither action nor permissions": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "web"},
+				Sources:     []SourceIntentionSpec{{Name: "db"}},
+			},
+			expErr: "spec.sources[0] must set either action or permissions",
+		},
+		"invalid action": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "web"},
+				Sources:     []SourceIntentionSpec{{Name: "db", Action: "block"}},
+			},
+			expErr: `spec.sources[0].action must be "allow" or "deny", got "block"`,
+		},
+		"permission without http": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "web"},
+				Sources:     []SourceIntentionSpec{{Name: "db", Permissions: []IntentionPermissionSpec{{Action: "allow"}}}},
+			},
+			expErr: "spec.sources[0].permissions[0].http must be set",
+		},
+		"empty http permission": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "web"},
+				Sources: []SourceIntentionSpec{{Name: "db", Permissions: []IntentionPermissionSpec{
+					{Action: "allow", HTTP: &IntentionHTTPPermissionSpec{}},
+				}}},
+			},
+			expErr: "spec.sources[0].permissions[0].http must set at least one of pathExact, pathPrefix, pathRegex, header or methods",
+		},
+		"multiple paths": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "web"},
+				Sources: []SourceIntentionSpec{{Name: "db", Permissions: []IntentionPermissionSpec{
+					{Action: "allow", HTTP: &IntentionHTTPPermissionSpec{PathExact: "/a", PathPrefix: "/b"}},
+				}}},
+			},
+			expErr: "spec.sources[0].permissions[0].http must only set one of pathExact, pathPrefix or pathRegex",
+		},
+		"header with multiple criteria": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "web"},
+				Sources: []SourceIntentionSpec{{Name: "db", Permissions: []IntentionPermissionSpec{
+					{Action: "allow", HTTP: &IntentionHTTPPermissionSpec{
+						Header: []IntentionHTTPHeaderPermissionSpec{{Name: "x", Exact: "1", Regex: "1.*"}},
+					}},
+				}}},
+			},
+			expErr: "spec.sources[0].permissions[0].http.header[0] must only set one of present, exact, prefix, suffix or regex",
+		},
+		"invalid method": {
+			spec: ServiceIntentionsSpec{
+				Destination: Destination{Name: "web"},
+				Sources: []SourceIntentionSpec{{Name: "db", Permissions: []IntentionPermissionSpec{
+					{Action: "allow", HTTP: &IntentionHTTPPermissionSpec{Methods: []string{"get"}}},
+				}}},
+			},
+			expErr: `spec.sources[0].permissions[0].http.methods contains invalid method "get"`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &ServiceIntentions{
+				ObjectMeta: metav1.ObjectMeta{Name: "web-intentions"},
+				Spec:       c.spec,
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
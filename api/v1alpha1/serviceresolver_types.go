@@ -0,0 +1,256 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-bexpr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceResolverResource is the resource name of ServiceResolver.
+const ServiceResolverResource = "serviceresolvers"
+
+// validSubsetName matches the subset names Consul accepts.
+var validSubsetName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// ServiceResolver is the Schema for the serviceresolvers API. It is
+// reconciled into the service-resolver config entry of the service with
+// the resource's name.
+type ServiceResolver struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ServiceResolverSpec `json:"spec,omitempty"`
+	Status Status              `json:"status,omitempty"`
+}
+
+// ServiceResolverSpec defines the desired state of ServiceResolver.
+type ServiceResolverSpec struct {
+	ConsulTarget `json:",inline"`
+
+	// DefaultSubset is the subset to use when no explicit subset is
+	// requested. If empty, the unnamed subset is used.
+	DefaultSubset string `json:"defaultSubset,omitempty"`
+	// Subsets is a map of subset name to subset definition for all
+	// usable named subsets of this service.
+	Subsets map[string]ServiceResolverSubset `json:"subsets,omitempty"`
+	// Redirect, when configured, redirects all traffic for this service
+	// to another service, subset or datacenter. It can't be combined
+	// with any other field.
+	Redirect *ServiceResolverRedirect `json:"redirect,omitempty"`
+	// Failover controls when and how to reroute traffic to an alternate
+	// pool of service instances. The map is keyed by the service subset
+	// it applies to and the special name "*" applies to all subsets.
+	Failover map[string]ServiceResolverFailover `json:"failover,omitempty"`
+	// ConnectTimeout is the timeout for establishing new network
+	// connections to this service.
+	ConnectTimeout metav1.Duration `json:"connectTimeout,omitempty"`
+}
+
+// ServiceResolverSubset defines a subset of service instances.
+type ServiceResolverSubset struct {
+	// Filter is the filter expression to select service instances for
+	// the subset, e.g. "Service.Meta.version == v1".
+	Filter string `json:"filter,omitempty"`
+	// OnlyPassing specifies the behavior of the resolver's health check
+	// filtering. If true, only instances with all passing checks are
+	// selected.
+	OnlyPassing bool `json:"onlyPassing,omitempty"`
+}
+
+// ServiceResolverRedirect redirects traffic to another service, subset
+// or datacenter.
+type ServiceResolverRedirect struct {
+	// Service is a service to resolve instead of the current service.
+	Service string `json:"service,omitempty"`
+	// ServiceSubset is a named subset of the given service to resolve.
+	ServiceSubset string `json:"serviceSubset,omitempty"`
+	// Namespace is the namespace to resolve the service from.
+	Namespace string `json:"namespace,omitempty"`
+	// Datacenter is the datacenter to resolve the service from.
+	Datacenter string `json:"datacenter,omitempty"`
+}
+
+// ServiceResolverFailover defines the failover targets of a subset.
+type ServiceResolverFailover struct {
+	// Service is the service to resolve instead of the default as the
+	// failover group of instances during failover.
+	Service string `json:"service,omitempty"`
+	// ServiceSubset is the named subset of the requested service to
+	// resolve as the failover group of instances.
+	ServiceSubset string `json:"serviceSubset,omitempty"`
+	// Namespace is the namespace to resolve the requested service from
+	// to form the failover group of instances.
+	Namespace string `json:"namespace,omitempty"`
+	// Datacenters is a fixed list of datacenters to try during failover.
+	Datacenters []string `json:"datacenters,omitempty"`
+}
+
+func (in *ServiceResolver) ConsulKind() string {
+	return api.ServiceResolver
+}
+
+func (in *ServiceResolver) ConsulName() string {
+	return in.Name
+}
+
+func (in *ServiceResolver) ResourceStatus() *Status {
+	return &in.Status
+}
+
+func (in *ServiceResolver) ConsulTarget() ConsulTarget {
+	return in.Spec.ConsulTarget
+}
+
+func (in *ServiceResolver) ToConsul(namespace string) api.ConfigEntry {
+	entry := &api.ServiceResolverConfigEntry{
+		Kind:           in.ConsulKind(),
+		Name:           in.ConsulName(),
+		Namespace:      namespace,
+		DefaultSubset:  in.Spec.DefaultSubset,
+		ConnectTimeout: in.Spec.ConnectTimeout.Duration,
+	}
+	if len(in.Spec.Subsets) > 0 {
+		entry.Subsets = make(map[string]api.ServiceResolverSubset)
+		for name, subset := range in.Spec.Subsets {
+			entry.Subsets[name] = api.ServiceResolverSubset{
+				Filter:      subset.Filter,
+				OnlyPassing: subset.OnlyPassing,
+			}
+		}
+	}
+	if redirect := in.Spec.Redirect; redirect != nil {
+		entry.Redirect = &api.ServiceResolverRedirect{
+			Service:       redirect.Service,
+			ServiceSubset: redirect.ServiceSubset,
+			Namespace:     redirect.Namespace,
+			Datacenter:    redirect.Datacenter,
+		}
+	}
+	if len(in.Spec.Failover) > 0 {
+		entry.Failover = make(map[string]api.ServiceResolverFailover)
+		for name, failover := range in.Spec.Failover {
+			entry.Failover[name] = api.ServiceResolverFailover{
+				Service:       failover.Service,
+				ServiceSubset: failover.ServiceSubset,
+				Namespace:     failover.Namespace,
+				Datacenters:   failover.Datacenters,
+			}
+		}
+	}
+	return entry
+}
+
+func (in *ServiceResolver) MatchesConsul(entry api.ConfigEntry) bool {
+	serviceResolver, ok := entry.(*api.ServiceResolverConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *serviceResolver
+	actual.Namespace = ""
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+	return reflect.DeepEqual(in.ToConsul(""), &actual)
+}
+
+// SubsetRefs returns the subsets of other services that the redirect and
+// the failovers reference. The subsets of the resolver's own service are
+// checked by Validate.
+func (in *ServiceResolver) SubsetRefs() []SubsetRef {
+	var refs []SubsetRef
+	if redirect := in.Spec.Redirect; redirect != nil && redirect.ServiceSubset != "" {
+		refs = append(refs, SubsetRef{
+			Path:      "spec.redirect",
+			Service:   redirect.Service,
+			Namespace: redirect.Namespace,
+			Subset:    redirect.ServiceSubset,
+		})
+	}
+	names := make([]string, 0, len(in.Spec.Failover))
+	for name := range in.Spec.Failover {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		failover := in.Spec.Failover[name]
+		if failover.Service != "" && failover.ServiceSubset != "" {
+			refs = append(refs, SubsetRef{
+				Path:      fmt.Sprintf("spec.failover[%s]", name),
+				Service:   failover.Service,
+				Namespace: failover.Namespace,
+				Subset:    failover.ServiceSubset,
+			})
+		}
+	}
+	return refs
+}
+
+func (in *ServiceResolver) Validate() error {
+	spec := in.Spec
+	if spec.Redirect != nil {
+		if spec.DefaultSubset != "" || len(spec.Subsets) > 0 || len(spec.Failover) > 0 {
+			return fmt.Errorf("spec.redirect cannot be set with spec.defaultSubset, spec.subsets or spec.failover")
+		}
+		if *spec.Redirect == (ServiceResolverRedirect{}) {
+			return fmt.Errorf("spec.redirect must set at least one of service, serviceSubset, namespace or datacenter")
+		}
+		if spec.Redirect.ServiceSubset != "" && spec.Redirect.Service == "" {
+			return fmt.Errorf("spec.redirect.serviceSubset cannot be set without spec.redirect.service")
+		}
+	}
+
+	// Iterate in a stable order so that the same error is reported
+	// for the same resource.
+	subsetNames := make([]string, 0, len(spec.Subsets))
+	for name := range spec.Subsets {
+		subsetNames = append(subsetNames, name)
+	}
+	sort.Strings(subsetNames)
+	for _, name := range subsetNames {
+		if !validSubsetName.MatchString(name) {
+			return fmt.Errorf("spec.subsets[%s]: name must be a valid DNS label of lowercase letters, digits and dashes", name)
+		}
+		if filter := spec.Subsets[name].Filter; filter != "" {
+			if _, err := bexpr.CreateEvaluatorForType(filter, nil, (*api.ServiceEntry)(nil)); err != nil {
+				return fmt.Errorf("spec.subsets[%s].filter %q is invalid: %s", name, filter, err)
+			}
+		}
+	}
+	if spec.DefaultSubset != "" {
+		if _, ok := spec.Subsets[spec.DefaultSubset]; !ok {
+			return fmt.Errorf("spec.defaultSubset %q is not a defined subset", spec.DefaultSubset)
+		}
+	}
+
+	failoverNames := make([]string, 0, len(spec.Failover))
+	for name := range spec.Failover {
+		failoverNames = append(failoverNames, name)
+	}
+	sort.Strings(failoverNames)
+	for _, name := range failoverNames {
+		if _, ok := spec.Subsets[name]; name != "*" && !ok {
+			return fmt.Errorf("spec.failover[%s]: must be \"*\" or a defined subset", name)
+		}
+		failover := spec.Failover[name]
+		if failover.Service == "" && failover.ServiceSubset == "" && failover.Namespace == "" && len(failover.Datacenters) == 0 {
+			return fmt.Errorf("spec.failover[%s] must set at least one of service, serviceSubset, namespace or datacenters", name)
+		}
+		if _, ok := spec.Subsets[failover.ServiceSubset]; failover.ServiceSubset != "" && failover.Service == "" && !ok {
+			return fmt.Errorf("spec.failover[%s].serviceSubset %q is not a defined subset", name, failover.ServiceSubset)
+		}
+		for i, dc := range failover.Datacenters {
+			if dc == "" {
+				return fmt.Errorf("spec.failover[%s].datacenters[%d] cannot be empty", name, i)
+			}
+		}
+	}
+
+	if spec.ConnectTimeout.Duration < 0 {
+		return fmt.Errorf("spec.connectTimeout cannot be negative")
+	}
+	return nil
+}
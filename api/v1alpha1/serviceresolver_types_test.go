@@ -0,0 +1,210 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServiceResolver_ToConsul(t *testing.T) {
+	resource := &ServiceResolver{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec: ServiceResolverSpec{
+			DefaultSubset: "v1",
+			Subsets: map[string]ServiceResolverSubset{
+				"v1": {Filter: "Service.Meta.version == v1", OnlyPassing: true},
+			},
+			Failover: map[string]ServiceResolverFailover{
+				"*": {Service: "bar", ServiceSubset: "v2", Namespace: "ns", Datacenters: []string{"dc2"}},
+			},
+			ConnectTimeout: metav1.Duration{Duration: 5 * time.Second},
+		},
+	}
+	require.Equal(t, &api.ServiceResolverConfigEntry{
+		Kind:          api.ServiceResolver,
+		Name:          "foo",
+		Namespace:     "consul-ns",
+		DefaultSubset: "v1",
+		Subsets: map[string]api.ServiceResolverSubset{
+			"v1": {Filter: "Service.Meta.version == v1", OnlyPassing: true},
+		},
+		Failover: map[string]api.ServiceResolverFailover{
+			"*": {Service: "bar", ServiceSubset: "v2", Namespace: "ns", Datacenters: []string{"dc2"}},
+		},
+		ConnectTimeout: 5 * time.Second,
+	}, resource.ToConsul("consul-ns"))
+
+	redirect := &ServiceResolver{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec: ServiceResolverSpec{
+			Redirect: &ServiceResolverRedirect{Service: "bar", Datacenter: "dc2"},
+		},
+	}
+	require.Equal(t, &api.ServiceResolverConfigEntry{
+		Kind:     api.ServiceResolver,
+		Name:     "foo",
+		Redirect: &api.ServiceResolverRedirect{Service: "bar", Datacenter: "dc2"},
+	}, redirect.ToConsul(""))
+}
+
+func TestServiceResolver_MatchesConsul(t *testing.T) {
+	resource := &ServiceResolver{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec: ServiceResolverSpec{
+			Subsets: map[string]ServiceResolverSubset{
+				"v1": {Filter: "Service.Meta.version == v1"},
+			},
+		},
+	}
+	require.True(t, resource.MatchesConsul(&api.ServiceResolverConfigEntry{
+		Kind:        api.ServiceResolver,
+		Name:        "foo",
+		Namespace:   "ns",
+		Subsets:     map[string]api.ServiceResolverSubset{"v1": {Filter: "Service.Meta.version == v1"}},
+		ModifyIndex: 10,
+	}))
+	require.False(t, resource.MatchesConsul(&api.ServiceResolverConfigEntry{
+		Kind:    api.ServiceResolver,
+		Name:    "foo",
+		Subsets: map[string]api.ServiceResolverSubset{"v1": {Filter: "Service.Meta.version == v2"}},
+	}))
+	require.False(t, resource.MatchesConsul(&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "foo"}))
+}
+
+func TestServiceResolver_SubsetRefs(t *testing.T) {
+	resource := &ServiceResolver{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: ServiceResolverSpec{
+			Subsets: map[string]ServiceResolverSubset{"v1": {}, "v2": {}},
+			Failover: map[string]ServiceResolverFailover{
+				"v2": {Service: "backup", ServiceSubset: "v2"},
+				"v1": {Service: "backup", Namespace: "dr", ServiceSubset: "v1"},
+				"*":  {ServiceSubset: "v1"},
+			},
+		},
+	}
+	require.Equal(t, []SubsetRef{
+		{Path: "spec.failover[v1]", Service: "backup", Namespace: "dr", Subset: "v1"},
+		{Path: "spec.failover[v2]", Service: "backup", Subset: "v2"},
+	}, resource.SubsetRefs())
+
+	resource = &ServiceResolver{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec:       ServiceResolverSpec{Redirect: &ServiceResolverRedirect{Service: "api", ServiceSubset: "v1"}},
+	}
+	require.Equal(t, []SubsetRef{{Path: "spec.redirect", Service: "api", Subset: "v1"}}, resource.SubsetRefs())
+}
+
+func TestServiceResolver_Validate(t *testing.T) {
+	cases := map[string]struct {
+		spec   ServiceResolverSpec
+		expErr string
+	}{
+		"valid subsets and failover": {
+			spec: ServiceResolverSpec{
+				DefaultSubset: "v1",
+				Subsets: map[string]ServiceResolverSubset{
+					"v1": {Filter: "Service.Meta.version == v1"},
+					"v2": {Filter: `Service.Meta.version == "v2" and Service.Tags contains canary`},
+				},
+				Failover: map[string]ServiceResolverFailover{
+					"v1": {ServiceSubset: "v2"},
+					"*":  {Datacenters: []string{"dc2", "dc3"}},
+				},
+			},
+		},
+		"valid redirect": {
+			spec: ServiceResolverSpec{
+				Redirect: &ServiceResolverRedirect{Service: "bar", ServiceSubset: "v1"},
+			},
+		},
+		"redirect with subsets": {
+			spec: ServiceResolverSpec{
+				Redirect: &ServiceResolverRedirect{Service: "bar"},
+				Subsets:  map[string]ServiceResolverSubset{"v1": {}},
+			},
+			expErr: "spec.redirect cannot be set with spec.defaultSubset, spec.subsets or spec.failover",
+		},
+		"empty redirect": {
+			spec:   ServiceResolverSpec{Redirect: &ServiceResolverRedirect{}},
+			expErr: "spec.redirect must set at least one of service, serviceSubset, namespace or datacenter",
+		},
+		"redirect subset without service": {
+			spec:   ServiceResolverSpec{Redirect: &ServiceResolverRedirect{ServiceSubset: "v1"}},
+			expErr: "spec.redirect.serviceSubset cannot be set without spec.redirect.service",
+		},
+		"invalid subset name": {
+			spec: ServiceResolverSpec{
+				Subsets: map[string]ServiceResolverSubset{"V_1": {}},
+			},
+			expErr: "spec.subsets[V_1]: name must be a valid DNS label of lowercase letters, digits and dashes",
+		},
+		"invalid filter syntax": {
+			spec: ServiceResolverSpec{
+				Subsets: map[string]ServiceResolverSubset{"v1": {Filter: "Service.Meta.version =="}},
+			},
+			expErr: `spec.subsets[v1].filter "Service.Meta.version ==" is invalid`,
+		},
+		"invalid filter field": {
+			spec: ServiceResolverSpec{
+				Subsets: map[string]ServiceResolverSubset{"v1": {Filter: "Service.Foo == bar"}},
+			},
+			expErr: `spec.subsets[v1].filter "Service.Foo == bar" is invalid`,
+		},
+		"undefined default subset": {
+			spec: ServiceResolverSpec{
+				DefaultSubset: "v1",
+			},
+			expErr: `spec.defaultSubset "v1" is not a defined subset`,
+		},
+		"failover for undefined subset": {
+			spec: ServiceResolverSpec{
+				Failover: map[string]ServiceResolverFailover{"v1": {Service: "bar"}},
+			},
+			expErr: `spec.failover[v1]: must be "*" or a defined subset`,
+		},
+		"empty failover": {
+			spec: ServiceResolverSpec{
+				Failover: map[string]ServiceResolverFailover{"*": {}},
+			},
+			expErr: "spec.failover[*] must set at least one of service, serviceSubset, namespace or datacenters",
+		},
+		"failover to undefined subset": {
+			spec: ServiceResolverSpec{
+				Failover: map[string]ServiceResolverFailover{"*": {ServiceSubset: "v2"}},
+			},
+			expErr: `spec.failover[*].serviceSubset "v2" is not a defined subset`,
+		},
+		"failover to empty datacenter": {
+			spec: ServiceResolverSpec{
+				Failover: map[string]ServiceResolverFailover{"*": {Datacenters: []string{""}}},
+			},
+			expErr: "spec.failover[*].datacenters[0] cannot be empty",
+		},
+		"negative connect timeout": {
+			spec: ServiceResolverSpec{
+				ConnectTimeout: metav1.Duration{Duration: -time.Second},
+			},
+			expErr: "spec.connectTimeout cannot be negative",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &ServiceResolver{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec:       c.spec,
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
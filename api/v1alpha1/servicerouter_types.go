@@ -0,0 +1,338 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceRouterResource is the resource name of ServiceRouter.
+const ServiceRouterResource = "servicerouters"
+
+// ServiceRouter is the Schema for the servicerouters API. It is
+// reconciled into the service-router config entry of the service with
+// the resource's name.
+type ServiceRouter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ServiceRouterSpec `json:"spec,omitempty"`
+	Status Status            `json:"status,omitempty"`
+}
+
+// ServiceRouterSpec defines the desired state of ServiceRouter.
+type ServiceRouterSpec struct {
+	ConsulTarget `json:",inline"`
+
+	// Routes are the list of routes to consider when processing L7
+	// requests. The first route to match in the list is terminal and
+	// stops further evaluation. Traffic that fails to match any of the
+	// provided routes will be routed to the default service.
+	Routes []ServiceRoute `json:"routes,omitempty"`
+}
+
+// ServiceRoute is a route of a ServiceRouter.
+type ServiceRoute struct {
+	// Match is a set of criteria that can match incoming L7 requests.
+	// If empty or omitted it acts as a catch-all.
+	Match *ServiceRouteMatch `json:"match,omitempty"`
+	// Destination controls how to proxy the matching request(s) to a
+	// service.
+	Destination *ServiceRouteDestination `json:"destination,omitempty"`
+}
+
+// ServiceRouteMatch is the criteria of a route.
+type ServiceRouteMatch struct {
+	// HTTP is a set of http-specific match criteria.
+	HTTP *ServiceRouteHTTPMatch `json:"http,omitempty"`
+}
+
+// ServiceRouteHTTPMatch is the HTTP criteria of a route.
+type ServiceRouteHTTPMatch struct {
+	// PathExact matches the exact path of the request.
+	PathExact string `json:"pathExact,omitempty"`
+	// PathPrefix matches the path prefix of the request.
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	// PathRegex matches the path of the request with a regular expression.
+	PathRegex string `json:"pathRegex,omitempty"`
+	// Header is a set of criteria that can match on HTTP request headers.
+	// If more than one is configured all must match for the overall
+	// match to apply.
+	Header []ServiceRouteHTTPMatchHeader `json:"header,omitempty"`
+	// QueryParam is a set of criteria that can match on HTTP query
+	// parameters. If more than one is configured all must match for the
+	// overall match to apply.
+	QueryParam []ServiceRouteHTTPMatchQueryParam `json:"queryParam,omitempty"`
+	// Methods is a list of HTTP methods for which this match applies.
+	// If unspecified all HTTP methods are matched.
+	Methods []string `json:"methods,omitempty"`
+}
+
+// ServiceRouteHTTPMatchHeader matches an HTTP request header.
+type ServiceRouteHTTPMatchHeader struct {
+	// Name is the name of the header to match.
+	Name string `json:"name"`
+	// Present matches if the header is present with any value.
+	Present bool `json:"present,omitempty"`
+	// Exact matches if the header is set to this value.
+	Exact string `json:"exact,omitempty"`
+	// Prefix matches if the header's value starts with this.
+	Prefix string `json:"prefix,omitempty"`
+	// Suffix matches if the header's value ends with this.
+	Suffix string `json:"suffix,omitempty"`
+	// Regex matches the header's value with a regular expression.
+	Regex string `json:"regex,omitempty"`
+	// Invert inverts the logic of the match.
+	Invert bool `json:"invert,omitempty"`
+}
+
+// ServiceRouteHTTPMatchQueryParam matches an HTTP query parameter.
+type ServiceRouteHTTPMatchQueryParam struct {
+	// Name is the name of the query parameter to match.
+	Name string `json:"name"`
+	// Present matches if the query parameter is present with any value.
+	Present bool `json:"present,omitempty"`
+	// Exact matches if the query parameter is set to this value.
+	Exact string `json:"exact,omitempty"`
+	// Regex matches the query parameter's value with a regular expression.
+	Regex string `json:"regex,omitempty"`
+}
+
+// ServiceRouteDestination is the destination of a route.
+type ServiceRouteDestination struct {
+	// Service is the service to resolve instead of the default service.
+	// If empty then the default service name is used.
+	Service string `json:"service,omitempty"`
+	// ServiceSubset is a named subset of the given service to resolve
+	// instead of the one defined as that service's defaultSubset.
+	ServiceSubset string `json:"serviceSubset,omitempty"`
+	// Namespace is the Consul namespace to resolve the service from
+	// instead of the current namespace.
+	Namespace string `json:"namespace,omitempty"`
+	// PrefixRewrite defines how to rewrite the HTTP request path before
+	// proxying it to its final destination. This requires that either
+	// match.http.pathPrefix or match.http.pathExact be configured on
+	// this route.
+	PrefixRewrite string `json:"prefixRewrite,omitempty"`
+	// RequestTimeout is the total amount of time permitted for the
+	// entire downstream request (and retries) to be processed.
+	RequestTimeout metav1.Duration `json:"requestTimeout,omitempty"`
+	// NumRetries is the number of times to retry the request when a
+	// retryable result occurs.
+	NumRetries uint32 `json:"numRetries,omitempty"`
+	// RetryOnConnectFailure allows for connection failure errors to
+	// trigger a retry.
+	RetryOnConnectFailure bool `json:"retryOnConnectFailure,omitempty"`
+	// RetryOnStatusCodes is a flat list of http response status codes
+	// that are eligible for retry.
+	RetryOnStatusCodes []uint32 `json:"retryOnStatusCodes,omitempty"`
+}
+
+func (in *ServiceRouter) ConsulKind() string {
+	return api.ServiceRouter
+}
+
+func (in *ServiceRouter) ConsulName() string {
+	return in.Name
+}
+
+func (in *ServiceRouter) ResourceStatus() *Status {
+	return &in.Status
+}
+
+func (in *ServiceRouter) ConsulTarget() ConsulTarget {
+	return in.Spec.ConsulTarget
+}
+
+func (in *ServiceRouter) ToConsul(namespace string) api.ConfigEntry {
+	entry := &api.ServiceRouterConfigEntry{
+		Kind:      in.ConsulKind(),
+		Name:      in.ConsulName(),
+		Namespace: namespace,
+	}
+	for _, route := range in.Spec.Routes {
+		var consulRoute api.ServiceRoute
+		if route.Match != nil {
+			consulRoute.Match = &api.ServiceRouteMatch{}
+			if http := route.Match.HTTP; http != nil {
+				consulRoute.Match.HTTP = &api.ServiceRouteHTTPMatch{
+					PathExact:  http.PathExact,
+					PathPrefix: http.PathPrefix,
+					PathRegex:  http.PathRegex,
+				}
+				for _, header := range http.Header {
+					consulRoute.Match.HTTP.Header = append(consulRoute.Match.HTTP.Header, api.ServiceRouteHTTPMatchHeader{
+						Name:    header.Name,
+						Present: header.Present,
+						Exact:   header.Exact,
+						Prefix:  header.Prefix,
+						Suffix:  header.Suffix,
+						Regex:   header.Regex,
+						Invert:  header.Invert,
+					})
+				}
+				for _, param := range http.QueryParam {
+					consulRoute.Match.HTTP.QueryParam = append(consulRoute.Match.HTTP.QueryParam, api.ServiceRouteHTTPMatchQueryParam{
+						Name:    param.Name,
+						Present: param.Present,
+						Exact:   param.Exact,
+						Regex:   param.Regex,
+					})
+				}
+				// Consul stores the methods in upper case.
+				for _, method := range http.Methods {
+					consulRoute.Match.HTTP.Methods = append(consulRoute.Match.HTTP.Methods, strings.ToUpper(method))
+				}
+			}
+		}
+		if dest := route.Destination; dest != nil {
+			consulRoute.Destination = &api.ServiceRouteDestination{
+				Service:               dest.Service,
+				ServiceSubset:         dest.ServiceSubset,
+				Namespace:             dest.Namespace,
+				PrefixRewrite:         dest.PrefixRewrite,
+				RequestTimeout:        dest.RequestTimeout.Duration,
+				NumRetries:            dest.NumRetries,
+				RetryOnConnectFailure: dest.RetryOnConnectFailure,
+				RetryOnStatusCodes:    dest.RetryOnStatusCodes,
+			}
+		}
+		entry.Routes = append(entry.Routes, consulRoute)
+	}
+	return entry
+}
+
+func (in *ServiceRouter) MatchesConsul(entry api.ConfigEntry) bool {
+	serviceRouter, ok := entry.(*api.ServiceRouterConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *serviceRouter
+	actual.Namespace = ""
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+
+	// Consul Enterprise defaults the destinations' namespace to the
+	// entry's namespace.
+	expected := in.ToConsul("").(*api.ServiceRouterConfigEntry)
+	if len(expected.Routes) != len(actual.Routes) {
+		return false
+	}
+	actual.Routes = append([]api.ServiceRoute(nil), actual.Routes...)
+	for i, route := range expected.Routes {
+		if route.Destination != nil && route.Destination.Namespace == "" && actual.Routes[i].Destination != nil {
+			dest := *actual.Routes[i].Destination
+			dest.Namespace = ""
+			actual.Routes[i].Destination = &dest
+		}
+	}
+	return reflect.DeepEqual(expected, &actual)
+}
+
+// SubsetRefs returns the subsets of the routes' destinations.
+// Destinations without a service reference the router's service.
+func (in *ServiceRouter) SubsetRefs() []SubsetRef {
+	var refs []SubsetRef
+	for i, route := range in.Spec.Routes {
+		dest := route.Destination
+		if dest == nil || dest.ServiceSubset == "" {
+			continue
+		}
+		service := dest.Service
+		if service == "" {
+			service = in.ConsulName()
+		}
+		refs = append(refs, SubsetRef{
+			Path:      fmt.Sprintf("spec.routes[%d].destination", i),
+			Service:   service,
+			Namespace: dest.Namespace,
+			Subset:    dest.ServiceSubset,
+		})
+	}
+	return refs
+}
+
+func (in *ServiceRouter) Validate() error {
+	for i, route := range in.Spec.Routes {
+		path := fmt.Sprintf("spec.routes[%d]", i)
+		eligibleForPrefixRewrite := false
+		if route.Match != nil && route.Match.HTTP != nil {
+			http := route.Match.HTTP
+			pathParts := 0
+			if http.PathExact != "" {
+				eligibleForPrefixRewrite = true
+				pathParts++
+				if !strings.HasPrefix(http.PathExact, "/") {
+					return fmt.Errorf("%s.match.http.pathExact must begin with '/', got %q", path, http.PathExact)
+				}
+			}
+			if http.PathPrefix != "" {
+				eligibleForPrefixRewrite = true
+				pathParts++
+				if !strings.HasPrefix(http.PathPrefix, "/") {
+					return fmt.Errorf("%s.match.http.pathPrefix must begin with '/', got %q", path, http.PathPrefix)
+				}
+			}
+			if http.PathRegex != "" {
+				pathParts++
+			}
+			if pathParts > 1 {
+				return fmt.Errorf("%s.match.http must only set one of pathExact, pathPrefix or pathRegex", path)
+			}
+
+			for j, header := range http.Header {
+				if header.Name == "" {
+					return fmt.Errorf("%s.match.http.header[%d].name must be set", path, j)
+				}
+				if countSet(header.Present, header.Exact != "", header.Prefix != "", header.Suffix != "", header.Regex != "") != 1 {
+					return fmt.Errorf("%s.match.http.header[%d] must set exactly one of present, exact, prefix, suffix or regex", path, j)
+				}
+			}
+			for j, param := range http.QueryParam {
+				if param.Name == "" {
+					return fmt.Errorf("%s.match.http.queryParam[%d].name must be set", path, j)
+				}
+				if countSet(param.Present, param.Exact != "", param.Regex != "") != 1 {
+					return fmt.Errorf("%s.match.http.queryParam[%d] must set exactly one of present, exact or regex", path, j)
+				}
+			}
+			found := make(map[string]bool)
+			for _, method := range http.Methods {
+				method = strings.ToUpper(method)
+				if found[method] {
+					return fmt.Errorf("%s.match.http.methods contains %q more than once", path, method)
+				}
+				found[method] = true
+			}
+		}
+
+		if dest := route.Destination; dest != nil {
+			if dest.PrefixRewrite != "" && !eligibleForPrefixRewrite {
+				return fmt.Errorf("%s.destination.prefixRewrite requires match.http.pathExact or match.http.pathPrefix", path)
+			}
+			if dest.RequestTimeout.Duration < 0 {
+				return fmt.Errorf("%s.destination.requestTimeout cannot be negative", path)
+			}
+			for _, code := range dest.RetryOnStatusCodes {
+				if code < 100 || code > 599 {
+					return fmt.Errorf("%s.destination.retryOnStatusCodes contains invalid status code %d", path, code)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// countSet returns the number of true values.
+func countSet(values ...bool) int {
+	count := 0
+	for _, v := range values {
+		if v {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,211 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServiceRouter_ToConsul(t *testing.T) {
+	resource := &ServiceRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec: ServiceRouterSpec{
+			Routes: []ServiceRoute{
+				{
+					Match: &ServiceRouteMatch{
+						HTTP: &ServiceRouteHTTPMatch{
+							PathPrefix: "/admin",
+							Header:     []ServiceRouteHTTPMatchHeader{{Name: "x-debug", Present: true}},
+							QueryParam: []ServiceRouteHTTPMatchQueryParam{{Name: "version", Exact: "2"}},
+							Methods:    []string{"get", "POST"},
+						},
+					},
+					Destination: &ServiceRouteDestination{
+						Service:               "admin",
+						PrefixRewrite:         "/",
+						RequestTimeout:        metav1.Duration{Duration: 10 * time.Second},
+						NumRetries:            3,
+						RetryOnConnectFailure: true,
+						RetryOnStatusCodes:    []uint32{503},
+					},
+				},
+				{
+					Destination: &ServiceRouteDestination{ServiceSubset: "v2"},
+				},
+			},
+		},
+	}
+	require.Equal(t, &api.ServiceRouterConfigEntry{
+		Kind:      api.ServiceRouter,
+		Name:      "foo",
+		Namespace: "consul-ns",
+		Routes: []api.ServiceRoute{
+			{
+				Match: &api.ServiceRouteMatch{
+					HTTP: &api.ServiceRouteHTTPMatch{
+						PathPrefix: "/admin",
+						Header:     []api.ServiceRouteHTTPMatchHeader{{Name: "x-debug", Present: true}},
+						QueryParam: []api.ServiceRouteHTTPMatchQueryParam{{Name: "version", Exact: "2"}},
+						Methods:    []string{"GET", "POST"},
+					},
+				},
+				Destination: &api.ServiceRouteDestination{
+					Service:               "admin",
+					PrefixRewrite:         "/",
+					RequestTimeout:        10 * time.Second,
+					NumRetries:            3,
+					RetryOnConnectFailure: true,
+					RetryOnStatusCodes:    []uint32{503},
+				},
+			},
+			{
+				Destination: &api.ServiceRouteDestination{ServiceSubset: "v2"},
+			},
+		},
+	}, resource.ToConsul("consul-ns"))
+}
+
+func TestServiceRouter_MatchesConsul(t *testing.T) {
+	resource := &ServiceRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec: ServiceRouterSpec{
+			Routes: []ServiceRoute{{
+				Match:       &ServiceRouteMatch{HTTP: &ServiceRouteHTTPMatch{PathExact: "/v2", Methods: []string{"get"}}},
+				Destination: &ServiceRouteDestination{Service: "bar"},
+			}},
+		},
+	}
+	// Consul upper cases the methods and, in Enterprise, defaults the
+	// destination's namespace.
+	require.True(t, resource.MatchesConsul(&api.ServiceRouterConfigEntry{
+		Kind:      api.ServiceRouter,
+		Name:      "foo",
+		Namespace: "ns",
+		Routes: []api.ServiceRoute{{
+			Match:       &api.ServiceRouteMatch{HTTP: &api.ServiceRouteHTTPMatch{PathExact: "/v2", Methods: []string{"GET"}}},
+			Destination: &api.ServiceRouteDestination{Service: "bar", Namespace: "ns"},
+		}},
+		ModifyIndex: 10,
+	}))
+	require.False(t, resource.MatchesConsul(&api.ServiceRouterConfigEntry{
+		Kind: api.ServiceRouter,
+		Name: "foo",
+		Routes: []api.ServiceRoute{{
+			Match:       &api.ServiceRouteMatch{HTTP: &api.ServiceRouteHTTPMatch{PathExact: "/v2", Methods: []string{"GET"}}},
+			Destination: &api.ServiceRouteDestination{Service: "baz"},
+		}},
+	}))
+	require.False(t, resource.MatchesConsul(&api.ServiceRouterConfigEntry{Kind: api.ServiceRouter, Name: "foo"}))
+	require.False(t, resource.MatchesConsul(&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "foo"}))
+}
+
+func TestServiceRouter_SubsetRefs(t *testing.T) {
+	resource := &ServiceRouter{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: ServiceRouterSpec{Routes: []ServiceRoute{
+			{Destination: &ServiceRouteDestination{ServiceSubset: "v1"}},
+			{},
+			{Destination: &ServiceRouteDestination{Service: "admin", ServiceSubset: "v2"}},
+		}},
+	}
+	require.Equal(t, []SubsetRef{
+		{Path: "spec.routes[0].destination", Service: "web", Subset: "v1"},
+		{Path: "spec.routes[2].destination", Service: "admin", Subset: "v2"},
+	}, resource.SubsetRefs())
+}
+
+func TestServiceRouter_Validate(t *testing.T) {
+	cases := map[string]struct {
+		route  ServiceRoute
+		expErr string
+	}{
+		"valid": {
+			route: ServiceRoute{
+				Match: &ServiceRouteMatch{HTTP: &ServiceRouteHTTPMatch{
+					PathPrefix: "/admin",
+					Header:     []ServiceRouteHTTPMatchHeader{{Name: "x-debug", Exact: "1", Invert: true}},
+					QueryParam: []ServiceRouteHTTPMatchQueryParam{{Name: "version", Regex: "2.*"}},
+					Methods:    []string{"GET", "PUT"},
+				}},
+				Destination: &ServiceRouteDestination{Service: "admin", PrefixRewrite: "/", RetryOnStatusCodes: []uint32{503}},
+			},
+		},
+		"catch-all": {
+			route: ServiceRoute{Destination: &ServiceRouteDestination{Service: "bar"}},
+		},
+		"multiple paths": {
+			route: ServiceRoute{
+				Match: &ServiceRouteMatch{HTTP: &ServiceRouteHTTPMatch{PathExact: "/a", PathRegex: "/b.*"}},
+			},
+			expErr: "spec.routes[0].match.http must only set one of pathExact, pathPrefix or pathRegex",
+		},
+		"relative path prefix": {
+			route: ServiceRoute{
+				Match: &ServiceRouteMatch{HTTP: &ServiceRouteHTTPMatch{PathPrefix: "admin"}},
+			},
+			expErr: `spec.routes[0].match.http.pathPrefix must begin with '/', got "admin"`,
+		},
+		"header without name": {
+			route: ServiceRoute{
+				Match: &ServiceRouteMatch{HTTP: &ServiceRouteHTTPMatch{Header: []ServiceRouteHTTPMatchHeader{{Exact: "1"}}}},
+			},
+			expErr: "spec.routes[0].match.http.header[0].name must be set",
+		},
+		"header with multiple criteria": {
+			route: ServiceRoute{
+				Match: &ServiceRouteMatch{HTTP: &ServiceRouteHTTPMatch{Header: []ServiceRouteHTTPMatchHeader{{Name: "x", Present: true, Exact: "1"}}}},
+			},
+			expErr: "spec.routes[0].match.http.header[0] must set exactly one of present, exact, prefix, suffix or regex",
+		},
+		"query param without criteria": {
+			route: ServiceRoute{
+				Match: &ServiceRouteMatch{HTTP: &ServiceRouteHTTPMatch{QueryParam: []ServiceRouteHTTPMatchQueryParam{{Name: "x"}}}},
+			},
+			expErr: "spec.routes[0].match.http.queryParam[0] must set exactly one of present, exact or regex",
+		},
+		"duplicate methods": {
+			route: ServiceRoute{
+				Match: &ServiceRouteMatch{HTTP: &ServiceRouteHTTPMatch{Methods: []string{"GET", "get"}}},
+			},
+			expErr: `spec.routes[0].match.http.methods contains "GET" more than once`,
+		},
+		"prefix rewrite without path": {
+			route: ServiceRoute{
+				Match:       &ServiceRouteMatch{HTTP: &ServiceRouteHTTPMatch{PathRegex: "/a.*"}},
+				Destination: &ServiceRouteDestination{PrefixRewrite: "/"},
+			},
+			expErr: "spec.routes[0].destination.prefixRewrite requires match.http.pathExact or match.http.pathPrefix",
+		},
+		"negative request timeout": {
+			route: ServiceRoute{
+				Destination: &ServiceRouteDestination{RequestTimeout: metav1.Duration{Duration: -time.Second}},
+			},
+			expErr: "spec.routes[0].destination.requestTimeout cannot be negative",
+		},
+		"invalid status code": {
+			route: ServiceRoute{
+				Destination: &ServiceRouteDestination{RetryOnStatusCodes: []uint32{5000}},
+			},
+			expErr: "spec.routes[0].destination.retryOnStatusCodes contains invalid status code 5000",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &ServiceRouter{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec:       ServiceRouterSpec{Routes: []ServiceRoute{c.route}},
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
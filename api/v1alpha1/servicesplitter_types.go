@@ -0,0 +1,167 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServiceSplitterResource is the resource name of ServiceSplitter.
+const ServiceSplitterResource = "servicesplitters"
+
+// ServiceSplitter is the Schema for the servicesplitters API. It is
+// reconciled into the service-splitter config entry of the service with
+// the resource's name.
+type ServiceSplitter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ServiceSplitterSpec `json:"spec,omitempty"`
+	Status Status              `json:"status,omitempty"`
+}
+
+// ServiceSplitterSpec defines the desired state of ServiceSplitter.
+type ServiceSplitterSpec struct {
+	ConsulTarget `json:",inline"`
+
+	// Splits defines how much traffic to send to which set of service
+	// instances during a traffic split. The sum of weights across all
+	// splits must add up to 100.
+	Splits []ServiceSplit `json:"splits,omitempty"`
+}
+
+// ServiceSplit is a split of a ServiceSplitter.
+type ServiceSplit struct {
+	// Weight is a value between 0 and 100 reflecting what portion of
+	// traffic should be directed to this split. The smallest
+	// representable weight is 1/10000 or .01%.
+	Weight float32 `json:"weight"`
+	// Service is the service to resolve instead of the default.
+	Service string `json:"service,omitempty"`
+	// ServiceSubset is a named subset of the given service to resolve
+	// instead of one defined as that service's defaultSubset.
+	ServiceSubset string `json:"serviceSubset,omitempty"`
+	// Namespace is the Consul namespace to resolve the service from
+	// instead of the current namespace.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func (in *ServiceSplitter) ConsulKind() string {
+	return api.ServiceSplitter
+}
+
+func (in *ServiceSplitter) ConsulName() string {
+	return in.Name
+}
+
+func (in *ServiceSplitter) ResourceStatus() *Status {
+	return &in.Status
+}
+
+func (in *ServiceSplitter) ConsulTarget() ConsulTarget {
+	return in.Spec.ConsulTarget
+}
+
+func (in *ServiceSplitter) ToConsul(namespace string) api.ConfigEntry {
+	entry := &api.ServiceSplitterConfigEntry{
+		Kind:      in.ConsulKind(),
+		Name:      in.ConsulName(),
+		Namespace: namespace,
+	}
+	for _, split := range in.Spec.Splits {
+		entry.Splits = append(entry.Splits, api.ServiceSplit{
+			Weight:        normalizeWeight(split.Weight),
+			Service:       split.Service,
+			ServiceSubset: split.ServiceSubset,
+			Namespace:     split.Namespace,
+		})
+	}
+	return entry
+}
+
+func (in *ServiceSplitter) MatchesConsul(entry api.ConfigEntry) bool {
+	serviceSplitter, ok := entry.(*api.ServiceSplitterConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *serviceSplitter
+	actual.Namespace = ""
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+
+	// Consul Enterprise defaults the splits' namespace to the entry's
+	// namespace.
+	expected := in.ToConsul("").(*api.ServiceSplitterConfigEntry)
+	if len(expected.Splits) != len(actual.Splits) {
+		return false
+	}
+	actual.Splits = append([]api.ServiceSplit(nil), actual.Splits...)
+	for i, split := range expected.Splits {
+		if split.Namespace == "" {
+			actual.Splits[i].Namespace = ""
+		}
+	}
+	return reflect.DeepEqual(expected, &actual)
+}
+
+// SubsetRefs returns the subsets of the splits. Splits without a service
+// reference the splitter's service.
+func (in *ServiceSplitter) SubsetRefs() []SubsetRef {
+	var refs []SubsetRef
+	for i, split := range in.Spec.Splits {
+		if split.ServiceSubset == "" {
+			continue
+		}
+		service := split.Service
+		if service == "" {
+			service = in.ConsulName()
+		}
+		refs = append(refs, SubsetRef{
+			Path:      fmt.Sprintf("spec.splits[%d]", i),
+			Service:   service,
+			Namespace: split.Namespace,
+			Subset:    split.ServiceSubset,
+		})
+	}
+	return refs
+}
+
+func (in *ServiceSplitter) Validate() error {
+	if len(in.Spec.Splits) == 0 {
+		return fmt.Errorf("spec.splits must have at least one split")
+	}
+
+	type splitKey struct {
+		service, subset, namespace string
+	}
+	found := make(map[splitKey]bool)
+	var sum float32
+	for i, split := range in.Spec.Splits {
+		weight := normalizeWeight(split.Weight)
+		if weight < 0 || weight > 100 {
+			return fmt.Errorf("spec.splits[%d].weight must be between 0 and 100, got %v", i, split.Weight)
+		}
+		key := splitKey{split.Service, split.ServiceSubset, split.Namespace}
+		if found[key] {
+			return fmt.Errorf("spec.splits[%d] has the same service, serviceSubset and namespace as a previous split", i)
+		}
+		found[key] = true
+		sum += weight
+	}
+
+	// Consul compares the sum in hundredths of a percent to avoid
+	// floating point rounding errors.
+	if sumScaled := int(math.Round(float64(sum) * 100)); sumScaled != 10000 {
+		return fmt.Errorf("spec.splits weights must add up to 100, got %.2f", float64(sumScaled)/100)
+	}
+	return nil
+}
+
+// normalizeWeight rounds weight to two decimal places like Consul does
+// when storing a service-splitter config entry.
+func normalizeWeight(weight float32) float32 {
+	return float32(math.Round(float64(weight)*100) / 100)
+}
@@ -0,0 +1,131 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServiceSplitter_ToConsul(t *testing.T) {
+	resource := &ServiceSplitter{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec: ServiceSplitterSpec{
+			Splits: []ServiceSplit{
+				{Weight: 90, ServiceSubset: "v1"},
+				{Weight: 10.004, Service: "bar", ServiceSubset: "v2", Namespace: "ns"},
+			},
+		},
+	}
+	require.Equal(t, &api.ServiceSplitterConfigEntry{
+		Kind:      api.ServiceSplitter,
+		Name:      "foo",
+		Namespace: "consul-ns",
+		Splits: []api.ServiceSplit{
+			{Weight: 90, ServiceSubset: "v1"},
+			{Weight: 10, Service: "bar", ServiceSubset: "v2", Namespace: "ns"},
+		},
+	}, resource.ToConsul("consul-ns"))
+}
+
+func TestServiceSplitter_MatchesConsul(t *testing.T) {
+	resource := &ServiceSplitter{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+		Spec: ServiceSplitterSpec{
+			Splits: []ServiceSplit{
+				{Weight: 66.667, ServiceSubset: "v1"},
+				{Weight: 33.333, ServiceSubset: "v2"},
+			},
+		},
+	}
+	// Consul rounds the weights and, in Enterprise, defaults the splits'
+	// namespace.
+	require.True(t, resource.MatchesConsul(&api.ServiceSplitterConfigEntry{
+		Kind:      api.ServiceSplitter,
+		Name:      "foo",
+		Namespace: "ns",
+		Splits: []api.ServiceSplit{
+			{Weight: 66.67, ServiceSubset: "v1", Namespace: "ns"},
+			{Weight: 33.33, ServiceSubset: "v2", Namespace: "ns"},
+		},
+		ModifyIndex: 10,
+	}))
+	require.False(t, resource.MatchesConsul(&api.ServiceSplitterConfigEntry{
+		Kind: api.ServiceSplitter,
+		Name: "foo",
+		Splits: []api.ServiceSplit{
+			{Weight: 50, ServiceSubset: "v1"},
+			{Weight: 50, ServiceSubset: "v2"},
+		},
+	}))
+	require.False(t, resource.MatchesConsul(&api.ServiceSplitterConfigEntry{Kind: api.ServiceSplitter, Name: "foo"}))
+	require.False(t, resource.MatchesConsul(&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "foo"}))
+}
+
+func TestServiceSplitter_SubsetRefs(t *testing.T) {
+	resource := &ServiceSplitter{
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: ServiceSplitterSpec{Splits: []ServiceSplit{
+			{Weight: 50, ServiceSubset: "v1"},
+			{Weight: 40, Service: "db", Namespace: "billing", ServiceSubset: "v2"},
+			{Weight: 10, Service: "db"},
+		}},
+	}
+	require.Equal(t, []SubsetRef{
+		{Path: "spec.splits[0]", Service: "web", Subset: "v1"},
+		{Path: "spec.splits[1]", Service: "db", Namespace: "billing", Subset: "v2"},
+	}, resource.SubsetRefs())
+}
+
+func TestServiceSplitter_Validate(t *testing.T) {
+	cases := map[string]struct {
+		splits []ServiceSplit
+		expErr string
+	}{
+		"valid": {
+			splits: []ServiceSplit{
+				{Weight: 33.33, ServiceSubset: "v1"},
+				{Weight: 33.33, ServiceSubset: "v2"},
+				{Weight: 33.34, Service: "bar"},
+			},
+		},
+		"single split": {
+			splits: []ServiceSplit{{Weight: 100}},
+		},
+		"zero weight split": {
+			splits: []ServiceSplit{{Weight: 100, ServiceSubset: "v1"}, {Weight: 0, ServiceSubset: "v2"}},
+		},
+		"no splits": {
+			expErr: "spec.splits must have at least one split",
+		},
+		"negative weight": {
+			splits: []ServiceSplit{{Weight: 110, ServiceSubset: "v1"}, {Weight: -10, ServiceSubset: "v2"}},
+			expErr: "spec.splits[0].weight must be between 0 and 100, got 110",
+		},
+		"duplicate split": {
+			splits: []ServiceSplit{{Weight: 50, ServiceSubset: "v1"}, {Weight: 50, ServiceSubset: "v1"}},
+			expErr: "spec.splits[1] has the same service, serviceSubset and namespace as a previous split",
+		},
+		"weights don't add up to 100": {
+			splits: []ServiceSplit{{Weight: 50, ServiceSubset: "v1"}, {Weight: 49.99, ServiceSubset: "v2"}},
+			expErr: "spec.splits weights must add up to 100, got 99.99",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &ServiceSplitter{
+				ObjectMeta: metav1.ObjectMeta{Name: "foo"},
+				Spec:       ServiceSplitterSpec{Splits: c.splits},
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
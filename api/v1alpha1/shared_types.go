@@ -0,0 +1,286 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConfigEntryResource is implemented by the custom resources that are
+// reconciled into Consul config entries.
+type ConfigEntryResource interface {
+	metav1.Object
+
+	// ConsulKind returns the kind of the config entry, e.g. service-defaults.
+	ConsulKind() string
+
+	// ConsulName returns the name of the config entry.
+	ConsulName() string
+
+	// ToConsul returns the config entry for the resource in the given
+	// Consul namespace. The namespace is empty if namespaces are disabled.
+	ToConsul(namespace string) api.ConfigEntry
+
+	// MatchesConsul returns true if the config entry read from Consul
+	// has the configuration of the resource.
+	MatchesConsul(entry api.ConfigEntry) bool
+
+	// Validate returns an error if the resource can't be written to Consul.
+	Validate() error
+
+	// ResourceStatus returns the status of the resource.
+	ResourceStatus() *Status
+
+	// ConsulTarget returns the Consul namespace and partition that the
+	// resource's spec writes the config entry to. Empty fields are the
+	// controller's defaults.
+	ConsulTarget() ConsulTarget
+}
+
+// DefaultPartition is the name of Consul's default admin partition.
+const DefaultPartition = "default"
+
+// ConsulTarget is the Consul namespace and admin partition of a config
+// entry. The controllers only allow the resources of the Kubernetes
+// namespaces they're configured with to target namespaces and partitions
+// other than their defaults.
+type ConsulTarget struct {
+	// Namespace is the Consul namespace to write the config entry to. It
+	// defaults to the namespace given by the controller's namespace
+	// options, e.g. the mirrored namespace of the resource. Requires
+	// Consul Enterprise with namespaces enabled.
+	Namespace string `json:"namespace,omitempty"`
+	// Partition is the Consul admin partition to write the config entry
+	// to. It defaults to the partition of the controller. Requires Consul
+	// Enterprise 1.11+.
+	Partition string `json:"partition,omitempty"`
+}
+
+// RawConfigEntryResource is implemented by the custom resources whose
+// config entry kind isn't supported by the Consul API client. Their config
+// entries are read from Consul into the entry returned by NewConsulEntry.
+type RawConfigEntryResource interface {
+	ConfigEntryResource
+
+	// NewConsulEntry returns an empty config entry of the resource's kind.
+	NewConsulEntry() api.ConfigEntry
+}
+
+// ServiceLinkingResource is implemented by the custom resources whose
+// config entries reference services that must be registered in Consul.
+// The controllers report the services that aren't registered in the
+// resource's LinkedServicesResolved condition.
+type ServiceLinkingResource interface {
+	ConfigEntryResource
+
+	// LinkedServices returns the referenced services. An empty namespace
+	// is the namespace of the config entry.
+	LinkedServices() []ServiceRef
+}
+
+// ServiceRef references a Consul service.
+type ServiceRef struct {
+	Name      string
+	Namespace string
+}
+
+// SubsetReferencingResource is implemented by the custom resources whose
+// config entries reference subsets of services. Consul rejects their
+// config entries if a subset isn't defined by the service-resolver of its
+// service, which the validating webhook checks.
+type SubsetReferencingResource interface {
+	ConfigEntryResource
+
+	// SubsetRefs returns the referenced subsets.
+	SubsetRefs() []SubsetRef
+}
+
+// SubsetRef references a subset of a Consul service.
+type SubsetRef struct {
+	// Path is the path of the reference in the resource, e.g.
+	// spec.splits[0].
+	Path string
+	// Service is the name of the service.
+	Service string
+	// Namespace is the Consul namespace of the service. If it's empty,
+	// it's the namespace of the config entry.
+	Namespace string
+	// Subset is the name of the subset.
+	Subset string
+}
+
+// Defaulter is implemented by the custom resources that have defaults.
+// The defaults are set by the defaulting webhook, and by the controllers
+// in case the webhook isn't installed.
+type Defaulter interface {
+	// Default sets the defaults of the resource's unset fields.
+	Default()
+}
+
+const (
+	// MigrateEntryKey is the annotation of the resources allowed to take
+	// over a config entry that already exists in Consul, e.g. one created
+	// with the Consul CLI before migrating to Kubernetes.
+	MigrateEntryKey = "consul.hashicorp.com/migrate-entry"
+
+	// MigrateEntryTrue is the value of the MigrateEntryKey annotation
+	// allowing the migration.
+	MigrateEntryTrue = "true"
+)
+
+// ConditionSynced is the type of the condition reporting whether the
+// resource has been synced to Consul.
+const ConditionSynced = "Synced"
+
+// ConditionLinkedServicesResolved is the type of the condition reporting
+// whether the services referenced by a ServiceLinkingResource are
+// registered in Consul.
+const ConditionLinkedServicesResolved = "LinkedServicesResolved"
+
+// Condition is a condition of a resource's status.
+type Condition struct {
+	// Type of the condition.
+	Type string `json:"type"`
+	// Status of the condition, one of True, False or Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition changed status.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a one-word CamelCase reason for the condition's status.
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the condition's status.
+	Message string `json:"message,omitempty"`
+}
+
+// Status is the status of the custom resources.
+type Status struct {
+	Conditions []Condition `json:"conditions,omitempty"`
+	// LastSyncedTime is the last time the resource was written to Consul.
+	LastSyncedTime *metav1.Time `json:"lastSyncedTime,omitempty"`
+	// ConsulNamespace is the Consul namespace the config entry was
+	// written to. It's empty if namespaces are disabled.
+	ConsulNamespace string `json:"consulNamespace,omitempty"`
+	// ConsulPartition is the Consul admin partition the config entry was
+	// written to. It's empty if it's the partition of the controller.
+	ConsulPartition string `json:"consulPartition,omitempty"`
+}
+
+// HasSynced returns true if the resource has been written to Consul.
+// Resources synced before LastSyncedTime was reported have a true Synced
+// condition.
+func (s *Status) HasSynced() bool {
+	if s.LastSyncedTime != nil {
+		return true
+	}
+	synced := s.GetCondition(ConditionSynced)
+	return synced != nil && synced.Status == corev1.ConditionTrue
+}
+
+// GetCondition returns the condition of the given type or nil if the
+// status has none.
+func (s *Status) GetCondition(conditionType string) *Condition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition sets the condition of the given type. The transition time
+// is only updated if the condition's status changes. It returns false if
+// the status already had the same condition.
+func (s *Status) SetCondition(conditionType string, status corev1.ConditionStatus, reason, message string) bool {
+	if existing := s.GetCondition(conditionType); existing != nil {
+		if existing.Status == status && existing.Reason == reason && existing.Message == message {
+			return false
+		}
+		if existing.Status != status {
+			existing.LastTransitionTime = metav1.Now()
+		}
+		existing.Status = status
+		existing.Reason = reason
+		existing.Message = message
+		return true
+	}
+	s.Conditions = append(s.Conditions, Condition{
+		Type:               conditionType,
+		Status:             status,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	})
+	return true
+}
+
+// MeshGatewayConfig controls how mesh gateways are used for upstreams.
+type MeshGatewayConfig struct {
+	// Mode is the mode that should be used for the upstream connection,
+	// one of "", "none", "local" or "remote".
+	Mode string `json:"mode,omitempty"`
+}
+
+func (in MeshGatewayConfig) toConsul() api.MeshGatewayConfig {
+	return api.MeshGatewayConfig{Mode: api.MeshGatewayMode(in.Mode)}
+}
+
+func (in MeshGatewayConfig) validate() error {
+	switch api.MeshGatewayMode(in.Mode) {
+	case api.MeshGatewayModeDefault, api.MeshGatewayModeNone, api.MeshGatewayModeLocal, api.MeshGatewayModeRemote:
+		return nil
+	}
+	return fmt.Errorf("meshGateway.mode must be one of \"\", %q, %q or %q, got %q",
+		api.MeshGatewayModeNone, api.MeshGatewayModeLocal, api.MeshGatewayModeRemote, in.Mode)
+}
+
+// ExposeConfig describes HTTP paths to expose through Envoy outside of
+// Connect.
+type ExposeConfig struct {
+	// Checks defines whether paths associated with Consul checks will be
+	// exposed.
+	Checks bool `json:"checks,omitempty"`
+	// Paths is the list of paths exposed through the proxy.
+	Paths []ExposePath `json:"paths,omitempty"`
+}
+
+// ExposePath is a path exposed through the proxy.
+type ExposePath struct {
+	// ListenerPort is the port the proxy listens on for the path.
+	ListenerPort int `json:"listenerPort,omitempty"`
+	// Path is the path to expose, e.g. /metrics.
+	Path string `json:"path,omitempty"`
+	// LocalPathPort is the port the service listens on for the path.
+	LocalPathPort int `json:"localPathPort,omitempty"`
+	// Protocol of the path, one of "http" or "http2".
+	Protocol string `json:"protocol,omitempty"`
+}
+
+func (in ExposeConfig) toConsul() api.ExposeConfig {
+	var paths []api.ExposePath
+	for _, path := range in.Paths {
+		paths = append(paths, api.ExposePath{
+			ListenerPort:  path.ListenerPort,
+			Path:          path.Path,
+			LocalPathPort: path.LocalPathPort,
+			Protocol:      path.Protocol,
+		})
+	}
+	return api.ExposeConfig{
+		Checks: in.Checks,
+		Paths:  paths,
+	}
+}
+
+func (in ExposeConfig) validate() error {
+	for i, path := range in.Paths {
+		if !strings.HasPrefix(path.Path, "/") {
+			return fmt.Errorf("expose.paths[%d].path must begin with '/', got %q", i, path.Path)
+		}
+		if path.Protocol != "" && path.Protocol != "http" && path.Protocol != "http2" {
+			return fmt.Errorf("expose.paths[%d].protocol must be one of \"http\" or \"http2\", got %q", i, path.Protocol)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package v1alpha1
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStatus_SetCondition(t *testing.T) {
+	var status Status
+	require.Nil(t, status.GetCondition(ConditionSynced))
+
+	require.True(t, status.SetCondition(ConditionSynced, corev1.ConditionFalse, "ConsulAgentError", "error"))
+	condition := status.GetCondition(ConditionSynced)
+	require.NotNil(t, condition)
+	require.Equal(t, corev1.ConditionFalse, condition.Status)
+	transitionTime := condition.LastTransitionTime
+
+	// Setting the same condition again doesn't change it.
+	require.False(t, status.SetCondition(ConditionSynced, corev1.ConditionFalse, "ConsulAgentError", "error"))
+
+	// Changing the message keeps the transition time.
+	require.True(t, status.SetCondition(ConditionSynced, corev1.ConditionFalse, "ConsulAgentError", "other error"))
+	require.Equal(t, transitionTime, status.GetCondition(ConditionSynced).LastTransitionTime)
+	require.Equal(t, "other error", status.GetCondition(ConditionSynced).Message)
+
+	require.True(t, status.SetCondition(ConditionSynced, corev1.ConditionTrue, "Synced", ""))
+	require.Len(t, status.Conditions, 1)
+	require.Equal(t, corev1.ConditionTrue, status.GetCondition(ConditionSynced).Status)
+}
+
+func TestStatus_HasSynced(t *testing.T) {
+	var status Status
+	require.False(t, status.HasSynced())
+	status.SetCondition(ConditionSynced, corev1.ConditionFalse, "InvalidConfig", "")
+	require.False(t, status.HasSynced())
+
+	// Resources synced before the sync time was reported.
+	status.SetCondition(ConditionSynced, corev1.ConditionTrue, "Synced", "")
+	require.True(t, status.HasSynced())
+
+	now := metav1.Now()
+	status = Status{LastSyncedTime: &now}
+	require.True(t, status.HasSynced())
+}
+
+// The target fields are inlined in the specs of namespaced resources.
+func TestConsulTarget_Inline(t *testing.T) {
+	var resource ServiceDefaults
+	data := `{"spec": {"namespace": "billing", "partition": "web", "protocol": "http"}}`
+	require.NoError(t, json.Unmarshal([]byte(data), &resource))
+	require.Equal(t, ConsulTarget{Namespace: "billing", Partition: "web"}, resource.ConsulTarget())
+	require.Equal(t, "http", resource.Spec.Protocol)
+
+	out, err := json.Marshal(&ServiceDefaults{})
+	require.NoError(t, err)
+	require.NotContains(t, string(out), "partition")
+}
@@ -0,0 +1,187 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// TerminatingGatewayResource is the resource name of TerminatingGateway.
+const TerminatingGatewayResource = "terminatinggateways"
+
+// TerminatingGatewayTLSDir is the directory of the terminating gateway pods
+// where the sds-server sidecar writes the TLS secrets of the linked
+// services, one directory per secret.
+const TerminatingGatewayTLSDir = "/consul/terminating-gateway/tls"
+
+// TerminatingGateway is the Schema for the terminatinggateways API. It is
+// reconciled into the terminating-gateway config entry of the gateway
+// service with the resource's name, which requires Consul 1.8 or later.
+// Its LinkedServicesResolved condition reports the linked services that
+// aren't registered in Consul.
+type TerminatingGateway struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TerminatingGatewaySpec `json:"spec,omitempty"`
+	Status Status                 `json:"status,omitempty"`
+}
+
+// TerminatingGatewaySpec defines the desired state of TerminatingGateway.
+type TerminatingGatewaySpec struct {
+	ConsulTarget `json:",inline"`
+
+	// Services is a list of service names represented by the terminating
+	// gateway.
+	Services []LinkedServiceSpec `json:"services,omitempty"`
+}
+
+// LinkedServiceSpec is a service linked to the terminating gateway.
+type LinkedServiceSpec struct {
+	// Name is the name of the service, as defined in Consul's catalog.
+	// It can be set to "*" to link all the services of the namespace.
+	Name string `json:"name"`
+	// Namespace is the Consul namespace of the service.
+	Namespace string `json:"namespace,omitempty"`
+	// CAFile is the optional path to a CA certificate to use for TLS
+	// connections from the gateway to the linked service.
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile is the optional path to a client certificate to use for
+	// TLS connections from the gateway to the linked service.
+	CertFile string `json:"certFile,omitempty"`
+	// KeyFile is the optional path to a private key to use for TLS
+	// connections from the gateway to the linked service.
+	KeyFile string `json:"keyFile,omitempty"`
+	// SNI is the optional hostname to specify during the TLS handshake
+	// with a linked service.
+	SNI string `json:"sni,omitempty"`
+	// TLSSecretName is the name of a kubernetes.io/tls secret in the
+	// namespace of the resource, e.g. of a cert-manager certificate,
+	// holding the CA certificate (ca.crt), client certificate and private
+	// key the gateway originates mutual TLS to the linked service with.
+	// The sds-server sidecar of the gateway writes it to
+	// TerminatingGatewayTLSDir, so it can't be set with the files.
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+}
+
+// tlsFiles returns the CA, certificate and key files of the service,
+// which are the files of its TLS secret if it's set.
+func (in LinkedServiceSpec) tlsFiles() (caFile, certFile, keyFile string) {
+	if in.TLSSecretName == "" {
+		return in.CAFile, in.CertFile, in.KeyFile
+	}
+	dir := TerminatingGatewayTLSDir + "/" + in.TLSSecretName + "/"
+	return dir + "ca.crt", dir + "tls.crt", dir + "tls.key"
+}
+
+func (in *TerminatingGateway) ConsulKind() string {
+	return TerminatingGatewayKind
+}
+
+func (in *TerminatingGateway) ConsulName() string {
+	return in.Name
+}
+
+func (in *TerminatingGateway) ResourceStatus() *Status {
+	return &in.Status
+}
+
+func (in *TerminatingGateway) ConsulTarget() ConsulTarget {
+	return in.Spec.ConsulTarget
+}
+
+func (in *TerminatingGateway) NewConsulEntry() api.ConfigEntry {
+	return &TerminatingGatewayConfigEntry{}
+}
+
+// LinkedServices returns the linked services, except for wildcards.
+func (in *TerminatingGateway) LinkedServices() []ServiceRef {
+	var refs []ServiceRef
+	for _, service := range in.Spec.Services {
+		if service.Name != "*" {
+			refs = append(refs, ServiceRef{Name: service.Name, Namespace: service.Namespace})
+		}
+	}
+	return refs
+}
+
+func (in *TerminatingGateway) ToConsul(namespace string) api.ConfigEntry {
+	entry := &TerminatingGatewayConfigEntry{
+		Kind:      in.ConsulKind(),
+		Name:      in.ConsulName(),
+		Namespace: namespace,
+	}
+	for _, service := range in.Spec.Services {
+		caFile, certFile, keyFile := service.tlsFiles()
+		entry.Services = append(entry.Services, LinkedService{
+			Name:      service.Name,
+			Namespace: service.Namespace,
+			CAFile:    caFile,
+			CertFile:  certFile,
+			KeyFile:   keyFile,
+			SNI:       service.SNI,
+		})
+	}
+	return entry
+}
+
+func (in *TerminatingGateway) MatchesConsul(entry api.ConfigEntry) bool {
+	terminatingGateway, ok := entry.(*TerminatingGatewayConfigEntry)
+	if !ok {
+		return false
+	}
+	actual := *terminatingGateway
+	actual.CreateIndex = 0
+	actual.ModifyIndex = 0
+	if len(actual.Services) == 0 {
+		actual.Services = nil
+	}
+
+	// Consul Enterprise defaults the services' namespace to the entry's
+	// namespace.
+	expected := in.ToConsul(actual.Namespace).(*TerminatingGatewayConfigEntry)
+	for i := range expected.Services {
+		if expected.Services[i].Namespace == "" {
+			expected.Services[i].Namespace = actual.Namespace
+		}
+	}
+	return reflect.DeepEqual(expected, &actual)
+}
+
+func (in *TerminatingGateway) Validate() error {
+	type serviceKey struct {
+		name, namespace string
+	}
+	found := make(map[serviceKey]bool)
+	for i, service := range in.Spec.Services {
+		path := fmt.Sprintf("spec.services[%d]", i)
+		if service.Name == "" {
+			return fmt.Errorf("%s.name must be set", path)
+		}
+		key := serviceKey{service.Name, service.Namespace}
+		if found[key] {
+			return fmt.Errorf("%s: service %q is listed more than once", path, service.Name)
+		}
+		found[key] = true
+
+		if service.TLSSecretName != "" {
+			if service.CAFile != "" || service.CertFile != "" || service.KeyFile != "" {
+				return fmt.Errorf("%s.tlsSecretName can't be set with caFile, certFile or keyFile", path)
+			}
+			if errs := validation.IsDNS1123Subdomain(service.TLSSecretName); len(errs) > 0 {
+				return fmt.Errorf("%s.tlsSecretName %q is invalid: %s", path, service.TLSSecretName, errs[0])
+			}
+		}
+
+		// A CA file alone is enough for one-way TLS, but mutual TLS
+		// requires all three files.
+		if (service.CertFile != "" || service.KeyFile != "") &&
+			(service.CAFile == "" || service.CertFile == "" || service.KeyFile == "") {
+			return fmt.Errorf("%s must set caFile, certFile and keyFile for mutual TLS", path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTerminatingGateway_ToConsul(t *testing.T) {
+	resource := &TerminatingGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "terminating-gateway"},
+		Spec: TerminatingGatewaySpec{
+			Services: []LinkedServiceSpec{
+				{Name: "db", CAFile: "/etc/ca.pem", CertFile: "/etc/cert.pem", KeyFile: "/etc/key.pem", SNI: "db.example.com"},
+				{Name: "*", Namespace: "legacy"},
+				{Name: "api", TLSSecretName: "api-client", SNI: "api.example.com"},
+			},
+		},
+	}
+	require.Equal(t, &TerminatingGatewayConfigEntry{
+		Kind:      TerminatingGatewayKind,
+		Name:      "terminating-gateway",
+		Namespace: "consul-ns",
+		Services: []LinkedService{
+			{Name: "db", CAFile: "/etc/ca.pem", CertFile: "/etc/cert.pem", KeyFile: "/etc/key.pem", SNI: "db.example.com"},
+			{Name: "*", Namespace: "legacy"},
+			{
+				Name:     "api",
+				CAFile:   "/consul/terminating-gateway/tls/api-client/ca.crt",
+				CertFile: "/consul/terminating-gateway/tls/api-client/tls.crt",
+				KeyFile:  "/consul/terminating-gateway/tls/api-client/tls.key",
+				SNI:      "api.example.com",
+			},
+		},
+	}, resource.ToConsul("consul-ns"))
+	require.Equal(t, []ServiceRef{{Name: "db"}, {Name: "api"}}, resource.LinkedServices())
+}
+
+func TestTerminatingGateway_MatchesConsul(t *testing.T) {
+	resource := &TerminatingGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "terminating-gateway"},
+		Spec: TerminatingGatewaySpec{
+			Services: []LinkedServiceSpec{{Name: "db", CAFile: "/etc/ca.pem"}},
+		},
+	}
+	// Consul Enterprise defaults the services' namespace.
+	require.True(t, resource.MatchesConsul(&TerminatingGatewayConfigEntry{
+		Kind:        TerminatingGatewayKind,
+		Name:        "terminating-gateway",
+		Namespace:   "ns",
+		Services:    []LinkedService{{Name: "db", Namespace: "ns", CAFile: "/etc/ca.pem"}},
+		ModifyIndex: 10,
+	}))
+	require.False(t, resource.MatchesConsul(&TerminatingGatewayConfigEntry{
+		Kind:     TerminatingGatewayKind,
+		Name:     "terminating-gateway",
+		Services: []LinkedService{{Name: "db"}},
+	}))
+	require.False(t, resource.MatchesConsul(&api.ServiceConfigEntry{Kind: api.ServiceDefaults, Name: "terminating-gateway"}))
+
+	empty := &TerminatingGateway{ObjectMeta: metav1.ObjectMeta{Name: "terminating-gateway"}}
+	require.True(t, empty.MatchesConsul(&TerminatingGatewayConfigEntry{
+		Kind:     TerminatingGatewayKind,
+		Name:     "terminating-gateway",
+		Services: []LinkedService{},
+	}))
+}
+
+func TestTerminatingGateway_Validate(t *testing.T) {
+	cases := map[string]struct {
+		services []LinkedServiceSpec
+		expErr   string
+	}{
+		"valid": {
+			services: []LinkedServiceSpec{
+				{Name: "db", CAFile: "/etc/ca.pem", CertFile: "/etc/cert.pem", KeyFile: "/etc/key.pem"},
+				{Name: "db", Namespace: "other", CAFile: "/etc/ca.pem"},
+				{Name: "*", Namespace: "legacy", SNI: "example.com"},
+				{Name: "api", TLSSecretName: "api-client"},
+			},
+		},
+		"no name": {
+			services: []LinkedServiceSpec{{CAFile: "/etc/ca.pem"}},
+			expErr:   "spec.services[0].name must be set",
+		},
+		"duplicate service": {
+			services: []LinkedServiceSpec{{Name: "db"}, {Name: "db"}},
+			expErr:   `spec.services[1]: service "db" is listed more than once`,
+		},
+		"cert without key": {
+			services: []LinkedServiceSpec{{Name: "db", CAFile: "/etc/ca.pem", CertFile: "/etc/cert.pem"}},
+			expErr:   "spec.services[0] must set caFile, certFile and keyFile for mutual TLS",
+		},
+		"secret with files": {
+			services: []LinkedServiceSpec{{Name: "db", TLSSecretName: "db-client", CAFile: "/etc/ca.pem"}},
+			expErr:   "spec.services[0].tlsSecretName can't be set with caFile, certFile or keyFile",
+		},
+		"invalid secret name": {
+			services: []LinkedServiceSpec{{Name: "db", TLSSecretName: "../db"}},
+			expErr:   `spec.services[0].tlsSecretName "../db" is invalid`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			resource := &TerminatingGateway{
+				ObjectMeta: metav1.ObjectMeta{Name: "terminating-gateway"},
+				Spec:       TerminatingGatewaySpec{Services: c.services},
+			}
+			err := resource.Validate()
+			if c.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package v1beta1
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// conversion converts the unstructured content of the resources of a kind
+// between v1alpha1 and v1beta1.
+type conversion struct {
+	fromV1alpha1 func(obj map[string]interface{}) error
+	toV1alpha1   func(obj map[string]interface{}) error
+}
+
+// conversions are the conversions of the kinds whose v1beta1 schema
+// differs from their v1alpha1 schema, keyed by kind. The resources of the
+// other kinds are converted by setting their apiVersion.
+var conversions = map[string]conversion{}
+
+// Convert converts the custom resource to the version of the group. The
+// resource is converted to v1beta1 first, so that each version only
+// converts to and from the hub.
+func Convert(obj *unstructured.Unstructured, version string) error {
+	gv, err := schema.ParseGroupVersion(obj.GetAPIVersion())
+	if err != nil {
+		return err
+	}
+	if gv.Group != v1alpha1.Group {
+		return fmt.Errorf("unsupported group %q", gv.Group)
+	}
+	for _, v := range []string{gv.Version, version} {
+		if v != v1alpha1.Version && v != Version {
+			return fmt.Errorf("unsupported version %q", v)
+		}
+	}
+
+	conv := conversions[obj.GetKind()]
+	if gv.Version == v1alpha1.Version && version != v1alpha1.Version && conv.fromV1alpha1 != nil {
+		if err := conv.fromV1alpha1(obj.Object); err != nil {
+			return fmt.Errorf("converting %s %q from %s: %s", obj.GetKind(), obj.GetName(), v1alpha1.Version, err)
+		}
+	}
+	if version == v1alpha1.Version && gv.Version != v1alpha1.Version && conv.toV1alpha1 != nil {
+		if err := conv.toV1alpha1(obj.Object); err != nil {
+			return fmt.Errorf("converting %s %q to %s: %s", obj.GetKind(), obj.GetName(), v1alpha1.Version, err)
+		}
+	}
+	obj.SetAPIVersion(schema.GroupVersion{Group: v1alpha1.Group, Version: version}.String())
+	return nil
+}
@@ -0,0 +1,158 @@
+package v1beta1
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	apiresource "k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// resource is a v1alpha1 custom resource.
+type resource interface {
+	GetObjectKind() schema.ObjectKind
+}
+
+// Test that converting fuzzed resources of every kind to v1beta1 and back
+// to v1alpha1 doesn't change them.
+func TestConvert_RoundTrip(t *testing.T) {
+	kinds := map[string]func() resource{
+		"ServiceDefaults":    func() resource { return &v1alpha1.ServiceDefaults{} },
+		"ProxyDefaults":      func() resource { return &v1alpha1.ProxyDefaults{} },
+		"ServiceResolver":    func() resource { return &v1alpha1.ServiceResolver{} },
+		"ServiceRouter":      func() resource { return &v1alpha1.ServiceRouter{} },
+		"ServiceSplitter":    func() resource { return &v1alpha1.ServiceSplitter{} },
+		"ServiceIntentions":  func() resource { return &v1alpha1.ServiceIntentions{} },
+		"IngressGateway":     func() resource { return &v1alpha1.IngressGateway{} },
+		"TerminatingGateway": func() resource { return &v1alpha1.TerminatingGateway{} },
+		"Mesh":               func() resource { return &v1alpha1.Mesh{} },
+		"ExportedServices":   func() resource { return &v1alpha1.ExportedServices{} },
+		"SamenessGroup":      func() resource { return &v1alpha1.SamenessGroup{} },
+		"JWTProvider":        func() resource { return &v1alpha1.JWTProvider{} },
+		"PeeringAcceptor":    func() resource { return &v1alpha1.PeeringAcceptor{} },
+		"PeeringDialer":      func() resource { return &v1alpha1.PeeringDialer{} },
+		"Registration":       func() resource { return &v1alpha1.Registration{} },
+		"GatewayClassConfig": func() resource { return &v1alpha1.GatewayClassConfig{} },
+		"RouteTimeoutFilter": func() resource { return &v1alpha1.RouteTimeoutFilter{} },
+		"RouteRetryFilter":   func() resource { return &v1alpha1.RouteRetryFilter{} },
+		"RouteAuthFilter":    func() resource { return &v1alpha1.RouteAuthFilter{} },
+		"RateLimitPolicy":    func() resource { return &v1alpha1.RateLimitPolicy{} },
+	}
+	fuzzer := fuzz.New().NilChance(0.2).Funcs(
+		func(meta *metav1.ObjectMeta, c fuzz.Continue) {
+			meta.Name = c.RandString()
+			meta.Namespace = c.RandString()
+			c.Fuzz(&meta.Labels)
+			c.Fuzz(&meta.Annotations)
+		},
+		// Times are encoded with a precision of seconds.
+		func(t *metav1.Time, c fuzz.Continue) {
+			*t = metav1.Unix(c.Int63n(1<<32), 0)
+		},
+		// Quantities are compared as decoded from their canonical string,
+		// which they cache once encoded.
+		func(q *apiresource.Quantity, c fuzz.Continue) {
+			*q = apiresource.MustParse(apiresource.NewMilliQuantity(c.Int63n(1<<32), apiresource.DecimalSI).String())
+			_ = q.String()
+		},
+		// Arbitrary values are decoded from JSON, e.g. the proxy config.
+		func(m *map[string]interface{}, c fuzz.Continue) {
+			*m = map[string]interface{}{c.RandString(): c.RandString()}
+		},
+	)
+
+	for kind, newResource := range kinds {
+		t.Run(kind, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				original := newResource()
+				fuzzer.Fuzz(original)
+				original.GetObjectKind().SetGroupVersionKind(v1alpha1.GroupVersion.WithKind(kind))
+
+				data, err := json.Marshal(original)
+				require.NoError(t, err)
+				obj := &unstructured.Unstructured{}
+				require.NoError(t, obj.UnmarshalJSON(data))
+
+				require.NoError(t, Convert(obj, Version))
+				require.Equal(t, GroupVersion.String(), obj.GetAPIVersion())
+				require.NoError(t, Convert(obj, v1alpha1.Version))
+				require.Equal(t, v1alpha1.GroupVersion.String(), obj.GetAPIVersion())
+
+				data, err = obj.MarshalJSON()
+				require.NoError(t, err)
+				converted := newResource()
+				require.NoError(t, json.Unmarshal(data, converted))
+				require.True(t, reflect.DeepEqual(original, converted), "%#v\n!=\n%#v", original, converted)
+			}
+		})
+	}
+}
+
+func TestConvert_Errors(t *testing.T) {
+	cases := map[string]struct {
+		apiVersion string
+		version    string
+		expErr     string
+	}{
+		"unsupported group": {
+			apiVersion: "apps/v1",
+			version:    Version,
+			expErr:     `unsupported group "apps"`,
+		},
+		"unsupported source version": {
+			apiVersion: "consul.hashicorp.com/v2",
+			version:    Version,
+			expErr:     `unsupported version "v2"`,
+		},
+		"unsupported desired version": {
+			apiVersion: "consul.hashicorp.com/v1alpha1",
+			version:    "v2",
+			expErr:     `unsupported version "v2"`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{}
+			obj.SetAPIVersion(c.apiVersion)
+			obj.SetKind("ServiceDefaults")
+			require.EqualError(t, Convert(obj, c.version), c.expErr)
+		})
+	}
+}
+
+// Test that the conversions of a kind are only applied when converting
+// to and from v1alpha1.
+func TestConvert_Conversion(t *testing.T) {
+	conversions["Renamed"] = conversion{
+		fromV1alpha1: func(obj map[string]interface{}) error {
+			return unstructured.SetNestedField(obj, "v1beta1", "spec", "field")
+		},
+		toV1alpha1: func(obj map[string]interface{}) error {
+			return unstructured.SetNestedField(obj, "v1alpha1", "spec", "field")
+		},
+	}
+	defer delete(conversions, "Renamed")
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(v1alpha1.GroupVersion.String())
+	obj.SetKind("Renamed")
+	require.NoError(t, Convert(obj, Version))
+	field, _, _ := unstructured.NestedString(obj.Object, "spec", "field")
+	require.Equal(t, "v1beta1", field)
+
+	unstructured.RemoveNestedField(obj.Object, "spec", "field")
+	require.NoError(t, Convert(obj, Version))
+	_, found, _ := unstructured.NestedString(obj.Object, "spec", "field")
+	require.False(t, found)
+
+	require.NoError(t, Convert(obj, v1alpha1.Version))
+	field, _, _ = unstructured.NestedString(obj.Object, "spec", "field")
+	require.Equal(t, "v1alpha1", field)
+}
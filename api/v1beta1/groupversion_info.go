@@ -0,0 +1,20 @@
+// Package v1beta1 contains the v1beta1 version of the consul.hashicorp.com
+// API group. It's the storage version of the CRDs and the hub version that
+// the conversion webhook converts the other versions to and from.
+//
+// Its schemas are the v1alpha1 schemas so far, so its resources are
+// decoded into the v1alpha1 types and converting them only sets their
+// apiVersion. A kind whose schema changes registers the conversion of its
+// unstructured content in conversions.
+package v1beta1
+
+import (
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Version is the API version of this package.
+const Version = "v1beta1"
+
+// GroupVersion is the group version of this package.
+var GroupVersion = schema.GroupVersion{Group: v1alpha1.Group, Version: Version}
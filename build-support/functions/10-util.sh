@@ -514,8 +514,9 @@ function update_git_env {
    export GIT_COMMIT=$(git rev-parse --short HEAD)
    export GIT_DIRTY=$(test -n "$(git status --porcelain)" && echo "+CHANGES")
    export GIT_DESCRIBE=$(git describe --tags --always)
+   export BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
    export GIT_IMPORT=github.com/hashicorp/consul-k8s/version
-   export GOLDFLAGS="-X ${GIT_IMPORT}.GitCommit=${GIT_COMMIT}${GIT_DIRTY} -X ${GIT_IMPORT}.GitDescribe=${GIT_DESCRIBE}"
+   export GOLDFLAGS="-X ${GIT_IMPORT}.GitCommit=${GIT_COMMIT}${GIT_DIRTY} -X ${GIT_IMPORT}.GitDescribe=${GIT_DESCRIBE} -X ${GIT_IMPORT}.BuildDate=${BUILD_DATE}"
    return 0
 }
 
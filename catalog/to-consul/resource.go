@@ -2,9 +2,11 @@ package catalog
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/deckarep/golang-set"
 	"github.com/hashicorp/consul-k8s/helper/controller"
@@ -27,8 +29,18 @@ const (
 	// ConsulK8SNS is the key used in the meta to record the namespace
 	// of the service/node registration.
 	ConsulK8SNS = "external-k8s-ns"
+
+	// ConsulK8SRefKind and ConsulK8SRefValue are the keys used in the meta
+	// to record the kind and name of the Kubernetes resource the service
+	// was created from.
+	ConsulK8SRefKind  = "external-k8s-ref-kind"
+	ConsulK8SRefValue = "external-k8s-ref-name"
 )
 
+// syncInterval is the minimum interval between the syncs of Run, so that
+// the changes made in the meantime are synced together.
+const syncInterval = 100 * time.Millisecond
+
 type NodePortSyncType string
 
 const (
@@ -126,6 +138,15 @@ type ServiceResource struct {
 	// It's populated via Consul's API and lets us diff what is actually in
 	// Consul vs. what we expect to be there.
 	consulMap map[string][]*consulapi.CatalogRegistration
+
+	// endpointsInformer is the informer of the endpoints controller started
+	// by Run. Once it has synced, the initial endpoints of the services are
+	// read from its store rather than from the API.
+	endpointsInformer cache.SharedIndexInformer
+
+	// syncCh signals Run to sync the registrations. It's nil until Run
+	// starts, and sync calls the Syncer itself until then.
+	syncCh chan struct{}
 }
 
 // Informer implements the controller.Resource interface.
@@ -181,14 +202,12 @@ func (t *ServiceResource) Upsert(key string, raw interface{}) error {
 
 	// If we care about endpoints, we should do the initial endpoints load.
 	if t.shouldTrackEndpoints(key) {
-		endpoints, err := t.Client.CoreV1().
-			Endpoints(service.Namespace).
-			Get(service.Name, metav1.GetOptions{})
+		endpoints, err := t.initialEndpoints(key, service)
 		if err != nil {
 			t.Log.Warn("error loading initial endpoints",
 				"key", key,
 				"err", err)
-		} else {
+		} else if endpoints != nil {
 			if t.endpointsMap == nil {
 				t.endpointsMap = make(map[string]*apiv1.Endpoints)
 			}
@@ -204,6 +223,26 @@ func (t *ServiceResource) Upsert(key string, raw interface{}) error {
 	return nil
 }
 
+// initialEndpoints returns the endpoints of the service. Once the endpoints
+// informer has synced, they're read from its store so that upserting the
+// services doesn't make a request for each, and are nil if the service has
+// no endpoints yet.
+//
+// Precondition: assumes t.serviceLock is held
+func (t *ServiceResource) initialEndpoints(key string, service *apiv1.Service) (*apiv1.Endpoints, error) {
+	if t.endpointsInformer != nil && t.endpointsInformer.HasSynced() {
+		raw, exists, err := t.endpointsInformer.GetStore().GetByKey(key)
+		if err != nil || !exists {
+			return nil, err
+		}
+		endpoints, _ := raw.(*apiv1.Endpoints)
+		return endpoints, nil
+	}
+	return t.Client.CoreV1().
+		Endpoints(service.Namespace).
+		Get(service.Name, metav1.GetOptions{})
+}
+
 // Delete implements the controller.Resource interface.
 func (t *ServiceResource) Delete(key string) error {
 	t.serviceLock.Lock()
@@ -229,13 +268,43 @@ func (t *ServiceResource) doDelete(key string) {
 	}
 }
 
-// Run implements the controller.Backgrounder interface.
+// Run implements the controller.Backgrounder interface. It runs the
+// endpoints controller and syncs the registrations when they change,
+// coalescing the changes made within syncInterval of each other.
 func (t *ServiceResource) Run(ch <-chan struct{}) {
+	t.serviceLock.Lock()
+	t.syncCh = make(chan struct{}, 1)
+	t.serviceLock.Unlock()
+
 	t.Log.Info("starting runner for endpoints")
-	(&controller.Controller{
-		Log:      t.Log.Named("controller/endpoints"),
-		Resource: &serviceEndpointsResource{Service: t},
-	}).Run(ch)
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		(&controller.Controller{
+			Log:      t.Log.Named("controller/endpoints"),
+			Resource: &serviceEndpointsResource{Service: t},
+		}).Run(ch)
+	}()
+	defer func() { <-doneCh }()
+
+	for {
+		select {
+		case <-ch:
+			return
+		case <-t.syncCh:
+		}
+
+		t.serviceLock.RLock()
+		rs := t.registrations()
+		t.serviceLock.RUnlock()
+		t.Syncer.Sync(rs)
+
+		select {
+		case <-ch:
+			return
+		case <-time.After(syncInterval):
+		}
+	}
 }
 
 // shouldSync returns true if resyncing should be enabled for the given service.
@@ -337,8 +406,10 @@ func (t *ServiceResource) generateRegistrations(key string) {
 		Service: t.addPrefixAndK8SNamespace(svc.Name, svc.Namespace),
 		Tags:    []string{t.ConsulK8STag},
 		Meta: map[string]string{
-			ConsulSourceKey: ConsulSourceValue,
-			ConsulK8SNS:     svc.Namespace,
+			ConsulSourceKey:   ConsulSourceValue,
+			ConsulK8SNS:       svc.Namespace,
+			ConsulK8SRefKind:  "Service",
+			ConsulK8SRefValue: svc.Name,
 		},
 	}
 
@@ -506,6 +577,7 @@ func (t *ServiceResource) generateRegistrations(key string) {
 			return
 		}
 
+		nodes := make(map[string]*apiv1.Node)
 		for _, subset := range endpoints.Subsets {
 			for _, subsetAddr := range subset.Addresses {
 				// Check that the node name exists
@@ -514,11 +586,18 @@ func (t *ServiceResource) generateRegistrations(key string) {
 					continue
 				}
 
-				// Look up the node's ip address by getting node info
-				node, err := t.Client.CoreV1().Nodes().Get(*subsetAddr.NodeName, metav1.GetOptions{})
-				if err != nil {
-					t.Log.Warn("error getting node info", "error", err)
-					continue
+				// Look up the node's ip address by getting node info. The
+				// nodes are only looked up once since a service usually has
+				// several endpoints on the same node.
+				node, ok := nodes[*subsetAddr.NodeName]
+				if !ok {
+					var err error
+					node, err = t.Client.CoreV1().Nodes().Get(*subsetAddr.NodeName, metav1.GetOptions{})
+					if err != nil {
+						t.Log.Warn("error getting node info", "error", err)
+						continue
+					}
+					nodes[*subsetAddr.NodeName] = node
 				}
 
 				// Set the expected node address type
@@ -529,37 +608,29 @@ func (t *ServiceResource) generateRegistrations(key string) {
 					expectedType = apiv1.NodeExternalIP
 				}
 
-				// Find the ip address for the node and
-				// create the Consul service using it
-				var found bool
-				for _, address := range node.Status.Addresses {
-					if address.Type == expectedType {
-						found = true
-						r := baseNode
-						rs := baseService
-						r.Service = &rs
-						r.Service.ID = serviceID(r.Service.Service, subsetAddr.IP)
-						r.Service.Address = address.Address
-
-						t.consulMap[key] = append(t.consulMap[key], &r)
-					}
+				// Find the ip address for the node and create the Consul
+				// service using it. If an ExternalIP wasn't found, and
+				// ExternalFirst is set, use an InternalIP.
+				address, tagged := nodeAddress(node, expectedType, subsetAddr.IP)
+				if address == "" && t.NodePortSync == ExternalFirst {
+					address, tagged = nodeAddress(node, apiv1.NodeInternalIP, subsetAddr.IP)
 				}
-
-				// If an ExternalIP wasn't found, and ExternalFirst is set,
-				// use an InternalIP
-				if t.NodePortSync == ExternalFirst && !found {
-					for _, address := range node.Status.Addresses {
-						if address.Type == apiv1.NodeInternalIP {
-							r := baseNode
-							rs := baseService
-							r.Service = &rs
-							r.Service.ID = serviceID(r.Service.Service, subsetAddr.IP)
-							r.Service.Address = address.Address
-
-							t.consulMap[key] = append(t.consulMap[key], &r)
-						}
+				if address == "" {
+					continue
+				}
+				r := baseNode
+				rs := baseService
+				r.Service = &rs
+				r.Service.ID = serviceID(r.Service.Service, subsetAddr.IP)
+				r.Service.Address = address
+				if len(tagged) > 0 {
+					r.Service.TaggedAddresses = make(map[string]consulapi.ServiceAddress, len(tagged))
+					for name, addr := range tagged {
+						r.Service.TaggedAddresses[name] = consulapi.ServiceAddress{Address: addr, Port: r.Service.Port}
 					}
 				}
+
+				t.consulMap[key] = append(t.consulMap[key], &r)
 			}
 		}
 
@@ -627,21 +698,78 @@ func (t *ServiceResource) generateRegistrations(key string) {
 	}
 }
 
+// nodeAddress returns the address of node of addrType to register the
+// endpoint at endpointIP with, or "" if it has none. On dual-stack nodes,
+// which have an address of each IP family, it's the one of the family of
+// endpointIP, and the addresses of both families are also returned as the
+// lan_ipv4 and lan_ipv6 tagged addresses, or wan_ipv4 and wan_ipv6 for
+// ExternalIPs.
+func nodeAddress(node *apiv1.Node, addrType apiv1.NodeAddressType, endpointIP string) (string, map[string]string) {
+	var address, ipv4, ipv6 string
+	for _, a := range node.Status.Addresses {
+		if a.Type != addrType {
+			continue
+		}
+		if address == "" || (isIPv6(a.Address) == isIPv6(endpointIP) && isIPv6(address) != isIPv6(endpointIP)) {
+			address = a.Address
+		}
+		if isIPv6(a.Address) {
+			if ipv6 == "" {
+				ipv6 = a.Address
+			}
+		} else if ipv4 == "" {
+			ipv4 = a.Address
+		}
+	}
+	if ipv4 == "" || ipv6 == "" {
+		return address, nil
+	}
+	prefix := "lan"
+	if addrType == apiv1.NodeExternalIP {
+		prefix = "wan"
+	}
+	return address, map[string]string{prefix + "_ipv4": ipv4, prefix + "_ipv6": ipv6}
+}
+
+// isIPv6 returns whether addr is an IPv6 address.
+func isIPv6(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() == nil
+}
+
 // sync calls the Syncer.Sync function from the generated registrations.
+// Once Run has started, it signals Run to call it instead, so that the
+// upserts of the services listed at startup don't each copy all the
+// registrations.
 //
 // Precondition: lock must be held
 func (t *ServiceResource) sync() {
-	// NOTE(mitchellh): This isn't the most efficient way to do this and
-	// the times that sync are called are also not the most efficient. All
-	// of these are implementation details so lets improve this later when
-	// it becomes a performance issue and just do the easy thing first.
-	rs := make([]*consulapi.CatalogRegistration, 0, len(t.consulMap)*4)
-	for _, set := range t.consulMap {
-		rs = append(rs, set...)
+	if t.syncCh != nil {
+		select {
+		case t.syncCh <- struct{}{}:
+		default:
+			// A sync is already pending.
+		}
+		return
 	}
 
 	// Sync, which should be non-blocking in real-world cases
-	t.Syncer.Sync(rs)
+	t.Syncer.Sync(t.registrations())
+}
+
+// registrations returns all the generated registrations.
+//
+// Precondition: lock must be held
+func (t *ServiceResource) registrations() []*consulapi.CatalogRegistration {
+	n := 0
+	for _, set := range t.consulMap {
+		n += len(set)
+	}
+	rs := make([]*consulapi.CatalogRegistration, 0, n)
+	for _, set := range t.consulMap {
+		rs = append(rs, set...)
+	}
+	return rs
 }
 
 // serviceEndpointsResource implements controller.Resource and starts
@@ -656,7 +784,7 @@ func (t *serviceEndpointsResource) Informer() cache.SharedIndexInformer {
 	// `shouldTrackEndpoints` function which checks whether the service is marked
 	// to be tracked by the `shouldSync` function which uses the allow and deny
 	// namespace lists.
-	return cache.NewSharedIndexInformer(
+	informer := cache.NewSharedIndexInformer(
 		&cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
 				return t.Service.Client.CoreV1().
@@ -674,6 +802,11 @@ func (t *serviceEndpointsResource) Informer() cache.SharedIndexInformer {
 		0,
 		cache.Indexers{},
 	)
+
+	t.Service.serviceLock.Lock()
+	t.Service.endpointsInformer = informer
+	t.Service.serviceLock.Unlock()
+	return informer
 }
 
 func (t *serviceEndpointsResource) Upsert(key string, raw interface{}) error {
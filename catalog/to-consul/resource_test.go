@@ -1,11 +1,13 @@
 package catalog
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/deckarep/golang-set"
 	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/require"
@@ -906,6 +908,117 @@ func TestServiceResource_nodePort_externalFirstSync(t *testing.T) {
 	require.NotEqual(actual[0].Service.ID, actual[1].Service.ID)
 }
 
+// Test that the services of the endpoints on dual-stack nodes are registered
+// once, with the node address of the family of the endpoint and both node
+// addresses as tagged addresses.
+func TestServiceResource_nodePort_dualStack(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	client := fake.NewSimpleClientset()
+	syncer := &TestSyncer{}
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.NodePortSync = InternalOnly
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	node1, node2 := createNodes(t, client)
+	node1.Status.Addresses = append(node1.Status.Addresses, apiv1.NodeAddress{Type: apiv1.NodeInternalIP, Address: "fd00::4"})
+	_, err := client.CoreV1().Nodes().UpdateStatus(node1)
+	require.NoError(err)
+	node2.Status.Addresses = []apiv1.NodeAddress{
+		{Type: apiv1.NodeInternalIP, Address: "fd00::3"},
+		{Type: apiv1.NodeInternalIP, Address: "3.4.5.6"},
+	}
+	_, err = client.CoreV1().Nodes().UpdateStatus(node2)
+	require.NoError(err)
+
+	nodeName1, nodeName2 := node1.Name, node2.Name
+	_, err = client.CoreV1().Endpoints(metav1.NamespaceDefault).Create(&apiv1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: metav1.NamespaceDefault},
+		Subsets: []apiv1.EndpointSubset{
+			{Addresses: []apiv1.EndpointAddress{{NodeName: &nodeName1, IP: "1.1.1.1"}}},
+			{Addresses: []apiv1.EndpointAddress{{NodeName: &nodeName2, IP: "fd01::2"}}},
+		},
+	})
+	require.NoError(err)
+
+	// Insert the service
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Create(nodePortService("foo", metav1.NamespaceDefault))
+	require.NoError(err)
+
+	// Wait a bit
+	time.Sleep(300 * time.Millisecond)
+
+	// Verify what we got
+	syncer.Lock()
+	defer syncer.Unlock()
+	actual := syncer.Registrations
+	require.Len(actual, 2)
+	require.Equal("4.5.6.7", actual[0].Service.Address)
+	require.Equal(map[string]api.ServiceAddress{
+		"lan_ipv4": {Address: "4.5.6.7", Port: 30000},
+		"lan_ipv6": {Address: "fd00::4", Port: 30000},
+	}, actual[0].Service.TaggedAddresses)
+	require.Equal("fd00::3", actual[1].Service.Address)
+	require.Equal(map[string]api.ServiceAddress{
+		"lan_ipv4": {Address: "3.4.5.6", Port: 30000},
+		"lan_ipv6": {Address: "fd00::3", Port: 30000},
+	}, actual[1].Service.TaggedAddresses)
+}
+
+func TestNodeAddress(t *testing.T) {
+	cases := map[string]struct {
+		addresses  []apiv1.NodeAddress
+		addrType   apiv1.NodeAddressType
+		endpointIP string
+		exp        string
+		expTagged  map[string]string
+	}{
+		"no address": {
+			addresses: []apiv1.NodeAddress{{Type: apiv1.NodeInternalIP, Address: "10.0.0.1"}},
+			addrType:  apiv1.NodeExternalIP,
+		},
+		"IPv4": {
+			addresses:  []apiv1.NodeAddress{{Type: apiv1.NodeExternalIP, Address: "1.2.3.4"}},
+			addrType:   apiv1.NodeExternalIP,
+			endpointIP: "10.0.0.5",
+			exp:        "1.2.3.4",
+		},
+		"IPv6 only": {
+			addresses:  []apiv1.NodeAddress{{Type: apiv1.NodeInternalIP, Address: "fd00::1"}},
+			addrType:   apiv1.NodeInternalIP,
+			endpointIP: "fd01::5",
+			exp:        "fd00::1",
+		},
+		"IPv6 node address of IPv4 endpoint": {
+			addresses:  []apiv1.NodeAddress{{Type: apiv1.NodeInternalIP, Address: "fd00::1"}},
+			addrType:   apiv1.NodeInternalIP,
+			endpointIP: "10.0.0.5",
+			exp:        "fd00::1",
+		},
+		"dual-stack external": {
+			addresses: []apiv1.NodeAddress{
+				{Type: apiv1.NodeExternalIP, Address: "1.2.3.4"},
+				{Type: apiv1.NodeExternalIP, Address: "2001:db8::4"},
+			},
+			addrType:   apiv1.NodeExternalIP,
+			endpointIP: "fd01::5",
+			exp:        "2001:db8::4",
+			expTagged:  map[string]string{"wan_ipv4": "1.2.3.4", "wan_ipv6": "2001:db8::4"},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			node := &apiv1.Node{Status: apiv1.NodeStatus{Addresses: c.addresses}}
+			address, tagged := nodeAddress(node, c.addrType, c.endpointIP)
+			require.Equal(t, c.exp, address)
+			require.Equal(t, c.expTagged, tagged)
+		})
+	}
+}
+
 // Test that the proper registrations are generated for a ClusterIP type.
 func TestServiceResource_clusterIP(t *testing.T) {
 	t.Parallel()
@@ -1406,6 +1519,80 @@ func TestServiceResource_MirroredPrefixNamespace(t *testing.T) {
 	})
 }
 
+// Test that upserting the services of a large cluster allocates less than
+// 100MB.
+func TestServiceResource_UpsertMemory(t *testing.T) {
+	result := testing.Benchmark(BenchmarkServiceResource_Upsert)
+	require.NotZero(t, result.N)
+	require.Less(t, result.AllocedBytesPerOp(), int64(100<<20))
+}
+
+// BenchmarkServiceResource_Upsert measures upserting the 10k ClusterIP
+// services of a large cluster, with 5 endpoints each for its 50k pods, as
+// sync-catalog does at startup. The upserts make no Kubernetes API
+// requests.
+func BenchmarkServiceResource_Upsert(b *testing.B) {
+	const services, pods = 10000, 5
+	client := fake.NewSimpleClientset()
+	svcs := make([]*apiv1.Service, services)
+	for i := range svcs {
+		name := fmt.Sprintf("svc-%d", i)
+		svcs[i] = clusterIPService(name, metav1.NamespaceDefault)
+		endpoints := &apiv1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault},
+			Subsets: []apiv1.EndpointSubset{
+				{Ports: []apiv1.EndpointPort{{Name: "http", Port: 8080}}},
+			},
+		}
+		for j := 0; j < pods; j++ {
+			endpoints.Subsets[0].Addresses = append(endpoints.Subsets[0].Addresses,
+				apiv1.EndpointAddress{IP: fmt.Sprintf("10.%d.%d.%d", j, i/256, i%256)})
+		}
+		_, err := client.CoreV1().Endpoints(metav1.NamespaceDefault).Create(endpoints)
+		require.NoError(b, err)
+	}
+	syncer := &TestSyncer{}
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.Log = hclog.NewNullLogger()
+	serviceResource.ClusterIPSync = true
+
+	// Run the endpoints controller without the services controller to
+	// upsert the services here.
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		serviceResource.Run(stopCh)
+	}()
+	defer func() {
+		close(stopCh)
+		<-doneCh
+	}()
+	retry.Run(b, func(r *retry.R) {
+		serviceResource.serviceLock.RLock()
+		defer serviceResource.serviceLock.RUnlock()
+		if serviceResource.endpointsInformer == nil || !serviceResource.endpointsInformer.HasSynced() {
+			r.Fatal("endpoints informer not synced")
+		}
+	})
+	client.ClearActions()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, svc := range svcs {
+			require.NoError(b, serviceResource.Upsert(metav1.NamespaceDefault+"/"+svc.Name, svc))
+		}
+	}
+	b.StopTimer()
+	require.Empty(b, client.Actions())
+	retry.Run(b, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.Len(r, syncer.Registrations, services*pods)
+	})
+}
+
 // lbService returns a Kubernetes service of type LoadBalancer.
 func lbService(name, namespace, lbIP string) *apiv1.Service {
 	return &apiv1.Service{
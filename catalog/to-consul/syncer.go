@@ -2,13 +2,17 @@ package catalog
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
 	"github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul-k8s/helper/audit"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -20,9 +24,17 @@ const (
 	// whether it has instances to reap.
 	ConsulServicePollPeriod = 60 * time.Second
 
+	// ConsulReregisterPeriod is how often all the services are registered
+	// again, even if they haven't changed since they were last registered.
+	ConsulReregisterPeriod = 5 * time.Minute
+
 	// ConsulSyncNodeName is the name of the node in Consul that we register
 	// services on. It's not a real node backed by a Consul agent.
 	ConsulSyncNodeName = "k8s-sync"
+
+	// EventReasonSyncFailed is the reason of the events recorded when a
+	// service fails to be registered in Consul.
+	EventReasonSyncFailed = "ConsulSyncFailed"
 )
 
 // Syncer is responsible for syncing a set of Consul catalog registrations.
@@ -63,8 +75,15 @@ type ConsulSyncer struct {
 	//
 	// For both syncs, smaller more frequent and focused syncs may be
 	// triggered by known drift or changes.
+	//
+	// ReregisterPeriod is the interval between the full catalog syncs that
+	// re-register all the services. The other full syncs only register the
+	// services that changed since they were last registered, or whose
+	// address or port was changed in Consul, so that they don't write every
+	// service to Consul in large clusters. It defaults to 5 minutes.
 	SyncPeriod        time.Duration
 	ServicePollPeriod time.Duration
+	ReregisterPeriod  time.Duration
 
 	// ConsulK8STag is the tag value for services registered.
 	ConsulK8STag string
@@ -73,6 +92,11 @@ type ConsulSyncer struct {
 	// separate client for this API call that handles older version of Consul.
 	ConsulNodeServicesClient ConsulNodeServicesClient
 
+	// EventRecorder records the failures to register the services as
+	// events on the Kubernetes resources they were created from. If nil,
+	// they're only logged.
+	EventRecorder record.EventRecorder
+
 	lock sync.Mutex
 	once sync.Once
 
@@ -93,6 +117,12 @@ type ConsulSyncer struct {
 	namespaces map[string]map[string]*api.CatalogRegistration
 	deregs     map[string]*api.CatalogDeregistration
 
+	// registered is the registrations of s.namespaces that were registered
+	// since lastReregister, by namespace and service ID. They're skipped by
+	// syncFull until the next ReregisterPeriod.
+	registered     map[string]*api.CatalogRegistration
+	lastReregister time.Time
+
 	// watchers is all namespaces mapped to a map of Consul service
 	// names mapped to a cancel function for watcher routines
 	watchers map[string]map[string]context.CancelFunc
@@ -107,6 +137,9 @@ func (s *ConsulSyncer) Sync(rs []*api.CatalogRegistration) {
 	s.serviceNames = make(map[string]mapset.Set)
 	s.namespaces = make(map[string]map[string]*api.CatalogRegistration)
 
+	// Only build the debug logs of the registrations if they're logged,
+	// since there are as many as the service instances.
+	debug := s.Log.IsDebug()
 	for _, r := range rs {
 		// Determine the namespace the service is in to use for indexing
 		// against the s.serviceNames and s.namespaces maps.
@@ -118,14 +151,18 @@ func (s *ConsulSyncer) Sync(rs []*api.CatalogRegistration) {
 			s.serviceNames[ns] = mapset.NewSet()
 		}
 		s.serviceNames[ns].Add(r.Service.Service)
-		s.Log.Debug("[Sync] adding service to serviceNames set", "service", r.Service, "service name", r.Service.Service)
+		if debug {
+			s.Log.Debug("[Sync] adding service to serviceNames set", "service", r.Service, "service name", r.Service.Service)
+		}
 
 		// Add service to namespaces map, initializing if necessary
 		if _, ok := s.namespaces[ns]; !ok {
 			s.namespaces[ns] = make(map[string]*api.CatalogRegistration)
 		}
 		s.namespaces[ns][r.Service.ID] = r
-		s.Log.Debug("[Sync] adding service to namespaces map", "service", r.Service)
+		if debug {
+			s.Log.Debug("[Sync] adding service to namespaces map", "service", r.Service)
+		}
 	}
 
 	// Signal that the initial sync is complete and our maps have been populated.
@@ -293,7 +330,11 @@ func (s *ConsulSyncer) watchService(ctx context.Context, name, namespace string)
 			// Make sure the namespace exists before we run checks against it
 			if _, ok := s.serviceNames[namespace]; ok {
 				// If the service is valid and its info isn't nil, we don't deregister it
-				if s.serviceNames[namespace].Contains(svc.ServiceName) && s.namespaces[namespace][svc.ServiceID] != nil {
+				if r := s.namespaces[namespace][svc.ServiceID]; s.serviceNames[namespace].Contains(svc.ServiceName) && r != nil {
+					// Register it again if its address or port was changed.
+					if svc.ServiceAddress != r.Service.Address || svc.ServicePort != r.Service.Port {
+						delete(s.registered, registrationKey(namespace, svc.ServiceID))
+					}
 					continue
 				}
 			}
@@ -412,10 +453,20 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 	// Always clear deregistrations, they'll repopulate if we had errors
 	s.deregs = make(map[string]*api.CatalogDeregistration)
 
-	// Register all the services. This will overwrite any changes that
-	// may have been made to the registered services.
-	for _, services := range s.namespaces {
-		for _, r := range services {
+	// Register all the services every ReregisterPeriod. This will overwrite
+	// any changes that may have been made to the registered services. In
+	// between, only the registrations that changed are registered.
+	if time.Since(s.lastReregister) >= s.ReregisterPeriod {
+		s.registered = make(map[string]*api.CatalogRegistration)
+		s.lastReregister = time.Now()
+	}
+	for ns, services := range s.namespaces {
+		for id, r := range services {
+			key := registrationKey(ns, id)
+			if s.registered[key] == r {
+				continue
+			}
+
 			if s.EnableNamespaces {
 				// Check and potentially create the service's namespace if
 				// it doesn't already exist
@@ -426,21 +477,24 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 						"service-name", r.Service.Service,
 						"consul-namespace-name", r.Service.Namespace,
 						"err", err)
+					s.event(r, fmt.Sprintf("Error creating Consul namespace %q: %s", r.Service.Namespace, err))
 					continue
 				}
 			}
 
 			// Register the service
-			_, err := s.Client.Catalog().Register(r, nil)
+			_, err := s.Client.Catalog().Register(r, (&api.WriteOptions{}).WithContext(writeContext(r)))
 			if err != nil {
 				s.Log.Warn("error registering service",
 					"node-name", r.Node,
 					"service-name", r.Service.Service,
 					"service", r.Service,
 					"err", err)
+				s.event(r, fmt.Sprintf("Error registering service %q in Consul: %s", r.Service.Service, err))
 				continue
 			}
 
+			s.registered[key] = r
 			s.Log.Debug("registered service instance",
 				"node-name", r.Node,
 				"service-name", r.Service.Service,
@@ -450,6 +504,41 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 	}
 }
 
+// registrationKey is the key of the registration of the service instance
+// id in the Consul namespace ns in s.registered.
+func registrationKey(ns, id string) string {
+	return ns + "/" + id
+}
+
+// event records a failure to register r as an event on the Kubernetes
+// resource it was created from, if the syncer has an event recorder and
+// the meta of the service records the resource.
+func (s *ConsulSyncer) event(r *api.CatalogRegistration, message string) {
+	if s.EventRecorder == nil || r.Service.Meta[ConsulK8SRefValue] == "" {
+		return
+	}
+	s.EventRecorder.Event(&corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       r.Service.Meta[ConsulK8SRefKind],
+		Name:       r.Service.Meta[ConsulK8SRefValue],
+		Namespace:  r.Service.Meta[ConsulK8SNS],
+	}, corev1.EventTypeWarning, EventReasonSyncFailed, message)
+}
+
+// writeContext returns the context of the writes of r, which are audited
+// as caused by the Kubernetes resource it was created from. It's not the
+// context of the sync so that the writes in flight complete once it stops.
+func writeContext(r *api.CatalogRegistration) context.Context {
+	if r.Service.Meta[ConsulK8SRefValue] == "" {
+		return context.Background()
+	}
+	return audit.WithCause(context.Background(), audit.Cause{
+		Kind:      r.Service.Meta[ConsulK8SRefKind],
+		Namespace: r.Service.Meta[ConsulK8SNS],
+		Name:      r.Service.Meta[ConsulK8SRefValue],
+	})
+}
+
 func (s *ConsulSyncer) init() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -471,6 +560,9 @@ func (s *ConsulSyncer) init() {
 	if s.ServicePollPeriod == 0 {
 		s.ServicePollPeriod = ConsulServicePollPeriod
 	}
+	if s.ReregisterPeriod == 0 {
+		s.ReregisterPeriod = ConsulReregisterPeriod
+	}
 	if s.initialSync == nil {
 		s.initialSync = make(chan bool)
 	}
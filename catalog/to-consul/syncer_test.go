@@ -2,8 +2,11 @@ package catalog
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestConsulSyncer_register(t *testing.T) {
@@ -251,6 +255,121 @@ func TestConsulSyncer_stopsGracefully(t *testing.T) {
 	require.LessOrEqual(t, callCount-beforeStopAPICount, 2)
 }
 
+// Test that the failures to register the services are recorded as events
+// on their Kubernetes Services.
+func TestConsulSyncer_registerFailedEvent(t *testing.T) {
+	t.Parallel()
+
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/catalog/register":
+			w.WriteHeader(500)
+			w.Write([]byte("no cluster leader"))
+		case strings.HasPrefix(r.URL.Path, "/v1/catalog/service/"):
+			w.Write([]byte("[]"))
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer consulServer.Close()
+	client, err := api.NewClient(&api.Config{Address: consulServer.URL})
+	require.NoError(t, err)
+	recorder := record.NewFakeRecorder(10)
+	s, closer := testConsulSyncerWithConfig(client, func(s *ConsulSyncer) {
+		s.EventRecorder = recorder
+	})
+	defer closer()
+
+	// Registrations that don't record their Kubernetes resource have no
+	// events.
+	reg := testRegistration(ConsulSyncNodeName, "bar", "default")
+	reg.Service.Meta[ConsulK8SRefKind] = "Service"
+	reg.Service.Meta[ConsulK8SRefValue] = "bar-svc"
+	s.Sync([]*api.CatalogRegistration{reg, testRegistration(ConsulSyncNodeName, "baz", "default")})
+
+	select {
+	case event := <-recorder.Events:
+		require.Equal(t, `Warning ConsulSyncFailed Error registering service "bar" in Consul: `+
+			`Unexpected response code: 500 (no cluster leader)`, event)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event recorded")
+	}
+}
+
+// Test that the full syncs only register the services that changed since
+// they were last registered, until the next ReregisterPeriod.
+func TestConsulSyncer_syncFullChanged(t *testing.T) {
+	t.Parallel()
+
+	var registers int32
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/catalog/register", r.URL.Path)
+		atomic.AddInt32(&registers, 1)
+		w.Write([]byte("true"))
+	}))
+	defer consulServer.Close()
+	client, err := api.NewClient(&api.Config{Address: consulServer.URL})
+	require.NoError(t, err)
+	s := &ConsulSyncer{
+		Client:           client,
+		Log:              hclog.Default(),
+		ReregisterPeriod: time.Hour,
+	}
+	s.init()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bar := testRegistration(ConsulSyncNodeName, "bar", "default")
+	baz := testRegistration(ConsulSyncNodeName, "baz", "default")
+	s.Sync([]*api.CatalogRegistration{bar, baz})
+	s.syncFull(ctx)
+	require.Equal(t, int32(2), atomic.LoadInt32(&registers))
+
+	// Nothing changed.
+	s.syncFull(ctx)
+	require.Equal(t, int32(2), atomic.LoadInt32(&registers))
+
+	// baz was regenerated.
+	s.Sync([]*api.CatalogRegistration{bar, testRegistration(ConsulSyncNodeName, "baz", "default")})
+	s.syncFull(ctx)
+	require.Equal(t, int32(3), atomic.LoadInt32(&registers))
+
+	// Everything is registered again after ReregisterPeriod.
+	s.lastReregister = time.Now().Add(-time.Hour)
+	s.syncFull(ctx)
+	require.Equal(t, int32(5), atomic.LoadInt32(&registers))
+}
+
+// Test that replacing the registrations of a large cluster allocates less
+// than 10MB per sync.
+func TestConsulSyncer_SyncMemory(t *testing.T) {
+	result := testing.Benchmark(BenchmarkConsulSyncer_Sync)
+	require.NotZero(t, result.N)
+	require.Less(t, result.AllocedBytesPerOp(), int64(10<<20))
+}
+
+// BenchmarkConsulSyncer_Sync measures replacing the registrations of the
+// syncer with the 50k instances of 10k services, which sync-catalog does
+// at most every syncInterval.
+func BenchmarkConsulSyncer_Sync(b *testing.B) {
+	s := &ConsulSyncer{Log: hclog.NewNullLogger()}
+	s.init()
+	rs := make([]*api.CatalogRegistration, 0, 50000)
+	for i := 0; i < 10000; i++ {
+		for j := 0; j < 5; j++ {
+			r := testRegistration(ConsulSyncNodeName, fmt.Sprintf("svc-%d", i), "default")
+			r.Service.ID = serviceID(r.Service.Service, fmt.Sprintf("10.0.%d.%d", j, i%256))
+			rs = append(rs, r)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Sync(rs)
+	}
+}
+
 func testRegistration(node, service, k8sSrcNamespace string) *api.CatalogRegistration {
 	return &api.CatalogRegistration{
 		Node:           node,
@@ -4,13 +4,46 @@ import (
 	"os"
 
 	cmdACLInit "github.com/hashicorp/consul-k8s/subcommand/acl-init"
+	cmdConfig "github.com/hashicorp/consul-k8s/subcommand/config"
+	cmdConfigRead "github.com/hashicorp/consul-k8s/subcommand/config/read"
+	cmdConsulDNSConfig "github.com/hashicorp/consul-k8s/subcommand/consul-dns-config"
+	cmdController "github.com/hashicorp/consul-k8s/subcommand/controller"
+	cmdDebug "github.com/hashicorp/consul-k8s/subcommand/debug"
 	cmdDeleteCompletedJob "github.com/hashicorp/consul-k8s/subcommand/delete-completed-job"
+	cmdDistributeCA "github.com/hashicorp/consul-k8s/subcommand/distribute-ca"
+	cmdExportTrustBundle "github.com/hashicorp/consul-k8s/subcommand/export-trust-bundle"
+	cmdFederationInit "github.com/hashicorp/consul-k8s/subcommand/federation-init"
 	cmdGetConsulClientCA "github.com/hashicorp/consul-k8s/subcommand/get-consul-client-ca"
+	cmdGossipKey "github.com/hashicorp/consul-k8s/subcommand/gossip-key"
 	cmdInjectConnect "github.com/hashicorp/consul-k8s/subcommand/inject-connect"
+	cmdInstall "github.com/hashicorp/consul-k8s/subcommand/install"
 	cmdLifecycleSidecar "github.com/hashicorp/consul-k8s/subcommand/lifecycle-sidecar"
+	cmdPeering "github.com/hashicorp/consul-k8s/subcommand/peering"
+	cmdPeeringEstablish "github.com/hashicorp/consul-k8s/subcommand/peering/establish"
+	cmdProxy "github.com/hashicorp/consul-k8s/subcommand/proxy"
+	cmdProxyList "github.com/hashicorp/consul-k8s/subcommand/proxy/list"
+	cmdProxyLogLevel "github.com/hashicorp/consul-k8s/subcommand/proxy/log-level"
+	cmdProxyRead "github.com/hashicorp/consul-k8s/subcommand/proxy/read"
+	cmdRegisterMeshGateway "github.com/hashicorp/consul-k8s/subcommand/register-mesh-gateway"
+	cmdRegisterTerminatingGateway "github.com/hashicorp/consul-k8s/subcommand/register-terminating-gateway"
+	cmdRotate "github.com/hashicorp/consul-k8s/subcommand/rotate"
+	cmdRotateGossipKey "github.com/hashicorp/consul-k8s/subcommand/rotate/gossip-key"
+	cmdSDSServer "github.com/hashicorp/consul-k8s/subcommand/sds-server"
 	cmdServerACLInit "github.com/hashicorp/consul-k8s/subcommand/server-acl-init"
+	cmdServerRestart "github.com/hashicorp/consul-k8s/subcommand/server-restart"
 	cmdServiceAddress "github.com/hashicorp/consul-k8s/subcommand/service-address"
+	cmdSnapshot "github.com/hashicorp/consul-k8s/subcommand/snapshot"
+	cmdSnapshotAgentToken "github.com/hashicorp/consul-k8s/subcommand/snapshot-agent-token"
+	cmdSnapshotRestore "github.com/hashicorp/consul-k8s/subcommand/snapshot/restore"
+	cmdSnapshotSave "github.com/hashicorp/consul-k8s/subcommand/snapshot/save"
+	cmdStatus "github.com/hashicorp/consul-k8s/subcommand/status"
 	cmdSyncCatalog "github.com/hashicorp/consul-k8s/subcommand/sync-catalog"
+	cmdTLSInit "github.com/hashicorp/consul-k8s/subcommand/tls-init"
+	cmdTroubleshoot "github.com/hashicorp/consul-k8s/subcommand/troubleshoot"
+	cmdTroubleshootProxy "github.com/hashicorp/consul-k8s/subcommand/troubleshoot/proxy"
+	cmdTroubleshootUpstreams "github.com/hashicorp/consul-k8s/subcommand/troubleshoot/upstreams"
+	cmdUninstall "github.com/hashicorp/consul-k8s/subcommand/uninstall"
+	cmdUpgrade "github.com/hashicorp/consul-k8s/subcommand/upgrade"
 	cmdVersion "github.com/hashicorp/consul-k8s/subcommand/version"
 	"github.com/hashicorp/consul-k8s/version"
 	"github.com/mitchellh/cli"
@@ -51,12 +84,149 @@ func init() {
 			return &cmdServiceAddress.Command{UI: ui}, nil
 		},
 
+		"consul-dns-config": func() (cli.Command, error) {
+			return &cmdConsulDNSConfig.Command{UI: ui}, nil
+		},
+
+		"snapshot-agent-token": func() (cli.Command, error) {
+			return &cmdSnapshotAgentToken.Command{UI: ui}, nil
+		},
+
+		"server-restart": func() (cli.Command, error) {
+			return &cmdServerRestart.Command{UI: ui}, nil
+		},
+
 		"get-consul-client-ca": func() (cli.Command, error) {
 			return &cmdGetConsulClientCA.Command{UI: ui}, nil
 		},
 
+		"export-trust-bundle": func() (cli.Command, error) {
+			return &cmdExportTrustBundle.Command{UI: ui}, nil
+		},
+
+		"federation-init": func() (cli.Command, error) {
+			return &cmdFederationInit.Command{UI: ui}, nil
+		},
+
+		"gossip-key": func() (cli.Command, error) {
+			return &cmdGossipKey.Command{UI: ui}, nil
+		},
+
+		"tls-init": func() (cli.Command, error) {
+			return &cmdTLSInit.Command{UI: ui}, nil
+		},
+
+		"distribute-ca": func() (cli.Command, error) {
+			return &cmdDistributeCA.Command{UI: ui}, nil
+		},
+
+		"register-mesh-gateway": func() (cli.Command, error) {
+			return &cmdRegisterMeshGateway.Command{UI: ui}, nil
+		},
+
+		"register-terminating-gateway": func() (cli.Command, error) {
+			return &cmdRegisterTerminatingGateway.Command{UI: ui}, nil
+		},
+
+		"sds-server": func() (cli.Command, error) {
+			return &cmdSDSServer.Command{UI: ui}, nil
+		},
+
+		"controller": func() (cli.Command, error) {
+			return &cmdController.Command{UI: ui}, nil
+		},
+
+		"config": func() (cli.Command, error) {
+			return &cmdConfig.Command{UI: ui}, nil
+		},
+
+		"config read": func() (cli.Command, error) {
+			return &cmdConfigRead.Command{UI: ui}, nil
+		},
+
+		"debug": func() (cli.Command, error) {
+			return &cmdDebug.Command{UI: ui}, nil
+		},
+
+		"install": func() (cli.Command, error) {
+			return &cmdInstall.Command{UI: ui}, nil
+		},
+
+		"peering": func() (cli.Command, error) {
+			return &cmdPeering.Command{UI: ui}, nil
+		},
+
+		"peering establish": func() (cli.Command, error) {
+			return &cmdPeeringEstablish.Command{UI: ui}, nil
+		},
+
+		"proxy": func() (cli.Command, error) {
+			return &cmdProxy.Command{UI: ui}, nil
+		},
+
+		"proxy list": func() (cli.Command, error) {
+			return &cmdProxyList.Command{UI: ui}, nil
+		},
+
+		"proxy log-level": func() (cli.Command, error) {
+			return &cmdProxyLogLevel.Command{UI: ui}, nil
+		},
+
+		"proxy read": func() (cli.Command, error) {
+			return &cmdProxyRead.Command{UI: ui}, nil
+		},
+
+		"rotate": func() (cli.Command, error) {
+			return &cmdRotate.Command{UI: ui}, nil
+		},
+
+		"rotate gossip-key": func() (cli.Command, error) {
+			return &cmdRotateGossipKey.Command{UI: ui}, nil
+		},
+
+		"snapshot": func() (cli.Command, error) {
+			return &cmdSnapshot.Command{UI: ui}, nil
+		},
+
+		"snapshot restore": func() (cli.Command, error) {
+			return &cmdSnapshotRestore.Command{UI: ui}, nil
+		},
+
+		"snapshot save": func() (cli.Command, error) {
+			return &cmdSnapshotSave.Command{UI: ui}, nil
+		},
+
+		"status": func() (cli.Command, error) {
+			return &cmdStatus.Command{UI: ui}, nil
+		},
+
+		"troubleshoot": func() (cli.Command, error) {
+			return &cmdTroubleshoot.Command{UI: ui}, nil
+		},
+
+		"troubleshoot proxy": func() (cli.Command, error) {
+			return &cmdTroubleshootProxy.Command{UI: ui}, nil
+		},
+
+		"troubleshoot upstreams": func() (cli.Command, error) {
+			return &cmdTroubleshootUpstreams.Command{UI: ui}, nil
+		},
+
+		"uninstall": func() (cli.Command, error) {
+			return &cmdUninstall.Command{UI: ui}, nil
+		},
+
+		"upgrade": func() (cli.Command, error) {
+			return &cmdUpgrade.Command{UI: ui}, nil
+		},
+
 		"version": func() (cli.Command, error) {
-			return &cmdVersion.Command{UI: ui, Version: version.GetHumanVersion()}, nil
+			return &cmdVersion.Command{
+				UI:        ui,
+				Version:   version.GetHumanVersion(),
+				GitCommit: version.GitCommit,
+				BuildDate: version.BuildDate,
+			}, nil
 		},
 	}
 }
@@ -0,0 +1,389 @@
+package connectinject
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mattbaird/jsonpatch"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annotationPrefix is the prefix of the annotations of the pods read by
+// consul-k8s.
+const annotationPrefix = "consul.hashicorp.com/"
+
+// annotationSchema describes the values of an annotation.
+type annotationSchema struct {
+	// normalize returns the canonical form of a valid value, e.g. "true"
+	// for "True". If it's nil, the value is kept as is.
+	normalize func(value string) string
+
+	// validate returns an error if the value can't be used by the
+	// injector. If it's nil, any value is valid.
+	validate func(pod *corev1.Pod, value string) error
+
+	// replacedBy is the annotation to use instead of a deprecated one.
+	replacedBy string
+}
+
+// podAnnotations are the annotations of the pods read or written by
+// consul-k8s, keyed by name. The service meta annotations, which are
+// prefixed with annotationMeta, are checked separately.
+var podAnnotations = map[string]annotationSchema{
+	annotationStatus:               {},
+	annotationInject:               {normalize: normalizeBool, validate: validateBool},
+	annotationService:              {normalize: strings.TrimSpace, validate: validateNotEmpty},
+	annotationPort:                 {normalize: strings.TrimSpace, validate: validatePort},
+	annotationProtocol:             {normalize: normalizeProtocol, validate: validateProtocol},
+	annotationUpstreams:            {validate: validateUpstreams},
+	annotationTags:                 {},
+	annotationConnectTags:          {replacedBy: annotationTags},
+	annotationSyncPeriod:           {normalize: strings.TrimSpace, validate: validateDuration},
+	annotationDrainTimeout:         {normalize: strings.TrimSpace, validate: validateDuration},
+	annotationEnableMetricsMerging: {normalize: normalizeBool, validate: validateBool},
+	annotationServiceMetricsPort:   {normalize: strings.TrimSpace, validate: validatePort},
+	annotationServiceMetricsPath:   {normalize: strings.TrimSpace, validate: validatePath},
+	annotationEnvoyMetricsAllow:    {validate: validateRegexp},
+	annotationEnvoyMetricsDrop:     {validate: validateRegexp},
+
+	// The checksums of the pod templates that roll the pods when the CA
+	// certificate or the server config change.
+	annotationPrefix + "ca-checksum":     {},
+	annotationPrefix + "config-checksum": {},
+}
+
+// AnnotationWebhook is the mutating admission webhook of the
+// consul.hashicorp.com annotations of pods. The injector ignores the
+// annotations it doesn't know, so the webhook warns about them, suggesting
+// the known annotation they're likely a typo of, and about deprecated
+// annotations. It also checks the values that the injector would reject or
+// misread, and normalizes the valid ones, e.g. "True" to "true" and "HTTP"
+// to "http".
+//
+// The warnings are shown by kubectl with Kubernetes 1.19 and later, and
+// are ignored by earlier versions.
+type AnnotationWebhook struct {
+	Log hclog.Logger
+
+	// DenyInvalid rejects the pods with invalid annotation values. If it's
+	// false, the pods are only warned about.
+	DenyInvalid bool
+}
+
+// annotationReview is a v1beta1.AdmissionReview whose response has the
+// warnings added to admission responses in Kubernetes 1.19, which the
+// vendored v1beta1 API doesn't have.
+type annotationReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *v1beta1.AdmissionRequest `json:"request,omitempty"`
+	Response        *annotationResponse       `json:"response,omitempty"`
+}
+
+type annotationResponse struct {
+	*v1beta1.AdmissionResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Handle is the http.HandlerFunc implementation of the webhook.
+func (w *AnnotationWebhook) Handle(rw http.ResponseWriter, r *http.Request) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+		msg := fmt.Sprintf("Invalid content-type: %q", ct)
+		http.Error(rw, msg, http.StatusBadRequest)
+		w.Log.Error("Error on request", "err", msg, "Code", http.StatusBadRequest)
+		return
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			msg := fmt.Sprintf("Error reading request body: %s", err)
+			http.Error(rw, msg, http.StatusBadRequest)
+			w.Log.Error("Error on request", "err", msg, "Code", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var review annotationReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		msg := "Could not decode admission request"
+		http.Error(rw, msg, http.StatusBadRequest)
+		w.Log.Error("Error on request", "err", msg, "Code", http.StatusBadRequest)
+		return
+	}
+	resp, warnings := w.Review(review.Request)
+	resp.UID = review.Request.UID
+	review.Response = &annotationResponse{AdmissionResponse: resp, Warnings: warnings}
+	review.Request = nil
+
+	out, err := json.Marshal(&review)
+	if err != nil {
+		msg := fmt.Sprintf("Error marshalling admission response: %s", err)
+		http.Error(rw, msg, http.StatusInternalServerError)
+		w.Log.Error("Error on request", "err", msg, "Code", http.StatusInternalServerError)
+		return
+	}
+	if _, err := rw.Write(out); err != nil {
+		w.Log.Error("Error writing response", "err", err)
+	}
+}
+
+// Review returns the response to the admission request of a pod, with the
+// patch normalizing its annotations, and the warnings about them.
+func (w *AnnotationWebhook) Review(req *v1beta1.AdmissionRequest) (*v1beta1.AdmissionResponse, []string) {
+	if req.Operation == v1beta1.Delete {
+		return &v1beta1.AdmissionResponse{Allowed: true}, nil
+	}
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return admissionError(fmt.Errorf("Could not unmarshal request to pod: %s", err)), nil
+	}
+
+	var keys []string
+	for key := range pod.Annotations {
+		if strings.HasPrefix(key, annotationPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var warnings, invalid []string
+	normalized := make(map[string]string)
+	for _, key := range keys {
+		value := pod.Annotations[key]
+		if strings.HasPrefix(key, annotationMeta) {
+			if key == annotationMeta {
+				warnings = append(warnings, fmt.Sprintf("annotation %q has no meta key, it must be %s<key>", key, annotationMeta))
+			}
+			continue
+		}
+		schema, ok := podAnnotations[key]
+		if !ok {
+			warnings = append(warnings, unknownAnnotationWarning(key))
+			continue
+		}
+		if schema.replacedBy != "" {
+			warnings = append(warnings, fmt.Sprintf("annotation %q is deprecated, use %q instead", key, schema.replacedBy))
+		}
+		if schema.normalize != nil {
+			if v := schema.normalize(value); v != value {
+				value = v
+				normalized[key] = v
+			}
+		}
+		if schema.validate != nil {
+			if err := schema.validate(&pod, value); err != nil {
+				msg := fmt.Sprintf("invalid annotation %q: %s", key, err)
+				invalid = append(invalid, msg)
+				warnings = append(warnings, msg)
+				delete(normalized, key)
+			}
+		}
+	}
+
+	if len(invalid) > 0 && w.DenyInvalid {
+		return admissionError(errors.New(strings.Join(invalid, "; "))), warnings
+	}
+	resp := &v1beta1.AdmissionResponse{Allowed: true}
+	if len(normalized) == 0 {
+		return resp, warnings
+	}
+	// The annotations exist, so they're replaced rather than added.
+	var patches []jsonpatch.JsonPatchOperation
+	for _, key := range keys {
+		if value, ok := normalized[key]; ok {
+			patches = append(patches, jsonpatch.JsonPatchOperation{
+				Operation: "replace",
+				Path:      "/metadata/annotations/" + escapeJSONPointer(key),
+				Value:     value,
+			})
+		}
+	}
+	patch, err := json.Marshal(patches)
+	if err != nil {
+		return admissionError(fmt.Errorf("Could not marshal patches: %s", err)), warnings
+	}
+	patchType := v1beta1.PatchTypeJSONPatch
+	resp.Patch = patch
+	resp.PatchType = &patchType
+	return resp, warnings
+}
+
+// unknownAnnotationWarning returns the warning about the unknown annotation
+// key, with the known annotation it's closest to if it's likely a typo.
+func unknownAnnotationWarning(key string) string {
+	name := strings.TrimPrefix(key, annotationPrefix)
+	var candidates []string
+	for known := range podAnnotations {
+		candidates = append(candidates, strings.TrimPrefix(known, annotationPrefix))
+	}
+	sort.Strings(candidates)
+
+	best, bestDistance := "", -1
+	for _, candidate := range candidates {
+		d := editDistance(name, candidate)
+		if bestDistance < 0 || d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	// Allow a typo every 5 characters, with at least 2.
+	maxDistance := len(best) / 5
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	if bestDistance <= maxDistance {
+		return fmt.Sprintf("unknown annotation %q is ignored by consul-k8s, did you mean %q?", key, annotationPrefix+best)
+	}
+
+	// The service meta annotations have a key after the prefix, so only the
+	// prefix of name is compared, e.g. "service_meta-" of
+	// "service_meta-version".
+	metaPrefix := strings.TrimPrefix(annotationMeta, annotationPrefix)
+	if len(name) > len(metaPrefix) && editDistance(name[:len(metaPrefix)], metaPrefix) <= 1 {
+		return fmt.Sprintf("unknown annotation %q is ignored by consul-k8s, did you mean %q?",
+			key, annotationMeta+name[len(metaPrefix):])
+	}
+	return fmt.Sprintf("unknown annotation %q is ignored by consul-k8s", key)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func normalizeBool(value string) string {
+	b, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return value
+	}
+	return strconv.FormatBool(b)
+}
+
+func validateBool(_ *corev1.Pod, value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("%q must be \"true\" or \"false\"", value)
+	}
+	return nil
+}
+
+func validateNotEmpty(_ *corev1.Pod, value string) error {
+	if value == "" {
+		return errors.New("must not be empty")
+	}
+	return nil
+}
+
+// validatePort checks that value is a port number or the name of a port of
+// the containers of pod, like portValue reads it.
+func validatePort(pod *corev1.Pod, value string) error {
+	port, err := portValue(pod, value)
+	if err != nil {
+		return fmt.Errorf("%q is neither a port number nor the name of a container port", value)
+	}
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port %d is out of range", port)
+	}
+	return nil
+}
+
+// protocols are the values of the protocol annotation.
+var protocols = []string{"http", "http2", "grpc", "tcp"}
+
+func normalizeProtocol(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+func validateProtocol(_ *corev1.Pod, value string) error {
+	for _, protocol := range protocols {
+		if value == protocol {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q must be one of \"http\", \"http2\", \"grpc\" or \"tcp\"", value)
+}
+
+// validateUpstreams checks the upstreams like the init container reads
+// them: <service>[.<namespace>]:<port>[:<datacenter>] or
+// prepared_query:<query>:<port>, separated by commas.
+func validateUpstreams(pod *corev1.Pod, value string) error {
+	if value == "" {
+		return nil
+	}
+	for _, raw := range strings.Split(value, ",") {
+		parts := strings.SplitN(raw, ":", 3)
+		port := ""
+		switch {
+		case parts[0] == "prepared_query" && len(parts) == 3:
+			port = parts[2]
+		case parts[0] != "prepared_query" && len(parts) >= 2 && strings.TrimSpace(parts[0]) != "":
+			port = parts[1]
+		default:
+			return fmt.Errorf("upstream %q must be <service>:<port>[:<datacenter>] or prepared_query:<query>:<port>", raw)
+		}
+		if err := validatePort(pod, strings.TrimSpace(port)); err != nil {
+			return fmt.Errorf("upstream %q: %s", raw, err)
+		}
+	}
+	return nil
+}
+
+func validateDuration(_ *corev1.Pod, value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration, e.g. \"30s\"", value)
+	}
+	if d < 0 {
+		return fmt.Errorf("%q must not be negative", value)
+	}
+	return nil
+}
+
+func validatePath(_ *corev1.Pod, value string) error {
+	if !strings.HasPrefix(value, "/") {
+		return fmt.Errorf("%q must start with \"/\"", value)
+	}
+	return nil
+}
+
+func validateRegexp(_ *corev1.Pod, value string) error {
+	if _, err := regexp.Compile(value); err != nil {
+		return fmt.Errorf("%q is not a regular expression: %s", value, err)
+	}
+	return nil
+}
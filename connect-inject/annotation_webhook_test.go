@@ -0,0 +1,233 @@
+package connectinject
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/mattbaird/jsonpatch"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAnnotationWebhook_Review(t *testing.T) {
+	cases := map[string]struct {
+		annotations map[string]string
+		denyInvalid bool
+		expAllowed  bool
+		expMessage  string
+		expWarnings []string
+		expPatches  []jsonpatch.JsonPatchOperation
+	}{
+		"no annotations": {
+			expAllowed: true,
+		},
+		"valid annotations": {
+			annotations: map[string]string{
+				annotationInject:                        "true",
+				annotationService:                       "web",
+				annotationPort:                          "http",
+				annotationProtocol:                      "grpc",
+				annotationUpstreams:                     "db:1234,cache.ns:2345:dc2,prepared_query:q:3456",
+				annotationSyncPeriod:                    "10s",
+				annotationServiceMetricsPath:            "/metrics",
+				annotationEnvoyMetricsAllow:             "^envoy_cluster_.*",
+				annotationMeta + "version":              "2",
+				"consul.hashicorp.com/config-checksum":  "abc",
+				"app.kubernetes.io/name":                "web",
+				"other.hashicorp.com/connect-injection": "true",
+			},
+			expAllowed: true,
+		},
+		"normalized values": {
+			annotations: map[string]string{
+				annotationInject:       "True",
+				annotationProtocol:     "HTTP",
+				annotationDrainTimeout: " 5s",
+			},
+			expAllowed: true,
+			expPatches: []jsonpatch.JsonPatchOperation{
+				{Operation: "replace", Path: "/metadata/annotations/consul.hashicorp.com~1connect-drain-timeout", Value: "5s"},
+				{Operation: "replace", Path: "/metadata/annotations/consul.hashicorp.com~1connect-inject", Value: "true"},
+				{Operation: "replace", Path: "/metadata/annotations/consul.hashicorp.com~1connect-service-protocol", Value: "http"},
+			},
+		},
+		"typo": {
+			annotations: map[string]string{"consul.hashicorp.com/conect-inject": "true"},
+			expAllowed:  true,
+			expWarnings: []string{
+				`unknown annotation "consul.hashicorp.com/conect-inject" is ignored by consul-k8s, did you mean "consul.hashicorp.com/connect-inject"?`,
+			},
+		},
+		"typo of the service meta prefix": {
+			annotations: map[string]string{"consul.hashicorp.com/service_meta-version": "2"},
+			expAllowed:  true,
+			expWarnings: []string{
+				`unknown annotation "consul.hashicorp.com/service_meta-version" is ignored by consul-k8s, did you mean "consul.hashicorp.com/service-meta-version"?`,
+			},
+		},
+		"typo close to the service meta prefix": {
+			annotations: map[string]string{"consul.hashicorp.com/service-metrics-prot": "9102"},
+			expAllowed:  true,
+			expWarnings: []string{
+				`unknown annotation "consul.hashicorp.com/service-metrics-prot" is ignored by consul-k8s, did you mean "consul.hashicorp.com/service-metrics-port"?`,
+			},
+		},
+		"unknown annotation": {
+			annotations: map[string]string{"consul.hashicorp.com/mesh-gateway-mode": "local"},
+			expAllowed:  true,
+			expWarnings: []string{
+				`unknown annotation "consul.hashicorp.com/mesh-gateway-mode" is ignored by consul-k8s`,
+			},
+		},
+		"service meta without key": {
+			annotations: map[string]string{annotationMeta: "v2"},
+			expAllowed:  true,
+			expWarnings: []string{
+				`annotation "consul.hashicorp.com/service-meta-" has no meta key, it must be consul.hashicorp.com/service-meta-<key>`,
+			},
+		},
+		"deprecated annotation": {
+			annotations: map[string]string{annotationConnectTags: "v2"},
+			expAllowed:  true,
+			expWarnings: []string{
+				`annotation "consul.hashicorp.com/connect-service-tags" is deprecated, use "consul.hashicorp.com/service-tags" instead`,
+			},
+		},
+		"invalid values are warned about": {
+			annotations: map[string]string{
+				annotationInject:             "yes",
+				annotationProtocol:           "HTP",
+				annotationUpstreams:          "db",
+				annotationServiceMetricsPort: "metrics",
+			},
+			expAllowed: true,
+			expWarnings: []string{
+				`invalid annotation "consul.hashicorp.com/connect-inject": "yes" must be "true" or "false"`,
+				`invalid annotation "consul.hashicorp.com/connect-service-protocol": "htp" must be one of "http", "http2", "grpc" or "tcp"`,
+				`invalid annotation "consul.hashicorp.com/connect-service-upstreams": upstream "db" must be <service>:<port>[:<datacenter>] or prepared_query:<query>:<port>`,
+				`invalid annotation "consul.hashicorp.com/service-metrics-port": "metrics" is neither a port number nor the name of a container port`,
+			},
+		},
+		"invalid values are denied": {
+			annotations: map[string]string{
+				annotationSyncPeriod:         "10",
+				annotationServiceMetricsPath: "metrics",
+				annotationProtocol:           "HTTP",
+			},
+			denyInvalid: true,
+			expMessage:  `invalid annotation "consul.hashicorp.com/connect-sync-period": "10" is not a duration, e.g. "30s"; invalid annotation "consul.hashicorp.com/service-metrics-path": "metrics" must start with "/"`,
+			expWarnings: []string{
+				`invalid annotation "consul.hashicorp.com/connect-sync-period": "10" is not a duration, e.g. "30s"`,
+				`invalid annotation "consul.hashicorp.com/service-metrics-path": "metrics" must start with "/"`,
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := &AnnotationWebhook{Log: hclog.NewNullLogger(), DenyInvalid: c.denyInvalid}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  "web",
+						Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+					}},
+				},
+			}
+			resp, warnings := w.Review(&v1beta1.AdmissionRequest{
+				Operation: v1beta1.Create,
+				Object:    encodeRaw(t, pod),
+			})
+
+			require.Equal(t, c.expAllowed, resp.Allowed)
+			if c.expMessage != "" {
+				require.Equal(t, c.expMessage, resp.Result.Message)
+			}
+			require.Equal(t, c.expWarnings, warnings)
+			if c.expPatches == nil {
+				require.Empty(t, resp.Patch)
+				return
+			}
+			var patches []jsonpatch.JsonPatchOperation
+			require.NoError(t, json.Unmarshal(resp.Patch, &patches))
+			require.Equal(t, c.expPatches, patches)
+		})
+	}
+}
+
+func TestAnnotationWebhook_ReviewDelete(t *testing.T) {
+	w := &AnnotationWebhook{Log: hclog.NewNullLogger(), DenyInvalid: true}
+	resp, warnings := w.Review(&v1beta1.AdmissionRequest{Operation: v1beta1.Delete})
+	require.True(t, resp.Allowed)
+	require.Empty(t, warnings)
+}
+
+// Test that the warnings are added to the admission response.
+func TestAnnotationWebhook_Handle(t *testing.T) {
+	w := &AnnotationWebhook{Log: hclog.NewNullLogger()}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{"consul.hashicorp.com/connect-injet": "true"},
+		},
+	}
+	body, err := json.Marshal(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			UID:       "1",
+			Operation: v1beta1.Create,
+			Object:    encodeRaw(t, pod),
+		},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/mutate-annotations", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	w.Handle(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var review struct {
+		Response struct {
+			UID      string   `json:"uid"`
+			Allowed  bool     `json:"allowed"`
+			Warnings []string `json:"warnings"`
+		} `json:"response"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &review))
+	require.Equal(t, "1", review.Response.UID)
+	require.True(t, review.Response.Allowed)
+	require.Equal(t, []string{
+		`unknown annotation "consul.hashicorp.com/connect-injet" is ignored by consul-k8s, did you mean "consul.hashicorp.com/connect-inject"?`,
+	}, review.Response.Warnings)
+
+	// Requests that aren't JSON are rejected.
+	req = httptest.NewRequest("POST", "/mutate-annotations", bytes.NewReader(body))
+	rec = httptest.NewRecorder()
+	w.Handle(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		exp  int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"connect-inject", "connect-inject", 0},
+		{"conect-inject", "connect-inject", 1},
+		{"connect-injcet", "connect-inject", 2},
+		{"service-tag", "service-tags", 1},
+	}
+	for _, c := range cases {
+		t.Run(c.a+"/"+c.b, func(t *testing.T) {
+			require.Equal(t, c.exp, editDistance(c.a, c.b))
+			require.Equal(t, c.exp, editDistance(c.b, c.a))
+		})
+	}
+}
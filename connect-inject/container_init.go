@@ -179,9 +179,7 @@ func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Co
 
 	// Render the command
 	var buf bytes.Buffer
-	tpl := template.Must(template.New("root").Parse(strings.TrimSpace(
-		initContainerCommandTpl)))
-	err := tpl.Execute(&buf, &data)
+	err := initContainerCommand.Execute(&buf, &data)
 	if err != nil {
 		return corev1.Container{}, err
 	}
@@ -202,6 +200,12 @@ func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Co
 					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"},
 				},
 			},
+			{
+				Name: "POD_IPS",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIPs"},
+				},
+			},
 			{
 				Name: "POD_NAME",
 				ValueFrom: &corev1.EnvVarSource{
@@ -228,10 +232,41 @@ func (h *Handler) containerInit(pod *corev1.Pod, k8sNamespace string) (corev1.Co
 	}, nil
 }
 
+// initContainerCommand is initContainerCommandTpl, parsed once rather than
+// for each pod.
+var initContainerCommand = template.Must(template.New("root").Parse(strings.TrimSpace(
+	initContainerCommandTpl)))
+
 // initContainerCommandTpl is the template for the command executed by
 // the init container.
 const initContainerCommandTpl = `
-{{- if .ConsulCACert}}
+# Bracket IPv6 addresses so that they can be combined with a port. On
+# dual-stack clusters, the pod's IPv4 and IPv6 addresses are registered as
+# tagged addresses.
+case "${HOST_IP}" in *:*) HOST_IP="[${HOST_IP}]" ;; esac
+case "${POD_IP}" in *:*) POD_HOST="[${POD_IP}]" ;; *) POD_HOST="${POD_IP}" ;; esac
+POD_IPV4=""
+POD_IPV6=""
+for ip in $(echo "${POD_IPS}" | tr ',' ' '); do
+  case "${ip}" in *:*) POD_IPV6="${ip}" ;; *) POD_IPV4="${ip}" ;; esac
+done
+tagged_addresses() {
+  if [ -n "${POD_IPV4}" ] && [ -n "${POD_IPV6}" ]; then
+    cat <<TAGGED
+  tagged_addresses {
+    lan_ipv4 {
+      address = "${POD_IPV4}"
+      port = $1
+    }
+    lan_ipv6 {
+      address = "${POD_IPV6}"
+      port = $1
+    }
+  }
+TAGGED
+  fi
+}
+{{ if .ConsulCACert}}
 export CONSUL_HTTP_ADDR="https://${HOST_IP}:8501"
 export CONSUL_GRPC_ADDR="https://${HOST_IP}:8502"
 export CONSUL_CACERT=/consul/connect-inject/consul-ca.pem
@@ -252,6 +287,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   {{- if .ConsulNamespace }}
   namespace = "{{ .ConsulNamespace }}"
   {{- end }}
@@ -296,7 +332,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -312,6 +348,7 @@ services {
   name = "{{ .ServiceName }}"
   address = "${POD_IP}"
   port = {{ .ServicePort }}
+$(tagged_addresses {{ .ServicePort }})
   {{- if .ConsulNamespace }}
   namespace = "{{ .ConsulNamespace }}"
   {{- end }}
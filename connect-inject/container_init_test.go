@@ -48,7 +48,33 @@ func TestHandlerContainerInit(t *testing.T) {
 				pod.Annotations[annotationService] = "web"
 				return pod
 			},
-			`/bin/sh -ec 
+			`/bin/sh -ec # Bracket IPv6 addresses so that they can be combined with a port. On
+# dual-stack clusters, the pod's IPv4 and IPv6 addresses are registered as
+# tagged addresses.
+case "${HOST_IP}" in *:*) HOST_IP="[${HOST_IP}]" ;; esac
+case "${POD_IP}" in *:*) POD_HOST="[${POD_IP}]" ;; *) POD_HOST="${POD_IP}" ;; esac
+POD_IPV4=""
+POD_IPV6=""
+for ip in $(echo "${POD_IPS}" | tr ',' ' '); do
+  case "${ip}" in *:*) POD_IPV6="${ip}" ;; *) POD_IPV4="${ip}" ;; esac
+done
+tagged_addresses() {
+  if [ -n "${POD_IPV4}" ] && [ -n "${POD_IPV6}" ]; then
+    cat <<TAGGED
+  tagged_addresses {
+    lan_ipv4 {
+      address = "${POD_IPV4}"
+      port = $1
+    }
+    lan_ipv6 {
+      address = "${POD_IPV6}"
+      port = $1
+    }
+  }
+TAGGED
+  fi
+}
+
 export CONSUL_HTTP_ADDR="${HOST_IP}:8500"
 export CONSUL_GRPC_ADDR="${HOST_IP}:8502"
 
@@ -61,6 +87,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
 
   proxy {
     destination_service_name = "web"
@@ -69,7 +96,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -85,6 +112,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 0
+$(tagged_addresses 0)
 }
 EOF
 
@@ -114,6 +142,7 @@ cp /bin/consul /consul/connect-inject/consul`,
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
 
   proxy {
     destination_service_name = "web"
@@ -124,7 +153,7 @@ cp /bin/consul /consul/connect-inject/consul`,
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -140,6 +169,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 1234
+$(tagged_addresses 1234)
 }`,
 			"",
 		},
@@ -227,6 +257,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   tags = ["abc"]
 
   proxy {
@@ -238,7 +269,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -254,6 +285,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 1234
+$(tagged_addresses 1234)
   tags = ["abc"]
 }`,
 			"",
@@ -273,6 +305,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   tags = ["abc","123"]
 
   proxy {
@@ -284,7 +317,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -300,6 +333,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 1234
+$(tagged_addresses 1234)
   tags = ["abc","123"]
 }`,
 			"",
@@ -319,6 +353,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   tags = ["abc","123"]
 
   proxy {
@@ -330,7 +365,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -346,6 +381,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 1234
+$(tagged_addresses 1234)
   tags = ["abc","123"]
 }`,
 			"",
@@ -366,6 +402,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   tags = ["abc","123","abc","123","def","456"]
 
   proxy {
@@ -377,7 +414,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -393,6 +430,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 1234
+$(tagged_addresses 1234)
   tags = ["abc","123","abc","123","def","456"]
 }`,
 			"",
@@ -422,6 +460,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   meta = {
     name = "abc"
     version = "2"
@@ -436,7 +475,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -452,6 +491,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 1234
+$(tagged_addresses 1234)
   meta = {
     name = "abc"
     version = "2"
@@ -547,7 +587,33 @@ func TestHandlerContainerInit_namespacesEnabled(t *testing.T) {
 				ConsulDestinationNamespace: "default",
 			},
 			k8sNamespace,
-			`/bin/sh -ec 
+			`/bin/sh -ec # Bracket IPv6 addresses so that they can be combined with a port. On
+# dual-stack clusters, the pod's IPv4 and IPv6 addresses are registered as
+# tagged addresses.
+case "${HOST_IP}" in *:*) HOST_IP="[${HOST_IP}]" ;; esac
+case "${POD_IP}" in *:*) POD_HOST="[${POD_IP}]" ;; *) POD_HOST="${POD_IP}" ;; esac
+POD_IPV4=""
+POD_IPV6=""
+for ip in $(echo "${POD_IPS}" | tr ',' ' '); do
+  case "${ip}" in *:*) POD_IPV6="${ip}" ;; *) POD_IPV4="${ip}" ;; esac
+done
+tagged_addresses() {
+  if [ -n "${POD_IPV4}" ] && [ -n "${POD_IPV6}" ]; then
+    cat <<TAGGED
+  tagged_addresses {
+    lan_ipv4 {
+      address = "${POD_IPV4}"
+      port = $1
+    }
+    lan_ipv6 {
+      address = "${POD_IPV6}"
+      port = $1
+    }
+  }
+TAGGED
+  fi
+}
+
 export CONSUL_HTTP_ADDR="${HOST_IP}:8500"
 export CONSUL_GRPC_ADDR="${HOST_IP}:8502"
 
@@ -560,6 +626,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   namespace = "default"
 
   proxy {
@@ -569,7 +636,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -585,6 +652,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 0
+$(tagged_addresses 0)
   namespace = "default"
 }
 EOF
@@ -615,7 +683,33 @@ cp /bin/consul /consul/connect-inject/consul`,
 				ConsulDestinationNamespace: "non-default",
 			},
 			k8sNamespace,
-			`/bin/sh -ec 
+			`/bin/sh -ec # Bracket IPv6 addresses so that they can be combined with a port. On
+# dual-stack clusters, the pod's IPv4 and IPv6 addresses are registered as
+# tagged addresses.
+case "${HOST_IP}" in *:*) HOST_IP="[${HOST_IP}]" ;; esac
+case "${POD_IP}" in *:*) POD_HOST="[${POD_IP}]" ;; *) POD_HOST="${POD_IP}" ;; esac
+POD_IPV4=""
+POD_IPV6=""
+for ip in $(echo "${POD_IPS}" | tr ',' ' '); do
+  case "${ip}" in *:*) POD_IPV6="${ip}" ;; *) POD_IPV4="${ip}" ;; esac
+done
+tagged_addresses() {
+  if [ -n "${POD_IPV4}" ] && [ -n "${POD_IPV6}" ]; then
+    cat <<TAGGED
+  tagged_addresses {
+    lan_ipv4 {
+      address = "${POD_IPV4}"
+      port = $1
+    }
+    lan_ipv6 {
+      address = "${POD_IPV6}"
+      port = $1
+    }
+  }
+TAGGED
+  fi
+}
+
 export CONSUL_HTTP_ADDR="${HOST_IP}:8500"
 export CONSUL_GRPC_ADDR="${HOST_IP}:8502"
 
@@ -628,6 +722,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   namespace = "non-default"
 
   proxy {
@@ -637,7 +732,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -653,6 +748,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 0
+$(tagged_addresses 0)
   namespace = "non-default"
 }
 EOF
@@ -684,7 +780,33 @@ cp /bin/consul /consul/connect-inject/consul`,
 				ConsulDestinationNamespace: "non-default",
 			},
 			k8sNamespace,
-			`/bin/sh -ec 
+			`/bin/sh -ec # Bracket IPv6 addresses so that they can be combined with a port. On
+# dual-stack clusters, the pod's IPv4 and IPv6 addresses are registered as
+# tagged addresses.
+case "${HOST_IP}" in *:*) HOST_IP="[${HOST_IP}]" ;; esac
+case "${POD_IP}" in *:*) POD_HOST="[${POD_IP}]" ;; *) POD_HOST="${POD_IP}" ;; esac
+POD_IPV4=""
+POD_IPV6=""
+for ip in $(echo "${POD_IPS}" | tr ',' ' '); do
+  case "${ip}" in *:*) POD_IPV6="${ip}" ;; *) POD_IPV4="${ip}" ;; esac
+done
+tagged_addresses() {
+  if [ -n "${POD_IPV4}" ] && [ -n "${POD_IPV6}" ]; then
+    cat <<TAGGED
+  tagged_addresses {
+    lan_ipv4 {
+      address = "${POD_IPV4}"
+      port = $1
+    }
+    lan_ipv6 {
+      address = "${POD_IPV6}"
+      port = $1
+    }
+  }
+TAGGED
+  fi
+}
+
 export CONSUL_HTTP_ADDR="${HOST_IP}:8500"
 export CONSUL_GRPC_ADDR="${HOST_IP}:8502"
 
@@ -697,6 +819,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   namespace = "non-default"
 
   proxy {
@@ -706,7 +829,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -722,6 +845,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 0
+$(tagged_addresses 0)
   namespace = "non-default"
 }
 EOF
@@ -762,7 +886,33 @@ cp /bin/consul /consul/connect-inject/consul`,
 				EnableK8SNSMirroring:       true,
 			},
 			k8sNamespace,
-			`/bin/sh -ec 
+			`/bin/sh -ec # Bracket IPv6 addresses so that they can be combined with a port. On
+# dual-stack clusters, the pod's IPv4 and IPv6 addresses are registered as
+# tagged addresses.
+case "${HOST_IP}" in *:*) HOST_IP="[${HOST_IP}]" ;; esac
+case "${POD_IP}" in *:*) POD_HOST="[${POD_IP}]" ;; *) POD_HOST="${POD_IP}" ;; esac
+POD_IPV4=""
+POD_IPV6=""
+for ip in $(echo "${POD_IPS}" | tr ',' ' '); do
+  case "${ip}" in *:*) POD_IPV6="${ip}" ;; *) POD_IPV4="${ip}" ;; esac
+done
+tagged_addresses() {
+  if [ -n "${POD_IPV4}" ] && [ -n "${POD_IPV6}" ]; then
+    cat <<TAGGED
+  tagged_addresses {
+    lan_ipv4 {
+      address = "${POD_IPV4}"
+      port = $1
+    }
+    lan_ipv6 {
+      address = "${POD_IPV6}"
+      port = $1
+    }
+  }
+TAGGED
+  fi
+}
+
 export CONSUL_HTTP_ADDR="${HOST_IP}:8500"
 export CONSUL_GRPC_ADDR="${HOST_IP}:8502"
 
@@ -775,6 +925,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   namespace = "k8snamespace"
 
   proxy {
@@ -784,7 +935,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -800,6 +951,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 0
+$(tagged_addresses 0)
   namespace = "k8snamespace"
 }
 EOF
@@ -840,7 +992,33 @@ cp /bin/consul /consul/connect-inject/consul`,
 				ConsulDestinationNamespace: "non-default",
 			},
 			k8sNamespace,
-			`/bin/sh -ec 
+			`/bin/sh -ec # Bracket IPv6 addresses so that they can be combined with a port. On
+# dual-stack clusters, the pod's IPv4 and IPv6 addresses are registered as
+# tagged addresses.
+case "${HOST_IP}" in *:*) HOST_IP="[${HOST_IP}]" ;; esac
+case "${POD_IP}" in *:*) POD_HOST="[${POD_IP}]" ;; *) POD_HOST="${POD_IP}" ;; esac
+POD_IPV4=""
+POD_IPV6=""
+for ip in $(echo "${POD_IPS}" | tr ',' ' '); do
+  case "${ip}" in *:*) POD_IPV6="${ip}" ;; *) POD_IPV4="${ip}" ;; esac
+done
+tagged_addresses() {
+  if [ -n "${POD_IPV4}" ] && [ -n "${POD_IPV6}" ]; then
+    cat <<TAGGED
+  tagged_addresses {
+    lan_ipv4 {
+      address = "${POD_IPV4}"
+      port = $1
+    }
+    lan_ipv6 {
+      address = "${POD_IPV6}"
+      port = $1
+    }
+  }
+TAGGED
+  fi
+}
+
 export CONSUL_HTTP_ADDR="${HOST_IP}:8500"
 export CONSUL_GRPC_ADDR="${HOST_IP}:8502"
 
@@ -853,6 +1031,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   namespace = "non-default"
 
   proxy {
@@ -862,7 +1041,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -878,6 +1057,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 0
+$(tagged_addresses 0)
   namespace = "non-default"
 }
 EOF
@@ -922,7 +1102,33 @@ cp /bin/consul /consul/connect-inject/consul`,
 				EnableK8SNSMirroring:       true,
 			},
 			k8sNamespace,
-			`/bin/sh -ec 
+			`/bin/sh -ec # Bracket IPv6 addresses so that they can be combined with a port. On
+# dual-stack clusters, the pod's IPv4 and IPv6 addresses are registered as
+# tagged addresses.
+case "${HOST_IP}" in *:*) HOST_IP="[${HOST_IP}]" ;; esac
+case "${POD_IP}" in *:*) POD_HOST="[${POD_IP}]" ;; *) POD_HOST="${POD_IP}" ;; esac
+POD_IPV4=""
+POD_IPV6=""
+for ip in $(echo "${POD_IPS}" | tr ',' ' '); do
+  case "${ip}" in *:*) POD_IPV6="${ip}" ;; *) POD_IPV4="${ip}" ;; esac
+done
+tagged_addresses() {
+  if [ -n "${POD_IPV4}" ] && [ -n "${POD_IPV6}" ]; then
+    cat <<TAGGED
+  tagged_addresses {
+    lan_ipv4 {
+      address = "${POD_IPV4}"
+      port = $1
+    }
+    lan_ipv6 {
+      address = "${POD_IPV6}"
+      port = $1
+    }
+  }
+TAGGED
+  fi
+}
+
 export CONSUL_HTTP_ADDR="${HOST_IP}:8500"
 export CONSUL_GRPC_ADDR="${HOST_IP}:8502"
 
@@ -935,6 +1141,7 @@ services {
   kind = "connect-proxy"
   address = "${POD_IP}"
   port = 20000
+$(tagged_addresses 20000)
   namespace = "k8snamespace"
 
   proxy {
@@ -944,7 +1151,7 @@ services {
 
   checks {
     name = "Proxy Public Listener"
-    tcp = "${POD_IP}:20000"
+    tcp = "${POD_HOST}:20000"
     interval = "10s"
     deregister_critical_service_after = "10m"
   }
@@ -960,6 +1167,7 @@ services {
   name = "web"
   address = "${POD_IP}"
   port = 0
+$(tagged_addresses 0)
   namespace = "k8snamespace"
 }
 EOF
@@ -11,19 +11,42 @@ import (
 type sidecarContainerCommandData struct {
 	AuthMethod      string
 	ConsulNamespace string
+
+	// DrainCompleteFile is the file the lifecycle sidecar writes once it
+	// has drained Envoy. If set, the preStop hook waits up to DrainWait
+	// seconds for it before deregistering.
+	DrainCompleteFile string
+	DrainWait         int
+
+	// IPv6HTTPAddr is CONSUL_HTTP_ADDR with the host IP bracketed, which
+	// the hook exports if the host IP is an IPv6 address since Kubernetes
+	// doesn't bracket it in "$(HOST_IP):8500".
+	IPv6HTTPAddr string
 }
 
 func (h *Handler) envoySidecar(pod *corev1.Pod, k8sNamespace string) (corev1.Container, error) {
 	templateData := sidecarContainerCommandData{
 		AuthMethod:      h.AuthMethod,
 		ConsulNamespace: h.consulNamespace(k8sNamespace),
+		IPv6HTTPAddr:    "[${HOST_IP}]:8500",
+	}
+	if h.ConsulCACert != "" {
+		templateData.IPv6HTTPAddr = "https://[${HOST_IP}]:8501"
+	}
+	timeout, drain, err := drainTimeout(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	if drain {
+		// Give the lifecycle sidecar some extra time on top of the
+		// drain timeout to deregister the services.
+		templateData.DrainCompleteFile = drainCompleteFile
+		templateData.DrainWait = int(timeout.Seconds()) + 5
 	}
 
 	// Render the command
 	var buf bytes.Buffer
-	tpl := template.Must(template.New("root").Parse(strings.TrimSpace(
-		sidecarPreStopCommandTpl)))
-	err := tpl.Execute(&buf, &templateData)
+	err = sidecarPreStopCommand.Execute(&buf, &templateData)
 	if err != nil {
 		return corev1.Container{}, err
 	}
@@ -81,7 +104,20 @@ func (h *Handler) envoySidecar(pod *corev1.Pod, k8sNamespace string) (corev1.Con
 	return container, nil
 }
 
+// sidecarPreStopCommand is sidecarPreStopCommandTpl, parsed once rather than
+// for each pod.
+var sidecarPreStopCommand = template.Must(template.New("root").Parse(strings.TrimSpace(
+	sidecarPreStopCommandTpl)))
+
 const sidecarPreStopCommandTpl = `
+case "${HOST_IP}" in *:*) export CONSUL_HTTP_ADDR="{{ .IPv6HTTPAddr }}" ;; esac
+{{- if .DrainCompleteFile }}
+i=0
+while [ ! -f "{{ .DrainCompleteFile }}" ] && [ $i -lt {{ .DrainWait }} ]; do
+  sleep 1
+  i=$((i+1))
+done
+{{- end }}
 /consul/connect-inject/consul services deregister \
   {{- if .AuthMethod }}
   -token-file="/consul/connect-inject/acl-token" \
@@ -36,7 +36,8 @@ func TestHandlerEnvoySidecar(t *testing.T) {
 	})
 
 	preStopCommand := strings.Join(container.Lifecycle.PreStop.Exec.Command, " ")
-	require.Equal(preStopCommand, `/bin/sh -ec /consul/connect-inject/consul services deregister \
+	require.Equal(preStopCommand, `/bin/sh -ec case "${HOST_IP}" in *:*) export CONSUL_HTTP_ADDR="[${HOST_IP}]:8500" ;; esac
+/consul/connect-inject/consul services deregister \
   /consul/connect-inject/service.hcl`)
 
 	require.Equal(container.VolumeMounts, []corev1.VolumeMount{
@@ -86,13 +87,54 @@ func TestHandlerEnvoySidecar_AuthMethod(t *testing.T) {
 	require.NoError(err)
 
 	preStopCommand := strings.Join(container.Lifecycle.PreStop.Exec.Command, " ")
-	require.Equal(preStopCommand, `/bin/sh -ec /consul/connect-inject/consul services deregister \
+	require.Equal(preStopCommand, `/bin/sh -ec case "${HOST_IP}" in *:*) export CONSUL_HTTP_ADDR="[${HOST_IP}]:8500" ;; esac
+/consul/connect-inject/consul services deregister \
   -token-file="/consul/connect-inject/acl-token" \
   /consul/connect-inject/service.hcl
 && /consul/connect-inject/consul logout \
   -token-file="/consul/connect-inject/acl-token"`)
 }
 
+// Test that if the drain timeout annotation is set the preStop command
+// waits for the lifecycle sidecar to finish draining Envoy.
+func TestHandlerEnvoySidecar_DrainTimeout(t *testing.T) {
+	require := require.New(t)
+	h := Handler{}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationService:      "foo",
+				annotationDrainTimeout: "20s",
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+				},
+			},
+		},
+	}
+	container, err := h.envoySidecar(pod, k8sNamespace)
+	require.NoError(err)
+
+	preStopCommand := strings.Join(container.Lifecycle.PreStop.Exec.Command, " ")
+	require.Equal(preStopCommand, `/bin/sh -ec case "${HOST_IP}" in *:*) export CONSUL_HTTP_ADDR="[${HOST_IP}]:8500" ;; esac
+i=0
+while [ ! -f "/consul/connect-inject/drain-complete" ] && [ $i -lt 25 ]; do
+  sleep 1
+  i=$((i+1))
+done
+/consul/connect-inject/consul services deregister \
+  /consul/connect-inject/service.hcl`)
+
+	pod.Annotations[annotationDrainTimeout] = "foo"
+	_, err = h.envoySidecar(pod, k8sNamespace)
+	require.Error(err)
+	require.Contains(err.Error(), `invalid consul.hashicorp.com/connect-drain-timeout annotation "foo"`)
+}
+
 // If Consul CA cert is set,
 // Consul addresses should use HTTPS
 // and CA cert should be set as env variable
@@ -134,6 +176,10 @@ func TestHandlerEnvoySidecar_WithTLS(t *testing.T) {
 			Value: "https://$(HOST_IP):8501",
 		},
 	})
+
+	// The preStop hook brackets the host IP on IPv6 clusters.
+	preStopCommand := strings.Join(container.Lifecycle.PreStop.Exec.Command, " ")
+	require.Contains(preStopCommand, `case "${HOST_IP}" in *:*) export CONSUL_HTTP_ADDR="https://[${HOST_IP}]:8501" ;; esac`)
 }
 
 // Test that the pre-stop command is modified when namespaces
@@ -165,7 +211,8 @@ func TestHandlerEnvoySidecar_Namespaces(t *testing.T) {
 	require.NoError(err)
 
 	preStopCommand := strings.Join(container.Lifecycle.PreStop.Exec.Command, " ")
-	require.Equal(preStopCommand, `/bin/sh -ec /consul/connect-inject/consul services deregister \
+	require.Equal(preStopCommand, `/bin/sh -ec case "${HOST_IP}" in *:*) export CONSUL_HTTP_ADDR="[${HOST_IP}]:8500" ;; esac
+/consul/connect-inject/consul services deregister \
   -namespace="k8snamespace" \
   /consul/connect-inject/service.hcl`)
 }
@@ -196,7 +243,8 @@ func TestHandlerEnvoySidecar_NamespacesAndAuthMethod(t *testing.T) {
 	require.NoError(err)
 
 	preStopCommand := strings.Join(container.Lifecycle.PreStop.Exec.Command, " ")
-	require.Equal(preStopCommand, `/bin/sh -ec /consul/connect-inject/consul services deregister \
+	require.Equal(preStopCommand, `/bin/sh -ec case "${HOST_IP}" in *:*) export CONSUL_HTTP_ADDR="[${HOST_IP}]:8500" ;; esac
+/consul/connect-inject/consul services deregister \
   -token-file="/consul/connect-inject/acl-token" \
   -namespace="k8snamespace" \
   /consul/connect-inject/service.hcl
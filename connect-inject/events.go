@@ -0,0 +1,51 @@
+package connectinject
+
+import (
+	"k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EventReasonInjectionFailed is the reason of the events recorded when a
+// pod fails to be injected.
+const EventReasonInjectionFailed = "ConnectInjectionFailed"
+
+// injectionError records the failure to inject pod as an event and returns
+// the admission response with its message.
+func (h *Handler) injectionError(pod *corev1.Pod, namespace, message string) *v1beta1.AdmissionResponse {
+	if h.EventRecorder != nil {
+		if ref := eventObject(pod, namespace); ref != nil {
+			h.EventRecorder.Event(ref, corev1.EventTypeWarning, EventReasonInjectionFailed, message)
+		}
+	}
+	return &v1beta1.AdmissionResponse{
+		Result: &metav1.Status{
+			Message: message,
+		},
+	}
+}
+
+// eventObject returns the object to record the events of pod on. Since the
+// pod isn't created if it fails to be injected, that's its controller, e.g.
+// its ReplicaSet, so that the events show up in `kubectl describe`. It
+// returns nil if the pod has neither a controller nor a name.
+func eventObject(pod *corev1.Pod, namespace string) *corev1.ObjectReference {
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		return &corev1.ObjectReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			Namespace:  namespace,
+			UID:        owner.UID,
+		}
+	}
+	if pod.Name == "" {
+		return nil
+	}
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       pod.Name,
+		Namespace:  namespace,
+	}
+}
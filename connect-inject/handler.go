@@ -1,14 +1,21 @@
 package connectinject
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul-k8s/helper/audit"
+	"github.com/hashicorp/consul-k8s/helper/metrics"
+	"github.com/hashicorp/consul-k8s/helper/tracing"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mattbaird/jsonpatch"
@@ -17,11 +24,18 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
 	DefaultConsulImage = "consul:1.7.1"
 	DefaultEnvoyImage  = "envoyproxy/envoy-alpine:v1.13.0"
+
+	// DefaultLifecycleSidecarUID and DefaultLifecycleSidecarGID are the
+	// user and group the lifecycle sidecar runs as. They match the non-root
+	// consul-k8s user of the hashicorp/consul-k8s image.
+	DefaultLifecycleSidecarUID int64 = 100
+	DefaultLifecycleSidecarGID int64 = 1000
 )
 
 const (
@@ -74,8 +88,33 @@ const (
 
 	// annotationSyncPeriod controls the -sync-period flag passed to the
 	// consul-k8s lifecycle-sidecar command. This flag controls how often the
-	// service is synced (i.e. re-registered) with the local agent.
+	// sidecar retries syncing (i.e. re-registering) the service with the
+	// local agent when a sync fails.
 	annotationSyncPeriod = "consul.hashicorp.com/connect-sync-period"
+
+	// annotationDrainTimeout enables draining Envoy when the pod is deleted
+	// and controls the -drain-timeout flag passed to the consul-k8s
+	// lifecycle-sidecar command. The sidecar fails Envoy's health checks,
+	// drains its listeners and waits up to this long for in-flight requests
+	// before deregistering the service. Envoy's preStop hook waits for the
+	// drain to complete so that Envoy isn't killed while draining.
+	annotationDrainTimeout = "consul.hashicorp.com/connect-drain-timeout"
+
+	// annotationEnableMetricsMerging enables serving Envoy's and the
+	// service's metrics merged on a single endpoint from the lifecycle
+	// sidecar. annotationServiceMetricsPort and annotationServiceMetricsPath
+	// set where the service's metrics are scraped from. They default to the
+	// service port and /metrics. If no port is known, only Envoy's metrics
+	// are served.
+	annotationEnableMetricsMerging = "consul.hashicorp.com/enable-metrics-merging"
+	annotationServiceMetricsPort   = "consul.hashicorp.com/service-metrics-port"
+	annotationServiceMetricsPath   = "consul.hashicorp.com/service-metrics-path"
+
+	// annotationEnvoyMetricsAllow and annotationEnvoyMetricsDrop are regular
+	// expressions passed to the -envoy-metrics-allow and -envoy-metrics-drop
+	// flags of the lifecycle sidecar to filter the merged Envoy metrics.
+	annotationEnvoyMetricsAllow = "consul.hashicorp.com/envoy-metrics-allow"
+	annotationEnvoyMetricsDrop  = "consul.hashicorp.com/envoy-metrics-drop"
 )
 
 var (
@@ -102,6 +141,13 @@ type Handler struct {
 	// This image is used for the lifecycle-sidecar container.
 	ImageConsulK8S string
 
+	// LifecycleSidecarUID and LifecycleSidecarGID are the user and group
+	// the lifecycle-sidecar container runs as. They must not be root. If
+	// unset, DefaultLifecycleSidecarUID and DefaultLifecycleSidecarGID are
+	// used.
+	LifecycleSidecarUID int64
+	LifecycleSidecarGID int64
+
 	// RequireAnnotation means that the annotation must be given to inject.
 	// If this is false, injection is default.
 	RequireAnnotation bool
@@ -163,8 +209,17 @@ type Handler struct {
 	// Only necessary if ACLs are enabled.
 	CrossNamespaceACLPolicy string
 
+	// EventRecorder records the failures to inject the pods as events on
+	// their controllers. If nil, they're only logged.
+	EventRecorder record.EventRecorder
+
 	// Log
 	Log hclog.Logger
+
+	// namespaces is the *namespaceCache of the Consul namespaces known to
+	// exist, so that they're read from Consul once per namespaceCheckPeriod
+	// rather than for each pod.
+	namespaces atomic.Value
 }
 
 // Handle is the http.HandlerFunc implementation that actually handles the
@@ -203,7 +258,15 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 		h.Log.Error("Could not decode admission request", "err", err)
 		admResp.Response = admissionError(err)
 	} else {
-		admResp.Response = h.Mutate(admReq.Request)
+		start := time.Now()
+		ctx, span := tracing.StartServer(r, "admission")
+		admResp.Response = h.Mutate(ctx, admReq.Request)
+		var mutateErr error
+		if !admResp.Response.Allowed && admResp.Response.Result != nil {
+			mutateErr = errors.New(admResp.Response.Result.Message)
+		}
+		metrics.ObserveOperation("inject", start, mutateErr)
+		span.End(mutateErr)
 	}
 
 	resp, err := json.Marshal(&admResp)
@@ -220,8 +283,9 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 }
 
 // Mutate takes an admission request and performs mutation if necessary,
-// returning the final API response.
-func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+// returning the final API response. The span of ctx, if any, is the parent
+// of the Consul API requests.
+func (h *Handler) Mutate(ctx context.Context, req *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
 	// Decode the pod from the request
 	var pod corev1.Pod
 	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
@@ -233,6 +297,14 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 		}
 	}
 
+	// The pods are usually named by their controller after the admission,
+	// so they're logged with their generate name.
+	podName := pod.Name
+	if podName == "" {
+		podName = pod.GenerateName
+	}
+	log := h.Log.With("namespace", req.Namespace, "pod", podName)
+
 	// Build the basic response
 	resp := &v1beta1.AdmissionResponse{
 		Allowed: true,
@@ -245,23 +317,21 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 	// Setup the default annotation values that are used for the container.
 	// This MUST be done before shouldInject is called since k.
 	if err := h.defaultAnnotations(&pod, &patches); err != nil {
-		h.Log.Error("Error creating default annotations", "err", err, "Request Name", req.Name)
-		return &v1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: fmt.Sprintf("Error creating default annotations: %s", err),
-			},
-		}
+		log.Error("Error creating default annotations", "err", err)
+		return h.injectionError(&pod, req.Namespace, fmt.Sprintf("Error creating default annotations: %s", err))
 	}
+	log = log.With("service", pod.Annotations[annotationService])
+	tracing.FromContext(ctx).SetAttributes(
+		tracing.String("k8s.namespace.name", req.Namespace),
+		tracing.String("k8s.pod.name", podName),
+		tracing.String("consul.service.name", pod.Annotations[annotationService]),
+	)
 
 	// Check if we should inject, for example we don't inject in the
 	// system namespaces.
 	if shouldInject, err := h.shouldInject(&pod, req.Namespace); err != nil {
-		h.Log.Error("Error checking if should inject", "err", err, "Request Name", req.Name)
-		return &v1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: fmt.Sprintf("Error checking if should inject: %s", err),
-			},
-		}
+		log.Error("Error checking if should inject", "err", err)
+		return h.injectionError(&pod, req.Namespace, fmt.Sprintf("Error checking if should inject: %s", err))
 	} else if !shouldInject {
 		return resp
 	}
@@ -292,12 +362,8 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 	// the Envoy configuration.
 	container, err := h.containerInit(&pod, req.Namespace)
 	if err != nil {
-		h.Log.Error("Error configuring injection init container", "err", err, "Request Name", req.Name)
-		return &v1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: fmt.Sprintf("Error configuring injection init container: %s", err),
-			},
-		}
+		log.Error("Error configuring injection init container", "err", err)
+		return h.injectionError(&pod, req.Namespace, fmt.Sprintf("Error configuring injection init container: %s", err))
 	}
 	patches = append(patches, addContainer(
 		pod.Spec.InitContainers,
@@ -307,14 +373,14 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 	// Add the Envoy and lifecycle sidecars.
 	esContainer, err := h.envoySidecar(&pod, req.Namespace)
 	if err != nil {
-		h.Log.Error("Error configuring injection sidecar container", "err", err, "Request Name", req.Name)
-		return &v1beta1.AdmissionResponse{
-			Result: &metav1.Status{
-				Message: fmt.Sprintf("Error configuring injection sidecar container: %s", err),
-			},
-		}
+		log.Error("Error configuring injection sidecar container", "err", err)
+		return h.injectionError(&pod, req.Namespace, fmt.Sprintf("Error configuring injection sidecar container: %s", err))
+	}
+	connectContainer, err := h.lifecycleSidecar(&pod, req.Namespace)
+	if err != nil {
+		log.Error("Error configuring lifecycle sidecar container", "err", err)
+		return h.injectionError(&pod, req.Namespace, fmt.Sprintf("Error configuring lifecycle sidecar container: %s", err))
 	}
-	connectContainer := h.lifecycleSidecar(&pod)
 	patches = append(patches, addContainer(
 		pod.Spec.Containers,
 		[]corev1.Container{esContainer, connectContainer},
@@ -331,12 +397,8 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 		var err error
 		patch, err = json.Marshal(patches)
 		if err != nil {
-			h.Log.Error("Could not marshal patches", "err", err, "Request Name", req.Name)
-			return &v1beta1.AdmissionResponse{
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("Could not marshal patches: %s", err),
-				},
-			}
+			log.Error("Could not marshal patches", "err", err)
+			return h.injectionError(&pod, req.Namespace, fmt.Sprintf("Could not marshal patches: %s", err))
 		}
 
 		resp.Patch = patch
@@ -349,14 +411,12 @@ func (h *Handler) Mutate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionRespon
 	// that process before modifying the Consul cluster.
 	if h.EnableNamespaces {
 		// Check if the namespace exists. If not, create it.
-		if err := h.checkAndCreateNamespace(h.consulNamespace(req.Namespace)); err != nil {
-			h.Log.Error("Error checking or creating namespace", "err", err,
-				"Namespace", h.consulNamespace(req.Namespace), "Request Name", req.Name)
-			return &v1beta1.AdmissionResponse{
-				Result: &metav1.Status{
-					Message: fmt.Sprintf("Error checking or creating namespace: %s", err),
-				},
-			}
+		// The namespace is audited as created for the pod.
+		ctx := audit.WithCause(ctx, audit.Cause{Kind: "Pod", Namespace: req.Namespace, Name: podName})
+		if err := h.checkAndCreateNamespace(ctx, h.consulNamespace(req.Namespace)); err != nil {
+			log.Error("Error checking or creating namespace", "err", err,
+				"consul-namespace", h.consulNamespace(req.Namespace))
+			return h.injectionError(&pod, req.Namespace, fmt.Sprintf("Error checking or creating namespace: %s", err))
 		}
 	}
 
@@ -483,9 +543,14 @@ func (h *Handler) consulNamespace(ns string) string {
 	}
 }
 
-func (h *Handler) checkAndCreateNamespace(ns string) error {
+func (h *Handler) checkAndCreateNamespace(ctx context.Context, ns string) error {
+	cache := h.namespaceCache()
+	if cache.exists(ns) {
+		return nil
+	}
+
 	// Check if the Consul namespace exists
-	namespaceInfo, _, err := h.ConsulClient.Namespaces().Read(ns, nil)
+	namespaceInfo, _, err := h.ConsulClient.Namespaces().Read(ns, (&api.QueryOptions{}).WithContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -510,15 +575,49 @@ func (h *Handler) checkAndCreateNamespace(ns string) error {
 			Meta:        map[string]string{"external-source": "kubernetes"},
 		}
 
-		_, _, err = h.ConsulClient.Namespaces().Create(&consulNamespace, nil)
+		_, _, err = h.ConsulClient.Namespaces().Create(&consulNamespace, (&api.WriteOptions{}).WithContext(ctx))
 		if err != nil {
 			return err
 		}
 	}
 
+	cache.add(ns)
 	return nil
 }
 
+func (h *Handler) namespaceCache() *namespaceCache {
+	if cache, ok := h.namespaces.Load().(*namespaceCache); ok {
+		return cache
+	}
+	h.namespaces.CompareAndSwap(nil, &namespaceCache{checked: make(map[string]time.Time)})
+	return h.namespaces.Load().(*namespaceCache)
+}
+
+// namespaceCheckPeriod is how long a Consul namespace is known to exist
+// after it was read or created. It's re-read after that in case it was
+// deleted.
+const namespaceCheckPeriod = 1 * time.Minute
+
+// namespaceCache is the set of the Consul namespaces known to exist, mapped
+// to when they were last read or created.
+type namespaceCache struct {
+	lock    sync.Mutex
+	checked map[string]time.Time
+}
+
+func (c *namespaceCache) exists(ns string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	checked, ok := c.checked[ns]
+	return ok && time.Since(checked) < namespaceCheckPeriod
+}
+
+func (c *namespaceCache) add(ns string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.checked[ns] = time.Now()
+}
+
 func portValue(pod *corev1.Pod, value string) (int32, error) {
 	// First search for the named port
 	for _, c := range pod.Spec.Containers {
@@ -3,6 +3,7 @@
 package connectinject
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -207,7 +208,7 @@ func TestHandler_MutateWithNamespaces(t *testing.T) {
 			tt.Handler.ConsulClient = client
 
 			// Mutate!
-			resp := tt.Handler.Mutate(&tt.Req)
+			resp := tt.Handler.Mutate(context.Background(), &tt.Req)
 			require.Equal(resp.Allowed, true)
 
 			// Check all the namespace things
@@ -471,7 +472,7 @@ func TestHandler_MutateWithNamespaces_ACLs(t *testing.T) {
 			require.NoError(t, err)
 
 			// Mutate!
-			resp := tt.Handler.Mutate(&tt.Req)
+			resp := tt.Handler.Mutate(context.Background(), &tt.Req)
 			require.Equal(t, resp.Allowed, true)
 
 			// Check all the namespace things
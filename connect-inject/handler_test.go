@@ -1,12 +1,17 @@
 package connectinject
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mattbaird/jsonpatch"
 	"github.com/stretchr/testify/require"
@@ -14,6 +19,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestHandlerHandle(t *testing.T) {
@@ -342,7 +348,7 @@ func TestHandlerHandle(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.Name, func(t *testing.T) {
 			require := require.New(t)
-			resp := tt.Handler.Mutate(&tt.Req)
+			resp := tt.Handler.Mutate(context.Background(), &tt.Req)
 			if (tt.Err == "") != resp.Allowed {
 				t.Fatalf("allowed: %v, expected err: %v", resp.Allowed, tt.Err)
 			}
@@ -363,6 +369,67 @@ func TestHandlerHandle(t *testing.T) {
 	}
 }
 
+// Test that the failures to inject are recorded as events on the
+// controller of the pod, or on the pod if it has a name.
+func TestHandlerMutate_InjectionFailedEvent(t *testing.T) {
+	owner := metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "web-5d4f8b9c6",
+		UID:        "uid",
+		Controller: func(b bool) *bool { return &b }(true),
+	}
+	cases := map[string]struct {
+		Meta     metav1.ObjectMeta
+		ExpEvent string
+	}{
+		"controller": {
+			Meta:     metav1.ObjectMeta{GenerateName: "web-5d4f8b9c6-", OwnerReferences: []metav1.OwnerReference{owner}},
+			ExpEvent: "ReplicaSet/web-5d4f8b9c6",
+		},
+		"named pod": {
+			Meta:     metav1.ObjectMeta{Name: "web"},
+			ExpEvent: "Pod/web",
+		},
+		"unnamed pod": {
+			Meta: metav1.ObjectMeta{GenerateName: "web-"},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &eventRecorder{}
+			h := Handler{Log: hclog.NewNullLogger(), EventRecorder: recorder}
+			c.Meta.Annotations = map[string]string{annotationInject: "invalid"}
+			resp := h.Mutate(context.Background(), &v1beta1.AdmissionRequest{
+				Namespace: "default",
+				Object: encodeRaw(t, &corev1.Pod{
+					ObjectMeta: c.Meta,
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+				}),
+			})
+			require.False(t, resp.Allowed)
+			if c.ExpEvent == "" {
+				require.Empty(t, recorder.events)
+				return
+			}
+			require.Equal(t, []string{
+				"default/" + c.ExpEvent + " Warning " + EventReasonInjectionFailed + ": " + resp.Result.Message,
+			}, recorder.events)
+		})
+	}
+}
+
+// eventRecorder records the events of object references.
+type eventRecorder struct {
+	record.FakeRecorder
+	events []string
+}
+
+func (r *eventRecorder) Event(object runtime.Object, eventType, reason, message string) {
+	ref := object.(*corev1.ObjectReference)
+	r.events = append(r.events, fmt.Sprintf("%s/%s/%s %s %s: %s", ref.Namespace, ref.Kind, ref.Name, eventType, reason, message))
+}
+
 // Test that an incorrect content type results in an error.
 func TestHandlerHandle_badContentType(t *testing.T) {
 	req, err := http.NewRequest("POST", "/", nil)
@@ -944,8 +1011,79 @@ func TestShouldInject(t *testing.T) {
 	}
 }
 
+// Test that the Consul namespaces known to exist aren't read for each pod.
+func TestHandlerCheckAndCreateNamespace_Cached(t *testing.T) {
+	var reads int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/namespace/ns", r.URL.Path)
+		atomic.AddInt32(&reads, 1)
+		w.Write([]byte(`{"Name": "ns"}`))
+	}))
+	defer srv.Close()
+	client, err := api.NewClient(&api.Config{Address: srv.URL})
+	require.NoError(t, err)
+
+	h := Handler{ConsulClient: client, Log: hclog.Default().Named("handler")}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, h.checkAndCreateNamespace(context.Background(), "ns"))
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&reads))
+
+	// The namespace is read again once it was last checked too long ago.
+	h.namespaceCache().checked["ns"] = time.Now().Add(-namespaceCheckPeriod)
+	require.NoError(t, h.checkAndCreateNamespace(context.Background(), "ns"))
+	require.Equal(t, int32(2), atomic.LoadInt32(&reads))
+}
+
+// Test that a mutation makes fewer than 500 allocations, so that injecting
+// the pods of a large cluster at once, e.g. when it's restored, stays cheap.
+func TestHandlerMutate_Allocs(t *testing.T) {
+	result := testing.Benchmark(BenchmarkHandlerMutate)
+	require.NotZero(t, result.N)
+	require.Less(t, result.AllocsPerOp(), int64(500))
+}
+
+// BenchmarkHandlerMutate measures the injection of a pod with upstreams,
+// without Consul namespaces.
+func BenchmarkHandlerMutate(b *testing.B) {
+	h := Handler{
+		Log:                   hclog.NewNullLogger(),
+		AllowK8sNamespacesSet: mapset.NewSet("*"),
+		DenyK8sNamespacesSet:  mapset.NewSet(),
+	}
+	req := v1beta1.AdmissionRequest{
+		Namespace: metav1.NamespaceDefault,
+		Object: encodeRaw(b, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "web-",
+				Annotations: map[string]string{
+					annotationService:   "web",
+					annotationUpstreams: "db:1234,cache:1235",
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:  "web",
+						Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+					},
+				},
+			},
+		}),
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp := h.Mutate(context.Background(), &req)
+		if !resp.Allowed || resp.Result != nil {
+			b.Fatalf("unexpected response: %v", resp.Result)
+		}
+	}
+}
+
 // encodeRaw is a helper to encode some data into a RawExtension.
-func encodeRaw(t *testing.T, input interface{}) runtime.RawExtension {
+func encodeRaw(t testing.TB, input interface{}) runtime.RawExtension {
 	data, err := json.Marshal(input)
 	require.NoError(t, err)
 	return runtime.RawExtension{Raw: data}
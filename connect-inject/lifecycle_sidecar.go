@@ -1,25 +1,122 @@
 package connectinject
 
 import (
-	corev1 "k8s.io/api/core/v1"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
-func (h *Handler) lifecycleSidecar(pod *corev1.Pod) corev1.Container {
+// lifecycleSidecarHealthPort is the port the lifecycle sidecar serves its
+// health endpoints on. The endpoints are used for the container's liveness
+// and readiness probes.
+const lifecycleSidecarHealthPort = 21000
+
+// lifecycleEndpointAddr is the localhost address the lifecycle sidecar
+// serves the application lifecycle endpoints on. Applications can poll
+// /ready to wait for the proxy and call /quitquitquit to stop the sidecars,
+// e.g. when a Job has completed.
+const lifecycleEndpointAddr = "127.0.0.1:21001"
+
+// mergedMetricsPort is the port the lifecycle sidecar serves the merged
+// Envoy and service metrics on if metrics merging is enabled.
+const mergedMetricsPort = 20100
+
+const (
+	// envoyAdminAddr is the address of the Envoy admin API. This is the
+	// default used by `consul connect envoy -bootstrap`.
+	envoyAdminAddr = "127.0.0.1:19000"
+
+	// drainCompleteFile is written by the lifecycle sidecar once it is done
+	// draining Envoy. Envoy's preStop hook waits for it.
+	drainCompleteFile = "/consul/connect-inject/drain-complete"
+)
+
+// drainTimeout returns the drain timeout set by the drain timeout
+// annotation and whether draining is enabled.
+func drainTimeout(pod *corev1.Pod) (time.Duration, bool, error) {
+	raw, ok := pod.Annotations[annotationDrainTimeout]
+	if !ok {
+		return 0, false, nil
+	}
+	timeout, err := time.ParseDuration(strings.TrimSpace(raw))
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid %s annotation %q: %s", annotationDrainTimeout, raw, err)
+	}
+	return timeout, true, nil
+}
+
+func (h *Handler) lifecycleSidecar(pod *corev1.Pod, k8sNamespace string) (corev1.Container, error) {
 	command := []string{
 		"consul-k8s",
 		"lifecycle-sidecar",
 		"-service-config", "/consul/connect-inject/service.hcl",
 		"-consul-binary", "/consul/connect-inject/consul",
+		fmt.Sprintf("-health-listen=:%d", lifecycleSidecarHealthPort),
+		"-lifecycle-listen=" + lifecycleEndpointAddr,
+		"-envoy-admin-addr=" + envoyAdminAddr,
+	}
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      volumeName,
+			MountPath: "/consul/connect-inject",
+		},
 	}
 	if h.AuthMethod != "" {
-		command = append(command, "-token-file=/consul/connect-inject/acl-token")
+		// The sidecar starts with the token written by the init container
+		// and logs in again with the auth method before it expires.
+		command = append(command,
+			"-auth-method="+h.AuthMethod,
+			"-token-sink-file=/consul/connect-inject/acl-token",
+			"-login-meta=pod=$(POD_NAMESPACE)/$(POD_NAME)",
+		)
+		if ns := h.consulNamespace(k8sNamespace); ns != "" {
+			// If namespace mirroring is enabled, the auth method is
+			// defined in the default namespace.
+			if h.EnableK8SNSMirroring {
+				ns = "default"
+			}
+			command = append(command, "-auth-method-namespace="+ns)
+		}
+
+		saTokenVolumeMount, err := findServiceAccountVolumeMount(pod)
+		if err != nil {
+			return corev1.Container{}, err
+		}
+		volumeMounts = append(volumeMounts, saTokenVolumeMount)
 	}
 
 	if period, ok := pod.Annotations[annotationSyncPeriod]; ok {
 		command = append(command, "-sync-period="+strings.TrimSpace(period))
 	}
 
+	timeout, drain, err := drainTimeout(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	if drain {
+		command = append(command,
+			"-drain-timeout="+timeout.String(),
+			"-drain-complete-file="+drainCompleteFile,
+		)
+	}
+
+	metricsArgs, err := metricsMergingArgs(pod)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	command = append(command, metricsArgs...)
+	var ports []corev1.ContainerPort
+	if len(metricsArgs) > 0 {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          "merged-metrics",
+			ContainerPort: mergedMetricsPort,
+		})
+	}
+
 	envVariables := []corev1.EnvVar{
 		{
 			Name: "HOST_IP",
@@ -28,6 +125,22 @@ func (h *Handler) lifecycleSidecar(pod *corev1.Pod) corev1.Container {
 			},
 		},
 	}
+	if h.AuthMethod != "" {
+		envVariables = append(envVariables,
+			corev1.EnvVar{
+				Name: "POD_NAME",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+				},
+			},
+			corev1.EnvVar{
+				Name: "POD_NAMESPACE",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+				},
+			},
+		)
+	}
 
 	if h.ConsulCACert != "" {
 		envVariables = append(envVariables,
@@ -52,16 +165,105 @@ func (h *Handler) lifecycleSidecar(pod *corev1.Pod) corev1.Container {
 			})
 	}
 
+	uid, gid := h.LifecycleSidecarUID, h.LifecycleSidecarGID
+	if uid == 0 {
+		uid = DefaultLifecycleSidecarUID
+	}
+	if gid == 0 {
+		gid = DefaultLifecycleSidecarGID
+	}
+
 	return corev1.Container{
-		Name:  "consul-connect-lifecycle-sidecar",
-		Image: h.ImageConsulK8S,
-		Env:   envVariables,
-		VolumeMounts: []corev1.VolumeMount{
-			{
-				Name:      volumeName,
-				MountPath: "/consul/connect-inject",
+		Name:         "consul-connect-lifecycle-sidecar",
+		Image:        h.ImageConsulK8S,
+		Env:          envVariables,
+		Ports:        ports,
+		VolumeMounts: volumeMounts,
+		Command:      command,
+		LivenessProbe: &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/health/live",
+					Port: intstr.FromInt(lifecycleSidecarHealthPort),
+				},
 			},
+			InitialDelaySeconds: 1,
+			PeriodSeconds:       10,
+		},
+		ReadinessProbe: &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/health/ready",
+					Port: intstr.FromInt(lifecycleSidecarHealthPort),
+				},
+			},
+			InitialDelaySeconds: 1,
+			PeriodSeconds:       10,
+		},
+		SecurityContext: lifecycleSidecarSecurityContext(uid, gid),
+	}, nil
+}
+
+// metricsMergingArgs returns the lifecycle sidecar flags to serve merged
+// metrics if the metrics merging annotation is set.
+func metricsMergingArgs(pod *corev1.Pod) ([]string, error) {
+	raw, ok := pod.Annotations[annotationEnableMetricsMerging]
+	if !ok {
+		return nil, nil
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation %q: %s", annotationEnableMetricsMerging, raw, err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	args := []string{
+		fmt.Sprintf("-merged-metrics-listen=:%d", mergedMetricsPort),
+		fmt.Sprintf("-envoy-metrics-url=http://%s/stats/prometheus", envoyAdminAddr),
+	}
+	rawPort, ok := pod.Annotations[annotationServiceMetricsPort]
+	if !ok {
+		rawPort = pod.Annotations[annotationPort]
+	}
+	if rawPort != "" {
+		port, err := portValue(pod, rawPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service metrics port %q: %s", rawPort, err)
+		}
+		path := pod.Annotations[annotationServiceMetricsPath]
+		if path == "" {
+			path = "/metrics"
+		}
+		args = append(args, fmt.Sprintf("-service-metrics-url=http://127.0.0.1:%d%s", port, path))
+	}
+	if allow, ok := pod.Annotations[annotationEnvoyMetricsAllow]; ok {
+		args = append(args, "-envoy-metrics-allow="+allow)
+	}
+	if drop, ok := pod.Annotations[annotationEnvoyMetricsDrop]; ok {
+		args = append(args, "-envoy-metrics-drop="+drop)
+	}
+	return args, nil
+}
+
+// lifecycleSidecarSecurityContext returns the security context of the
+// lifecycle sidecar. The sidecar doesn't need a shell and only writes to the
+// shared connect-inject volume, so it runs as a non-root user with a
+// read-only root filesystem and no capabilities. This satisfies the
+// restricted pod security policies many clusters enforce.
+func lifecycleSidecarSecurityContext(uid, gid int64) *corev1.SecurityContext {
+	runAsNonRoot := true
+	readOnlyRootFilesystem := true
+	allowPrivilegeEscalation := false
+	return &corev1.SecurityContext{
+		RunAsUser:                &uid,
+		RunAsGroup:               &gid,
+		RunAsNonRoot:             &runAsNonRoot,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
 		},
-		Command: command,
 	}
 }
@@ -1,11 +1,15 @@
 package connectinject
 
 import (
+	"testing"
+
+	lifecyclesidecar "github.com/hashicorp/consul-k8s/subcommand/lifecycle-sidecar"
 	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"testing"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // NOTE: This is tested here rather than in handler_test because doing it there
@@ -18,7 +22,7 @@ func TestLifecycleSidecar_Default(t *testing.T) {
 		Log:            hclog.Default().Named("handler"),
 		ImageConsulK8S: "hashicorp/consul-k8s:9.9.9",
 	}
-	container := handler.lifecycleSidecar(&corev1.Pod{
+	container, err := handler.lifecycleSidecar(&corev1.Pod{
 		Spec: corev1.PodSpec{
 			Containers: []corev1.Container{
 				{
@@ -26,7 +30,8 @@ func TestLifecycleSidecar_Default(t *testing.T) {
 				},
 			},
 		},
-	})
+	}, "default")
+	require.NoError(t, err)
 	require.Equal(t, corev1.Container{
 		Name:  "consul-connect-lifecycle-sidecar",
 		Image: "hashicorp/consul-k8s:9.9.9",
@@ -52,12 +57,36 @@ func TestLifecycleSidecar_Default(t *testing.T) {
 			"consul-k8s", "lifecycle-sidecar",
 			"-service-config", "/consul/connect-inject/service.hcl",
 			"-consul-binary", "/consul/connect-inject/consul",
+			"-health-listen=:21000",
+			"-lifecycle-listen=127.0.0.1:21001",
+			"-envoy-admin-addr=127.0.0.1:19000",
+		},
+		LivenessProbe: &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/health/live",
+					Port: intstr.FromInt(21000),
+				},
+			},
+			InitialDelaySeconds: 1,
+			PeriodSeconds:       10,
+		},
+		ReadinessProbe: &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/health/ready",
+					Port: intstr.FromInt(21000),
+				},
+			},
+			InitialDelaySeconds: 1,
+			PeriodSeconds:       10,
 		},
+		SecurityContext: lifecycleSidecarSecurityContext(DefaultLifecycleSidecarUID, DefaultLifecycleSidecarGID),
 	}, container)
 }
 
-// Test that if there's an auth method we set the -token-file flag
-// and if there isn't we don't.
+// Test that if there's an auth method we set the flags to log in with it
+// and mount the service account token, and if there isn't we don't.
 func TestLifecycleSidecar_AuthMethod(t *testing.T) {
 	for _, authMethod := range []string{"", "auth-method"} {
 		t.Run("authmethod: "+authMethod, func(t *testing.T) {
@@ -66,24 +95,90 @@ func TestLifecycleSidecar_AuthMethod(t *testing.T) {
 				AuthMethod:     authMethod,
 				ImageConsulK8S: "hashicorp/consul-k8s:9.9.9",
 			}
-			container := handler.lifecycleSidecar(&corev1.Pod{
+			container, err := handler.lifecycleSidecar(&corev1.Pod{
 				Spec: corev1.PodSpec{
 					Containers: []corev1.Container{
 						{
 							Name: "web",
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "default-token-podid",
+									ReadOnly:  true,
+									MountPath: "/var/run/secrets/kubernetes.io/serviceaccount",
+								},
+							},
 						},
 					},
 				},
-			})
+			}, "default")
+			require.NoError(t, err)
 
+			loginFlags := []string{
+				"-auth-method=auth-method",
+				"-token-sink-file=/consul/connect-inject/acl-token",
+				"-login-meta=pod=$(POD_NAMESPACE)/$(POD_NAME)",
+			}
 			if authMethod == "" {
-				require.NotContains(t, container.Command, "-token-file=/consul/connect-inject/acl-token")
+				for _, f := range loginFlags {
+					require.NotContains(t, container.Command, f)
+				}
+				require.Len(t, container.VolumeMounts, 1)
 			} else {
-				require.Contains(t,
-					container.Command,
-					"-token-file=/consul/connect-inject/acl-token",
-				)
+				for _, f := range loginFlags {
+					require.Contains(t, container.Command, f)
+				}
+				require.Contains(t, container.VolumeMounts, corev1.VolumeMount{
+					Name:      "default-token-podid",
+					ReadOnly:  true,
+					MountPath: "/var/run/secrets/kubernetes.io/serviceaccount",
+				})
+			}
+		})
+	}
+}
+
+// Test that the auth method namespace is set when namespaces are enabled.
+func TestLifecycleSidecar_AuthMethodNamespace(t *testing.T) {
+	cases := map[string]struct {
+		Mirroring bool
+		ExpFlag   string
+	}{
+		"destination namespace": {
+			Mirroring: false,
+			ExpFlag:   "-auth-method-namespace=dest",
+		},
+		"mirroring": {
+			Mirroring: true,
+			ExpFlag:   "-auth-method-namespace=default",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			handler := Handler{
+				Log:                        hclog.Default().Named("handler"),
+				AuthMethod:                 "auth-method",
+				ImageConsulK8S:             "hashicorp/consul-k8s:9.9.9",
+				EnableNamespaces:           true,
+				ConsulDestinationNamespace: "dest",
+				EnableK8SNSMirroring:       c.Mirroring,
 			}
+			container, err := handler.lifecycleSidecar(&corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "web",
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "default-token-podid",
+									MountPath: "/var/run/secrets/kubernetes.io/serviceaccount",
+								},
+							},
+						},
+					},
+				},
+			}, "k8snamespace")
+			require.NoError(t, err)
+			require.Contains(t, container.Command, c.ExpFlag)
 		})
 	}
 }
@@ -95,7 +190,7 @@ func TestLifecycleSidecar_SyncPeriodAnnotation(t *testing.T) {
 		Log:            hclog.Default().Named("handler"),
 		ImageConsulK8S: "hashicorp/consul-k8s:9.9.9",
 	}
-	container := handler.lifecycleSidecar(&corev1.Pod{
+	container, err := handler.lifecycleSidecar(&corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
 			Annotations: map[string]string{
 				"consul.hashicorp.com/connect-sync-period": "55s",
@@ -108,11 +203,37 @@ func TestLifecycleSidecar_SyncPeriodAnnotation(t *testing.T) {
 				},
 			},
 		},
-	})
-
+	}, "default")
+	require.NoError(t, err)
 	require.Contains(t, container.Command, "-sync-period=55s")
 }
 
+// Test that if there's a drain timeout annotation the sidecar drains Envoy.
+func TestLifecycleSidecar_DrainTimeoutAnnotation(t *testing.T) {
+	handler := Handler{
+		Log:            hclog.Default().Named("handler"),
+		ImageConsulK8S: "hashicorp/consul-k8s:9.9.9",
+	}
+	container, err := handler.lifecycleSidecar(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				"consul.hashicorp.com/connect-drain-timeout": "20s",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+				},
+			},
+		},
+	}, "default")
+	require.NoError(t, err)
+	require.Contains(t, container.Command, "-envoy-admin-addr=127.0.0.1:19000")
+	require.Contains(t, container.Command, "-drain-timeout=20s")
+	require.Contains(t, container.Command, "-drain-complete-file=/consul/connect-inject/drain-complete")
+}
+
 // Test that the Consul address uses HTTPS
 // and that the CA is provided
 func TestLifecycleSidecar_TLS(t *testing.T) {
@@ -121,7 +242,7 @@ func TestLifecycleSidecar_TLS(t *testing.T) {
 		ImageConsulK8S: "hashicorp/consul-k8s:9.9.9",
 		ConsulCACert:   "consul-ca-cert",
 	}
-	container := handler.lifecycleSidecar(&corev1.Pod{
+	container, err := handler.lifecycleSidecar(&corev1.Pod{
 		Spec: corev1.PodSpec{
 			Containers: []corev1.Container{
 				{
@@ -129,7 +250,8 @@ func TestLifecycleSidecar_TLS(t *testing.T) {
 				},
 			},
 		},
-	})
+	}, "default")
+	require.NoError(t, err)
 	require.Equal(t, corev1.Container{
 		Name:  "consul-connect-lifecycle-sidecar",
 		Image: "hashicorp/consul-k8s:9.9.9",
@@ -159,6 +281,195 @@ func TestLifecycleSidecar_TLS(t *testing.T) {
 			"consul-k8s", "lifecycle-sidecar",
 			"-service-config", "/consul/connect-inject/service.hcl",
 			"-consul-binary", "/consul/connect-inject/consul",
+			"-health-listen=:21000",
+			"-lifecycle-listen=127.0.0.1:21001",
+			"-envoy-admin-addr=127.0.0.1:19000",
+		},
+		LivenessProbe: &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/health/live",
+					Port: intstr.FromInt(21000),
+				},
+			},
+			InitialDelaySeconds: 1,
+			PeriodSeconds:       10,
+		},
+		ReadinessProbe: &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/health/ready",
+					Port: intstr.FromInt(21000),
+				},
+			},
+			InitialDelaySeconds: 1,
+			PeriodSeconds:       10,
 		},
+		SecurityContext: lifecycleSidecarSecurityContext(DefaultLifecycleSidecarUID, DefaultLifecycleSidecarGID),
 	}, container)
 }
+
+// Test that the lifecycle sidecar runs as a non-root user with a read-only
+// root filesystem and no capabilities.
+func TestLifecycleSidecar_SecurityContext(t *testing.T) {
+	handler := Handler{
+		Log:                 hclog.Default().Named("handler"),
+		ImageConsulK8S:      "hashicorp/consul-k8s:9.9.9",
+		LifecycleSidecarUID: 5995,
+		LifecycleSidecarGID: 5996,
+	}
+	container, err := handler.lifecycleSidecar(&corev1.Pod{}, "default")
+	require.NoError(t, err)
+
+	sc := container.SecurityContext
+	require.NotNil(t, sc)
+	require.Equal(t, int64(5995), *sc.RunAsUser)
+	require.Equal(t, int64(5996), *sc.RunAsGroup)
+	require.True(t, *sc.RunAsNonRoot)
+	require.True(t, *sc.ReadOnlyRootFilesystem)
+	require.False(t, *sc.AllowPrivilegeEscalation)
+	require.Equal(t, []corev1.Capability{"ALL"}, sc.Capabilities.Drop)
+	require.Empty(t, sc.Capabilities.Add)
+}
+
+// Test that metrics merging is configured from the pod's annotations.
+func TestLifecycleSidecar_MetricsMerging(t *testing.T) {
+	cases := map[string]struct {
+		Annotations map[string]string
+		ExpArgs     []string
+		ExpErr      string
+	}{
+		"not enabled": {
+			Annotations: map[string]string{},
+		},
+		"disabled": {
+			Annotations: map[string]string{
+				annotationEnableMetricsMerging: "false",
+			},
+		},
+		"invalid": {
+			Annotations: map[string]string{
+				annotationEnableMetricsMerging: "yes please",
+			},
+			ExpErr: "invalid consul.hashicorp.com/enable-metrics-merging annotation",
+		},
+		"no service port": {
+			Annotations: map[string]string{
+				annotationEnableMetricsMerging: "true",
+			},
+			ExpArgs: []string{
+				"-merged-metrics-listen=:20100",
+				"-envoy-metrics-url=http://127.0.0.1:19000/stats/prometheus",
+			},
+		},
+		"service port": {
+			Annotations: map[string]string{
+				annotationEnableMetricsMerging: "true",
+				annotationPort:                 "http",
+			},
+			ExpArgs: []string{
+				"-merged-metrics-listen=:20100",
+				"-envoy-metrics-url=http://127.0.0.1:19000/stats/prometheus",
+				"-service-metrics-url=http://127.0.0.1:8080/metrics",
+			},
+		},
+		"metrics port, path and filters": {
+			Annotations: map[string]string{
+				annotationEnableMetricsMerging: "true",
+				annotationPort:                 "http",
+				annotationServiceMetricsPort:   "9102",
+				annotationServiceMetricsPath:   "/stats",
+				annotationEnvoyMetricsAllow:    "^envoy_cluster_",
+				annotationEnvoyMetricsDrop:     "_bucket$",
+			},
+			ExpArgs: []string{
+				"-merged-metrics-listen=:20100",
+				"-envoy-metrics-url=http://127.0.0.1:19000/stats/prometheus",
+				"-service-metrics-url=http://127.0.0.1:9102/stats",
+				"-envoy-metrics-allow=^envoy_cluster_",
+				"-envoy-metrics-drop=_bucket$",
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			handler := Handler{
+				Log:            hclog.Default().Named("handler"),
+				ImageConsulK8S: "hashicorp/consul-k8s:9.9.9",
+			}
+			container, err := handler.lifecycleSidecar(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: c.Annotations,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "web",
+							Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+						},
+					},
+				},
+			}, "default")
+			if c.ExpErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.ExpErr)
+				return
+			}
+			require.NoError(t, err)
+			if len(c.ExpArgs) == 0 {
+				for _, arg := range container.Command {
+					require.NotContains(t, arg, "metrics")
+				}
+				require.Empty(t, container.Ports)
+				return
+			}
+			require.Equal(t, c.ExpArgs, container.Command[len(container.Command)-len(c.ExpArgs):])
+			require.Equal(t, []corev1.ContainerPort{{Name: "merged-metrics", ContainerPort: 20100}}, container.Ports)
+		})
+	}
+}
+
+// Test that the lifecycle-sidecar command accepts every flag the injector
+// passes to it, so that the two can't drift apart.
+func TestLifecycleSidecar_CommandFlags(t *testing.T) {
+	handler := Handler{
+		Log:                        hclog.Default().Named("handler"),
+		AuthMethod:                 "auth-method",
+		ImageConsulK8S:             "hashicorp/consul-k8s:9.9.9",
+		EnableNamespaces:           true,
+		ConsulDestinationNamespace: "dest",
+	}
+	container, err := handler.lifecycleSidecar(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationSyncPeriod:           "30s",
+				annotationDrainTimeout:         "10s",
+				annotationEnableMetricsMerging: "true",
+				annotationServiceMetricsPort:   "8080",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							Name:      "default-token-podid",
+							MountPath: "/var/run/secrets/kubernetes.io/serviceaccount",
+						},
+					},
+				},
+			},
+		},
+	}, "default")
+	require.NoError(t, err)
+	require.Equal(t, []string{"consul-k8s", "lifecycle-sidecar"}, container.Command[:2])
+
+	// The service config file doesn't exist here, so the command fails
+	// validating the flags after they have all been parsed.
+	ui := cli.NewMockUi()
+	cmd := lifecyclesidecar.Command{UI: ui}
+	code := cmd.Run(container.Command[2:])
+	require.Equal(t, 1, code)
+	require.Contains(t, ui.ErrorWriter.String(), `-service-config file "/consul/connect-inject/service.hcl" not found`)
+}
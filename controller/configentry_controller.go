@@ -0,0 +1,662 @@
+// Package controller contains the controllers that reconcile the custom
+// resources of the consul.hashicorp.com API group into Consul config
+// entries.
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/helper/audit"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// FinalizerName is the finalizer of the resources whose config entry is
+// deleted from Consul when they're deleted.
+const FinalizerName = "finalizers.consul.hashicorp.com"
+
+const (
+	// Reasons of the Synced condition.
+	reasonSynced            = "Synced"
+	reasonInvalidConfig     = "InvalidConfig"
+	reasonConsulAgentError  = "ConsulAgentError"
+	reasonExternallyManaged = "ExternallyManaged"
+
+	// Reasons of the LinkedServicesResolved condition.
+	reasonResolved           = "Resolved"
+	reasonUnresolvedServices = "UnresolvedServices"
+)
+
+// ConfigEntryController implements controller.Resource to reconcile
+// custom resources into Consul config entries. One controller reconciles
+// the resources of one kind, which New creates.
+//
+// Unless OrphanConfigEntries is set, the controller adds its finalizer to
+// the resources so that deleting a resource deletes its config entry, and
+// the resource is only removed from Kubernetes once Consul has deleted the
+// entry.
+type ConfigEntryController struct {
+	Log          hclog.Logger
+	Client       dynamic.Interface
+	ConsulClient *api.Client
+
+	// ConsulConfig is the config of the Consul API client, used for the
+	// config entries of other admin partitions than the controller's,
+	// which the client doesn't support. It must have been passed to
+	// api.NewClient, which completes it.
+	ConsulConfig *api.Config
+
+	// Resource is the group version resource of the custom resources.
+	Resource schema.GroupVersionResource
+
+	// New returns a new empty custom resource of the kind.
+	New func() v1alpha1.ConfigEntryResource
+
+	// Namespace is the Kubernetes namespace to watch. If it's empty,
+	// all namespaces are watched.
+	Namespace string
+
+	// EnableConsulNamespaces indicates that a user is running Consul
+	// Enterprise with version 1.7+ which is namespace aware. It enables
+	// Consul namespaces, with config entries being written to either
+	// ConsulDestinationNamespace or mirrored Kubernetes namespaces.
+	EnableConsulNamespaces bool
+
+	// ConsulDestinationNamespace is the name of the Consul namespace to
+	// write all config entries to. If EnableNSMirroring is set, this is
+	// ignored.
+	ConsulDestinationNamespace string
+
+	// EnableNSMirroring causes Consul namespaces to be created to match
+	// the Kubernetes namespace of the resources.
+	EnableNSMirroring bool
+
+	// NSMirroringPrefix is an optional prefix that can be added to the
+	// Consul namespaces created while mirroring.
+	NSMirroringPrefix string
+
+	// CrossNSACLPolicy is the name of the ACL policy to attach to any
+	// created Consul namespaces to allow cross namespace service
+	// discovery. Only necessary if ACLs are enabled.
+	CrossNSACLPolicy string
+
+	// ConsulPartition is the admin partition of the Consul agent, which
+	// config entries are written to unless their resource targets another
+	// partition. It's empty for the default partition.
+	ConsulPartition string
+
+	// NamespaceTargets allows the resources of Kubernetes namespaces to
+	// target other Consul namespaces than their default with
+	// spec.namespace.
+	NamespaceTargets []TargetRule
+
+	// PartitionTargets allows the resources of Kubernetes namespaces to
+	// target other admin partitions than the controller's with
+	// spec.partition. Cluster-scoped resources can target any partition
+	// since managing them requires cluster-wide permissions.
+	PartitionTargets []TargetRule
+
+	// OrphanConfigEntries leaves the config entries in Consul when their
+	// resources are deleted. The resources that already have the finalizer
+	// are still deleted from Consul.
+	OrphanConfigEntries bool
+
+	// EventRecorder records the errors of syncing the resources as
+	// Kubernetes events on the resources. Events aren't recorded if it's
+	// nil.
+	EventRecorder record.EventRecorder
+
+	// ResyncPeriod is how often all the resources are synced again, e.g.
+	// to revert changes made to the config entries outside of Kubernetes
+	// and to update the status of linked services. If 0, resources are
+	// only synced when they change.
+	ResyncPeriod time.Duration
+}
+
+// Informer implements the controller.Resource interface.
+func (c *ConfigEntryController) Informer() cache.SharedIndexInformer {
+	return newInformer(c.Client, c.Resource, c.Namespace, c.ResyncPeriod)
+}
+
+// Upsert implements the controller.Resource interface. It writes the
+// config entry to Consul unless it's already up to date and reports the
+// result in the resource's Synced condition.
+func (c *ConfigEntryController) Upsert(key string, raw interface{}) error {
+	// We expect an unstructured custom resource. If it isn't,
+	// just ignore it.
+	obj, ok := raw.(*unstructured.Unstructured)
+	if !ok {
+		c.Log.Warn("upsert got invalid type", "key", key, "type", fmt.Sprintf("%T", raw))
+		return nil
+	}
+	resource := c.New()
+	if err := decode(obj, resource); err != nil {
+		c.Log.Error("error decoding resource", "key", key, "err", err)
+		return nil
+	}
+	if defaulter, ok := resource.(v1alpha1.Defaulter); ok {
+		defaulter.Default()
+	}
+
+	// The writes to Consul are audited as caused by the resource.
+	ctx := audit.WithCause(context.Background(), audit.Cause{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()})
+	if obj.GetDeletionTimestamp() != nil {
+		return c.finalize(ctx, obj, resource)
+	}
+	if !c.OrphanConfigEntries && !hasFinalizer(obj) {
+		var err error
+		if obj, err = addFinalizer(c.Client, c.Resource, obj); err != nil {
+			return err
+		}
+	}
+
+	// Invalid resources are not retried since they only become valid
+	// once they're updated.
+	if err := resource.Validate(); err != nil {
+		c.Log.Warn("invalid resource", "key", key, "err", err)
+		c.event(obj, corev1.EventTypeWarning, reasonInvalidConfig, err.Error())
+		return c.updateSynced(obj, resource, corev1.ConditionFalse, reasonInvalidConfig, err.Error())
+	}
+	target, err := c.target(resource)
+	if err != nil {
+		c.Log.Warn("invalid resource", "key", key, "err", err)
+		c.event(obj, corev1.EventTypeWarning, reasonInvalidConfig, err.Error())
+		return c.updateSynced(obj, resource, corev1.ConditionFalse, reasonInvalidConfig, err.Error())
+	}
+
+	written, err := c.sync(ctx, resource, target)
+	if conflict, ok := err.(*externallyManagedError); ok {
+		// The conflict is only resolved by updating the resource, or by
+		// deleting the entry from Consul, which the resync notices.
+		c.Log.Warn("config entry managed outside of Kubernetes", "key", key, "err", err)
+		c.event(obj, corev1.EventTypeWarning, reasonExternallyManaged, conflict.Error())
+		return c.updateSynced(obj, resource, corev1.ConditionFalse, reasonExternallyManaged, conflict.Error())
+	}
+	if err != nil {
+		c.event(obj, corev1.EventTypeWarning, reasonConsulAgentError, err.Error())
+		if statusErr := c.updateSynced(obj, resource, corev1.ConditionFalse, reasonConsulAgentError, err.Error()); statusErr != nil {
+			c.Log.Error("error updating status", "key", key, "err", statusErr)
+		}
+		return err
+	}
+
+	// The sync time is also set for resources whose config entry was
+	// already up to date when they were created.
+	status := resource.ResourceStatus()
+	changed := false
+	if written || status.LastSyncedTime == nil {
+		now := metav1.Now()
+		status.LastSyncedTime = &now
+		changed = true
+	}
+	if status.ConsulNamespace != target.Namespace || status.ConsulPartition != target.Partition {
+		status.ConsulNamespace, status.ConsulPartition = target.Namespace, target.Partition
+		changed = true
+	}
+	if linker, ok := resource.(v1alpha1.ServiceLinkingResource); ok {
+		resolvedChanged, err := c.resolveLinkedServices(linker, target)
+		if err != nil {
+			return err
+		}
+		changed = resolvedChanged || changed
+	}
+	changed = status.SetCondition(v1alpha1.ConditionSynced, corev1.ConditionTrue, reasonSynced, "") || changed
+	return c.updateStatus(obj, resource, changed)
+}
+
+// Delete implements the controller.Resource interface. Config entries
+// are deleted by finalize before their resource is removed.
+func (c *ConfigEntryController) Delete(key string) error {
+	c.Log.Debug("resource deleted", "key", key)
+	return nil
+}
+
+// finalize deletes the config entry of the resource being deleted and
+// then removes the finalizer of the resource. Entries are only deleted if
+// the controller wrote them, from the namespace and partition they were
+// written to.
+func (c *ConfigEntryController) finalize(ctx context.Context, obj *unstructured.Unstructured, resource v1alpha1.ConfigEntryResource) error {
+	if !hasFinalizer(obj) {
+		return nil
+	}
+	status := resource.ResourceStatus()
+	if !c.OrphanConfigEntries && status.LastSyncedTime != nil {
+		kind, name := resource.ConsulKind(), resource.ConsulName()
+		target := v1alpha1.ConsulTarget{Namespace: status.ConsulNamespace, Partition: status.ConsulPartition}
+		if err := c.deleteConfigEntry(ctx, kind, name, target); err != nil {
+			err = fmt.Errorf("deleting %s config entry %q: %s", kind, name, err)
+			c.event(obj, corev1.EventTypeWarning, reasonConsulAgentError, err.Error())
+			if statusErr := c.updateSynced(obj, resource, corev1.ConditionFalse, reasonConsulAgentError, err.Error()); statusErr != nil {
+				c.Log.Error("error updating status", "name", obj.GetName(), "err", statusErr)
+			}
+			return err
+		}
+		c.Log.Info("config entry deleted", "kind", kind, "name", name, "namespace", target.Namespace, "partition", target.Partition)
+	}
+
+	return removeFinalizer(c.Client, c.Resource, obj)
+}
+
+// sync writes the config entry of the resource to the target namespace and
+// partition unless it's already up to date. It returns true if the entry
+// was written.
+//
+// Entries that exist in Consul before the resource is first synced were
+// created outside of Kubernetes. They're only overwritten if the resource
+// has the migrate-entry annotation, otherwise an externallyManagedError is
+// returned.
+func (c *ConfigEntryController) sync(ctx context.Context, resource v1alpha1.ConfigEntryResource, target v1alpha1.ConsulTarget) (bool, error) {
+	if target.Namespace != "" {
+		if err := c.checkAndCreateNamespace(ctx, target); err != nil {
+			return false, fmt.Errorf("checking or creating namespace %q: %s", target.Namespace, err)
+		}
+	}
+
+	kind, name := resource.ConsulKind(), resource.ConsulName()
+	var newEntry func() api.ConfigEntry
+	if raw, ok := resource.(v1alpha1.RawConfigEntryResource); ok {
+		newEntry = raw.NewConsulEntry
+	}
+	entry, err := c.readConfigEntry(kind, name, newEntry, target)
+	if err != nil {
+		return false, fmt.Errorf("reading %s config entry %q: %s", kind, name, err)
+	}
+	if entry != nil && resource.MatchesConsul(entry) {
+		return false, nil
+	}
+	if entry != nil && !resource.ResourceStatus().HasSynced() &&
+		resource.GetAnnotations()[v1alpha1.MigrateEntryKey] != v1alpha1.MigrateEntryTrue {
+		return false, &externallyManagedError{kind: kind, name: name}
+	}
+
+	if err := c.writeConfigEntry(ctx, resource.ToConsul(target.Namespace), target); err != nil {
+		return false, fmt.Errorf("writing %s config entry %q: %s", kind, name, err)
+	}
+	c.Log.Info("config entry written", "kind", kind, "name", name, "namespace", target.Namespace, "partition", target.Partition)
+	return true, nil
+}
+
+// readConfigEntry reads a config entry from Consul. It returns nil if the
+// entry doesn't exist. Entries are decoded into the entry returned by
+// newEntry if it's not nil, for the kinds the Consul API client doesn't
+// support. The client reads them by listing the entries of their kind
+// with a raw query, since raw queries don't report 404s.
+func (c *ConfigEntryController) readConfigEntry(kind, name string, newEntry func() api.ConfigEntry, target v1alpha1.ConsulTarget) (api.ConfigEntry, error) {
+	if target.Partition != "" {
+		var data json.RawMessage
+		err := c.partitionRequest(context.Background(), http.MethodGet, "/v1/config/"+url.PathEscape(kind)+"/"+url.PathEscape(name), target, nil, &data)
+		if isNotFound(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return decodeConfigEntry(data, newEntry)
+	}
+
+	opts := &api.QueryOptions{Namespace: target.Namespace}
+	if newEntry == nil {
+		entry, _, err := c.ConsulClient.ConfigEntries().Get(kind, name, opts)
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return entry, err
+	}
+
+	var entries []json.RawMessage
+	if _, err := c.ConsulClient.Raw().Query("/v1/config/"+url.PathEscape(kind), &entries, opts); err != nil {
+		return nil, err
+	}
+	for _, data := range entries {
+		entry, err := decodeConfigEntry(data, newEntry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.GetName() == name {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// writeConfigEntry writes the config entry to the target namespace and
+// partition.
+func (c *ConfigEntryController) writeConfigEntry(ctx context.Context, entry api.ConfigEntry, target v1alpha1.ConsulTarget) error {
+	if target.Partition == "" {
+		_, _, err := c.ConsulClient.ConfigEntries().Set(entry, (&api.WriteOptions{Namespace: target.Namespace}).WithContext(ctx))
+		return err
+	}
+
+	// The client's config entries have no partition field, so it's added
+	// to the encoded entry.
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+	body["Partition"] = target.Partition
+	return c.partitionRequest(ctx, http.MethodPut, "/v1/config", target, body, nil)
+}
+
+// deleteConfigEntry deletes the config entry from the target namespace and
+// partition.
+func (c *ConfigEntryController) deleteConfigEntry(ctx context.Context, kind, name string, target v1alpha1.ConsulTarget) error {
+	if target.Partition == "" {
+		_, err := c.ConsulClient.ConfigEntries().Delete(kind, name, (&api.WriteOptions{Namespace: target.Namespace}).WithContext(ctx))
+		return err
+	}
+	return c.partitionRequest(ctx, http.MethodDelete, "/v1/config/"+url.PathEscape(kind)+"/"+url.PathEscape(name), target, nil, nil)
+}
+
+// partitionRequest sends a request to Consul for the target namespace of
+// another partition than the controller's.
+func (c *ConfigEntryController) partitionRequest(ctx context.Context, method, path string, target v1alpha1.ConsulTarget, in, out interface{}) error {
+	params := url.Values{"partition": {target.Partition}}
+	if target.Namespace != "" {
+		params.Set("ns", target.Namespace)
+	}
+	return consulRequest(ctx, c.ConsulConfig, method, path, params, in, out)
+}
+
+// resolveLinkedServices sets the LinkedServicesResolved condition of the
+// resource based on whether its linked services are registered in Consul.
+// It returns true if the condition changed.
+func (c *ConfigEntryController) resolveLinkedServices(resource v1alpha1.ServiceLinkingResource, target v1alpha1.ConsulTarget) (bool, error) {
+	var unresolved []string
+	for _, ref := range resource.LinkedServices() {
+		namespace := ref.Namespace
+		if namespace == "" || !c.EnableConsulNamespaces {
+			namespace = target.Namespace
+		}
+		var services []*api.CatalogService
+		var err error
+		if target.Partition == "" {
+			services, _, err = c.ConsulClient.Catalog().Service(ref.Name, "", &api.QueryOptions{Namespace: namespace})
+		} else {
+			err = c.partitionRequest(context.Background(), http.MethodGet, "/v1/catalog/service/"+url.PathEscape(ref.Name),
+				v1alpha1.ConsulTarget{Namespace: namespace, Partition: target.Partition}, nil, &services)
+		}
+		if err != nil {
+			return false, fmt.Errorf("reading service %q: %s", ref.Name, err)
+		}
+		if len(services) == 0 {
+			name := ref.Name
+			if ref.Namespace != "" {
+				name = ref.Namespace + "/" + ref.Name
+			}
+			unresolved = append(unresolved, name)
+		}
+	}
+
+	if len(unresolved) > 0 {
+		return resource.ResourceStatus().SetCondition(v1alpha1.ConditionLinkedServicesResolved, corev1.ConditionFalse,
+			reasonUnresolvedServices, fmt.Sprintf("services aren't registered in Consul: %s", strings.Join(unresolved, ", "))), nil
+	}
+	return resource.ResourceStatus().SetCondition(v1alpha1.ConditionLinkedServicesResolved, corev1.ConditionTrue,
+		reasonResolved, ""), nil
+}
+
+// updateSynced sets the Synced condition of the resource and updates its
+// status unless the condition didn't change.
+func (c *ConfigEntryController) updateSynced(obj *unstructured.Unstructured, resource v1alpha1.ConfigEntryResource,
+	status corev1.ConditionStatus, reason, message string) error {
+	return c.updateStatus(obj, resource, resource.ResourceStatus().SetCondition(v1alpha1.ConditionSynced, status, reason, message))
+}
+
+// updateStatus updates the status of the resource if it changed.
+func (c *ConfigEntryController) updateStatus(obj *unstructured.Unstructured, resource v1alpha1.ConfigEntryResource, changed bool) error {
+	if !changed {
+		return nil
+	}
+	return writeStatus(c.Client, c.Resource, obj, resource.ResourceStatus())
+}
+
+// event records an event on the resource if the controller has an event
+// recorder.
+func (c *ConfigEntryController) event(obj *unstructured.Unstructured, eventType, reason, message string) {
+	if c.EventRecorder != nil {
+		c.EventRecorder.Event(obj, eventType, reason, message)
+	}
+}
+
+// consulNamespace returns the namespace that a config entry should be
+// written to based on the namespace options. It returns an
+// empty string if namespaces aren't enabled or if the resource is
+// cluster-scoped, since those config entries are global.
+func (c *ConfigEntryController) consulNamespace(ns string) string {
+	if !c.EnableConsulNamespaces || ns == "" {
+		return ""
+	}
+
+	// Mirroring takes precedence
+	if c.EnableNSMirroring {
+		return fmt.Sprintf("%s%s", c.NSMirroringPrefix, ns)
+	}
+	return c.ConsulDestinationNamespace
+}
+
+// target returns the Consul namespace and partition of the resource's
+// config entry: the targets of its spec, or the controller's defaults. The
+// partition is empty if it's the controller's. It returns an error if the
+// resource targets a namespace or partition that the resources of its
+// Kubernetes namespace aren't allowed to.
+func (c *ConfigEntryController) target(resource v1alpha1.ConfigEntryResource) (v1alpha1.ConsulTarget, error) {
+	spec, kubeNS := resource.ConsulTarget(), resource.GetNamespace()
+	target := v1alpha1.ConsulTarget{Namespace: c.consulNamespace(kubeNS)}
+	if spec.Namespace != "" && spec.Namespace != target.Namespace {
+		if !c.EnableConsulNamespaces {
+			return target, fmt.Errorf("spec.namespace can't be set since Consul namespaces aren't enabled")
+		}
+		if !targetAllowed(c.NamespaceTargets, kubeNS, spec.Namespace) {
+			return target, fmt.Errorf("spec.namespace: resources in namespace %q aren't allowed to target Consul namespace %q",
+				kubeNS, spec.Namespace)
+		}
+		target.Namespace = spec.Namespace
+	}
+	if spec.Partition != "" && !c.isControllerPartition(spec.Partition) {
+		if kubeNS != "" && !targetAllowed(c.PartitionTargets, kubeNS, spec.Partition) {
+			return target, fmt.Errorf("spec.partition: resources in namespace %q aren't allowed to target Consul partition %q",
+				kubeNS, spec.Partition)
+		}
+		target.Partition = spec.Partition
+	}
+	return target, nil
+}
+
+// isControllerPartition returns true if the partition is the partition of
+// the controller's Consul agent.
+func (c *ConfigEntryController) isControllerPartition(partition string) bool {
+	return partition == c.ConsulPartition || (c.ConsulPartition == "" && partition == v1alpha1.DefaultPartition)
+}
+
+// checkAndCreateNamespace creates the target namespace in the target
+// partition unless it exists.
+func (c *ConfigEntryController) checkAndCreateNamespace(ctx context.Context, target v1alpha1.ConsulTarget) error {
+	ns := target.Namespace
+	// Check if the Consul namespace exists
+	var namespaceInfo *api.Namespace
+	var err error
+	if target.Partition == "" {
+		namespaceInfo, _, err = c.ConsulClient.Namespaces().Read(ns, nil)
+	} else {
+		namespaceInfo = &api.Namespace{}
+		err = c.partitionRequest(ctx, http.MethodGet, "/v1/namespace/"+url.PathEscape(ns), v1alpha1.ConsulTarget{Partition: target.Partition}, nil, namespaceInfo)
+		if isNotFound(err) {
+			namespaceInfo, err = nil, nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// If not, create it
+	if namespaceInfo == nil {
+		var aclConfig api.NamespaceACLConfig
+		if c.CrossNSACLPolicy != "" {
+			// Create the ACLs config for the cross-Consul-namespace
+			// default policy that needs to be attached
+			aclConfig = api.NamespaceACLConfig{
+				PolicyDefaults: []api.ACLLink{
+					{Name: c.CrossNSACLPolicy},
+				},
+			}
+		}
+
+		consulNamespace := api.Namespace{
+			Name:        ns,
+			Description: "Auto-generated by the config entry controller",
+			ACLs:        &aclConfig,
+			Meta:        map[string]string{"external-source": "kubernetes"},
+		}
+
+		if target.Partition == "" {
+			_, _, err = c.ConsulClient.Namespaces().Create(&consulNamespace, (&api.WriteOptions{}).WithContext(ctx))
+		} else {
+			body := struct {
+				*api.Namespace
+				Partition string
+			}{&consulNamespace, target.Partition}
+			err = c.partitionRequest(ctx, http.MethodPut, "/v1/namespace", v1alpha1.ConsulTarget{Partition: target.Partition}, body, nil)
+		}
+		if err != nil {
+			return err
+		}
+		c.Log.Info("creating consul namespace", "name", consulNamespace.Name, "partition", target.Partition)
+	}
+
+	return nil
+}
+
+// externallyManagedError is the error of syncing a resource whose config
+// entry was created outside of Kubernetes.
+type externallyManagedError struct {
+	kind, name string
+}
+
+func (e *externallyManagedError) Error() string {
+	return fmt.Sprintf("%s config entry %q already exists in Consul and was not created from Kubernetes, "+
+		"set the %s annotation to %q to migrate it", e.kind, e.name, v1alpha1.MigrateEntryKey, v1alpha1.MigrateEntryTrue)
+}
+
+// hasFinalizer returns true if the resource has the controller's
+// finalizer.
+func hasFinalizer(obj *unstructured.Unstructured) bool {
+	for _, finalizer := range obj.GetFinalizers() {
+		if finalizer == FinalizerName {
+			return true
+		}
+	}
+	return false
+}
+
+// addFinalizer adds the controller's finalizer to the resource and returns
+// the updated resource.
+func addFinalizer(client dynamic.Interface, resource schema.GroupVersionResource, obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	obj = obj.DeepCopy()
+	obj.SetFinalizers(append(obj.GetFinalizers(), FinalizerName))
+	updated, err := client.Resource(resource).Namespace(obj.GetNamespace()).Update(obj, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("adding finalizer: %s", err)
+	}
+	return updated, nil
+}
+
+// removeFinalizer removes the controller's finalizer from the resource.
+func removeFinalizer(client dynamic.Interface, resource schema.GroupVersionResource, obj *unstructured.Unstructured) error {
+	obj = obj.DeepCopy()
+	var finalizers []string
+	for _, finalizer := range obj.GetFinalizers() {
+		if finalizer != FinalizerName {
+			finalizers = append(finalizers, finalizer)
+		}
+	}
+	obj.SetFinalizers(finalizers)
+	if _, err := client.Resource(resource).Namespace(obj.GetNamespace()).Update(obj, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("removing finalizer: %s", err)
+	}
+	return nil
+}
+
+// isNotFound returns true if the error is Consul's response to reading
+// a config entry that doesn't exist.
+func isNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Unexpected response code: 404")
+}
+
+// decodeConfigEntry decodes a config entry read from Consul into the entry
+// returned by newEntry, or into the Consul API client's entry of its kind
+// if newEntry is nil.
+func decodeConfigEntry(data []byte, newEntry func() api.ConfigEntry) (api.ConfigEntry, error) {
+	if newEntry == nil {
+		return api.DecodeConfigEntryFromJSON(data)
+	}
+	entry := newEntry()
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// newInformer returns an informer of the custom resources in the
+// namespace, or in all namespaces if it's empty.
+func newInformer(client dynamic.Interface, resource schema.GroupVersionResource, namespace string, resyncPeriod time.Duration) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return client.Resource(resource).Namespace(namespace).List(options)
+			},
+
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return client.Resource(resource).Namespace(namespace).Watch(options)
+			},
+		},
+		&unstructured.Unstructured{},
+		resyncPeriod,
+		cache.Indexers{},
+	)
+}
+
+// decode decodes the unstructured custom resource into resource. The
+// resource is decoded from JSON rather than converted from the
+// unstructured object since the converter rejects integers for float
+// fields, e.g. a splitter's "weight: 50".
+func decode(obj *unstructured.Unstructured, resource interface{}) error {
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, resource)
+}
+
+// writeStatus replaces the status of the custom resource.
+func writeStatus(client dynamic.Interface, resource schema.GroupVersionResource, obj *unstructured.Unstructured, status interface{}) error {
+	statusObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return err
+	}
+	obj = obj.DeepCopy()
+	if err := unstructured.SetNestedField(obj.Object, statusObj, "status"); err != nil {
+		return err
+	}
+	_, err = client.Resource(resource).Namespace(obj.GetNamespace()).UpdateStatus(obj, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,774 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/helper/audit"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestConfigEntryController_Upsert(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		resource  *v1alpha1.ServiceDefaults
+		expEntry  map[string]interface{}
+		expStatus corev1.ConditionStatus
+		expReason string
+	}{
+		"valid resource": {
+			resource: serviceDefaults("foo", "default", "http"),
+			expEntry: map[string]interface{}{
+				"Kind":     "service-defaults",
+				"Name":     "foo",
+				"Protocol": "http",
+			},
+			expStatus: corev1.ConditionTrue,
+			expReason: reasonSynced,
+		},
+		"invalid resource": {
+			resource:  serviceDefaults("foo", "default", "udp"),
+			expStatus: corev1.ConditionFalse,
+			expReason: reasonInvalidConfig,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			obj := toUnstructured(t, c.resource)
+			client := newFakeDynamicClient(obj)
+			controller := serviceDefaultsController(client)
+			controller.ConsulClient = consulClient
+
+			require.NoError(t, controller.Upsert("default/foo", obj))
+			entry := consul.entry("", "service-defaults", "foo")
+			if c.expEntry == nil {
+				require.Nil(t, entry)
+			} else {
+				for k, v := range c.expEntry {
+					require.Equal(t, v, entry[k])
+				}
+			}
+			condition := syncedCondition(t, client, "default", "foo")
+			require.Equal(t, c.expStatus, condition.Status)
+			require.Equal(t, c.expReason, condition.Reason)
+		})
+	}
+}
+
+// Test that the last sync time is only updated when the config entry is
+// written.
+func TestConfigEntryController_LastSyncedTime(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	obj := toUnstructured(t, serviceDefaults("foo", "default", "http"))
+	client := newFakeDynamicClient(obj)
+	controller := serviceDefaultsController(client)
+	controller.ConsulClient = consulClient
+
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	status := resourceStatus(t, client, v1alpha1.ServiceDefaultsResource, "default", "foo")
+	require.NotNil(t, status.LastSyncedTime)
+	require.Empty(t, status.ConsulNamespace)
+
+	// Going back in time shows whether the sync time was updated.
+	lastSynced := metav1.NewTime(status.LastSyncedTime.Add(-time.Hour))
+	obj, err := client.Resource(controller.Resource).Namespace("default").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, unstructured.SetNestedField(obj.Object, lastSynced.UTC().Format(time.RFC3339), "status", "lastSyncedTime"))
+	obj, err = client.Resource(controller.Resource).Namespace("default").UpdateStatus(obj, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.Equal(t, lastSynced.Unix(), resourceStatus(t, client, v1alpha1.ServiceDefaultsResource, "default", "foo").LastSyncedTime.Unix())
+
+	// Changes outside of Kubernetes are reverted on the next sync.
+	consul.lock.Lock()
+	consul.entries["/service-defaults/foo"]["Protocol"] = "tcp"
+	consul.lock.Unlock()
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.True(t, resourceStatus(t, client, v1alpha1.ServiceDefaultsResource, "default", "foo").LastSyncedTime.After(lastSynced.Time))
+}
+
+// Test that sync errors are recorded as events.
+func TestConfigEntryController_Events(t *testing.T) {
+	t.Parallel()
+	_, consulClient, stop := newFakeConsul(t)
+	stop()
+	recorder := record.NewFakeRecorder(10)
+	invalid := toUnstructured(t, serviceDefaults("invalid", "default", "udp"))
+	obj := toUnstructured(t, serviceDefaults("foo", "default", "http"))
+	client := newFakeDynamicClient(invalid, obj)
+	controller := serviceDefaultsController(client)
+	controller.ConsulClient = consulClient
+	controller.EventRecorder = recorder
+
+	require.NoError(t, controller.Upsert("default/invalid", invalid))
+	require.Contains(t, <-recorder.Events, "Warning InvalidConfig spec.protocol")
+	require.Error(t, controller.Upsert("default/foo", obj))
+	require.Contains(t, <-recorder.Events, "Warning ConsulAgentError reading service-defaults config entry \"foo\"")
+}
+
+// Test that the config entry writes are audited as caused by their
+// resource.
+func TestConfigEntryController_AuditCause(t *testing.T) {
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	stopAudit, err := audit.Start(hclog.NewNullLogger(), path, "controller")
+	require.NoError(t, err)
+	defer stopAudit()
+	// Only this test's client audits its writes. It shares the HTTP client
+	// of its config.
+	consul.config.HttpClient.Transport = audit.Transport(consul.config.HttpClient.Transport)
+
+	obj := toUnstructured(t, serviceDefaults("foo", "default", "http"))
+	client := newFakeDynamicClient(obj)
+	controller := serviceDefaultsController(client)
+	controller.ConsulClient = consulClient
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	stopAudit()
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var entry audit.Entry
+	require.NoError(t, json.Unmarshal(data, &entry))
+	require.Equal(t, "PUT", entry.Method)
+	require.Equal(t, "/v1/config", entry.Path)
+	require.Equal(t, &audit.Cause{Kind: "ServiceDefaults", Namespace: "default", Name: "foo"}, entry.Cause)
+}
+
+// Test that deleting a resource deletes its config entry before its
+// finalizer is removed.
+func TestConfigEntryController_Finalizer(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		orphan       bool
+		consulDown   bool
+		expFinalizer bool
+		expEntry     bool
+	}{
+		"deleted": {},
+		"orphaned": {
+			orphan:   true,
+			expEntry: true,
+		},
+		"Consul error": {
+			consulDown:   true,
+			expFinalizer: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			obj := toUnstructured(t, serviceDefaults("foo", "default", "http"))
+			client := newFakeDynamicClient(obj)
+			controller := serviceDefaultsController(client)
+			controller.ConsulClient = consulClient
+
+			// The finalizer is added before the entry is written.
+			require.NoError(t, controller.Upsert("default/foo", obj))
+			obj, err := client.Resource(controller.Resource).Namespace("default").Get("foo", metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, []string{FinalizerName}, obj.GetFinalizers())
+			require.NotNil(t, consul.entry("", "service-defaults", "foo"))
+
+			controller.OrphanConfigEntries = c.orphan
+			if c.consulDown {
+				stop()
+			}
+			now := metav1.Now()
+			obj.SetDeletionTimestamp(&now)
+			err = controller.Upsert("default/foo", obj)
+			if c.consulDown {
+				require.Error(t, err)
+				condition := syncedCondition(t, client, "default", "foo")
+				require.Equal(t, corev1.ConditionFalse, condition.Status)
+				require.Contains(t, condition.Message, `deleting service-defaults config entry "foo"`)
+			} else {
+				require.NoError(t, err)
+			}
+			obj, err = client.Resource(controller.Resource).Namespace("default").Get("foo", metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, c.expFinalizer, hasFinalizer(obj))
+			if !c.consulDown {
+				require.Equal(t, c.expEntry, consul.entry("", "service-defaults", "foo") != nil)
+			}
+		})
+	}
+}
+
+// Test that entries created outside of Kubernetes are only overwritten
+// by resources with the migrate-entry annotation.
+func TestConfigEntryController_MigrateEntry(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		existingProtocol string
+		migrate          bool
+		expProtocol      string
+		expReason        string
+		expWrites        int
+	}{
+		"different entry": {
+			existingProtocol: "tcp",
+			expProtocol:      "tcp",
+			expReason:        reasonExternallyManaged,
+		},
+		"different entry with migrate-entry": {
+			existingProtocol: "tcp",
+			migrate:          true,
+			expProtocol:      "http",
+			expReason:        reasonSynced,
+			expWrites:        1,
+		},
+		"same entry": {
+			existingProtocol: "http",
+			expProtocol:      "http",
+			expReason:        reasonSynced,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			consul.entries["/service-defaults/foo"] = map[string]interface{}{
+				"Kind":     "service-defaults",
+				"Name":     "foo",
+				"Protocol": c.existingProtocol,
+			}
+			resource := serviceDefaults("foo", "default", "http")
+			if c.migrate {
+				resource.Annotations = map[string]string{v1alpha1.MigrateEntryKey: v1alpha1.MigrateEntryTrue}
+			}
+			obj := toUnstructured(t, resource)
+			client := newFakeDynamicClient(obj)
+			controller := serviceDefaultsController(client)
+			controller.ConsulClient = consulClient
+
+			require.NoError(t, controller.Upsert("default/foo", obj))
+			require.Equal(t, c.expProtocol, consul.entry("", "service-defaults", "foo")["Protocol"])
+			require.Equal(t, c.expWrites, consul.writes)
+			require.Equal(t, c.expReason, syncedCondition(t, client, "default", "foo").Reason)
+		})
+	}
+}
+
+// Test that a resource refused because of an external entry takes it
+// over once it's annotated, and keeps managing it afterwards.
+func TestConfigEntryController_MigrateEntryLater(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	consul.entries["/service-defaults/foo"] = map[string]interface{}{
+		"Kind":     "service-defaults",
+		"Name":     "foo",
+		"Protocol": "tcp",
+	}
+	obj := toUnstructured(t, serviceDefaults("foo", "default", "http"))
+	client := newFakeDynamicClient(obj)
+	controller := serviceDefaultsController(client)
+	controller.ConsulClient = consulClient
+
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	condition := syncedCondition(t, client, "default", "foo")
+	require.Equal(t, reasonExternallyManaged, condition.Reason)
+	require.Contains(t, condition.Message, `set the consul.hashicorp.com/migrate-entry annotation to "true" to migrate it`)
+
+	obj, err := client.Resource(controller.Resource).Namespace("default").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	obj.SetAnnotations(map[string]string{v1alpha1.MigrateEntryKey: v1alpha1.MigrateEntryTrue})
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.Equal(t, "http", consul.entry("", "service-defaults", "foo")["Protocol"])
+
+	// Once migrated, changes made in Consul are reverted even without the
+	// annotation.
+	obj, err = client.Resource(controller.Resource).Namespace("default").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	obj.SetAnnotations(nil)
+	consul.lock.Lock()
+	consul.entries["/service-defaults/foo"]["Protocol"] = "tcp"
+	consul.lock.Unlock()
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.Equal(t, "http", consul.entry("", "service-defaults", "foo")["Protocol"])
+}
+
+// Test that no finalizer is added if config entries are orphaned.
+func TestConfigEntryController_Orphan(t *testing.T) {
+	t.Parallel()
+	_, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	obj := toUnstructured(t, serviceDefaults("foo", "default", "http"))
+	client := newFakeDynamicClient(obj)
+	controller := serviceDefaultsController(client)
+	controller.ConsulClient = consulClient
+	controller.OrphanConfigEntries = true
+
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	obj, err := client.Resource(controller.Resource).Namespace("default").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Empty(t, obj.GetFinalizers())
+}
+
+// Test that the config entry isn't written again if it's up to date.
+func TestConfigEntryController_UpsertUpToDate(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	obj := toUnstructured(t, serviceDefaults("foo", "default", "http"))
+	client := newFakeDynamicClient(obj)
+	controller := serviceDefaultsController(client)
+	controller.ConsulClient = consulClient
+
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.Equal(t, 1, consul.writes)
+	obj, err := client.Resource(controller.Resource).Namespace("default").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.Equal(t, 1, consul.writes)
+
+	// Updating the resource writes the config entry again.
+	require.NoError(t, unstructured.SetNestedField(obj.Object, "grpc", "spec", "protocol"))
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.Equal(t, 2, consul.writes)
+	require.Equal(t, "grpc", consul.entry("", "service-defaults", "foo")["Protocol"])
+}
+
+// Test that the Synced condition is false when Consul can't be reached.
+func TestConfigEntryController_UpsertConsulError(t *testing.T) {
+	t.Parallel()
+	_, consulClient, stop := newFakeConsul(t)
+	stop()
+	obj := toUnstructured(t, serviceDefaults("foo", "default", "http"))
+	client := newFakeDynamicClient(obj)
+	controller := serviceDefaultsController(client)
+	controller.ConsulClient = consulClient
+
+	require.Error(t, controller.Upsert("default/foo", obj))
+	condition := syncedCondition(t, client, "default", "foo")
+	require.Equal(t, corev1.ConditionFalse, condition.Status)
+	require.Equal(t, reasonConsulAgentError, condition.Reason)
+	require.Contains(t, condition.Message, "reading service-defaults config entry \"foo\"")
+}
+
+func TestConfigEntryController_ConsulNamespaces(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		mirroring    bool
+		prefix       string
+		destination  string
+		expNamespace string
+	}{
+		"destination namespace": {
+			destination:  "dest",
+			expNamespace: "dest",
+		},
+		"mirroring": {
+			mirroring:    true,
+			expNamespace: "k8s-ns",
+		},
+		"mirroring with prefix": {
+			mirroring:    true,
+			prefix:       "prefix-",
+			expNamespace: "prefix-k8s-ns",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			obj := toUnstructured(t, serviceDefaults("foo", "k8s-ns", "http"))
+			client := newFakeDynamicClient(obj)
+			controller := serviceDefaultsController(client)
+			controller.ConsulClient = consulClient
+			controller.EnableConsulNamespaces = true
+			controller.EnableNSMirroring = c.mirroring
+			controller.NSMirroringPrefix = c.prefix
+			controller.ConsulDestinationNamespace = c.destination
+
+			require.NoError(t, controller.Upsert("k8s-ns/foo", obj))
+			require.True(t, consul.namespaces[c.expNamespace])
+			require.NotNil(t, consul.entry(c.expNamespace, "service-defaults", "foo"))
+			require.Equal(t, c.expNamespace, resourceStatus(t, client, v1alpha1.ServiceDefaultsResource, "k8s-ns", "foo").ConsulNamespace)
+		})
+	}
+}
+
+// Test that cluster-scoped resources are written to the default namespace.
+func TestConfigEntryController_ClusterScoped(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	resource := &v1alpha1.ProxyDefaults{
+		Spec: v1alpha1.ProxyDefaultsSpec{
+			Config: map[string]interface{}{"local_connect_timeout_ms": int64(1000)},
+		},
+	}
+	resource.Name = "global"
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	controller := &ConfigEntryController{
+		Log:                    hclog.NewNullLogger(),
+		Client:                 client,
+		ConsulClient:           consulClient,
+		Resource:               v1alpha1.GroupVersion.WithResource(v1alpha1.ProxyDefaultsResource),
+		New:                    func() v1alpha1.ConfigEntryResource { return &v1alpha1.ProxyDefaults{} },
+		EnableConsulNamespaces: true,
+		EnableNSMirroring:      true,
+	}
+
+	require.NoError(t, controller.Upsert("global", obj))
+	require.Empty(t, consul.namespaces)
+	entry := consul.entry("", "proxy-defaults", "global")
+	require.Equal(t, map[string]interface{}{"local_connect_timeout_ms": float64(1000)}, entry["Config"])
+
+	// The config read back from Consul matches the resource.
+	require.NoError(t, controller.Upsert("global", obj))
+	require.Equal(t, 1, consul.writes)
+}
+
+func TestConfigEntryController_Target(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		resource         v1alpha1.ConfigEntryResource
+		disableNS        bool
+		partition        string
+		namespaceTargets []TargetRule
+		partitionTargets []TargetRule
+		expTarget        v1alpha1.ConsulTarget
+		expErr           string
+	}{
+		"defaults": {
+			resource:  serviceDefaults("foo", "k8s-ns", "http"),
+			expTarget: v1alpha1.ConsulTarget{Namespace: "k8s-ns"},
+		},
+		"namespace allowed": {
+			resource:         targeted(serviceDefaults("foo", "k8s-ns", "http"), "billing", ""),
+			namespaceTargets: []TargetRule{{KubeNamespace: "k8s-ns", Target: "billing"}},
+			expTarget:        v1alpha1.ConsulTarget{Namespace: "billing"},
+		},
+		"namespace allowed by wildcard": {
+			resource:         targeted(serviceDefaults("foo", "k8s-ns", "http"), "billing", ""),
+			namespaceTargets: []TargetRule{{KubeNamespace: "k8s-ns", Target: "*"}},
+			expTarget:        v1alpha1.ConsulTarget{Namespace: "billing"},
+		},
+		"namespace not allowed": {
+			resource:         targeted(serviceDefaults("foo", "k8s-ns", "http"), "billing", ""),
+			namespaceTargets: []TargetRule{{KubeNamespace: "other", Target: "*"}},
+			expErr:           `spec.namespace: resources in namespace "k8s-ns" aren't allowed to target Consul namespace "billing"`,
+		},
+		"default namespace always allowed": {
+			resource:  targeted(serviceDefaults("foo", "k8s-ns", "http"), "k8s-ns", ""),
+			expTarget: v1alpha1.ConsulTarget{Namespace: "k8s-ns"},
+		},
+		"namespaces disabled": {
+			resource:  targeted(serviceDefaults("foo", "k8s-ns", "http"), "billing", ""),
+			disableNS: true,
+			expErr:    "spec.namespace can't be set since Consul namespaces aren't enabled",
+		},
+		"partition allowed": {
+			resource:         targeted(serviceDefaults("foo", "k8s-ns", "http"), "", "ap1"),
+			partitionTargets: []TargetRule{{KubeNamespace: "*", Target: "ap1"}},
+			expTarget:        v1alpha1.ConsulTarget{Namespace: "k8s-ns", Partition: "ap1"},
+		},
+		"partition not allowed": {
+			resource: targeted(serviceDefaults("foo", "k8s-ns", "http"), "", "ap1"),
+			expErr:   `spec.partition: resources in namespace "k8s-ns" aren't allowed to target Consul partition "ap1"`,
+		},
+		"controller partition": {
+			resource:  targeted(serviceDefaults("foo", "k8s-ns", "http"), "", "ap1"),
+			partition: "ap1",
+			expTarget: v1alpha1.ConsulTarget{Namespace: "k8s-ns"},
+		},
+		"default partition": {
+			resource:  targeted(serviceDefaults("foo", "k8s-ns", "http"), "", "default"),
+			expTarget: v1alpha1.ConsulTarget{Namespace: "k8s-ns"},
+		},
+		"cluster-scoped resource": {
+			resource:  &v1alpha1.ExportedServices{ObjectMeta: metav1.ObjectMeta{Name: "ap1"}},
+			expTarget: v1alpha1.ConsulTarget{Partition: "ap1"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			controller := &ConfigEntryController{
+				EnableConsulNamespaces: !c.disableNS,
+				EnableNSMirroring:      true,
+				ConsulPartition:        c.partition,
+				NamespaceTargets:       c.namespaceTargets,
+				PartitionTargets:       c.partitionTargets,
+			}
+			target, err := controller.target(c.resource)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expTarget, target)
+		})
+	}
+}
+
+// Test that the config entries of other partitions are written, read and
+// deleted with the partition parameter.
+func TestConfigEntryController_Partition(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	obj := toUnstructured(t, targeted(serviceDefaults("foo", "k8s-ns", "http"), "billing", "ap1"))
+	client := newFakeDynamicClient(obj)
+	controller := serviceDefaultsController(client)
+	controller.ConsulClient = consulClient
+	controller.ConsulConfig = consul.config
+	controller.EnableConsulNamespaces = true
+	controller.EnableNSMirroring = true
+	controller.NamespaceTargets = []TargetRule{{KubeNamespace: "k8s-ns", Target: "billing"}}
+	controller.PartitionTargets = []TargetRule{{KubeNamespace: "k8s-ns", Target: "ap1"}}
+
+	require.NoError(t, controller.Upsert("k8s-ns/foo", obj))
+	require.True(t, consul.namespaces["ap1:billing"])
+	entry := consul.entry("ap1:billing", "service-defaults", "foo")
+	require.NotNil(t, entry)
+	require.Equal(t, "ap1", entry["Partition"])
+	require.Equal(t, "http", entry["Protocol"])
+	status := resourceStatus(t, client, v1alpha1.ServiceDefaultsResource, "k8s-ns", "foo")
+	require.Equal(t, "billing", status.ConsulNamespace)
+	require.Equal(t, "ap1", status.ConsulPartition)
+
+	// The entry read back from the partition is up to date.
+	obj, err := client.Resource(controller.Resource).Namespace("k8s-ns").Get("foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("k8s-ns/foo", obj))
+	require.Equal(t, 1, consul.writes)
+
+	now := metav1.Now()
+	obj.SetDeletionTimestamp(&now)
+	require.NoError(t, controller.Upsert("k8s-ns/foo", obj))
+	require.Nil(t, consul.entry("ap1:billing", "service-defaults", "foo"))
+}
+
+// Test that durations in the resources are decoded.
+func TestConfigEntryController_Durations(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": v1alpha1.GroupVersion.String(),
+		"kind":       "ServiceResolver",
+		"metadata": map[string]interface{}{
+			"name":      "foo",
+			"namespace": "default",
+		},
+		"spec": map[string]interface{}{
+			"connectTimeout": "15s",
+		},
+	}}
+	client := newFakeDynamicClient(obj)
+	controller := &ConfigEntryController{
+		Log:          hclog.NewNullLogger(),
+		Client:       client,
+		ConsulClient: consulClient,
+		Resource:     v1alpha1.GroupVersion.WithResource(v1alpha1.ServiceResolverResource),
+		New:          func() v1alpha1.ConfigEntryResource { return &v1alpha1.ServiceResolver{} },
+	}
+
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.Equal(t, "15s", consul.entry("", "service-resolver", "foo")["ConnectTimeout"])
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.Equal(t, 1, consul.writes)
+}
+
+// Test that integers are decoded into float fields.
+func TestConfigEntryController_IntegerWeights(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": v1alpha1.GroupVersion.String(),
+		"kind":       "ServiceSplitter",
+		"metadata": map[string]interface{}{
+			"name":      "foo",
+			"namespace": "default",
+		},
+		"spec": map[string]interface{}{
+			"splits": []interface{}{
+				map[string]interface{}{"weight": int64(50), "serviceSubset": "v1"},
+				map[string]interface{}{"weight": 50.0, "serviceSubset": "v2"},
+			},
+		},
+	}}
+	client := newFakeDynamicClient(obj)
+	controller := &ConfigEntryController{
+		Log:          hclog.NewNullLogger(),
+		Client:       client,
+		ConsulClient: consulClient,
+		Resource:     v1alpha1.GroupVersion.WithResource(v1alpha1.ServiceSplitterResource),
+		New:          func() v1alpha1.ConfigEntryResource { return &v1alpha1.ServiceSplitter{} },
+	}
+
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.NotNil(t, consul.entry("", "service-splitter", "foo"))
+	require.NoError(t, controller.Upsert("default/foo", obj))
+	require.Equal(t, 1, consul.writes)
+}
+
+// Test that config entries of kinds the Consul API client doesn't
+// support are read with raw queries.
+func TestConfigEntryController_RawConfigEntry(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	resource := serviceIntentions("web-intentions", "default", "web")
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	controller := serviceIntentionsController(client)
+	controller.ConsulClient = consulClient
+
+	require.NoError(t, controller.Upsert("default/web-intentions", obj))
+	entry := consul.entry("", "service-intentions", "web")
+	require.NotNil(t, entry)
+	require.Len(t, entry["Sources"], 1)
+	require.NoError(t, controller.Upsert("default/web-intentions", obj))
+	require.Equal(t, 1, consul.writes)
+}
+
+// Test that the linked services of terminating gateways that aren't
+// registered are reported in the status.
+func TestConfigEntryController_LinkedServices(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	resource := &v1alpha1.TerminatingGateway{
+		Spec: v1alpha1.TerminatingGatewaySpec{
+			Services: []v1alpha1.LinkedServiceSpec{{Name: "db"}, {Name: "cache"}, {Name: "*", Namespace: "other"}},
+		},
+	}
+	resource.APIVersion = v1alpha1.GroupVersion.String()
+	resource.Kind = "TerminatingGateway"
+	resource.Name = "terminating-gateway"
+	resource.Namespace = "default"
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	controller := &ConfigEntryController{
+		Log:          hclog.NewNullLogger(),
+		Client:       client,
+		ConsulClient: consulClient,
+		Resource:     v1alpha1.GroupVersion.WithResource(v1alpha1.TerminatingGatewayResource),
+		New:          func() v1alpha1.ConfigEntryResource { return &v1alpha1.TerminatingGateway{} },
+	}
+	consul.registerService("", "db")
+
+	require.NoError(t, controller.Upsert("default/terminating-gateway", obj))
+	require.NotNil(t, consul.entry("", "terminating-gateway", "terminating-gateway"))
+	condition := getCondition(t, client, v1alpha1.TerminatingGatewayResource, "default", "terminating-gateway", v1alpha1.ConditionLinkedServicesResolved)
+	require.Equal(t, corev1.ConditionFalse, condition.Status)
+	require.Equal(t, "UnresolvedServices", condition.Reason)
+	require.Equal(t, "services aren't registered in Consul: cache", condition.Message)
+
+	// Once the service is registered, the condition is updated on the
+	// next sync.
+	consul.registerService("", "cache")
+	obj, err := client.Resource(controller.Resource).Namespace("default").Get("terminating-gateway", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/terminating-gateway", obj))
+	condition = getCondition(t, client, v1alpha1.TerminatingGatewayResource, "default", "terminating-gateway", v1alpha1.ConditionLinkedServicesResolved)
+	require.Equal(t, corev1.ConditionTrue, condition.Status)
+	require.Equal(t, 1, consul.writes)
+}
+
+func serviceDefaultsController(client *fakeDynamicClient) *ConfigEntryController {
+	return &ConfigEntryController{
+		Log:      hclog.NewNullLogger(),
+		Client:   client,
+		Resource: v1alpha1.GroupVersion.WithResource(v1alpha1.ServiceDefaultsResource),
+		New:      func() v1alpha1.ConfigEntryResource { return &v1alpha1.ServiceDefaults{} },
+	}
+}
+
+func serviceDefaults(name, namespace, protocol string) *v1alpha1.ServiceDefaults {
+	resource := &v1alpha1.ServiceDefaults{
+		Spec: v1alpha1.ServiceDefaultsSpec{Protocol: protocol},
+	}
+	resource.APIVersion = v1alpha1.GroupVersion.String()
+	resource.Kind = "ServiceDefaults"
+	resource.Name = name
+	resource.Namespace = namespace
+	return resource
+}
+
+// targeted sets the Consul namespace and partition of the resource's spec.
+func targeted(resource *v1alpha1.ServiceDefaults, namespace, partition string) *v1alpha1.ServiceDefaults {
+	resource.Spec.ConsulTarget = v1alpha1.ConsulTarget{Namespace: namespace, Partition: partition}
+	return resource
+}
+
+func toUnstructured(t *testing.T, resource interface{}) *unstructured.Unstructured {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(resource)
+	require.NoError(t, err)
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func syncedCondition(t *testing.T, client *fakeDynamicClient, namespace, name string) *v1alpha1.Condition {
+	return getCondition(t, client, v1alpha1.ServiceDefaultsResource, namespace, name, v1alpha1.ConditionSynced)
+}
+
+func resourceStatus(t *testing.T, client *fakeDynamicClient, resource, namespace, name string) v1alpha1.Status {
+	obj, err := client.Resource(v1alpha1.GroupVersion.WithResource(resource)).
+		Namespace(namespace).Get(name, metav1.GetOptions{})
+	require.NoError(t, err)
+	var status struct {
+		Status v1alpha1.Status `json:"status"`
+	}
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &status))
+	return status.Status
+}
+
+func getCondition(t *testing.T, client *fakeDynamicClient, resource, namespace, name, conditionType string) *v1alpha1.Condition {
+	status := resourceStatus(t, client, resource, namespace, name)
+	condition := status.GetCondition(conditionType)
+	require.NotNil(t, condition)
+	return condition
+}
+
+func serviceIntentionsController(client *fakeDynamicClient) *ConfigEntryController {
+	return &ConfigEntryController{
+		Log:      hclog.NewNullLogger(),
+		Client:   client,
+		Resource: v1alpha1.GroupVersion.WithResource(v1alpha1.ServiceIntentionsResource),
+		New:      func() v1alpha1.ConfigEntryResource { return &v1alpha1.ServiceIntentions{} },
+	}
+}
+
+func serviceIntentions(name, namespace, destination string) *v1alpha1.ServiceIntentions {
+	resource := &v1alpha1.ServiceIntentions{
+		Spec: v1alpha1.ServiceIntentionsSpec{
+			Destination: v1alpha1.Destination{Name: destination},
+			Sources:     []v1alpha1.SourceIntentionSpec{{Name: "api", Action: "allow"}},
+		},
+	}
+	resource.APIVersion = v1alpha1.GroupVersion.String()
+	resource.Kind = "ServiceIntentions"
+	resource.Name = name
+	resource.Namespace = namespace
+	return resource
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// TargetRule allows the resources of a Kubernetes namespace to target a
+// Consul namespace or partition other than their default. Either side can
+// be "*" to match any namespace or partition.
+type TargetRule struct {
+	KubeNamespace string
+	Target        string
+}
+
+// ParseTargetRule parses a rule of the form
+// "<kubernetes namespace>=<consul namespace or partition>".
+func ParseTargetRule(s string) (TargetRule, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return TargetRule{}, fmt.Errorf("%q must be of the form <kubernetes namespace>=<consul namespace or partition>", s)
+	}
+	return TargetRule{KubeNamespace: parts[0], Target: parts[1]}, nil
+}
+
+// targetAllowed returns true if one of the rules allows the resources of
+// the Kubernetes namespace to target the Consul namespace or partition.
+func targetAllowed(rules []TargetRule, kubeNS, target string) bool {
+	for _, rule := range rules {
+		if (rule.KubeNamespace == "*" || rule.KubeNamespace == kubeNS) && (rule.Target == "*" || rule.Target == target) {
+			return true
+		}
+	}
+	return false
+}
+
+// consulRequest sends a request to Consul's HTTP API with the query
+// parameters and decodes the response into out if it's not nil. It's used
+// for the endpoints and parameters the Consul API client doesn't support,
+// e.g. peerings and admin partitions. The config must have been passed to
+// api.NewClient, which completes it.
+func consulRequest(ctx context.Context, config *api.Config, method, path string, params url.Values, in, out interface{}) error {
+	if config == nil {
+		return fmt.Errorf("no Consul API config to call %s", path)
+	}
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	u := url.URL{Scheme: config.Scheme, Host: config.Address, Path: path, RawQuery: params.Encode()}
+	req, err := http.NewRequest(method, u.String(), &body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if config.Token != "" {
+		req.Header.Set("X-Consul-Token", config.Token)
+	}
+	resp, err := config.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Unexpected response code: %d (%s)", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
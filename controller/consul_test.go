@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTargetRule(t *testing.T) {
+	rule, err := ParseTargetRule("team-a=billing")
+	require.NoError(t, err)
+	require.Equal(t, TargetRule{KubeNamespace: "team-a", Target: "billing"}, rule)
+
+	rule, err = ParseTargetRule("*=*")
+	require.NoError(t, err)
+	require.Equal(t, TargetRule{KubeNamespace: "*", Target: "*"}, rule)
+
+	for _, s := range []string{"", "team-a", "team-a=", "=billing"} {
+		_, err := ParseTargetRule(s)
+		require.EqualError(t, err, `"`+s+`" must be of the form <kubernetes namespace>=<consul namespace or partition>`)
+	}
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/hashicorp/consul-k8s/api/v1beta1"
+	"github.com/hashicorp/go-hclog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ConversionReview is the apiextensions.k8s.io/v1beta1 ConversionReview
+// sent to conversion webhooks. It's defined here since the apiextensions
+// API isn't a dependency.
+type ConversionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Request         *ConversionRequest  `json:"request,omitempty"`
+	Response        *ConversionResponse `json:"response,omitempty"`
+}
+
+// ConversionRequest is the request of a ConversionReview.
+type ConversionRequest struct {
+	UID               types.UID              `json:"uid"`
+	DesiredAPIVersion string                 `json:"desiredAPIVersion"`
+	Objects           []runtime.RawExtension `json:"objects"`
+}
+
+// ConversionResponse is the response of a ConversionReview.
+type ConversionResponse struct {
+	UID              types.UID              `json:"uid"`
+	ConvertedObjects []runtime.RawExtension `json:"convertedObjects"`
+	Result           metav1.Status          `json:"result"`
+}
+
+// ConversionWebhook is the CRD conversion webhook that converts the custom
+// resources of the consul.hashicorp.com group between its versions, so
+// that the objects stored in an older version keep being served once the
+// schema of a kind changes. See v1beta1.Convert.
+type ConversionWebhook struct {
+	Log hclog.Logger
+}
+
+// Handle is the http.HandlerFunc implementation of the webhook.
+func (w *ConversionWebhook) Handle(rw http.ResponseWriter, r *http.Request) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+		msg := fmt.Sprintf("Invalid content-type: %q", ct)
+		http.Error(rw, msg, http.StatusBadRequest)
+		w.Log.Error("Error on request", "err", msg, "Code", http.StatusBadRequest)
+		return
+	}
+	var review ConversionReview
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &review)
+	}
+	if err != nil || review.Request == nil {
+		msg := "Could not decode conversion request"
+		http.Error(rw, msg, http.StatusBadRequest)
+		w.Log.Error("Error on request", "err", msg, "Code", http.StatusBadRequest)
+		return
+	}
+	review.Response = w.Convert(review.Request)
+	review.Request = nil
+
+	resp, err := json.Marshal(&review)
+	if err != nil {
+		msg := fmt.Sprintf("Error marshalling conversion response: %s", err)
+		http.Error(rw, msg, http.StatusInternalServerError)
+		w.Log.Error("Error on request", "err", msg, "Code", http.StatusInternalServerError)
+		return
+	}
+	if _, err := rw.Write(resp); err != nil {
+		w.Log.Error("Error writing response", "err", err)
+	}
+}
+
+// Convert returns the response converting the objects of the request to
+// the desired version. It fails if any object can't be converted.
+func (w *ConversionWebhook) Convert(req *ConversionRequest) *ConversionResponse {
+	resp := &ConversionResponse{UID: req.UID}
+	gv, err := schema.ParseGroupVersion(req.DesiredAPIVersion)
+	if err != nil {
+		return conversionFailed(resp, err.Error())
+	}
+	for _, raw := range req.Objects {
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+			return conversionFailed(resp, fmt.Sprintf("decoding object: %s", err))
+		}
+		if gv.Group != obj.GroupVersionKind().Group {
+			return conversionFailed(resp, fmt.Sprintf("can't convert %s to %s", obj.GetAPIVersion(), req.DesiredAPIVersion))
+		}
+		if err := v1beta1.Convert(obj, gv.Version); err != nil {
+			w.Log.Error("error converting resource", "kind", obj.GetKind(), "name", obj.GetName(), "err", err)
+			return conversionFailed(resp, err.Error())
+		}
+		data, err := obj.MarshalJSON()
+		if err != nil {
+			return conversionFailed(resp, fmt.Sprintf("encoding object: %s", err))
+		}
+		resp.ConvertedObjects = append(resp.ConvertedObjects, runtime.RawExtension{Raw: data})
+	}
+	resp.Result = metav1.Status{Status: metav1.StatusSuccess}
+	return resp
+}
+
+func conversionFailed(resp *ConversionResponse, message string) *ConversionResponse {
+	resp.ConvertedObjects = nil
+	resp.Result = metav1.Status{Status: metav1.StatusFailure, Message: message}
+	return resp
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/api/v1beta1"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestConversionWebhook_Convert(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		apiVersion        string
+		desiredAPIVersion string
+		expMessage        string
+	}{
+		"to v1beta1": {
+			apiVersion:        v1alpha1.GroupVersion.String(),
+			desiredAPIVersion: v1beta1.GroupVersion.String(),
+		},
+		"to v1alpha1": {
+			apiVersion:        v1beta1.GroupVersion.String(),
+			desiredAPIVersion: v1alpha1.GroupVersion.String(),
+		},
+		"unsupported version": {
+			apiVersion:        v1alpha1.GroupVersion.String(),
+			desiredAPIVersion: "consul.hashicorp.com/v2",
+			expMessage:        `unsupported version "v2"`,
+		},
+		"other group": {
+			apiVersion:        v1alpha1.GroupVersion.String(),
+			desiredAPIVersion: "apps/v1",
+			expMessage:        "can't convert consul.hashicorp.com/v1alpha1 to apps/v1",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			webhook := &ConversionWebhook{Log: hclog.NewNullLogger()}
+			obj := toUnstructured(t, serviceDefaults("foo", "default", "http"))
+			obj.SetAPIVersion(c.apiVersion)
+			obj.SetKind("ServiceDefaults")
+			raw, err := obj.MarshalJSON()
+			require.NoError(t, err)
+
+			resp := webhook.Convert(&ConversionRequest{
+				UID:               types.UID("uid"),
+				DesiredAPIVersion: c.desiredAPIVersion,
+				Objects:           []runtime.RawExtension{{Raw: raw}},
+			})
+			require.Equal(t, types.UID("uid"), resp.UID)
+			if c.expMessage != "" {
+				require.Equal(t, metav1.StatusFailure, resp.Result.Status)
+				require.Equal(t, c.expMessage, resp.Result.Message)
+				require.Empty(t, resp.ConvertedObjects)
+				return
+			}
+			require.Equal(t, metav1.StatusSuccess, resp.Result.Status)
+			require.Len(t, resp.ConvertedObjects, 1)
+			converted := &unstructured.Unstructured{}
+			require.NoError(t, converted.UnmarshalJSON(resp.ConvertedObjects[0].Raw))
+			require.Equal(t, c.desiredAPIVersion, converted.GetAPIVersion())
+			protocol, _, _ := unstructured.NestedString(converted.Object, "spec", "protocol")
+			require.Equal(t, "http", protocol)
+		})
+	}
+}
+
+func TestConversionWebhook_Handle(t *testing.T) {
+	t.Parallel()
+	webhook := &ConversionWebhook{Log: hclog.NewNullLogger()}
+	obj := toUnstructured(t, serviceDefaults("foo", "default", "http"))
+	obj.SetAPIVersion(v1alpha1.GroupVersion.String())
+	obj.SetKind("ServiceDefaults")
+	raw, err := obj.MarshalJSON()
+	require.NoError(t, err)
+	body, err := json.Marshal(&ConversionReview{
+		Request: &ConversionRequest{
+			UID:               types.UID("uid"),
+			DesiredAPIVersion: v1beta1.GroupVersion.String(),
+			Objects:           []runtime.RawExtension{{Raw: raw}},
+		},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	webhook.Handle(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var review ConversionReview
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &review))
+	require.Nil(t, review.Request)
+	require.Equal(t, types.UID("uid"), review.Response.UID)
+	require.Equal(t, metav1.StatusSuccess, review.Response.Result.Status)
+	require.Len(t, review.Response.ConvertedObjects, 1)
+
+	// Requests that aren't JSON are rejected.
+	req = httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(body))
+	rec = httptest.NewRecorder()
+	webhook.Handle(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/gatewayapi"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// GatewayCertificateController implements controller.Resource to write
+// the inline-certificate config entries of the TLS secrets referenced by
+// the gateways of GatewayController as soon as the secrets change, e.g.
+// when cert-manager renews a certificate. Consul pushes the certificates
+// of the entries to the gateways' Envoy proxies, so they're rotated
+// without restarting the gateways.
+type GatewayCertificateController struct {
+	Log          hclog.Logger
+	Client       dynamic.Interface
+	KubeClient   kubernetes.Interface
+	ConsulClient *api.Client
+
+	// Namespace is the Kubernetes namespace to watch. If it's empty,
+	// all namespaces are watched.
+	Namespace string
+}
+
+// Informer implements the controller.Resource interface. It watches the
+// secrets of type kubernetes.io/tls. They aren't synced again
+// periodically since GatewayController writes their entries when it
+// syncs the gateways.
+func (c *GatewayCertificateController) Informer() cache.SharedIndexInformer {
+	secrets := c.KubeClient.CoreV1().Secrets(c.Namespace)
+	selector := fields.OneTermEqualSelector("type", string(corev1.SecretTypeTLS)).String()
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = selector
+				return secrets.List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				return secrets.Watch(options)
+			},
+		},
+		&corev1.Secret{},
+		time.Duration(0),
+		cache.Indexers{},
+	)
+}
+
+// Upsert implements the controller.Resource interface. It writes the
+// inline-certificate config entry of the secret if a gateway references
+// it.
+func (c *GatewayCertificateController) Upsert(key string, raw interface{}) error {
+	secret, ok := raw.(*corev1.Secret)
+	if !ok {
+		c.Log.Warn("upsert got invalid type", "key", key, "type", fmt.Sprintf("%T", raw))
+		return nil
+	}
+	referenced, err := c.referenced(secret)
+	if err != nil || !referenced {
+		return err
+	}
+	cert, err := inlineCertificate(secret)
+	if err != nil {
+		// GatewayController reports the invalid secret on the gateways.
+		c.Log.Warn("referenced secret isn't a valid certificate", "key", key, "err", err)
+		return nil
+	}
+	if _, _, err := c.ConsulClient.ConfigEntries().Set(cert, nil); err != nil {
+		return fmt.Errorf("writing inline-certificate config entry %q: %s", cert.Name, err)
+	}
+	c.Log.Info("wrote certificate", "key", key, "name", cert.Name)
+	return nil
+}
+
+// Delete implements the controller.Resource interface. The config entries
+// of the deleted secrets are cleaned up by GatewayController once the
+// gateways no longer reference them.
+func (c *GatewayCertificateController) Delete(key string) error {
+	c.Log.Debug("resource deleted", "key", key)
+	return nil
+}
+
+// referenced returns true if the listeners of a gateway managed by
+// GatewayController reference the secret.
+func (c *GatewayCertificateController) referenced(secret *corev1.Secret) (bool, error) {
+	list, err := c.Client.Resource(gatewayResource).Namespace(secret.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("listing gateways: %s", err)
+	}
+	for i := range list.Items {
+		gateway := &gatewayapi.Gateway{}
+		if err := decode(&list.Items[i], gateway); err != nil || gateway.DeletionTimestamp != nil {
+			continue
+		}
+		if !referencesSecret(gateway, secret.Name) {
+			continue
+		}
+		class, err := managedClass(c.Client, gateway.Spec.GatewayClassName)
+		if err != nil {
+			return false, err
+		}
+		if class != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// referencesSecret returns true if a listener of the gateway references
+// the secret of its namespace.
+func referencesSecret(gateway *gatewayapi.Gateway, name string) bool {
+	for _, listener := range gateway.Spec.Listeners {
+		if listener.TLS == nil {
+			continue
+		}
+		for _, ref := range listener.TLS.CertificateRefs {
+			if ref.Name == name && (ref.Namespace == "" || ref.Namespace == gateway.Namespace) {
+				return true
+			}
+		}
+	}
+	return false
+}
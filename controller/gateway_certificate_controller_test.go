@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/api/gatewayapi"
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGatewayCertificateController_Upsert(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		secret         string
+		controllerName string
+		expWritten     bool
+	}{
+		"referenced secret": {
+			secret:         "cert",
+			controllerName: GatewayControllerName,
+			expWritten:     true,
+		},
+		"unreferenced secret": {
+			secret:         "other",
+			controllerName: GatewayControllerName,
+		},
+		"gateway of another class": {
+			secret:         "cert",
+			controllerName: "example.com/other",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			gw := gateway("gw")
+			gw.Spec.Listeners = append(gw.Spec.Listeners, gatewayapi.Listener{
+				Name:     "https",
+				Port:     443,
+				Protocol: gatewayapi.HTTPSProtocol,
+				TLS:      &gatewayapi.GatewayTLSConfig{CertificateRefs: []gatewayapi.SecretObjectReference{{Name: "cert"}}},
+			})
+			client := newFakeDynamicClient(toUnstructured(t, gatewayClass("consul", c.controllerName)), toUnstructured(t, gw))
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: c.secret, Namespace: "default"},
+				Type:       corev1.SecretTypeTLS,
+				Data:       map[string][]byte{"tls.crt": []byte("RENEWED"), "tls.key": []byte("KEY")},
+			}
+			controller := &GatewayCertificateController{
+				Log:          hclog.NewNullLogger(),
+				Client:       client,
+				KubeClient:   fake.NewSimpleClientset(secret),
+				ConsulClient: consulClient,
+			}
+
+			require.NoError(t, controller.Upsert("default/"+c.secret, secret))
+			entry := consul.entry("", v1alpha1.InlineCertificateKind, c.secret+"-default")
+			if !c.expWritten {
+				require.Nil(t, entry)
+				return
+			}
+			require.NotNil(t, entry)
+			require.Equal(t, "RENEWED", entry["Certificate"])
+			require.Equal(t, "KEY", entry["PrivateKey"])
+		})
+	}
+}
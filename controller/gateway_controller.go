@@ -0,0 +1,953 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/gatewayapi"
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta1 "k8s.io/api/autoscaling/v2beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// GatewayControllerName is the controllerName of the GatewayClasses
+	// whose gateways are managed by the gateway controllers.
+	GatewayControllerName = "consul.hashicorp.com/gateway-controller"
+
+	// gatewayConfigHashKey is the annotation of the gateway deployments
+	// and services with the hash of the spec they were last written with,
+	// so that they're only updated when the gateway changes.
+	gatewayConfigHashKey = "consul.hashicorp.com/gateway-config-hash"
+
+	// gatewayRegistrationPort is the port of the gateways' registrations
+	// in Consul. Consul configures the listeners of Envoy from the
+	// api-gateway config entry, so the port doesn't need to be one of
+	// the listeners, and it's fixed so that the pods aren't restarted
+	// when the listeners change.
+	gatewayRegistrationPort = 20000
+
+	// Types and reasons of the Gateway API conditions.
+	conditionAccepted         = "Accepted"
+	conditionProgrammed       = "Programmed"
+	conditionResolvedRefs     = "ResolvedRefs"
+	reasonAccepted            = "Accepted"
+	reasonProgrammed          = "Programmed"
+	reasonPending             = "Pending"
+	reasonInvalid             = "Invalid"
+	reasonInvalidCertRef      = "InvalidCertificateRef"
+	reasonUnsupportedProtocol = "UnsupportedProtocol"
+	reasonInvalidParameters   = "InvalidParameters"
+	reasonInvalidPolicy       = "InvalidPolicy"
+)
+
+// GatewayController implements controller.Resource to provision the
+// Gateways of the Gateway API whose class is implemented by
+// GatewayControllerName. Each gateway is an Envoy deployment registered in
+// Consul as an API gateway, exposed by a LoadBalancer service, and its
+// listeners are written to the api-gateway config entry of the gateway,
+// which requires Consul 1.15 or later. The routes attached to the gateway
+// are written by RouteController.
+//
+// The deployments and services are configured by the GatewayClassConfig
+// referenced by the parametersRef of the class. Changes to the config are
+// applied to the gateways when they're next synced. If the config enables
+// autoscaling, each gateway also gets a HorizontalPodAutoscaler, and the
+// replicas of its deployment are left to it.
+//
+// Listeners are added and removed without restarting the gateways: Consul
+// pushes the listeners of the config entry to the running Envoy proxies,
+// and only the ports of the service are updated.
+//
+// The config entries of a gateway are named <name>-<namespace> since the
+// gateways of all Kubernetes namespaces are written to the same Consul
+// namespace.
+//
+// The RateLimitPolicies attached to a gateway or to the HTTPRoutes it
+// accepted are written to the service-defaults config entry of the
+// gateway's Consul service, whose rate limits Consul configures on the
+// gateway's Envoy proxies. Since the policies aren't watched, changes to
+// them are applied when the gateways are next synced.
+type GatewayController struct {
+	Log          hclog.Logger
+	Client       dynamic.Interface
+	KubeClient   kubernetes.Interface
+	ConsulClient *api.Client
+
+	// Namespace is the Kubernetes namespace to watch. If it's empty,
+	// all namespaces are watched.
+	Namespace string
+
+	// ImageConsul is the Consul image of the gateways' init container,
+	// which registers the gateway and generates its Envoy bootstrap.
+	ImageConsul string
+	// ImageEnvoy is the Envoy image of the gateways.
+	ImageEnvoy string
+
+	// EventRecorder records the errors of syncing the gateways as
+	// Kubernetes events on the gateways. Events aren't recorded if it's
+	// nil.
+	EventRecorder record.EventRecorder
+
+	// ResyncPeriod is how often all gateways are synced again, e.g. to
+	// update their status once their deployment is ready.
+	ResyncPeriod time.Duration
+}
+
+// Informer implements the controller.Resource interface.
+func (c *GatewayController) Informer() cache.SharedIndexInformer {
+	return newInformer(c.Client, gatewayResource, c.Namespace, c.ResyncPeriod)
+}
+
+// Upsert implements the controller.Resource interface. It provisions the
+// gateway and writes its config entries to Consul.
+func (c *GatewayController) Upsert(key string, raw interface{}) error {
+	obj, ok := raw.(*unstructured.Unstructured)
+	if !ok {
+		c.Log.Warn("upsert got invalid type", "key", key, "type", fmt.Sprintf("%T", raw))
+		return nil
+	}
+	gateway := &gatewayapi.Gateway{}
+	if err := decode(obj, gateway); err != nil {
+		c.Log.Error("error decoding resource", "key", key, "err", err)
+		return nil
+	}
+
+	class, err := managedClass(c.Client, gateway.Spec.GatewayClassName)
+	if err != nil {
+		return err
+	}
+	// Gateways whose class changed to another controller are cleaned up
+	// like deleted gateways.
+	if obj.GetDeletionTimestamp() != nil || class == nil {
+		return c.finalize(obj, gateway)
+	}
+	if !hasFinalizer(obj) {
+		if obj, err = addFinalizer(c.Client, gatewayResource, obj); err != nil {
+			return err
+		}
+	}
+	status := &gateway.Status
+	generation := obj.GetGeneration()
+
+	config, err := c.classConfig(class)
+	var entry *v1alpha1.APIGatewayConfigEntry
+	var certs []*v1alpha1.InlineCertificateConfigEntry
+	if err == nil {
+		entry, certs, err = c.configEntries(gateway)
+	}
+	if err != nil {
+		reason := reasonInvalid
+		if invalid, ok := err.(*invalidGatewayError); ok {
+			reason = invalid.reason
+		}
+		c.event(obj, corev1.EventTypeWarning, reason, err.Error())
+		changed := status.Conditions.Set(gatewayCondition(conditionAccepted, false, reason, err.Error(), generation))
+		return c.updateStatus(obj, status, changed)
+	}
+	changed := status.Conditions.Set(gatewayCondition(conditionAccepted, true, reasonAccepted, "", generation))
+
+	for _, cert := range certs {
+		if _, _, err := c.ConsulClient.ConfigEntries().Set(cert, nil); err != nil {
+			return c.consulError(obj, status, generation, fmt.Errorf("writing inline-certificate config entry %q: %s", cert.Name, err))
+		}
+	}
+	if _, _, err := c.ConsulClient.ConfigEntries().Set(entry, nil); err != nil {
+		return c.consulError(obj, status, generation, fmt.Errorf("writing api-gateway config entry %q: %s", entry.Name, err))
+	}
+	limits, err := c.rateLimits(obj, gateway)
+	if err != nil {
+		return err
+	}
+	if limits == nil {
+		if err := deleteConfigEntryIfExists(c.ConsulClient, api.ServiceDefaults, entry.Name); err != nil {
+			return c.consulError(obj, status, generation, err)
+		}
+	} else if _, _, err := c.ConsulClient.ConfigEntries().Set(limits, nil); err != nil {
+		return c.consulError(obj, status, generation, fmt.Errorf("writing service-defaults config entry %q: %s", limits.Name, err))
+	}
+
+	deployment, service, err := c.provision(gateway, config)
+	if err != nil {
+		c.event(obj, corev1.EventTypeWarning, reasonPending, err.Error())
+		return err
+	}
+	if deployment.Status.AvailableReplicas > 0 {
+		changed = status.Conditions.Set(gatewayCondition(conditionProgrammed, true, reasonProgrammed, "", generation)) || changed
+	} else {
+		changed = status.Conditions.Set(gatewayCondition(conditionProgrammed, false, reasonPending,
+			"Waiting for the gateway deployment to have an available replica", generation)) || changed
+	}
+	var addresses []gatewayapi.GatewayAddress
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			addresses = append(addresses, gatewayapi.GatewayAddress{Type: "IPAddress", Value: ingress.IP})
+		}
+		if ingress.Hostname != "" {
+			addresses = append(addresses, gatewayapi.GatewayAddress{Type: "Hostname", Value: ingress.Hostname})
+		}
+	}
+	if !addressesEqual(status.Addresses, addresses) {
+		status.Addresses = addresses
+		changed = true
+	}
+	return c.updateStatus(obj, status, changed)
+}
+
+// Delete implements the controller.Resource interface. Gateways are
+// cleaned up by finalize before they're removed.
+func (c *GatewayController) Delete(key string) error {
+	c.Log.Debug("resource deleted", "key", key)
+	return nil
+}
+
+// configEntries returns the api-gateway config entry of the gateway and
+// the inline-certificate config entries of its listeners' certificates.
+func (c *GatewayController) configEntries(gateway *gatewayapi.Gateway) (*v1alpha1.APIGatewayConfigEntry, []*v1alpha1.InlineCertificateConfigEntry, error) {
+	entry := &v1alpha1.APIGatewayConfigEntry{
+		Kind: v1alpha1.APIGatewayKind,
+		Name: gatewayEntryName(gateway.Namespace, gateway.Name),
+		Meta: gatewayEntryMeta(gateway.Namespace, gateway.Name),
+	}
+	var certs []*v1alpha1.InlineCertificateConfigEntry
+	for i, listener := range gateway.Spec.Listeners {
+		path := fmt.Sprintf("spec.listeners[%d]", i)
+		consulListener := v1alpha1.APIGatewayListener{
+			Name:     listener.Name,
+			Hostname: listener.Hostname,
+			Port:     int(listener.Port),
+		}
+		switch listener.Protocol {
+		case gatewayapi.HTTPProtocol, gatewayapi.HTTPSProtocol:
+			consulListener.Protocol = "http"
+		case gatewayapi.TCPProtocol:
+			consulListener.Protocol = "tcp"
+		default:
+			return nil, nil, &invalidGatewayError{reasonUnsupportedProtocol,
+				fmt.Sprintf("%s.protocol %q isn't supported, must be one of HTTP, HTTPS or TCP", path, listener.Protocol)}
+		}
+		if listener.Protocol == gatewayapi.HTTPSProtocol {
+			if listener.TLS == nil || len(listener.TLS.CertificateRefs) == 0 {
+				return nil, nil, &invalidGatewayError{reasonInvalidCertRef, fmt.Sprintf("%s.tls.certificateRefs must be set for HTTPS listeners", path)}
+			}
+			if listener.TLS.Mode != "" && listener.TLS.Mode != "Terminate" {
+				return nil, nil, &invalidGatewayError{reasonInvalid, fmt.Sprintf("%s.tls.mode %q isn't supported, must be Terminate", path, listener.TLS.Mode)}
+			}
+			for j, ref := range listener.TLS.CertificateRefs {
+				cert, err := c.certificate(gateway.Namespace, ref)
+				if err != nil {
+					return nil, nil, &invalidGatewayError{reasonInvalidCertRef, fmt.Sprintf("%s.tls.certificateRefs[%d]: %s", path, j, err)}
+				}
+				certs = append(certs, cert)
+				consulListener.TLS.Certificates = append(consulListener.TLS.Certificates,
+					v1alpha1.ResourceReference{Kind: v1alpha1.InlineCertificateKind, Name: cert.Name})
+			}
+		}
+		entry.Listeners = append(entry.Listeners, consulListener)
+	}
+	return entry, certs, nil
+}
+
+// certificate returns the inline-certificate config entry of the TLS
+// secret referenced by a listener of a gateway in the namespace.
+func (c *GatewayController) certificate(namespace string, ref gatewayapi.SecretObjectReference) (*v1alpha1.InlineCertificateConfigEntry, error) {
+	if (ref.Group != "" && ref.Group != "core") || (ref.Kind != "" && ref.Kind != "Secret") {
+		return nil, fmt.Errorf("only references to Secrets are supported")
+	}
+	if ref.Namespace != "" && ref.Namespace != namespace {
+		return nil, fmt.Errorf("secret %q must be in the namespace of the gateway", ref.Name)
+	}
+	secret, err := c.KubeClient.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("reading secret %q: %s", ref.Name, err)
+	}
+	return inlineCertificate(secret)
+}
+
+// inlineCertificate returns the inline-certificate config entry of the
+// TLS secret.
+func inlineCertificate(secret *corev1.Secret) (*v1alpha1.InlineCertificateConfigEntry, error) {
+	cert, key := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	if len(cert) == 0 || len(key) == 0 {
+		return nil, fmt.Errorf("secret %q must have the %s and %s keys", secret.Name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+	return &v1alpha1.InlineCertificateConfigEntry{
+		Kind:        v1alpha1.InlineCertificateKind,
+		Name:        gatewayEntryName(secret.Namespace, secret.Name),
+		Meta:        gatewayEntryMeta(secret.Namespace, secret.Name),
+		Certificate: string(cert),
+		PrivateKey:  string(key),
+	}, nil
+}
+
+// rateLimits returns the service-defaults config entry with the rate
+// limits of the RateLimitPolicies attached to the gateway or to the
+// HTTPRoutes it accepted, or nil if there are none. Each gateway or route
+// is limited by its oldest policy. Invalid policies and the other policies
+// of a target are ignored and reported as events on the gateway.
+func (c *GatewayController) rateLimits(obj *unstructured.Unstructured, gateway *gatewayapi.Gateway) (*v1alpha1.ServiceDefaultsConfigEntry, error) {
+	list, err := c.Client.Resource(rateLimitPolicyResource).Namespace(c.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing RateLimitPolicies: %s", err)
+	}
+	var policies []*v1alpha1.RateLimitPolicy
+	for i := range list.Items {
+		policy := &v1alpha1.RateLimitPolicy{}
+		if err := decode(&list.Items[i], policy); err != nil || policy.Spec.TargetRef.Group != gatewayapi.Group {
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		a, b := policies[i], policies[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+	})
+
+	var limits v1alpha1.InstanceLevelRateLimits
+	limited := make(map[string]bool)
+	for _, policy := range policies {
+		ref := policy.Spec.TargetRef
+		var paths []v1alpha1.HTTPPathMatch
+		switch {
+		case ref.Kind == "Gateway" && policy.Namespace == gateway.Namespace && ref.Name == gateway.Name:
+		case ref.Kind == gatewayapi.HTTPRouteKind:
+			if paths, err = c.acceptedRoutePaths(gateway, policy.Namespace, ref.Name); err != nil {
+				return nil, err
+			}
+			if len(paths) == 0 {
+				continue
+			}
+		default:
+			continue
+		}
+		target := fmt.Sprintf("%s %s/%s", ref.Kind, policy.Namespace, ref.Name)
+		if err := policy.Validate(); err != nil {
+			c.event(obj, corev1.EventTypeWarning, reasonInvalidPolicy,
+				fmt.Sprintf("%s %s/%s: %s", v1alpha1.RateLimitPolicyKind, policy.Namespace, policy.Name, err))
+			continue
+		}
+		if limited[target] {
+			c.event(obj, corev1.EventTypeWarning, reasonInvalidPolicy,
+				fmt.Sprintf("%s %s/%s is ignored since an older policy is attached to %s",
+					v1alpha1.RateLimitPolicyKind, policy.Namespace, policy.Name, target))
+			continue
+		}
+		limited[target] = true
+
+		rate, burst := policy.RequestsPerSecond(), int(policy.Spec.Burst)
+		if ref.Kind == "Gateway" {
+			limits.RequestsPerSecond, limits.RequestsMaxBurst = rate, burst
+			continue
+		}
+		for _, path := range paths {
+			route := v1alpha1.InstanceLevelRouteRateLimits{RequestsPerSecond: rate, RequestsMaxBurst: burst}
+			switch path.Match {
+			case "exact":
+				route.PathExact = path.Value
+			case "prefix":
+				route.PathPrefix = path.Value
+			case "regex":
+				route.PathRegex = path.Value
+			}
+			limits.Routes = append(limits.Routes, route)
+		}
+	}
+	if len(limited) == 0 {
+		return nil, nil
+	}
+	return &v1alpha1.ServiceDefaultsConfigEntry{
+		Kind:       api.ServiceDefaults,
+		Name:       gatewayEntryName(gateway.Namespace, gateway.Name),
+		Meta:       gatewayEntryMeta(gateway.Namespace, gateway.Name),
+		RateLimits: &v1alpha1.RateLimits{InstanceLevel: limits},
+	}, nil
+}
+
+// acceptedRoutePaths returns the paths matched by the rules of the
+// HTTPRoute, or nil if the route doesn't exist or the gateway didn't accept
+// it. Consul's route rate limits only match paths, so the limits of a route
+// apply to all the requests of the gateway with the paths of its rules.
+func (c *GatewayController) acceptedRoutePaths(gateway *gatewayapi.Gateway, namespace, name string) ([]v1alpha1.HTTPPathMatch, error) {
+	obj, err := c.Client.Resource(httpRouteResource).Namespace(namespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading HTTPRoute %s/%s: %s", namespace, name, err)
+	}
+	route := &gatewayapi.HTTPRoute{}
+	if err := decode(obj, route); err != nil {
+		return nil, err
+	}
+	accepted := false
+	for _, parent := range route.Status.Parents {
+		ref := parent.ParentRef
+		if ref.Namespace == "" {
+			ref.Namespace = namespace
+		}
+		if parent.ControllerName != GatewayControllerName || ref.Namespace != gateway.Namespace || ref.Name != gateway.Name {
+			continue
+		}
+		if condition := parent.Conditions.Get(conditionAccepted); condition != nil && condition.Status == string(corev1.ConditionTrue) {
+			accepted = true
+		}
+	}
+	if !accepted {
+		return nil, nil
+	}
+	var paths []v1alpha1.HTTPPathMatch
+	for _, rule := range route.Spec.Rules {
+		// Rules without matches match all requests.
+		if len(rule.Matches) == 0 {
+			paths = append(paths, v1alpha1.HTTPPathMatch{Match: "prefix", Value: "/"})
+		}
+		for _, match := range rule.Matches {
+			path := v1alpha1.HTTPPathMatch{Match: "prefix", Value: "/"}
+			if match.Path != nil {
+				// Accepted routes only have supported match types.
+				path.Match, _ = translateMatchType(match.Path.Type, gatewayapi.PathMatchPathPrefix, "")
+				if match.Path.Value != "" {
+					path.Value = match.Path.Value
+				}
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// classConfig returns the GatewayClassConfig referenced by the
+// parametersRef of the class, or the default config if the class has no
+// parameters.
+func (c *GatewayController) classConfig(class *gatewayapi.GatewayClass) (*v1alpha1.GatewayClassConfig, error) {
+	ref := class.Spec.ParametersRef
+	if ref == nil {
+		return &v1alpha1.GatewayClassConfig{}, nil
+	}
+	if ref.Group != v1alpha1.GroupVersion.Group || ref.Kind != v1alpha1.GatewayClassConfigKind {
+		return nil, &invalidGatewayError{reasonInvalidParameters,
+			fmt.Sprintf("parametersRef of GatewayClass %q must reference a %s", class.Name, v1alpha1.GatewayClassConfigKind)}
+	}
+	obj, err := c.Client.Resource(gatewayClassConfigResource).Get(ref.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, &invalidGatewayError{reasonInvalidParameters,
+			fmt.Sprintf("%s %q of GatewayClass %q doesn't exist", v1alpha1.GatewayClassConfigKind, ref.Name, class.Name)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s %q: %s", v1alpha1.GatewayClassConfigKind, ref.Name, err)
+	}
+	config := &v1alpha1.GatewayClassConfig{}
+	if err := decode(obj, config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, &invalidGatewayError{reasonInvalidParameters,
+			fmt.Sprintf("%s %q: %s", v1alpha1.GatewayClassConfigKind, ref.Name, err)}
+	}
+	return config, nil
+}
+
+// provision creates or updates the deployment, service and autoscaler of
+// the gateway and returns the deployment and service.
+func (c *GatewayController) provision(gateway *gatewayapi.Gateway, config *v1alpha1.GatewayClassConfig) (*appsv1.Deployment, *corev1.Service, error) {
+	deployment, err := c.deployment(gateway, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	deployments := c.KubeClient.AppsV1().Deployments(gateway.Namespace)
+	existingDeployment, err := deployments.Get(deployment.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if deployment, err = deployments.Create(deployment); err != nil {
+			return nil, nil, fmt.Errorf("creating deployment %q: %s", deployment.Name, err)
+		}
+	case err != nil:
+		return nil, nil, fmt.Errorf("reading deployment %q: %s", deployment.Name, err)
+	case existingDeployment.Annotations[gatewayConfigHashKey] != deployment.Annotations[gatewayConfigHashKey]:
+		deployment.ResourceVersion = existingDeployment.ResourceVersion
+		// The autoscaler owns the replicas.
+		if config.Spec.Deployment.Autoscaling != nil && existingDeployment.Spec.Replicas != nil {
+			deployment.Spec.Replicas = existingDeployment.Spec.Replicas
+		}
+		if deployment, err = deployments.Update(deployment); err != nil {
+			return nil, nil, fmt.Errorf("updating deployment %q: %s", deployment.Name, err)
+		}
+	default:
+		deployment = existingDeployment
+	}
+
+	service := c.service(gateway, config)
+	services := c.KubeClient.CoreV1().Services(gateway.Namespace)
+	existingService, err := services.Get(service.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if service, err = services.Create(service); err != nil {
+			return nil, nil, fmt.Errorf("creating service %q: %s", service.Name, err)
+		}
+	case err != nil:
+		return nil, nil, fmt.Errorf("reading service %q: %s", service.Name, err)
+	case existingService.Annotations[gatewayConfigHashKey] != service.Annotations[gatewayConfigHashKey]:
+		// The cluster IP and node ports are allocated by Kubernetes and
+		// can't be changed.
+		service.ResourceVersion = existingService.ResourceVersion
+		service.Spec.ClusterIP = existingService.Spec.ClusterIP
+		for i := range service.Spec.Ports {
+			for _, existing := range existingService.Spec.Ports {
+				if existing.Name == service.Spec.Ports[i].Name {
+					service.Spec.Ports[i].NodePort = existing.NodePort
+				}
+			}
+		}
+		if service, err = services.Update(service); err != nil {
+			return nil, nil, fmt.Errorf("updating service %q: %s", service.Name, err)
+		}
+	default:
+		service = existingService
+	}
+
+	if err := c.provisionAutoscaler(gateway, config); err != nil {
+		return nil, nil, err
+	}
+	return deployment, service, nil
+}
+
+// provisionAutoscaler creates or updates the autoscaler of the gateway if
+// the class config enables autoscaling, and deletes it otherwise.
+func (c *GatewayController) provisionAutoscaler(gateway *gatewayapi.Gateway, config *v1alpha1.GatewayClassConfig) error {
+	autoscalers := c.KubeClient.AutoscalingV2beta1().HorizontalPodAutoscalers(gateway.Namespace)
+	existing, err := autoscalers.Get(gateway.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		existing, err = nil, nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading autoscaler %q: %s", gateway.Name, err)
+	}
+	if config.Spec.Deployment.Autoscaling == nil {
+		if existing == nil || !ownedBy(existing.OwnerReferences, gateway.UID) {
+			return nil
+		}
+		if err := autoscalers.Delete(gateway.Name, nil); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting autoscaler %q: %s", gateway.Name, err)
+		}
+		return nil
+	}
+
+	autoscaler := c.autoscaler(gateway, config.Spec.Deployment.Autoscaling)
+	switch {
+	case existing == nil:
+		if _, err := autoscalers.Create(autoscaler); err != nil {
+			return fmt.Errorf("creating autoscaler %q: %s", autoscaler.Name, err)
+		}
+	case existing.Annotations[gatewayConfigHashKey] != autoscaler.Annotations[gatewayConfigHashKey]:
+		autoscaler.ResourceVersion = existing.ResourceVersion
+		if _, err := autoscalers.Update(autoscaler); err != nil {
+			return fmt.Errorf("updating autoscaler %q: %s", autoscaler.Name, err)
+		}
+	}
+	return nil
+}
+
+// autoscaler returns the HorizontalPodAutoscaler of the gateway's
+// deployment.
+func (c *GatewayController) autoscaler(gateway *gatewayapi.Gateway, spec *v1alpha1.GatewayAutoscalingSpec) *autoscalingv2beta1.HorizontalPodAutoscaler {
+	metrics := spec.Metrics
+	if len(metrics) == 0 {
+		utilization := v1alpha1.DefaultGatewayCPUUtilization
+		metrics = []autoscalingv2beta1.MetricSpec{{
+			Type: autoscalingv2beta1.ResourceMetricSourceType,
+			Resource: &autoscalingv2beta1.ResourceMetricSource{
+				Name:                     corev1.ResourceCPU,
+				TargetAverageUtilization: &utilization,
+			},
+		}}
+	}
+	autoscaler := &autoscalingv2beta1.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            gateway.Name,
+			Namespace:       gateway.Namespace,
+			Labels:          gatewayLabels(gateway),
+			OwnerReferences: gatewayOwnerReferences(gateway),
+		},
+		Spec: autoscalingv2beta1.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2beta1.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       gateway.Name,
+			},
+			MinReplicas: spec.MinReplicas,
+			MaxReplicas: spec.MaxReplicas,
+			Metrics:     metrics,
+		},
+	}
+	// The spec only holds encodable fields, so the hash can't fail.
+	setConfigHash(&autoscaler.ObjectMeta, autoscaler.Spec)
+	return autoscaler
+}
+
+// deployment returns the deployment of the gateway's Envoy proxies. Its
+// init container registers the gateway in Consul with the local client
+// agent and writes the Envoy bootstrap, and the Envoy container's preStop
+// hook deregisters it. The pod template doesn't depend on the listeners,
+// whose container ports would only be informational.
+func (c *GatewayController) deployment(gateway *gatewayapi.Gateway, config *v1alpha1.GatewayClassConfig) (*appsv1.Deployment, error) {
+	name := gatewayEntryName(gateway.Namespace, gateway.Name)
+	var buf bytes.Buffer
+	err := gatewayInitCommandTpl.Execute(&buf, struct {
+		Service string
+		Port    int32
+	}{name, gatewayRegistrationPort})
+	if err != nil {
+		return nil, err
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "HOST_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"}}},
+		{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+		{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+		{Name: "CONSUL_HTTP_ADDR", Value: "$(HOST_IP):8500"},
+	}
+	volumeMounts := []corev1.VolumeMount{{Name: "consul-gateway", MountPath: "/consul/gateway"}}
+	replicas := int32(1)
+	if autoscaling := config.Spec.Deployment.Autoscaling; autoscaling != nil {
+		// The autoscaler scales the new deployments up from their minimum.
+		if autoscaling.MinReplicas != nil {
+			replicas = *autoscaling.MinReplicas
+		}
+	} else if config.Spec.Deployment.Replicas != nil {
+		replicas = *config.Spec.Deployment.Replicas
+	}
+	labels := gatewayLabels(gateway)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            gateway.Name,
+			Namespace:       gateway.Namespace,
+			Labels:          labels,
+			OwnerReferences: gatewayOwnerReferences(gateway),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: config.Spec.Deployment.Annotations},
+				Spec: corev1.PodSpec{
+					NodeSelector:              config.Spec.NodeSelector,
+					Tolerations:               config.Spec.Tolerations,
+					Affinity:                  config.Spec.Affinity,
+					TopologySpreadConstraints: config.Spec.TopologySpreadConstraints,
+					PriorityClassName:         config.Spec.PriorityClassName,
+					Volumes: []corev1.Volume{{
+						Name:         "consul-gateway",
+						VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+					}},
+					InitContainers: []corev1.Container{{
+						Name:         "consul-gateway-init",
+						Image:        c.ImageConsul,
+						Env:          env,
+						VolumeMounts: volumeMounts,
+						Command:      []string{"/bin/sh", "-ec", buf.String()},
+					}},
+					Containers: []corev1.Container{{
+						Name:         "envoy",
+						Image:        c.ImageEnvoy,
+						Env:          env,
+						VolumeMounts: volumeMounts,
+						Command:      []string{"envoy", "--max-obj-name-len", "256", "--config-path", "/consul/gateway/envoy-bootstrap.yaml"},
+						Lifecycle: &corev1.Lifecycle{
+							PreStop: &corev1.Handler{
+								Exec: &corev1.ExecAction{
+									Command: []string{"/bin/sh", "-ec", gatewayBracketHostIP + "\n" +
+										`/consul/gateway/consul services deregister -id="$POD_NAME"`},
+								},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+	if resources := config.Spec.Deployment.Resources; resources != nil {
+		deployment.Spec.Template.Spec.Containers[0].Resources = *resources
+	}
+	if err := setConfigHash(&deployment.ObjectMeta, deployment.Spec); err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// service returns the service exposing the listeners of the gateway. It's
+// a LoadBalancer service unless the class config sets another type.
+func (c *GatewayController) service(gateway *gatewayapi.Gateway, config *v1alpha1.GatewayClassConfig) *corev1.Service {
+	labels := gatewayLabels(gateway)
+	serviceType := config.Spec.ServiceType
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeLoadBalancer
+	}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            gateway.Name,
+			Namespace:       gateway.Namespace,
+			Labels:          labels,
+			OwnerReferences: gatewayOwnerReferences(gateway),
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     serviceType,
+			Selector: labels,
+		},
+	}
+	for _, key := range config.Spec.CopyAnnotations.Service {
+		if value, ok := gateway.Annotations[key]; ok {
+			if service.Annotations == nil {
+				service.Annotations = make(map[string]string)
+			}
+			service.Annotations[key] = value
+		}
+	}
+	for _, listener := range gateway.Spec.Listeners {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       listener.Name,
+			Port:       listener.Port,
+			TargetPort: intstr.FromInt(int(listener.Port)),
+			Protocol:   corev1.ProtocolTCP,
+		})
+	}
+	// The spec is encoded without the allocated fields, so the hash can't
+	// fail. The copied annotations are part of the hash so that the
+	// service is updated when they change.
+	setConfigHash(&service.ObjectMeta, struct {
+		Spec        corev1.ServiceSpec
+		Annotations map[string]string
+	}{service.Spec, service.Annotations})
+	return service
+}
+
+// finalize deletes the config entries of the gateway, and its deployment
+// and service if it isn't being deleted, and then removes the finalizer of
+// the gateway.
+func (c *GatewayController) finalize(obj *unstructured.Unstructured, gateway *gatewayapi.Gateway) error {
+	if !hasFinalizer(obj) {
+		return nil
+	}
+	for _, kind := range []string{v1alpha1.APIGatewayKind, api.ServiceDefaults} {
+		if err := deleteConfigEntryIfExists(c.ConsulClient, kind, gatewayEntryName(gateway.Namespace, gateway.Name)); err != nil {
+			c.event(obj, corev1.EventTypeWarning, reasonConsulAgentError, err.Error())
+			return err
+		}
+	}
+	for _, listener := range gateway.Spec.Listeners {
+		if listener.TLS == nil {
+			continue
+		}
+		for _, ref := range listener.TLS.CertificateRefs {
+			if err := deleteConfigEntryIfExists(c.ConsulClient, v1alpha1.InlineCertificateKind, gatewayEntryName(gateway.Namespace, ref.Name)); err != nil {
+				c.event(obj, corev1.EventTypeWarning, reasonConsulAgentError, err.Error())
+				return err
+			}
+		}
+	}
+	// Deleted gateways' deployments, services and autoscalers are garbage
+	// collected.
+	if obj.GetDeletionTimestamp() == nil {
+		err := c.KubeClient.AutoscalingV2beta1().HorizontalPodAutoscalers(gateway.Namespace).Delete(gateway.Name, nil)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting autoscaler %q: %s", gateway.Name, err)
+		}
+		err = c.KubeClient.AppsV1().Deployments(gateway.Namespace).Delete(gateway.Name, nil)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting deployment %q: %s", gateway.Name, err)
+		}
+		err = c.KubeClient.CoreV1().Services(gateway.Namespace).Delete(gateway.Name, nil)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting service %q: %s", gateway.Name, err)
+		}
+	}
+	return removeFinalizer(c.Client, gatewayResource, obj)
+}
+
+// consulError reports an error writing the config entries of the gateway
+// and returns it.
+func (c *GatewayController) consulError(obj *unstructured.Unstructured, status *gatewayapi.GatewayStatus, generation int64, err error) error {
+	c.event(obj, corev1.EventTypeWarning, reasonConsulAgentError, err.Error())
+	if status.Conditions.Set(gatewayCondition(conditionProgrammed, false, reasonPending, err.Error(), generation)) {
+		if statusErr := writeStatus(c.Client, gatewayResource, obj, status); statusErr != nil {
+			c.Log.Error("error updating status", "name", obj.GetName(), "err", statusErr)
+		}
+	}
+	return err
+}
+
+// updateStatus updates the status of the gateway if it changed.
+func (c *GatewayController) updateStatus(obj *unstructured.Unstructured, status *gatewayapi.GatewayStatus, changed bool) error {
+	if !changed {
+		return nil
+	}
+	return writeStatus(c.Client, gatewayResource, obj, status)
+}
+
+// event records an event on the gateway if the controller has an event
+// recorder.
+func (c *GatewayController) event(obj *unstructured.Unstructured, eventType, reason, message string) {
+	if c.EventRecorder != nil {
+		c.EventRecorder.Event(obj, eventType, reason, message)
+	}
+}
+
+// invalidGatewayError is returned for gateways that can't be programmed,
+// with the reason of their Accepted condition.
+type invalidGatewayError struct {
+	reason  string
+	message string
+}
+
+func (e *invalidGatewayError) Error() string {
+	return e.message
+}
+
+var (
+	gatewayClassResource       = gatewayapi.GroupVersion.WithResource(gatewayapi.GatewayClassResource)
+	gatewayResource            = gatewayapi.GroupVersion.WithResource(gatewayapi.GatewayResource)
+	gatewayClassConfigResource = v1alpha1.GroupVersion.WithResource(v1alpha1.GatewayClassConfigResource)
+	httpRouteResource          = gatewayapi.GroupVersion.WithResource(gatewayapi.HTTPRouteResource)
+	rateLimitPolicyResource    = v1alpha1.GroupVersion.WithResource(v1alpha1.RateLimitPolicyResource)
+)
+
+// managedClass returns the GatewayClass if it's implemented by the gateway
+// controllers, or nil if it isn't or doesn't exist.
+func managedClass(client dynamic.Interface, name string) (*gatewayapi.GatewayClass, error) {
+	obj, err := client.Resource(gatewayClassResource).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading GatewayClass %q: %s", name, err)
+	}
+	class := &gatewayapi.GatewayClass{}
+	if err := decode(obj, class); err != nil {
+		return nil, err
+	}
+	if class.Spec.ControllerName != GatewayControllerName {
+		return nil, nil
+	}
+	return class, nil
+}
+
+// gatewayEntryName returns the name of the config entry of a Gateway API
+// resource. The namespace is part of the name since the resources of all
+// namespaces are written to the same Consul namespace.
+func gatewayEntryName(namespace, name string) string {
+	return name + "-" + namespace
+}
+
+// gatewayEntryMeta returns the meta of the config entry of a Gateway API
+// resource.
+func gatewayEntryMeta(namespace, name string) map[string]string {
+	return map[string]string{
+		"external-source": "kubernetes",
+		"k8s-namespace":   namespace,
+		"k8s-name":        name,
+	}
+}
+
+// deleteConfigEntryIfExists deletes the config entry, ignoring entries
+// that don't exist.
+func deleteConfigEntryIfExists(client *api.Client, kind, name string) error {
+	if _, err := client.ConfigEntries().Delete(kind, name, nil); err != nil && !isNotFound(err) {
+		return fmt.Errorf("deleting %s config entry %q: %s", kind, name, err)
+	}
+	return nil
+}
+
+func gatewayLabels(gateway *gatewayapi.Gateway) map[string]string {
+	return map[string]string{
+		"app":                                    "consul",
+		"component":                              "api-gateway",
+		"gateway.consul.hashicorp.com/name":      gateway.Name,
+		"gateway.consul.hashicorp.com/namespace": gateway.Namespace,
+	}
+}
+
+func gatewayOwnerReferences(gateway *gatewayapi.Gateway) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{
+		APIVersion: gatewayapi.GroupVersion.String(),
+		Kind:       "Gateway",
+		Name:       gateway.Name,
+		UID:        gateway.UID,
+		Controller: &controller,
+	}}
+}
+
+func gatewayCondition(conditionType string, status bool, reason, message string, generation int64) gatewayapi.Condition {
+	condition := gatewayapi.Condition{
+		Type:               conditionType,
+		Status:             string(corev1.ConditionFalse),
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	}
+	if status {
+		condition.Status = string(corev1.ConditionTrue)
+	}
+	return condition
+}
+
+func addressesEqual(a, b []gatewayapi.GatewayAddress) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// setConfigHash sets the gatewayConfigHashKey annotation to the hash of
+// the spec.
+func setConfigHash(meta *metav1.ObjectMeta, spec interface{}) error {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	hash := fnv.New32a()
+	hash.Write(data)
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[gatewayConfigHashKey] = fmt.Sprintf("%x", hash.Sum32())
+	return nil
+}
+
+// gatewayBracketHostIP brackets the host IP of CONSUL_HTTP_ADDR on IPv6
+// clusters, since Kubernetes doesn't bracket it in "$(HOST_IP):8500".
+const gatewayBracketHostIP = `case "${HOST_IP}" in *:*) export CONSUL_HTTP_ADDR="[${HOST_IP}]:8500" ;; esac`
+
+var gatewayInitCommandTpl = template.Must(template.New("root").Parse(strings.TrimSpace(gatewayBracketHostIP + `
+case "${POD_IP}" in *:*) POD_HOST="[${POD_IP}]" ;; *) POD_HOST="${POD_IP}" ;; esac
+/bin/consul connect envoy -gateway=api -register \
+  -service="{{ .Service }}" \
+  -proxy-id="$POD_NAME" \
+  -address="${POD_HOST}:{{ .Port }}" \
+  -bootstrap > /consul/gateway/envoy-bootstrap.yaml
+cp /bin/consul /consul/gateway/consul
+`)))
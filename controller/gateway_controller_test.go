@@ -0,0 +1,557 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/api/gatewayapi"
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestGatewayController_Upsert(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	kubeClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cert", Namespace: "default"},
+		Data:       map[string][]byte{"tls.crt": []byte("CERT"), "tls.key": []byte("KEY")},
+	})
+	gw := gateway("gw")
+	gw.Spec.Listeners = append(gw.Spec.Listeners, gatewayapi.Listener{
+		Name:     "https",
+		Port:     443,
+		Protocol: gatewayapi.HTTPSProtocol,
+		TLS:      &gatewayapi.GatewayTLSConfig{CertificateRefs: []gatewayapi.SecretObjectReference{{Name: "cert"}}},
+	})
+	obj := toUnstructured(t, gw)
+	client := newFakeDynamicClient(toUnstructured(t, gatewayClass("consul", GatewayControllerName)), obj)
+	controller := gatewayController(client, kubeClient, consulClient)
+
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	entry := consul.entry("", v1alpha1.APIGatewayKind, "gw-default")
+	require.NotNil(t, entry)
+	require.Equal(t, "kubernetes", entry["Meta"].(map[string]interface{})["external-source"])
+	listeners := entry["Listeners"].([]interface{})
+	require.Len(t, listeners, 2)
+	require.Equal(t, "http", listeners[0].(map[string]interface{})["Protocol"])
+	require.EqualValues(t, 80, listeners[0].(map[string]interface{})["Port"])
+	require.Equal(t, map[string]interface{}{
+		"Certificates": []interface{}{map[string]interface{}{"Kind": v1alpha1.InlineCertificateKind, "Name": "cert-default"}},
+	}, listeners[1].(map[string]interface{})["TLS"])
+	cert := consul.entry("", v1alpha1.InlineCertificateKind, "cert-default")
+	require.NotNil(t, cert)
+	require.Equal(t, "CERT", cert["Certificate"])
+	require.Equal(t, "KEY", cert["PrivateKey"])
+
+	deployment, err := kubeClient.AppsV1().Deployments("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "gw", deployment.OwnerReferences[0].Name)
+	require.Equal(t, "consul:latest", deployment.Spec.Template.Spec.InitContainers[0].Image)
+	initCommand := deployment.Spec.Template.Spec.InitContainers[0].Command[2]
+	require.Contains(t, initCommand, `-service="gw-default"`)
+	// The IPv6 addresses of the host and pod are bracketed.
+	require.Contains(t, initCommand, `case "${HOST_IP}" in *:*) export CONSUL_HTTP_ADDR="[${HOST_IP}]:8500" ;; esac`)
+	require.Contains(t, initCommand, `-address="${POD_HOST}:20000"`)
+	require.Contains(t, deployment.Spec.Template.Spec.Containers[0].Lifecycle.PreStop.Exec.Command[2],
+		`case "${HOST_IP}" in *:*) export CONSUL_HTTP_ADDR="[${HOST_IP}]:8500" ;; esac`)
+	require.Equal(t, "envoy:latest", deployment.Spec.Template.Spec.Containers[0].Image)
+	service, err := kubeClient.CoreV1().Services("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, corev1.ServiceTypeLoadBalancer, service.Spec.Type)
+	require.Len(t, service.Spec.Ports, 2)
+
+	status := gatewayStatus(t, client, "default", "gw")
+	require.Equal(t, "True", status.Conditions.Get(conditionAccepted).Status)
+	require.Equal(t, "False", status.Conditions.Get(conditionProgrammed).Status)
+	require.Equal(t, reasonPending, status.Conditions.Get(conditionProgrammed).Reason)
+
+	// Once the deployment is available and the service has an address,
+	// the gateway is programmed.
+	deployment.Status.AvailableReplicas = 1
+	_, err = kubeClient.AppsV1().Deployments("default").UpdateStatus(deployment)
+	require.NoError(t, err)
+	service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "1.2.3.4"}}
+	_, err = kubeClient.CoreV1().Services("default").UpdateStatus(service)
+	require.NoError(t, err)
+	obj, err = client.Resource(gatewayResource).Namespace("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	status = gatewayStatus(t, client, "default", "gw")
+	require.Equal(t, "True", status.Conditions.Get(conditionProgrammed).Status)
+	require.Equal(t, []gatewayapi.GatewayAddress{{Type: "IPAddress", Value: "1.2.3.4"}}, status.Addresses)
+}
+
+func TestGatewayController_UpsertClassConfig(t *testing.T) {
+	t.Parallel()
+	_, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	kubeClient := fake.NewSimpleClientset()
+	replicas := int32(3)
+	resources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+	}
+	tolerations := []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "gateways", Effect: corev1.TaintEffectNoSchedule}}
+	affinity := &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"component": "api-gateway"}},
+			TopologyKey:   "kubernetes.io/hostname",
+		}},
+	}}
+	spread := []corev1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       "topology.kubernetes.io/zone",
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"component": "api-gateway"}},
+	}}
+	config := &v1alpha1.GatewayClassConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "gateways"},
+		Spec: v1alpha1.GatewayClassConfigSpec{
+			Deployment:                v1alpha1.GatewayDeploymentSpec{Replicas: &replicas, Resources: &resources},
+			ServiceType:               corev1.ServiceTypeNodePort,
+			NodeSelector:              map[string]string{"pool": "gateways"},
+			Tolerations:               tolerations,
+			Affinity:                  affinity,
+			TopologySpreadConstraints: spread,
+			PriorityClassName:         "system-cluster-critical",
+			CopyAnnotations:           v1alpha1.CopyAnnotationsSpec{Service: []string{"external-dns.alpha.kubernetes.io/hostname"}},
+		},
+	}
+	class := gatewayClass("consul", GatewayControllerName)
+	class.Spec.ParametersRef = &gatewayapi.ParametersReference{
+		Group: v1alpha1.GroupVersion.Group,
+		Kind:  v1alpha1.GatewayClassConfigKind,
+		Name:  "gateways",
+	}
+	gw := gateway("gw")
+	gw.Annotations = map[string]string{
+		"external-dns.alpha.kubernetes.io/hostname": "gw.example.com",
+		"example.com/other":                         "not copied",
+	}
+	obj := toUnstructured(t, gw)
+	client := newFakeDynamicClient(toUnstructured(t, class), toUnstructured(t, config), obj)
+	controller := gatewayController(client, kubeClient, consulClient)
+
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	deployment, err := kubeClient.AppsV1().Deployments("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), *deployment.Spec.Replicas)
+	require.Equal(t, map[string]string{"pool": "gateways"}, deployment.Spec.Template.Spec.NodeSelector)
+	require.Equal(t, tolerations, deployment.Spec.Template.Spec.Tolerations)
+	require.Equal(t, affinity, deployment.Spec.Template.Spec.Affinity)
+	require.Equal(t, spread, deployment.Spec.Template.Spec.TopologySpreadConstraints)
+	require.Equal(t, "system-cluster-critical", deployment.Spec.Template.Spec.PriorityClassName)
+	require.Equal(t, "100m", deployment.Spec.Template.Spec.Containers[0].Resources.Requests.Cpu().String())
+	service, err := kubeClient.CoreV1().Services("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, corev1.ServiceTypeNodePort, service.Spec.Type)
+	require.Equal(t, "gw.example.com", service.Annotations["external-dns.alpha.kubernetes.io/hostname"])
+	require.NotContains(t, service.Annotations, "example.com/other")
+}
+
+// Test that the gateways of a class config enabling autoscaling get an
+// autoscaler, which owns the replicas of their deployment.
+func TestGatewayController_UpsertAutoscaling(t *testing.T) {
+	t.Parallel()
+	_, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	kubeClient := fake.NewSimpleClientset()
+	minReplicas := int32(2)
+	config := &v1alpha1.GatewayClassConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "gateways"},
+		Spec: v1alpha1.GatewayClassConfigSpec{
+			Deployment: v1alpha1.GatewayDeploymentSpec{
+				Annotations: map[string]string{"prometheus.io/scrape": "true"},
+				Autoscaling: &v1alpha1.GatewayAutoscalingSpec{MinReplicas: &minReplicas, MaxReplicas: 10},
+			},
+		},
+	}
+	class := gatewayClass("consul", GatewayControllerName)
+	class.Spec.ParametersRef = &gatewayapi.ParametersReference{
+		Group: v1alpha1.GroupVersion.Group,
+		Kind:  v1alpha1.GatewayClassConfigKind,
+		Name:  "gateways",
+	}
+	obj := toUnstructured(t, gateway("gw"))
+	client := newFakeDynamicClient(toUnstructured(t, class), toUnstructured(t, config), obj)
+	controller := gatewayController(client, kubeClient, consulClient)
+
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	deployment, err := kubeClient.AppsV1().Deployments("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int32(2), *deployment.Spec.Replicas)
+	require.Equal(t, "true", deployment.Spec.Template.Annotations["prometheus.io/scrape"])
+	autoscaler, err := kubeClient.AutoscalingV2beta1().HorizontalPodAutoscalers("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "Deployment", autoscaler.Spec.ScaleTargetRef.Kind)
+	require.Equal(t, "gw", autoscaler.Spec.ScaleTargetRef.Name)
+	require.Equal(t, int32(2), *autoscaler.Spec.MinReplicas)
+	require.Equal(t, int32(10), autoscaler.Spec.MaxReplicas)
+	require.Len(t, autoscaler.Spec.Metrics, 1)
+	require.Equal(t, corev1.ResourceCPU, autoscaler.Spec.Metrics[0].Resource.Name)
+	require.Equal(t, v1alpha1.DefaultGatewayCPUUtilization, *autoscaler.Spec.Metrics[0].Resource.TargetAverageUtilization)
+
+	// The autoscaler scales the deployment out, and the replicas are kept
+	// when the deployment is updated.
+	scaled := int32(5)
+	deployment.Spec.Replicas = &scaled
+	_, err = kubeClient.AppsV1().Deployments("default").Update(deployment)
+	require.NoError(t, err)
+	gw := gateway("gw")
+	gw.Spec.Listeners = append(gw.Spec.Listeners, gatewayapi.Listener{Name: "http-alt", Port: 8080, Protocol: gatewayapi.HTTPProtocol})
+	obj, err = client.Resource(gatewayResource).Namespace("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	obj.Object["spec"] = toUnstructured(t, gw).Object["spec"]
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	deployment, err = kubeClient.AppsV1().Deployments("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int32(5), *deployment.Spec.Replicas)
+
+	// The autoscaler is deleted when the config disables autoscaling.
+	config.Spec.Deployment.Autoscaling = nil
+	_, err = client.Resource(gatewayClassConfigResource).Update(toUnstructured(t, config), metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	_, err = kubeClient.AutoscalingV2beta1().HorizontalPodAutoscalers("default").Get("gw", metav1.GetOptions{})
+	require.True(t, apierrors.IsNotFound(err))
+}
+
+func TestGatewayController_UpsertInvalidClassConfig(t *testing.T) {
+	t.Parallel()
+	replicas := int32(-1)
+	cases := map[string]struct {
+		ref    gatewayapi.ParametersReference
+		expErr string
+	}{
+		"other kind": {
+			ref:    gatewayapi.ParametersReference{Group: "example.com", Kind: "Config", Name: "gateways"},
+			expErr: `parametersRef of GatewayClass "consul" must reference a GatewayClassConfig`,
+		},
+		"missing config": {
+			ref:    gatewayapi.ParametersReference{Group: v1alpha1.GroupVersion.Group, Kind: v1alpha1.GatewayClassConfigKind, Name: "missing"},
+			expErr: `GatewayClassConfig "missing" of GatewayClass "consul" doesn't exist`,
+		},
+		"invalid config": {
+			ref:    gatewayapi.ParametersReference{Group: v1alpha1.GroupVersion.Group, Kind: v1alpha1.GatewayClassConfigKind, Name: "gateways"},
+			expErr: `GatewayClassConfig "gateways": spec.deployment.replicas must be positive, got -1`,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			kubeClient := fake.NewSimpleClientset()
+			config := &v1alpha1.GatewayClassConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "gateways"},
+				Spec:       v1alpha1.GatewayClassConfigSpec{Deployment: v1alpha1.GatewayDeploymentSpec{Replicas: &replicas}},
+			}
+			class := gatewayClass("consul", GatewayControllerName)
+			class.Spec.ParametersRef = &c.ref
+			obj := toUnstructured(t, gateway("gw"))
+			client := newFakeDynamicClient(toUnstructured(t, class), toUnstructured(t, config), obj)
+			controller := gatewayController(client, kubeClient, consulClient)
+
+			require.NoError(t, controller.Upsert("default/gw", obj))
+			require.Nil(t, consul.entry("", v1alpha1.APIGatewayKind, "gw-default"))
+			condition := gatewayStatus(t, client, "default", "gw").Conditions.Get(conditionAccepted)
+			require.Equal(t, "False", condition.Status)
+			require.Equal(t, reasonInvalidParameters, condition.Reason)
+			require.Equal(t, c.expErr, condition.Message)
+		})
+	}
+}
+
+func TestGatewayController_UpsertInvalid(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		listener  gatewayapi.Listener
+		expReason string
+		expErr    string
+	}{
+		"unsupported protocol": {
+			listener:  gatewayapi.Listener{Name: "udp", Port: 53, Protocol: "UDP"},
+			expReason: reasonUnsupportedProtocol,
+			expErr:    `spec.listeners[1].protocol "UDP" isn't supported`,
+		},
+		"https without certificates": {
+			listener:  gatewayapi.Listener{Name: "https", Port: 443, Protocol: gatewayapi.HTTPSProtocol},
+			expReason: reasonInvalidCertRef,
+			expErr:    "spec.listeners[1].tls.certificateRefs must be set for HTTPS listeners",
+		},
+		"missing secret": {
+			listener: gatewayapi.Listener{
+				Name:     "https",
+				Port:     443,
+				Protocol: gatewayapi.HTTPSProtocol,
+				TLS:      &gatewayapi.GatewayTLSConfig{CertificateRefs: []gatewayapi.SecretObjectReference{{Name: "missing"}}},
+			},
+			expReason: reasonInvalidCertRef,
+			expErr:    `spec.listeners[1].tls.certificateRefs[0]: reading secret "missing"`,
+		},
+		"secret in another namespace": {
+			listener: gatewayapi.Listener{
+				Name:     "https",
+				Port:     443,
+				Protocol: gatewayapi.HTTPSProtocol,
+				TLS:      &gatewayapi.GatewayTLSConfig{CertificateRefs: []gatewayapi.SecretObjectReference{{Name: "cert", Namespace: "other"}}},
+			},
+			expReason: reasonInvalidCertRef,
+			expErr:    `secret "cert" must be in the namespace of the gateway`,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			kubeClient := fake.NewSimpleClientset()
+			gw := gateway("gw")
+			gw.Spec.Listeners = append(gw.Spec.Listeners, c.listener)
+			obj := toUnstructured(t, gw)
+			client := newFakeDynamicClient(toUnstructured(t, gatewayClass("consul", GatewayControllerName)), obj)
+			controller := gatewayController(client, kubeClient, consulClient)
+
+			require.NoError(t, controller.Upsert("default/gw", obj))
+			require.Nil(t, consul.entry("", v1alpha1.APIGatewayKind, "gw-default"))
+			_, err := kubeClient.AppsV1().Deployments("default").Get("gw", metav1.GetOptions{})
+			require.True(t, apierrors.IsNotFound(err))
+			condition := gatewayStatus(t, client, "default", "gw").Conditions.Get(conditionAccepted)
+			require.Equal(t, "False", condition.Status)
+			require.Equal(t, c.expReason, condition.Reason)
+			require.Contains(t, condition.Message, c.expErr)
+		})
+	}
+}
+
+func TestGatewayController_UpsertOtherClass(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	kubeClient := fake.NewSimpleClientset()
+	obj := toUnstructured(t, gateway("gw"))
+	client := newFakeDynamicClient(toUnstructured(t, gatewayClass("consul", "example.com/other")), obj)
+	controller := gatewayController(client, kubeClient, consulClient)
+
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	require.Nil(t, consul.entry("", v1alpha1.APIGatewayKind, "gw-default"))
+	obj, err := client.Resource(gatewayResource).Namespace("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.False(t, hasFinalizer(obj))
+	require.Empty(t, gatewayStatus(t, client, "default", "gw").Conditions)
+}
+
+func TestGatewayController_Finalize(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		// deleted deletes the gateway, otherwise its class is changed to
+		// another controller.
+		deleted bool
+	}{
+		"deleted":       {deleted: true},
+		"class changed": {deleted: false},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			kubeClient := fake.NewSimpleClientset()
+			obj := toUnstructured(t, gateway("gw"))
+			client := newFakeDynamicClient(
+				toUnstructured(t, gatewayClass("consul", GatewayControllerName)),
+				toUnstructured(t, gatewayClass("other", "example.com/other")),
+				obj)
+			controller := gatewayController(client, kubeClient, consulClient)
+			require.NoError(t, controller.Upsert("default/gw", obj))
+			require.NotNil(t, consul.entry("", v1alpha1.APIGatewayKind, "gw-default"))
+
+			obj, err := client.Resource(gatewayResource).Namespace("default").Get("gw", metav1.GetOptions{})
+			require.NoError(t, err)
+			require.True(t, hasFinalizer(obj))
+			if c.deleted {
+				now := metav1.Now()
+				obj.SetDeletionTimestamp(&now)
+			} else {
+				obj.Object["spec"].(map[string]interface{})["gatewayClassName"] = "other"
+			}
+			require.NoError(t, controller.Upsert("default/gw", obj))
+			require.Nil(t, consul.entry("", v1alpha1.APIGatewayKind, "gw-default"))
+			obj, err = client.Resource(gatewayResource).Namespace("default").Get("gw", metav1.GetOptions{})
+			require.NoError(t, err)
+			require.False(t, hasFinalizer(obj))
+
+			// The deployment of deleted gateways is garbage collected.
+			_, err = kubeClient.AppsV1().Deployments("default").Get("gw", metav1.GetOptions{})
+			require.Equal(t, !c.deleted, apierrors.IsNotFound(err), "%v", err)
+		})
+	}
+}
+
+func TestGatewayController_UpsertUnchanged(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	kubeClient := fake.NewSimpleClientset()
+	obj := toUnstructured(t, gateway("gw"))
+	client := newFakeDynamicClient(toUnstructured(t, gatewayClass("consul", GatewayControllerName)), obj)
+	controller := gatewayController(client, kubeClient, consulClient)
+	require.NoError(t, controller.Upsert("default/gw", obj))
+
+	// The deployment is only updated when the gateway changes.
+	kubeClient.ClearActions()
+	obj, err := client.Resource(gatewayResource).Namespace("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	for _, action := range kubeClient.Actions() {
+		require.Equal(t, "get", action.GetVerb(), "%v", action)
+	}
+
+	// Changing the listeners only updates the service, the pods of the
+	// deployment aren't restarted.
+	kubeClient.ClearActions()
+	spec := obj.Object["spec"].(map[string]interface{})
+	spec["listeners"] = append(spec["listeners"].([]interface{}), map[string]interface{}{
+		"name": "http-alt", "port": int64(8080), "protocol": "HTTP",
+	})
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	for _, action := range kubeClient.Actions() {
+		if action.GetVerb() != "get" {
+			require.Equal(t, "services", action.GetResource().Resource, "%v", action)
+		}
+	}
+	service, err := kubeClient.CoreV1().Services("default").Get("gw", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, service.Spec.Ports, 2)
+	require.Equal(t, int32(8080), service.Spec.Ports[1].Port)
+	entry := consul.entry("", v1alpha1.APIGatewayKind, "gw-default")
+	require.Len(t, entry["Listeners"], 2)
+}
+
+func TestGatewayController_UpsertRateLimits(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	route := httpRoute("api", gatewayapi.HTTPRouteRule{
+		Matches: []gatewayapi.HTTPRouteMatch{
+			{Path: &gatewayapi.HTTPPathMatch{Type: gatewayapi.PathMatchExact, Value: "/login"}},
+			{Path: &gatewayapi.HTTPPathMatch{Value: "/api"}},
+		},
+		BackendRefs: []gatewayapi.BackendRef{{Name: "api"}},
+	})
+	unattached := httpRoute("unattached", gatewayapi.HTTPRouteRule{})
+	unattached.Spec.ParentRefs = []gatewayapi.ParentReference{{Name: "other"}}
+	gatewayPolicy := rateLimitPolicy("gw-limit", "Gateway", "gw", 100, "")
+	gatewayPolicy.Spec.Burst = 200
+	// Newer policies of a target are ignored.
+	newerPolicy := rateLimitPolicy("gw-newer", "Gateway", "gw", 1, "")
+	newerPolicy.CreationTimestamp = metav1.Unix(2, 0)
+	invalidPolicy := rateLimitPolicy("invalid", "Gateway", "gw", 100, "Day")
+	obj := toUnstructured(t, gateway("gw"))
+	client := newFakeDynamicClient(
+		toUnstructured(t, gatewayClass("consul", GatewayControllerName)),
+		obj,
+		toUnstructured(t, route),
+		toUnstructured(t, unattached),
+		toUnstructured(t, gatewayPolicy),
+		toUnstructured(t, newerPolicy),
+		toUnstructured(t, invalidPolicy),
+		toUnstructured(t, rateLimitPolicy("api-limit", gatewayapi.HTTPRouteKind, "api", 600, "Minute")),
+		toUnstructured(t, rateLimitPolicy("unattached-limit", gatewayapi.HTTPRouteKind, "unattached", 5, "")),
+		toUnstructured(t, rateLimitPolicy("missing-limit", gatewayapi.HTTPRouteKind, "missing", 5, "")))
+	recorder := record.NewFakeRecorder(10)
+	controller := gatewayController(client, fake.NewSimpleClientset(), consulClient)
+	controller.EventRecorder = recorder
+
+	// The gateway only limits the routes it accepted.
+	routes := routeController(client, consulClient, gatewayapi.HTTPRouteKind)
+	require.NoError(t, routes.Upsert("default/api", toUnstructured(t, route)))
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	entry := consul.entry("", api.ServiceDefaults, "gw-default")
+	require.NotNil(t, entry)
+	require.Equal(t, "kubernetes", entry["Meta"].(map[string]interface{})["external-source"])
+	require.Equal(t, map[string]interface{}{"InstanceLevel": map[string]interface{}{
+		"RequestsPerSecond": float64(100),
+		"RequestsMaxBurst":  float64(200),
+		"Routes": []interface{}{
+			map[string]interface{}{"PathExact": "/login", "RequestsPerSecond": float64(10)},
+			map[string]interface{}{"PathPrefix": "/api", "RequestsPerSecond": float64(10)},
+		},
+	}}, entry["RateLimits"])
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	require.Equal(t, []string{
+		`Warning InvalidPolicy RateLimitPolicy default/invalid: spec.unit must be one of Second, Minute or Hour, got "Day"`,
+		`Warning InvalidPolicy RateLimitPolicy default/gw-newer is ignored since an older policy is attached to Gateway default/gw`,
+	}, events)
+
+	// The entry is deleted once no policy is attached to the gateway.
+	for _, name := range []string{"gw-limit", "gw-newer", "invalid", "api-limit"} {
+		require.NoError(t, client.Resource(rateLimitPolicyResource).Namespace("default").Delete(name, nil))
+	}
+	require.NoError(t, controller.Upsert("default/gw", obj))
+	require.Nil(t, consul.entry("", api.ServiceDefaults, "gw-default"))
+}
+
+func rateLimitPolicy(name, kind, target string, requestsPerUnit int32, unit string) *v1alpha1.RateLimitPolicy {
+	return &v1alpha1.RateLimitPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: metav1.Unix(1, 0)},
+		Spec: v1alpha1.RateLimitPolicySpec{
+			TargetRef:       v1alpha1.PolicyTargetReference{Group: gatewayapi.Group, Kind: kind, Name: target},
+			RequestsPerUnit: requestsPerUnit,
+			Unit:            unit,
+		},
+	}
+}
+
+func gatewayController(client *fakeDynamicClient, kubeClient kubernetes.Interface, consulClient *api.Client) *GatewayController {
+	return &GatewayController{
+		Log:          hclog.NewNullLogger(),
+		Client:       client,
+		KubeClient:   kubeClient,
+		ConsulClient: consulClient,
+		ImageConsul:  "consul:latest",
+		ImageEnvoy:   "envoy:latest",
+	}
+}
+
+func gatewayClass(name, controllerName string) *gatewayapi.GatewayClass {
+	return &gatewayapi.GatewayClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       gatewayapi.GatewayClassSpec{ControllerName: controllerName},
+	}
+}
+
+func gateway(name string) *gatewayapi.Gateway {
+	return &gatewayapi.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Generation: 1},
+		Spec: gatewayapi.GatewaySpec{
+			GatewayClassName: "consul",
+			Listeners:        []gatewayapi.Listener{{Name: "http", Port: 80, Protocol: gatewayapi.HTTPProtocol}},
+		},
+	}
+}
+
+func gatewayStatus(t *testing.T, client *fakeDynamicClient, namespace, name string) gatewayapi.GatewayStatus {
+	obj, err := client.Resource(gatewayResource).Namespace(namespace).Get(name, metav1.GetOptions{})
+	require.NoError(t, err)
+	gw := &gatewayapi.Gateway{}
+	require.NoError(t, decode(obj, gw))
+	return gw.Status
+}
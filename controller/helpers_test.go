@@ -0,0 +1,419 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// fakeConsul fakes Consul's config entry, namespace, catalog, health,
+// agent service deregistration and peering endpoints.
+type fakeConsul struct {
+	lock sync.Mutex
+	// entries are the config entries keyed by namespace/kind/name. The
+	// namespaces of partitions other than the agent's are prefixed with
+	// "<partition>:", as are the keys of namespaces and services.
+	entries    map[string]map[string]interface{}
+	namespaces map[string]bool
+	// namespaceDefs are the namespaces as they were last written, keyed
+	// like namespaces.
+	namespaceDefs map[string]api.Namespace
+	// services are the registered services keyed by namespace/name.
+	services map[string]bool
+	// nodes are the nodes registered with the catalog register endpoint,
+	// keyed by name.
+	nodes map[string]*fakeNode
+	// writes is the number of config entry writes.
+	writes int
+	// peerings are the peerings keyed by peer name.
+	peerings map[string]*fakePeering
+	// tokens is the number of generated peering tokens.
+	tokens int
+
+	// config is the config of the client returned by newFakeConsul.
+	config *api.Config
+}
+
+// fakePeering is a peering of the fake Consul server.
+type fakePeering struct {
+	Name  string
+	State string
+	// Token is the token the peering was generated or established with.
+	Token string `json:"-"`
+	// ServerExternalAddresses are the addresses the token was generated
+	// with.
+	ServerExternalAddresses []string `json:"-"`
+}
+
+// fakeNode is a node of the fake Consul catalog.
+type fakeNode struct {
+	Meta map[string]string
+	// Registrations are the registrations of the services of the node
+	// keyed by service ID.
+	Registrations map[string]*api.CatalogRegistration
+}
+
+// newFakeConsul starts a fake Consul server. The returned function stops it.
+func newFakeConsul(t *testing.T) (*fakeConsul, *api.Client, func()) {
+	consul := &fakeConsul{
+		entries:       make(map[string]map[string]interface{}),
+		namespaces:    make(map[string]bool),
+		namespaceDefs: make(map[string]api.Namespace),
+		services:      make(map[string]bool),
+		nodes:         make(map[string]*fakeNode),
+		peerings:      make(map[string]*fakePeering),
+	}
+	server := httptest.NewServer(consul)
+	consul.config = &api.Config{Address: server.URL}
+	client, err := api.NewClient(consul.config)
+	require.NoError(t, err)
+	return consul, client, server.Close
+}
+
+// entry returns the config entry or nil if it doesn't exist.
+func (f *fakeConsul) entry(namespace, kind, name string) map[string]interface{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.entries[namespace+"/"+kind+"/"+name]
+}
+
+// namespace returns the namespace as it was last written or nil if it
+// doesn't exist.
+func (f *fakeConsul) namespace(name string) *api.Namespace {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	namespace, ok := f.namespaceDefs[name]
+	if !ok {
+		return nil
+	}
+	return &namespace
+}
+
+// createNamespace creates the namespace as if it was written.
+func (f *fakeConsul) createNamespace(namespace api.Namespace) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.namespaces[namespace.Name] = true
+	f.namespaceDefs[namespace.Name] = namespace
+}
+
+// registration returns the registration of the service on the node or nil
+// if it isn't registered.
+func (f *fakeConsul) registration(node, serviceID string) *api.CatalogRegistration {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n, ok := f.nodes[node]
+	if !ok {
+		return nil
+	}
+	return n.Registrations[serviceID]
+}
+
+// hasNode returns true if the node is registered.
+func (f *fakeConsul) hasNode(node string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, ok := f.nodes[node]
+	return ok
+}
+
+// peering returns a copy of the peering or nil if it doesn't exist.
+func (f *fakeConsul) peering(name string) *fakePeering {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	p, ok := f.peerings[name]
+	if !ok {
+		return nil
+	}
+	peering := *p
+	return &peering
+}
+
+// registerService registers the service in the catalog.
+func (f *fakeConsul) registerService(namespace, name string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.services[namespace+"/"+name] = true
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ns := r.URL.Query().Get("ns")
+	partition := r.URL.Query().Get("partition")
+	if partition != "" {
+		ns = partition + ":" + ns
+	}
+
+	switch {
+	case r.URL.Path == "/v1/config" && r.Method == http.MethodPut:
+		var entry map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.entries[fmt.Sprintf("%s/%s/%s", ns, entry["Kind"], entry["Name"])] = entry
+		f.writes++
+		w.Write([]byte("true"))
+
+	case strings.HasPrefix(r.URL.Path, "/v1/config/") && strings.Count(r.URL.Path, "/") == 3:
+		prefix := ns + "/" + strings.TrimPrefix(r.URL.Path, "/v1/config/") + "/"
+		entries := []map[string]interface{}{}
+		for key, entry := range f.entries {
+			if strings.HasPrefix(key, prefix) {
+				entries = append(entries, entry)
+			}
+		}
+		json.NewEncoder(w).Encode(entries)
+
+	case strings.HasPrefix(r.URL.Path, "/v1/config/"):
+		key := ns + "/" + strings.TrimPrefix(r.URL.Path, "/v1/config/")
+		entry, ok := f.entries[key]
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(f.entries, key)
+		case !ok:
+			http.Error(w, "Config entry not found", http.StatusNotFound)
+		default:
+			json.NewEncoder(w).Encode(entry)
+		}
+
+	case strings.HasPrefix(r.URL.Path, "/v1/namespace"):
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/v1/namespace"), "/")
+		if r.Method == http.MethodPut {
+			var namespace api.Namespace
+			json.NewDecoder(r.Body).Decode(&namespace)
+			name = namespace.Name
+			if partition != "" {
+				name = partition + ":" + name
+			}
+			f.namespaces[name] = true
+			f.namespaceDefs[name] = namespace
+			json.NewEncoder(w).Encode(namespace)
+			return
+		}
+		if partition != "" {
+			name = partition + ":" + name
+		}
+		if !f.namespaces[name] {
+			http.Error(w, "Namespace not found", http.StatusNotFound)
+			return
+		}
+		if namespace, ok := f.namespaceDefs[name]; ok {
+			json.NewEncoder(w).Encode(namespace)
+			return
+		}
+		json.NewEncoder(w).Encode(api.Namespace{Name: name})
+
+	case strings.HasPrefix(r.URL.Path, "/v1/catalog/service/"):
+		name := strings.TrimPrefix(r.URL.Path, "/v1/catalog/service/")
+		services := []*api.CatalogService{}
+		if f.services[ns+"/"+name] {
+			services = append(services, &api.CatalogService{ServiceName: name, Namespace: ns})
+		}
+		for nodeName, node := range f.nodes {
+			for id, reg := range node.Registrations {
+				if reg.Service.Service == name && reg.Service.Namespace == ns {
+					services = append(services, &api.CatalogService{
+						Node:        nodeName,
+						Address:     reg.Address,
+						ServiceID:   id,
+						ServiceName: name,
+						ServiceMeta: reg.Service.Meta,
+						Namespace:   ns,
+					})
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(services)
+
+	case strings.HasPrefix(r.URL.Path, "/v1/health/service/"):
+		// The services are always healthy.
+		name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+		entries := []*api.ServiceEntry{}
+		if f.services[ns+"/"+name] {
+			entries = append(entries, &api.ServiceEntry{Service: &api.AgentService{Service: name, Namespace: ns}})
+		}
+		json.NewEncoder(w).Encode(entries)
+
+	case r.URL.Path == "/v1/catalog/register" && r.Method == http.MethodPut:
+		var reg api.CatalogRegistration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		node, ok := f.nodes[reg.Node]
+		if !ok {
+			node = &fakeNode{Registrations: make(map[string]*api.CatalogRegistration)}
+			f.nodes[reg.Node] = node
+		}
+		node.Meta = reg.NodeMeta
+		if reg.Service != nil {
+			node.Registrations[reg.Service.ID] = &reg
+		}
+		w.Write([]byte("true"))
+
+	case r.URL.Path == "/v1/catalog/deregister" && r.Method == http.MethodPut:
+		var dereg api.CatalogDeregistration
+		if err := json.NewDecoder(r.Body).Decode(&dereg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if node, ok := f.nodes[dereg.Node]; ok && dereg.ServiceID != "" {
+			delete(node.Registrations, dereg.ServiceID)
+		} else {
+			delete(f.nodes, dereg.Node)
+		}
+		w.Write([]byte("true"))
+
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/") && r.Method == http.MethodPut:
+		// The server is the agent of all the nodes.
+		id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+		for _, node := range f.nodes {
+			delete(node.Registrations, id)
+		}
+
+	case strings.HasPrefix(r.URL.Path, "/v1/catalog/node/"):
+		name := strings.TrimPrefix(r.URL.Path, "/v1/catalog/node/")
+		node, ok := f.nodes[name]
+		if !ok {
+			w.Write([]byte("null"))
+			return
+		}
+		catalogNode := api.CatalogNode{
+			Node:     &api.Node{Node: name, Meta: node.Meta},
+			Services: make(map[string]*api.AgentService),
+		}
+		for id, reg := range node.Registrations {
+			catalogNode.Services[id] = reg.Service
+		}
+		json.NewEncoder(w).Encode(catalogNode)
+
+	case r.URL.Path == "/v1/peering/token" && r.Method == http.MethodPost:
+		var req struct {
+			PeerName                string
+			ServerExternalAddresses []string
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.tokens++
+		token := fmt.Sprintf("%s-token-%d", req.PeerName, f.tokens)
+		f.peerings[req.PeerName] = &fakePeering{Name: req.PeerName, State: "PENDING", Token: token,
+			ServerExternalAddresses: req.ServerExternalAddresses}
+		json.NewEncoder(w).Encode(map[string]string{"PeeringToken": token})
+
+	case r.URL.Path == "/v1/peering/establish" && r.Method == http.MethodPost:
+		var req struct{ PeerName, PeeringToken string }
+		json.NewDecoder(r.Body).Decode(&req)
+		f.peerings[req.PeerName] = &fakePeering{Name: req.PeerName, State: "ESTABLISHING", Token: req.PeeringToken}
+		w.Write([]byte("{}"))
+
+	case strings.HasPrefix(r.URL.Path, "/v1/peering/") && r.Method == http.MethodGet:
+		p, ok := f.peerings[strings.TrimPrefix(r.URL.Path, "/v1/peering/")]
+		if !ok {
+			http.Error(w, "Peering not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(p)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// fakeDynamicClient is a dynamic.Interface that stores the resources in
+// memory. It only supports the methods used by the controllers.
+type fakeDynamicClient struct {
+	lock    sync.Mutex
+	objects map[string]*unstructured.Unstructured
+}
+
+func newFakeDynamicClient(objects ...*unstructured.Unstructured) *fakeDynamicClient {
+	client := &fakeDynamicClient{objects: make(map[string]*unstructured.Unstructured)}
+	for _, obj := range objects {
+		client.objects[obj.GetNamespace()+"/"+obj.GetName()] = obj.DeepCopy()
+	}
+	return client
+}
+
+func (f *fakeDynamicClient) Resource(schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &fakeResourceClient{client: f}
+}
+
+type fakeResourceClient struct {
+	client    *fakeDynamicClient
+	namespace string
+}
+
+func (f *fakeResourceClient) Namespace(ns string) dynamic.ResourceInterface {
+	return &fakeResourceClient{client: f.client, namespace: ns}
+}
+
+func (f *fakeResourceClient) Create(obj *unstructured.Unstructured, _ metav1.CreateOptions, _ ...string) (*unstructured.Unstructured, error) {
+	return f.Update(obj, metav1.UpdateOptions{})
+}
+
+func (f *fakeResourceClient) Update(obj *unstructured.Unstructured, _ metav1.UpdateOptions, _ ...string) (*unstructured.Unstructured, error) {
+	f.client.lock.Lock()
+	defer f.client.lock.Unlock()
+	f.client.objects[f.namespace+"/"+obj.GetName()] = obj.DeepCopy()
+	return obj.DeepCopy(), nil
+}
+
+func (f *fakeResourceClient) UpdateStatus(obj *unstructured.Unstructured, _ metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	return f.Update(obj, metav1.UpdateOptions{})
+}
+
+func (f *fakeResourceClient) Delete(name string, _ *metav1.DeleteOptions, _ ...string) error {
+	f.client.lock.Lock()
+	defer f.client.lock.Unlock()
+	delete(f.client.objects, f.namespace+"/"+name)
+	return nil
+}
+
+func (f *fakeResourceClient) DeleteCollection(*metav1.DeleteOptions, metav1.ListOptions) error {
+	return fmt.Errorf("not supported")
+}
+
+func (f *fakeResourceClient) Get(name string, _ metav1.GetOptions, _ ...string) (*unstructured.Unstructured, error) {
+	f.client.lock.Lock()
+	defer f.client.lock.Unlock()
+	obj, ok := f.client.objects[f.namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{}, name)
+	}
+	return obj.DeepCopy(), nil
+}
+
+func (f *fakeResourceClient) List(metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	f.client.lock.Lock()
+	defer f.client.lock.Unlock()
+	list := &unstructured.UnstructuredList{}
+	for _, obj := range f.client.objects {
+		if f.namespace == "" || obj.GetNamespace() == f.namespace {
+			list.Items = append(list.Items, *obj.DeepCopy())
+		}
+	}
+	return list, nil
+}
+
+func (f *fakeResourceClient) Watch(metav1.ListOptions) (watch.Interface, error) {
+	return watch.NewFake(), nil
+}
+
+func (f *fakeResourceClient) Patch(string, types.PatchType, []byte, metav1.PatchOptions, ...string) (*unstructured.Unstructured, error) {
+	return nil, fmt.Errorf("not supported")
+}
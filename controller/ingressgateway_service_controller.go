@@ -0,0 +1,199 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ingressGatewayServiceTypeKey is the annotation of the IngressGateways
+// setting the type of their service.
+const ingressGatewayServiceTypeKey = "consul.hashicorp.com/ingress-gateway-service-type"
+
+var ingressGatewayResource = v1alpha1.GroupVersion.WithResource(v1alpha1.IngressGatewayResource)
+
+// IngressGatewayServiceController implements controller.Resource to
+// expose the ingress gateways of the IngressGateway resources. Each
+// gateway gets a Kubernetes service named after the resource in its
+// namespace, with a port for each listener, selecting the pods of the
+// ingress gateway of the same name. The ports are updated whenever the
+// listeners change, and the service is garbage collected with the
+// resource.
+//
+// The config entries of the resources are written by ConfigEntryController,
+// this controller only manages their services.
+type IngressGatewayServiceController struct {
+	Log        hclog.Logger
+	Client     dynamic.Interface
+	KubeClient kubernetes.Interface
+
+	// Namespace is the Kubernetes namespace to watch. If it's empty,
+	// all namespaces are watched.
+	Namespace string
+
+	// ServiceType is the type of the services unless the resource has
+	// the consul.hashicorp.com/ingress-gateway-service-type annotation.
+	// Defaults to LoadBalancer.
+	ServiceType corev1.ServiceType
+
+	// ResyncPeriod is how often all services are synced again, e.g. to
+	// revert changes made to them.
+	ResyncPeriod time.Duration
+}
+
+// Informer implements the controller.Resource interface.
+func (c *IngressGatewayServiceController) Informer() cache.SharedIndexInformer {
+	return newInformer(c.Client, ingressGatewayResource, c.Namespace, c.ResyncPeriod)
+}
+
+// Upsert implements the controller.Resource interface. It creates or
+// updates the service of the gateway, or deletes it if the gateway has no
+// listeners.
+func (c *IngressGatewayServiceController) Upsert(key string, raw interface{}) error {
+	obj, ok := raw.(*unstructured.Unstructured)
+	if !ok {
+		c.Log.Warn("upsert got invalid type", "key", key, "type", fmt.Sprintf("%T", raw))
+		return nil
+	}
+	gateway := &v1alpha1.IngressGateway{}
+	if err := decode(obj, gateway); err != nil {
+		c.Log.Error("error decoding resource", "key", key, "err", err)
+		return nil
+	}
+	if obj.GetDeletionTimestamp() != nil {
+		return nil
+	}
+	gateway.Default()
+
+	services := c.KubeClient.CoreV1().Services(gateway.Namespace)
+	existing, err := services.Get(gateway.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("reading service %q: %s", gateway.Name, err)
+	}
+	found := err == nil
+	if found && !ownedBy(existing.OwnerReferences, gateway.UID) {
+		c.Log.Warn("not managing service of another owner", "key", key, "service", gateway.Name)
+		return nil
+	}
+
+	// Kubernetes doesn't accept services without ports.
+	if len(gateway.Spec.Listeners) == 0 {
+		if !found {
+			return nil
+		}
+		if err := services.Delete(gateway.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("deleting service %q: %s", gateway.Name, err)
+		}
+		c.Log.Info("deleted service of gateway without listeners", "key", key)
+		return nil
+	}
+
+	service := c.service(gateway)
+	switch {
+	case !found:
+		if _, err := services.Create(service); err != nil {
+			return fmt.Errorf("creating service %q: %s", service.Name, err)
+		}
+		c.Log.Info("created service", "key", key)
+	case existing.Annotations[gatewayConfigHashKey] != service.Annotations[gatewayConfigHashKey]:
+		// The cluster IP and node ports are allocated by Kubernetes and
+		// can't be changed.
+		service.ResourceVersion = existing.ResourceVersion
+		service.Spec.ClusterIP = existing.Spec.ClusterIP
+		for i := range service.Spec.Ports {
+			for _, port := range existing.Spec.Ports {
+				if port.Name == service.Spec.Ports[i].Name {
+					service.Spec.Ports[i].NodePort = port.NodePort
+				}
+			}
+		}
+		if _, err := services.Update(service); err != nil {
+			return fmt.Errorf("updating service %q: %s", service.Name, err)
+		}
+		c.Log.Info("updated service", "key", key)
+	}
+	return nil
+}
+
+// Delete implements the controller.Resource interface. The services are
+// garbage collected by Kubernetes through their owner reference.
+func (c *IngressGatewayServiceController) Delete(key string) error {
+	c.Log.Debug("resource deleted", "key", key)
+	return nil
+}
+
+// service returns the service exposing the listeners of the gateway. Its
+// ports are named <protocol>-<port>.
+func (c *IngressGatewayServiceController) service(gateway *v1alpha1.IngressGateway) *corev1.Service {
+	serviceType := corev1.ServiceType(gateway.Annotations[ingressGatewayServiceTypeKey])
+	if serviceType == "" {
+		serviceType = c.ServiceType
+	}
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeLoadBalancer
+	}
+	controller := true
+	labels := ingressGatewayLabels(gateway.Name)
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      gateway.Name,
+			Namespace: gateway.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: v1alpha1.GroupVersion.String(),
+				Kind:       "IngressGateway",
+				Name:       gateway.Name,
+				UID:        gateway.UID,
+				Controller: &controller,
+			}},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     serviceType,
+			Selector: labels,
+		},
+	}
+	for _, listener := range gateway.Spec.Listeners {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       fmt.Sprintf("%s-%d", listener.Protocol, listener.Port),
+			Port:       int32(listener.Port),
+			TargetPort: intstr.FromInt(listener.Port),
+			Protocol:   corev1.ProtocolTCP,
+		})
+	}
+	// The spec is encoded without the allocated fields, so the hash can't
+	// fail.
+	setConfigHash(&service.ObjectMeta, service.Spec)
+	return service
+}
+
+// ingressGatewayLabels are the labels of the pods of the ingress gateway
+// of the Helm chart with the name.
+func ingressGatewayLabels(name string) map[string]string {
+	return map[string]string{
+		"app":                  "consul",
+		"component":            "ingress-gateway",
+		"ingress-gateway-name": name,
+	}
+}
+
+// ownedBy returns true if one of the owner references is the object with
+// the UID.
+func ownedBy(refs []metav1.OwnerReference, uid types.UID) bool {
+	for _, ref := range refs {
+		if ref.UID == uid {
+			return true
+		}
+	}
+	return false
+}
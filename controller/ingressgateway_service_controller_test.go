@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIngressGatewayServiceController_Upsert(t *testing.T) {
+	t.Parallel()
+	kubeClient := fake.NewSimpleClientset()
+	gateway := ingressGateway(v1alpha1.IngressListenerSpec{Port: 8080, Protocol: "http"}, v1alpha1.IngressListenerSpec{Port: 9000})
+	controller := &IngressGatewayServiceController{
+		Log:        hclog.NewNullLogger(),
+		KubeClient: kubeClient,
+	}
+
+	require.NoError(t, controller.Upsert("default/ingress", toUnstructured(t, gateway)))
+	service, err := kubeClient.CoreV1().Services("default").Get("ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, corev1.ServiceTypeLoadBalancer, service.Spec.Type)
+	require.Equal(t, ingressGatewayLabels("ingress"), service.Spec.Selector)
+	require.Equal(t, []corev1.ServicePort{
+		{Name: "http-8080", Port: 8080, TargetPort: intstr.FromInt(8080), Protocol: corev1.ProtocolTCP},
+		{Name: "tcp-9000", Port: 9000, TargetPort: intstr.FromInt(9000), Protocol: corev1.ProtocolTCP},
+	}, service.Spec.Ports)
+	require.Equal(t, gateway.UID, service.OwnerReferences[0].UID)
+
+	// The node ports allocated by Kubernetes are kept when the listeners
+	// change.
+	service.Spec.ClusterIP = "10.0.0.1"
+	service.Spec.Ports[0].NodePort = 30080
+	_, err = kubeClient.CoreV1().Services("default").Update(service)
+	require.NoError(t, err)
+	gateway.Spec.Listeners = append(gateway.Spec.Listeners[:1], v1alpha1.IngressListenerSpec{Port: 9001, Protocol: "grpc"})
+	require.NoError(t, controller.Upsert("default/ingress", toUnstructured(t, gateway)))
+	service, err = kubeClient.CoreV1().Services("default").Get("ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", service.Spec.ClusterIP)
+	require.Equal(t, []corev1.ServicePort{
+		{Name: "http-8080", Port: 8080, TargetPort: intstr.FromInt(8080), Protocol: corev1.ProtocolTCP, NodePort: 30080},
+		{Name: "grpc-9001", Port: 9001, TargetPort: intstr.FromInt(9001), Protocol: corev1.ProtocolTCP},
+	}, service.Spec.Ports)
+
+	// The service is deleted once the gateway has no listeners.
+	gateway.Spec.Listeners = nil
+	require.NoError(t, controller.Upsert("default/ingress", toUnstructured(t, gateway)))
+	_, err = kubeClient.CoreV1().Services("default").Get("ingress", metav1.GetOptions{})
+	require.True(t, apierrors.IsNotFound(err))
+}
+
+func TestIngressGatewayServiceController_UpsertServiceType(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		serviceType    corev1.ServiceType
+		annotation     string
+		expServiceType corev1.ServiceType
+	}{
+		"default": {
+			expServiceType: corev1.ServiceTypeLoadBalancer,
+		},
+		"controller type": {
+			serviceType:    corev1.ServiceTypeNodePort,
+			expServiceType: corev1.ServiceTypeNodePort,
+		},
+		"annotation": {
+			serviceType:    corev1.ServiceTypeNodePort,
+			annotation:     "ClusterIP",
+			expServiceType: corev1.ServiceTypeClusterIP,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			kubeClient := fake.NewSimpleClientset()
+			gateway := ingressGateway(v1alpha1.IngressListenerSpec{Port: 8080})
+			if c.annotation != "" {
+				gateway.Annotations = map[string]string{ingressGatewayServiceTypeKey: c.annotation}
+			}
+			controller := &IngressGatewayServiceController{
+				Log:         hclog.NewNullLogger(),
+				KubeClient:  kubeClient,
+				ServiceType: c.serviceType,
+			}
+			require.NoError(t, controller.Upsert("default/ingress", toUnstructured(t, gateway)))
+			service, err := kubeClient.CoreV1().Services("default").Get("ingress", metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, c.expServiceType, service.Spec.Type)
+		})
+	}
+}
+
+// Test that services the controller didn't create aren't changed.
+func TestIngressGatewayServiceController_UpsertOtherOwner(t *testing.T) {
+	t.Parallel()
+	existing := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{{Name: "http", Port: 80}},
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(existing)
+	controller := &IngressGatewayServiceController{
+		Log:        hclog.NewNullLogger(),
+		KubeClient: kubeClient,
+	}
+	require.NoError(t, controller.Upsert("default/ingress", toUnstructured(t, ingressGateway())))
+	service, err := kubeClient.CoreV1().Services("default").Get("ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, existing.Spec, service.Spec)
+}
+
+func ingressGateway(listeners ...v1alpha1.IngressListenerSpec) *v1alpha1.IngressGateway {
+	return &v1alpha1.IngressGateway{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "IngressGateway"},
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "default", UID: "ingress-uid"},
+		Spec:       v1alpha1.IngressGatewaySpec{Listeners: listeners},
+	}
+}
@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// MetaKeyPodName and MetaKeyKubeNS are the service meta of the mesh
+	// gateways registered by register-mesh-gateway with -pod-name, with
+	// the name and namespace of their pod.
+	MetaKeyPodName = "pod-name"
+	MetaKeyKubeNS  = "external-k8s-ns"
+
+	// defaultAgentPort is the HTTP port of the client agents if the
+	// address of ConsulConfig has none.
+	defaultAgentPort = "8500"
+)
+
+// MeshGatewayJanitor implements controller.Resource to deregister the
+// instances of the mesh gateway service whose pods disappeared without
+// deregistering them, e.g. because they were killed or their node failed.
+// Each replica registers itself with the service meta of its pod, and the
+// instances whose pod doesn't exist anymore are deregistered when a
+// gateway pod is deleted and every ResyncPeriod, which also cleans up the
+// pods deleted while the controller wasn't running.
+//
+// The instances are registered with the client agent of their node, which
+// would register them again if they were only removed from the catalog,
+// so they're deregistered with that agent, or from the catalog if the
+// agent is unreachable.
+type MeshGatewayJanitor struct {
+	Log          hclog.Logger
+	KubeClient   kubernetes.Interface
+	ConsulClient *api.Client
+	// ConsulConfig is the config of ConsulClient. The agents of the nodes
+	// are reached on the port and with the TLS configuration of its
+	// address.
+	ConsulConfig *api.Config
+
+	// ServiceName is the name of the mesh gateway service.
+	ServiceName string
+	// PodSelector is the label selector of the mesh gateway pods.
+	PodSelector string
+
+	// Namespace is the Kubernetes namespace to watch. If it's empty,
+	// all namespaces are watched.
+	Namespace string
+
+	// ResyncPeriod is how often all the instances are checked.
+	ResyncPeriod time.Duration
+}
+
+// Informer implements the controller.Resource interface. It watches the
+// mesh gateway pods to clean up their instances as soon as they're
+// deleted.
+func (c *MeshGatewayJanitor) Informer() cache.SharedIndexInformer {
+	pods := c.KubeClient.CoreV1().Pods(c.Namespace)
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = c.PodSelector
+				return pods.List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = c.PodSelector
+				return pods.Watch(options)
+			},
+		},
+		&corev1.Pod{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+// Upsert implements the controller.Resource interface. Running pods
+// deregister their instance themselves.
+func (c *MeshGatewayJanitor) Upsert(key string, raw interface{}) error {
+	return nil
+}
+
+// Delete implements the controller.Resource interface. It deregisters the
+// instances whose pods don't exist anymore, including the deleted pod's
+// if it didn't deregister it.
+func (c *MeshGatewayJanitor) Delete(key string) error {
+	c.Log.Debug("pod deleted", "key", key)
+	return c.sweep()
+}
+
+// Run implements the controller.Backgrounder interface. It deregisters the
+// instances whose pods don't exist anymore every ResyncPeriod until stopCh
+// is closed.
+func (c *MeshGatewayJanitor) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(c.ResyncPeriod)
+	defer ticker.Stop()
+	for {
+		if err := c.sweep(); err != nil {
+			c.Log.Error("error cleaning up the mesh gateway instances", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// sweep deregisters the instances of the mesh gateway service registered
+// by the pods of the watched namespaces that don't exist anymore. The
+// instances without the meta of a pod, e.g. the gateways of other
+// clusters or of VMs, are ignored.
+func (c *MeshGatewayJanitor) sweep() error {
+	services, _, err := c.ConsulClient.Catalog().Service(c.ServiceName, "", nil)
+	if err != nil {
+		return fmt.Errorf("listing the instances of service %q: %s", c.ServiceName, err)
+	}
+	for _, service := range services {
+		podName, namespace := service.ServiceMeta[MetaKeyPodName], service.ServiceMeta[MetaKeyKubeNS]
+		if podName == "" || namespace == "" || (c.Namespace != "" && namespace != c.Namespace) {
+			continue
+		}
+		_, err := c.KubeClient.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{})
+		if err == nil {
+			continue
+		}
+		if !apierrors.IsNotFound(err) {
+			return fmt.Errorf("reading pod %q: %s", namespace+"/"+podName, err)
+		}
+		if err := c.deregister(service); err != nil {
+			return err
+		}
+		c.Log.Info("deregistered the instance of a deleted pod", "service-id", service.ServiceID,
+			"node", service.Node, "pod", namespace+"/"+podName)
+	}
+	return nil
+}
+
+// deregister deregisters the instance with the agent of its node, or from
+// the catalog if the agent can't be reached.
+func (c *MeshGatewayJanitor) deregister(service *api.CatalogService) error {
+	// The copy shares the HTTP client, and so the retries and connections,
+	// of the controller's client.
+	config := *c.ConsulConfig
+	config.Address = agentAddress(c.ConsulConfig.Address, service.Address)
+	agent, err := api.NewClient(&config)
+	if err != nil {
+		return fmt.Errorf("creating the client of the agent of node %q: %s", service.Node, err)
+	}
+	err = agent.Agent().ServiceDeregister(service.ServiceID)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(net.Error); !ok {
+		return fmt.Errorf("deregistering service %q with the agent of node %q: %s", service.ServiceID, service.Node, err)
+	}
+	c.Log.Warn("the agent is unreachable, deregistering from the catalog", "node", service.Node, "err", err)
+	_, err = c.ConsulClient.Catalog().Deregister(&api.CatalogDeregistration{
+		Node:      service.Node,
+		ServiceID: service.ServiceID,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("deregistering service %q from the catalog: %s", service.ServiceID, err)
+	}
+	return nil
+}
+
+// agentAddress returns the address of the agent at host, with the scheme
+// and port of address.
+func agentAddress(address, host string) string {
+	scheme := ""
+	if u, err := url.Parse(address); err == nil && u.Host != "" {
+		scheme, address = u.Scheme+"://", u.Host
+	}
+	port := defaultAgentPort
+	if _, p, err := net.SplitHostPort(address); err == nil {
+		port = p
+	}
+	return scheme + net.JoinHostPort(host, port)
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMeshGatewayJanitor_Delete(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		meta map[string]string
+		// address is the address of the node. Nothing listens on
+		// 127.0.0.2, so the instance is deregistered from the catalog.
+		address         string
+		namespace       string
+		expDeregistered bool
+	}{
+		"deleted pod": {
+			meta:            map[string]string{MetaKeyPodName: "mesh-gateway-2", MetaKeyKubeNS: "default"},
+			address:         "127.0.0.1",
+			expDeregistered: true,
+		},
+		"deleted pod with an unreachable agent": {
+			meta:            map[string]string{MetaKeyPodName: "mesh-gateway-2", MetaKeyKubeNS: "default"},
+			address:         "127.0.0.2",
+			expDeregistered: true,
+		},
+		"running pod": {
+			meta:    map[string]string{MetaKeyPodName: "mesh-gateway-1", MetaKeyKubeNS: "default"},
+			address: "127.0.0.1",
+		},
+		"instance without a pod": {
+			address: "127.0.0.1",
+		},
+		"pod of another namespace": {
+			meta:      map[string]string{MetaKeyPodName: "mesh-gateway-2", MetaKeyKubeNS: "other"},
+			address:   "127.0.0.1",
+			namespace: "default",
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			_, err := consulClient.Catalog().Register(&api.CatalogRegistration{
+				Node:    "node-1",
+				Address: c.address,
+				Service: &api.AgentService{ID: "mesh-gateway-2", Service: "mesh-gateway", Meta: c.meta},
+			}, nil)
+			require.NoError(t, err)
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "mesh-gateway-1", Namespace: "default"}}
+			janitor := &MeshGatewayJanitor{
+				Log:          hclog.NewNullLogger(),
+				KubeClient:   fake.NewSimpleClientset(pod),
+				ConsulClient: consulClient,
+				ConsulConfig: consul.config,
+				ServiceName:  "mesh-gateway",
+				Namespace:    c.namespace,
+				ResyncPeriod: time.Minute,
+			}
+
+			require.NoError(t, janitor.Delete("default/mesh-gateway-2"))
+			reg := consul.registration("node-1", "mesh-gateway-2")
+			if c.expDeregistered {
+				require.Nil(t, reg)
+			} else {
+				require.NotNil(t, reg)
+			}
+		})
+	}
+}
+
+func TestAgentAddress(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"":                       "10.0.0.1:8500",
+		"localhost":              "10.0.0.1:8500",
+		"localhost:8501":         "10.0.0.1:8501",
+		"https://localhost:8501": "https://10.0.0.1:8501",
+		"http://localhost":       "http://10.0.0.1:8500",
+	}
+	for address, exp := range cases {
+		require.Equal(t, exp, agentAddress(address, "10.0.0.1"), address)
+	}
+}
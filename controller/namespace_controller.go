@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/audit"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NamespaceController implements controller.Resource to create the mirrored
+// Consul namespace of each Kubernetes namespace as soon as it's created,
+// rather than when the first service or config entry is written to it, so
+// that intentions and config entries can be applied to it before the
+// workloads start.
+//
+// The namespaces are created with the ACL defaults of the controller. The
+// defaults missing from the namespaces that were created from Kubernetes,
+// e.g. lazily by the connect injector, are added to them, but the links
+// they already have are kept. Namespaces created outside of Kubernetes are
+// left as they are. Deleting a Kubernetes namespace doesn't delete its
+// Consul namespace, which would delete everything registered in it.
+type NamespaceController struct {
+	Log          hclog.Logger
+	KubeClient   kubernetes.Interface
+	ConsulClient *api.Client
+
+	// NSMirroringPrefix is prepended to the names of the Kubernetes
+	// namespaces to get the names of their Consul namespaces.
+	NSMirroringPrefix string
+
+	// CrossNSACLPolicy is the name of the ACL policy attached to the
+	// namespaces to allow cross namespace service discovery. It's one of
+	// their default policies, like DefaultPolicies.
+	CrossNSACLPolicy string
+
+	// DefaultPolicies and DefaultRoles are the names of the ACL policies and
+	// roles attached to the namespaces as the defaults of their tokens.
+	DefaultPolicies []string
+	DefaultRoles    []string
+
+	// ResyncPeriod is how often all namespaces are checked again, e.g. to
+	// recreate the Consul namespaces deleted outside of Kubernetes.
+	ResyncPeriod time.Duration
+}
+
+// Informer implements the controller.Resource interface.
+func (c *NamespaceController) Informer() cache.SharedIndexInformer {
+	namespaces := c.KubeClient.CoreV1().Namespaces()
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return namespaces.List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return namespaces.Watch(options)
+			},
+		},
+		&corev1.Namespace{},
+		c.ResyncPeriod,
+		cache.Indexers{},
+	)
+}
+
+// Upsert implements the controller.Resource interface. It creates the
+// Consul namespace of the Kubernetes namespace, or adds the missing ACL
+// defaults to it.
+func (c *NamespaceController) Upsert(key string, raw interface{}) error {
+	namespace, ok := raw.(*corev1.Namespace)
+	if !ok {
+		c.Log.Error("upsert of a non-namespace object", "key", key)
+		return nil
+	}
+	// Like the connect injector, the system namespaces aren't mirrored.
+	if namespace.Name == metav1.NamespaceSystem || namespace.Name == metav1.NamespacePublic {
+		return nil
+	}
+	if namespace.DeletionTimestamp != nil || namespace.Status.Phase == corev1.NamespaceTerminating {
+		return nil
+	}
+
+	// The writes to Consul are audited as caused by the namespace.
+	ctx := audit.WithCause(context.Background(), audit.Cause{Kind: "Namespace", Name: namespace.Name})
+	name := c.NSMirroringPrefix + namespace.Name
+	existing, _, err := c.ConsulClient.Namespaces().Read(name, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("reading consul namespace %q: %s", name, err)
+	}
+	if existing == nil {
+		_, _, err := c.ConsulClient.Namespaces().Create(&api.Namespace{
+			Name:        name,
+			Description: "Auto-generated by the namespace controller",
+			ACLs:        c.aclDefaults(nil),
+			Meta:        map[string]string{"external-source": "kubernetes"},
+		}, (&api.WriteOptions{}).WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("creating consul namespace %q: %s", name, err)
+		}
+		c.Log.Info("created consul namespace", "name", name, "k8s-namespace", namespace.Name)
+		return nil
+	}
+
+	if existing.DeletedAt != nil {
+		// The namespace is recreated once Consul has deleted it.
+		return fmt.Errorf("consul namespace %q is being deleted", name)
+	}
+	if existing.Meta["external-source"] != "kubernetes" {
+		c.Log.Debug("consul namespace not created from kubernetes", "name", name)
+		return nil
+	}
+	acls := c.aclDefaults(existing.ACLs)
+	if len(acls.PolicyDefaults) == len(aclLinks(existing.ACLs, true)) && len(acls.RoleDefaults) == len(aclLinks(existing.ACLs, false)) {
+		return nil
+	}
+	existing.ACLs = acls
+	if _, _, err := c.ConsulClient.Namespaces().Update(existing, (&api.WriteOptions{}).WithContext(ctx)); err != nil {
+		return fmt.Errorf("updating the ACL defaults of consul namespace %q: %s", name, err)
+	}
+	c.Log.Info("updated the ACL defaults of consul namespace", "name", name, "k8s-namespace", namespace.Name)
+	return nil
+}
+
+// Delete implements the controller.Resource interface. The Consul
+// namespace is kept.
+func (c *NamespaceController) Delete(key string) error {
+	return nil
+}
+
+// aclDefaults returns the ACL defaults of existing with the ones of the
+// controller that it doesn't link yet appended.
+func (c *NamespaceController) aclDefaults(existing *api.NamespaceACLConfig) *api.NamespaceACLConfig {
+	policies := c.DefaultPolicies
+	if c.CrossNSACLPolicy != "" {
+		policies = append([]string{c.CrossNSACLPolicy}, policies...)
+	}
+	return &api.NamespaceACLConfig{
+		PolicyDefaults: appendLinks(aclLinks(existing, true), policies),
+		RoleDefaults:   appendLinks(aclLinks(existing, false), c.DefaultRoles),
+	}
+}
+
+// aclLinks returns the default policies of acls, or its default roles if
+// policies is false.
+func aclLinks(acls *api.NamespaceACLConfig, policies bool) []api.ACLLink {
+	if acls == nil {
+		return nil
+	}
+	if policies {
+		return acls.PolicyDefaults
+	}
+	return acls.RoleDefaults
+}
+
+// appendLinks appends the links to names to links, except for those that
+// links already has.
+func appendLinks(links []api.ACLLink, names []string) []api.ACLLink {
+	result := append([]api.ACLLink{}, links...)
+	for _, name := range names {
+		linked := false
+		for _, link := range result {
+			if link.Name == name {
+				linked = true
+				break
+			}
+		}
+		if !linked {
+			result = append(result, api.ACLLink{Name: name})
+		}
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceController_Upsert(t *testing.T) {
+	t.Parallel()
+	kubernetesMeta := map[string]string{"external-source": "kubernetes"}
+	cases := map[string]struct {
+		namespace corev1.Namespace
+		existing  *api.Namespace
+		// expNamespace is the Consul namespace k8s-web, nil if it
+		// mustn't exist.
+		expNamespace *api.Namespace
+	}{
+		"new namespace": {
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+			expNamespace: &api.Namespace{
+				Name:        "k8s-web",
+				Description: "Auto-generated by the namespace controller",
+				ACLs: &api.NamespaceACLConfig{
+					PolicyDefaults: []api.ACLLink{{Name: "cross-namespace-policy"}, {Name: "dns-policy"}},
+					RoleDefaults:   []api.ACLLink{{Name: "operators"}},
+				},
+				Meta: kubernetesMeta,
+			},
+		},
+		"system namespace": {
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		},
+		"terminating namespace": {
+			namespace: corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "web"},
+				Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+			},
+		},
+		"namespace created lazily": {
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+			existing: &api.Namespace{
+				Name:        "k8s-web",
+				Description: "Auto-generated by a Connect Injector",
+				ACLs: &api.NamespaceACLConfig{
+					PolicyDefaults: []api.ACLLink{{ID: "1", Name: "cross-namespace-policy"}, {ID: "2", Name: "custom"}},
+				},
+				Meta: kubernetesMeta,
+			},
+			expNamespace: &api.Namespace{
+				Name:        "k8s-web",
+				Description: "Auto-generated by a Connect Injector",
+				ACLs: &api.NamespaceACLConfig{
+					PolicyDefaults: []api.ACLLink{{ID: "1", Name: "cross-namespace-policy"}, {ID: "2", Name: "custom"}, {Name: "dns-policy"}},
+					RoleDefaults:   []api.ACLLink{{Name: "operators"}},
+				},
+				Meta: kubernetesMeta,
+			},
+		},
+		"namespace created outside of kubernetes": {
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+			existing:  &api.Namespace{Name: "k8s-web", Description: "Created by the web team"},
+			expNamespace: &api.Namespace{
+				Name:        "k8s-web",
+				Description: "Created by the web team",
+			},
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			if c.existing != nil {
+				consul.createNamespace(*c.existing)
+			}
+			ctrl := &NamespaceController{
+				Log:               hclog.NewNullLogger(),
+				KubeClient:        fake.NewSimpleClientset(&c.namespace),
+				ConsulClient:      consulClient,
+				NSMirroringPrefix: "k8s-",
+				CrossNSACLPolicy:  "cross-namespace-policy",
+				DefaultPolicies:   []string{"dns-policy"},
+				DefaultRoles:      []string{"operators"},
+			}
+
+			require.NoError(t, ctrl.Upsert(c.namespace.Name, &c.namespace))
+			require.Equal(t, c.expNamespace, consul.namespace("k8s-web"))
+			require.Nil(t, consul.namespace("k8s-kube-system"))
+
+			// Upserting the namespace again doesn't change it.
+			require.NoError(t, ctrl.Upsert(c.namespace.Name, &c.namespace))
+			require.Equal(t, c.expNamespace, consul.namespace("k8s-web"))
+		})
+	}
+}
+
+func TestNamespaceController_Delete(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	consul.createNamespace(api.Namespace{Name: "web", Meta: map[string]string{"external-source": "kubernetes"}})
+	ctrl := &NamespaceController{
+		Log:          hclog.NewNullLogger(),
+		KubeClient:   fake.NewSimpleClientset(),
+		ConsulClient: consulClient,
+	}
+
+	require.NoError(t, ctrl.Delete("web"))
+	require.NotNil(t, consul.namespace("web"))
+}
@@ -0,0 +1,357 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+// reasonSecretError is the reason of the Synced condition of peering
+// resources whose peering token secret can't be read or written.
+const reasonSecretError = "SecretError"
+
+// reasonMeshGatewayUnavailable is the reason of the Synced condition of
+// peering resources that can't be synced because the mesh config entry
+// peers through mesh gateways but no mesh gateway is healthy.
+const reasonMeshGatewayUnavailable = "MeshGatewayUnavailable"
+
+// peeringServerAddressesAnnotation is the annotation of an acceptor's
+// secret holding the server external addresses its token was generated
+// with, so the token is generated again when they change.
+const peeringServerAddressesAnnotation = "consul.hashicorp.com/peering-server-addresses"
+
+// PeeringController implements controller.Resource to reconcile
+// PeeringAcceptor and PeeringDialer resources, which New creates, into
+// Consul peerings. The name of a resource is the name of the peer.
+//
+// An acceptor's peering token is generated when the peering doesn't exist
+// in Consul or its secret is missing or was modified, and the secret is
+// created if needed, owned by the acceptor. A dialer establishes the
+// peering when it doesn't exist in Consul or its secret was modified.
+//
+// If the mesh config entry peers through mesh gateways, the peers' servers
+// reach each other through the mesh gateways, whose addresses Consul puts
+// in the tokens, so tokens aren't generated and peerings aren't
+// established until a mesh gateway is healthy.
+//
+// Deleting a resource leaves its peering in Consul.
+type PeeringController struct {
+	Log        hclog.Logger
+	Client     dynamic.Interface
+	KubeClient kubernetes.Interface
+
+	// ConsulConfig is the config of the Consul API client, used to call
+	// the peering endpoints, which the client doesn't support. It must
+	// have been passed to api.NewClient, which completes it.
+	ConsulConfig *api.Config
+
+	// Resource is the group version resource of the custom resources.
+	Resource schema.GroupVersionResource
+
+	// New returns a new empty custom resource of the kind.
+	New func() v1alpha1.PeeringResource
+
+	// Namespace is the Kubernetes namespace to watch. If it's empty,
+	// all namespaces are watched.
+	Namespace string
+
+	// ResyncPeriod is how often all the resources are synced again, which
+	// updates the state of their peering. If 0, resources are only synced
+	// when they change.
+	ResyncPeriod time.Duration
+
+	// MeshGatewayServiceName is the name of the mesh gateway service
+	// checked when peering through mesh gateways. If it's empty, the
+	// mesh gateways aren't checked.
+	MeshGatewayServiceName string
+
+	// EventRecorder records the errors of syncing the resources as
+	// Kubernetes events on the resources. Events aren't recorded if it's
+	// nil.
+	EventRecorder record.EventRecorder
+}
+
+// peering is a peering read from Consul.
+type peering struct {
+	Name  string
+	State string
+}
+
+// Informer implements the controller.Resource interface.
+func (c *PeeringController) Informer() cache.SharedIndexInformer {
+	return newInformer(c.Client, c.Resource, c.Namespace, c.ResyncPeriod)
+}
+
+// Upsert implements the controller.Resource interface. It generates the
+// peering token or establishes the peering, and reports the result and
+// the state of the peering in the resource's status.
+func (c *PeeringController) Upsert(key string, raw interface{}) error {
+	obj, ok := raw.(*unstructured.Unstructured)
+	if !ok {
+		c.Log.Warn("upsert got invalid type", "key", key, "type", fmt.Sprintf("%T", raw))
+		return nil
+	}
+	resource := c.New()
+	if err := decode(obj, resource); err != nil {
+		c.Log.Error("error decoding resource", "key", key, "err", err)
+		return nil
+	}
+	status := resource.PeeringStatus()
+
+	if err := resource.Validate(); err != nil {
+		c.Log.Warn("invalid resource", "key", key, "err", err)
+		c.event(obj, corev1.EventTypeWarning, reasonInvalidConfig, err.Error())
+		return c.updateStatus(obj, resource,
+			status.SetCondition(v1alpha1.ConditionSynced, corev1.ConditionFalse, reasonInvalidConfig, err.Error()))
+	}
+
+	oldSecretRef, oldState, oldSynced := status.SecretRef, status.State, status.LastSyncedTime
+	var reason string
+	var err error
+	switch resource := resource.(type) {
+	case *v1alpha1.PeeringAcceptor:
+		reason, err = c.accept(obj, resource)
+	case *v1alpha1.PeeringDialer:
+		reason, err = c.dial(resource)
+	default:
+		return fmt.Errorf("unsupported peering resource %T", resource)
+	}
+	if err == nil {
+		p, readErr := c.readPeering(resource.GetName())
+		if readErr != nil {
+			reason, err = reasonConsulAgentError, fmt.Errorf("reading peering %q: %s", resource.GetName(), readErr)
+		} else if p != nil {
+			status.State = p.State
+		} else {
+			status.State = ""
+		}
+	}
+	if err != nil {
+		c.event(obj, corev1.EventTypeWarning, reason, err.Error())
+		if statusErr := c.updateStatus(obj, resource,
+			status.SetCondition(v1alpha1.ConditionSynced, corev1.ConditionFalse, reason, err.Error())); statusErr != nil {
+			c.Log.Error("error updating status", "key", key, "err", statusErr)
+		}
+		return err
+	}
+
+	changed := status.SetCondition(v1alpha1.ConditionSynced, corev1.ConditionTrue, reasonSynced, "")
+	changed = changed || status.State != oldState || status.LastSyncedTime != oldSynced ||
+		!reflect.DeepEqual(status.SecretRef, oldSecretRef)
+	return c.updateStatus(obj, resource, changed)
+}
+
+// event records an event on the resource if the controller has an event
+// recorder.
+func (c *PeeringController) event(obj *unstructured.Unstructured, eventType, reason, message string) {
+	if c.EventRecorder != nil {
+		c.EventRecorder.Event(obj, eventType, reason, message)
+	}
+}
+
+// Delete implements the controller.Resource interface.
+func (c *PeeringController) Delete(key string) error {
+	c.Log.Info("resource deleted, leaving peering in Consul", "key", key)
+	return nil
+}
+
+// accept generates the acceptor's peering token unless its secret holds
+// the token of the existing peering. On error, it returns the reason of
+// the Synced condition.
+func (c *PeeringController) accept(obj *unstructured.Unstructured, acceptor *v1alpha1.PeeringAcceptor) (string, error) {
+	name, spec, status := acceptor.Name, acceptor.PeerSecret(), acceptor.PeeringStatus()
+	existing, err := c.readPeering(name)
+	if err != nil {
+		return reasonConsulAgentError, fmt.Errorf("reading peering %q: %s", name, err)
+	}
+	secret, err := c.KubeClient.CoreV1().Secrets(acceptor.Namespace).Get(spec.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		secret, err = nil, nil
+	}
+	if err != nil {
+		return reasonSecretError, fmt.Errorf("reading secret %q: %s", spec.Name, err)
+	}
+	addresses := strings.Join(acceptor.Spec.ServerExternalAddresses, ",")
+	if existing != nil && secret != nil && len(secret.Data[spec.Key]) > 0 &&
+		secret.Annotations[peeringServerAddressesAnnotation] == addresses &&
+		status.SecretRef.Matches(spec) && status.SecretRef.ResourceVersion == secret.ResourceVersion {
+		return "", nil
+	}
+	// The server external addresses override the addresses of the mesh
+	// gateways.
+	if len(acceptor.Spec.ServerExternalAddresses) == 0 {
+		if reason, err := c.checkMeshGateway(); err != nil {
+			return reason, err
+		}
+	}
+
+	req := struct {
+		PeerName                string
+		ServerExternalAddresses []string `json:",omitempty"`
+	}{name, acceptor.Spec.ServerExternalAddresses}
+	var resp struct {
+		PeeringToken string
+	}
+	if err := c.consulRequest(http.MethodPost, "/v1/peering/token", req, &resp); err != nil {
+		return reasonConsulAgentError, fmt.Errorf("generating peering token: %s", err)
+	}
+
+	if secret == nil {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      spec.Name,
+				Namespace: acceptor.Namespace,
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: obj.GetAPIVersion(),
+					Kind:       obj.GetKind(),
+					Name:       obj.GetName(),
+					UID:        obj.GetUID(),
+				}},
+			},
+			Data: map[string][]byte{spec.Key: []byte(resp.PeeringToken)},
+		}
+		setServerAddresses(secret, addresses)
+		secret, err = c.KubeClient.CoreV1().Secrets(acceptor.Namespace).Create(secret)
+	} else {
+		secret = secret.DeepCopy()
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[spec.Key] = []byte(resp.PeeringToken)
+		setServerAddresses(secret, addresses)
+		secret, err = c.KubeClient.CoreV1().Secrets(acceptor.Namespace).Update(secret)
+	}
+	if err != nil {
+		return reasonSecretError, fmt.Errorf("writing secret %q: %s", spec.Name, err)
+	}
+	status.SecretRef = &v1alpha1.SecretRefStatus{PeerSecret: *spec, ResourceVersion: secret.ResourceVersion}
+	now := metav1.Now()
+	status.LastSyncedTime = &now
+	c.Log.Info("peering token generated", "peer", name, "secret", spec.Name)
+	return "", nil
+}
+
+// dial establishes the dialer's peering unless it exists and was
+// established with the current version of its secret. On error, it
+// returns the reason of the Synced condition.
+func (c *PeeringController) dial(dialer *v1alpha1.PeeringDialer) (string, error) {
+	name, spec, status := dialer.Name, dialer.PeerSecret(), dialer.PeeringStatus()
+	secret, err := c.KubeClient.CoreV1().Secrets(dialer.Namespace).Get(spec.Name, metav1.GetOptions{})
+	if err != nil {
+		return reasonSecretError, fmt.Errorf("reading secret %q: %s", spec.Name, err)
+	}
+	token := secret.Data[spec.Key]
+	if len(token) == 0 {
+		return reasonSecretError, fmt.Errorf("secret %q has no %q key", spec.Name, spec.Key)
+	}
+	existing, err := c.readPeering(name)
+	if err != nil {
+		return reasonConsulAgentError, fmt.Errorf("reading peering %q: %s", name, err)
+	}
+	if existing != nil && status.SecretRef.Matches(spec) && status.SecretRef.ResourceVersion == secret.ResourceVersion {
+		return "", nil
+	}
+	if reason, err := c.checkMeshGateway(); err != nil {
+		return reason, err
+	}
+
+	req := map[string]string{"PeerName": name, "PeeringToken": string(token)}
+	if err := c.consulRequest(http.MethodPost, "/v1/peering/establish", req, nil); err != nil {
+		return reasonConsulAgentError, fmt.Errorf("establishing peering %q: %s", name, err)
+	}
+	status.SecretRef = &v1alpha1.SecretRefStatus{PeerSecret: *spec, ResourceVersion: secret.ResourceVersion}
+	now := metav1.Now()
+	status.LastSyncedTime = &now
+	c.Log.Info("peering established", "peer", name, "secret", spec.Name)
+	return "", nil
+}
+
+// checkMeshGateway returns an error if the mesh config entry peers through
+// mesh gateways but no instance of the mesh gateway service is passing its
+// health checks, along with the reason of the Synced condition.
+func (c *PeeringController) checkMeshGateway() (string, error) {
+	if c.MeshGatewayServiceName == "" {
+		return "", nil
+	}
+	var mesh v1alpha1.MeshConfigEntry
+	err := c.consulRequest(http.MethodGet, "/v1/config/"+v1alpha1.MeshKind+"/"+v1alpha1.MeshName, nil, &mesh)
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return reasonConsulAgentError, fmt.Errorf("reading mesh config entry: %s", err)
+	}
+	if mesh.Peering == nil || !mesh.Peering.PeerThroughMeshGateways {
+		return "", nil
+	}
+	var instances []json.RawMessage
+	err = consulRequest(context.Background(), c.ConsulConfig, http.MethodGet, "/v1/health/service/"+url.PathEscape(c.MeshGatewayServiceName),
+		url.Values{"passing": []string{"1"}}, nil, &instances)
+	if err != nil {
+		return reasonConsulAgentError, fmt.Errorf("reading mesh gateway %q: %s", c.MeshGatewayServiceName, err)
+	}
+	if len(instances) == 0 {
+		return reasonMeshGatewayUnavailable, fmt.Errorf(
+			"the mesh config entry peers through mesh gateways, but no instance of %q is healthy", c.MeshGatewayServiceName)
+	}
+	return "", nil
+}
+
+// setServerAddresses records the server external addresses the token of
+// the secret was generated with.
+func setServerAddresses(secret *corev1.Secret, addresses string) {
+	if addresses == "" {
+		delete(secret.Annotations, peeringServerAddressesAnnotation)
+		return
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[peeringServerAddressesAnnotation] = addresses
+}
+
+// readPeering reads the peering from Consul. It returns nil if the
+// peering doesn't exist.
+func (c *PeeringController) readPeering(name string) (*peering, error) {
+	var p peering
+	err := c.consulRequest(http.MethodGet, "/v1/peering/"+url.PathEscape(name), nil, &p)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// consulRequest sends a request to Consul's HTTP API and decodes the
+// response into out if it's not nil.
+func (c *PeeringController) consulRequest(method, path string, in, out interface{}) error {
+	return consulRequest(context.Background(), c.ConsulConfig, method, path, nil, in, out)
+}
+
+// updateStatus updates the status of the resource if it changed.
+func (c *PeeringController) updateStatus(obj *unstructured.Unstructured, resource v1alpha1.PeeringResource, changed bool) error {
+	if !changed {
+		return nil
+	}
+	return writeStatus(c.Client, c.Resource, obj, resource.PeeringStatus())
+}
@@ -0,0 +1,303 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPeeringController_Acceptor(t *testing.T) {
+	t.Parallel()
+	consul, _, stop := newFakeConsul(t)
+	defer stop()
+	resource := &v1alpha1.PeeringAcceptor{
+		Spec: v1alpha1.PeeringAcceptorSpec{Peer: peerSecret()},
+	}
+	resource.APIVersion = v1alpha1.GroupVersion.String()
+	resource.Kind = "PeeringAcceptor"
+	resource.Name = "dc2"
+	resource.Namespace = "default"
+	resource.UID = types.UID("acceptor-uid")
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	kubeClient := fake.NewSimpleClientset()
+	controller := peeringController(client, kubeClient, consul, v1alpha1.PeeringAcceptorResource)
+
+	// The token is generated and stored in a secret owned by the acceptor.
+	require.NoError(t, controller.Upsert("default/dc2", obj))
+	secret, err := kubeClient.CoreV1().Secrets("default").Get("peering-token", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "dc2-token-1", string(secret.Data["data"]))
+	require.Len(t, secret.OwnerReferences, 1)
+	require.Equal(t, types.UID("acceptor-uid"), secret.OwnerReferences[0].UID)
+	status := peeringStatus(t, client, v1alpha1.PeeringAcceptorResource, "default", "dc2")
+	require.Equal(t, "PENDING", status.State)
+	require.NotNil(t, status.LastSyncedTime)
+	require.True(t, status.SecretRef.Matches(resource.Spec.Peer.Secret))
+	require.Equal(t, corev1.ConditionTrue, status.GetCondition(v1alpha1.ConditionSynced).Status)
+
+	// The token isn't generated again while the peering exists.
+	obj, err = client.Resource(controller.Resource).Namespace("default").Get("dc2", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/dc2", obj))
+	require.Equal(t, 1, consul.tokens)
+
+	// A new token is generated if the peering is deleted from Consul.
+	consul.lock.Lock()
+	delete(consul.peerings, "dc2")
+	consul.lock.Unlock()
+	require.NoError(t, controller.Upsert("default/dc2", obj))
+	secret, err = kubeClient.CoreV1().Secrets("default").Get("peering-token", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "dc2-token-2", string(secret.Data["data"]))
+}
+
+func TestPeeringController_Dialer(t *testing.T) {
+	t.Parallel()
+	consul, _, stop := newFakeConsul(t)
+	defer stop()
+	resource := &v1alpha1.PeeringDialer{
+		Spec: v1alpha1.PeeringDialerSpec{Peer: peerSecret()},
+	}
+	resource.APIVersion = v1alpha1.GroupVersion.String()
+	resource.Kind = "PeeringDialer"
+	resource.Name = "dc1"
+	resource.Namespace = "default"
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	kubeClient := fake.NewSimpleClientset()
+	controller := peeringController(client, kubeClient, consul, v1alpha1.PeeringDialerResource)
+
+	// The peering can't be established until the secret exists.
+	require.Error(t, controller.Upsert("default/dc1", obj))
+	condition := getCondition(t, client, v1alpha1.PeeringDialerResource, "default", "dc1", v1alpha1.ConditionSynced)
+	require.Equal(t, corev1.ConditionFalse, condition.Status)
+	require.Equal(t, reasonSecretError, condition.Reason)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "peering-token", Namespace: "default", ResourceVersion: "1"},
+		Data:       map[string][]byte{"data": []byte("token-1")},
+	}
+	_, err := kubeClient.CoreV1().Secrets("default").Create(secret)
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/dc1", obj))
+	require.Equal(t, "token-1", consul.peering("dc1").Token)
+	status := peeringStatus(t, client, v1alpha1.PeeringDialerResource, "default", "dc1")
+	require.Equal(t, "ESTABLISHING", status.State)
+	require.Equal(t, "1", status.SecretRef.ResourceVersion)
+	require.Equal(t, corev1.ConditionTrue, status.GetCondition(v1alpha1.ConditionSynced).Status)
+
+	// The peering isn't established again, but its state is updated.
+	consul.lock.Lock()
+	consul.peerings["dc1"].State = "ACTIVE"
+	consul.lock.Unlock()
+	obj, err = client.Resource(controller.Resource).Namespace("default").Get("dc1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/dc1", obj))
+	require.Equal(t, "ACTIVE", consul.peering("dc1").State)
+	require.Equal(t, "ACTIVE", peeringStatus(t, client, v1alpha1.PeeringDialerResource, "default", "dc1").State)
+
+	// The peering is established again with a new token.
+	secret.ResourceVersion = "2"
+	secret.Data["data"] = []byte("token-2")
+	_, err = kubeClient.CoreV1().Secrets("default").Update(secret)
+	require.NoError(t, err)
+	obj, err = client.Resource(controller.Resource).Namespace("default").Get("dc1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/dc1", obj))
+	require.Equal(t, "token-2", consul.peering("dc1").Token)
+}
+
+func TestPeeringController_ServerExternalAddresses(t *testing.T) {
+	t.Parallel()
+	consul, _, stop := newFakeConsul(t)
+	defer stop()
+	resource := &v1alpha1.PeeringAcceptor{
+		Spec: v1alpha1.PeeringAcceptorSpec{Peer: peerSecret(), ServerExternalAddresses: []string{"1.2.3.4:8502"}},
+	}
+	resource.APIVersion = v1alpha1.GroupVersion.String()
+	resource.Kind = "PeeringAcceptor"
+	resource.Name = "dc2"
+	resource.Namespace = "default"
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	kubeClient := fake.NewSimpleClientset()
+	controller := peeringController(client, kubeClient, consul, v1alpha1.PeeringAcceptorResource)
+
+	require.NoError(t, controller.Upsert("default/dc2", obj))
+	require.Equal(t, []string{"1.2.3.4:8502"}, consul.peering("dc2").ServerExternalAddresses)
+
+	// The token is generated again when the addresses change.
+	obj, err := client.Resource(controller.Resource).Namespace("default").Get("dc2", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, unstructured.SetNestedStringSlice(obj.Object, []string{"5.6.7.8:443"}, "spec", "serverExternalAddresses"))
+	require.NoError(t, controller.Upsert("default/dc2", obj))
+	require.Equal(t, 2, consul.tokens)
+	require.Equal(t, []string{"5.6.7.8:443"}, consul.peering("dc2").ServerExternalAddresses)
+	secret, err := kubeClient.CoreV1().Secrets("default").Get("peering-token", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "dc2-token-2", string(secret.Data["data"]))
+
+	// But not while they're the same.
+	obj, err = client.Resource(controller.Resource).Namespace("default").Get("dc2", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/dc2", obj))
+	require.Equal(t, 2, consul.tokens)
+}
+
+// Test that peering through mesh gateways waits for a healthy mesh gateway.
+func TestPeeringController_MeshGateway(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		resource  string
+		addresses []string
+		peerMesh  bool
+		gateway   bool
+		expErr    bool
+	}{
+		"acceptor without mesh config entry": {
+			resource: v1alpha1.PeeringAcceptorResource,
+		},
+		"acceptor without mesh gateway": {
+			resource: v1alpha1.PeeringAcceptorResource,
+			peerMesh: true,
+			expErr:   true,
+		},
+		"acceptor with mesh gateway": {
+			resource: v1alpha1.PeeringAcceptorResource,
+			peerMesh: true,
+			gateway:  true,
+		},
+		"acceptor with server external addresses": {
+			resource:  v1alpha1.PeeringAcceptorResource,
+			addresses: []string{"1.2.3.4:8502"},
+			peerMesh:  true,
+		},
+		"dialer without mesh gateway": {
+			resource: v1alpha1.PeeringDialerResource,
+			peerMesh: true,
+			expErr:   true,
+		},
+		"dialer with mesh gateway": {
+			resource: v1alpha1.PeeringDialerResource,
+			peerMesh: true,
+			gateway:  true,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, _, stop := newFakeConsul(t)
+			defer stop()
+			if c.peerMesh {
+				consul.entries["/mesh/mesh"] = map[string]interface{}{
+					"Kind":    v1alpha1.MeshKind,
+					"Name":    v1alpha1.MeshName,
+					"Peering": map[string]interface{}{"PeerThroughMeshGateways": true},
+				}
+			}
+			if c.gateway {
+				consul.services["/mesh-gateway"] = true
+			}
+			var resource interface{}
+			if c.resource == v1alpha1.PeeringDialerResource {
+				resource = &v1alpha1.PeeringDialer{
+					TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "PeeringDialer"},
+					ObjectMeta: metav1.ObjectMeta{Name: "peer", Namespace: "default"},
+					Spec:       v1alpha1.PeeringDialerSpec{Peer: peerSecret()},
+				}
+			} else {
+				resource = &v1alpha1.PeeringAcceptor{
+					TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "PeeringAcceptor"},
+					ObjectMeta: metav1.ObjectMeta{Name: "peer", Namespace: "default"},
+					Spec:       v1alpha1.PeeringAcceptorSpec{Peer: peerSecret(), ServerExternalAddresses: c.addresses},
+				}
+			}
+			obj := toUnstructured(t, resource)
+			client := newFakeDynamicClient(obj)
+			kubeClient := fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "peering-token", Namespace: "default"},
+				Data:       map[string][]byte{"data": []byte("token")},
+			})
+			controller := peeringController(client, kubeClient, consul, c.resource)
+			controller.MeshGatewayServiceName = "mesh-gateway"
+
+			err := controller.Upsert("default/peer", obj)
+			condition := getCondition(t, client, c.resource, "default", "peer", v1alpha1.ConditionSynced)
+			if c.expErr {
+				require.Error(t, err)
+				require.Equal(t, corev1.ConditionFalse, condition.Status)
+				require.Equal(t, reasonMeshGatewayUnavailable, condition.Reason)
+				require.Nil(t, consul.peering("peer"))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, corev1.ConditionTrue, condition.Status)
+			require.NotNil(t, consul.peering("peer"))
+		})
+	}
+}
+
+func TestPeeringController_Invalid(t *testing.T) {
+	t.Parallel()
+	consul, _, stop := newFakeConsul(t)
+	defer stop()
+	resource := &v1alpha1.PeeringAcceptor{}
+	resource.APIVersion = v1alpha1.GroupVersion.String()
+	resource.Kind = "PeeringAcceptor"
+	resource.Name = "dc2"
+	resource.Namespace = "default"
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	controller := peeringController(client, fake.NewSimpleClientset(), consul, v1alpha1.PeeringAcceptorResource)
+	recorder := record.NewFakeRecorder(10)
+	controller.EventRecorder = recorder
+
+	require.NoError(t, controller.Upsert("default/dc2", obj))
+	condition := getCondition(t, client, v1alpha1.PeeringAcceptorResource, "default", "dc2", v1alpha1.ConditionSynced)
+	require.Equal(t, corev1.ConditionFalse, condition.Status)
+	require.Equal(t, reasonInvalidConfig, condition.Reason)
+	require.Equal(t, "Warning InvalidConfig "+condition.Message, <-recorder.Events)
+	require.Nil(t, consul.peering("dc2"))
+}
+
+func peeringController(client *fakeDynamicClient, kubeClient *fake.Clientset, consul *fakeConsul, resource string) *PeeringController {
+	return &PeeringController{
+		Log:          hclog.NewNullLogger(),
+		Client:       client,
+		KubeClient:   kubeClient,
+		ConsulConfig: consul.config,
+		Resource:     v1alpha1.GroupVersion.WithResource(resource),
+		New: func() v1alpha1.PeeringResource {
+			if resource == v1alpha1.PeeringDialerResource {
+				return &v1alpha1.PeeringDialer{}
+			}
+			return &v1alpha1.PeeringAcceptor{}
+		},
+	}
+}
+
+func peerSecret() v1alpha1.Peer {
+	return v1alpha1.Peer{Secret: &v1alpha1.PeerSecret{Name: "peering-token", Key: "data", Backend: "kubernetes"}}
+}
+
+func peeringStatus(t *testing.T, client *fakeDynamicClient, resource, namespace, name string) v1alpha1.PeeringStatus {
+	obj, err := client.Resource(v1alpha1.GroupVersion.WithResource(resource)).
+		Namespace(namespace).Get(name, metav1.GetOptions{})
+	require.NoError(t, err)
+	var status struct {
+		Status v1alpha1.PeeringStatus `json:"status"`
+	}
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &status))
+	return status.Status
+}
@@ -0,0 +1,343 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// defaultCheckTimeout is the timeout of the Registration checks that
+	// don't set one.
+	defaultCheckTimeout = 10 * time.Second
+
+	// Reasons of the GatewayLinked condition.
+	reasonLinked    = "Linked"
+	reasonNotLinked = "NotLinked"
+)
+
+// RegistrationController implements controller.Resource to register the
+// services of Registration resources in the Consul catalog. Each sync runs
+// the checks of the resource and registers the service with their status,
+// so ResyncPeriod is the interval of the checks.
+//
+// The controller adds its finalizer to the resources so that deleting a
+// resource deregisters its service, and its node once it has no services
+// left.
+type RegistrationController struct {
+	Log          hclog.Logger
+	Client       dynamic.Interface
+	ConsulClient *api.Client
+
+	// Namespace is the Kubernetes namespace to watch. If it's empty,
+	// all namespaces are watched.
+	Namespace string
+
+	// EventRecorder records the errors of syncing the resources as
+	// Kubernetes events on the resources. Events aren't recorded if it's
+	// nil.
+	EventRecorder record.EventRecorder
+
+	// ResyncPeriod is how often the checks of the resources are run. If
+	// 0, they're only run when the resources change.
+	ResyncPeriod time.Duration
+}
+
+// Informer implements the controller.Resource interface.
+func (c *RegistrationController) Informer() cache.SharedIndexInformer {
+	return newInformer(c.Client, c.resource(), c.Namespace, c.ResyncPeriod)
+}
+
+// Upsert implements the controller.Resource interface. It runs the checks
+// of the resource and registers its service in Consul with their status.
+func (c *RegistrationController) Upsert(key string, raw interface{}) error {
+	obj, ok := raw.(*unstructured.Unstructured)
+	if !ok {
+		c.Log.Warn("upsert got invalid type", "key", key, "type", fmt.Sprintf("%T", raw))
+		return nil
+	}
+	registration := &v1alpha1.Registration{}
+	if err := decode(obj, registration); err != nil {
+		c.Log.Error("error decoding resource", "key", key, "err", err)
+		return nil
+	}
+	status := &registration.Status
+
+	if obj.GetDeletionTimestamp() != nil {
+		return c.finalize(obj, registration)
+	}
+	if !hasFinalizer(obj) {
+		var err error
+		if obj, err = addFinalizer(c.Client, c.resource(), obj); err != nil {
+			return err
+		}
+	}
+
+	if err := registration.Validate(); err != nil {
+		c.Log.Warn("invalid resource", "key", key, "err", err)
+		c.event(obj, corev1.EventTypeWarning, reasonInvalidConfig, err.Error())
+		return c.updateStatus(obj, registration,
+			status.SetCondition(v1alpha1.ConditionSynced, corev1.ConditionFalse, reasonInvalidConfig, err.Error()))
+	}
+
+	checks := c.runChecks(registration)
+	if err := c.register(registration, checks); err != nil {
+		c.event(obj, corev1.EventTypeWarning, reasonConsulAgentError, err.Error())
+		if statusErr := c.updateStatus(obj, registration,
+			status.SetCondition(v1alpha1.ConditionSynced, corev1.ConditionFalse, reasonConsulAgentError, err.Error())); statusErr != nil {
+			c.Log.Error("error updating status", "key", key, "err", statusErr)
+		}
+		return err
+	}
+
+	// The service is registered on every sync with the result of its
+	// checks, but the sync time is only updated when the registration
+	// changes so that the status isn't written on every run.
+	changed := false
+	node, serviceID, namespace := registration.Spec.Node, registration.ServiceID(), registration.Spec.Service.Namespace
+	if status.LastSyncedTime == nil || !reflect.DeepEqual(status.Checks, checks) ||
+		status.Node != node || status.ServiceID != serviceID || status.ConsulNamespace != namespace {
+		now := metav1.Now()
+		status.LastSyncedTime = &now
+		status.Checks, status.Node, status.ServiceID, status.ConsulNamespace = checks, node, serviceID, namespace
+		changed = true
+	}
+	if registration.Spec.TerminatingGateway != "" {
+		linkedChanged, err := c.checkGatewayLink(registration)
+		if err != nil {
+			return err
+		}
+		changed = linkedChanged || changed
+	}
+	changed = status.SetCondition(v1alpha1.ConditionSynced, corev1.ConditionTrue, reasonSynced, "") || changed
+	return c.updateStatus(obj, registration, changed)
+}
+
+// Delete implements the controller.Resource interface. Services are
+// deregistered by finalize before their resource is removed.
+func (c *RegistrationController) Delete(key string) error {
+	c.Log.Debug("resource deleted", "key", key)
+	return nil
+}
+
+// register registers the service of the resource with the status of its
+// checks. If the spec moved the service to another node or ID, the
+// previous instance is deregistered first.
+func (c *RegistrationController) register(registration *v1alpha1.Registration, checks []v1alpha1.CheckStatus) error {
+	spec, status := registration.Spec, registration.Status
+	serviceID := registration.ServiceID()
+	if status.Node != "" && (status.Node != spec.Node || status.ServiceID != serviceID || status.ConsulNamespace != spec.Service.Namespace) {
+		if err := c.deregister(status.Node, status.ServiceID, status.ConsulNamespace); err != nil {
+			return err
+		}
+	}
+
+	meta := map[string]string{"external-source": "kubernetes"}
+	for k, v := range spec.Service.Meta {
+		meta[k] = v
+	}
+	nodeMeta := map[string]string{"external-source": "kubernetes"}
+	for k, v := range spec.NodeMeta {
+		nodeMeta[k] = v
+	}
+	reg := &api.CatalogRegistration{
+		Node:     spec.Node,
+		Address:  spec.Address,
+		NodeMeta: nodeMeta,
+		Service: &api.AgentService{
+			ID:        serviceID,
+			Service:   registration.ServiceName(),
+			Namespace: spec.Service.Namespace,
+			Address:   spec.Service.Address,
+			Port:      spec.Service.Port,
+			Tags:      spec.Service.Tags,
+			Meta:      meta,
+		},
+	}
+	for i, check := range spec.Checks {
+		healthCheck := &api.HealthCheck{
+			Node:      spec.Node,
+			CheckID:   registration.CheckID(check),
+			Name:      check.Name,
+			Status:    checks[i].Status,
+			Output:    checks[i].Output,
+			ServiceID: serviceID,
+			Namespace: spec.Service.Namespace,
+			Type:      "tcp",
+			Definition: api.HealthCheckDefinition{
+				HTTP: check.HTTP,
+				TCP:  check.TCP,
+			},
+		}
+		if check.HTTP != "" {
+			healthCheck.Type = "http"
+		}
+		reg.Checks = append(reg.Checks, healthCheck)
+	}
+	if _, err := c.ConsulClient.Catalog().Register(reg, nil); err != nil {
+		return fmt.Errorf("registering service %q: %s", serviceID, err)
+	}
+	return nil
+}
+
+// finalize deregisters the service of the resource being deleted and then
+// removes the finalizer of the resource.
+func (c *RegistrationController) finalize(obj *unstructured.Unstructured, registration *v1alpha1.Registration) error {
+	if !hasFinalizer(obj) {
+		return nil
+	}
+	status := &registration.Status
+	if status.Node != "" {
+		if err := c.deregister(status.Node, status.ServiceID, status.ConsulNamespace); err != nil {
+			c.event(obj, corev1.EventTypeWarning, reasonConsulAgentError, err.Error())
+			if statusErr := c.updateStatus(obj, registration,
+				status.SetCondition(v1alpha1.ConditionSynced, corev1.ConditionFalse, reasonConsulAgentError, err.Error())); statusErr != nil {
+				c.Log.Error("error updating status", "name", obj.GetName(), "err", statusErr)
+			}
+			return err
+		}
+	}
+	return removeFinalizer(c.Client, c.resource(), obj)
+}
+
+// deregister deregisters the service instance, and its node if it has no
+// other services and was registered from Kubernetes.
+func (c *RegistrationController) deregister(node, serviceID, namespace string) error {
+	_, err := c.ConsulClient.Catalog().Deregister(&api.CatalogDeregistration{
+		Node:      node,
+		ServiceID: serviceID,
+		Namespace: namespace,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("deregistering service %q: %s", serviceID, err)
+	}
+	c.Log.Info("service deregistered", "node", node, "service", serviceID)
+
+	// The services of every namespace are listed so that a node is only
+	// deregistered once it has no services in any of them.
+	opts := &api.QueryOptions{}
+	if namespace != "" {
+		opts.Namespace = "*"
+	}
+	catalogNode, _, err := c.ConsulClient.Catalog().Node(node, opts)
+	if err != nil {
+		return fmt.Errorf("reading node %q: %s", node, err)
+	}
+	if catalogNode == nil || catalogNode.Node == nil || len(catalogNode.Services) > 0 ||
+		catalogNode.Node.Meta["external-source"] != "kubernetes" {
+		return nil
+	}
+	if _, err := c.ConsulClient.Catalog().Deregister(&api.CatalogDeregistration{Node: node}, nil); err != nil {
+		return fmt.Errorf("deregistering node %q: %s", node, err)
+	}
+	c.Log.Info("node deregistered", "node", node)
+	return nil
+}
+
+// runChecks runs the checks of the resource and returns their status.
+func (c *RegistrationController) runChecks(registration *v1alpha1.Registration) []v1alpha1.CheckStatus {
+	var statuses []v1alpha1.CheckStatus
+	for _, check := range registration.Spec.Checks {
+		status, output := runCheck(check)
+		statuses = append(statuses, v1alpha1.CheckStatus{Name: check.Name, Status: status, Output: output})
+	}
+	return statuses
+}
+
+// checkGatewayLink sets the GatewayLinked condition of the resource based
+// on whether the terminating-gateway config entry of its spec links its
+// service. It returns true if the condition changed.
+func (c *RegistrationController) checkGatewayLink(registration *v1alpha1.Registration) (bool, error) {
+	gateway, name, namespace := registration.Spec.TerminatingGateway, registration.ServiceName(), registration.Spec.Service.Namespace
+	status := &registration.Status
+	// The Consul API client doesn't decode terminating-gateway entries and
+	// its raw queries don't report missing entries, so the entries of the
+	// kind are listed instead.
+	var entries []*v1alpha1.TerminatingGatewayConfigEntry
+	_, err := c.ConsulClient.Raw().Query("/v1/config/"+v1alpha1.TerminatingGatewayKind, &entries, &api.QueryOptions{Namespace: namespace})
+	if err != nil {
+		return false, fmt.Errorf("reading terminating-gateway %q: %s", gateway, err)
+	}
+	var entry *v1alpha1.TerminatingGatewayConfigEntry
+	for _, e := range entries {
+		if e.Name == gateway {
+			entry = e
+		}
+	}
+	if entry == nil {
+		return status.SetCondition(v1alpha1.ConditionGatewayLinked, corev1.ConditionFalse, reasonNotLinked,
+			fmt.Sprintf("terminating-gateway %q doesn't exist", gateway)), nil
+	}
+	for _, service := range entry.Services {
+		if (service.Name == name || service.Name == "*") && (service.Namespace == "" || service.Namespace == namespace) {
+			return status.SetCondition(v1alpha1.ConditionGatewayLinked, corev1.ConditionTrue, reasonLinked, ""), nil
+		}
+	}
+	return status.SetCondition(v1alpha1.ConditionGatewayLinked, corev1.ConditionFalse, reasonNotLinked,
+		fmt.Sprintf("service %q isn't linked to terminating-gateway %q", name, gateway)), nil
+}
+
+// updateStatus updates the status of the resource if it changed.
+func (c *RegistrationController) updateStatus(obj *unstructured.Unstructured, registration *v1alpha1.Registration, changed bool) error {
+	if !changed {
+		return nil
+	}
+	return writeStatus(c.Client, c.resource(), obj, &registration.Status)
+}
+
+// event records an event on the resource if the controller has an event
+// recorder.
+func (c *RegistrationController) event(obj *unstructured.Unstructured, eventType, reason, message string) {
+	if c.EventRecorder != nil {
+		c.EventRecorder.Event(obj, eventType, reason, message)
+	}
+}
+
+func (c *RegistrationController) resource() schema.GroupVersionResource {
+	return v1alpha1.GroupVersion.WithResource(v1alpha1.RegistrationResource)
+}
+
+// runCheck runs the check and returns its status and output.
+func runCheck(check v1alpha1.RegistrationCheck) (string, string) {
+	timeout := check.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultCheckTimeout
+	}
+	if check.TCP != "" {
+		conn, err := net.DialTimeout("tcp", check.TCP, timeout)
+		if err != nil {
+			return api.HealthCritical, fmt.Sprintf("TCP connect %s: %s", check.TCP, err)
+		}
+		conn.Close()
+		return api.HealthPassing, fmt.Sprintf("TCP connect %s: Success", check.TCP)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(check.HTTP)
+	if err != nil {
+		return api.HealthCritical, fmt.Sprintf("HTTP GET %s: %s", check.HTTP, err)
+	}
+	resp.Body.Close()
+	output := fmt.Sprintf("HTTP GET %s: %s", check.HTTP, resp.Status)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return api.HealthPassing, output
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return api.HealthWarning, output
+	default:
+		return api.HealthCritical, output
+	}
+}
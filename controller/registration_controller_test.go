@@ -0,0 +1,251 @@
+package controller
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestRegistrationController_Upsert(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	resource := registration("db")
+	resource.Spec.Service.Tags = []string{"primary"}
+	resource.Spec.Checks = []v1alpha1.RegistrationCheck{{Name: "http", HTTP: server.URL}}
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	controller := registrationController(client, consulClient)
+
+	require.NoError(t, controller.Upsert("default/db", obj))
+	reg := consul.registration("vm-1", "db")
+	require.NotNil(t, reg)
+	require.Equal(t, "10.0.0.1", reg.Address)
+	require.Equal(t, "kubernetes", reg.NodeMeta["external-source"])
+	require.Equal(t, "db", reg.Service.Service)
+	require.Equal(t, 5432, reg.Service.Port)
+	require.Equal(t, []string{"primary"}, reg.Service.Tags)
+	require.Equal(t, "kubernetes", reg.Service.Meta["external-source"])
+	require.Len(t, reg.Checks, 1)
+	require.Equal(t, "db:http", reg.Checks[0].CheckID)
+	require.Equal(t, api.HealthPassing, reg.Checks[0].Status)
+
+	status := registrationStatus(t, client, "default", "db")
+	require.Equal(t, "vm-1", status.Node)
+	require.Equal(t, "db", status.ServiceID)
+	require.Len(t, status.Checks, 1)
+	require.Equal(t, api.HealthPassing, status.Checks[0].Status)
+	require.Equal(t, corev1.ConditionTrue, status.GetCondition(v1alpha1.ConditionSynced).Status)
+	require.NotNil(t, status.LastSyncedTime)
+
+	// The checks run again on the next sync.
+	healthy = false
+	obj, err := client.Resource(controller.resource()).Namespace("default").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/db", obj))
+	require.Equal(t, api.HealthCritical, consul.registration("vm-1", "db").Checks[0].Status)
+	status = registrationStatus(t, client, "default", "db")
+	require.Equal(t, api.HealthCritical, status.Checks[0].Status)
+	require.Contains(t, status.Checks[0].Output, "503")
+}
+
+// Test that the service is deregistered from its previous node when the
+// spec moves it, and that the node is deregistered once it's empty.
+func TestRegistrationController_MoveNode(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	obj := toUnstructured(t, registration("db"))
+	client := newFakeDynamicClient(obj)
+	controller := registrationController(client, consulClient)
+	require.NoError(t, controller.Upsert("default/db", obj))
+	require.NotNil(t, consul.registration("vm-1", "db"))
+
+	obj, err := client.Resource(controller.resource()).Namespace("default").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, unstructured.SetNestedField(obj.Object, "vm-2", "spec", "node"))
+	require.NoError(t, controller.Upsert("default/db", obj))
+	require.NotNil(t, consul.registration("vm-2", "db"))
+	require.False(t, consul.hasNode("vm-1"))
+	require.Equal(t, "vm-2", registrationStatus(t, client, "default", "db").Node)
+}
+
+func TestRegistrationController_Finalizer(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	obj := toUnstructured(t, registration("db"))
+	other := toUnstructured(t, registration("cache"))
+	client := newFakeDynamicClient(obj, other)
+	controller := registrationController(client, consulClient)
+	require.NoError(t, controller.Upsert("default/db", obj))
+	require.NoError(t, controller.Upsert("default/cache", other))
+
+	obj, err := client.Resource(controller.resource()).Namespace("default").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{FinalizerName}, obj.GetFinalizers())
+
+	// The node is kept while it has other services.
+	now := metav1.Now()
+	obj.SetDeletionTimestamp(&now)
+	require.NoError(t, controller.Upsert("default/db", obj))
+	require.Nil(t, consul.registration("vm-1", "db"))
+	require.NotNil(t, consul.registration("vm-1", "cache"))
+	obj, err = client.Resource(controller.resource()).Namespace("default").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Empty(t, obj.GetFinalizers())
+
+	other, err = client.Resource(controller.resource()).Namespace("default").Get("cache", metav1.GetOptions{})
+	require.NoError(t, err)
+	other.SetDeletionTimestamp(&now)
+	require.NoError(t, controller.Upsert("default/cache", other))
+	require.False(t, consul.hasNode("vm-1"))
+}
+
+func TestRegistrationController_TCPCheck(t *testing.T) {
+	t.Parallel()
+	_, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+
+	resource := registration("db")
+	resource.Spec.Checks = []v1alpha1.RegistrationCheck{{Name: "tcp", TCP: addr}}
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	controller := registrationController(client, consulClient)
+	require.NoError(t, controller.Upsert("default/db", obj))
+	require.Equal(t, api.HealthPassing, registrationStatus(t, client, "default", "db").Checks[0].Status)
+
+	require.NoError(t, listener.Close())
+	obj, err = client.Resource(controller.resource()).Namespace("default").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/db", obj))
+	require.Equal(t, api.HealthCritical, registrationStatus(t, client, "default", "db").Checks[0].Status)
+}
+
+func TestRegistrationController_TerminatingGateway(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	resource := registration("db")
+	resource.Spec.TerminatingGateway = "terminating-gateway"
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	controller := registrationController(client, consulClient)
+
+	require.NoError(t, controller.Upsert("default/db", obj))
+	condition := getCondition(t, client, v1alpha1.RegistrationResource, "default", "db", v1alpha1.ConditionGatewayLinked)
+	require.Equal(t, corev1.ConditionFalse, condition.Status)
+	require.Equal(t, `terminating-gateway "terminating-gateway" doesn't exist`, condition.Message)
+
+	consul.lock.Lock()
+	consul.entries["/terminating-gateway/terminating-gateway"] = map[string]interface{}{
+		"Kind":     "terminating-gateway",
+		"Name":     "terminating-gateway",
+		"Services": []interface{}{map[string]interface{}{"Name": "cache"}},
+	}
+	consul.lock.Unlock()
+	obj, err := client.Resource(controller.resource()).Namespace("default").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/db", obj))
+	condition = getCondition(t, client, v1alpha1.RegistrationResource, "default", "db", v1alpha1.ConditionGatewayLinked)
+	require.Equal(t, corev1.ConditionFalse, condition.Status)
+	require.Equal(t, reasonNotLinked, condition.Reason)
+
+	consul.lock.Lock()
+	consul.entries["/terminating-gateway/terminating-gateway"]["Services"] = []interface{}{map[string]interface{}{"Name": "db"}}
+	consul.lock.Unlock()
+	obj, err = client.Resource(controller.resource()).Namespace("default").Get("db", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/db", obj))
+	condition = getCondition(t, client, v1alpha1.RegistrationResource, "default", "db", v1alpha1.ConditionGatewayLinked)
+	require.Equal(t, corev1.ConditionTrue, condition.Status)
+	require.Equal(t, reasonLinked, condition.Reason)
+}
+
+func TestRegistrationController_Invalid(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	resource := registration("db")
+	resource.Spec.Address = ""
+	obj := toUnstructured(t, resource)
+	client := newFakeDynamicClient(obj)
+	controller := registrationController(client, consulClient)
+
+	require.NoError(t, controller.Upsert("default/db", obj))
+	condition := getCondition(t, client, v1alpha1.RegistrationResource, "default", "db", v1alpha1.ConditionSynced)
+	require.Equal(t, corev1.ConditionFalse, condition.Status)
+	require.Equal(t, reasonInvalidConfig, condition.Reason)
+	require.False(t, consul.hasNode("vm-1"))
+}
+
+func TestRegistrationController_ConsulError(t *testing.T) {
+	t.Parallel()
+	_, consulClient, stop := newFakeConsul(t)
+	stop()
+	obj := toUnstructured(t, registration("db"))
+	client := newFakeDynamicClient(obj)
+	controller := registrationController(client, consulClient)
+
+	err := controller.Upsert("default/db", obj)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `registering service "db"`)
+	condition := getCondition(t, client, v1alpha1.RegistrationResource, "default", "db", v1alpha1.ConditionSynced)
+	require.Equal(t, corev1.ConditionFalse, condition.Status)
+	require.Equal(t, reasonConsulAgentError, condition.Reason)
+}
+
+func registrationController(client *fakeDynamicClient, consulClient *api.Client) *RegistrationController {
+	return &RegistrationController{
+		Log:          hclog.NewNullLogger(),
+		Client:       client,
+		ConsulClient: consulClient,
+	}
+}
+
+func registration(name string) *v1alpha1.Registration {
+	resource := &v1alpha1.Registration{
+		Spec: v1alpha1.RegistrationSpec{
+			Node:    "vm-1",
+			Address: "10.0.0.1",
+			Service: v1alpha1.RegistrationService{Port: 5432},
+		},
+	}
+	resource.APIVersion = v1alpha1.GroupVersion.String()
+	resource.Kind = "Registration"
+	resource.Name = name
+	resource.Namespace = "default"
+	return resource
+}
+
+func registrationStatus(t *testing.T, client *fakeDynamicClient, namespace, name string) v1alpha1.RegistrationStatus {
+	obj, err := client.Resource(v1alpha1.GroupVersion.WithResource(v1alpha1.RegistrationResource)).
+		Namespace(namespace).Get(name, metav1.GetOptions{})
+	require.NoError(t, err)
+	var status struct {
+		Status v1alpha1.RegistrationStatus `json:"status"`
+	}
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &status))
+	return status.Status
+}
@@ -0,0 +1,661 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/gatewayapi"
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// Reasons of the route conditions.
+	reasonNotAllowedByListeners = "NotAllowedByListeners"
+	reasonNoMatchingParent      = "NoMatchingParent"
+	reasonUnsupportedValue      = "UnsupportedValue"
+	reasonResolvedRefs          = "ResolvedRefs"
+	reasonInvalidKind           = "InvalidKind"
+	reasonInvalidFilter         = "InvalidFilter"
+	reasonRefNotPermitted       = "RefNotPermitted"
+)
+
+// RouteController implements controller.Resource to write the HTTPRoutes
+// or TCPRoutes attached to the gateways of GatewayController to their
+// http-route or tcp-route config entries. Only the parents of the routes
+// that are gateways of GatewayControllerName are written to the config
+// entries and have their status set.
+//
+// The backends of the routes must be Services, and are routed to the
+// Consul services of the same name. Services of other namespaces must be
+// permitted by a ReferenceGrant of their namespace. Since the grants
+// aren't watched, routes are only synced again when a grant changes every
+// ResyncPeriod.
+//
+// Listeners allowing the routes of the namespaces matching a selector
+// match the labels of the route's namespace, read with KubeClient.
+type RouteController struct {
+	Log          hclog.Logger
+	Client       dynamic.Interface
+	KubeClient   kubernetes.Interface
+	ConsulClient *api.Client
+
+	// Kind is the kind of the routes, gatewayapi.HTTPRouteKind or
+	// gatewayapi.TCPRouteKind.
+	Kind string
+
+	// Namespace is the Kubernetes namespace to watch. If it's empty,
+	// all namespaces are watched.
+	Namespace string
+
+	// ResyncPeriod is how often all routes are synced again, e.g. to
+	// attach them to gateways created after them.
+	ResyncPeriod time.Duration
+}
+
+// Informer implements the controller.Resource interface.
+func (c *RouteController) Informer() cache.SharedIndexInformer {
+	return newInformer(c.Client, c.resource(), c.Namespace, c.ResyncPeriod)
+}
+
+// Upsert implements the controller.Resource interface. It writes the
+// config entry of the route if it's attached to a gateway.
+func (c *RouteController) Upsert(key string, raw interface{}) error {
+	obj, ok := raw.(*unstructured.Unstructured)
+	if !ok {
+		c.Log.Warn("upsert got invalid type", "key", key, "type", fmt.Sprintf("%T", raw))
+		return nil
+	}
+	route, err := c.decodeRoute(obj)
+	if err != nil {
+		c.Log.Error("error decoding resource", "key", key, "err", err)
+		return nil
+	}
+	if err := c.resolveGrants(route); err != nil {
+		return err
+	}
+
+	parents, err := c.resolveParents(route)
+	if err != nil {
+		return err
+	}
+	if obj.GetDeletionTimestamp() != nil || len(parents) == 0 {
+		return c.finalize(obj)
+	}
+	if !hasFinalizer(obj) {
+		if obj, err = addFinalizer(c.Client, c.resource(), obj); err != nil {
+			return err
+		}
+	}
+
+	entry, translateErr := c.configEntry(route)
+	refsErr := checkBackendRefs(route)
+	generation := obj.GetGeneration()
+	var attached []v1alpha1.ResourceReference
+	for i := range parents {
+		parent := &parents[i]
+		switch {
+		case parent.err != nil:
+			parent.setCondition(gatewayCondition(conditionAccepted, false, parent.reason, parent.err.Error(), generation))
+		case translateErr != nil:
+			reason := reasonUnsupportedValue
+			if conditionErr, ok := translateErr.(*routeConditionError); ok {
+				reason = conditionErr.reason
+			}
+			parent.setCondition(gatewayCondition(conditionAccepted, false, reason, translateErr.Error(), generation))
+		default:
+			parent.setCondition(gatewayCondition(conditionAccepted, true, reasonAccepted, "", generation))
+			attached = append(attached, parent.reference)
+		}
+		if refsErr != nil {
+			parent.setCondition(gatewayCondition(conditionResolvedRefs, false, refsErr.reason, refsErr.message, generation))
+		} else {
+			parent.setCondition(gatewayCondition(conditionResolvedRefs, true, reasonResolvedRefs, "", generation))
+		}
+	}
+
+	name := gatewayEntryName(route.namespace, route.name)
+	if len(attached) == 0 {
+		if err := deleteConfigEntryIfExists(c.ConsulClient, entry.GetKind(), name); err != nil {
+			return err
+		}
+	} else {
+		setRouteParents(entry, attached)
+		if _, _, err := c.ConsulClient.ConfigEntries().Set(entry, nil); err != nil {
+			return fmt.Errorf("writing %s config entry %q: %s", entry.GetKind(), name, err)
+		}
+	}
+	return c.updateStatus(obj, route.status, parents)
+}
+
+// Delete implements the controller.Resource interface. Routes are cleaned
+// up by finalize before they're removed.
+func (c *RouteController) Delete(key string) error {
+	c.Log.Debug("resource deleted", "key", key)
+	return nil
+}
+
+// route is the part of an HTTPRoute or TCPRoute that's common to both.
+type route struct {
+	namespace  string
+	name       string
+	parentRefs []gatewayapi.ParentReference
+	backends   []gatewayapi.BackendRef
+	status     gatewayapi.RouteStatus
+	http       *gatewayapi.HTTPRouteSpec
+	tcp        *gatewayapi.TCPRouteSpec
+	// granted are the backends of other namespaces permitted by
+	// ReferenceGrants, keyed by namespace/name.
+	granted map[string]bool
+}
+
+func (c *RouteController) decodeRoute(obj *unstructured.Unstructured) (*route, error) {
+	r := &route{namespace: obj.GetNamespace(), name: obj.GetName()}
+	if c.Kind == gatewayapi.TCPRouteKind {
+		tcpRoute := &gatewayapi.TCPRoute{}
+		if err := decode(obj, tcpRoute); err != nil {
+			return nil, err
+		}
+		r.parentRefs, r.status, r.tcp = tcpRoute.Spec.ParentRefs, tcpRoute.Status, &tcpRoute.Spec
+		for _, rule := range tcpRoute.Spec.Rules {
+			r.backends = append(r.backends, rule.BackendRefs...)
+		}
+		return r, nil
+	}
+	httpRoute := &gatewayapi.HTTPRoute{}
+	if err := decode(obj, httpRoute); err != nil {
+		return nil, err
+	}
+	r.parentRefs, r.status, r.http = httpRoute.Spec.ParentRefs, httpRoute.Status, &httpRoute.Spec
+	for _, rule := range httpRoute.Spec.Rules {
+		r.backends = append(r.backends, rule.BackendRefs...)
+	}
+	return r, nil
+}
+
+// routeParent is a parent of a route that's a gateway of
+// GatewayControllerName.
+type routeParent struct {
+	reference v1alpha1.ResourceReference
+	status    gatewayapi.RouteParentStatus
+	// err is why the route can't attach to the gateway, with the reason
+	// of its Accepted condition.
+	err    error
+	reason string
+}
+
+func (p *routeParent) setCondition(condition gatewayapi.Condition) {
+	p.status.Conditions.Set(condition)
+}
+
+// resolveGrants sets the backends of the route in other namespaces that
+// ReferenceGrants of their namespace permit the route to reference.
+func (c *RouteController) resolveGrants(r *route) error {
+	r.granted = make(map[string]bool)
+	grants := make(map[string][]gatewayapi.ReferenceGrant)
+	from := gatewayapi.ReferenceGrantFrom{Group: gatewayapi.Group, Kind: c.Kind, Namespace: r.namespace}
+	for _, backend := range r.backends {
+		if backend.Namespace == "" || backend.Namespace == r.namespace || !isServiceKind(backend) {
+			continue
+		}
+		namespaceGrants, ok := grants[backend.Namespace]
+		if !ok {
+			list, err := c.Client.Resource(gatewayapi.GroupVersion.WithResource(gatewayapi.ReferenceGrantResource)).
+				Namespace(backend.Namespace).List(metav1.ListOptions{})
+			if err != nil {
+				return fmt.Errorf("listing the ReferenceGrants of namespace %q: %s", backend.Namespace, err)
+			}
+			for i := range list.Items {
+				grant := gatewayapi.ReferenceGrant{}
+				if err := decode(&list.Items[i], &grant); err != nil {
+					continue
+				}
+				namespaceGrants = append(namespaceGrants, grant)
+			}
+			grants[backend.Namespace] = namespaceGrants
+		}
+		for i := range namespaceGrants {
+			if namespaceGrants[i].Permits(from, "", "Service", backend.Name) {
+				r.granted[backend.Namespace+"/"+backend.Name] = true
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// resolveParents returns the parents of the route that are gateways of
+// GatewayControllerName.
+func (c *RouteController) resolveParents(r *route) ([]routeParent, error) {
+	var parents []routeParent
+	var namespaceLabels labels.Set
+	for _, ref := range r.parentRefs {
+		if (ref.Group != "" && ref.Group != gatewayapi.Group) || (ref.Kind != "" && ref.Kind != "Gateway") {
+			continue
+		}
+		namespace := ref.Namespace
+		if namespace == "" {
+			namespace = r.namespace
+		}
+		obj, err := c.Client.Resource(gatewayResource).Namespace(namespace).Get(ref.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading gateway %s/%s: %s", namespace, ref.Name, err)
+		}
+		gateway := &gatewayapi.Gateway{}
+		if err := decode(obj, gateway); err != nil {
+			return nil, err
+		}
+		class, err := managedClass(c.Client, gateway.Spec.GatewayClassName)
+		if err != nil {
+			return nil, err
+		}
+		if class == nil {
+			continue
+		}
+
+		parent := routeParent{
+			reference: v1alpha1.ResourceReference{
+				Kind:        v1alpha1.APIGatewayKind,
+				Name:        gatewayEntryName(namespace, ref.Name),
+				SectionName: ref.SectionName,
+			},
+			status: gatewayapi.RouteParentStatus{ParentRef: ref, ControllerName: GatewayControllerName},
+		}
+		for _, existing := range r.status.Parents {
+			if existing.ControllerName == GatewayControllerName && existing.ParentRef == ref {
+				parent.status.Conditions = existing.Conditions
+			}
+		}
+		if namespaceLabels == nil && selectsNamespaces(gateway) {
+			namespace, err := c.KubeClient.CoreV1().Namespaces().Get(r.namespace, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("reading namespace %q: %s", r.namespace, err)
+			}
+			namespaceLabels = labels.Set(namespace.Labels)
+		}
+		parent.reason, parent.err = c.checkListeners(r, gateway, ref.SectionName, namespaceLabels)
+		parents = append(parents, parent)
+	}
+	return parents, nil
+}
+
+// checkListeners returns an error if none of the listeners of the gateway
+// the route refers to allow the route. The labels of the route's namespace
+// are only required if a listener selects the namespaces of its routes.
+func (c *RouteController) checkListeners(r *route, gateway *gatewayapi.Gateway, sectionName string, namespaceLabels labels.Set) (string, error) {
+	found := false
+	for _, listener := range gateway.Spec.Listeners {
+		if sectionName != "" && listener.Name != sectionName {
+			continue
+		}
+		found = true
+		if c.Kind == gatewayapi.TCPRouteKind && listener.Protocol != gatewayapi.TCPProtocol {
+			continue
+		}
+		if c.Kind == gatewayapi.HTTPRouteKind && listener.Protocol != gatewayapi.HTTPProtocol && listener.Protocol != gatewayapi.HTTPSProtocol {
+			continue
+		}
+		from := gatewayapi.NamespacesFromSame
+		if listener.AllowedRoutes != nil && listener.AllowedRoutes.Namespaces != nil && listener.AllowedRoutes.Namespaces.From != "" {
+			from = listener.AllowedRoutes.Namespaces.From
+		}
+		if from == gatewayapi.NamespacesFromAll || (from == gatewayapi.NamespacesFromSame && gateway.Namespace == r.namespace) {
+			return "", nil
+		}
+		if from == gatewayapi.NamespacesFromSelector && listener.AllowedRoutes.Namespaces.Selector != nil {
+			// Invalid selectors don't select any namespace.
+			selector, err := metav1.LabelSelectorAsSelector(listener.AllowedRoutes.Namespaces.Selector)
+			if err == nil && selector.Matches(namespaceLabels) {
+				return "", nil
+			}
+		}
+	}
+	if !found {
+		return reasonNoMatchingParent, fmt.Errorf("gateway %s/%s has no listener %q", gateway.Namespace, gateway.Name, sectionName)
+	}
+	return reasonNotAllowedByListeners, fmt.Errorf("no listener of gateway %s/%s allows the %s", gateway.Namespace, gateway.Name, c.Kind)
+}
+
+// configEntry returns the config entry of the route without its parents.
+// It returns an error alongside the entry if the route uses features that
+// aren't supported by Consul.
+func (c *RouteController) configEntry(r *route) (api.ConfigEntry, error) {
+	name := gatewayEntryName(r.namespace, r.name)
+	meta := gatewayEntryMeta(r.namespace, r.name)
+	if r.tcp != nil {
+		entry := &v1alpha1.TCPRouteConfigEntry{Kind: v1alpha1.TCPRouteKind, Name: name, Meta: meta}
+		for _, backend := range r.validBackends() {
+			entry.Services = append(entry.Services, v1alpha1.TCPService{Name: backend.Name})
+		}
+		return entry, nil
+	}
+
+	entry := &v1alpha1.HTTPRouteConfigEntry{Kind: v1alpha1.HTTPRouteKind, Name: name, Meta: meta, Hostnames: r.http.Hostnames}
+	for i, rule := range r.http.Rules {
+		path := fmt.Sprintf("spec.rules[%d]", i)
+		consulRule, err := translateHTTPRule(r, rule, path, c.extensionResolver(r.namespace))
+		if err != nil {
+			return entry, err
+		}
+		entry.Rules = append(entry.Rules, consulRule)
+	}
+	return entry, nil
+}
+
+// extensionResolver returns the route filter resource referenced by an
+// extensionRef filter, e.g. a v1alpha1.RouteTimeoutFilter.
+type extensionResolver func(ref gatewayapi.LocalObjectReference) (interface{}, error)
+
+// extensionResolver returns the resolver of the extensionRef filters of the
+// routes in the namespace. The referenced filters must be valid route
+// filter resources of the consul.hashicorp.com group.
+func (c *RouteController) extensionResolver(namespace string) extensionResolver {
+	return func(ref gatewayapi.LocalObjectReference) (interface{}, error) {
+		var resource string
+		var filter interface{ Validate() error }
+		switch {
+		case ref.Group != v1alpha1.GroupVersion.Group:
+		case ref.Kind == v1alpha1.RouteTimeoutFilterKind:
+			resource, filter = v1alpha1.RouteTimeoutFilterResource, &v1alpha1.RouteTimeoutFilter{}
+		case ref.Kind == v1alpha1.RouteRetryFilterKind:
+			resource, filter = v1alpha1.RouteRetryFilterResource, &v1alpha1.RouteRetryFilter{}
+		case ref.Kind == v1alpha1.RouteAuthFilterKind:
+			resource, filter = v1alpha1.RouteAuthFilterResource, &v1alpha1.RouteAuthFilter{}
+		}
+		if filter == nil {
+			return nil, &routeConditionError{reasonUnsupportedValue,
+				fmt.Sprintf("extensionRef filters of kind %s.%s aren't supported", ref.Kind, ref.Group)}
+		}
+		obj, err := c.Client.Resource(v1alpha1.GroupVersion.WithResource(resource)).Namespace(namespace).Get(ref.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, &routeConditionError{reasonInvalidFilter, fmt.Sprintf("%s %q doesn't exist", ref.Kind, ref.Name)}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s %q: %s", ref.Kind, ref.Name, err)
+		}
+		if err := decode(obj, filter); err != nil {
+			return nil, err
+		}
+		if err := filter.Validate(); err != nil {
+			return nil, &routeConditionError{reasonInvalidFilter, fmt.Sprintf("%s %q: %s", ref.Kind, ref.Name, err)}
+		}
+		return filter, nil
+	}
+}
+
+// translateHTTPRule returns the rule of an http-route config entry for the
+// rule of the HTTPRoute. The extensionRef filters of the rule are resolved
+// with resolve, and a rule can only have one filter of each kind.
+func translateHTTPRule(r *route, rule gatewayapi.HTTPRouteRule, path string, resolve extensionResolver) (v1alpha1.HTTPRouteRule, error) {
+	consulRule := v1alpha1.HTTPRouteRule{}
+	for j, match := range rule.Matches {
+		matchPath := fmt.Sprintf("%s.matches[%d]", path, j)
+		consulMatch := v1alpha1.HTTPMatch{
+			Method: match.Method,
+			Path:   v1alpha1.HTTPPathMatch{Match: "prefix", Value: "/"},
+		}
+		if match.Path != nil {
+			pathType, err := translateMatchType(match.Path.Type, gatewayapi.PathMatchPathPrefix, matchPath+".path")
+			if err != nil {
+				return consulRule, err
+			}
+			consulMatch.Path.Match = pathType
+			if match.Path.Value != "" {
+				consulMatch.Path.Value = match.Path.Value
+			}
+		}
+		for k, header := range match.Headers {
+			matchType, err := translateMatchType(header.Type, gatewayapi.MatchExact, fmt.Sprintf("%s.headers[%d]", matchPath, k))
+			if err != nil {
+				return consulRule, err
+			}
+			consulMatch.Headers = append(consulMatch.Headers, v1alpha1.HTTPHeaderMatch{Match: matchType, Name: header.Name, Value: header.Value})
+		}
+		for k, query := range match.QueryParams {
+			matchType, err := translateMatchType(query.Type, gatewayapi.MatchExact, fmt.Sprintf("%s.queryParams[%d]", matchPath, k))
+			if err != nil {
+				return consulRule, err
+			}
+			consulMatch.Query = append(consulMatch.Query, v1alpha1.HTTPQueryMatch{Match: matchType, Name: query.Name, Value: query.Value})
+		}
+		consulRule.Matches = append(consulRule.Matches, consulMatch)
+	}
+	// Rules without matches match all requests.
+	if len(consulRule.Matches) == 0 {
+		consulRule.Matches = []v1alpha1.HTTPMatch{{Path: v1alpha1.HTTPPathMatch{Match: "prefix", Value: "/"}}}
+	}
+
+	for j, filter := range rule.Filters {
+		filterPath := fmt.Sprintf("%s.filters[%d]", path, j)
+		switch {
+		case filter.Type == gatewayapi.FilterRequestHeaderModifier && filter.RequestHeaderModifier != nil:
+			modifier := filter.RequestHeaderModifier
+			headers := v1alpha1.HTTPHeaderFilter{Remove: modifier.Remove}
+			for _, header := range modifier.Add {
+				if headers.Add == nil {
+					headers.Add = make(map[string]string)
+				}
+				headers.Add[header.Name] = header.Value
+			}
+			for _, header := range modifier.Set {
+				if headers.Set == nil {
+					headers.Set = make(map[string]string)
+				}
+				headers.Set[header.Name] = header.Value
+			}
+			consulRule.Filters.Headers = append(consulRule.Filters.Headers, headers)
+		case filter.Type == gatewayapi.FilterURLRewrite && filter.URLRewrite != nil:
+			rewrite := filter.URLRewrite
+			if rewrite.Path == nil || rewrite.Path.Type != "ReplacePrefixMatch" {
+				return consulRule, fmt.Errorf("%s.urlRewrite only supports replacing the prefix of the path", filterPath)
+			}
+			consulRule.Filters.URLRewrite = &v1alpha1.URLRewrite{Path: rewrite.Path.ReplacePrefixMatch}
+		case filter.Type == gatewayapi.FilterExtensionRef && filter.ExtensionRef != nil:
+			extension, err := resolve(*filter.ExtensionRef)
+			if err != nil {
+				return consulRule, prefixError(filterPath, err)
+			}
+			filters := &consulRule.Filters
+			duplicate := false
+			switch extension := extension.(type) {
+			case *v1alpha1.RouteTimeoutFilter:
+				duplicate = filters.TimeoutFilter != nil
+				filters.TimeoutFilter = extension.ToConsul()
+			case *v1alpha1.RouteRetryFilter:
+				duplicate = filters.RetryFilter != nil
+				filters.RetryFilter = extension.ToConsul()
+			case *v1alpha1.RouteAuthFilter:
+				duplicate = filters.JWT != nil
+				filters.JWT = extension.ToConsul()
+			}
+			if duplicate {
+				return consulRule, &routeConditionError{reasonInvalidFilter,
+					fmt.Sprintf("%s: the rule has more than one %s filter", filterPath, filter.ExtensionRef.Kind)}
+			}
+		default:
+			return consulRule, fmt.Errorf("%s: filters of type %q aren't supported", filterPath, filter.Type)
+		}
+	}
+
+	for _, backend := range rule.BackendRefs {
+		if !r.isServiceBackend(backend) {
+			continue
+		}
+		weight := 1
+		if backend.Weight != nil {
+			weight = int(*backend.Weight)
+		}
+		// Backends with a weight of 0 mustn't receive requests.
+		if weight == 0 {
+			continue
+		}
+		consulRule.Services = append(consulRule.Services, v1alpha1.HTTPService{Name: backend.Name, Weight: weight})
+	}
+	return consulRule, nil
+}
+
+// translateMatchType returns the Consul match type of a path, header or
+// query parameter match type of the Gateway API.
+func translateMatchType(matchType, defaultType, path string) (string, error) {
+	if matchType == "" {
+		matchType = defaultType
+	}
+	switch matchType {
+	case gatewayapi.PathMatchExact:
+		return "exact", nil
+	case gatewayapi.PathMatchPathPrefix:
+		return "prefix", nil
+	case gatewayapi.PathMatchRegularExpression:
+		return "regex", nil
+	}
+	return "", fmt.Errorf("%s.type %q isn't supported", path, matchType)
+}
+
+// finalize deletes the config entry of the route and removes its
+// finalizer.
+func (c *RouteController) finalize(obj *unstructured.Unstructured) error {
+	if !hasFinalizer(obj) {
+		return nil
+	}
+	kind := v1alpha1.HTTPRouteKind
+	if c.Kind == gatewayapi.TCPRouteKind {
+		kind = v1alpha1.TCPRouteKind
+	}
+	if err := deleteConfigEntryIfExists(c.ConsulClient, kind, gatewayEntryName(obj.GetNamespace(), obj.GetName())); err != nil {
+		return err
+	}
+	return removeFinalizer(c.Client, c.resource(), obj)
+}
+
+// updateStatus sets the status of the route for the parents of
+// GatewayControllerName, keeping the status of the other controllers.
+func (c *RouteController) updateStatus(obj *unstructured.Unstructured, status gatewayapi.RouteStatus, parents []routeParent) error {
+	updated := gatewayapi.RouteStatus{}
+	for _, existing := range status.Parents {
+		if existing.ControllerName != GatewayControllerName {
+			updated.Parents = append(updated.Parents, existing)
+		}
+	}
+	for _, parent := range parents {
+		updated.Parents = append(updated.Parents, parent.status)
+	}
+	if routeStatusEqual(status, updated) {
+		return nil
+	}
+	return writeStatus(c.Client, c.resource(), obj, &updated)
+}
+
+func (c *RouteController) resource() schema.GroupVersionResource {
+	if c.Kind == gatewayapi.TCPRouteKind {
+		return gatewayapi.ExperimentalGroupVersion.WithResource(gatewayapi.TCPRouteResource)
+	}
+	return gatewayapi.GroupVersion.WithResource(gatewayapi.HTTPRouteResource)
+}
+
+// routeConditionError is why a route can't be accepted or its backends
+// can't be resolved, with the reason of the condition.
+type routeConditionError struct {
+	reason  string
+	message string
+}
+
+func (e *routeConditionError) Error() string {
+	return e.message
+}
+
+// prefixError prefixes the message of the error with the path of the
+// field it's about, keeping the reason of route condition errors.
+func prefixError(path string, err error) error {
+	if conditionErr, ok := err.(*routeConditionError); ok {
+		return &routeConditionError{conditionErr.reason, path + ": " + conditionErr.message}
+	}
+	return fmt.Errorf("%s: %s", path, err)
+}
+
+// checkBackendRefs returns an error for the first backend of the route
+// that isn't a Service of its namespace or permitted by a ReferenceGrant.
+func checkBackendRefs(r *route) *routeConditionError {
+	for _, backend := range r.backends {
+		if !isServiceKind(backend) {
+			return &routeConditionError{reasonInvalidKind, fmt.Sprintf("backend %q must be a Service", backend.Name)}
+		}
+		if !r.isServiceBackend(backend) {
+			return &routeConditionError{reasonRefNotPermitted,
+				fmt.Sprintf("backend %q of namespace %q isn't permitted by a ReferenceGrant", backend.Name, backend.Namespace)}
+		}
+	}
+	return nil
+}
+
+// validBackends returns the backends of the route that are Services of its
+// namespace or permitted by a ReferenceGrant.
+func (r *route) validBackends() []gatewayapi.BackendRef {
+	var backends []gatewayapi.BackendRef
+	for _, backend := range r.backends {
+		if r.isServiceBackend(backend) {
+			backends = append(backends, backend)
+		}
+	}
+	return backends
+}
+
+func (r *route) isServiceBackend(backend gatewayapi.BackendRef) bool {
+	return isServiceKind(backend) &&
+		(backend.Namespace == "" || backend.Namespace == r.namespace || r.granted[backend.Namespace+"/"+backend.Name])
+}
+
+func isServiceKind(backend gatewayapi.BackendRef) bool {
+	return (backend.Group == "" || backend.Group == "core") && (backend.Kind == "" || backend.Kind == "Service")
+}
+
+// selectsNamespaces returns true if a listener of the gateway allows the
+// routes of the namespaces matching a selector.
+func selectsNamespaces(gateway *gatewayapi.Gateway) bool {
+	for _, listener := range gateway.Spec.Listeners {
+		if listener.AllowedRoutes != nil && listener.AllowedRoutes.Namespaces != nil &&
+			listener.AllowedRoutes.Namespaces.From == gatewayapi.NamespacesFromSelector {
+			return true
+		}
+	}
+	return false
+}
+
+// setRouteParents sets the parents of the config entry of a route.
+func setRouteParents(entry api.ConfigEntry, parents []v1alpha1.ResourceReference) {
+	switch entry := entry.(type) {
+	case *v1alpha1.HTTPRouteConfigEntry:
+		entry.Parents = parents
+	case *v1alpha1.TCPRouteConfigEntry:
+		entry.Parents = parents
+	}
+}
+
+func routeStatusEqual(a, b gatewayapi.RouteStatus) bool {
+	if len(a.Parents) != len(b.Parents) {
+		return false
+	}
+	for i := range a.Parents {
+		if a.Parents[i].ParentRef != b.Parents[i].ParentRef || a.Parents[i].ControllerName != b.Parents[i].ControllerName ||
+			len(a.Parents[i].Conditions) != len(b.Parents[i].Conditions) {
+			return false
+		}
+		for j := range a.Parents[i].Conditions {
+			if a.Parents[i].Conditions[j] != b.Parents[i].Conditions[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,574 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/gatewayapi"
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRouteController_UpsertHTTPRoute(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	route := httpRoute("web", gatewayapi.HTTPRouteRule{BackendRefs: []gatewayapi.BackendRef{{Name: "web"}}})
+	route.Spec.Hostnames = []string{"example.com"}
+	// Parents of other controllers are ignored.
+	route.Spec.ParentRefs = append(route.Spec.ParentRefs, gatewayapi.ParentReference{Name: "other-gw"})
+	route.Status.Parents = []gatewayapi.RouteParentStatus{{
+		ParentRef:      gatewayapi.ParentReference{Name: "other-gw"},
+		ControllerName: "example.com/other",
+	}}
+	obj := toUnstructured(t, route)
+	otherGateway := gateway("other-gw")
+	otherGateway.Spec.GatewayClassName = "other"
+	client := newFakeDynamicClient(
+		toUnstructured(t, gatewayClass("consul", GatewayControllerName)),
+		toUnstructured(t, gatewayClass("other", "example.com/other")),
+		toUnstructured(t, gateway("gw")),
+		toUnstructured(t, otherGateway),
+		obj)
+	controller := routeController(client, consulClient, gatewayapi.HTTPRouteKind)
+
+	require.NoError(t, controller.Upsert("default/web", obj))
+	entry := consul.entry("", v1alpha1.HTTPRouteKind, "web-default")
+	require.NotNil(t, entry)
+	require.Equal(t, []interface{}{map[string]interface{}{"Kind": v1alpha1.APIGatewayKind, "Name": "gw-default"}}, entry["Parents"])
+	require.Equal(t, []interface{}{"example.com"}, entry["Hostnames"])
+	rules := entry["Rules"].([]interface{})
+	require.Len(t, rules, 1)
+	require.Equal(t, []interface{}{map[string]interface{}{"Name": "web", "Weight": float64(1)}}, rules[0].(map[string]interface{})["Services"])
+
+	status := routeStatus(t, client, controller, "default", "web")
+	require.Len(t, status.Parents, 2)
+	require.Equal(t, "example.com/other", status.Parents[0].ControllerName)
+	require.Equal(t, GatewayControllerName, status.Parents[1].ControllerName)
+	require.Equal(t, "gw", status.Parents[1].ParentRef.Name)
+	require.Equal(t, "True", status.Parents[1].Conditions.Get(conditionAccepted).Status)
+	require.Equal(t, "True", status.Parents[1].Conditions.Get(conditionResolvedRefs).Status)
+
+	// The status isn't written again if it's unchanged.
+	obj, err := client.Resource(controller.resource()).Namespace("default").Get("web", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, controller.Upsert("default/web", obj))
+	require.Equal(t, status, routeStatus(t, client, controller, "default", "web"))
+}
+
+func TestRouteController_UpsertTCPRoute(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	gw := gateway("gw")
+	gw.Spec.Listeners = append(gw.Spec.Listeners, gatewayapi.Listener{Name: "tcp", Port: 5432, Protocol: gatewayapi.TCPProtocol})
+	route := &gatewayapi.TCPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", Generation: 1},
+		Spec: gatewayapi.TCPRouteSpec{
+			ParentRefs: []gatewayapi.ParentReference{{Name: "gw", SectionName: "tcp"}},
+			Rules:      []gatewayapi.TCPRouteRule{{BackendRefs: []gatewayapi.BackendRef{{Name: "postgres"}}}},
+		},
+	}
+	obj := toUnstructured(t, route)
+	client := newFakeDynamicClient(toUnstructured(t, gatewayClass("consul", GatewayControllerName)), toUnstructured(t, gw), obj)
+	controller := routeController(client, consulClient, gatewayapi.TCPRouteKind)
+
+	require.NoError(t, controller.Upsert("default/db", obj))
+	entry := consul.entry("", v1alpha1.TCPRouteKind, "db-default")
+	require.NotNil(t, entry)
+	require.Equal(t, []interface{}{map[string]interface{}{"Kind": v1alpha1.APIGatewayKind, "Name": "gw-default", "SectionName": "tcp"}}, entry["Parents"])
+	require.Equal(t, []interface{}{map[string]interface{}{"Name": "postgres"}}, entry["Services"])
+	status := routeStatus(t, client, controller, "default", "db")
+	require.Equal(t, "True", status.Parents[0].Conditions.Get(conditionAccepted).Status)
+}
+
+func TestRouteController_UpsertNotAccepted(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		routeNamespace string
+		parentRef      gatewayapi.ParentReference
+		rule           gatewayapi.HTTPRouteRule
+		expReason      string
+		expErr         string
+	}{
+		"unknown listener": {
+			parentRef: gatewayapi.ParentReference{Name: "gw", SectionName: "https"},
+			expReason: reasonNoMatchingParent,
+			expErr:    `gateway default/gw has no listener "https"`,
+		},
+		"incompatible listener": {
+			parentRef: gatewayapi.ParentReference{Name: "gw", SectionName: "tcp"},
+			expReason: reasonNotAllowedByListeners,
+			expErr:    "no listener of gateway default/gw allows the HTTPRoute",
+		},
+		"other namespace": {
+			routeNamespace: "other",
+			parentRef:      gatewayapi.ParentReference{Name: "gw", Namespace: "default", SectionName: "http"},
+			expReason:      reasonNotAllowedByListeners,
+			expErr:         "no listener of gateway default/gw allows the HTTPRoute",
+		},
+		"unsupported filter": {
+			parentRef: gatewayapi.ParentReference{Name: "gw"},
+			rule:      gatewayapi.HTTPRouteRule{Filters: []gatewayapi.HTTPRouteFilter{{Type: "RequestMirror"}}},
+			expReason: reasonUnsupportedValue,
+			expErr:    `spec.rules[0].filters[0]: filters of type "RequestMirror" aren't supported`,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			gw := gateway("gw")
+			gw.Spec.Listeners = append(gw.Spec.Listeners, gatewayapi.Listener{Name: "tcp", Port: 5432, Protocol: gatewayapi.TCPProtocol})
+			route := httpRoute("web", c.rule)
+			route.Spec.ParentRefs = []gatewayapi.ParentReference{c.parentRef}
+			if c.routeNamespace != "" {
+				route.Namespace = c.routeNamespace
+			}
+			obj := toUnstructured(t, route)
+			client := newFakeDynamicClient(toUnstructured(t, gatewayClass("consul", GatewayControllerName)), toUnstructured(t, gw), obj)
+			controller := routeController(client, consulClient, gatewayapi.HTTPRouteKind)
+
+			require.NoError(t, controller.Upsert("key", obj))
+			require.Nil(t, consul.entry("", v1alpha1.HTTPRouteKind, "web-"+route.Namespace))
+			status := routeStatus(t, client, controller, route.Namespace, "web")
+			require.Len(t, status.Parents, 1)
+			condition := status.Parents[0].Conditions.Get(conditionAccepted)
+			require.Equal(t, "False", condition.Status)
+			require.Equal(t, c.expReason, condition.Reason)
+			require.Equal(t, c.expErr, condition.Message)
+		})
+	}
+}
+
+func TestRouteController_UpsertInvalidBackend(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	route := httpRoute("web", gatewayapi.HTTPRouteRule{BackendRefs: []gatewayapi.BackendRef{
+		{Name: "web"},
+		{Name: "bucket", Group: "example.com", Kind: "Bucket"},
+	}})
+	obj := toUnstructured(t, route)
+	client := newFakeDynamicClient(toUnstructured(t, gatewayClass("consul", GatewayControllerName)), toUnstructured(t, gateway("gw")), obj)
+	controller := routeController(client, consulClient, gatewayapi.HTTPRouteKind)
+
+	require.NoError(t, controller.Upsert("default/web", obj))
+	// The route is written without the invalid backends.
+	entry := consul.entry("", v1alpha1.HTTPRouteKind, "web-default")
+	require.NotNil(t, entry)
+	services := entry["Rules"].([]interface{})[0].(map[string]interface{})["Services"]
+	require.Equal(t, []interface{}{map[string]interface{}{"Name": "web", "Weight": float64(1)}}, services)
+	condition := routeStatus(t, client, controller, "default", "web").Parents[0].Conditions.Get(conditionResolvedRefs)
+	require.Equal(t, "False", condition.Status)
+	require.Equal(t, reasonInvalidKind, condition.Reason)
+}
+
+// Test that the backends of other namespaces are only routed to if a
+// ReferenceGrant of their namespace permits it.
+func TestRouteController_UpsertCrossNamespaceBackend(t *testing.T) {
+	t.Parallel()
+	db := "db"
+	cases := map[string]struct {
+		grant      *gatewayapi.ReferenceGrantSpec
+		expGranted bool
+	}{
+		"no grant": {},
+		"granted service": {
+			grant: &gatewayapi.ReferenceGrantSpec{
+				From: []gatewayapi.ReferenceGrantFrom{{Group: gatewayapi.Group, Kind: gatewayapi.HTTPRouteKind, Namespace: "default"}},
+				To:   []gatewayapi.ReferenceGrantTo{{Kind: "Service", Name: &db}},
+			},
+			expGranted: true,
+		},
+		"granted services": {
+			grant: &gatewayapi.ReferenceGrantSpec{
+				From: []gatewayapi.ReferenceGrantFrom{{Group: gatewayapi.Group, Kind: gatewayapi.HTTPRouteKind, Namespace: "default"}},
+				To:   []gatewayapi.ReferenceGrantTo{{Group: "core", Kind: "Service"}},
+			},
+			expGranted: true,
+		},
+		"grant of another namespace": {
+			grant: &gatewayapi.ReferenceGrantSpec{
+				From: []gatewayapi.ReferenceGrantFrom{{Group: gatewayapi.Group, Kind: gatewayapi.HTTPRouteKind, Namespace: "other"}},
+				To:   []gatewayapi.ReferenceGrantTo{{Kind: "Service"}},
+			},
+		},
+		"grant of TCPRoutes": {
+			grant: &gatewayapi.ReferenceGrantSpec{
+				From: []gatewayapi.ReferenceGrantFrom{{Group: gatewayapi.Group, Kind: gatewayapi.TCPRouteKind, Namespace: "default"}},
+				To:   []gatewayapi.ReferenceGrantTo{{Kind: "Service"}},
+			},
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			route := httpRoute("web", gatewayapi.HTTPRouteRule{BackendRefs: []gatewayapi.BackendRef{
+				{Name: "web"},
+				{Name: "db", Namespace: "backend"},
+			}})
+			obj := toUnstructured(t, route)
+			objects := []*unstructured.Unstructured{
+				toUnstructured(t, gatewayClass("consul", GatewayControllerName)), toUnstructured(t, gateway("gw")), obj,
+			}
+			if c.grant != nil {
+				objects = append(objects, toUnstructured(t, &gatewayapi.ReferenceGrant{
+					ObjectMeta: metav1.ObjectMeta{Name: "routes", Namespace: "backend"},
+					Spec:       *c.grant,
+				}))
+			}
+			client := newFakeDynamicClient(objects...)
+			controller := routeController(client, consulClient, gatewayapi.HTTPRouteKind)
+
+			require.NoError(t, controller.Upsert("default/web", obj))
+			entry := consul.entry("", v1alpha1.HTTPRouteKind, "web-default")
+			require.NotNil(t, entry)
+			services := entry["Rules"].([]interface{})[0].(map[string]interface{})["Services"]
+			condition := routeStatus(t, client, controller, "default", "web").Parents[0].Conditions.Get(conditionResolvedRefs)
+			if c.expGranted {
+				require.Equal(t, []interface{}{
+					map[string]interface{}{"Name": "web", "Weight": float64(1)},
+					map[string]interface{}{"Name": "db", "Weight": float64(1)},
+				}, services)
+				require.Equal(t, "True", condition.Status)
+				return
+			}
+			require.Equal(t, []interface{}{map[string]interface{}{"Name": "web", "Weight": float64(1)}}, services)
+			require.Equal(t, "False", condition.Status)
+			require.Equal(t, reasonRefNotPermitted, condition.Reason)
+			require.Equal(t, `backend "db" of namespace "backend" isn't permitted by a ReferenceGrant`, condition.Message)
+		})
+	}
+}
+
+// Test that listeners selecting the namespaces of their routes only allow
+// the routes of the matching namespaces.
+func TestRouteController_UpsertNamespaceSelector(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		labels      map[string]string
+		expAccepted bool
+	}{
+		"matching namespace": {
+			labels:      map[string]string{"team": "web"},
+			expAccepted: true,
+		},
+		"other namespace": {
+			labels: map[string]string{"team": "db"},
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			gw := gateway("gw")
+			gw.Spec.Listeners[0].AllowedRoutes = &gatewayapi.AllowedRoutes{Namespaces: &gatewayapi.RouteNamespaces{
+				From:     gatewayapi.NamespacesFromSelector,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}},
+			}}
+			route := httpRoute("web", gatewayapi.HTTPRouteRule{BackendRefs: []gatewayapi.BackendRef{{Name: "web"}}})
+			route.Namespace = "frontend"
+			route.Spec.ParentRefs = []gatewayapi.ParentReference{{Name: "gw", Namespace: "default"}}
+			obj := toUnstructured(t, route)
+			client := newFakeDynamicClient(toUnstructured(t, gatewayClass("consul", GatewayControllerName)), toUnstructured(t, gw), obj)
+			controller := routeController(client, consulClient, gatewayapi.HTTPRouteKind)
+			controller.KubeClient = fake.NewSimpleClientset(&corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "frontend", Labels: c.labels},
+			})
+
+			require.NoError(t, controller.Upsert("frontend/web", obj))
+			condition := routeStatus(t, client, controller, "frontend", "web").Parents[0].Conditions.Get(conditionAccepted)
+			if c.expAccepted {
+				require.Equal(t, "True", condition.Status)
+				require.NotNil(t, consul.entry("", v1alpha1.HTTPRouteKind, "web-frontend"))
+				return
+			}
+			require.Equal(t, "False", condition.Status)
+			require.Equal(t, reasonNotAllowedByListeners, condition.Reason)
+			require.Nil(t, consul.entry("", v1alpha1.HTTPRouteKind, "web-frontend"))
+		})
+	}
+}
+
+func TestRouteController_Finalize(t *testing.T) {
+	t.Parallel()
+	consul, consulClient, stop := newFakeConsul(t)
+	defer stop()
+	obj := toUnstructured(t, httpRoute("web", gatewayapi.HTTPRouteRule{BackendRefs: []gatewayapi.BackendRef{{Name: "web"}}}))
+	client := newFakeDynamicClient(toUnstructured(t, gatewayClass("consul", GatewayControllerName)), toUnstructured(t, gateway("gw")), obj)
+	controller := routeController(client, consulClient, gatewayapi.HTTPRouteKind)
+	require.NoError(t, controller.Upsert("default/web", obj))
+	require.NotNil(t, consul.entry("", v1alpha1.HTTPRouteKind, "web-default"))
+
+	obj, err := client.Resource(controller.resource()).Namespace("default").Get("web", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.True(t, hasFinalizer(obj))
+	now := metav1.Now()
+	obj.SetDeletionTimestamp(&now)
+	require.NoError(t, controller.Upsert("default/web", obj))
+	require.Nil(t, consul.entry("", v1alpha1.HTTPRouteKind, "web-default"))
+	obj, err = client.Resource(controller.resource()).Namespace("default").Get("web", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.False(t, hasFinalizer(obj))
+}
+
+func TestTranslateHTTPRule(t *testing.T) {
+	weight := func(w int32) *int32 { return &w }
+	cases := map[string]struct {
+		rule   gatewayapi.HTTPRouteRule
+		exp    v1alpha1.HTTPRouteRule
+		expErr string
+	}{
+		"defaults": {
+			rule: gatewayapi.HTTPRouteRule{BackendRefs: []gatewayapi.BackendRef{{Name: "web"}}},
+			exp: v1alpha1.HTTPRouteRule{
+				Matches:  []v1alpha1.HTTPMatch{{Path: v1alpha1.HTTPPathMatch{Match: "prefix", Value: "/"}}},
+				Services: []v1alpha1.HTTPService{{Name: "web", Weight: 1}},
+			},
+		},
+		"matches": {
+			rule: gatewayapi.HTTPRouteRule{Matches: []gatewayapi.HTTPRouteMatch{{
+				Path:        &gatewayapi.HTTPPathMatch{Type: gatewayapi.PathMatchExact, Value: "/api"},
+				Headers:     []gatewayapi.HTTPHeaderMatch{{Name: "x-version", Value: "2"}},
+				QueryParams: []gatewayapi.HTTPQueryParamMatch{{Type: gatewayapi.MatchRegularExpression, Name: "debug", Value: ".*"}},
+				Method:      "GET",
+			}}},
+			exp: v1alpha1.HTTPRouteRule{
+				Matches: []v1alpha1.HTTPMatch{{
+					Method:  "GET",
+					Path:    v1alpha1.HTTPPathMatch{Match: "exact", Value: "/api"},
+					Headers: []v1alpha1.HTTPHeaderMatch{{Match: "exact", Name: "x-version", Value: "2"}},
+					Query:   []v1alpha1.HTTPQueryMatch{{Match: "regex", Name: "debug", Value: ".*"}},
+				}},
+			},
+		},
+		"filters": {
+			rule: gatewayapi.HTTPRouteRule{Filters: []gatewayapi.HTTPRouteFilter{
+				{
+					Type: gatewayapi.FilterRequestHeaderModifier,
+					RequestHeaderModifier: &gatewayapi.HTTPHeaderFilter{
+						Set:    []gatewayapi.HTTPHeader{{Name: "x-env", Value: "prod"}},
+						Add:    []gatewayapi.HTTPHeader{{Name: "x-gateway", Value: "consul"}},
+						Remove: []string{"x-debug"},
+					},
+				},
+				{
+					Type:       gatewayapi.FilterURLRewrite,
+					URLRewrite: &gatewayapi.HTTPURLRewriteFilter{Path: &gatewayapi.HTTPPathModifier{Type: "ReplacePrefixMatch", ReplacePrefixMatch: "/v2"}},
+				},
+			}},
+			exp: v1alpha1.HTTPRouteRule{
+				Matches: []v1alpha1.HTTPMatch{{Path: v1alpha1.HTTPPathMatch{Match: "prefix", Value: "/"}}},
+				Filters: v1alpha1.HTTPFilters{
+					Headers: []v1alpha1.HTTPHeaderFilter{{
+						Add:    map[string]string{"x-gateway": "consul"},
+						Remove: []string{"x-debug"},
+						Set:    map[string]string{"x-env": "prod"},
+					}},
+					URLRewrite: &v1alpha1.URLRewrite{Path: "/v2"},
+				},
+			},
+		},
+		"weights": {
+			rule: gatewayapi.HTTPRouteRule{BackendRefs: []gatewayapi.BackendRef{
+				{Name: "v1", Weight: weight(90)},
+				{Name: "v2", Weight: weight(10)},
+				{Name: "v3", Weight: weight(0)},
+				{Name: "other", Namespace: "other"},
+			}},
+			exp: v1alpha1.HTTPRouteRule{
+				Matches:  []v1alpha1.HTTPMatch{{Path: v1alpha1.HTTPPathMatch{Match: "prefix", Value: "/"}}},
+				Services: []v1alpha1.HTTPService{{Name: "v1", Weight: 90}, {Name: "v2", Weight: 10}},
+			},
+		},
+		"full path rewrite": {
+			rule: gatewayapi.HTTPRouteRule{Filters: []gatewayapi.HTTPRouteFilter{{
+				Type:       gatewayapi.FilterURLRewrite,
+				URLRewrite: &gatewayapi.HTTPURLRewriteFilter{Path: &gatewayapi.HTTPPathModifier{Type: "ReplaceFullPath"}},
+			}}},
+			expErr: "spec.rules[0].filters[0].urlRewrite only supports replacing the prefix of the path",
+		},
+		"unsupported match type": {
+			rule: gatewayapi.HTTPRouteRule{Matches: []gatewayapi.HTTPRouteMatch{{
+				Path: &gatewayapi.HTTPPathMatch{Type: "Glob", Value: "/*"},
+			}}},
+			expErr: `spec.rules[0].matches[0].path.type "Glob" isn't supported`,
+		},
+		"extension filters": {
+			rule: gatewayapi.HTTPRouteRule{Filters: []gatewayapi.HTTPRouteFilter{
+				extensionRef(v1alpha1.RouteTimeoutFilterKind, "timeout"),
+				extensionRef(v1alpha1.RouteRetryFilterKind, "retry"),
+				extensionRef(v1alpha1.RouteAuthFilterKind, "auth"),
+			}},
+			exp: v1alpha1.HTTPRouteRule{
+				Matches: []v1alpha1.HTTPMatch{{Path: v1alpha1.HTTPPathMatch{Match: "prefix", Value: "/"}}},
+				Filters: v1alpha1.HTTPFilters{
+					TimeoutFilter: &v1alpha1.HTTPTimeoutFilter{RequestTimeout: "5s"},
+					RetryFilter:   &v1alpha1.RetryFilter{NumRetries: 2, RetryOn: []string{"reset"}},
+					JWT:           &v1alpha1.JWTFilter{Providers: []v1alpha1.JWTFilterProvider{{Name: "okta"}}},
+				},
+			},
+		},
+		"duplicate extension filter": {
+			rule: gatewayapi.HTTPRouteRule{Filters: []gatewayapi.HTTPRouteFilter{
+				extensionRef(v1alpha1.RouteTimeoutFilterKind, "timeout"),
+				extensionRef(v1alpha1.RouteTimeoutFilterKind, "timeout"),
+			}},
+			expErr: "spec.rules[0].filters[1]: the rule has more than one RouteTimeoutFilter filter",
+		},
+		"unresolved extension filter": {
+			rule:   gatewayapi.HTTPRouteRule{Filters: []gatewayapi.HTTPRouteFilter{extensionRef(v1alpha1.RouteTimeoutFilterKind, "missing")}},
+			expErr: `spec.rules[0].filters[0]: RouteTimeoutFilter "missing" doesn't exist`,
+		},
+	}
+	extensions := map[string]interface{}{
+		"timeout": &v1alpha1.RouteTimeoutFilter{Spec: v1alpha1.RouteTimeoutFilterSpec{RequestTimeout: metav1.Duration{Duration: 5 * time.Second}}},
+		"retry":   &v1alpha1.RouteRetryFilter{Spec: v1alpha1.RouteRetryFilterSpec{NumRetries: 2, RetryOn: []string{"reset"}}},
+		"auth": &v1alpha1.RouteAuthFilter{Spec: v1alpha1.RouteAuthFilterSpec{JWT: v1alpha1.RouteJWTRequirement{
+			Providers: []v1alpha1.RouteJWTProvider{{Name: "okta"}},
+		}}},
+	}
+	resolve := func(ref gatewayapi.LocalObjectReference) (interface{}, error) {
+		if extension, ok := extensions[ref.Name]; ok {
+			return extension, nil
+		}
+		return nil, fmt.Errorf("%s %q doesn't exist", ref.Kind, ref.Name)
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			rule, err := translateHTTPRule(&route{namespace: "default"}, c.rule, "spec.rules[0]", resolve)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, rule)
+		})
+	}
+}
+
+func TestRouteController_UpsertExtensionRef(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		filter    gatewayapi.HTTPRouteFilter
+		expReason string
+		expErr    string
+	}{
+		"valid": {
+			filter: extensionRef(v1alpha1.RouteRetryFilterKind, "retries"),
+		},
+		"missing": {
+			filter:    extensionRef(v1alpha1.RouteRetryFilterKind, "missing"),
+			expReason: reasonInvalidFilter,
+			expErr:    `spec.rules[0].filters[0]: RouteRetryFilter "missing" doesn't exist`,
+		},
+		"invalid": {
+			filter:    extensionRef(v1alpha1.RouteRetryFilterKind, "invalid-retries"),
+			expReason: reasonInvalidFilter,
+			expErr:    `spec.rules[0].filters[0]: RouteRetryFilter "invalid-retries": spec.retryOn[0]: "always" isn't a supported retry condition`,
+		},
+		"unsupported kind": {
+			filter: gatewayapi.HTTPRouteFilter{
+				Type:         gatewayapi.FilterExtensionRef,
+				ExtensionRef: &gatewayapi.LocalObjectReference{Group: "example.com", Kind: "RateLimit", Name: "limit"},
+			},
+			expReason: reasonUnsupportedValue,
+			expErr:    "spec.rules[0].filters[0]: extensionRef filters of kind RateLimit.example.com aren't supported",
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			obj := toUnstructured(t, httpRoute("web", gatewayapi.HTTPRouteRule{
+				Filters:     []gatewayapi.HTTPRouteFilter{c.filter},
+				BackendRefs: []gatewayapi.BackendRef{{Name: "web"}},
+			}))
+			client := newFakeDynamicClient(
+				toUnstructured(t, gatewayClass("consul", GatewayControllerName)),
+				toUnstructured(t, gateway("gw")),
+				toUnstructured(t, &v1alpha1.RouteRetryFilter{
+					ObjectMeta: metav1.ObjectMeta{Name: "retries", Namespace: "default"},
+					Spec:       v1alpha1.RouteRetryFilterSpec{NumRetries: 3, RetryOnStatusCodes: []int32{503}},
+				}),
+				toUnstructured(t, &v1alpha1.RouteRetryFilter{
+					ObjectMeta: metav1.ObjectMeta{Name: "invalid-retries", Namespace: "default"},
+					Spec:       v1alpha1.RouteRetryFilterSpec{RetryOn: []string{"always"}},
+				}),
+				obj)
+			controller := routeController(client, consulClient, gatewayapi.HTTPRouteKind)
+
+			require.NoError(t, controller.Upsert("default/web", obj))
+			condition := routeStatus(t, client, controller, "default", "web").Parents[0].Conditions.Get(conditionAccepted)
+			entry := consul.entry("", v1alpha1.HTTPRouteKind, "web-default")
+			if c.expErr == "" {
+				require.Equal(t, "True", condition.Status)
+				require.NotNil(t, entry)
+				filters := entry["Rules"].([]interface{})[0].(map[string]interface{})["Filters"]
+				require.Equal(t, map[string]interface{}{
+					"RetryFilter": map[string]interface{}{"NumRetries": float64(3), "RetryOnStatusCodes": []interface{}{float64(503)}},
+				}, filters)
+				return
+			}
+			require.Nil(t, entry)
+			require.Equal(t, "False", condition.Status)
+			require.Equal(t, c.expReason, condition.Reason)
+			require.Equal(t, c.expErr, condition.Message)
+		})
+	}
+}
+
+func routeController(client *fakeDynamicClient, consulClient *api.Client, kind string) *RouteController {
+	return &RouteController{
+		Log:          hclog.NewNullLogger(),
+		Client:       client,
+		KubeClient:   fake.NewSimpleClientset(),
+		ConsulClient: consulClient,
+		Kind:         kind,
+	}
+}
+
+func extensionRef(kind, name string) gatewayapi.HTTPRouteFilter {
+	return gatewayapi.HTTPRouteFilter{
+		Type:         gatewayapi.FilterExtensionRef,
+		ExtensionRef: &gatewayapi.LocalObjectReference{Group: v1alpha1.GroupVersion.Group, Kind: kind, Name: name},
+	}
+}
+
+func httpRoute(name string, rule gatewayapi.HTTPRouteRule) *gatewayapi.HTTPRoute {
+	return &gatewayapi.HTTPRoute{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Generation: 1},
+		Spec: gatewayapi.HTTPRouteSpec{
+			ParentRefs: []gatewayapi.ParentReference{{Name: "gw"}},
+			Rules:      []gatewayapi.HTTPRouteRule{rule},
+		},
+	}
+}
+
+func routeStatus(t *testing.T, client *fakeDynamicClient, controller *RouteController, namespace, name string) gatewayapi.RouteStatus {
+	obj, err := client.Resource(controller.resource()).Namespace(namespace).Get(name, metav1.GetOptions{})
+	require.NoError(t, err)
+	route := &gatewayapi.HTTPRoute{}
+	require.NoError(t, decode(obj, route))
+	return route.Status
+}
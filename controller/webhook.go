@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mattbaird/jsonpatch"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+// consulNameIndex indexes the cached resources of a ValidatingWebhook by
+// the name of their config entry.
+const consulNameIndex = "consulName"
+
+// ValidatingWebhook is the admission webhook that rejects the resources
+// of a ConfigEntryController that are invalid or that would write the
+// same config entry as another resource. Several ServiceIntentions
+// resources can have the same destination for example, and they would
+// overwrite each other's config entry.
+//
+// Consul has no dry-run of config entry writes, so the webhook also
+// checks the references to other config entries that Consul validates
+// when the entry is written: the subsets referenced by the resources
+// implementing v1alpha1.SubsetReferencingResource must be defined by the
+// service-resolver of their service.
+//
+// The webhook checks the resources against a cache of the resources of
+// the kind in all namespaces, which Run keeps up to date.
+type ValidatingWebhook struct {
+	Log hclog.Logger
+
+	// Controller is the controller of the resources.
+	Controller *ConfigEntryController
+
+	// Resolvers is the webhook of the ServiceResolver resources. If it's
+	// set, the referenced subsets are checked against the resolver
+	// resources in its cache, which are written to Consul shortly, and
+	// only against Consul for the services without one.
+	Resolvers *ValidatingWebhook
+
+	once     sync.Once
+	informer cache.SharedIndexInformer
+}
+
+// Run caches the resources until stopCh is closed.
+func (w *ValidatingWebhook) Run(stopCh <-chan struct{}) {
+	w.resources().Run(stopCh)
+}
+
+// HasSynced returns whether the cache of the resources has synced. The
+// resources are denied until it has.
+func (w *ValidatingWebhook) HasSynced() bool {
+	return w.resources().HasSynced()
+}
+
+// resources returns the informer caching the resources, indexed by the
+// name of their config entry.
+func (w *ValidatingWebhook) resources() cache.SharedIndexInformer {
+	w.once.Do(func() {
+		c := w.Controller
+		w.informer = newInformer(c.Client, c.Resource, "", 0)
+		w.informer.AddIndexers(cache.Indexers{consulNameIndex: func(obj interface{}) ([]string, error) {
+			resource := c.New()
+			if err := decode(obj.(*unstructured.Unstructured), resource); err != nil {
+				// Resources that can't be decoded write no config entry.
+				return nil, nil
+			}
+			return []string{resource.ConsulName()}, nil
+		}})
+	})
+	return w.informer
+}
+
+// cached returns the cached resources writing the config entry name.
+func (w *ValidatingWebhook) cached(name string) ([]v1alpha1.ConfigEntryResource, error) {
+	items, err := w.resources().GetIndexer().ByIndex(consulNameIndex, name)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]v1alpha1.ConfigEntryResource, 0, len(items))
+	for _, item := range items {
+		resource := w.Controller.New()
+		if err := decode(item.(*unstructured.Unstructured), resource); err == nil {
+			resources = append(resources, resource)
+		}
+	}
+	return resources, nil
+}
+
+// Handle is the http.HandlerFunc implementation of the webhook.
+func (w *ValidatingWebhook) Handle(rw http.ResponseWriter, r *http.Request) {
+	serveAdmission(w.Log, rw, r, w.Validate)
+}
+
+// Validate returns whether the resource of the admission request is
+// allowed.
+func (w *ValidatingWebhook) Validate(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	if req.Operation == v1beta1.Delete {
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	c := w.Controller
+	resource := c.New()
+	if err := json.Unmarshal(req.Object.Raw, resource); err != nil {
+		return denied(fmt.Sprintf("decoding resource: %s", err))
+	}
+	if err := resource.Validate(); err != nil {
+		return denied(err.Error())
+	}
+
+	// The request's namespace is set even if the object's isn't yet.
+	if resource.GetNamespace() == "" {
+		resource.SetNamespace(req.Namespace)
+	}
+	target, err := c.target(resource)
+	if err != nil {
+		return denied(err.Error())
+	}
+	if !w.HasSynced() {
+		return denied(fmt.Sprintf("the %s aren't cached yet, try again", c.Resource.Resource))
+	}
+	others, err := w.cached(resource.ConsulName())
+	if err != nil {
+		w.Log.Error("error reading cached resources", "err", err)
+		return denied(fmt.Sprintf("reading cached %s: %s", c.Resource.Resource, err))
+	}
+	for _, other := range others {
+		if other.GetNamespace() == req.Namespace && other.GetName() == req.Name {
+			continue
+		}
+		otherTarget, err := c.target(other)
+		if err == nil && otherTarget == target {
+			return denied(fmt.Sprintf("%s config entry %q is already managed by %s %s/%s",
+				resource.ConsulKind(), resource.ConsulName(), c.Resource.Resource, other.GetNamespace(), other.GetName()))
+		}
+	}
+
+	if referencing, ok := resource.(v1alpha1.SubsetReferencingResource); ok {
+		if err := w.checkSubsetRefs(referencing, target); err != nil {
+			return denied(err.Error())
+		}
+	}
+	return &v1beta1.AdmissionResponse{Allowed: true}
+}
+
+// checkSubsetRefs returns an error if a subset referenced by the resource
+// isn't defined by the service-resolver of its service. A resolver's
+// references to its own service are checked against the resource itself.
+func (w *ValidatingWebhook) checkSubsetRefs(resource v1alpha1.SubsetReferencingResource, target v1alpha1.ConsulTarget) error {
+	c := w.Controller
+	for _, ref := range resource.SubsetRefs() {
+		namespace := ref.Namespace
+		if namespace == "" || !c.EnableConsulNamespaces {
+			namespace = target.Namespace
+		}
+
+		var resolver *api.ServiceResolverConfigEntry
+		if resource.ConsulKind() == api.ServiceResolver && ref.Service == resource.ConsulName() && namespace == target.Namespace {
+			resolver, _ = resource.ToConsul(target.Namespace).(*api.ServiceResolverConfigEntry)
+		} else {
+			var err error
+			resolver, err = w.resolver(ref.Service, v1alpha1.ConsulTarget{Namespace: namespace, Partition: target.Partition})
+			if err != nil {
+				w.Log.Error("error reading service-resolver", "service", ref.Service, "err", err)
+				return fmt.Errorf("%s: reading service-resolver %q: %s", ref.Path, ref.Service, err)
+			}
+		}
+		if resolver == nil {
+			return fmt.Errorf("%s: subset %q of service %q is not defined since the service has no service-resolver", ref.Path, ref.Subset, ref.Service)
+		}
+		if _, ok := resolver.Subsets[ref.Subset]; !ok {
+			return fmt.Errorf("%s: subset %q is not defined by the service-resolver of service %q", ref.Path, ref.Subset, ref.Service)
+		}
+	}
+	return nil
+}
+
+// resolver returns the service-resolver of service in target: the one of
+// the cached ServiceResolver resource writing it if there's one, and the
+// one in Consul otherwise. It's nil if the service has none.
+func (w *ValidatingWebhook) resolver(service string, target v1alpha1.ConsulTarget) (*api.ServiceResolverConfigEntry, error) {
+	if r := w.Resolvers; r != nil && r.HasSynced() {
+		resources, err := r.cached(service)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resources {
+			if resourceTarget, err := r.Controller.target(resource); err == nil && resourceTarget == target {
+				resolver, _ := resource.ToConsul(target.Namespace).(*api.ServiceResolverConfigEntry)
+				return resolver, nil
+			}
+		}
+	}
+	entry, err := w.Controller.readConfigEntry(api.ServiceResolver, service, nil, target)
+	if err != nil {
+		return nil, err
+	}
+	resolver, _ := entry.(*api.ServiceResolverConfigEntry)
+	return resolver, nil
+}
+
+func denied(message string) *v1beta1.AdmissionResponse {
+	return &v1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Message: message,
+		},
+	}
+}
+
+// DefaultingWebhook is the admission webhook that sets the defaults of
+// resources implementing v1alpha1.Defaulter so that they're visible in
+// Kubernetes. The controllers apply the same defaults if the webhook
+// isn't installed.
+type DefaultingWebhook struct {
+	Log hclog.Logger
+
+	// New returns an empty resource of the webhook's kind.
+	New func() v1alpha1.ConfigEntryResource
+}
+
+// Handle is the http.HandlerFunc implementation of the webhook.
+func (w *DefaultingWebhook) Handle(rw http.ResponseWriter, r *http.Request) {
+	serveAdmission(w.Log, rw, r, w.Default)
+}
+
+// Default returns the admission response patching the spec of the
+// request's resource with its defaults.
+func (w *DefaultingWebhook) Default(req *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	if req.Operation == v1beta1.Delete {
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+	resource := w.New()
+	if err := json.Unmarshal(req.Object.Raw, resource); err != nil {
+		return denied(fmt.Sprintf("decoding resource: %s", err))
+	}
+	defaulter, ok := resource.(v1alpha1.Defaulter)
+	if !ok {
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+	defaulter.Default()
+
+	// Only the spec is patched so that fields that the typed resource
+	// doesn't have are left untouched.
+	var original, defaulted struct {
+		Spec interface{} `json:"spec"`
+	}
+	data, err := json.Marshal(resource)
+	if err == nil {
+		err = json.Unmarshal(data, &defaulted)
+	}
+	if err == nil {
+		err = json.Unmarshal(req.Object.Raw, &original)
+	}
+	if err != nil {
+		return denied(fmt.Sprintf("defaulting resource: %s", err))
+	}
+	if reflect.DeepEqual(original.Spec, defaulted.Spec) {
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	operation := "replace"
+	if original.Spec == nil {
+		operation = "add"
+	}
+	patch, err := json.Marshal([]jsonpatch.JsonPatchOperation{{
+		Operation: operation,
+		Path:      "/spec",
+		Value:     defaulted.Spec,
+	}})
+	if err != nil {
+		return denied(fmt.Sprintf("marshalling patch: %s", err))
+	}
+	patchType := v1beta1.PatchTypeJSONPatch
+	return &v1beta1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+	}
+}
+
+// serveAdmission decodes the admission review of the request and writes
+// the review with the response of handler.
+func serveAdmission(log hclog.Logger, rw http.ResponseWriter, r *http.Request,
+	handler func(*v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+		msg := fmt.Sprintf("Invalid content-type: %q", ct)
+		http.Error(rw, msg, http.StatusBadRequest)
+		log.Error("Error on request", "err", msg, "Code", http.StatusBadRequest)
+		return
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			msg := fmt.Sprintf("Error reading request body: %s", err)
+			http.Error(rw, msg, http.StatusBadRequest)
+			log.Error("Error on request", "err", msg, "Code", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var review v1beta1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		msg := "Could not decode admission request"
+		http.Error(rw, msg, http.StatusBadRequest)
+		log.Error("Error on request", "err", msg, "Code", http.StatusBadRequest)
+		return
+	}
+	review.Response = handler(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	resp, err := json.Marshal(&review)
+	if err != nil {
+		msg := fmt.Sprintf("Error marshalling admission response: %s", err)
+		http.Error(rw, msg, http.StatusInternalServerError)
+		log.Error("Error on request", "err", msg, "Code", http.StatusInternalServerError)
+		return
+	}
+	if _, err := rw.Write(resp); err != nil {
+		log.Error("Error writing response", "err", err)
+	}
+}
@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestValidatingWebhook_Validate(t *testing.T) {
+	t.Parallel()
+	existing := serviceIntentions("web-intentions", "default", "web")
+	cases := map[string]struct {
+		namespace  string
+		operation  v1beta1.Operation
+		resource   interface{}
+		mirroring  bool
+		expAllowed bool
+		expMessage string
+	}{
+		"new destination": {
+			namespace:  "default",
+			resource:   serviceIntentions("api-intentions", "default", "api"),
+			expAllowed: true,
+		},
+		"update of the existing resource": {
+			namespace:  "default",
+			operation:  v1beta1.Update,
+			resource:   existing,
+			expAllowed: true,
+		},
+		"duplicate destination": {
+			namespace:  "default",
+			resource:   serviceIntentions("other-intentions", "default", "web"),
+			expMessage: `service-intentions config entry "web" is already managed by serviceintentions default/web-intentions`,
+		},
+		"duplicate destination in another namespace": {
+			namespace:  "other",
+			resource:   serviceIntentions("web-intentions", "other", "web"),
+			expMessage: `service-intentions config entry "web" is already managed by serviceintentions default/web-intentions`,
+		},
+		"same destination in another mirrored namespace": {
+			namespace:  "other",
+			resource:   serviceIntentions("web-intentions", "other", "web"),
+			mirroring:  true,
+			expAllowed: true,
+		},
+		"same destination targeting the existing namespace": {
+			namespace:  "other",
+			resource:   targetedIntentions(serviceIntentions("web-intentions", "other", "web"), "default"),
+			mirroring:  true,
+			expMessage: `service-intentions config entry "web" is already managed by serviceintentions default/web-intentions`,
+		},
+		"target not allowed": {
+			namespace:  "other",
+			resource:   targetedIntentions(serviceIntentions("web-intentions", "other", "web"), "billing"),
+			mirroring:  true,
+			expMessage: `spec.namespace: resources in namespace "other" aren't allowed to target Consul namespace "billing"`,
+		},
+		"invalid resource": {
+			namespace:  "default",
+			resource:   serviceIntentions("invalid-intentions", "default", ""),
+			expMessage: "spec.destination.name must be set",
+		},
+		"delete": {
+			namespace:  "default",
+			operation:  v1beta1.Delete,
+			resource:   serviceIntentions("other-intentions", "default", "web"),
+			expAllowed: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			controller := serviceIntentionsController(newFakeDynamicClient(toUnstructured(t, existing)))
+			controller.EnableConsulNamespaces = c.mirroring
+			controller.EnableNSMirroring = c.mirroring
+			controller.NamespaceTargets = []TargetRule{{KubeNamespace: "other", Target: "default"}}
+			webhook := &ValidatingWebhook{Log: hclog.NewNullLogger(), Controller: controller}
+			defer runWebhook(t, webhook)()
+
+			obj := toUnstructured(t, c.resource)
+			raw, err := obj.MarshalJSON()
+			require.NoError(t, err)
+			operation := c.operation
+			if operation == "" {
+				operation = v1beta1.Create
+			}
+			resp := webhook.Validate(&v1beta1.AdmissionRequest{
+				Name:      obj.GetName(),
+				Namespace: c.namespace,
+				Operation: operation,
+				Object:    runtime.RawExtension{Raw: raw},
+			})
+			require.Equal(t, c.expAllowed, resp.Allowed)
+			if c.expMessage != "" {
+				require.Equal(t, c.expMessage, resp.Result.Message)
+			}
+		})
+	}
+}
+
+// Test that a resource isn't validated before the cache has synced.
+func TestValidatingWebhook_Validate_NotSynced(t *testing.T) {
+	t.Parallel()
+	controller := serviceIntentionsController(newFakeDynamicClient())
+	webhook := &ValidatingWebhook{Log: hclog.NewNullLogger(), Controller: controller}
+
+	raw, err := toUnstructured(t, serviceIntentions("web-intentions", "default", "web")).MarshalJSON()
+	require.NoError(t, err)
+	resp := webhook.Validate(&v1beta1.AdmissionRequest{
+		Name:      "web-intentions",
+		Namespace: "default",
+		Operation: v1beta1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	})
+	require.False(t, resp.Allowed)
+	require.Equal(t, "the serviceintentions aren't cached yet, try again", resp.Result.Message)
+}
+
+// Test that the subsets referenced by a resource must be defined by the
+// service-resolver resources, or by the service-resolvers in Consul for the
+// services without one.
+func TestValidatingWebhook_SubsetRefs(t *testing.T) {
+	t.Parallel()
+	splitter := func(service, subset string) *v1alpha1.ServiceSplitter {
+		resource := &v1alpha1.ServiceSplitter{
+			Spec: v1alpha1.ServiceSplitterSpec{Splits: []v1alpha1.ServiceSplit{
+				{Weight: 50},
+				{Weight: 50, Service: service, ServiceSubset: subset},
+			}},
+		}
+		resource.Name = "web"
+		resource.Namespace = "default"
+		return resource
+	}
+	resolver := &v1alpha1.ServiceResolver{
+		Spec: v1alpha1.ServiceResolverSpec{
+			Subsets: map[string]v1alpha1.ServiceResolverSubset{"v1": {}, "v2": {}},
+			Failover: map[string]v1alpha1.ServiceResolverFailover{
+				"v1": {Service: "web", ServiceSubset: "v2"},
+			},
+		},
+	}
+	resolver.Name = "web"
+	resolver.Namespace = "default"
+	// The resolver of api is in Kubernetes and not yet in Consul.
+	apiResolver := &v1alpha1.ServiceResolver{
+		Spec: v1alpha1.ServiceResolverSpec{
+			Subsets: map[string]v1alpha1.ServiceResolverSubset{"v2": {}},
+		},
+	}
+	apiResolver.Name = "api"
+	apiResolver.Namespace = "default"
+
+	cases := map[string]struct {
+		resource   v1alpha1.ConfigEntryResource
+		expMessage string
+	}{
+		"defined subset": {
+			resource: splitter("db", "v1"),
+		},
+		"subset of the splitter's service": {
+			resource:   splitter("", "v1"),
+			expMessage: `spec.splits[1]: subset "v1" of service "web" is not defined since the service has no service-resolver`,
+		},
+		"undefined subset": {
+			resource:   splitter("db", "v2"),
+			expMessage: `spec.splits[1]: subset "v2" is not defined by the service-resolver of service "db"`,
+		},
+		"subset of a resolver resource": {
+			resource: splitter("api", "v2"),
+		},
+		"undefined subset of a resolver resource": {
+			resource:   splitter("api", "v1"),
+			expMessage: `spec.splits[1]: subset "v1" is not defined by the service-resolver of service "api"`,
+		},
+		"resolver's own subset": {
+			resource: resolver,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			consul, consulClient, stop := newFakeConsul(t)
+			defer stop()
+			consul.entries["/service-resolver/db"] = map[string]interface{}{
+				"Kind":    "service-resolver",
+				"Name":    "db",
+				"Subsets": map[string]interface{}{"v1": map[string]interface{}{}},
+			}
+			resource := c.resource
+			controller := &ConfigEntryController{
+				Log:          hclog.NewNullLogger(),
+				Client:       newFakeDynamicClient(),
+				ConsulClient: consulClient,
+				New: func() v1alpha1.ConfigEntryResource {
+					if resource.ConsulKind() == "service-resolver" {
+						return &v1alpha1.ServiceResolver{}
+					}
+					return &v1alpha1.ServiceSplitter{}
+				},
+			}
+			resolvers := &ValidatingWebhook{
+				Log: hclog.NewNullLogger(),
+				Controller: &ConfigEntryController{
+					Log:          hclog.NewNullLogger(),
+					Client:       newFakeDynamicClient(toUnstructured(t, apiResolver)),
+					ConsulClient: consulClient,
+					New:          func() v1alpha1.ConfigEntryResource { return &v1alpha1.ServiceResolver{} },
+				},
+			}
+			defer runWebhook(t, resolvers)()
+			webhook := &ValidatingWebhook{Log: hclog.NewNullLogger(), Controller: controller, Resolvers: resolvers}
+			defer runWebhook(t, webhook)()
+
+			raw, err := json.Marshal(resource)
+			require.NoError(t, err)
+			resp := webhook.Validate(&v1beta1.AdmissionRequest{
+				Name:      resource.GetName(),
+				Namespace: "default",
+				Operation: v1beta1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			})
+			if c.expMessage == "" {
+				require.True(t, resp.Allowed, resp.Result)
+			} else {
+				require.False(t, resp.Allowed)
+				require.Equal(t, c.expMessage, resp.Result.Message)
+			}
+		})
+	}
+}
+
+func TestValidatingWebhook_Handle(t *testing.T) {
+	t.Parallel()
+	controller := serviceIntentionsController(newFakeDynamicClient())
+	webhook := &ValidatingWebhook{Log: hclog.NewNullLogger(), Controller: controller}
+	defer runWebhook(t, webhook)()
+
+	raw, err := toUnstructured(t, serviceIntentions("web-intentions", "default", "web")).MarshalJSON()
+	require.NoError(t, err)
+	body, err := json.Marshal(&v1beta1.AdmissionReview{
+		Request: &v1beta1.AdmissionRequest{
+			UID:       types.UID("uid"),
+			Name:      "web-intentions",
+			Namespace: "default",
+			Operation: v1beta1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/validate/serviceintentions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	webhook.Handle(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var review v1beta1.AdmissionReview
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &review))
+	require.Equal(t, types.UID("uid"), review.Response.UID)
+	require.True(t, review.Response.Allowed)
+
+	// Requests that aren't JSON are rejected.
+	req = httptest.NewRequest(http.MethodPost, "/validate/serviceintentions", bytes.NewReader(body))
+	rec = httptest.NewRecorder()
+	webhook.Handle(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestDefaultingWebhook_Default(t *testing.T) {
+	t.Parallel()
+	webhook := &DefaultingWebhook{
+		Log: hclog.NewNullLogger(),
+		New: func() v1alpha1.ConfigEntryResource { return &v1alpha1.IngressGateway{} },
+	}
+	request := func(t *testing.T, listeners []v1alpha1.IngressListenerSpec) *v1beta1.AdmissionRequest {
+		resource := &v1alpha1.IngressGateway{Spec: v1alpha1.IngressGatewaySpec{Listeners: listeners}}
+		resource.Name = "ingress-gateway"
+		raw, err := json.Marshal(resource)
+		require.NoError(t, err)
+		return &v1beta1.AdmissionRequest{Operation: v1beta1.Create, Object: runtime.RawExtension{Raw: raw}}
+	}
+
+	// The protocol of the listener is defaulted.
+	resp := webhook.Default(request(t, []v1alpha1.IngressListenerSpec{{Port: 8080}}))
+	require.True(t, resp.Allowed)
+	require.NotNil(t, resp.PatchType)
+	var patch []map[string]interface{}
+	require.NoError(t, json.Unmarshal(resp.Patch, &patch))
+	require.Len(t, patch, 1)
+	require.Equal(t, "replace", patch[0]["op"])
+	require.Equal(t, "/spec", patch[0]["path"])
+	require.Equal(t, map[string]interface{}{
+		"tls": map[string]interface{}{},
+		"listeners": []interface{}{
+			map[string]interface{}{"port": 8080.0, "protocol": "tcp"},
+		},
+	}, patch[0]["value"])
+
+	// Resources with defaults aren't patched.
+	resp = webhook.Default(request(t, []v1alpha1.IngressListenerSpec{{Port: 8080, Protocol: "http"}}))
+	require.True(t, resp.Allowed)
+	require.Nil(t, resp.Patch)
+}
+
+// targetedIntentions sets the Consul namespace of the resource's spec.
+// runWebhook runs the cache of the webhook until the returned function is
+// called, and waits for it to sync.
+func runWebhook(t *testing.T, webhook *ValidatingWebhook) func() {
+	stopCh := make(chan struct{})
+	go webhook.Run(stopCh)
+	require.True(t, cache.WaitForCacheSync(stopCh, webhook.HasSynced))
+	return func() { close(stopCh) }
+}
+
+func targetedIntentions(resource *v1alpha1.ServiceIntentions, namespace string) *v1alpha1.ServiceIntentions {
+	resource.Spec.Namespace = namespace
+	return resource
+}
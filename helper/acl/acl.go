@@ -0,0 +1,51 @@
+// Package acl has the helpers shared by the commands that log in with the
+// Kubernetes auth method and keep their ACL tokens renewed.
+package acl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// DefaultBearerTokenFile is where Kubernetes mounts the pod's service
+// account token.
+const DefaultBearerTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// TokenNeedsRenewal returns true if tok expires within the last third of
+// its lifetime. Tokens without an expiration time never need renewal.
+func TokenNeedsRenewal(tok *api.ACLToken, now time.Time) bool {
+	if tok == nil || tok.ExpirationTime == nil {
+		return false
+	}
+	ttl := tok.ExpirationTime.Sub(tok.CreateTime)
+	return tok.ExpirationTime.Sub(now) < ttl/3
+}
+
+// WriteFileAtomic writes data to a temporary file and renames it to path
+// so that readers never see a partially written file, e.g. a token sink or
+// an agent config with the token. This also allows replacing a read-only
+// file written by another user, such as the init container, as long as the
+// directory is writable.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,73 @@
+package acl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that we want to renew tokens only in the last third of their lifetime.
+func TestTokenNeedsRenewal(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	expiresIn := func(d time.Duration) *time.Time {
+		exp := now.Add(d)
+		return &exp
+	}
+	cases := map[string]struct {
+		Token *api.ACLToken
+		Exp   bool
+	}{
+		"nil token": {
+			Token: nil,
+			Exp:   false,
+		},
+		"no expiration": {
+			Token: &api.ACLToken{CreateTime: now.Add(-1 * time.Hour)},
+			Exp:   false,
+		},
+		"fresh token": {
+			Token: &api.ACLToken{CreateTime: now.Add(-10 * time.Minute), ExpirationTime: expiresIn(50 * time.Minute)},
+			Exp:   false,
+		},
+		"close to expiring": {
+			Token: &api.ACLToken{CreateTime: now.Add(-50 * time.Minute), ExpirationTime: expiresIn(10 * time.Minute)},
+			Exp:   true,
+		},
+		"expired": {
+			Token: &api.ACLToken{CreateTime: now.Add(-2 * time.Hour), ExpirationTime: expiresIn(-1 * time.Hour)},
+			Exp:   true,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.Exp, TokenNeedsRenewal(c.Token, now))
+		})
+	}
+}
+
+// Test that we can replace a read-only token sink file.
+func TestWriteFileAtomic(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "acl-token")
+	require.NoError(t, ioutil.WriteFile(path, []byte("old"), 0444))
+	require.NoError(t, WriteFileAtomic(path, []byte("new"), 0444))
+
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(contents))
+
+	files, err := ioutil.ReadDir(tmpDir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, os.FileMode(0444), files[0].Mode().Perm())
+}
@@ -0,0 +1,149 @@
+// Package audit logs the writes of the components of consul-k8s to Consul,
+// e.g. the catalog registrations, the config entry writes, which include
+// the intentions, and the ACL changes, for compliance reviews. Each write
+// is appended to the audit log as a JSON object on its own line, with the
+// component that made it and, when it's known, the Kubernetes object that
+// caused it.
+//
+// Audit logging is disabled until Start is called. Until then, Transport
+// passes the requests through.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// auditLog is the log of Start, or nil if audit logging is disabled.
+var auditLog atomic.Value
+
+// Cause is the Kubernetes object that caused a write.
+type Cause struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// Entry is the audit log entry of a write.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Component string    `json:"component"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Namespace string    `json:"namespace,omitempty"`
+	Partition string    `json:"partition,omitempty"`
+	Code      int       `json:"code,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Cause     *Cause    `json:"cause,omitempty"`
+}
+
+type causeKey struct{}
+
+// WithCause returns a copy of ctx with the cause of the writes whose
+// requests have ctx.
+func WithCause(ctx context.Context, cause Cause) context.Context {
+	return context.WithValue(ctx, causeKey{}, &cause)
+}
+
+// Start appends the entries of the writes of component to the file at
+// path, which is created if it doesn't exist, e.g. /dev/stdout to write
+// them with the logs. The returned function stops logging and closes the
+// file.
+func Start(logger hclog.Logger, path, component string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &logFile{logger: logger, component: component, file: file}
+	auditLog.Store(l)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			auditLog.Store((*logFile)(nil))
+			l.close()
+		})
+	}, nil
+}
+
+func current() *logFile {
+	l, _ := auditLog.Load().(*logFile)
+	return l
+}
+
+// Transport returns a transport logging the writes of next while audit
+// logging is enabled. The requests that only read, i.e. the GETs and
+// HEADs, aren't logged.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return &transport{next: next}
+}
+
+type transport struct {
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := current()
+	if l == nil || req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+	resp, err := t.next.RoundTrip(req)
+	entry := Entry{
+		Time:      time.Now().UTC(),
+		Component: l.component,
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		Namespace: req.URL.Query().Get("ns"),
+		Partition: req.URL.Query().Get("partition"),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Code = resp.StatusCode
+	}
+	entry.Cause, _ = req.Context().Value(causeKey{}).(*Cause)
+	l.write(entry)
+	return resp, err
+}
+
+// logFile is an audit log file.
+type logFile struct {
+	logger    hclog.Logger
+	component string
+
+	lock sync.Mutex
+	file *os.File
+}
+
+// write appends entry to the file. The error of a write can't be
+// returned since the request was already sent, so it's logged.
+func (l *logFile) write(entry Entry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		l.logger.Error("error encoding audit log entry", "err", err)
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		l.logger.Error("error writing audit log entry", "method", entry.Method, "path", entry.Path, "err", err)
+	}
+}
+
+func (l *logFile) close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if err := l.file.Close(); err != nil {
+		l.logger.Error("error closing audit log", "err", err)
+	}
+	l.file = nil
+}
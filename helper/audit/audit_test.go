@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the writes are appended to the audit log with their cause,
+// and that the reads and the writes once it's stopped aren't.
+func TestStart(t *testing.T) {
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/acl/token" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer consul.Close()
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte("{}\n"), 0600))
+
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+	do := func(ctx context.Context, method, path string) {
+		req, err := http.NewRequest(method, consul.URL+path, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req.WithContext(ctx))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	do(context.Background(), "PUT", "/v1/catalog/register")
+
+	stop, err := Start(hclog.NewNullLogger(), path, "controller")
+	require.NoError(t, err)
+	ctx := WithCause(context.Background(), Cause{Kind: "ServiceIntentions", Namespace: "default", Name: "web"})
+	do(ctx, "PUT", "/v1/config?ns=team-a")
+	do(ctx, "GET", "/v1/config/service-intentions/web")
+	do(context.Background(), "PUT", "/v1/acl/token")
+	stop()
+	do(context.Background(), "DELETE", "/v1/config/service-defaults/web")
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	require.Equal(t, "{}", lines[0])
+	var entries []Entry
+	for _, line := range lines[1:] {
+		var entry Entry
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		require.False(t, entry.Time.IsZero())
+		entries = append(entries, entry)
+	}
+	require.Equal(t, "controller", entries[0].Component)
+	require.Equal(t, "PUT", entries[0].Method)
+	require.Equal(t, "/v1/config", entries[0].Path)
+	require.Equal(t, "team-a", entries[0].Namespace)
+	require.Equal(t, http.StatusOK, entries[0].Code)
+	require.Equal(t, &Cause{Kind: "ServiceIntentions", Namespace: "default", Name: "web"}, entries[0].Cause)
+	require.Equal(t, "/v1/acl/token", entries[1].Path)
+	require.Equal(t, http.StatusForbidden, entries[1].Code)
+	require.Nil(t, entries[1].Cause)
+}
+
+// Test that the writes failing to reach Consul are logged with their error.
+func TestTransport_Error(t *testing.T) {
+	consul := httptest.NewServer(http.NotFoundHandler())
+	addr := consul.URL
+	consul.Close()
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	stop, err := Start(hclog.NewNullLogger(), path, "sync-catalog")
+	require.NoError(t, err)
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+	_, err = client.Post(addr+"/v1/catalog/register", "application/json", nil)
+	require.Error(t, err)
+	stop()
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var entry Entry
+	require.NoError(t, json.Unmarshal(data, &entry))
+	require.Equal(t, "POST", entry.Method)
+	require.Zero(t, entry.Code)
+	require.Contains(t, entry.Error, "connection refused")
+}
+
+func TestStart_InvalidPath(t *testing.T) {
+	_, err := Start(hclog.NewNullLogger(), "/nonexistent/audit.log", "controller")
+	require.Error(t, err)
+}
@@ -84,6 +84,31 @@ func GenerateCert(
 	caCert *x509.Certificate,
 	caCertSigner crypto.Signer,
 	hosts []string) (string, string, error) {
+	return generateCert(commonName, expiry, caCert, caCertSigner, hosts,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+}
+
+// GenerateServerCert generates a certificate for a Consul server
+// like GenerateCert does. Unlike certificates generated by GenerateCert
+// it can also be used for client authentication since servers
+// make outgoing TLS connections to each other.
+func GenerateServerCert(
+	commonName string,
+	expiry time.Duration,
+	caCert *x509.Certificate,
+	caCertSigner crypto.Signer,
+	hosts []string) (string, string, error) {
+	return generateCert(commonName, expiry, caCert, caCertSigner, hosts,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth})
+}
+
+func generateCert(
+	commonName string,
+	expiry time.Duration,
+	caCert *x509.Certificate,
+	caCertSigner crypto.Signer,
+	hosts []string,
+	extKeyUsage []x509.ExtKeyUsage) (string, string, error) {
 	// Create the private key we'll use for this leaf cert.
 	signer, keyPEM, err := privateKey()
 	if err != nil {
@@ -102,7 +127,7 @@ func GenerateCert(
 		Subject:               pkix.Name{CommonName: commonName},
 		BasicConstraintsValid: true,
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtKeyUsage:           extKeyUsage,
 		NotAfter:              time.Now().Add(expiry),
 		NotBefore:             time.Now().Add(-1 * time.Minute),
 	}
@@ -142,6 +167,33 @@ func ParseCert(pemValue []byte) (*x509.Certificate, error) {
 	return x509.ParseCertificate(block.Bytes)
 }
 
+// ParseSigner parses a PEM-encoded EC, RSA or PKCS #8 private key.
+func ParseSigner(pemValue []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemValue)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM-encoded data found")
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("private key of type %T is not a signer", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key PEM-block type %q", block.Type)
+	}
+}
+
 // privateKey returns a new ECDSA-based private key. Both a crypto.Signer
 // and the key in PEM format are returned.
 func privateKey() (crypto.Signer, string, error) {
@@ -0,0 +1,201 @@
+// Package consul builds the Consul API clients of the commands, so that they
+// all connect to Consul, and behave while it's unavailable, the same way.
+package consul
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul-k8s/helper/audit"
+	"github.com/hashicorp/consul-k8s/helper/fips"
+	"github.com/hashicorp/consul-k8s/helper/metrics"
+	"github.com/hashicorp/consul-k8s/helper/tracing"
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	// dialTimeout and tlsHandshakeTimeout bound the time to connect to
+	// Consul. The requests themselves aren't bounded since the blocking
+	// queries are held by the servers for up to their wait time.
+	dialTimeout         = 10 * time.Second
+	tlsHandshakeTimeout = 10 * time.Second
+
+	// maxRetries is the number of times the requests are retried while
+	// Consul is unavailable or rate limits them. The commands retry their
+	// operations on their own after that, so the retries only smooth over
+	// leader elections and the restarts of the agents.
+	maxRetries = 4
+
+	// maxRetryAfter caps the wait of the Retry-After headers.
+	maxRetryAfter = 5 * time.Second
+)
+
+// NewClient returns the client of config. Unless config has its own HTTP
+// client, the client keeps its connections to Consul open to reuse them
+// and times out connecting to it. Its requests are retried with backoff on
+// connection errors and on 429 and 5xx responses, and are recorded in the
+// metrics, traced by the tracing package and, for the writes, rate limited
+// by LimitWrites and logged by the audit package.
+//
+// The 5xx responses and the errors after connecting are only retried for
+// GETs and HEADs, since other requests like the ACL bootstrap may have been
+// handled and can't be repeated. The requests failing to connect or rate
+// limited are retried whatever their method.
+//
+// In the FIPS build, the TLS config of the transport is constrained by
+// fips.Constrain, including the one of the caller's HTTP client.
+func NewClient(config *api.Config) (*api.Client, error) {
+	if config.HttpClient == nil {
+		// Replace the transport of api.DefaultConfig, keeping its TLS
+		// config if it was set.
+		transport := newTransport()
+		if config.Transport != nil {
+			transport.TLSClientConfig = config.Transport.TLSClientConfig
+		}
+		config.Transport = transport
+	} else {
+		// Don't wrap the transport of the caller's HTTP client in place.
+		httpClient := *config.HttpClient
+		config.HttpClient = &httpClient
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	// The client shares the HTTP client of its config.
+	next := config.HttpClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	// The default transport is shared with the rest of the process, and is
+	// constrained by crypto/tls/fipsonly in the FIPS build.
+	if transport, ok := next.(*http.Transport); ok && transport != http.DefaultTransport {
+		transport.TLSClientConfig = fips.Constrain(transport.TLSClientConfig)
+	}
+	config.HttpClient.Transport = audit.Transport(&retryTransport{
+		next:       &limitTransport{next: tracing.Transport(metrics.Transport(next))},
+		newBackOff: newBackOff,
+	})
+	return client, nil
+}
+
+// newTransport returns a transport pooling the connections to Consul, like
+// the one of api.DefaultConfig but with shorter timeouts to connect.
+func newTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   runtime.GOMAXPROCS(0) + 1,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+func newBackOff() backoff.BackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 100 * time.Millisecond
+	b.MaxInterval = 2 * time.Second
+	b.MaxElapsedTime = 10 * time.Second
+	return backoff.WithMaxRetries(b, maxRetries)
+}
+
+// retryTransport retries the requests of next that can be retried.
+type retryTransport struct {
+	next       http.RoundTripper
+	newBackOff func() backoff.BackOff
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := t.newBackOff()
+	for {
+		resp, err := t.next.RoundTrip(req)
+		if !retryable(req, resp, err) {
+			return resp, err
+		}
+		wait := b.NextBackOff()
+		if wait == backoff.Stop {
+			return resp, err
+		}
+		retry := req
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			retry = req.WithContext(req.Context())
+			retry.Body = body
+		}
+		if resp != nil {
+			if after := retryAfter(resp); after > wait {
+				wait = after
+			}
+			// Drain the body so that the connection is reused.
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		req = retry
+	}
+}
+
+// retryable returns whether the request can be retried after the response
+// or error of its attempt.
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true
+		}
+		return idempotent(req)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent(req)
+	}
+	return false
+}
+
+func idempotent(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// retryAfter returns the wait of the Retry-After header of resp, in seconds.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	wait := time.Duration(seconds) * time.Second
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+	return wait
+}
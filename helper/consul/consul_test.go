@@ -0,0 +1,154 @@
+package consul
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the requests of the clients are retried while Consul is
+// unavailable.
+func TestNewClient(t *testing.T) {
+	var attempts int32
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"Config": {"Datacenter": "dc1"}}`))
+	}))
+	defer consul.Close()
+
+	client, err := NewClient(&api.Config{Address: consul.URL})
+	require.NoError(t, err)
+	self, err := client.Agent().Self()
+	require.NoError(t, err)
+	require.Equal(t, "dc1", self["Config"]["Datacenter"])
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+// Test that the HTTP client of the config isn't modified.
+func TestNewClient_HttpClient(t *testing.T) {
+	httpClient := &http.Client{Transport: http.DefaultTransport}
+	config := &api.Config{HttpClient: httpClient}
+	_, err := NewClient(config)
+	require.NoError(t, err)
+	require.Equal(t, http.DefaultTransport, httpClient.Transport)
+	require.NotEqual(t, http.DefaultTransport, config.HttpClient.Transport)
+}
+
+func TestRetryTransport(t *testing.T) {
+	cases := map[string]struct {
+		method      string
+		code        int
+		expAttempts int32
+	}{
+		"GET 503": {
+			method:      "GET",
+			code:        http.StatusServiceUnavailable,
+			expAttempts: 4,
+		},
+		"GET 404": {
+			method:      "GET",
+			code:        http.StatusNotFound,
+			expAttempts: 1,
+		},
+		"GET 501": {
+			method:      "GET",
+			code:        http.StatusNotImplemented,
+			expAttempts: 1,
+		},
+		"PUT 500": {
+			method:      "PUT",
+			code:        http.StatusInternalServerError,
+			expAttempts: 1,
+		},
+		"PUT 429": {
+			method:      "PUT",
+			code:        http.StatusTooManyRequests,
+			expAttempts: 4,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&attempts, 1)
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.Equal(t, "body", string(body))
+				w.WriteHeader(c.code)
+			}))
+			defer server.Close()
+
+			client := &http.Client{Transport: &retryTransport{
+				next:       http.DefaultTransport,
+				newBackOff: zeroBackOff,
+			}}
+			req, err := http.NewRequest(c.method, server.URL, strings.NewReader("body"))
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, c.code, resp.StatusCode)
+			require.Equal(t, c.expAttempts, atomic.LoadInt32(&attempts))
+		})
+	}
+}
+
+// Test that the requests failing to connect are retried whatever their
+// method.
+func TestRetryTransport_DialError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	addr := server.URL
+	server.Close()
+
+	var attempts int32
+	client := &http.Client{Transport: &retryTransport{
+		next: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&attempts, 1)
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+		newBackOff: zeroBackOff,
+	}}
+	req, err := http.NewRequest("PUT", addr+"/v1/acl/bootstrap", nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.Error(t, err)
+	require.Equal(t, int32(4), atomic.LoadInt32(&attempts))
+}
+
+func TestRetryAfter(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":        0,
+		"invalid": 0,
+		"-1":      0,
+		"2":       2 * time.Second,
+		"60":      maxRetryAfter,
+	}
+	for header, exp := range cases {
+		t.Run(header, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			resp.Header.Set("Retry-After", header)
+			require.Equal(t, exp, retryAfter(resp))
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func zeroBackOff() backoff.BackOff {
+	return backoff.WithMaxRetries(&backoff.ZeroBackOff{}, 3)
+}
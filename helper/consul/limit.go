@@ -0,0 +1,44 @@
+package consul
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/hashicorp/consul-k8s/helper/metrics"
+	"golang.org/x/time/rate"
+)
+
+// writeLimiter is the limiter of LimitWrites, shared by all the clients of
+// the process, or nil if their writes aren't limited.
+var writeLimiter atomic.Value
+
+// LimitWrites limits the writes of the clients of NewClient to qps writes
+// per second, with bursts of up to burst writes, so that a full resync or a
+// mass churn of pods doesn't overwhelm the Consul servers. The writes above
+// the limit wait for their turn. If qps is 0, the writes aren't limited.
+func LimitWrites(qps float64, burst int) {
+	if qps <= 0 {
+		writeLimiter.Store((*rate.Limiter)(nil))
+		return
+	}
+	writeLimiter.Store(rate.NewLimiter(rate.Limit(qps), burst))
+}
+
+// limitTransport waits for the limiter of LimitWrites before sending the
+// writes to next.
+type limitTransport struct {
+	next http.RoundTripper
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiter, _ := writeLimiter.Load().(*rate.Limiter)
+	if limiter != nil && !idempotent(req) {
+		done := metrics.QueueConsulWrite()
+		err := limiter.Wait(req.Context())
+		done()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t.next.RoundTrip(req)
+}
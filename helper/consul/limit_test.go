@@ -0,0 +1,57 @@
+package consul
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the writes wait for the limiter.
+func TestLimitWrites(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	LimitWrites(20, 1)
+	defer LimitWrites(0, 0)
+	client := &http.Client{Transport: &limitTransport{next: http.DefaultTransport}}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		req, err := http.NewRequest("PUT", server.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	// The first write is the burst, the others wait 50ms each.
+	require.True(t, time.Since(start) >= 140*time.Millisecond)
+}
+
+// Test that the writes stop waiting once their context is done, and that
+// the reads don't wait.
+func TestLimitWrites_Context(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	LimitWrites(0.1, 1)
+	defer LimitWrites(0, 0)
+	client := &http.Client{Transport: &limitTransport{next: http.DefaultTransport}}
+
+	req, err := http.NewRequest("PUT", server.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	read, err := http.NewRequest("GET", server.URL, nil)
+	require.NoError(t, err)
+	resp, err = client.Do(read.WithContext(ctx))
+	require.NoError(t, err)
+	resp.Body.Close()
+	_, err = client.Do(req.WithContext(ctx))
+	require.Error(t, err)
+}
@@ -8,6 +8,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/hashicorp/consul-k8s/helper/metrics"
+	"github.com/hashicorp/consul-k8s/helper/tracing"
 	"github.com/hashicorp/go-hclog"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -22,6 +24,11 @@ type Controller struct {
 	Log      hclog.Logger
 	Resource Resource
 
+	// Name is the controller label of the metrics of its reconciles and
+	// the controller attribute of their spans. If it's empty, the
+	// reconciles aren't recorded in the metrics.
+	Name string
+
 	informer cache.SharedIndexInformer
 }
 
@@ -169,11 +176,21 @@ func (c *Controller) processSingle(
 	if err == nil {
 		c.Log.Debug("processing object", "key", keyRaw, "exists", exists)
 		c.Log.Trace("processing object", "object", item)
+		start := time.Now()
+		namespace, name, _ := cache.SplitMetaNamespaceKey(keyRaw)
+		_, span := tracing.StartSpan(context.Background(), "reconcile",
+			tracing.String("controller", c.Name),
+			tracing.String("k8s.namespace.name", namespace),
+			tracing.String("k8s.object.name", name))
 		if !exists {
 			err = c.Resource.Delete(keyRaw)
 		} else {
 			err = c.Resource.Upsert(keyRaw, item)
 		}
+		span.End(err)
+		if c.Name != "" {
+			metrics.ObserveReconcile(c.Name, start, err)
+		}
 
 		if err == nil {
 			queue.Forget(key)
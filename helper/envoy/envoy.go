@@ -0,0 +1,502 @@
+// Package envoy reads the configuration of the Envoy sidecars from their
+// admin API.
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of an Envoy proxy.
+type Config struct {
+	Clusters     []Cluster
+	Listeners    []Listener
+	Routes       []Route
+	Endpoints    []Endpoint
+	Secrets      []Secret
+	Certificates []Certificate
+}
+
+// Cluster is an upstream cluster of Envoy.
+type Cluster struct {
+	Name string
+	// Type is the discovery type of the cluster, e.g. EDS or STATIC.
+	Type        string
+	LastUpdated string
+}
+
+// Listener is a listener of Envoy.
+type Listener struct {
+	Name        string
+	Address     string
+	LastUpdated string
+}
+
+// Route is a route of a virtual host of a route configuration.
+type Route struct {
+	// Config is the name of the route configuration.
+	Config      string
+	VirtualHost string
+	Domains     []string
+	// Match is the path, prefix or regex the route matches.
+	Match string
+	// Clusters are the clusters the route sends traffic to.
+	Clusters []string
+}
+
+// Endpoint is an endpoint of a cluster.
+type Endpoint struct {
+	Cluster string
+	Address string
+	// Health is the health status of the endpoint, e.g. HEALTHY.
+	Health string
+}
+
+// Secret is a secret of Envoy, discovered with SDS.
+type Secret struct {
+	Name string
+	// Kind is "certificate" or "validation context".
+	Kind        string
+	LastUpdated string
+}
+
+// Certificate is a certificate of Envoy, as listed by /certs.
+type Certificate struct {
+	// CA is true if the certificate is one of the trusted CAs, and false
+	// if it's the leaf certificate of the proxy.
+	CA              bool
+	SerialNumber    string
+	SubjectAltNames []string
+	ValidFrom       time.Time
+	ExpirationTime  time.Time
+}
+
+// Valid is true if the certificate is valid at t.
+func (c Certificate) Valid(t time.Time) bool {
+	return !t.Before(c.ValidFrom) && t.Before(c.ExpirationTime)
+}
+
+// Admin is a client of the admin API of an Envoy proxy.
+type Admin struct {
+	// Addr is the host:port of the admin API.
+	Addr string
+	// Client defaults to a client with a 10 second timeout.
+	Client *http.Client
+}
+
+// ConfigDump returns the dump of the configuration of Envoy, including the
+// endpoints of the clusters.
+func (a *Admin) ConfigDump() ([]byte, error) {
+	return a.get("/config_dump?include_eds")
+}
+
+// Config returns the parsed configuration and certificates of Envoy.
+func (a *Admin) Config() (*Config, error) {
+	dump, err := a.ConfigDump()
+	if err != nil {
+		return nil, err
+	}
+	config, err := ParseConfigDump(dump)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := a.get("/certs")
+	if err != nil {
+		return nil, err
+	}
+	config.Certificates, err = parseCertificates(certs)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Metrics returns the stats of Envoy in the Prometheus format.
+func (a *Admin) Metrics() ([]byte, error) {
+	return a.get("/stats/prometheus")
+}
+
+// Connected is true if Envoy is connected to its control plane, i.e. to
+// the Consul client it gets its configuration from.
+func (a *Admin) Connected() (bool, error) {
+	out, err := a.get("/stats?filter=^control_plane.connected_state$")
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if value := strings.TrimPrefix(line, "control_plane.connected_state: "); value != line {
+			return strings.TrimSpace(value) == "1", nil
+		}
+	}
+	return false, fmt.Errorf("control_plane.connected_state not found in the stats")
+}
+
+// LogLevels returns the levels of the loggers of Envoy by name.
+func (a *Admin) LogLevels() (map[string]string, error) {
+	out, err := a.do(http.MethodPost, "/logging")
+	if err != nil {
+		return nil, err
+	}
+	return parseLogLevels(out), nil
+}
+
+// SetLogLevel sets the level of logger, or of all the loggers if logger is
+// "", and returns the levels of the loggers of Envoy by name.
+func (a *Admin) SetLogLevel(logger, level string) (map[string]string, error) {
+	if logger == "" {
+		logger = "level"
+	}
+	out, err := a.do(http.MethodPost, "/logging?"+url.Values{logger: {level}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return parseLogLevels(out), nil
+}
+
+// parseLogLevels parses the output of /logging, e.g.:
+//
+//	active loggers:
+//	  admin: info
+//	  upstream: debug
+func parseLogLevels(out []byte) map[string]string {
+	levels := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ": ", 2)
+		if len(parts) == 2 {
+			levels[parts[0]] = parts[1]
+		}
+	}
+	return levels
+}
+
+func (a *Admin) get(path string) ([]byte, error) {
+	return a.do(http.MethodGet, path)
+}
+
+func (a *Admin) do(method, path string) ([]byte, error) {
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequest(method, "http://"+a.Addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// ParseConfigDump parses the output of /config_dump. The configs are
+// parsed by the suffix of their type, e.g. ClustersConfigDump, so that the
+// v2 and v3 APIs of Envoy are supported.
+func ParseConfigDump(dump []byte) (*Config, error) {
+	var raw struct {
+		Configs []json.RawMessage `json:"configs"`
+	}
+	if err := json.Unmarshal(dump, &raw); err != nil {
+		return nil, fmt.Errorf("parsing the config dump: %s", err)
+	}
+
+	var config Config
+	for _, rawConfig := range raw.Configs {
+		var typed struct {
+			Type string `json:"@type"`
+		}
+		if err := json.Unmarshal(rawConfig, &typed); err != nil {
+			return nil, fmt.Errorf("parsing the config dump: %s", err)
+		}
+		var err error
+		switch {
+		case strings.HasSuffix(typed.Type, ".ClustersConfigDump"):
+			config.Clusters, err = parseClusters(rawConfig)
+		case strings.HasSuffix(typed.Type, ".ListenersConfigDump"):
+			config.Listeners, err = parseListeners(rawConfig)
+		case strings.HasSuffix(typed.Type, ".RoutesConfigDump"):
+			config.Routes, err = parseRoutes(rawConfig)
+		case strings.HasSuffix(typed.Type, ".EndpointsConfigDump"):
+			config.Endpoints, err = parseEndpoints(rawConfig)
+		case strings.HasSuffix(typed.Type, ".SecretsConfigDump"):
+			config.Secrets, err = parseSecrets(rawConfig)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %s", typed.Type, err)
+		}
+	}
+	return &config, nil
+}
+
+type socketAddress struct {
+	SocketAddress struct {
+		Address   string `json:"address"`
+		PortValue int    `json:"port_value"`
+	} `json:"socket_address"`
+}
+
+func (a socketAddress) String() string {
+	return net.JoinHostPort(a.SocketAddress.Address, strconv.Itoa(a.SocketAddress.PortValue))
+}
+
+func parseClusters(raw []byte) ([]Cluster, error) {
+	type cluster struct {
+		Cluster struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"cluster"`
+		LastUpdated string `json:"last_updated"`
+	}
+	var dump struct {
+		Static  []cluster `json:"static_clusters"`
+		Dynamic []cluster `json:"dynamic_active_clusters"`
+	}
+	if err := json.Unmarshal(raw, &dump); err != nil {
+		return nil, err
+	}
+	var clusters []Cluster
+	for _, c := range append(dump.Static, dump.Dynamic...) {
+		typ := c.Cluster.Type
+		// STATIC is the default value, omitted from the JSON.
+		if typ == "" {
+			typ = "STATIC"
+		}
+		clusters = append(clusters, Cluster{Name: c.Cluster.Name, Type: typ, LastUpdated: c.LastUpdated})
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Name < clusters[j].Name })
+	return clusters, nil
+}
+
+func parseListeners(raw []byte) ([]Listener, error) {
+	type listener struct {
+		Name    string        `json:"name"`
+		Address socketAddress `json:"address"`
+	}
+	type staticListener struct {
+		Listener    listener `json:"listener"`
+		LastUpdated string   `json:"last_updated"`
+	}
+	var dump struct {
+		Static []staticListener `json:"static_listeners"`
+		// DynamicActive is the field of the dynamic listeners before Envoy
+		// 1.13.
+		DynamicActive []staticListener `json:"dynamic_active_listeners"`
+		Dynamic       []struct {
+			Name        string          `json:"name"`
+			ActiveState *staticListener `json:"active_state"`
+		} `json:"dynamic_listeners"`
+	}
+	if err := json.Unmarshal(raw, &dump); err != nil {
+		return nil, err
+	}
+	all := append(dump.Static, dump.DynamicActive...)
+	for _, l := range dump.Dynamic {
+		// Listeners being warmed or drained have no active state.
+		if l.ActiveState != nil {
+			all = append(all, *l.ActiveState)
+		}
+	}
+	var listeners []Listener
+	for _, l := range all {
+		listeners = append(listeners, Listener{
+			Name:        l.Listener.Name,
+			Address:     l.Listener.Address.String(),
+			LastUpdated: l.LastUpdated,
+		})
+	}
+	sort.Slice(listeners, func(i, j int) bool { return listeners[i].Name < listeners[j].Name })
+	return listeners, nil
+}
+
+func parseRoutes(raw []byte) ([]Route, error) {
+	type routeConfig struct {
+		RouteConfig struct {
+			Name         string `json:"name"`
+			VirtualHosts []struct {
+				Name    string   `json:"name"`
+				Domains []string `json:"domains"`
+				Routes  []struct {
+					Match struct {
+						Prefix    *string `json:"prefix"`
+						Path      *string `json:"path"`
+						Regex     *string `json:"regex"`
+						SafeRegex *struct {
+							Regex string `json:"regex"`
+						} `json:"safe_regex"`
+					} `json:"match"`
+					Route struct {
+						Cluster          string `json:"cluster"`
+						WeightedClusters struct {
+							Clusters []struct {
+								Name string `json:"name"`
+							} `json:"clusters"`
+						} `json:"weighted_clusters"`
+					} `json:"route"`
+				} `json:"routes"`
+			} `json:"virtual_hosts"`
+		} `json:"route_config"`
+	}
+	var dump struct {
+		Static  []routeConfig `json:"static_route_configs"`
+		Dynamic []routeConfig `json:"dynamic_route_configs"`
+	}
+	if err := json.Unmarshal(raw, &dump); err != nil {
+		return nil, err
+	}
+	var routes []Route
+	for _, config := range append(dump.Static, dump.Dynamic...) {
+		for _, host := range config.RouteConfig.VirtualHosts {
+			for _, r := range host.Routes {
+				route := Route{Config: config.RouteConfig.Name, VirtualHost: host.Name, Domains: host.Domains}
+				switch match := r.Match; {
+				case match.Prefix != nil:
+					route.Match = "prefix " + *match.Prefix
+				case match.Path != nil:
+					route.Match = "path " + *match.Path
+				case match.SafeRegex != nil:
+					route.Match = "regex " + match.SafeRegex.Regex
+				case match.Regex != nil:
+					route.Match = "regex " + *match.Regex
+				}
+				if r.Route.Cluster != "" {
+					route.Clusters = []string{r.Route.Cluster}
+				}
+				for _, c := range r.Route.WeightedClusters.Clusters {
+					route.Clusters = append(route.Clusters, c.Name)
+				}
+				routes = append(routes, route)
+			}
+		}
+	}
+	return routes, nil
+}
+
+func parseEndpoints(raw []byte) ([]Endpoint, error) {
+	type endpointConfig struct {
+		EndpointConfig struct {
+			ClusterName string `json:"cluster_name"`
+			Endpoints   []struct {
+				LBEndpoints []struct {
+					Endpoint struct {
+						Address socketAddress `json:"address"`
+					} `json:"endpoint"`
+					HealthStatus string `json:"health_status"`
+				} `json:"lb_endpoints"`
+			} `json:"endpoints"`
+		} `json:"endpoint_config"`
+	}
+	var dump struct {
+		Static  []endpointConfig `json:"static_endpoint_configs"`
+		Dynamic []endpointConfig `json:"dynamic_endpoint_configs"`
+	}
+	if err := json.Unmarshal(raw, &dump); err != nil {
+		return nil, err
+	}
+	var endpoints []Endpoint
+	for _, config := range append(dump.Static, dump.Dynamic...) {
+		for _, locality := range config.EndpointConfig.Endpoints {
+			for _, e := range locality.LBEndpoints {
+				health := e.HealthStatus
+				// UNKNOWN is the default value, omitted from the JSON.
+				if health == "" {
+					health = "UNKNOWN"
+				}
+				endpoints = append(endpoints, Endpoint{
+					Cluster: config.EndpointConfig.ClusterName,
+					Address: e.Endpoint.Address.String(),
+					Health:  health,
+				})
+			}
+		}
+	}
+	sort.SliceStable(endpoints, func(i, j int) bool { return endpoints[i].Cluster < endpoints[j].Cluster })
+	return endpoints, nil
+}
+
+func parseSecrets(raw []byte) ([]Secret, error) {
+	type secret struct {
+		Name   string `json:"name"`
+		Secret struct {
+			TLSCertificate    json.RawMessage `json:"tls_certificate"`
+			ValidationContext json.RawMessage `json:"validation_context"`
+		} `json:"secret"`
+		LastUpdated string `json:"last_updated"`
+	}
+	var dump struct {
+		Static  []secret `json:"static_secrets"`
+		Dynamic []secret `json:"dynamic_active_secrets"`
+	}
+	if err := json.Unmarshal(raw, &dump); err != nil {
+		return nil, err
+	}
+	var secrets []Secret
+	for _, s := range append(dump.Static, dump.Dynamic...) {
+		kind := "certificate"
+		if s.Secret.ValidationContext != nil {
+			kind = "validation context"
+		}
+		secrets = append(secrets, Secret{Name: s.Name, Kind: kind, LastUpdated: s.LastUpdated})
+	}
+	return secrets, nil
+}
+
+// parseCertificates parses the output of /certs.
+func parseCertificates(raw []byte) ([]Certificate, error) {
+	type cert struct {
+		SerialNumber    string `json:"serial_number"`
+		SubjectAltNames []struct {
+			URI string `json:"uri"`
+			DNS string `json:"dns"`
+		} `json:"subject_alt_names"`
+		ValidFrom      time.Time `json:"valid_from"`
+		ExpirationTime time.Time `json:"expiration_time"`
+	}
+	var dump struct {
+		Certificates []struct {
+			CACert    []cert `json:"ca_cert"`
+			CertChain []cert `json:"cert_chain"`
+		} `json:"certificates"`
+	}
+	if err := json.Unmarshal(raw, &dump); err != nil {
+		return nil, fmt.Errorf("parsing the certificates: %s", err)
+	}
+	var certs []Certificate
+	for _, c := range dump.Certificates {
+		for i, chain := range [][]cert{c.CACert, c.CertChain} {
+			for _, cert := range chain {
+				certificate := Certificate{
+					CA:             i == 0,
+					SerialNumber:   cert.SerialNumber,
+					ValidFrom:      cert.ValidFrom,
+					ExpirationTime: cert.ExpirationTime,
+				}
+				for _, san := range cert.SubjectAltNames {
+					if san.URI != "" {
+						certificate.SubjectAltNames = append(certificate.SubjectAltNames, san.URI)
+					}
+					if san.DNS != "" {
+						certificate.SubjectAltNames = append(certificate.SubjectAltNames, san.DNS)
+					}
+				}
+				certs = append(certs, certificate)
+			}
+		}
+	}
+	return certs, nil
+}
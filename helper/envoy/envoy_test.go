@@ -0,0 +1,255 @@
+package envoy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const configDump = `{
+  "configs": [
+    {
+      "@type": "type.googleapis.com/envoy.admin.v3.BootstrapConfigDump",
+      "bootstrap": {"node": {"id": "web-0-web-sidecar-proxy"}}
+    },
+    {
+      "@type": "type.googleapis.com/envoy.admin.v3.ClustersConfigDump",
+      "static_clusters": [
+        {"cluster": {"name": "local_agent", "connect_timeout": "1s"}}
+      ],
+      "dynamic_active_clusters": [
+        {
+          "version_info": "5",
+          "cluster": {"name": "db.default.dc1.internal.11111111-2222-3333-4444-555555555555.consul", "type": "EDS"},
+          "last_updated": "2020-06-01T10:00:00Z"
+        },
+        {
+          "cluster": {"name": "local_app", "type": "STATIC"},
+          "last_updated": "2020-06-01T10:00:00Z"
+        }
+      ]
+    },
+    {
+      "@type": "type.googleapis.com/envoy.admin.v3.ListenersConfigDump",
+      "dynamic_listeners": [
+        {
+          "name": "public_listener:10.0.0.5:20000",
+          "active_state": {
+            "listener": {"name": "public_listener:10.0.0.5:20000", "address": {"socket_address": {"address": "10.0.0.5", "port_value": 20000}}},
+            "last_updated": "2020-06-01T10:00:00Z"
+          }
+        },
+        {"name": "db:127.0.0.1:5432", "warming_state": {}}
+      ]
+    },
+    {
+      "@type": "type.googleapis.com/envoy.admin.v3.RoutesConfigDump",
+      "dynamic_route_configs": [
+        {
+          "route_config": {
+            "name": "api",
+            "virtual_hosts": [
+              {
+                "name": "api",
+                "domains": ["*"],
+                "routes": [
+                  {"match": {"prefix": "/v2"}, "route": {"weighted_clusters": {"clusters": [{"name": "api-v1"}, {"name": "api-v2"}]}}},
+                  {"match": {"prefix": "/"}, "route": {"cluster": "api-v1"}}
+                ]
+              }
+            ]
+          }
+        }
+      ]
+    },
+    {
+      "@type": "type.googleapis.com/envoy.admin.v3.EndpointsConfigDump",
+      "dynamic_endpoint_configs": [
+        {
+          "endpoint_config": {
+            "cluster_name": "db.default.dc1.internal.11111111-2222-3333-4444-555555555555.consul",
+            "endpoints": [
+              {
+                "lb_endpoints": [
+                  {"endpoint": {"address": {"socket_address": {"address": "10.0.0.7", "port_value": 20000}}}, "health_status": "HEALTHY"},
+                  {"endpoint": {"address": {"socket_address": {"address": "fd00::8", "port_value": 20000}}}, "health_status": "UNHEALTHY"}
+                ]
+              }
+            ]
+          }
+        }
+      ]
+    },
+    {
+      "@type": "type.googleapis.com/envoy.admin.v3.SecretsConfigDump",
+      "dynamic_active_secrets": [
+        {"name": "default", "secret": {"tls_certificate": {}}, "last_updated": "2020-06-01T10:00:00Z"},
+        {"name": "ROOTCA", "secret": {"validation_context": {}}, "last_updated": "2020-06-01T10:00:00Z"}
+      ]
+    }
+  ]
+}`
+
+const certs = `{
+  "certificates": [
+    {
+      "ca_cert": [
+        {"serial_number": "7", "subject_alt_names": [{"uri": "spiffe://11111111-2222-3333-4444-555555555555.consul"}], "valid_from": "2020-01-01T00:00:00Z", "expiration_time": "2030-01-01T00:00:00Z"}
+      ],
+      "cert_chain": [
+        {"serial_number": "2a", "subject_alt_names": [{"uri": "spiffe://11111111-2222-3333-4444-555555555555.consul/ns/default/dc/dc1/svc/web"}], "valid_from": "2020-06-01T00:00:00Z", "expiration_time": "2020-06-04T00:00:00Z"}
+      ]
+    }
+  ]
+}`
+
+const dbCluster = "db.default.dc1.internal.11111111-2222-3333-4444-555555555555.consul"
+
+func TestParseConfigDump(t *testing.T) {
+	config, err := ParseConfigDump([]byte(configDump))
+	require.NoError(t, err)
+	require.Equal(t, []Cluster{
+		{Name: dbCluster, Type: "EDS", LastUpdated: "2020-06-01T10:00:00Z"},
+		{Name: "local_agent", Type: "STATIC"},
+		{Name: "local_app", Type: "STATIC", LastUpdated: "2020-06-01T10:00:00Z"},
+	}, config.Clusters)
+	require.Equal(t, []Listener{
+		{Name: "public_listener:10.0.0.5:20000", Address: "10.0.0.5:20000", LastUpdated: "2020-06-01T10:00:00Z"},
+	}, config.Listeners)
+	require.Equal(t, []Route{
+		{Config: "api", VirtualHost: "api", Domains: []string{"*"}, Match: "prefix /v2", Clusters: []string{"api-v1", "api-v2"}},
+		{Config: "api", VirtualHost: "api", Domains: []string{"*"}, Match: "prefix /", Clusters: []string{"api-v1"}},
+	}, config.Routes)
+	require.Equal(t, []Endpoint{
+		{Cluster: dbCluster, Address: "10.0.0.7:20000", Health: "HEALTHY"},
+		{Cluster: dbCluster, Address: "[fd00::8]:20000", Health: "UNHEALTHY"},
+	}, config.Endpoints)
+	require.Equal(t, []Secret{
+		{Name: "default", Kind: "certificate", LastUpdated: "2020-06-01T10:00:00Z"},
+		{Name: "ROOTCA", Kind: "validation context", LastUpdated: "2020-06-01T10:00:00Z"},
+	}, config.Secrets)
+}
+
+func TestParseConfigDump_Invalid(t *testing.T) {
+	_, err := ParseConfigDump([]byte(`{"configs": [{"@type": "type.googleapis.com/envoy.admin.v3.ClustersConfigDump", "static_clusters": {}}]}`))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "parsing type.googleapis.com/envoy.admin.v3.ClustersConfigDump: ")
+}
+
+func TestAdmin_Config(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config_dump":
+			require.Equal(t, "include_eds", r.URL.RawQuery)
+			fmt.Fprint(w, configDump)
+		case "/certs":
+			fmt.Fprint(w, certs)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	admin := &Admin{Addr: strings.TrimPrefix(server.URL, "http://")}
+	config, err := admin.Config()
+	require.NoError(t, err)
+	require.Len(t, config.Clusters, 3)
+	require.Equal(t, []Certificate{
+		{
+			CA:              true,
+			SerialNumber:    "7",
+			SubjectAltNames: []string{"spiffe://11111111-2222-3333-4444-555555555555.consul"},
+			ValidFrom:       time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			ExpirationTime:  time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			SerialNumber:    "2a",
+			SubjectAltNames: []string{"spiffe://11111111-2222-3333-4444-555555555555.consul/ns/default/dc/dc1/svc/web"},
+			ValidFrom:       time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+			ExpirationTime:  time.Date(2020, 6, 4, 0, 0, 0, 0, time.UTC),
+		},
+	}, config.Certificates)
+	require.True(t, config.Certificates[1].Valid(time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC)))
+	require.False(t, config.Certificates[1].Valid(time.Date(2020, 6, 5, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestAdmin_Connected(t *testing.T) {
+	cases := map[string]struct {
+		stats        string
+		status       int
+		expConnected bool
+		expErr       string
+	}{
+		"connected": {
+			stats:        "control_plane.connected_state: 1\n",
+			status:       http.StatusOK,
+			expConnected: true,
+		},
+		"disconnected": {
+			stats:  "control_plane.connected_state: 0\n",
+			status: http.StatusOK,
+		},
+		"missing": {
+			stats:  "",
+			status: http.StatusOK,
+			expErr: "control_plane.connected_state not found in the stats",
+		},
+		"error": {
+			stats:  "invalid regex",
+			status: http.StatusBadRequest,
+			expErr: "GET /stats?filter=^control_plane.connected_state$: 400 Bad Request: invalid regex",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/stats", r.URL.Path)
+				w.WriteHeader(c.status)
+				fmt.Fprint(w, c.stats)
+			}))
+			defer server.Close()
+
+			connected, err := (&Admin{Addr: strings.TrimPrefix(server.URL, "http://")}).Connected()
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expConnected, connected)
+		})
+	}
+}
+
+func TestAdmin_LogLevels(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/logging", r.URL.Path)
+		requests = append(requests, r.URL.RawQuery)
+		if r.URL.Query().Get("level") == "loud" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "error: unknown logger level\n")
+			return
+		}
+		fmt.Fprint(w, "active loggers:\n  admin: info\n  upstream: debug\n")
+	}))
+	defer server.Close()
+	admin := &Admin{Addr: strings.TrimPrefix(server.URL, "http://")}
+
+	levels, err := admin.LogLevels()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"admin": "info", "upstream": "debug"}, levels)
+	_, err = admin.SetLogLevel("upstream", "debug")
+	require.NoError(t, err)
+	_, err = admin.SetLogLevel("", "debug")
+	require.NoError(t, err)
+	_, err = admin.SetLogLevel("", "loud")
+	require.EqualError(t, err, "POST /logging?level=loud: 404 Not Found: error: unknown logger level")
+	require.Equal(t, []string{"", "upstream=debug", "level=debug", "level=loud"}, requests)
+}
@@ -0,0 +1,20 @@
+// +build fips
+
+package fips
+
+import (
+	"crypto/boring"
+	"errors"
+
+	// Restrict crypto/tls to the FIPS-approved settings.
+	_ "crypto/tls/fipsonly"
+)
+
+const enabled = true
+
+func check() error {
+	if !boring.Enabled() {
+		return errors.New("the FIPS build of consul-k8s must use BoringCrypto, which isn't enabled on this platform")
+	}
+	return nil
+}
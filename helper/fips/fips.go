@@ -0,0 +1,93 @@
+// Package fips holds the TLS configuration of the FIPS build of consul-k8s,
+// which is built with the fips tag and GOEXPERIMENT=boringcrypto, e.g. with
+// `make dev-fips`. In the FIPS build, the TLS connections of the webhook
+// servers and the Consul clients only use the FIPS 140-2 approved versions,
+// cipher suites and curves, and the config entries of the gateways and the
+// mesh can only set approved versions and cipher suites. The FIPS build
+// doesn't compile without BoringCrypto, and Check returns an error if it
+// isn't used at runtime.
+//
+// In the other builds, the TLS configuration isn't constrained.
+package fips
+
+import (
+	"crypto/tls"
+)
+
+// cipherSuites are the approved TLS 1.2 cipher suites. The TLS 1.3 cipher
+// suites aren't configurable.
+var cipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// envoyCipherSuites are the names of cipherSuites in Envoy.
+var envoyCipherSuites = map[string]bool{
+	"ECDHE-ECDSA-AES128-GCM-SHA256": true,
+	"ECDHE-ECDSA-AES256-GCM-SHA384": true,
+	"ECDHE-RSA-AES128-GCM-SHA256":   true,
+	"ECDHE-RSA-AES256-GCM-SHA384":   true,
+}
+
+// Enabled returns whether the binary is the FIPS build.
+func Enabled() bool {
+	return enabled
+}
+
+// Check returns an error if the binary is the FIPS build and doesn't use
+// BoringCrypto, e.g. on a platform it doesn't support.
+func Check() error {
+	return check()
+}
+
+// Constrain returns config, or a new config if it's nil, constrained to the
+// approved versions, cipher suites and curves in the FIPS build. The cipher
+// suites set in config that aren't approved are removed. In the other
+// builds, it returns config unchanged.
+func Constrain(config *tls.Config) *tls.Config {
+	if !enabled {
+		return config
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.MinVersion < tls.VersionTLS12 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	var suites []uint16
+	for _, suite := range config.CipherSuites {
+		if approved(suite) {
+			suites = append(suites, suite)
+		}
+	}
+	if len(suites) == 0 {
+		suites = append(suites, cipherSuites...)
+	}
+	config.CipherSuites = suites
+	config.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+	return config
+}
+
+// EnvoyCipherSuiteApproved returns whether the Envoy cipher suite can be
+// used, i.e. it's approved or the binary isn't the FIPS build.
+func EnvoyCipherSuiteApproved(suite string) bool {
+	return !enabled || envoyCipherSuites[suite]
+}
+
+// EnvoyTLSVersionApproved returns whether the Envoy TLS version, e.g.
+// TLSv1_2, can be used, i.e. it's TLS 1.2 or later, TLS_AUTO for Envoy's
+// default, or the binary isn't the FIPS build.
+func EnvoyTLSVersionApproved(version string) bool {
+	return !enabled || (version != "TLSv1_0" && version != "TLSv1_1")
+}
+
+func approved(suite uint16) bool {
+	for _, s := range cipherSuites {
+		if s == suite {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package fips
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstrain(t *testing.T) {
+	cases := map[string]struct {
+		config *tls.Config
+		exp    *tls.Config
+	}{
+		"nil": {
+			exp: &tls.Config{
+				MinVersion:       tls.VersionTLS12,
+				CipherSuites:     cipherSuites,
+				CurvePreferences: []tls.CurveID{tls.CurveP256, tls.CurveP384},
+			},
+		},
+		"unapproved settings": {
+			config: &tls.Config{
+				MinVersion: tls.VersionTLS10,
+				CipherSuites: []uint16{
+					tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+					tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				},
+				CurvePreferences: []tls.CurveID{tls.X25519},
+			},
+			exp: &tls.Config{
+				MinVersion:       tls.VersionTLS12,
+				CipherSuites:     []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+				CurvePreferences: []tls.CurveID{tls.CurveP256, tls.CurveP384},
+			},
+		},
+		"no approved cipher suites": {
+			config: &tls.Config{
+				MinVersion:   tls.VersionTLS13,
+				CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305},
+			},
+			exp: &tls.Config{
+				MinVersion:       tls.VersionTLS13,
+				CipherSuites:     cipherSuites,
+				CurvePreferences: []tls.CurveID{tls.CurveP256, tls.CurveP384},
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var orig *tls.Config
+			if c.config != nil {
+				orig = c.config.Clone()
+			}
+			actual := Constrain(c.config)
+			if !Enabled() {
+				// The config isn't constrained outside the FIPS build.
+				require.Equal(t, orig, actual)
+				return
+			}
+			require.Equal(t, c.exp, actual)
+		})
+	}
+}
+
+func TestEnvoyApproved(t *testing.T) {
+	require.True(t, EnvoyCipherSuiteApproved("ECDHE-RSA-AES128-GCM-SHA256"))
+	require.Equal(t, !Enabled(), EnvoyCipherSuiteApproved("ECDHE-RSA-CHACHA20-POLY1305"))
+	require.Equal(t, !Enabled(), EnvoyCipherSuiteApproved("AES128-SHA"))
+
+	require.True(t, EnvoyTLSVersionApproved(""))
+	require.True(t, EnvoyTLSVersionApproved("TLS_AUTO"))
+	require.True(t, EnvoyTLSVersionApproved("TLSv1_2"))
+	require.True(t, EnvoyTLSVersionApproved("TLSv1_3"))
+	require.Equal(t, !Enabled(), EnvoyTLSVersionApproved("TLSv1_0"))
+	require.Equal(t, !Enabled(), EnvoyTLSVersionApproved("TLSv1_1"))
+}
+
+func TestCheck(t *testing.T) {
+	require.NoError(t, Check())
+}
@@ -0,0 +1,177 @@
+package gossip
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// keySize is the size in bytes of generated keys. Consul uses the key
+// for AES-256.
+const keySize = 32
+
+// Rotation rotates the gossip encryption key of the agents stored in a
+// Kubernetes secret:
+//
+//  1. A new key is generated and stored in the secret under
+//     <SecretKey>-next, then installed on all agents.
+//  2. The new key becomes the primary key. The secret then stores it
+//     under <SecretKey> and the old key under <SecretKey>-previous
+//     so that restarted agents use the new key.
+//  3. The old key is removed from the agents and the secret.
+//
+// Since the secret records the progress, running the rotation again after
+// a failure resumes it instead of starting a new one, unless it was rolled
+// back.
+type Rotation struct {
+	Secrets    corev1.SecretInterface
+	SecretName string
+	SecretKey  string
+	Operator   *api.Operator
+
+	// Progress is called with a description of each step, and key/value
+	// pairs of its details, before the step starts. hclog.Logger's Info
+	// can be used.
+	Progress func(msg string, args ...interface{})
+
+	// Rollback, if true, removes the new key from the agents and the
+	// secret if the rotation fails before the agents use it, so that the
+	// keys are left as they were before the rotation.
+	Rollback bool
+}
+
+// Run runs the rotation.
+func (r *Rotation) Run() error {
+	secret, err := r.Secrets.Get(r.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting secret %q: %s", r.SecretName, err)
+	}
+	currentKey := string(secret.Data[r.SecretKey])
+	if currentKey == "" {
+		return fmt.Errorf("secret %q has no %q key", r.SecretName, r.SecretKey)
+	}
+	nextDataKey := r.SecretKey + "-next"
+	previousDataKey := r.SecretKey + "-previous"
+
+	// If a previous run failed after switching to the new key,
+	// only the old key needs to be removed.
+	oldKey := string(secret.Data[previousDataKey])
+	if oldKey == "" {
+		newKey := string(secret.Data[nextDataKey])
+		if newKey == "" {
+			newKey, err = GenerateKey()
+			if err != nil {
+				return err
+			}
+			secret.Data[nextDataKey] = []byte(newKey)
+			if secret, err = r.Secrets.Update(secret); err != nil {
+				return fmt.Errorf("storing new key in secret %q: %s", r.SecretName, err)
+			}
+		} else {
+			r.progress("Resuming rotation to the key stored in the secret", "secret-key", nextDataKey)
+		}
+
+		if err := r.switchKey(newKey); err != nil {
+			if !r.Rollback {
+				return err
+			}
+			r.progress("Rolling back the rotation")
+			if rollbackErr := r.rollback(newKey, nextDataKey); rollbackErr != nil {
+				return fmt.Errorf("%s, and rolling back failed: %s", err, rollbackErr)
+			}
+			return fmt.Errorf("%s, the rotation was rolled back", err)
+		}
+		secret.Data[r.SecretKey] = []byte(newKey)
+		secret.Data[previousDataKey] = []byte(currentKey)
+		delete(secret.Data, nextDataKey)
+		if secret, err = r.Secrets.Update(secret); err != nil {
+			return fmt.Errorf("storing new key in secret %q: %s", r.SecretName, err)
+		}
+		oldKey = currentKey
+	} else {
+		r.progress("Resuming rotation by removing the old key stored in the secret", "secret-key", previousDataKey)
+	}
+
+	r.progress("Removing old key")
+	if err := r.Operator.KeyringRemove(oldKey, nil); err != nil {
+		return fmt.Errorf("removing old key: %s", err)
+	}
+	delete(secret.Data, previousDataKey)
+	if _, err := r.Secrets.Update(secret); err != nil {
+		return fmt.Errorf("removing old key from secret %q: %s", r.SecretName, err)
+	}
+	return nil
+}
+
+// switchKey installs key on the agents and makes it their primary key.
+func (r *Rotation) switchKey(key string) error {
+	r.progress("Installing new key")
+	if err := r.Operator.KeyringInstall(key, nil); err != nil {
+		return fmt.Errorf("installing new key: %s", err)
+	}
+	if err := r.requireInstalled(key); err != nil {
+		return err
+	}
+
+	r.progress("Using new key")
+	if err := r.Operator.KeyringUse(key, nil); err != nil {
+		return fmt.Errorf("using new key: %s", err)
+	}
+	return nil
+}
+
+// rollback removes the new key from the agents and the secret.
+func (r *Rotation) rollback(key, dataKey string) error {
+	if err := r.Operator.KeyringRemove(key, nil); err != nil {
+		return fmt.Errorf("removing new key: %s", err)
+	}
+	secret, err := r.Secrets.Get(r.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting secret %q: %s", r.SecretName, err)
+	}
+	delete(secret.Data, dataKey)
+	if _, err := r.Secrets.Update(secret); err != nil {
+		return fmt.Errorf("removing new key from secret %q: %s", r.SecretName, err)
+	}
+	return nil
+}
+
+// requireInstalled returns an error unless key is installed on all the
+// nodes of every keyring. Switching to a key that some agents don't have
+// would partition them from the cluster.
+func (r *Rotation) requireInstalled(key string) error {
+	rings, err := r.Operator.KeyringList(nil)
+	if err != nil {
+		return fmt.Errorf("listing keys: %s", err)
+	}
+	for _, ring := range rings {
+		if ring.Keys[key] != ring.NumNodes {
+			name := "LAN"
+			if ring.WAN {
+				name = "WAN"
+			}
+			return fmt.Errorf("new key is installed on %d of %d nodes of the %s keyring of datacenter %q",
+				ring.Keys[key], ring.NumNodes, name, ring.Datacenter)
+		}
+	}
+	return nil
+}
+
+func (r *Rotation) progress(msg string, args ...interface{}) {
+	if r.Progress != nil {
+		r.Progress(msg, args...)
+	}
+}
+
+// GenerateKey returns a new base64-encoded gossip encryption key.
+func GenerateKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("generating key: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
@@ -0,0 +1,86 @@
+package gossip
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRotation_Run(t *testing.T) {
+	cases := map[string]struct {
+		rollback     bool
+		missingNodes int
+		expErr       string
+		expKeys      []string
+		expData      []string
+	}{
+		"rotated": {
+			rollback: true,
+			expData:  []string{"key"},
+		},
+		"failed": {
+			missingNodes: 1,
+			expErr:       `new key is installed on 2 of 3 nodes of the LAN keyring of datacenter "dc1"`,
+			expData:      []string{"key", "key-next"},
+		},
+		"rolled back": {
+			rollback:     true,
+			missingNodes: 1,
+			expErr:       `new key is installed on 2 of 3 nodes of the LAN keyring of datacenter "dc1", the rotation was rolled back`,
+			expKeys:      []string{"old"},
+			expData:      []string{"key"},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8s := fake.NewSimpleClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "gossip", Namespace: "default"},
+				Data:       map[string][]byte{"key": []byte("old")},
+			})
+			keyring := NewTestKeyring("old")
+			keyring.MissingNodes = c.missingNodes
+			server := httptest.NewServer(keyring)
+			defer server.Close()
+			consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+			require.NoError(t, err)
+
+			var steps []string
+			rotation := &Rotation{
+				Secrets:    k8s.CoreV1().Secrets("default"),
+				SecretName: "gossip",
+				SecretKey:  "key",
+				Operator:   consulClient.Operator(),
+				Progress:   func(msg string, _ ...interface{}) { steps = append(steps, msg) },
+				Rollback:   c.rollback,
+			}
+			err = rotation.Run()
+			secret, getErr := k8s.CoreV1().Secrets("default").Get("gossip", metav1.GetOptions{})
+			require.NoError(t, getErr)
+			var data []string
+			for key := range secret.Data {
+				data = append(data, key)
+			}
+			require.ElementsMatch(t, c.expData, data)
+			keys, primary := keyring.State()
+
+			if c.expErr == "" {
+				require.NoError(t, err)
+				require.Equal(t, []string{"Installing new key", "Using new key", "Removing old key"}, steps)
+				require.Equal(t, []string{string(secret.Data["key"])}, keys)
+				require.Equal(t, keys[0], primary)
+				return
+			}
+			require.EqualError(t, err, c.expErr)
+			require.Equal(t, "old", primary)
+			require.Equal(t, "old", string(secret.Data["key"]))
+			if c.expKeys != nil {
+				require.Equal(t, c.expKeys, keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package gossip
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// TestKeyring fakes the keyring endpoints of the HTTP API of a 3 node
+// cluster.
+type TestKeyring struct {
+	lock    sync.Mutex
+	keys    []string
+	primary string
+
+	// MissingNodes is the number of nodes new keys aren't installed on.
+	MissingNodes int
+}
+
+// NewTestKeyring returns a TestKeyring with the keys, the first one being
+// the primary key.
+func NewTestKeyring(keys ...string) *TestKeyring {
+	return &TestKeyring{keys: keys, primary: keys[0]}
+}
+
+// State returns the installed keys and the primary key.
+func (f *TestKeyring) State() ([]string, string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.keys...), f.primary
+}
+
+func (f *TestKeyring) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/operator/keyring" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var req struct{ Key string }
+	if r.Method != http.MethodGet {
+		json.NewDecoder(r.Body).Decode(&req)
+	}
+	index := -1
+	for i, k := range f.keys {
+		if k == req.Key {
+			index = i
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		keys := make(map[string]int)
+		for i, k := range f.keys {
+			keys[k] = 3
+			if i > 0 {
+				keys[k] -= f.MissingNodes
+			}
+		}
+		json.NewEncoder(w).Encode([]*api.KeyringResponse{{Datacenter: "dc1", Keys: keys, NumNodes: 3}})
+	case http.MethodPost:
+		if index == -1 {
+			f.keys = append(f.keys, req.Key)
+		}
+	case http.MethodPut:
+		if index == -1 {
+			http.Error(w, "key not installed", http.StatusInternalServerError)
+			return
+		}
+		f.primary = req.Key
+	case http.MethodDelete:
+		if req.Key == f.primary {
+			http.Error(w, "removing the primary key is not allowed", http.StatusInternalServerError)
+			return
+		}
+		if index != -1 {
+			f.keys = append(f.keys[:index], f.keys[index+1:]...)
+		}
+	}
+}
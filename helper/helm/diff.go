@@ -0,0 +1,157 @@
+package helm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Change operations of resources.
+const (
+	Added   = "+"
+	Removed = "-"
+	Changed = "~"
+)
+
+// Change is the change of a resource of a release.
+type Change struct {
+	// Resource is the kind and name of the resource, e.g. "Deployment
+	// consul-connect-injector".
+	Resource string
+	// Op is Added, Removed or Changed.
+	Op string
+	// Diff is the unified diff of the YAML of the resource.
+	Diff string
+}
+
+// DiffValues returns the unified diff of the YAML of the values current and
+// proposed, or "" if they're the same.
+func DiffValues(current, proposed map[string]interface{}) (string, error) {
+	currentYAML, err := valuesYAML(current)
+	if err != nil {
+		return "", err
+	}
+	proposedYAML, err := valuesYAML(proposed)
+	if err != nil {
+		return "", err
+	}
+	return unifiedDiff(currentYAML, proposedYAML)
+}
+
+// DiffManifests returns the changes of the resources of the manifest
+// current to proposed, sorted by resource.
+func DiffManifests(current, proposed string) ([]Change, error) {
+	currentResources, err := splitManifest(current)
+	if err != nil {
+		return nil, err
+	}
+	proposedResources, err := splitManifest(proposed)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for resource, currentYAML := range currentResources {
+		proposedYAML, ok := proposedResources[resource]
+		if ok && proposedYAML == currentYAML {
+			continue
+		}
+		op := Changed
+		if !ok {
+			op = Removed
+		}
+		diff, err := unifiedDiff(currentYAML, proposedYAML)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, Change{Resource: resource, Op: op, Diff: diff})
+	}
+	for resource, proposedYAML := range proposedResources {
+		if _, ok := currentResources[resource]; ok {
+			continue
+		}
+		diff, err := unifiedDiff("", proposedYAML)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, Change{Resource: resource, Op: Added, Diff: diff})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Resource < changes[j].Resource })
+	return changes, nil
+}
+
+// ParseManifest returns the resources of manifest, skipping the documents
+// of the templates that render nothing.
+func ParseManifest(manifest string) ([]unstructured.Unstructured, error) {
+	var resources []unstructured.Unstructured
+	for _, doc := range strings.Split(manifest, "\n---") {
+		var object map[string]interface{}
+		if err := yaml.Unmarshal([]byte(strings.TrimPrefix(doc, "---")), &object); err != nil {
+			return nil, fmt.Errorf("parsing the manifest: %s", err)
+		}
+		if object["kind"] == nil {
+			continue
+		}
+		resources = append(resources, unstructured.Unstructured{Object: object})
+	}
+	return resources, nil
+}
+
+// splitManifest returns the YAML of the resources of manifest keyed by
+// their kind and name.
+func splitManifest(manifest string) (map[string]string, error) {
+	resources := make(map[string]string)
+	for _, doc := range strings.Split(manifest, "\n---") {
+		doc = strings.TrimSpace(strings.TrimPrefix(doc, "---"))
+		var resource struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}
+		if err := yaml.Unmarshal([]byte(doc), &resource); err != nil {
+			return nil, fmt.Errorf("parsing the manifest: %s", err)
+		}
+		// Documents of templates rendering nothing only have comments.
+		if resource.Kind == "" {
+			continue
+		}
+		resources[resource.Kind+" "+resource.Metadata.Name] = doc + "\n"
+	}
+	return resources, nil
+}
+
+func valuesYAML(values map[string]interface{}) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	out, err := yaml.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("rendering the values: %s", err)
+	}
+	return string(out), nil
+}
+
+func unifiedDiff(current, proposed string) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(current),
+		B:        splitLines(proposed),
+		FromFile: "current",
+		ToFile:   "proposed",
+		Context:  3,
+	})
+}
+
+// splitLines splits s into lines keeping their newline. Unlike
+// difflib.SplitLines, it doesn't add an empty line to the end.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
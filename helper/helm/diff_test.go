@@ -0,0 +1,150 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffValues(t *testing.T) {
+	diff, err := DiffValues(
+		map[string]interface{}{"global": map[string]interface{}{"name": "consul"}, "server": map[string]interface{}{"replicas": 3}},
+		map[string]interface{}{"global": map[string]interface{}{"name": "consul"}, "server": map[string]interface{}{"replicas": 5}},
+	)
+	require.NoError(t, err)
+	require.Equal(t, `--- current
++++ proposed
+@@ -1,4 +1,4 @@
+ global:
+   name: consul
+ server:
+-  replicas: 3
++  replicas: 5
+`, diff)
+
+	diff, err = DiffValues(map[string]interface{}{"a": "b"}, map[string]interface{}{"a": "b"})
+	require.NoError(t, err)
+	require.Empty(t, diff)
+}
+
+func TestDiffManifests(t *testing.T) {
+	current := `---
+# Source: consul/templates/server-config-configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: consul-server-config
+data:
+  extra-from-values.json: '{}'
+---
+# Source: consul/templates/sync-catalog-deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: consul-sync-catalog
+---
+# Source: consul/templates/server-statefulset.yaml
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: consul-server
+spec:
+  replicas: 3
+`
+	proposed := `---
+# Source: consul/templates/server-config-configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: consul-server-config
+data:
+  extra-from-values.json: '{}'
+---
+# Source: consul/templates/server-statefulset.yaml
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: consul-server
+spec:
+  replicas: 5
+---
+# Source: consul/templates/controller-deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: consul-controller
+---
+# Source: consul/templates/mesh-gateway-deployment.yaml
+`
+
+	changes, err := DiffManifests(current, proposed)
+	require.NoError(t, err)
+	require.Equal(t, []Change{
+		{
+			Resource: "Deployment consul-controller",
+			Op:       Added,
+			Diff: `--- current
++++ proposed
+@@ -0,0 +1,5 @@
++# Source: consul/templates/controller-deployment.yaml
++apiVersion: apps/v1
++kind: Deployment
++metadata:
++  name: consul-controller
+`,
+		},
+		{
+			Resource: "Deployment consul-sync-catalog",
+			Op:       Removed,
+			Diff: `--- current
++++ proposed
+@@ -1,5 +0,0 @@
+-# Source: consul/templates/sync-catalog-deployment.yaml
+-apiVersion: apps/v1
+-kind: Deployment
+-metadata:
+-  name: consul-sync-catalog
+`,
+		},
+		{
+			Resource: "StatefulSet consul-server",
+			Op:       Changed,
+			Diff: `--- current
++++ proposed
+@@ -4,4 +4,4 @@
+ metadata:
+   name: consul-server
+ spec:
+-  replicas: 3
++  replicas: 5
+`,
+		},
+	}, changes)
+}
+
+func TestParseManifest(t *testing.T) {
+	manifest := `---
+# Source: consul/templates/server-statefulset.yaml
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: consul-server
+---
+# Source: consul/templates/mesh-gateway-deployment.yaml
+---
+# Source: consul/templates/crd-servicedefaults.yaml
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: servicedefaults.consul.hashicorp.com
+`
+	resources, err := ParseManifest(manifest)
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+	require.Equal(t, "StatefulSet", resources[0].GetKind())
+	require.Equal(t, "consul-server", resources[0].GetName())
+	require.Equal(t, "servicedefaults.consul.hashicorp.com", resources[1].GetName())
+
+	_, err = ParseManifest("kind: [")
+	require.Error(t, err)
+}
@@ -0,0 +1,373 @@
+// Package helm manages the releases of the Consul Helm chart with the Helm
+// SDK. The chart is embedded in the binary, so that neither the helm binary
+// nor the HashiCorp Helm repository are needed.
+package helm
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ChartVersion is the version of the embedded Consul chart.
+const ChartVersion = "0.32.1"
+
+// chartArchive is the archive of the Consul chart of version ChartVersion,
+// packaged from github.com/hashicorp/consul-helm.
+//
+//go:embed chart/consul-0.32.1.tgz
+var chartArchive []byte
+
+const (
+	// CRDGroup is the API group of the CRDs of the chart.
+	CRDGroup = "consul.hashicorp.com"
+	// ManagedByLabel is set to Helm on the resources Helm manages.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+)
+
+// CRDResource is the resource of the CustomResourceDefinitions, read with
+// the dynamic client since the Kubernetes client has no typed client for
+// them.
+var CRDResource = schema.GroupVersionResource{
+	Group:    "apiextensions.k8s.io",
+	Version:  "v1beta1",
+	Resource: "customresourcedefinitions",
+}
+
+// consulChart is the name of the Consul chart in the metadata of its
+// releases.
+const consulChart = "consul"
+
+// Client manages the releases with the Helm SDK, storing them in secrets
+// like the helm binary does.
+type Client struct {
+	// KubeConfig is the path of the kubeconfig. If empty, the default
+	// kubeconfig or the in-cluster config is used.
+	KubeConfig string
+
+	// config returns the configuration of the Helm actions on the releases
+	// of namespace, or of all namespaces if it's empty. It defaults to
+	// actionConfig and is only set by NewTestClient.
+	config func(namespace string) (*action.Configuration, error)
+	// kubeClient creates the namespaces of the releases. It defaults to the
+	// client of KubeConfig.
+	kubeClient kubernetes.Interface
+}
+
+// Release is a Helm release, as listed by `helm list`.
+type Release struct {
+	Name       string
+	Namespace  string
+	Revision   int
+	Status     string
+	Chart      string
+	AppVersion string
+}
+
+// ChartVersion is the version of the chart of the release.
+func (r Release) ChartVersion() string {
+	return strings.TrimPrefix(r.Chart, "consul-")
+}
+
+// ResourcePrefix is the prefix of the names of the resources of the release
+// with the user-supplied values, i.e. the consul.fullname of the chart:
+// fullnameOverride if it's set, else global.name, else the release name and
+// nameOverride or "consul", truncated to 63 characters.
+func (r Release) ResourcePrefix(values map[string]interface{}) string {
+	prefix, _, _ := unstructured.NestedString(values, "fullnameOverride")
+	if prefix == "" {
+		prefix, _, _ = unstructured.NestedString(values, "global", "name")
+	}
+	if prefix == "" {
+		name, _, _ := unstructured.NestedString(values, "nameOverride")
+		if name == "" {
+			name = "consul"
+		}
+		prefix = r.Name + "-" + name
+	}
+	if len(prefix) > 63 {
+		prefix = prefix[:63]
+	}
+	return strings.TrimSuffix(prefix, "-")
+}
+
+// BootstrapTokenSecretName is the name of the secret of the bootstrap token
+// written by server-acl-init for the resources prefixed by prefix.
+func BootstrapTokenSecretName(prefix string) string {
+	return prefix + "-bootstrap-acl-token"
+}
+
+// ReleaseOptions are the options of Install and Upgrade.
+type ReleaseOptions struct {
+	// Name is the name of the release.
+	Name string
+	// Namespace is the namespace of the release. Install creates it if it
+	// doesn't exist.
+	Namespace string
+	// Chart is the path of a chart archive or directory to install or
+	// upgrade to. If empty, the embedded Consul chart is used.
+	Chart string
+	// ValuesFiles are the paths of the values files, in order of
+	// precedence.
+	ValuesFiles []string
+	// Set are the values set with --set, e.g. global.name=consul. They
+	// take precedence over ValuesFiles.
+	Set []string
+	// Timeout is how long to wait for the resources of the release to be
+	// ready.
+	Timeout time.Duration
+}
+
+// ReleaseDetails are the values and manifest of a release.
+type ReleaseDetails struct {
+	// Config are the user-supplied values of the release.
+	Config map[string]interface{}
+	// Manifest are the rendered resources of the release.
+	Manifest string
+}
+
+// Releases returns the releases of the Consul chart in all namespaces.
+func (c *Client) Releases() ([]Release, error) {
+	cfg, err := c.actionConfig("")
+	if err != nil {
+		return nil, err
+	}
+	list := action.NewList(cfg)
+	list.AllNamespaces = true
+	list.All = true
+	list.SetStateMask()
+	all, err := list.Run()
+	if err != nil {
+		return nil, err
+	}
+	var releases []Release
+	for _, rel := range all {
+		if rel.Chart != nil && rel.Chart.Metadata != nil && rel.Chart.Metadata.Name == consulChart {
+			releases = append(releases, newRelease(rel))
+		}
+	}
+	return releases, nil
+}
+
+// Release returns the release of the Consul chart. It fails if there's
+// none or several.
+func (c *Client) Release() (Release, error) {
+	releases, err := c.Releases()
+	if err != nil {
+		return Release{}, fmt.Errorf("listing the Helm releases: %s", err)
+	}
+	switch len(releases) {
+	case 0:
+		return Release{}, errors.New("no installation of Consul found")
+	case 1:
+		return releases[0], nil
+	}
+	var names []string
+	for _, r := range releases {
+		names = append(names, r.Namespace+"/"+r.Name)
+	}
+	return Release{}, fmt.Errorf("found several installations of Consul: %s", strings.Join(names, ", "))
+}
+
+// Install installs the release of opts and waits for its resources to be
+// ready. It returns the notes of the chart.
+func (c *Client) Install(opts ReleaseOptions) (string, error) {
+	chrt, vals, err := opts.load()
+	if err != nil {
+		return "", err
+	}
+	cfg, err := c.actionConfig(opts.Namespace)
+	if err != nil {
+		return "", err
+	}
+	if err := c.createNamespace(cfg, opts.Namespace); err != nil {
+		return "", err
+	}
+	install := action.NewInstall(cfg)
+	install.ReleaseName = opts.Name
+	install.Namespace = opts.Namespace
+	install.Wait = opts.Timeout > 0
+	install.Timeout = opts.Timeout
+	rel, err := install.Run(chrt, vals)
+	if err != nil {
+		return "", fmt.Errorf("installing the chart: %s", err)
+	}
+	return rel.Info.Notes, nil
+}
+
+// Get returns the values and manifest of the latest revision of the
+// release name in namespace.
+func (c *Client) Get(name, namespace string) (*ReleaseDetails, error) {
+	cfg, err := c.actionConfig(namespace)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := action.NewGet(cfg).Run(name)
+	if err != nil {
+		return nil, fmt.Errorf("getting release %q: %s", name, err)
+	}
+	return &ReleaseDetails{Config: rel.Config, Manifest: rel.Manifest}, nil
+}
+
+// Values returns the values set by the user of the latest revision of
+// the release name in namespace, which Helm stores in the secret of the
+// revision.
+func (c *Client) Values(name, namespace string) (map[string]interface{}, error) {
+	cfg, err := c.actionConfig(namespace)
+	if err != nil {
+		return nil, err
+	}
+	vals, err := action.NewGetValues(cfg).Run(name)
+	if err != nil {
+		return nil, fmt.Errorf("getting the values of release %q: %s", name, err)
+	}
+	return vals, nil
+}
+
+// UpgradeDryRun returns the values and manifest the release of opts would
+// have if it was upgraded, without upgrading it.
+func (c *Client) UpgradeDryRun(opts ReleaseOptions) (*ReleaseDetails, error) {
+	rel, err := c.upgrade(opts, true)
+	if err != nil {
+		return nil, err
+	}
+	return &ReleaseDetails{Config: rel.Config, Manifest: rel.Manifest}, nil
+}
+
+// Upgrade upgrades the release of opts and waits for its resources to be
+// ready. Values that opts doesn't set are reset to the defaults of the
+// chart. It returns the notes of the chart.
+func (c *Client) Upgrade(opts ReleaseOptions) (string, error) {
+	rel, err := c.upgrade(opts, false)
+	if err != nil {
+		return "", err
+	}
+	return rel.Info.Notes, nil
+}
+
+func (c *Client) upgrade(opts ReleaseOptions, dryRun bool) (*release.Release, error) {
+	chrt, vals, err := opts.load()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := c.actionConfig(opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	upgrade := action.NewUpgrade(cfg)
+	upgrade.Namespace = opts.Namespace
+	upgrade.DryRun = dryRun
+	upgrade.Wait = !dryRun && opts.Timeout > 0
+	upgrade.Timeout = opts.Timeout
+	rel, err := upgrade.Run(opts.Name, chrt, vals)
+	if err != nil {
+		return nil, fmt.Errorf("upgrading the chart: %s", err)
+	}
+	return rel, nil
+}
+
+// Uninstall uninstalls the release name in namespace.
+func (c *Client) Uninstall(name, namespace string) error {
+	cfg, err := c.actionConfig(namespace)
+	if err != nil {
+		return err
+	}
+	if _, err := action.NewUninstall(cfg).Run(name); err != nil {
+		return fmt.Errorf("uninstalling release %q: %s", name, err)
+	}
+	return nil
+}
+
+// load loads the chart of opts and merges its values files and --set
+// values.
+func (opts ReleaseOptions) load() (*chart.Chart, map[string]interface{}, error) {
+	var chrt *chart.Chart
+	var err error
+	if opts.Chart == "" {
+		chrt, err = loader.LoadArchive(bytes.NewReader(chartArchive))
+	} else {
+		chrt, err = loader.Load(opts.Chart)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading the chart: %s", err)
+	}
+	valueOpts := values.Options{ValueFiles: opts.ValuesFiles, Values: opts.Set}
+	vals, err := valueOpts.MergeValues(getter.Providers{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading the values: %s", err)
+	}
+	return chrt, vals, nil
+}
+
+// actionConfig returns the configuration of the Helm actions on the
+// releases of namespace, or of all namespaces if it's empty.
+func (c *Client) actionConfig(namespace string) (*action.Configuration, error) {
+	if c.config != nil {
+		return c.config(namespace)
+	}
+	kubeFlags := genericclioptions.NewConfigFlags(false)
+	kubeFlags.KubeConfig = &c.KubeConfig
+	kubeFlags.Namespace = &namespace
+	var cfg action.Configuration
+	if err := cfg.Init(kubeFlags, namespace, "secret", func(string, ...interface{}) {}); err != nil {
+		return nil, fmt.Errorf("initializing Helm: %s", err)
+	}
+	return &cfg, nil
+}
+
+// createNamespace creates the namespace name of a release if it doesn't
+// exist.
+func (c *Client) createNamespace(cfg *action.Configuration, name string) error {
+	if c.kubeClient == nil {
+		var err error
+		c.kubeClient, err = cfg.KubernetesClientSet()
+		if err != nil {
+			return fmt.Errorf("initializing Kubernetes client: %s", err)
+		}
+	}
+	namespaces := c.kubeClient.CoreV1().Namespaces()
+	_, err := namespaces.Get(name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("getting namespace %q: %s", name, err)
+	}
+	_, err = namespaces.Create(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("creating namespace %q: %s", name, err)
+	}
+	return nil
+}
+
+// newRelease returns the Release of rel.
+func newRelease(rel *release.Release) Release {
+	r := Release{
+		Name:       rel.Name,
+		Namespace:  rel.Namespace,
+		Revision:   rel.Version,
+		Chart:      rel.Chart.Metadata.Name + "-" + rel.Chart.Metadata.Version,
+		AppVersion: rel.Chart.Metadata.AppVersion,
+	}
+	if rel.Info != nil {
+		r.Status = rel.Info.Status.String()
+	}
+	return r
+}
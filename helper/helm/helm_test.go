@@ -0,0 +1,259 @@
+package helm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that the embedded chart is the Consul chart of ChartVersion.
+func TestChartArchive(t *testing.T) {
+	chrt, err := loader.LoadArchive(bytes.NewReader(chartArchive))
+	require.NoError(t, err)
+	require.Equal(t, "consul", chrt.Metadata.Name)
+	require.Equal(t, ChartVersion, chrt.Metadata.Version)
+}
+
+func TestClient_Releases(t *testing.T) {
+	client, err := NewTestClient(fake.NewSimpleClientset(),
+		TestRelease{Name: "consul", Namespace: "consul", Revision: 2, Chart: "consul-0.24.1", AppVersion: "1.8.4"},
+		TestRelease{Name: "vault", Namespace: "vault", Chart: "vault-0.7.0", AppVersion: "1.5.2"},
+		TestRelease{Name: "esm", Namespace: "consul", Chart: "consul-esm-0.1.0", AppVersion: "0.4.0"},
+	)
+	require.NoError(t, err)
+
+	releases, err := client.Releases()
+	require.NoError(t, err)
+	require.Equal(t, []Release{{
+		Name:       "consul",
+		Namespace:  "consul",
+		Revision:   2,
+		Status:     "deployed",
+		Chart:      "consul-0.24.1",
+		AppVersion: "1.8.4",
+	}}, releases)
+	require.Equal(t, "0.24.1", releases[0].ChartVersion())
+}
+
+func TestClient_Install(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	base := filepath.Join(dir, "base.yaml")
+	require.NoError(t, ioutil.WriteFile(base, []byte("global:\n  name: consul\nserver:\n  replicas: 1\n"), 0644))
+	prod := filepath.Join(dir, "prod.yaml")
+	require.NoError(t, ioutil.WriteFile(prod, []byte("server:\n  replicas: 5\n"), 0644))
+
+	cases := map[string]struct {
+		opts      ReleaseOptions
+		expConfig map[string]interface{}
+	}{
+		"defaults": {
+			opts:      ReleaseOptions{Name: "consul", Namespace: "consul"},
+			expConfig: map[string]interface{}{},
+		},
+		"values": {
+			opts: ReleaseOptions{
+				Name:        "consul",
+				Namespace:   "mesh",
+				ValuesFiles: []string{base, prod},
+				Set:         []string{"server.replicas=3", "ui.enabled=false"},
+				Timeout:     10 * time.Minute,
+			},
+			expConfig: map[string]interface{}{
+				"global": map[string]interface{}{"name": "consul"},
+				"server": map[string]interface{}{"replicas": int64(3)},
+				"ui":     map[string]interface{}{"enabled": false},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			client, err := NewTestClient(kubeClient)
+			require.NoError(t, err)
+			notes, err := client.Install(c.opts)
+			require.NoError(t, err)
+			require.Contains(t, notes, "Thank you for installing HashiCorp Consul!")
+
+			_, err = kubeClient.CoreV1().Namespaces().Get(c.opts.Namespace, metav1.GetOptions{})
+			require.NoError(t, err, "the namespace is created")
+			releases, err := client.Releases()
+			require.NoError(t, err)
+			require.Equal(t, []Release{{
+				Name:       "consul",
+				Namespace:  c.opts.Namespace,
+				Revision:   1,
+				Status:     "deployed",
+				Chart:      "consul-" + ChartVersion,
+				AppVersion: "1.10.0",
+			}}, releases)
+			release, err := client.Get("consul", c.opts.Namespace)
+			require.NoError(t, err)
+			require.Equal(t, c.expConfig, release.Config)
+			require.Contains(t, release.Manifest, "kind: StatefulSet")
+		})
+	}
+}
+
+func TestClient_InstallErrors(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "consul"}})
+	client, err := NewTestClient(kubeClient, TestRelease{Name: "consul", Namespace: "consul", Chart: "consul-0.24.1"})
+	require.NoError(t, err)
+
+	_, err = client.Install(ReleaseOptions{Name: "consul", Namespace: "consul"})
+	require.EqualError(t, err, "installing the chart: cannot re-use a name that is still in use")
+	_, err = client.Install(ReleaseOptions{Name: "consul", Namespace: "consul", Chart: "/does/not/exist"})
+	require.EqualError(t, err, "loading the chart: stat /does/not/exist: no such file or directory")
+	_, err = client.Install(ReleaseOptions{Name: "consul", Namespace: "consul", ValuesFiles: []string{"/does/not/exist.yaml"}})
+	require.EqualError(t, err, "reading the values: open /does/not/exist.yaml: no such file or directory")
+}
+
+func TestClient_GetAndUpgrade(t *testing.T) {
+	client, err := NewTestClient(fake.NewSimpleClientset(), TestRelease{
+		Name:      "consul",
+		Namespace: "mesh",
+		Chart:     "consul-0.24.1",
+		Values:    `{"server":{"replicas":3}}`,
+		Manifest:  "kind: StatefulSet\n",
+	})
+	require.NoError(t, err)
+
+	current, err := client.Get("consul", "mesh")
+	require.NoError(t, err)
+	require.Equal(t, &ReleaseDetails{
+		Config:   map[string]interface{}{"server": map[string]interface{}{"replicas": float64(3)}},
+		Manifest: "kind: StatefulSet\n",
+	}, current)
+	values, err := client.Values("consul", "mesh")
+	require.NoError(t, err)
+	require.Equal(t, current.Config, values)
+
+	opts := ReleaseOptions{Name: "consul", Namespace: "mesh", Set: []string{"server.replicas=5"}, Timeout: time.Minute}
+	proposed, err := client.UpgradeDryRun(opts)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"server": map[string]interface{}{"replicas": int64(5)}}, proposed.Config)
+	require.Contains(t, proposed.Manifest, "replicas: 5")
+	release, err := client.Release()
+	require.NoError(t, err)
+	require.Equal(t, 1, release.Revision, "a dry run doesn't upgrade")
+
+	_, err = client.Upgrade(opts)
+	require.NoError(t, err)
+	release, err = client.Release()
+	require.NoError(t, err)
+	require.Equal(t, 2, release.Revision)
+	require.Equal(t, "consul-"+ChartVersion, release.Chart)
+	upgraded, err := client.Get("consul", "mesh")
+	require.NoError(t, err)
+	require.Equal(t, proposed, upgraded)
+
+	_, err = client.Upgrade(ReleaseOptions{Name: "dc2", Namespace: "mesh"})
+	require.EqualError(t, err, `upgrading the chart: "dc2" has no deployed releases`)
+}
+
+func TestClient_Uninstall(t *testing.T) {
+	client, err := NewTestClient(fake.NewSimpleClientset(), TestRelease{Name: "consul", Namespace: "mesh", Chart: "consul-0.24.1"})
+	require.NoError(t, err)
+	require.NoError(t, client.Uninstall("consul", "mesh"))
+	releases, err := client.Releases()
+	require.NoError(t, err)
+	require.Empty(t, releases)
+
+	require.EqualError(t, client.Uninstall("consul", "mesh"), `uninstalling release "consul": no release provided`)
+}
+
+func TestRelease_ResourcePrefix(t *testing.T) {
+	cases := map[string]struct {
+		release   string
+		values    map[string]interface{}
+		expPrefix string
+	}{
+		"defaults": {
+			release:   "prod",
+			expPrefix: "prod-consul",
+		},
+		"name override": {
+			release:   "prod",
+			values:    map[string]interface{}{"nameOverride": "mesh"},
+			expPrefix: "prod-mesh",
+		},
+		"global.name": {
+			release: "prod",
+			values: map[string]interface{}{
+				"nameOverride": "mesh",
+				"global":       map[string]interface{}{"name": "hashi"},
+			},
+			expPrefix: "hashi",
+		},
+		"fullname override": {
+			release: "prod",
+			values: map[string]interface{}{
+				"fullnameOverride": "override",
+				"global":           map[string]interface{}{"name": "hashi"},
+			},
+			expPrefix: "override",
+		},
+		"truncated": {
+			release: "prod",
+			values: map[string]interface{}{
+				"fullnameOverride": strings.Repeat("a", 62) + "-b",
+			},
+			expPrefix: strings.Repeat("a", 62),
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.expPrefix, Release{Name: c.release}.ResourcePrefix(c.values))
+		})
+	}
+}
+
+func TestClient_Release(t *testing.T) {
+	cases := map[string]struct {
+		releases   []TestRelease
+		expRelease Release
+		expErr     string
+	}{
+		"none": {
+			releases: []TestRelease{{Name: "vault", Namespace: "vault", Chart: "vault-0.7.0"}},
+			expErr:   "no installation of Consul found",
+		},
+		"one": {
+			releases:   []TestRelease{{Name: "consul", Namespace: "consul", Chart: "consul-0.24.1"}},
+			expRelease: Release{Name: "consul", Namespace: "consul", Revision: 1, Status: "deployed", Chart: "consul-0.24.1"},
+		},
+		"several": {
+			releases: []TestRelease{
+				{Name: "consul", Namespace: "consul", Chart: "consul-0.24.1"},
+				{Name: "dc2", Namespace: "dc2", Chart: "consul-0.24.0"},
+			},
+			expErr: "found several installations of Consul: consul/consul, dc2/dc2",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, err := NewTestClient(fake.NewSimpleClientset(), c.releases...)
+			require.NoError(t, err)
+			release, err := client.Release()
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expRelease, release)
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// TestRelease is a release stored by the client of NewTestClient.
+type TestRelease struct {
+	Name      string
+	Namespace string
+	// Chart is the name and version of the chart, e.g. consul-0.24.1.
+	Chart      string
+	AppVersion string
+	// Revision defaults to 1.
+	Revision int
+	// Status defaults to deployed.
+	Status string
+	// Values are the user-supplied values, in YAML or JSON.
+	Values   string
+	Manifest string
+}
+
+// NewTestClient returns a Client storing the releases in memory, starting
+// with releases. It renders the chart on installs and upgrades without
+// creating its resources, and creates the namespaces with kubeClient, which
+// may be nil if nothing is installed.
+func NewTestClient(kubeClient kubernetes.Interface, releases ...TestRelease) (*Client, error) {
+	mem := driver.NewMemory()
+	store := storage.Init(mem)
+	for _, r := range releases {
+		i := strings.LastIndex(r.Chart, "-")
+		if i < 0 {
+			return nil, fmt.Errorf("chart %q of release %q has no version", r.Chart, r.Name)
+		}
+		var config map[string]interface{}
+		if err := yaml.Unmarshal([]byte(r.Values), &config); err != nil {
+			return nil, fmt.Errorf("parsing the values of release %q: %s", r.Name, err)
+		}
+		rel := &release.Release{
+			Name:      r.Name,
+			Namespace: r.Namespace,
+			Version:   r.Revision,
+			Info:      &release.Info{Status: release.StatusDeployed},
+			Chart: &chart.Chart{Metadata: &chart.Metadata{
+				Name:       r.Chart[:i],
+				Version:    r.Chart[i+1:],
+				AppVersion: r.AppVersion,
+			}},
+			Config:   config,
+			Manifest: r.Manifest,
+		}
+		if rel.Version == 0 {
+			rel.Version = 1
+		}
+		if r.Status != "" {
+			rel.Info.Status = release.Status(r.Status)
+		}
+		mem.SetNamespace(r.Namespace)
+		if err := store.Create(rel); err != nil {
+			return nil, err
+		}
+	}
+
+	config := func(namespace string) (*action.Configuration, error) {
+		mem.SetNamespace(namespace)
+		return &action.Configuration{
+			Releases:     store,
+			KubeClient:   &kubefake.PrintingKubeClient{Out: ioutil.Discard},
+			Capabilities: chartutil.DefaultCapabilities,
+			Log:          func(string, ...interface{}) {},
+		}, nil
+	}
+	return &Client{config: config, kubeClient: kubeClient}, nil
+}
+
+// TestCRDClient is a dynamic client listing, getting and deleting the
+// CRDs. The fake dynamic client of client-go can't list resources.
+type TestCRDClient struct {
+	dynamic.Interface
+	CRDs []unstructured.Unstructured
+
+	// OnDelete, if set, is called before a CRD is deleted.
+	OnDelete func(name string)
+}
+
+// NewTestCRD returns a CRD of group, labeled as managed by managedBy if
+// it's set.
+func NewTestCRD(name, group, managedBy string) unstructured.Unstructured {
+	crd := unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name},
+		"spec":     map[string]interface{}{"group": group},
+	}}
+	if managedBy != "" {
+		crd.SetLabels(map[string]string{ManagedByLabel: managedBy})
+	}
+	return crd
+}
+
+func (f *TestCRDClient) Resource(resource schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &testCRDResource{client: f}
+}
+
+type testCRDResource struct {
+	dynamic.NamespaceableResourceInterface
+	client *TestCRDClient
+}
+
+func (f *testCRDResource) List(opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	return &unstructured.UnstructuredList{Items: f.client.CRDs}, nil
+}
+
+func (f *testCRDResource) Get(name string, opts metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	for _, crd := range f.client.CRDs {
+		if crd.GetName() == name {
+			return crd.DeepCopy(), nil
+		}
+	}
+	return nil, k8serrors.NewNotFound(CRDResource.GroupResource(), name)
+}
+
+func (f *testCRDResource) Delete(name string, opts *metav1.DeleteOptions, subresources ...string) error {
+	for i, crd := range f.client.CRDs {
+		if crd.GetName() == name {
+			if f.client.OnDelete != nil {
+				f.client.OnDelete(name)
+			}
+			f.client.CRDs = append(f.client.CRDs[:i], f.client.CRDs[i+1:]...)
+			return nil
+		}
+	}
+	return k8serrors.NewNotFound(CRDResource.GroupResource(), name)
+}
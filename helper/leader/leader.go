@@ -0,0 +1,139 @@
+// Package leader elects the leader of the replicas of a component, e.g.
+// sync-catalog or the controller, so that they can run with several
+// replicas for a fast failover while only one of them writes to Consul.
+//
+// The leader lock is a coordination.k8s.io/v1 Lease, or a ConfigMap for the
+// components that used one before Leases. The leader seen by each replica is
+// reported in consul_k8s_leader_election_leader and whether it's the leader
+// in consul_k8s_leader_election_is_leader, both labeled by lock.
+package leader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/metrics"
+	"github.com/hashicorp/go-hclog"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// LeasesLock is the lock type of a coordination.k8s.io/v1 Lease.
+	LeasesLock = "leases"
+	// ConfigMapsLock is the lock type of a ConfigMap with the leader
+	// election record in an annotation.
+	ConfigMapsLock = "configmaps"
+)
+
+// The defaults of Config. A new leader is elected at most DefaultLeaseDuration
+// after the leader stops renewing the lock.
+const (
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// NewLock returns the lock of lockType, LeasesLock or ConfigMapsLock, named
+// name in namespace. If config has no event recorder, the events of the
+// election are dropped.
+func NewLock(lockType, namespace, name string, kubeClient kubernetes.Interface,
+	config resourcelock.ResourceLockConfig) (resourcelock.Interface, error) {
+	switch lockType {
+	case LeasesLock:
+		return resourcelock.New(resourcelock.LeasesResourceLock, namespace, name, kubeClient.CoreV1(), kubeClient.CoordinationV1(), config)
+	case ConfigMapsLock:
+		return resourcelock.New(resourcelock.ConfigMapsResourceLock, namespace, name, kubeClient.CoreV1(), kubeClient.CoordinationV1(), config)
+	default:
+		return nil, fmt.Errorf("unknown lock type %q, must be %q or %q", lockType, LeasesLock, ConfigMapsLock)
+	}
+}
+
+// Config configures the election of Start.
+type Config struct {
+	Log  hclog.Logger
+	Lock resourcelock.Interface
+
+	// LeaseDuration, RenewDeadline and RetryPeriod configure the election,
+	// see leaderelection.LeaderElectionConfig. They default to
+	// DefaultLeaseDuration, DefaultRenewDeadline and DefaultRetryPeriod.
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+
+	// OnStartedLeading is called once the lock is acquired with a channel
+	// closed once the stop channel of Start is closed or the lock is lost.
+	OnStartedLeading func(stopCh <-chan struct{})
+
+	// OnStoppedLeading, if set, is called once the lock is lost. The
+	// election doesn't start over, so the component should exit rather
+	// than keep running next to the new leader.
+	OnStoppedLeading func()
+}
+
+// Start campaigns for the lock of config in the background, and calls
+// config.OnStartedLeading once it's acquired. It returns an error if the
+// config is invalid.
+func Start(config Config, stopCh <-chan struct{}) error {
+	if config.LeaseDuration == 0 {
+		config.LeaseDuration = DefaultLeaseDuration
+	}
+	if config.RenewDeadline == 0 {
+		config.RenewDeadline = DefaultRenewDeadline
+	}
+	if config.RetryPeriod == 0 {
+		config.RetryPeriod = DefaultRetryPeriod
+	}
+	lock := config.Lock
+	if lock == nil {
+		return fmt.Errorf("lock must be set")
+	}
+	if config.OnStartedLeading == nil {
+		return fmt.Errorf("OnStartedLeading must be set")
+	}
+	desc := lock.Describe()
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: config.LeaseDuration,
+		RenewDeadline: config.RenewDeadline,
+		RetryPeriod:   config.RetryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				config.Log.Info("acquired leader lock", "lock", desc)
+				metrics.SetLeading(desc, true)
+				leadingStopCh := make(chan struct{})
+				go func() {
+					select {
+					case <-stopCh:
+					case <-leaderCtx.Done():
+					}
+					close(leadingStopCh)
+				}()
+				config.OnStartedLeading(leadingStopCh)
+			},
+			OnStoppedLeading: func() {
+				metrics.SetLeading(desc, false)
+				config.Log.Error("lost leader lock", "lock", desc)
+				if config.OnStoppedLeading != nil {
+					config.OnStoppedLeading()
+				}
+			},
+			OnNewLeader: func(identity string) {
+				config.Log.Info("new leader elected", "lock", desc, "identity", identity)
+				metrics.SetLeader(desc, identity)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	metrics.SetLeading(desc, false)
+	config.Log.Info("waiting for leader lock", "lock", desc, "identity", lock.Identity())
+	// The elector isn't cancelled when stopCh is closed, so that stopping
+	// doesn't call OnStoppedLeading.
+	go elector.Run(context.Background())
+	return nil
+}
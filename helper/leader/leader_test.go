@@ -0,0 +1,133 @@
+package leader
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+func TestNewLock(t *testing.T) {
+	config := resourcelock.ResourceLockConfig{Identity: "replica-1"}
+	lock, err := NewLock(LeasesLock, "default", "leader", fake.NewSimpleClientset(), config)
+	require.NoError(t, err)
+	require.IsType(t, &resourcelock.LeaseLock{}, lock)
+	require.Equal(t, "default/leader", lock.Describe())
+	require.Equal(t, "replica-1", lock.Identity())
+
+	lock, err = NewLock(ConfigMapsLock, "default", "leader", fake.NewSimpleClientset(), config)
+	require.NoError(t, err)
+	require.IsType(t, &resourcelock.ConfigMapLock{}, lock)
+
+	_, err = NewLock("endpoints", "default", "leader", fake.NewSimpleClientset(), config)
+	require.EqualError(t, err, `unknown lock type "endpoints", must be "leases" or "configmaps"`)
+}
+
+func TestStart_InvalidConfig(t *testing.T) {
+	lock, err := NewLock(LeasesLock, "default", "leader", fake.NewSimpleClientset(), resourcelock.ResourceLockConfig{})
+	require.NoError(t, err)
+	cases := map[string]struct {
+		config Config
+		expErr string
+	}{
+		"no lock": {
+			config: Config{OnStartedLeading: func(<-chan struct{}) {}},
+			expErr: "lock must be set",
+		},
+		"no callback": {
+			config: Config{Lock: lock},
+			expErr: "OnStartedLeading must be set",
+		},
+		"renew deadline above lease duration": {
+			config: Config{Lock: lock, OnStartedLeading: func(<-chan struct{}) {}, RenewDeadline: time.Minute},
+			expErr: "leaseDuration must be greater than renewDeadline",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			c.config.Log = hclog.NewNullLogger()
+			require.EqualError(t, Start(c.config, nil), c.expErr)
+		})
+	}
+}
+
+// Test that the standby replica takes over once the leader stops renewing
+// the Lease.
+func TestStart_Failover(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	type replica struct {
+		lock    *failingLock
+		leading int32
+		stopped int32
+	}
+	start := func(identity string) *replica {
+		lock, err := NewLock(LeasesLock, "default", "consul-k8s-sync-catalog-leader", client,
+			resourcelock.ResourceLockConfig{Identity: identity})
+		require.NoError(t, err)
+		r := &replica{lock: &failingLock{Interface: lock}}
+		require.NoError(t, Start(Config{
+			Log:           hclog.NewNullLogger(),
+			Lock:          r.lock,
+			LeaseDuration: time.Second,
+			RenewDeadline: 500 * time.Millisecond,
+			RetryPeriod:   100 * time.Millisecond,
+			OnStartedLeading: func(leadingStopCh <-chan struct{}) {
+				atomic.StoreInt32(&r.leading, 1)
+				<-leadingStopCh
+				atomic.StoreInt32(&r.leading, 0)
+			},
+			OnStoppedLeading: func() { atomic.StoreInt32(&r.stopped, 1) },
+		}, stopCh))
+		return r
+	}
+	isLeading := func(r *replica) func() bool {
+		return func() bool { return atomic.LoadInt32(&r.leading) == 1 }
+	}
+
+	leader := start("replica-1")
+	require.Eventually(t, isLeading(leader), 5*time.Second, 20*time.Millisecond)
+	standby := start("replica-2")
+	time.Sleep(500 * time.Millisecond)
+	require.False(t, isLeading(standby)())
+
+	// The leader can't renew the Lease anymore, e.g. since it's partitioned
+	// from the API server.
+	atomic.StoreInt32(&leader.lock.failing, 1)
+	require.Eventually(t, isLeading(standby), 5*time.Second, 20*time.Millisecond)
+	require.False(t, isLeading(leader)())
+	require.Equal(t, int32(1), atomic.LoadInt32(&leader.stopped))
+
+	lease, err := client.CoordinationV1().Leases("default").Get("consul-k8s-sync-catalog-leader", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "replica-2", *lease.Spec.HolderIdentity)
+	require.Equal(t, int32(1), *lease.Spec.LeaseTransitions)
+}
+
+// failingLock is a lock whose reads and writes fail once failing is 1.
+type failingLock struct {
+	resourcelock.Interface
+	failing int32
+}
+
+func (l *failingLock) Get() (*resourcelock.LeaderElectionRecord, []byte, error) {
+	if atomic.LoadInt32(&l.failing) == 1 {
+		return nil, nil, errors.New("unavailable")
+	}
+	return l.Interface.Get()
+}
+
+func (l *failingLock) Update(ler resourcelock.LeaderElectionRecord) error {
+	if atomic.LoadInt32(&l.failing) == 1 {
+		return errors.New("unavailable")
+	}
+	return l.Interface.Update(ler)
+}
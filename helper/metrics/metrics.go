@@ -0,0 +1,244 @@
+// Package metrics holds the Prometheus metrics shared by the components
+// of consul-k8s, so that they're named and labeled the same way in all of
+// them: consul_k8s_consul_request_duration_seconds observes the Consul API
+// requests by method, endpoint and response code,
+// consul_k8s_reconcile_duration_seconds the reconciles of the Kubernetes
+// resources by controller and result, and
+// consul_k8s_operation_duration_seconds the other operations, e.g. the
+// admission requests of the injector, by operation and result.
+// consul_k8s_consul_write_queue_depth is the number of Consul API writes
+// waiting for the client-side rate limiter.
+// consul_k8s_leader_election_leader is 1 for the identity of the leader
+// of each leader lock, and consul_k8s_leader_election_is_leader is whether
+// this replica is the leader.
+//
+// The result label is "success" or "error", so the error rates are the
+// rates of the histograms' counts with result="error". Each component
+// serves them on its own endpoint, so the scrape targets tell them apart.
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "consul_k8s"
+
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+var (
+	consulRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "consul_request_duration_seconds",
+		Help:      "Duration of the Consul API requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "endpoint", "code"})
+
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of the reconciles of the Kubernetes resources.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"controller", "result"})
+
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of the operations of the component.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+
+	consulWriteQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consul_write_queue_depth",
+		Help:      "Number of Consul API writes waiting for the client-side rate limiter.",
+	})
+
+	leaderElectionLeader = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader_election_leader",
+		Help:      "Leader of the leader lock seen by this replica: 1 for the identity of the leader.",
+	}, []string{"lock", "identity"})
+
+	leaderElectionIsLeader = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader_election_is_leader",
+		Help:      "Whether this replica holds the leader lock.",
+	}, []string{"lock"})
+
+	// leaders are the identities of the leaders of the locks, to delete
+	// their series once they're replaced.
+	leadersLock sync.Mutex
+	leaders     = make(map[string]string)
+)
+
+// ObserveReconcile records a reconcile of controller that started at start
+// and returned err.
+func ObserveReconcile(controller string, start time.Time, err error) {
+	reconcileDuration.WithLabelValues(controller, result(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveOperation records an operation that started at start and
+// returned err.
+func ObserveOperation(operation string, start time.Time, err error) {
+	operationDuration.WithLabelValues(operation, result(err)).Observe(time.Since(start).Seconds())
+}
+
+// QueueConsulWrite records a Consul API write waiting for the rate limiter
+// in consul_k8s_consul_write_queue_depth until the returned function is
+// called.
+func QueueConsulWrite() func() {
+	consulWriteQueueDepth.Inc()
+	return consulWriteQueueDepth.Dec
+}
+
+// SetLeader records identity as the leader of lock in
+// consul_k8s_leader_election_leader.
+func SetLeader(lock, identity string) {
+	leadersLock.Lock()
+	defer leadersLock.Unlock()
+	if previous, ok := leaders[lock]; ok {
+		leaderElectionLeader.DeleteLabelValues(lock, previous)
+	}
+	leaders[lock] = identity
+	leaderElectionLeader.WithLabelValues(lock, identity).Set(1)
+}
+
+// SetLeading records whether this replica holds lock in
+// consul_k8s_leader_election_is_leader.
+func SetLeading(lock string, leading bool) {
+	value := 0.0
+	if leading {
+		value = 1
+	}
+	leaderElectionIsLeader.WithLabelValues(lock).Set(value)
+}
+
+func result(err error) string {
+	if err != nil {
+		return resultError
+	}
+	return resultSuccess
+}
+
+// Transport returns a transport recording the Consul API requests of next
+// in consul_k8s_consul_request_duration_seconds.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return &consulTransport{next: next}
+}
+
+// consulTransport records the duration of the requests of next.
+type consulTransport struct {
+	next http.RoundTripper
+}
+
+func (t *consulTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := resultError
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	consulRequestDuration.WithLabelValues(req.Method, endpoint(req.URL.Path), code).
+		Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// nameGroups are the endpoint groups whose third path segment is the name
+// of a resource rather than part of the endpoint, e.g. /v1/kv/<key>.
+var nameGroups = map[string]bool{
+	"kv":        true,
+	"namespace": true,
+	"partition": true,
+	"peering":   true,
+	"query":     true,
+}
+
+// endpoint returns the endpoint of the API path without the names of the
+// resources, e.g. /v1/catalog/service for /v1/catalog/service/web, to keep
+// the cardinality of the label bounded.
+func endpoint(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	n := 3
+	if len(segments) > 1 && nameGroups[segments[1]] {
+		n = 2
+	}
+	if len(segments) > n {
+		segments = segments[:n]
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// Handler returns the handler serving on /metrics the metrics of this
+// package, the Go runtime and process metrics and collectors.
+func Handler(collectors ...prometheus.Collector) (http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	collectors = append([]prometheus.Collector{
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		consulRequestDuration,
+		reconcileDuration,
+		operationDuration,
+		consulWriteQueueDepth,
+		leaderElectionLeader,
+		leaderElectionIsLeader,
+	}, collectors...)
+	for _, collector := range collectors {
+		if err := registry.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	return mux, nil
+}
+
+// Pprof returns a handler serving the pprof profiles on /debug/pprof/ and
+// the other paths with handler.
+func Pprof(handler http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", handler)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// ListenAndServe serves Handler on addr until the returned server is
+// closed. If enablePprof is true, it also serves Pprof.
+func ListenAndServe(logger hclog.Logger, addr string, enablePprof bool) (*http.Server, error) {
+	handler, err := Handler()
+	if err != nil {
+		return nil, fmt.Errorf("registering metrics: %s", err)
+	}
+	if enablePprof {
+		handler = Pprof(handler)
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %s", addr, err)
+	}
+	server := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			logger.Error("error serving metrics", "addr", addr, "err", err)
+		}
+	}()
+	logger.Info("serving metrics", "addr", listener.Addr().String())
+	return server, nil
+}
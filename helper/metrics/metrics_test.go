@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"/v1/agent/self":                     "/v1/agent/self",
+		"/v1/agent/service/deregister/web-1": "/v1/agent/service",
+		"/v1/catalog/service/web":            "/v1/catalog/service",
+		"/v1/config/service-defaults/web":    "/v1/config/service-defaults",
+		"/v1/kv/consul-k8s/migrated":         "/v1/kv",
+		"/v1/namespace/team-a":               "/v1/namespace",
+		"/v1/acl/bootstrap":                  "/v1/acl/bootstrap",
+	}
+	for path, exp := range cases {
+		t.Run(path, func(t *testing.T) {
+			require.Equal(t, exp, endpoint(path))
+		})
+	}
+}
+
+// Test that the handler serves the Consul API requests of the clients of
+// Transport, the observed reconciles and operations and the leaders.
+func TestHandler(t *testing.T) {
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Config": {"Datacenter": "dc1"}}`))
+	}))
+	defer consul.Close()
+	client, err := api.NewClient(&api.Config{Address: consul.URL, HttpClient: &http.Client{Transport: Transport(http.DefaultTransport)}})
+	require.NoError(t, err)
+	_, err = client.Agent().Self()
+	require.NoError(t, err)
+	ObserveReconcile("servicedefaults", time.Now(), nil)
+	ObserveOperation("inject", time.Now(), errors.New("invalid pod"))
+	done := QueueConsulWrite()
+	QueueConsulWrite()()
+	SetLeader("default/sync-catalog", "replica-1")
+	SetLeader("default/sync-catalog", "replica-2")
+	SetLeading("default/sync-catalog", true)
+
+	handler, err := Handler()
+	require.NoError(t, err)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	body := string(data)
+	require.Contains(t, body, `consul_k8s_consul_request_duration_seconds_count{code="200",endpoint="/v1/agent/self",method="GET"} 1`+"\n")
+	require.Contains(t, body, `consul_k8s_reconcile_duration_seconds_count{controller="servicedefaults",result="success"} 1`+"\n")
+	require.Contains(t, body, `consul_k8s_operation_duration_seconds_count{operation="inject",result="error"} 1`+"\n")
+	require.Contains(t, body, "consul_k8s_consul_write_queue_depth 1\n")
+	require.Contains(t, body, `consul_k8s_leader_election_leader{identity="replica-2",lock="default/sync-catalog"} 1`+"\n")
+	require.NotContains(t, body, `identity="replica-1"`)
+	require.Contains(t, body, `consul_k8s_leader_election_is_leader{lock="default/sync-catalog"} 1`+"\n")
+	require.Contains(t, body, "go_goroutines ")
+	done()
+}
+
+func TestPprof(t *testing.T) {
+	handler, err := Handler()
+	require.NoError(t, err)
+	server := httptest.NewServer(Pprof(handler))
+	defer server.Close()
+	for _, path := range []string{"/metrics", "/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/goroutine"} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode, path)
+	}
+}
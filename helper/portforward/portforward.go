@@ -0,0 +1,120 @@
+// Package portforward forwards local ports to the ports of pods with
+// kubectl port-forward, e.g. to reach the Envoy admin API of the sidecars,
+// which only listens on localhost.
+package portforward
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvoyAdminPort is the port of the Envoy admin API of the injected
+// sidecars.
+const EnvoyAdminPort = 19000
+
+// forwarding matches the line kubectl port-forward prints once forwarding,
+// e.g. "Forwarding from 127.0.0.1:54321 -> 19000".
+var forwarding = regexp.MustCompile(`^Forwarding from (127\.0\.0\.1:\d+) -> \d+`)
+
+// PortForward forwards a random local port to a port of a pod.
+type PortForward struct {
+	// Binary is the path of the kubectl binary. It defaults to kubectl in
+	// the PATH.
+	Binary string
+	// KubeConfig is the path of the kubeconfig passed to kubectl. If empty,
+	// kubectl uses its default.
+	KubeConfig string
+	// Timeout is how long Open waits for the forwarding to start. It
+	// defaults to 30 seconds.
+	Timeout time.Duration
+
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+// Open forwards a local port to remotePort of the pod name in namespace and
+// returns the local address. Close must be called to stop forwarding.
+func (p *PortForward) Open(namespace, name string, remotePort int) (string, error) {
+	binary := p.Binary
+	if binary == "" {
+		binary = "kubectl"
+	}
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	args := []string{"port-forward", "pod/" + name, fmt.Sprintf(":%d", remotePort), "--namespace", namespace}
+	if p.KubeConfig != "" {
+		args = append(args, "--kubeconfig", p.KubeConfig)
+	}
+
+	p.cmd = exec.Command(binary, args...)
+	p.cmd.Stderr = &p.stderr
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := p.cmd.Start(); err != nil {
+		return "", fmt.Errorf("kubectl port-forward: %s", err)
+	}
+
+	addrCh := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if match := forwarding.FindStringSubmatch(scanner.Text()); match != nil {
+				addrCh <- match[1]
+				// kubectl blocks when its output isn't read.
+				io.Copy(ioutil.Discard, stdout)
+				return
+			}
+		}
+		close(addrCh)
+	}()
+
+	select {
+	case addr, ok := <-addrCh:
+		if ok {
+			return addr, nil
+		}
+		p.cmd.Wait()
+		return "", fmt.Errorf("kubectl port-forward pod/%s: %s", name, strings.TrimSpace(p.stderr.String()))
+	case <-time.After(timeout):
+		p.Close()
+		return "", fmt.Errorf("kubectl port-forward pod/%s: timed out after %s", name, timeout)
+	}
+}
+
+// Close stops forwarding.
+func (p *PortForward) Close() {
+	if p.cmd != nil && p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+		p.cmd.Wait()
+	}
+}
+
+// Forwarder opens port forwards. It's a function so that tests can replace
+// the port forwards with local servers.
+type Forwarder func(namespace, name string, remotePort int) (addr string, close func(), err error)
+
+// Kubectl returns the Forwarder of kubectl port-forward with the binary and
+// the kubeconfig kubeConfig.
+func Kubectl(binary, kubeConfig string) Forwarder {
+	return func(namespace, name string, remotePort int) (string, func(), error) {
+		p := &PortForward{Binary: binary, KubeConfig: kubeConfig}
+		addr, err := p.Open(namespace, name, remotePort)
+		if err != nil {
+			return "", nil, err
+		}
+		var once sync.Once
+		return addr, func() { once.Do(p.Close) }, nil
+	}
+}
@@ -0,0 +1,63 @@
+package portforward
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// kubectl writes a fake kubectl binary running script to dir. The script
+// must exec its last command for Close to stop it.
+func kubectl(t *testing.T, dir, script string) string {
+	binary := filepath.Join(dir, "kubectl")
+	require.NoError(t, ioutil.WriteFile(binary, []byte("#!/bin/sh\n"+script), 0755))
+	return binary
+}
+
+func TestPortForward_Open(t *testing.T) {
+	cases := map[string]struct {
+		script  string
+		expAddr string
+		expErr  string
+	}{
+		"forwarding": {
+			script:  "echo \"$@\" > \"$(dirname \"$0\")/args\"\necho 'Forwarding from 127.0.0.1:54321 -> 19000'\necho 'Forwarding from [::1]:54321 -> 19000'\nexec sleep 60\n",
+			expAddr: "127.0.0.1:54321",
+		},
+		"error": {
+			script: "echo 'error: unable to forward port because pod is not running. Current status=Pending' >&2\nexit 1\n",
+			expErr: "kubectl port-forward pod/web-0: error: unable to forward port because pod is not running. Current status=Pending",
+		},
+		"timeout": {
+			script: "exec sleep 60\n",
+			expErr: "kubectl port-forward pod/web-0: timed out after 100ms",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "portforward")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			p := &PortForward{Binary: kubectl(t, dir, c.script), Timeout: 100 * time.Millisecond}
+			if c.expAddr != "" {
+				p.Timeout = 10 * time.Second
+			}
+			addr, err := p.Open("default", "web-0", EnvoyAdminPort)
+			defer p.Close()
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expAddr, addr)
+			args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+			require.NoError(t, err)
+			require.Equal(t, "port-forward pod/web-0 :19000 --namespace default\n", string(args))
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Package sds implements an Envoy secret discovery service (SDS) server
+// serving the certificates of Kubernetes TLS secrets, so that gateways
+// can terminate TLS with certificates issued outside of Consul, e.g. by
+// cert-manager. Envoy keeps the streams of its secrets open and the
+// server pushes the certificates again whenever their secrets change, so
+// the certificates are rotated without restarting the gateways.
+package sds
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/hashicorp/go-hclog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// serviceName is the gRPC service of the v3 SDS API.
+	serviceName = "envoy.service.secret.v3.SecretDiscoveryService"
+
+	// SecretTypeURL is the type of the resources of the SDS API.
+	SecretTypeURL = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret"
+)
+
+// Server serves the certificates of the TLS secrets it's given with
+// Upsert as SDS resources named after the secrets. Resources whose secret
+// doesn't exist aren't sent, so Envoy waits for them, and Envoy keeps the
+// last certificate it received if the secret is deleted.
+type Server struct {
+	Log hclog.Logger
+
+	lock     sync.Mutex
+	certs    map[string]certificate
+	watchers map[chan struct{}]bool
+	nonce    uint64
+}
+
+// certificate is the certificate of a TLS secret.
+type certificate struct {
+	chain []byte
+	key   []byte
+}
+
+// NewServer returns a server without certificates.
+func NewServer(log hclog.Logger) *Server {
+	return &Server{
+		Log:      log,
+		certs:    make(map[string]certificate),
+		watchers: make(map[chan struct{}]bool),
+	}
+}
+
+// Register registers the SDS service of the server with the gRPC server.
+func (s *Server) Register(g *grpc.Server) {
+	g.RegisterService(&grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*secretDiscoveryServer)(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "StreamSecrets",
+			Handler:       streamSecretsHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		}},
+		Metadata: "envoy/service/secret/v3/sds.proto",
+	}, s)
+}
+
+// Upsert sets the certificate of the secret and pushes it to the streams
+// of its resource. Secrets without the tls.crt and tls.key keys are
+// removed.
+func (s *Server) Upsert(secret *corev1.Secret) {
+	chain, key := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	if len(chain) == 0 || len(key) == 0 {
+		s.Delete(secret.Name)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.certs[secret.Name] = certificate{chain: chain, key: key}
+	s.notify()
+}
+
+// Delete removes the certificate of the secret.
+func (s *Server) Delete(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.certs[name]; !ok {
+		return
+	}
+	delete(s.certs, name)
+	s.notify()
+}
+
+// notify wakes up the streams so that they send the certificates that
+// changed. It must be called with the lock held.
+func (s *Server) notify() {
+	for ch := range s.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// secretDiscoveryServer is the handler type of the SDS service.
+type secretDiscoveryServer interface {
+	streamSecrets(stream grpc.ServerStream) error
+}
+
+func streamSecretsHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(secretDiscoveryServer).streamSecrets(stream)
+}
+
+// streamSecrets serves a stream of the State of the World protocol: each
+// request subscribes to its resource names, and the certificates of the
+// subscribed resources are sent whenever their version differs from the
+// last one sent on the stream. The requests acknowledging a response have
+// the same version, so they don't trigger another response.
+func (s *Server) streamSecrets(stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	reqCh := make(chan *discoveryRequest)
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			req := &discoveryRequest{}
+			if err := stream.RecvMsg(req); err != nil {
+				errCh <- err
+				return
+			}
+			select {
+			case reqCh <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	changed := make(chan struct{}, 1)
+	s.lock.Lock()
+	s.watchers[changed] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.watchers, changed)
+		s.lock.Unlock()
+	}()
+
+	var names []string
+	var sentVersion string
+	subscribed := false
+	for {
+		select {
+		case req := <-reqCh:
+			if req.TypeUrl != "" && req.TypeUrl != SecretTypeURL {
+				return status.Errorf(codes.InvalidArgument, "unsupported type %q, only %q is served", req.TypeUrl, SecretTypeURL)
+			}
+			if req.ErrorDetail != nil {
+				s.Log.Warn("Envoy rejected the secrets", "names", req.ResourceNames, "version", sentVersion, "err", req.ErrorDetail.Message)
+			}
+			if !equalNames(names, req.ResourceNames) {
+				names = req.ResourceNames
+				sentVersion = ""
+			}
+			subscribed = true
+		case <-changed:
+		case err := <-errCh:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return nil
+		}
+		if !subscribed {
+			continue
+		}
+
+		resp, err := s.response(names)
+		if err != nil {
+			return status.Errorf(codes.Internal, "encoding the secrets: %s", err)
+		}
+		if resp == nil || resp.VersionInfo == sentVersion {
+			continue
+		}
+		if err := stream.SendMsg(resp); err != nil {
+			return err
+		}
+		sentVersion = resp.VersionInfo
+		s.Log.Debug("sent secrets", "names", names, "version", sentVersion)
+	}
+}
+
+// response returns the response with the certificates of the names, or
+// nil if none of them has a certificate. Its version is the hash of the
+// certificates.
+func (s *Server) response(names []string) (*discoveryResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	resp := &discoveryResponse{TypeUrl: SecretTypeURL}
+	hash := fnv.New64a()
+	for _, name := range names {
+		cert, ok := s.certs[name]
+		if !ok {
+			continue
+		}
+		value, err := proto.Marshal(&secret{
+			Name: name,
+			TlsCertificate: &tlsCertificate{
+				CertificateChain: &dataSource{InlineBytes: cert.chain},
+				PrivateKey:       &dataSource{InlineBytes: cert.key},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp.Resources = append(resp.Resources, &any.Any{TypeUrl: SecretTypeURL, Value: value})
+		hash.Write(value)
+	}
+	if len(resp.Resources) == 0 {
+		return nil, nil
+	}
+	s.nonce++
+	resp.VersionInfo = fmt.Sprintf("%x", hash.Sum64())
+	resp.Nonce = strconv.FormatUint(s.nonce, 10)
+	return resp, nil
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package sds
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServer_StreamSecrets(t *testing.T) {
+	t.Parallel()
+	server, stream := startServer(t)
+	server.Upsert(tlsSecret("cert", "CERT-1"))
+	server.Upsert(tlsSecret("other", "OTHER-1"))
+
+	require.NoError(t, stream.SendMsg(&discoveryRequest{ResourceNames: []string{"cert"}, TypeUrl: SecretTypeURL}))
+	resp := receive(t, stream)
+	require.Equal(t, SecretTypeURL, resp.TypeUrl)
+	require.Equal(t, map[string]string{"cert": "CERT-1"}, certificates(t, resp))
+
+	// Envoy acknowledges the response, which doesn't send it again.
+	require.NoError(t, stream.SendMsg(&discoveryRequest{
+		VersionInfo:   resp.VersionInfo,
+		ResourceNames: []string{"cert"},
+		TypeUrl:       SecretTypeURL,
+		ResponseNonce: resp.Nonce,
+	}))
+
+	// Only the changes of the subscribed secrets are sent.
+	server.Upsert(tlsSecret("other", "OTHER-2"))
+	server.Upsert(tlsSecret("cert", "CERT-1"))
+	server.Upsert(tlsSecret("cert", "CERT-2"))
+	renewed := receive(t, stream)
+	require.NotEqual(t, resp.VersionInfo, renewed.VersionInfo)
+	require.NotEqual(t, resp.Nonce, renewed.Nonce)
+	require.Equal(t, map[string]string{"cert": "CERT-2"}, certificates(t, renewed))
+
+	// Subscribing to other names sends their certificates.
+	require.NoError(t, stream.SendMsg(&discoveryRequest{
+		VersionInfo:   renewed.VersionInfo,
+		ResourceNames: []string{"cert", "other"},
+		TypeUrl:       SecretTypeURL,
+		ResponseNonce: renewed.Nonce,
+	}))
+	require.Equal(t, map[string]string{"cert": "CERT-2", "other": "OTHER-2"}, certificates(t, receive(t, stream)))
+}
+
+// Test that the certificates of the secrets that don't exist yet are sent
+// once they're created.
+func TestServer_StreamSecretsMissing(t *testing.T) {
+	t.Parallel()
+	server, stream := startServer(t)
+	require.NoError(t, stream.SendMsg(&discoveryRequest{ResourceNames: []string{"cert"}, TypeUrl: SecretTypeURL}))
+
+	// Secrets without a certificate are ignored.
+	server.Upsert(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cert"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("CERT")},
+	})
+	server.Upsert(tlsSecret("cert", "CERT-1"))
+	require.Equal(t, map[string]string{"cert": "CERT-1"}, certificates(t, receive(t, stream)))
+}
+
+func TestServer_StreamSecretsUnsupportedType(t *testing.T) {
+	t.Parallel()
+	_, stream := startServer(t)
+	require.NoError(t, stream.SendMsg(&discoveryRequest{
+		ResourceNames: []string{"cluster"},
+		TypeUrl:       "type.googleapis.com/envoy.config.cluster.v3.Cluster",
+	}))
+	err := stream.RecvMsg(&discoveryResponse{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// startServer starts an SDS server and returns it with a stream of its
+// secrets.
+func startServer(t *testing.T) (*Server, grpc.ClientStream) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := NewServer(hclog.NewNullLogger())
+	grpcServer := grpc.NewServer()
+	server.Register(grpcServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true},
+		"/"+serviceName+"/StreamSecrets")
+	require.NoError(t, err)
+	return server, stream
+}
+
+func receive(t *testing.T, stream grpc.ClientStream) *discoveryResponse {
+	resp := &discoveryResponse{}
+	require.NoError(t, stream.RecvMsg(resp))
+	return resp
+}
+
+// certificates returns the certificate chains of the response keyed by
+// the names of their secrets.
+func certificates(t *testing.T, resp *discoveryResponse) map[string]string {
+	certs := make(map[string]string)
+	for _, resource := range resp.Resources {
+		require.Equal(t, SecretTypeURL, resource.TypeUrl)
+		s := &secret{}
+		require.NoError(t, proto.Unmarshal(resource.Value, s))
+		require.Equal(t, "KEY", string(s.TlsCertificate.PrivateKey.InlineBytes))
+		certs[s.Name] = string(s.TlsCertificate.CertificateChain.InlineBytes)
+	}
+	return certs
+}
+
+func tlsSecret(name, cert string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte(cert),
+			corev1.TLSPrivateKeyKey: []byte("KEY"),
+		},
+	}
+}
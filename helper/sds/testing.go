@@ -0,0 +1,67 @@
+package sds
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// TestClient is an SDS client for tests, subscribing to secrets like
+// Envoy.
+type TestClient struct {
+	conn   *grpc.ClientConn
+	stream grpc.ClientStream
+	last   *discoveryResponse
+}
+
+// NewTestClient opens a stream of secrets to the SDS server at the
+// address. The stream is closed when the context is cancelled.
+func NewTestClient(ctx context.Context, addr string) (*TestClient, error) {
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true},
+		"/"+serviceName+"/StreamSecrets")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &TestClient{conn: conn, stream: stream}, nil
+}
+
+// Subscribe subscribes to the secrets with the names, acknowledging the
+// last response received.
+func (c *TestClient) Subscribe(names ...string) error {
+	req := &discoveryRequest{ResourceNames: names, TypeUrl: SecretTypeURL}
+	if c.last != nil {
+		req.VersionInfo = c.last.VersionInfo
+		req.ResponseNonce = c.last.Nonce
+	}
+	return c.stream.SendMsg(req)
+}
+
+// Receive waits for the next response and returns its certificate chains
+// keyed by the names of their secrets.
+func (c *TestClient) Receive() (map[string]string, error) {
+	resp := &discoveryResponse{}
+	if err := c.stream.RecvMsg(resp); err != nil {
+		return nil, err
+	}
+	c.last = resp
+	certs := make(map[string]string)
+	for _, resource := range resp.Resources {
+		s := &secret{}
+		if err := proto.Unmarshal(resource.Value, s); err != nil {
+			return nil, err
+		}
+		certs[s.Name] = string(s.TlsCertificate.CertificateChain.InlineBytes)
+	}
+	return certs, nil
+}
+
+// Close closes the connection of the client.
+func (c *TestClient) Close() error {
+	return c.conn.Close()
+}
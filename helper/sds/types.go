@@ -0,0 +1,78 @@
+package sds
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/any"
+)
+
+// The messages of the v3 SDS API the server uses. The generated Envoy API
+// isn't a dependency, so they're declared with the subset of the fields
+// the server needs. The protobuf package encodes them by their tags, and
+// the fields they don't declare, e.g. the node of the requests, are
+// skipped when they're decoded.
+
+// discoveryRequest is envoy.service.discovery.v3.DiscoveryRequest.
+type discoveryRequest struct {
+	VersionInfo   string     `protobuf:"bytes,1,opt,name=version_info,json=versionInfo,proto3"`
+	ResourceNames []string   `protobuf:"bytes,3,rep,name=resource_names,json=resourceNames,proto3"`
+	TypeUrl       string     `protobuf:"bytes,4,opt,name=type_url,json=typeUrl,proto3"`
+	ResponseNonce string     `protobuf:"bytes,5,opt,name=response_nonce,json=responseNonce,proto3"`
+	ErrorDetail   *rpcStatus `protobuf:"bytes,6,opt,name=error_detail,json=errorDetail,proto3"`
+}
+
+func (m *discoveryRequest) Reset()         { *m = discoveryRequest{} }
+func (m *discoveryRequest) String() string { return proto.CompactTextString(m) }
+func (*discoveryRequest) ProtoMessage()    {}
+
+// discoveryResponse is envoy.service.discovery.v3.DiscoveryResponse.
+type discoveryResponse struct {
+	VersionInfo string     `protobuf:"bytes,1,opt,name=version_info,json=versionInfo,proto3"`
+	Resources   []*any.Any `protobuf:"bytes,2,rep,name=resources,proto3"`
+	TypeUrl     string     `protobuf:"bytes,4,opt,name=type_url,json=typeUrl,proto3"`
+	Nonce       string     `protobuf:"bytes,5,opt,name=nonce,proto3"`
+}
+
+func (m *discoveryResponse) Reset()         { *m = discoveryResponse{} }
+func (m *discoveryResponse) String() string { return proto.CompactTextString(m) }
+func (*discoveryResponse) ProtoMessage()    {}
+
+// rpcStatus is google.rpc.Status, the error of the requests rejecting the
+// last response.
+type rpcStatus struct {
+	Code    int32  `protobuf:"varint,1,opt,name=code,proto3"`
+	Message string `protobuf:"bytes,2,opt,name=message,proto3"`
+}
+
+func (m *rpcStatus) Reset()         { *m = rpcStatus{} }
+func (m *rpcStatus) String() string { return proto.CompactTextString(m) }
+func (*rpcStatus) ProtoMessage()    {}
+
+// secret is envoy.extensions.transport_sockets.tls.v3.Secret with a TLS
+// certificate.
+type secret struct {
+	Name           string          `protobuf:"bytes,1,opt,name=name,proto3"`
+	TlsCertificate *tlsCertificate `protobuf:"bytes,2,opt,name=tls_certificate,json=tlsCertificate,proto3"`
+}
+
+func (m *secret) Reset()         { *m = secret{} }
+func (m *secret) String() string { return proto.CompactTextString(m) }
+func (*secret) ProtoMessage()    {}
+
+// tlsCertificate is envoy.extensions.transport_sockets.tls.v3.TlsCertificate.
+type tlsCertificate struct {
+	CertificateChain *dataSource `protobuf:"bytes,1,opt,name=certificate_chain,json=certificateChain,proto3"`
+	PrivateKey       *dataSource `protobuf:"bytes,2,opt,name=private_key,json=privateKey,proto3"`
+}
+
+func (m *tlsCertificate) Reset()         { *m = tlsCertificate{} }
+func (m *tlsCertificate) String() string { return proto.CompactTextString(m) }
+func (*tlsCertificate) ProtoMessage()    {}
+
+// dataSource is envoy.config.core.v3.DataSource with inline bytes.
+type dataSource struct {
+	InlineBytes []byte `protobuf:"bytes,2,opt,name=inline_bytes,json=inlineBytes,proto3"`
+}
+
+func (m *dataSource) Reset()         { *m = dataSource{} }
+func (m *dataSource) String() string { return proto.CompactTextString(m) }
+func (*dataSource) ProtoMessage()    {}
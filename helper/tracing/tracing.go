@@ -0,0 +1,429 @@
+// Package tracing traces the operations of the components of consul-k8s,
+// e.g. the admission requests of the injector, the reconciles of the
+// controllers and their Consul API requests, and exports the spans to an
+// OpenTelemetry collector with OTLP over HTTP, in its JSON encoding.
+//
+// Tracing is disabled until Start is called. Until then, the spans of
+// StartSpan and StartServer are nil, and the methods of a nil span do
+// nothing, so the code is traced unconditionally.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	// batchSize is the number of spans exported in a single request.
+	batchSize = 512
+	// exportPeriod is the longest a span waits to be exported.
+	exportPeriod = 5 * time.Second
+	// queueSize is the number of spans waiting to be exported above which
+	// the new spans are dropped rather than slowing the traced code down.
+	queueSize = 4 * batchSize
+)
+
+// OTLP span kinds and status codes.
+const (
+	kindInternal = 1
+	kindServer   = 2
+	kindClient   = 3
+
+	statusError = 2
+)
+
+// exporter is the exporter of Start, or nil if tracing is disabled.
+var exporter atomic.Value
+
+// Attribute is a string attribute of a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// String returns the attribute key=value.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Start exports the spans to the OTLP/HTTP endpoint of a collector, e.g.
+// "http://otel-collector:4318", with the service.name serviceName. The
+// returned function exports the spans that are left and stops exporting.
+func Start(logger hclog.Logger, endpoint, serviceName string) (func(), error) {
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("endpoint %q must be an http:// or https:// URL", endpoint)
+	}
+	e := &otlpExporter{
+		log:         logger,
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		spans:       make(chan *Span, queueSize),
+		doneCh:      make(chan struct{}),
+	}
+	exporter.Store(e)
+	go e.run()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			exporter.Store((*otlpExporter)(nil))
+			e.stop()
+		})
+	}, nil
+}
+
+func current() *otlpExporter {
+	e, _ := exporter.Load().(*otlpExporter)
+	return e
+}
+
+type spanKey struct{}
+
+// FromContext returns the span of ctx, or nil if it has none.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// StartSpan starts a span, child of the span of ctx if it has one, and
+// returns the context of the span.
+func StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return startSpan(ctx, name, kindInternal, FromContext(ctx), attrs)
+}
+
+// StartServer starts the span of an incoming HTTP request, child of the
+// span of its W3C traceparent header if it has one, and returns the
+// context of the span.
+func StartServer(r *http.Request, name string, attrs ...Attribute) (context.Context, *Span) {
+	parent := FromContext(r.Context())
+	if remote, ok := parseTraceParent(r.Header.Get("traceparent")); ok {
+		parent = remote
+	}
+	return startSpan(r.Context(), name, kindServer, parent, attrs)
+}
+
+func startSpan(ctx context.Context, name string, kind int, parent *Span, attrs []Attribute) (context.Context, *Span) {
+	e := current()
+	if e == nil {
+		return ctx, nil
+	}
+	span := &Span{
+		exporter: e,
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+		attrs:    attrs,
+	}
+	if parent != nil {
+		span.traceID, span.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Span is an operation of a trace. A nil span is a disabled span.
+type Span struct {
+	exporter *otlpExporter
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+
+	lock  sync.Mutex
+	attrs []Attribute
+	err   string
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// End ends the span, with an error status if err isn't nil.
+func (s *Span) End(err error) {
+	if s == nil || s.exporter == nil {
+		return
+	}
+	s.lock.Lock()
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+	s.lock.Unlock()
+	s.exporter.export(s)
+}
+
+// TraceParent returns the W3C traceparent header of the span's children.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:]))
+}
+
+// parseTraceParent returns the remote parent span of the W3C traceparent
+// header, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func parseTraceParent(header string) (*Span, bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil, false
+	}
+	span := &Span{}
+	if _, err := hex.Decode(span.traceID[:], []byte(parts[1])); err != nil {
+		return nil, false
+	}
+	if _, err := hex.Decode(span.spanID[:], []byte(parts[2])); err != nil {
+		return nil, false
+	}
+	if span.traceID == [16]byte{} || span.spanID == [8]byte{} {
+		return nil, false
+	}
+	return span, true
+}
+
+// Handler returns a handler tracing the requests of next with the spans of
+// StartServer.
+func Handler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := StartServer(r, name, String("http.method", r.Method), String("http.target", r.URL.Path))
+		rw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rw, r.WithContext(ctx))
+		span.SetAttributes(String("http.status_code", strconv.Itoa(rw.code)))
+		var err error
+		if rw.code >= 500 {
+			err = fmt.Errorf("response code: %d", rw.code)
+		}
+		span.End(err)
+	})
+}
+
+// statusWriter records the response code of a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Transport returns a round tripper tracing the requests of next, e.g.
+// the Consul API requests of a client, as children of the span of their
+// context if it has one. The traceparent header of the requests is set
+// to their span.
+func Transport(next http.RoundTripper) http.RoundTripper {
+	return &transport{next: next}
+}
+
+type transport struct {
+	next http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, span := startSpan(req.Context(), "HTTP "+req.Method, kindClient, FromContext(req.Context()), []Attribute{
+		String("http.method", req.Method),
+		String("http.target", req.URL.Path),
+		String("net.peer.name", req.URL.Host),
+	})
+	if span == nil {
+		return t.next.RoundTrip(req)
+	}
+	// Round trippers must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set("traceparent", span.TraceParent())
+	resp, err := t.next.RoundTrip(req)
+	spanErr := err
+	if err == nil {
+		span.SetAttributes(String("http.status_code", strconv.Itoa(resp.StatusCode)))
+		if resp.StatusCode >= 500 {
+			spanErr = fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+		}
+	}
+	span.End(spanErr)
+	return resp, err
+}
+
+// otlpExporter exports the ended spans in batches.
+type otlpExporter struct {
+	log         hclog.Logger
+	url         string
+	serviceName string
+	client      *http.Client
+
+	// spans are the ended spans. It's closed when tracing stops, after
+	// which the spans ending are dropped.
+	lock    sync.Mutex
+	closed  bool
+	spans   chan *Span
+	doneCh  chan struct{}
+	dropped bool
+}
+
+// export queues the span, or drops it if the queue is full, e.g. because
+// the collector is unreachable.
+func (e *otlpExporter) export(span *Span) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.spans <- span:
+	default:
+		if !e.dropped {
+			e.dropped = true
+			e.log.Warn("dropping spans, the exporter is falling behind")
+		}
+	}
+}
+
+// stop exports the queued spans and stops the exporter.
+func (e *otlpExporter) stop() {
+	e.lock.Lock()
+	e.closed = true
+	close(e.spans)
+	e.lock.Unlock()
+	<-e.doneCh
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.doneCh)
+	ticker := time.NewTicker(exportPeriod)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case span, ok := <-e.spans:
+			if !ok {
+				e.send(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		e.send(batch)
+		batch = nil
+	}
+}
+
+// send exports the spans in a single request.
+func (e *otlpExporter) send(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		e.log.Error("error encoding spans", "err", err)
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		e.log.Error("error exporting spans", "url", e.url, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e.log.Error("error exporting spans", "url", e.url, "code", resp.StatusCode)
+	}
+}
+
+// The types of the JSON encoding of the OTLP ExportTraceServiceRequest.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+func (e *otlpExporter) request(spans []*Span) otlpRequest {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "github.com/hashicorp/consul-k8s"}}
+	for _, s := range spans {
+		s.lock.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, attr := range s.attrs {
+			span.Attributes = append(span.Attributes, otlpAttribute{Key: attr.Key, Value: otlpValue{attr.Value}})
+		}
+		if s.err != "" {
+			span.Status = &otlpStatus{Code: statusError, Message: s.err}
+		}
+		s.lock.Unlock()
+		scope.Spans = append(scope.Spans, span)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{e.serviceName}},
+		}},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+}
@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	cases := map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00": true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": false,
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01": false,
+		"": false,
+	}
+	for header, expOK := range cases {
+		t.Run(header, func(t *testing.T) {
+			span, ok := parseTraceParent(header)
+			require.Equal(t, expOK, ok)
+			if ok {
+				require.Equal(t, header[:52], span.TraceParent()[:52])
+			}
+		})
+	}
+}
+
+// Test that the spans are nil while tracing is disabled.
+func TestStartSpan_Disabled(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "reconcile")
+	require.Nil(t, span)
+	require.Nil(t, FromContext(ctx))
+	span.SetAttributes(String("key", "value"))
+	span.End(errors.New("error"))
+}
+
+// Test that the spans of a request, of its children and of the requests
+// of Transport are exported in the same trace when tracing stops.
+func TestStart(t *testing.T) {
+	var lock sync.Mutex
+	var spans []otlpSpan
+	var service string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/traces", r.URL.Path)
+		var req otlpRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		lock.Lock()
+		defer lock.Unlock()
+		service = req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue
+		spans = append(spans, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+	}))
+	defer collector.Close()
+	var traceParent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	stop, err := Start(hclog.NewNullLogger(), collector.URL, "consul-k8s-test")
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/mutate", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := StartServer(req, "admission", String("k8s.namespace.name", "default"))
+	_, child := StartSpan(ctx, "inject")
+	upstreamReq, err := http.NewRequest("GET", upstream.URL+"/v1/agent/self", nil)
+	require.NoError(t, err)
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+	resp, err := client.Do(upstreamReq.WithContext(ctx))
+	require.NoError(t, err)
+	resp.Body.Close()
+	child.End(errors.New("invalid pod"))
+	server.End(nil)
+	stop()
+
+	// Spans ending once tracing stopped are dropped.
+	_, late := StartSpan(context.Background(), "late")
+	require.Nil(t, late)
+
+	require.Equal(t, "consul-k8s-test", service)
+	require.Len(t, spans, 3)
+	byName := make(map[string]otlpSpan)
+	for _, span := range spans {
+		require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+		byName[span.Name] = span
+	}
+	require.Equal(t, "00f067aa0ba902b7", byName["admission"].ParentSpanID)
+	require.Equal(t, kindServer, byName["admission"].Kind)
+	require.Equal(t, []otlpAttribute{{Key: "k8s.namespace.name", Value: otlpValue{"default"}}}, byName["admission"].Attributes)
+	require.Nil(t, byName["admission"].Status)
+	require.Equal(t, byName["admission"].SpanID, byName["inject"].ParentSpanID)
+	require.Equal(t, &otlpStatus{Code: statusError, Message: "invalid pod"}, byName["inject"].Status)
+	require.Equal(t, byName["admission"].SpanID, byName["HTTP GET"].ParentSpanID)
+	require.Equal(t, kindClient, byName["HTTP GET"].Kind)
+	require.Equal(t, statusError, byName["HTTP GET"].Status.Code)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+byName["HTTP GET"].SpanID+"-01", traceParent)
+}
+
+func TestStart_InvalidEndpoint(t *testing.T) {
+	_, err := Start(hclog.NewNullLogger(), "otel-collector:4318", "consul-k8s-test")
+	require.EqualError(t, err, `endpoint "otel-collector:4318" must be an http:// or https:// URL`)
+}
+
+// Test that the requests of Handler are traced with their response code.
+func TestHandler(t *testing.T) {
+	var lock sync.Mutex
+	var spans []otlpSpan
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		lock.Lock()
+		defer lock.Unlock()
+		spans = append(spans, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+	}))
+	defer collector.Close()
+
+	stop, err := Start(hclog.NewNullLogger(), collector.URL, "consul-k8s-test")
+	require.NoError(t, err)
+	handler := Handler("webhook", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NotNil(t, FromContext(r.Context()))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/validate/servicedefaults", nil))
+	stop()
+
+	require.Len(t, spans, 1)
+	require.Equal(t, "webhook", spans[0].Name)
+	require.Contains(t, spans[0].Attributes, otlpAttribute{Key: "http.target", Value: otlpValue{"/validate/servicedefaults"}})
+	require.Contains(t, spans[0].Attributes, otlpAttribute{Key: "http.status_code", Value: otlpValue{"503"}})
+	require.Equal(t, &otlpStatus{Code: statusError, Message: "response code: 503"}, spans[0].Status)
+}
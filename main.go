@@ -4,11 +4,17 @@ import (
 	"log"
 	"os"
 
+	"github.com/hashicorp/consul-k8s/helper/fips"
 	"github.com/hashicorp/consul-k8s/version"
 	"github.com/mitchellh/cli"
 )
 
 func main() {
+	if err := fips.Check(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
 	c := cli.NewCLI("consul-k8s", version.GetHumanVersion())
 	c.Args = os.Args[1:]
 	c.Commands = Commands
@@ -5,9 +5,12 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -23,7 +26,9 @@ type Command struct {
 	UI cli.Ui
 
 	flags             *flag.FlagSet
+	configFile        *k8sflags.ConfigFileFlags
 	k8s               *k8sflags.K8SFlags
+	logging           *k8sflags.LogFlags
 	flagSecretName    string
 	flagInitType      string
 	flagNamespace     string
@@ -32,6 +37,9 @@ type Command struct {
 
 	k8sClient kubernetes.Interface
 
+	// sigCh receives a signal when the command should stop.
+	sigCh chan os.Signal
+
 	once sync.Once
 	help string
 }
@@ -51,7 +59,18 @@ func (c *Command) init() {
 
 	c.k8s = &k8sflags.K8SFlags{}
 	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
 	c.help = flags.Usage(help, c.flags)
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
 }
 
 func (c *Command) Run(args []string) int {
@@ -59,10 +78,19 @@ func (c *Command) Run(args []string) int {
 	if err := c.flags.Parse(args); err != nil {
 		return 1
 	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 	if len(c.flags.Args()) > 0 {
 		c.UI.Error(fmt.Sprintf("Should have no non-flag arguments."))
 		return 1
 	}
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	// Create the Kubernetes clientset
 	if c.k8sClient == nil {
@@ -85,12 +113,17 @@ func (c *Command) Run(args []string) int {
 		var err error
 		secret, err = c.getSecret(c.flagSecretName)
 		if err != nil {
-			c.UI.Error(fmt.Sprintf("Error getting Kubernetes secret: %s", err))
+			logger.Error("error getting Kubernetes secret", "secret", c.flagSecretName, "err", err)
 		}
 		if err == nil {
 			break
 		}
-		time.Sleep(1 * time.Second)
+		select {
+		case <-time.After(1 * time.Second):
+		case <-c.sigCh:
+			c.UI.Error(fmt.Sprintf("Interrupted waiting for secret %q", c.flagSecretName))
+			return 1
+		}
 	}
 
 	if c.flagInitType == "client" {
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/require"
@@ -43,6 +44,7 @@ func TestRun_TokenSinkFile(t *testing.T) {
 	}
 	code := cmd.Run([]string{
 		"-k8s-namespace", k8sNS,
+		"-secret-name", secretName,
 		"-token-sink-file", sinkFile,
 	})
 	require.Equal(0, code, ui.ErrorWriter.String())
@@ -78,6 +80,7 @@ func TestRun_TokenSinkFileErr(t *testing.T) {
 	}
 	code := cmd.Run([]string{
 		"-k8s-namespace", k8sNS,
+		"-secret-name", secretName,
 		"-token-sink-file", "/this/filepath/does/not/exist",
 	})
 	require.Equal(1, code)
@@ -85,3 +88,27 @@ func TestRun_TokenSinkFileErr(t *testing.T) {
 		`Error writing token to file "/this/filepath/does/not/exist": open /this/filepath/does/not/exist: no such file or directory`,
 	)
 }
+
+// Test that the command stops waiting for the secret once it's interrupted.
+func TestRun_Interrupt(t *testing.T) {
+	t.Parallel()
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		k8sClient: fake.NewSimpleClientset(),
+	}
+	cmd.init()
+
+	done := make(chan int, 1)
+	go func() {
+		done <- cmd.Run([]string{"-k8s-namespace", "default", "-secret-name", "secret-name"})
+	}()
+	cmd.sigCh <- os.Interrupt
+	select {
+	case code := <-done:
+		require.Equal(t, 1, code)
+		require.Contains(t, ui.ErrorWriter.String(), `Interrupted waiting for secret "secret-name"`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not exit after interrupt")
+	}
+}
@@ -47,3 +47,17 @@ func K8SConfig(path string) (*rest.Config, error) {
 
 	return config, nil
 }
+
+// K8SContextConfig returns a *restclient.Config for initializing a K8S
+// client of the given context of the kubeconfig. The kubeconfig is loaded
+// from path if it's given, and from the default locations otherwise.
+func K8SContextConfig(path, context string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading context %q of kubeconfig: %s", context, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+)
+
+// Command is the parent of the commands reading the configuration of the
+// installation of the Consul Helm chart.
+type Command struct {
+	UI cli.Ui
+}
+
+func (c *Command) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	return flags.Usage(help, nil)
+}
+
+const synopsis = "Read the configuration of the Consul installation"
+const help = `
+Usage: consul-k8s config <subcommand> [options] [args]
+
+  Reads the configuration of the installation of the Consul Helm chart.
+
+  Print the values of the chart deployed for the release:
+
+      $ consul-k8s config read
+
+  Compare them to a values file, e.g. the one of a GitOps repository:
+
+      $ consul-k8s config read -f values.yaml
+
+  For more examples, ask for subcommand help or view the documentation.
+`
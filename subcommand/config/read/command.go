@@ -0,0 +1,139 @@
+package read
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+)
+
+// Command prints the values of the chart deployed for the release of the
+// Consul Helm chart.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagValuesFile string
+
+	helm *helm.Client
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagValuesFile, "f", "",
+		"Path of a values file to compare the deployed values to. If set, the differences are "+
+			"printed instead of the values.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	var fileValues map[string]interface{}
+	if c.flagValuesFile != "" {
+		content, err := ioutil.ReadFile(c.flagValuesFile)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading values file: %s", err))
+			return 1
+		}
+		if err := yaml.Unmarshal(content, &fileValues); err != nil {
+			c.UI.Error(fmt.Sprintf("Error parsing values file: %s", err))
+			return 1
+		}
+	}
+	if c.helm == nil {
+		c.helm = &helm.Client{KubeConfig: c.k8s.KubeConfig()}
+	}
+
+	release, err := c.helm.Release()
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	values, err := c.helm.Values(release.Name, release.Namespace)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error getting the values of the release: %s", err))
+		return 1
+	}
+
+	if c.flagValuesFile == "" {
+		if len(values) == 0 {
+			c.UI.Output(fmt.Sprintf("# Release %q in namespace %q uses the defaults of the chart.",
+				release.Name, release.Namespace))
+			return 0
+		}
+		out, err := yaml.Marshal(values)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error rendering the values: %s", err))
+			return 1
+		}
+		c.UI.Output(strings.TrimSuffix(string(out), "\n"))
+		return 0
+	}
+
+	diff, err := helm.DiffValues(values, fileValues)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error comparing the values: %s", err))
+		return 1
+	}
+	if diff == "" {
+		c.UI.Output(fmt.Sprintf(" ✓ The values of release %q in namespace %q match %s",
+			release.Name, release.Namespace, c.flagValuesFile))
+		return 0
+	}
+	c.UI.Output(fmt.Sprintf("==> Differences of the values of release %q in namespace %q (-) and %s (+)",
+		release.Name, release.Namespace, c.flagValuesFile))
+	c.UI.Output(subcommand.Indent(diff))
+	c.UI.Error(fmt.Sprintf(" ✗ The values of the release don't match %s", c.flagValuesFile))
+	return 1
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Print the deployed values of the Consul Helm chart"
+const help = `
+Usage: consul-k8s config read [options]
+
+  Prints, as YAML, the values of the chart set by the user for the deployed
+  revision of the installation of the Consul Helm chart, as stored by Helm
+  in the secret of the revision. The defaults of the chart aren't included.
+
+  If -f is set, the deployed values are instead compared to the values
+  file, and their differences are printed. The command exits with 1 if
+  they differ, e.g. to check in a pipeline that the cluster matches the
+  values of a GitOps repository.
+
+`
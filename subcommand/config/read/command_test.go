@@ -0,0 +1,100 @@
+package read
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{"foo"},
+			expErr: "Should have no non-flag arguments.",
+		},
+		{
+			flags:  []string{"-f", "/does/not/exist.yaml"},
+			expErr: "Error reading values file: open /does/not/exist.yaml: no such file or directory",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.flags))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun_Read(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	same := filepath.Join(dir, "same.yaml")
+	require.NoError(t, ioutil.WriteFile(same, []byte("server:\n  replicas: 3\nglobal:\n  name: consul\n"), 0644))
+	different := filepath.Join(dir, "different.yaml")
+	require.NoError(t, ioutil.WriteFile(different, []byte("global:\n  name: consul\nserver:\n  replicas: 5\n"), 0644))
+
+	cases := map[string]struct {
+		values    string
+		flags     []string
+		expCode   int
+		expOutput string
+		expErr    string
+	}{
+		"values": {
+			values:    `{"global":{"name":"consul"},"server":{"replicas":3}}`,
+			expOutput: "global:\n  name: consul\nserver:\n  replicas: 3\n",
+		},
+		"defaults": {
+			values:    `null`,
+			expOutput: "# Release \"consul\" in namespace \"mesh\" uses the defaults of the chart.\n",
+		},
+		"matching values file": {
+			values:    `{"global":{"name":"consul"},"server":{"replicas":3}}`,
+			flags:     []string{"-f", same},
+			expOutput: ` ✓ The values of release "consul" in namespace "mesh" match ` + same + "\n",
+		},
+		"different values file": {
+			values:  `{"global":{"name":"consul"},"server":{"replicas":3}}`,
+			flags:   []string{"-f", different},
+			expCode: 1,
+			expOutput: `==> Differences of the values of release "consul" in namespace "mesh" (-) and ` + different + " (+)\n" +
+				"      --- current\n" +
+				"      +++ proposed\n" +
+				"      @@ -1,4 +1,4 @@\n" +
+				"       global:\n" +
+				"         name: consul\n" +
+				"       server:\n" +
+				"      -  replicas: 3\n" +
+				"      +  replicas: 5\n",
+			expErr: " ✗ The values of the release don't match " + different + "\n",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, err := helm.NewTestClient(nil, helm.TestRelease{
+				Name:      "consul",
+				Namespace: "mesh",
+				Chart:     "consul-0.24.1",
+				Values:    c.values,
+			})
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui, helm: client}
+			require.Equal(t, c.expCode, cmd.Run(c.flags), ui.ErrorWriter.String())
+			require.Equal(t, c.expOutput, ui.OutputWriter.String())
+			require.Equal(t, c.expErr, ui.ErrorWriter.String())
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package consuldnsconfig
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The values of -dns-provider.
+const (
+	providerAuto    = "auto"
+	providerCoreDNS = "coredns"
+	providerKubeDNS = "kube-dns"
+)
+
+// Command configures the cluster DNS to forward the Consul domain to the
+// Consul DNS service.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+
+	flagK8sNamespace     string
+	flagServiceName      string
+	flagDomain           string
+	flagProvider         string
+	flagDNSNamespace     string
+	flagCoreDNSConfigMap string
+	flagKubeDNSConfigMap string
+	flagWatch            bool
+	flagWatchInterval    time.Duration
+
+	clientset kubernetes.Interface
+
+	// retryDuration is how often the command retries until the service has
+	// a cluster IP. It's exposed for setting in tests.
+	retryDuration time.Duration
+
+	// sigCh receives a signal when the command should stop watching.
+	sigCh chan os.Signal
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of the Kubernetes namespace of the Consul DNS service.")
+	c.flags.StringVar(&c.flagServiceName, "service-name", "",
+		"Name of the Consul DNS service, whose cluster IP the domain is forwarded to.")
+	c.flags.StringVar(&c.flagDomain, "domain", "consul",
+		"The Consul DNS domain forwarded to the Consul DNS service.")
+	c.flags.StringVar(&c.flagProvider, "dns-provider", providerAuto,
+		fmt.Sprintf("The cluster DNS to configure: %q, %q, or %q to configure CoreDNS if its ConfigMap "+
+			"exists and kube-dns otherwise.", providerCoreDNS, providerKubeDNS, providerAuto))
+	c.flags.StringVar(&c.flagDNSNamespace, "dns-namespace", "kube-system",
+		"Name of the Kubernetes namespace of the cluster DNS ConfigMaps.")
+	c.flags.StringVar(&c.flagCoreDNSConfigMap, "coredns-configmap", "coredns",
+		"Name of the ConfigMap with the Corefile of CoreDNS.")
+	c.flags.StringVar(&c.flagKubeDNSConfigMap, "kube-dns-configmap", "kube-dns",
+		"Name of the ConfigMap with the stub domains of kube-dns.")
+	c.flags.BoolVar(&c.flagWatch, "watch", false,
+		"Keep running and update the cluster DNS whenever the cluster IP of the service changes or its "+
+			"configuration is overwritten. If false, the command exits once the cluster DNS is configured.")
+	c.flags.DurationVar(&c.flagWatchInterval, "watch-interval", 30*time.Second,
+		"How often the service and the cluster DNS configuration are checked with -watch.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	if c.retryDuration == 0 {
+		c.retryDuration = 1 * time.Second
+	}
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	ctx, cancel := subcommand.WithSignal(context.Background(), c.sigCh)
+	defer cancel()
+
+	// Until the cluster DNS is configured once, the errors are retried
+	// since the service may not have its cluster IP yet. Afterwards, with
+	// -watch, they're retried at the next check.
+	configured := false
+	for {
+		err := c.configure(logger)
+		if err == nil && !configured {
+			configured = true
+			if !c.flagWatch {
+				c.UI.Info(fmt.Sprintf("Successfully configured the cluster DNS to forward %q to service %q",
+					c.flagDomain, c.flagServiceName))
+				return 0
+			}
+		}
+		if err != nil {
+			logger.Error("Error configuring the cluster DNS", "err", err)
+		}
+
+		interval := c.flagWatchInterval
+		if !configured {
+			interval = c.retryDuration
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			if !configured {
+				c.UI.Error("Interrupted before the cluster DNS was configured")
+				return 1
+			}
+			return 0
+		}
+	}
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagK8sNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagServiceName == "" {
+		return errors.New("-service-name must be set")
+	}
+	if c.flagDomain == "" {
+		return errors.New("-domain must be set")
+	}
+	if c.flagProvider != providerAuto && c.flagProvider != providerCoreDNS && c.flagProvider != providerKubeDNS {
+		return fmt.Errorf("-dns-provider must be %q, %q or %q", providerAuto, providerCoreDNS, providerKubeDNS)
+	}
+	if c.flagWatch && c.flagWatchInterval <= 0 {
+		return errors.New("-watch-interval must be positive")
+	}
+	return nil
+}
+
+// configure forwards -domain to the cluster IP of the service in the
+// configuration of the cluster DNS. The ConfigMap is only updated if its
+// configuration changed.
+func (c *Command) configure(logger hclog.Logger) error {
+	svc, err := c.clientset.CoreV1().Services(c.flagK8sNamespace).Get(c.flagServiceName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting service %q: %s", c.flagServiceName, err)
+	}
+	ip := svc.Spec.ClusterIP
+	if ip == "" || ip == apiv1.ClusterIPNone {
+		return fmt.Errorf("service %q has no cluster IP", c.flagServiceName)
+	}
+
+	configMaps := c.clientset.CoreV1().ConfigMaps(c.flagDNSNamespace)
+	provider := c.flagProvider
+	var configMap *apiv1.ConfigMap
+	if provider == providerAuto || provider == providerCoreDNS {
+		configMap, err = configMaps.Get(c.flagCoreDNSConfigMap, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			provider = providerCoreDNS
+		case k8serrors.IsNotFound(err) && provider == providerAuto:
+			provider = providerKubeDNS
+		default:
+			return fmt.Errorf("getting ConfigMap %q: %s", c.flagCoreDNSConfigMap, err)
+		}
+	}
+
+	if provider == providerCoreDNS {
+		corefile, err := patchCorefile(configMap.Data[corefileKey], c.flagDomain, ip)
+		if err != nil {
+			return fmt.Errorf("patching the Corefile of ConfigMap %q: %s", c.flagCoreDNSConfigMap, err)
+		}
+		if configMap.Data[corefileKey] == corefile {
+			return nil
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data[corefileKey] = corefile
+		if _, err := configMaps.Update(configMap); err != nil {
+			return fmt.Errorf("updating ConfigMap %q: %s", c.flagCoreDNSConfigMap, err)
+		}
+		logger.Info("Updated the CoreDNS Corefile", "configmap", c.flagCoreDNSConfigMap, "domain", c.flagDomain, "ip", ip)
+		return nil
+	}
+
+	// kube-dns runs without its ConfigMap, in which case it's created.
+	configMap, err = configMaps.Get(c.flagKubeDNSConfigMap, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		stubDomains, err := patchStubDomains("", c.flagDomain, ip)
+		if err != nil {
+			return err
+		}
+		_, err = configMaps.Create(&apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: c.flagKubeDNSConfigMap,
+			},
+			Data: map[string]string{stubDomainsKey: stubDomains},
+		})
+		if err != nil {
+			return fmt.Errorf("creating ConfigMap %q: %s", c.flagKubeDNSConfigMap, err)
+		}
+		logger.Info("Created the kube-dns stub domains", "configmap", c.flagKubeDNSConfigMap, "domain", c.flagDomain, "ip", ip)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting ConfigMap %q: %s", c.flagKubeDNSConfigMap, err)
+	}
+	stubDomains, err := patchStubDomains(configMap.Data[stubDomainsKey], c.flagDomain, ip)
+	if err != nil {
+		return fmt.Errorf("patching the stub domains of ConfigMap %q: %s", c.flagKubeDNSConfigMap, err)
+	}
+	if configMap.Data[stubDomainsKey] == stubDomains {
+		return nil
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[stubDomainsKey] = stubDomains
+	if _, err := configMaps.Update(configMap); err != nil {
+		return fmt.Errorf("updating ConfigMap %q: %s", c.flagKubeDNSConfigMap, err)
+	}
+	logger.Info("Updated the kube-dns stub domains", "configmap", c.flagKubeDNSConfigMap, "domain", c.flagDomain, "ip", ip)
+	return nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Forward the Consul DNS domain from the cluster DNS"
+const help = `
+Usage: consul-k8s consul-dns-config [options]
+
+  Configures the cluster DNS to forward the Consul DNS domain, "consul" by
+  default, to the cluster IP of the Consul DNS service -service-name, so
+  that the pods can resolve names such as web.service.consul.
+
+  For CoreDNS, a server block for the domain is added to the Corefile of
+  the ConfigMap -coredns-configmap. CoreDNS picks it up if its Corefile
+  has the reload plugin, and after a restart otherwise. For kube-dns, the
+  domain is added to the stub domains of the ConfigMap -kube-dns-configmap.
+  The command can be run again: it replaces the configuration it added
+  and only updates the ConfigMap if it changed.
+
+  If -watch is set, the command keeps running and updates the cluster DNS
+  whenever the cluster IP of the service changes.
+`
@@ -0,0 +1,212 @@
+package consuldnsconfig
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{"foo"},
+			ExpErr: "Should have no non-flag arguments.",
+		},
+		{
+			Flags:  []string{},
+			ExpErr: "-k8s-namespace must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace=consul"},
+			ExpErr: "-service-name must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace=consul", "-service-name=consul-dns", "-domain="},
+			ExpErr: "-domain must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace=consul", "-service-name=consul-dns", "-dns-provider=bind"},
+			ExpErr: `-dns-provider must be "auto", "coredns" or "kube-dns"`,
+		},
+		{
+			Flags:  []string{"-k8s-namespace=consul", "-service-name=consul-dns", "-watch", "-watch-interval=0s"},
+			ExpErr: "-watch-interval must be positive",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui, clientset: fake.NewSimpleClientset()}
+			require.Equal(t, 1, cmd.Run(c.Flags))
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+func TestRun_Configure(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		Provider     string
+		ConfigMaps   []runtime.Object
+		ExpConfigMap string
+		ExpData      map[string]string
+		ExpErr       string
+	}{
+		"coredns": {
+			ConfigMaps:   []runtime.Object{configMap("coredns", corefileKey, defaultCorefile)},
+			ExpConfigMap: "coredns",
+			ExpData:      map[string]string{corefileKey: defaultCorefile + corefileBlock("consul", "10.0.0.10")},
+		},
+		"coredns already configured": {
+			ConfigMaps:   []runtime.Object{configMap("coredns", corefileKey, defaultCorefile+corefileBlock("consul", "10.0.0.9"))},
+			ExpConfigMap: "coredns",
+			ExpData:      map[string]string{corefileKey: defaultCorefile + corefileBlock("consul", "10.0.0.10")},
+		},
+		"kube-dns created": {
+			ExpConfigMap: "kube-dns",
+			ExpData:      map[string]string{stubDomainsKey: `{"consul":["10.0.0.10"]}`},
+		},
+		"kube-dns updated": {
+			ConfigMaps:   []runtime.Object{configMap("kube-dns", stubDomainsKey, `{"acme.local": ["1.2.3.4"]}`)},
+			ExpConfigMap: "kube-dns",
+			ExpData:      map[string]string{stubDomainsKey: `{"acme.local":["1.2.3.4"],"consul":["10.0.0.10"]}`},
+		},
+		"kube-dns forced": {
+			Provider: providerKubeDNS,
+			ConfigMaps: []runtime.Object{
+				configMap("coredns", corefileKey, defaultCorefile),
+				configMap("kube-dns", stubDomainsKey, ""),
+			},
+			ExpConfigMap: "kube-dns",
+			ExpData:      map[string]string{stubDomainsKey: `{"consul":["10.0.0.10"]}`},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8s := fake.NewSimpleClientset(append(c.ConfigMaps, dnsService("10.0.0.10"))...)
+			args := []string{"-k8s-namespace=consul", "-service-name=consul-dns"}
+			if c.Provider != "" {
+				args = append(args, "-dns-provider="+c.Provider)
+			}
+
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui, clientset: k8s}
+			require.Equal(t, 0, cmd.Run(args), ui.ErrorWriter.String())
+			actual, err := k8s.CoreV1().ConfigMaps("kube-system").Get(c.ExpConfigMap, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, c.ExpData, actual.Data)
+
+			// Running the command again doesn't change the ConfigMap.
+			k8s.ClearActions()
+			ui = cli.NewMockUi()
+			cmd = Command{UI: ui, clientset: k8s}
+			require.Equal(t, 0, cmd.Run(args), ui.ErrorWriter.String())
+			for _, action := range k8s.Actions() {
+				require.Equal(t, "get", action.GetVerb())
+			}
+		})
+	}
+}
+
+// Test that with -watch the cluster DNS is updated once the service gets
+// its cluster IP and whenever it changes.
+func TestRun_Watch(t *testing.T) {
+	t.Parallel()
+	svc := dnsService("")
+	k8s := fake.NewSimpleClientset(svc, configMap("coredns", corefileKey, defaultCorefile))
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:            ui,
+		clientset:     k8s,
+		retryDuration: 10 * time.Millisecond,
+		sigCh:         make(chan os.Signal, 1),
+	}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-k8s-namespace=consul",
+			"-service-name=consul-dns",
+			"-watch",
+			"-watch-interval=10ms",
+		})
+	}()
+
+	requireCorefile := func(ip string) {
+		require.Eventually(t, func() bool {
+			actual, err := k8s.CoreV1().ConfigMaps("kube-system").Get("coredns", metav1.GetOptions{})
+			return err == nil && actual.Data[corefileKey] == defaultCorefile+corefileBlock("consul", ip)
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	svc.Spec.ClusterIP = "10.0.0.10"
+	_, err := k8s.CoreV1().Services("consul").Update(svc)
+	require.NoError(t, err)
+	requireCorefile("10.0.0.10")
+
+	svc.Spec.ClusterIP = "10.0.0.11"
+	_, err = k8s.CoreV1().Services("consul").Update(svc)
+	require.NoError(t, err)
+	requireCorefile("10.0.0.11")
+
+	cmd.sigCh <- os.Interrupt
+	select {
+	case code := <-exitCh:
+		require.Equal(t, 0, code, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not exit after interrupt")
+	}
+}
+
+// Test that the command exits with an error if it's interrupted before the
+// service has a cluster IP.
+func TestRun_InterruptedBeforeClusterIP(t *testing.T) {
+	t.Parallel()
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:            ui,
+		clientset:     fake.NewSimpleClientset(dnsService(apiv1.ClusterIPNone)),
+		retryDuration: 10 * time.Millisecond,
+		sigCh:         make(chan os.Signal, 1),
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cmd.sigCh <- os.Interrupt
+	}()
+	require.Equal(t, 1, cmd.Run([]string{"-k8s-namespace=consul", "-service-name=consul-dns"}))
+	require.Contains(t, ui.ErrorWriter.String(), "Interrupted before the cluster DNS was configured")
+}
+
+func dnsService(clusterIP string) *apiv1.Service {
+	return &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "consul-dns",
+			Namespace: "consul",
+		},
+		Spec: apiv1.ServiceSpec{
+			ClusterIP: clusterIP,
+			Ports:     []apiv1.ServicePort{{Name: "dns-udp", Protocol: "UDP", Port: 53}},
+		},
+	}
+}
+
+func configMap(name, key, value string) *apiv1.ConfigMap {
+	return &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "kube-system",
+		},
+		Data: map[string]string{key: value},
+	}
+}
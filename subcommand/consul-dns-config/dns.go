@@ -0,0 +1,78 @@
+package consuldnsconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// corefileKey is the key of the Corefile in the CoreDNS ConfigMap.
+	corefileKey = "Corefile"
+
+	// stubDomainsKey is the key of the stub domains in the kube-dns
+	// ConfigMap.
+	stubDomainsKey = "stubDomains"
+
+	// corefileBegin and corefileEnd delimit the server block of the Corefile
+	// managed by the command, so that it's replaced rather than added again.
+	corefileBegin = "# BEGIN consul-k8s consul-dns-config, do not edit."
+	corefileEnd   = "# END consul-k8s consul-dns-config"
+)
+
+// corefileBlock returns the block of the Corefile forwarding domain to the
+// DNS server ip.
+func corefileBlock(domain, ip string) string {
+	return fmt.Sprintf(`%s
+%s:53 {
+    errors
+    cache 30
+    forward . %s
+}
+%s
+`, corefileBegin, domain, ip, corefileEnd)
+}
+
+// patchCorefile returns corefile with the block forwarding domain to ip.
+// The block replaces the one added by a previous run, or is appended to
+// corefile. It returns an error if corefile has a begin marker without an end
+// marker, since the block can't be replaced then.
+func patchCorefile(corefile, domain, ip string) (string, error) {
+	block := corefileBlock(domain, ip)
+	begin := strings.Index(corefile, corefileBegin)
+	if begin < 0 {
+		if corefile != "" && !strings.HasSuffix(corefile, "\n") {
+			corefile += "\n"
+		}
+		return corefile + block, nil
+	}
+	end := strings.Index(corefile[begin:], corefileEnd)
+	if end < 0 {
+		return "", fmt.Errorf("the Corefile has %q without %q", corefileBegin, corefileEnd)
+	}
+	end += begin + len(corefileEnd)
+	// The newline after the end marker is part of the block.
+	if end < len(corefile) && corefile[end] == '\n' {
+		end++
+	}
+	return corefile[:begin] + block + corefile[end:], nil
+}
+
+// patchStubDomains returns the JSON stub domains of kube-dns stubDomains with
+// domain forwarded to ip. The other domains are kept.
+func patchStubDomains(stubDomains, domain, ip string) (string, error) {
+	domains := make(map[string][]string)
+	if strings.TrimSpace(stubDomains) != "" {
+		if err := json.Unmarshal([]byte(stubDomains), &domains); err != nil {
+			return "", fmt.Errorf("parsing %s: %s", stubDomainsKey, err)
+		}
+	}
+	domains[domain] = []string{ip}
+	// The keys of maps are marshalled in order, so the value only changes
+	// with the domains.
+	out, err := json.Marshal(domains)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,95 @@
+package consuldnsconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const defaultCorefile = `.:53 {
+    errors
+    health
+    kubernetes cluster.local in-addr.arpa ip6.arpa {
+      pods insecure
+      fallthrough in-addr.arpa ip6.arpa
+    }
+    forward . /etc/resolv.conf
+    cache 30
+    reload
+}
+`
+
+func TestPatchCorefile(t *testing.T) {
+	block := corefileBlock("consul", "10.0.0.10")
+	cases := map[string]struct {
+		corefile string
+		exp      string
+		expErr   string
+	}{
+		"empty": {
+			exp: block,
+		},
+		"appended": {
+			corefile: defaultCorefile,
+			exp:      defaultCorefile + block,
+		},
+		"no trailing newline": {
+			corefile: "(snippet) {\n}",
+			exp:      "(snippet) {\n}\n" + block,
+		},
+		"replaced": {
+			corefile: defaultCorefile + corefileBlock("consul", "10.0.0.9") + "example.org:53 {\n}\n",
+			exp:      defaultCorefile + block + "example.org:53 {\n}\n",
+		},
+		"unchanged": {
+			corefile: defaultCorefile + block,
+			exp:      defaultCorefile + block,
+		},
+		"no end marker": {
+			corefile: defaultCorefile + corefileBegin + "\nconsul:53 {\n}\n",
+			expErr:   `the Corefile has "# BEGIN consul-k8s consul-dns-config, do not edit." without "# END consul-k8s consul-dns-config"`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			corefile, err := patchCorefile(c.corefile, "consul", "10.0.0.10")
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, corefile)
+		})
+	}
+}
+
+func TestPatchStubDomains(t *testing.T) {
+	cases := map[string]struct {
+		stubDomains string
+		exp         string
+		expErr      string
+	}{
+		"empty": {
+			exp: `{"consul":["10.0.0.10"]}`,
+		},
+		"other domains": {
+			stubDomains: `{"acme.local": ["1.2.3.4"], "consul": ["10.0.0.9"]}`,
+			exp:         `{"acme.local":["1.2.3.4"],"consul":["10.0.0.10"]}`,
+		},
+		"invalid": {
+			stubDomains: `consul: 10.0.0.9`,
+			expErr:      "parsing stubDomains: invalid character 'c' looking for beginning of value",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			stubDomains, err := patchStubDomains(c.stubDomains, "consul", "10.0.0.10")
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, stubDomains)
+		})
+	}
+}
@@ -0,0 +1,928 @@
+package controller
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/gatewayapi"
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/controller"
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	helpercontroller "github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul-k8s/helper/fips"
+	"github.com/hashicorp/consul-k8s/helper/leader"
+	"github.com/hashicorp/consul-k8s/helper/metrics"
+	"github.com/hashicorp/consul-k8s/helper/tracing"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// configEntryKinds are the custom resources reconciled into config entries.
+var configEntryKinds = []struct {
+	// resource is the resource name of the custom resources.
+	resource string
+	// clusterScoped is true if the custom resources aren't namespaced.
+	clusterScoped bool
+	new           func() v1alpha1.ConfigEntryResource
+}{
+	{
+		resource: v1alpha1.ServiceDefaultsResource,
+		new:      func() v1alpha1.ConfigEntryResource { return &v1alpha1.ServiceDefaults{} },
+	},
+	{
+		resource:      v1alpha1.ProxyDefaultsResource,
+		clusterScoped: true,
+		new:           func() v1alpha1.ConfigEntryResource { return &v1alpha1.ProxyDefaults{} },
+	},
+	{
+		resource: v1alpha1.ServiceResolverResource,
+		new:      func() v1alpha1.ConfigEntryResource { return &v1alpha1.ServiceResolver{} },
+	},
+	{
+		resource: v1alpha1.ServiceRouterResource,
+		new:      func() v1alpha1.ConfigEntryResource { return &v1alpha1.ServiceRouter{} },
+	},
+	{
+		resource: v1alpha1.ServiceSplitterResource,
+		new:      func() v1alpha1.ConfigEntryResource { return &v1alpha1.ServiceSplitter{} },
+	},
+	{
+		resource: v1alpha1.ServiceIntentionsResource,
+		new:      func() v1alpha1.ConfigEntryResource { return &v1alpha1.ServiceIntentions{} },
+	},
+	{
+		resource: v1alpha1.IngressGatewayResource,
+		new:      func() v1alpha1.ConfigEntryResource { return &v1alpha1.IngressGateway{} },
+	},
+	{
+		resource: v1alpha1.TerminatingGatewayResource,
+		new:      func() v1alpha1.ConfigEntryResource { return &v1alpha1.TerminatingGateway{} },
+	},
+	{
+		resource:      v1alpha1.MeshResource,
+		clusterScoped: true,
+		new:           func() v1alpha1.ConfigEntryResource { return &v1alpha1.Mesh{} },
+	},
+	{
+		resource:      v1alpha1.ExportedServicesResource,
+		clusterScoped: true,
+		new:           func() v1alpha1.ConfigEntryResource { return &v1alpha1.ExportedServices{} },
+	},
+	{
+		resource:      v1alpha1.SamenessGroupResource,
+		clusterScoped: true,
+		new:           func() v1alpha1.ConfigEntryResource { return &v1alpha1.SamenessGroup{} },
+	},
+	{
+		resource:      v1alpha1.JWTProviderResource,
+		clusterScoped: true,
+		new:           func() v1alpha1.ConfigEntryResource { return &v1alpha1.JWTProvider{} },
+	},
+}
+
+// peeringKinds are the custom resources reconciled into Consul peerings.
+var peeringKinds = []struct {
+	// resource is the resource name of the custom resources.
+	resource string
+	new      func() v1alpha1.PeeringResource
+}{
+	{
+		resource: v1alpha1.PeeringAcceptorResource,
+		new:      func() v1alpha1.PeeringResource { return &v1alpha1.PeeringAcceptor{} },
+	},
+	{
+		resource: v1alpha1.PeeringDialerResource,
+		new:      func() v1alpha1.PeeringResource { return &v1alpha1.PeeringDialer{} },
+	},
+}
+
+// Command runs the controllers of the consul.hashicorp.com custom resources.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+	audit      *k8sflags.AuditFlags
+	writeLimit *k8sflags.WriteLimitFlags
+
+	// leaderElection only runs the controllers in the replica holding
+	// the leader lock.
+	leaderElection *k8sflags.LeaderElectionFlags
+
+	flagWatchNamespace string
+	flagResyncPeriod   time.Duration
+	flagOrphanEntries  bool
+
+	flagRegistrationCheckInterval time.Duration // How often the checks of Registration resources are run
+
+	// Flags of the manager running the controllers
+	flagHealthProbeListen string // Address to serve the health probes on
+	flagMetricsListen     string // Address to serve the Prometheus metrics on
+	flagEnablePprof       bool   // Serve the pprof profiles on -metrics-listen
+	flagTracingEndpoint   string // OTLP/HTTP endpoint to export the traces to
+
+	// Flags of the Gateway API controllers
+	flagEnableGatewayController bool   // Run the controllers of the Gateway API resources
+	flagGatewayConsulImage      string // Consul image of the gateways' init container
+	flagGatewayEnvoyImage       string // Envoy image of the gateways
+
+	// Flags of the ingress gateway services
+	flagEnableIngressGatewayServices bool   // Create the services of the IngressGateway resources
+	flagIngressGatewayServiceType    string // Default type of the ingress gateway services
+
+	// Flags of the peering controllers
+	flagMeshGatewayServiceName string // Mesh gateway service checked when peering through mesh gateways
+
+	// Flags of the mesh gateway janitor
+	flagEnableMeshGatewayJanitor bool   // Deregister the mesh gateway instances of deleted pods
+	flagMeshGatewayPodSelector   string // Label selector of the mesh gateway pods
+
+	// Flags of the admission webhook
+	flagWebhookListen   string // Address to serve the webhook on
+	flagWebhookCertFile string // TLS cert of the webhook (PEM)
+	flagWebhookKeyFile  string // TLS cert private key of the webhook (PEM)
+
+	// Flags to support namespaces
+	flagEnableNamespaces           bool     // Use namespacing on all components
+	flagConsulDestinationNamespace string   // Consul namespace to write everything to if not mirroring
+	flagEnableK8SNSMirroring       bool     // Enables mirroring of k8s namespaces into Consul
+	flagK8SNSMirroringPrefix       string   // Prefix added to Consul namespaces created when mirroring
+	flagCrossNamespaceACLPolicy    string   // The name of the ACL policy to add to every created namespace if ACLs are enabled
+	flagAllowNamespaceTargets      []string // Kubernetes namespaces allowed to target other Consul namespaces
+
+	// Flags of the namespace controller
+	flagEnableNamespaceProvisioning bool     // Create the mirrored Consul namespace of each Kubernetes namespace
+	flagNamespaceDefaultPolicies    []string // ACL policies attached to the provisioned namespaces as defaults
+	flagNamespaceDefaultRoles       []string // ACL roles attached to the provisioned namespaces as defaults
+
+	// Flags to support admin partitions
+	flagPartition             string   // Admin partition of the Consul agent
+	flagAllowPartitionTargets []string // Kubernetes namespaces allowed to target other partitions
+
+	consulClient  *api.Client
+	consulConfig  *api.Config
+	dynamicClient dynamic.Interface
+	kubeClient    kubernetes.Interface
+
+	// sigCh receives a signal when the command should stop.
+	sigCh chan os.Signal
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagWatchNamespace, "watch-namespace", "",
+		"Kubernetes namespace to watch for namespaced custom resources. Defaults to all namespaces.")
+	c.flags.DurationVar(&c.flagResyncPeriod, "resync-period", 5*time.Minute,
+		"How often all resources are synced again, e.g. to revert changes made to config entries outside of "+
+			"Kubernetes and to update the status of linked services. If 0, resources are only synced when they change.")
+	c.flags.BoolVar(&c.flagOrphanEntries, "orphan-config-entries", false,
+		"If true, deleting a resource leaves its config entry in Consul. By default, the config entry is deleted "+
+			"and the resource is only removed once Consul has deleted the entry.")
+	c.flags.DurationVar(&c.flagRegistrationCheckInterval, "registration-check-interval", 30*time.Second,
+		"How often the health checks of the services of Registration resources are run and their status is "+
+			"updated in the Consul catalog.")
+	c.flags.StringVar(&c.flagHealthProbeListen, "health-probe-listen", "",
+		"Address to serve the /live and /ready endpoints, and their /healthz and /readyz aliases, on, "+
+			"e.g. \":8081\". If blank, the health endpoints are disabled.")
+	c.flags.StringVar(&c.flagMetricsListen, "metrics-listen", "",
+		"Address to serve the Prometheus metrics on at /metrics, e.g. \":9090\". If blank, metrics are disabled.")
+	c.flags.BoolVar(&c.flagEnablePprof, "enable-pprof", false,
+		"If true, the pprof profiles are served on /debug/pprof/ on -metrics-listen, e.g. to profile the memory "+
+			"and CPU usage in place. Requires -metrics-listen.")
+	c.flags.StringVar(&c.flagTracingEndpoint, "tracing-otlp-endpoint", "",
+		"OTLP/HTTP endpoint of an OpenTelemetry collector to export the traces of the reconciles, webhook requests "+
+			"and Consul API requests to, e.g. \"http://otel-collector:4318\". If blank, tracing is disabled.")
+	c.flags.BoolVar(&c.flagEnableGatewayController, "enable-gateway-controller", false,
+		"If true, the controllers of the Gateway API resources of the gateway.networking.k8s.io API group are run. "+
+			"Requires Consul 1.15+ and the Gateway API CRDs.")
+	c.flags.StringVar(&c.flagGatewayConsulImage, "gateway-consul-image", "hashicorp/consul:1.15.2",
+		"Docker image of Consul used by the gateways to register in Consul and generate their Envoy bootstrap.")
+	c.flags.StringVar(&c.flagGatewayEnvoyImage, "gateway-envoy-image", "envoyproxy/envoy:v1.25.1",
+		"Docker image of Envoy used by the gateways.")
+	c.flags.BoolVar(&c.flagEnableIngressGatewayServices, "enable-ingress-gateway-services", false,
+		"If true, a Kubernetes service exposing the listeners of each IngressGateway resource is created and "+
+			"kept in sync with its listeners.")
+	c.flags.StringVar(&c.flagIngressGatewayServiceType, "ingress-gateway-service-type", string(corev1.ServiceTypeLoadBalancer),
+		"Type of the services of the IngressGateway resources, one of \"LoadBalancer\", \"NodePort\" or \"ClusterIP\". "+
+			"Resources can set another type with the consul.hashicorp.com/ingress-gateway-service-type annotation.")
+	c.flags.StringVar(&c.flagMeshGatewayServiceName, "mesh-gateway-service-name", "mesh-gateway",
+		"Name of the mesh gateway service in Consul. If the mesh config entry peers through mesh gateways, peering "+
+			"tokens aren't generated and peerings aren't established until an instance of it is healthy. "+
+			"If blank, the mesh gateways aren't checked.")
+	c.flags.BoolVar(&c.flagEnableMeshGatewayJanitor, "enable-mesh-gateway-janitor", false,
+		"If true, the instances of -mesh-gateway-service-name registered by pods that were deleted without "+
+			"deregistering them are deregistered. Requires the gateways to be registered with "+
+			"register-mesh-gateway -pod-name.")
+	c.flags.StringVar(&c.flagMeshGatewayPodSelector, "mesh-gateway-pod-selector", "component=mesh-gateway",
+		"Label selector of the mesh gateway pods, whose deletion triggers the cleanup of "+
+			"-enable-mesh-gateway-janitor.")
+	c.flags.StringVar(&c.flagWebhookListen, "webhook-listen", "",
+		"Address to serve the validating admission webhook on, e.g. \":8080\". The webhook is disabled if empty.")
+	c.flags.StringVar(&c.flagWebhookCertFile, "webhook-tls-cert-file", "",
+		"PEM-encoded TLS certificate of the webhook. Required if -webhook-listen is set.")
+	c.flags.StringVar(&c.flagWebhookKeyFile, "webhook-tls-key-file", "",
+		"PEM-encoded TLS private key of the webhook. Required if -webhook-listen is set.")
+	c.flags.BoolVar(&c.flagEnableNamespaces, "enable-namespaces", false,
+		"[Enterprise Only] Enables namespaces, in either a single Consul namespace or mirrored")
+	c.flags.StringVar(&c.flagConsulDestinationNamespace, "consul-destination-namespace", "default",
+		"[Enterprise Only] Defines which Consul namespace to write all config entries to. If '-enable-k8s-namespace-mirroring' "+
+			"is true, this is not used.")
+	c.flags.BoolVar(&c.flagEnableK8SNSMirroring, "enable-k8s-namespace-mirroring", false, "[Enterprise Only] Enables "+
+		"k8s namespace mirroring")
+	c.flags.StringVar(&c.flagK8SNSMirroringPrefix, "k8s-namespace-mirroring-prefix", "",
+		"[Enterprise Only] Prefix that will be added to all k8s namespaces mirrored into Consul if mirroring is enabled.")
+	c.flags.StringVar(&c.flagCrossNamespaceACLPolicy, "consul-cross-namespace-acl-policy", "",
+		"[Enterprise Only] Name of the ACL policy to attach to all created Consul namespaces to allow service "+
+			"discovery across Consul namespaces. Only necessary if ACLs are enabled.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagAllowNamespaceTargets), "allow-consul-namespace-target",
+		"[Enterprise Only] Allows the resources of a Kubernetes namespace to write their config entry to another "+
+			"Consul namespace with spec.namespace, in the form <kubernetes namespace>=<consul namespace>. Either "+
+			"side can be \"*\". May be specified multiple times.")
+	c.flags.BoolVar(&c.flagEnableNamespaceProvisioning, "enable-namespace-provisioning", false,
+		"[Enterprise Only] If true, the mirrored Consul namespace of each Kubernetes namespace is created as soon as "+
+			"the Kubernetes namespace is created. Requires -enable-namespaces and -enable-k8s-namespace-mirroring.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagNamespaceDefaultPolicies), "consul-namespace-default-policy",
+		"[Enterprise Only] Name of an ACL policy attached as a default policy to the namespaces created with "+
+			"-enable-namespace-provisioning, next to -consul-cross-namespace-acl-policy. May be specified multiple times.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagNamespaceDefaultRoles), "consul-namespace-default-role",
+		"[Enterprise Only] Name of an ACL role attached as a default role to the namespaces created with "+
+			"-enable-namespace-provisioning. May be specified multiple times.")
+	c.flags.StringVar(&c.flagPartition, "partition", "",
+		"[Enterprise Only] Admin partition of the Consul agent. Config entries are written to it unless their "+
+			"resource targets another partition with spec.partition. Defaults to the default partition.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagAllowPartitionTargets), "allow-consul-partition-target",
+		"[Enterprise Only] Allows the resources of a Kubernetes namespace to write their config entry to another "+
+			"admin partition with spec.partition, in the form <kubernetes namespace>=<partition>. Either side can "+
+			"be \"*\". Cluster-scoped resources can target any partition. May be specified multiple times.")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.audit = &k8sflags.AuditFlags{}
+	flags.Merge(c.flags, c.audit.Flags())
+	c.writeLimit = &k8sflags.WriteLimitFlags{}
+	flags.Merge(c.flags, c.writeLimit.Flags())
+	// The controller used a ConfigMap lock before Leases, so keep it by
+	// default for the existing RBAC rules.
+	c.leaderElection = &k8sflags.LeaderElectionFlags{
+		DefaultID:       "consul-k8s-controller-leader",
+		DefaultLockType: leader.ConfigMapsLock,
+	}
+	flags.Merge(c.flags, c.leaderElection.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagWebhookListen != "" && (c.flagWebhookCertFile == "" || c.flagWebhookKeyFile == "") {
+		c.UI.Error("-webhook-tls-cert-file and -webhook-tls-key-file must be set if -webhook-listen is set")
+		return 1
+	}
+	if err := c.leaderElection.Validate(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if c.flagEnablePprof && c.flagMetricsListen == "" {
+		c.UI.Error("-metrics-listen must be set if -enable-pprof is set")
+		return 1
+	}
+	if c.flagEnableMeshGatewayJanitor && c.flagMeshGatewayServiceName == "" {
+		c.UI.Error("-mesh-gateway-service-name must be set if -enable-mesh-gateway-janitor is set")
+		return 1
+	}
+	if c.flagEnableNamespaceProvisioning && (!c.flagEnableNamespaces || !c.flagEnableK8SNSMirroring) {
+		c.UI.Error("-enable-namespace-provisioning requires -enable-namespaces and -enable-k8s-namespace-mirroring")
+		return 1
+	}
+	if c.flagEnableGatewayController && (c.flagEnableNamespaces || c.flagPartition != "") {
+		c.UI.Error("-enable-gateway-controller isn't supported with Consul namespaces or admin partitions")
+		return 1
+	}
+
+	switch corev1.ServiceType(c.flagIngressGatewayServiceType) {
+	case corev1.ServiceTypeLoadBalancer, corev1.ServiceTypeNodePort, corev1.ServiceTypeClusterIP:
+	default:
+		c.UI.Error(fmt.Sprintf("-ingress-gateway-service-type must be one of \"LoadBalancer\", \"NodePort\" or \"ClusterIP\", not %q",
+			c.flagIngressGatewayServiceType))
+		return 1
+	}
+
+	namespaceTargets, err := parseTargetRules(c.flagAllowNamespaceTargets)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Invalid -allow-consul-namespace-target: %s", err))
+		return 1
+	}
+	partitionTargets, err := parseTargetRules(c.flagAllowPartitionTargets)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Invalid -allow-consul-partition-target: %s", err))
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	stopAudit, err := c.audit.Start(logger.Named("audit"), "consul-k8s-controller")
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error opening the audit log: %s", err))
+		return 1
+	}
+	defer stopAudit()
+	if err := c.writeLimit.Limit(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.dynamicClient == nil || c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		if c.dynamicClient == nil {
+			c.dynamicClient, err = dynamic.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+		if c.kubeClient == nil {
+			c.kubeClient, err = kubernetes.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+	}
+	if c.consulClient == nil {
+		// The config is kept for the peering controllers and the config
+		// entries of other partitions, which the Consul API client doesn't
+		// support.
+		c.consulConfig = api.DefaultConfig()
+		c.http.MergeOntoConfig(c.consulConfig)
+		c.consulClient, err = consul.NewClient(c.consulConfig)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	if c.flagTracingEndpoint != "" {
+		stop, err := tracing.Start(logger.Named("tracing"), c.flagTracingEndpoint, "consul-k8s-controller")
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error starting tracing: %s", err))
+			return 1
+		}
+		defer stop()
+	}
+
+	// Sync errors are recorded as events on the resources.
+	recorder, stopRecorder := subcommand.EventRecorder(c.kubeClient, "consul-k8s-controller")
+	defer stopRecorder()
+
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+
+	// There's one controller per kind, keyed by its resource.
+	controllers := make(map[string]runner)
+	var webhooks []*controller.ValidatingWebhook
+	var resolvers *controller.ValidatingWebhook
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", (&controller.ConversionWebhook{Log: logger.Named("conversion")}).Handle)
+	for _, kind := range configEntryKinds {
+		watchNamespace := c.flagWatchNamespace
+		if kind.clusterScoped {
+			watchNamespace = ""
+		}
+		configEntryController := &controller.ConfigEntryController{
+			Log:                        logger.Named(kind.resource),
+			Client:                     c.dynamicClient,
+			ConsulClient:               c.consulClient,
+			ConsulConfig:               c.consulConfig,
+			Resource:                   v1alpha1.GroupVersion.WithResource(kind.resource),
+			New:                        kind.new,
+			Namespace:                  watchNamespace,
+			EnableConsulNamespaces:     c.flagEnableNamespaces,
+			ConsulDestinationNamespace: c.flagConsulDestinationNamespace,
+			EnableNSMirroring:          c.flagEnableK8SNSMirroring,
+			NSMirroringPrefix:          c.flagK8SNSMirroringPrefix,
+			CrossNSACLPolicy:           c.flagCrossNamespaceACLPolicy,
+			NamespaceTargets:           namespaceTargets,
+			ConsulPartition:            c.flagPartition,
+			PartitionTargets:           partitionTargets,
+			OrphanConfigEntries:        c.flagOrphanEntries,
+			EventRecorder:              recorder,
+			ResyncPeriod:               c.flagResyncPeriod,
+		}
+		webhook := &controller.ValidatingWebhook{
+			Log:        logger.Named(kind.resource + "/webhook"),
+			Controller: configEntryController,
+		}
+		webhooks = append(webhooks, webhook)
+		if kind.resource == v1alpha1.ServiceResolverResource {
+			resolvers = webhook
+		}
+		mux.HandleFunc("/validate/"+kind.resource, webhook.Handle)
+		if _, ok := kind.new().(v1alpha1.Defaulter); ok {
+			defaulter := &controller.DefaultingWebhook{
+				Log: logger.Named(kind.resource + "/webhook"),
+				New: kind.new,
+			}
+			mux.HandleFunc("/mutate/"+kind.resource, defaulter.Handle)
+		}
+		controllers[kind.resource] = &helpercontroller.Controller{
+			Log:      logger.Named(kind.resource + "/controller"),
+			Name:     kind.resource,
+			Resource: configEntryController,
+		}
+	}
+
+	// The subset references of the routers, splitters and resolvers are
+	// checked against the cached resolver resources.
+	for _, webhook := range webhooks {
+		webhook.Resolvers = resolvers
+	}
+
+	for _, kind := range peeringKinds {
+		controllers[kind.resource] = &helpercontroller.Controller{
+			Log:  logger.Named(kind.resource + "/controller"),
+			Name: kind.resource,
+			Resource: &controller.PeeringController{
+				Log:          logger.Named(kind.resource),
+				Client:       c.dynamicClient,
+				KubeClient:   c.kubeClient,
+				ConsulConfig: c.consulConfig,
+				Resource:     v1alpha1.GroupVersion.WithResource(kind.resource),
+				New:          kind.new,
+				Namespace:    c.flagWatchNamespace,
+				ResyncPeriod: c.flagResyncPeriod,
+
+				MeshGatewayServiceName: c.flagMeshGatewayServiceName,
+				EventRecorder:          recorder,
+			},
+		}
+	}
+
+	controllers[v1alpha1.RegistrationResource] = &helpercontroller.Controller{
+		Log:  logger.Named(v1alpha1.RegistrationResource + "/controller"),
+		Name: v1alpha1.RegistrationResource,
+		Resource: &controller.RegistrationController{
+			Log:           logger.Named(v1alpha1.RegistrationResource),
+			Client:        c.dynamicClient,
+			ConsulClient:  c.consulClient,
+			Namespace:     c.flagWatchNamespace,
+			EventRecorder: recorder,
+			ResyncPeriod:  c.flagRegistrationCheckInterval,
+		},
+	}
+
+	if c.flagEnableIngressGatewayServices {
+		// The config entry controller of the resource already uses its
+		// name as key.
+		name := v1alpha1.IngressGatewayResource + "/services"
+		controllers[name] = &helpercontroller.Controller{
+			Log:  logger.Named(name + "/controller"),
+			Name: name,
+			Resource: &controller.IngressGatewayServiceController{
+				Log:          logger.Named(name),
+				Client:       c.dynamicClient,
+				KubeClient:   c.kubeClient,
+				Namespace:    c.flagWatchNamespace,
+				ServiceType:  corev1.ServiceType(c.flagIngressGatewayServiceType),
+				ResyncPeriod: c.flagResyncPeriod,
+			},
+		}
+	}
+
+	if c.flagEnableNamespaceProvisioning {
+		controllers["namespaces"] = &helpercontroller.Controller{
+			Log:  logger.Named("namespaces/controller"),
+			Name: "namespaces",
+			Resource: &controller.NamespaceController{
+				Log:               logger.Named("namespaces"),
+				KubeClient:        c.kubeClient,
+				ConsulClient:      c.consulClient,
+				NSMirroringPrefix: c.flagK8SNSMirroringPrefix,
+				CrossNSACLPolicy:  c.flagCrossNamespaceACLPolicy,
+				DefaultPolicies:   c.flagNamespaceDefaultPolicies,
+				DefaultRoles:      c.flagNamespaceDefaultRoles,
+				ResyncPeriod:      c.flagResyncPeriod,
+			},
+		}
+	}
+
+	if c.flagEnableMeshGatewayJanitor {
+		controllers["mesh-gateway-janitor"] = &helpercontroller.Controller{
+			Log:  logger.Named("mesh-gateway-janitor/controller"),
+			Name: "mesh-gateway-janitor",
+			Resource: &controller.MeshGatewayJanitor{
+				Log:          logger.Named("mesh-gateway-janitor"),
+				KubeClient:   c.kubeClient,
+				ConsulClient: c.consulClient,
+				ConsulConfig: c.consulConfig,
+				ServiceName:  c.flagMeshGatewayServiceName,
+				PodSelector:  c.flagMeshGatewayPodSelector,
+				Namespace:    c.flagWatchNamespace,
+				ResyncPeriod: c.flagResyncPeriod,
+			},
+		}
+	}
+
+	if c.flagEnableGatewayController {
+		controllers[gatewayapi.GatewayResource] = &helpercontroller.Controller{
+			Log:  logger.Named(gatewayapi.GatewayResource + "/controller"),
+			Name: gatewayapi.GatewayResource,
+			Resource: &controller.GatewayController{
+				Log:           logger.Named(gatewayapi.GatewayResource),
+				Client:        c.dynamicClient,
+				KubeClient:    c.kubeClient,
+				ConsulClient:  c.consulClient,
+				Namespace:     c.flagWatchNamespace,
+				ImageConsul:   c.flagGatewayConsulImage,
+				ImageEnvoy:    c.flagGatewayEnvoyImage,
+				EventRecorder: recorder,
+				ResyncPeriod:  c.flagResyncPeriod,
+			},
+		}
+		controllers["secrets"] = &helpercontroller.Controller{
+			Log:  logger.Named("secrets/controller"),
+			Name: "secrets",
+			Resource: &controller.GatewayCertificateController{
+				Log:          logger.Named("secrets"),
+				Client:       c.dynamicClient,
+				KubeClient:   c.kubeClient,
+				ConsulClient: c.consulClient,
+				Namespace:    c.flagWatchNamespace,
+			},
+		}
+		for kind, resource := range map[string]string{
+			gatewayapi.HTTPRouteKind: gatewayapi.HTTPRouteResource,
+			gatewayapi.TCPRouteKind:  gatewayapi.TCPRouteResource,
+		} {
+			controllers[resource] = &helpercontroller.Controller{
+				Log:  logger.Named(resource + "/controller"),
+				Name: resource,
+				Resource: &controller.RouteController{
+					Log:          logger.Named(resource),
+					Client:       c.dynamicClient,
+					KubeClient:   c.kubeClient,
+					ConsulClient: c.consulClient,
+					Kind:         kind,
+					Namespace:    c.flagWatchNamespace,
+					ResyncPeriod: c.flagResyncPeriod,
+				},
+			}
+		}
+	}
+
+	// All the controllers run in this process. If any of them, the leader
+	// election or one of the servers exits unexpectedly, stop all of them.
+	doneCh := make(chan struct{}, len(controllers)+4)
+	mgr := &manager{
+		log:           logger.Named("manager"),
+		controllers:   controllers,
+		leaseDuration: leader.DefaultLeaseDuration,
+		renewDeadline: leader.DefaultRenewDeadline,
+		retryPeriod:   leader.DefaultRetryPeriod,
+	}
+	if c.leaderElection.Enabled() {
+		lock, err := c.leaderElection.Lock(c.kubeClient, recorder)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating the leader lock: %s", err))
+			return 1
+		}
+		if err := mgr.startWithLeaderElection(lock, ctx.Done(), doneCh); err != nil {
+			c.UI.Error(fmt.Sprintf("Error starting the leader election: %s", err))
+			return 1
+		}
+	} else {
+		mgr.start(ctx.Done(), doneCh)
+	}
+
+	if c.flagHealthProbeListen != "" {
+		server := &http.Server{Addr: c.flagHealthProbeListen, Handler: mgr.healthHandler()}
+		defer server.Close()
+		go serve(logger, "health probes", server, doneCh)
+	}
+	if c.flagMetricsListen != "" {
+		handler, err := mgr.metricsHandler()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error registering metrics: %s", err))
+			return 1
+		}
+		if c.flagEnablePprof {
+			handler = metrics.Pprof(handler)
+		}
+		server := &http.Server{Addr: c.flagMetricsListen, Handler: handler}
+		defer server.Close()
+		go serve(logger, "metrics", server, doneCh)
+	}
+
+	var webhookServer *http.Server
+	if c.flagWebhookListen != "" {
+		server := &http.Server{
+			Addr:      c.flagWebhookListen,
+			Handler:   tracing.Handler("webhook", mux),
+			TLSConfig: fips.Constrain(nil),
+		}
+		defer server.Close()
+		webhookServer = server
+		// The validating webhooks check the resources against their
+		// caches, which run in every replica, so they're only served once
+		// the caches have synced.
+		var synced []cache.InformerSynced
+		for _, webhook := range webhooks {
+			go webhook.Run(ctx.Done())
+			synced = append(synced, webhook.HasSynced)
+		}
+		go func() {
+			if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+				return
+			}
+			logger.Info("serving webhook", "address", c.flagWebhookListen)
+			if err := server.ListenAndServeTLS(c.flagWebhookCertFile, c.flagWebhookKeyFile); err != http.ErrServerClosed {
+				logger.Error("error serving webhook", "err", err)
+			}
+			doneCh <- struct{}{}
+		}()
+	}
+
+	select {
+	// Unexpected exit
+	case <-doneCh:
+		cancelF()
+		mgr.wait()
+		return 1
+
+	// Interrupted, gracefully exit once the in-flight webhook requests are
+	// answered and the in-flight reconciles are done.
+	case <-c.sigCh:
+		logger.Info("shutting down")
+		cancelF()
+		if webhookServer != nil {
+			if err := subcommand.Shutdown(webhookServer); err != nil {
+				logger.Error("error shutting down the webhook server", "err", err)
+			}
+		}
+		waitCh := make(chan struct{})
+		go func() {
+			mgr.wait()
+			close(waitCh)
+		}()
+		if !subcommand.Wait(waitCh) {
+			logger.Error("timed out waiting for the controllers to stop")
+			return 1
+		}
+		return 0
+	}
+}
+
+// serve serves the HTTP endpoints of server until it's closed. doneCh
+// receives a value when it stops.
+func serve(logger hclog.Logger, name string, server *http.Server, doneCh chan<- struct{}) {
+	logger.Info("serving "+name, "address", server.Addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		logger.Error("error serving "+name, "err", err)
+	}
+	doneCh <- struct{}{}
+}
+
+// parseTargetRules parses the values of the -allow-consul-*-target flags.
+func parseTargetRules(values []string) ([]controller.TargetRule, error) {
+	var rules []controller.TargetRule
+	for _, value := range values {
+		rule, err := controller.ParseTargetRule(value)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Reconcile custom resources into Consul config entries"
+const help = `
+Usage: consul-k8s controller [options]
+
+  Runs the controllers that reconcile the custom resources of the
+  consul.hashicorp.com API group into Consul config entries:
+
+    ServiceDefaults     service-defaults config entries
+    ProxyDefaults       the global proxy-defaults config entry
+    ServiceResolver     service-resolver config entries
+    ServiceRouter       service-router config entries
+    ServiceSplitter     service-splitter config entries
+    ServiceIntentions   service-intentions config entries (Consul 1.9+)
+    IngressGateway      ingress-gateway config entries (Consul 1.8+)
+    TerminatingGateway  terminating-gateway config entries (Consul 1.8+)
+    Mesh                the mesh config entry (Consul 1.10+)
+    ExportedServices    exported-services config entries (Consul 1.11+)
+    SamenessGroup       sameness-group config entries (Consul 1.16+)
+    JWTProvider         jwt-provider config entries (Consul 1.16+)
+
+  If -webhook-listen is set, the command also serves the admission
+  webhooks of these resources. The validating webhooks on
+  /validate/<resource> reject the resources that Consul would reject:
+  invalid resources, resources that would write the same config entry as
+  another resource, and routers, splitters and resolvers referencing
+  subsets that the ServiceResolver resources, or the service-resolvers in
+  Consul for the services without one, don't define. They check the
+  resources against caches of all the resources of their kinds, and are
+  only served once the caches have synced. The
+  defaulting webhook of IngressGateway on /mutate/ingressgateways sets
+  the default listener protocol. The conversion webhook on /convert
+  converts the resources between the v1alpha1 and v1beta1 versions of the
+  API group. The CRDs store v1beta1, so it must be served for the API
+  server to read the resources.
+
+  It also runs the controllers of the PeeringAcceptor and PeeringDialer
+  resources (Consul 1.13+), which make cluster peering declarative. An
+  acceptor generates a peering token for the peer of its name and stores
+  it in the Kubernetes secret of its spec. The secret is copied to the
+  other cluster, whose dialer establishes the peering with it. Their
+  status reports the state of the peering.
+
+  When the servers of the clusters can't reach each other, the peering
+  traffic goes through the mesh gateways (Consul 1.14+): set
+  spec.peering.peerThroughMeshGateways on the Mesh resource of both
+  clusters, and the peering tokens hold the addresses of the mesh
+  gateways, which expose the gRPC port of the servers to the peer. Tokens
+  aren't generated and peerings aren't established until an instance of
+  -mesh-gateway-service-name is healthy. Alternatively, an acceptor's
+  spec.serverExternalAddresses, e.g. a load balancer in front of the
+  servers, replace the addresses in its token.
+
+  Each replica of the mesh gateways registers an instance of the service
+  with the agent of its node and deregisters it when it stops. If
+  -enable-mesh-gateway-janitor is set, the instances of the pods that
+  disappeared without deregistering them, e.g. because their node failed,
+  are deregistered when a pod matching -mesh-gateway-pod-selector is
+  deleted and every -resync-period. Only the instances registered by
+  register-mesh-gateway with -pod-name are cleaned up.
+
+  The controller of the Registration resource registers services running
+  outside of Kubernetes, e.g. databases on VMs or SaaS endpoints, in the
+  Consul catalog on the node of its spec. Since no Consul agent runs on
+  these nodes, the controller runs the HTTP and TCP checks of the
+  resources every -registration-check-interval and updates their status
+  in the catalog. If spec.terminatingGateway is set, the GatewayLinked
+  condition reports whether the terminating gateway links the service.
+  Deleting the resource deregisters the service, and its node once it
+  has no services left.
+
+  If -enable-gateway-controller is set, Gateways of the Kubernetes
+  Gateway API whose GatewayClass has the controllerName
+  consul.hashicorp.com/gateway-controller are provisioned as Consul API
+  gateways (Consul 1.15+): a Deployment of -gateway-envoy-image
+  registered in Consul and a LoadBalancer Service, and an api-gateway
+  config entry with the listeners of the Gateway. The certificates of
+  HTTPS listeners are read from Secrets in the namespace of the Gateway
+  and written to inline-certificate config entries, which are written
+  again as soon as the secrets change, e.g. when cert-manager renews the
+  certificates, so that the gateways use them without restarting.
+  Listeners added to a Gateway are served without restarting its pods
+  either, only the ports of its Service are updated.
+  HTTPRoutes and TCPRoutes attached to these gateways are written to
+  http-route and tcp-route config entries, routing to the Consul services
+  named after their backend Services. Backend Services of other
+  namespaces must be permitted by a ReferenceGrant of their namespace,
+  and listeners can allow the routes of the namespaces matching a label
+  selector. The config entries are named <name>-<namespace>. Consul
+  namespaces, admin partitions and ACLs aren't supported by the gateway
+  controllers yet.
+  The parametersRef of a GatewayClass can reference a cluster-scoped
+  GatewayClassConfig resource, which sets the replicas, resources, node
+  selector, tolerations, affinity and priority class of the gateway
+  deployments of the class, the type of their services, and the
+  annotations of the Gateways copied to the services. If it enables
+  autoscaling, each gateway also gets a HorizontalPodAutoscaler
+  (autoscaling/v2beta1), scaling it between the min and max replicas on
+  the CPU utilization or the configured metrics.
+  The extensionRef filters of the HTTPRoute rules can reference
+  RouteTimeoutFilter, RouteRetryFilter and RouteAuthFilter resources in the
+  namespace of the route to set the timeouts of the requests, retry them,
+  or require a JWT of jwt-provider config entries. The timeout and retry
+  filters require Consul 1.16 or later, the auth filter Consul Enterprise
+  1.17 or later.
+  RateLimitPolicy resources attached to a Gateway or to an HTTPRoute of
+  their namespace by their targetRef limit the requests of the gateway, or
+  of the paths of the route's rules, and are written to the
+  service-defaults config entry of the gateway, which requires Consul 1.16
+  or later. Each gateway or route is limited by its oldest policy.
+
+  All the controllers run in this single process. If
+  -enable-leader-election is set, they only run in the replica holding
+  -leader-election-id in -leader-election-namespace, a ConfigMap by default
+  or a Lease if -leader-election-lock-type is "leases", as its
+  leader lock, and the other replicas only serve the webhooks until they
+  acquire it. A replica that loses the lock exits. -health-probe-listen
+  serves the liveness probe on /live and the readiness probe on /ready,
+  which fails until the caches of the running controllers have synced.
+  /healthz and /readyz are their aliases. -metrics-listen serves
+  Prometheus metrics on /metrics, and the pprof profiles on /debug/pprof/
+  if -enable-pprof is set. If -tracing-otlp-endpoint is set, the
+  reconciles, the webhook requests and their Consul API requests are
+  traced and exported to an OpenTelemetry collector with OTLP over HTTP.
+
+  Config entries that already exist in Consul when a resource is first
+  synced, e.g. entries created with the Consul CLI, aren't overwritten
+  unless the resource has the annotation
+  consul.hashicorp.com/migrate-entry: "true". Other resources report the
+  conflict in their Synced condition.
+
+  Deleting a resource deletes its config entry from Consul unless
+  -orphan-config-entries is set. The controllers add a finalizer to the
+  resources so that they're only removed once Consul has deleted the
+  entry. Entries the controllers didn't write are never deleted.
+
+  The result of syncing a resource is reported in its Synced condition,
+  and errors are also recorded as events on the resource. The status of
+  config entry resources also has the last time they were written to
+  Consul and the Consul namespace and partition of their config entry.
+
+  Config entries are written to the Consul namespace given by the
+  namespace flags, e.g. the mirrored namespace of the resource, and to the
+  admin partition of -partition. The resources of a Kubernetes namespace
+  can target another Consul namespace or partition with spec.namespace or
+  spec.partition only if -allow-consul-namespace-target or
+  -allow-consul-partition-target allows it, so that a single cluster can
+  manage the config entries of several namespaces and partitions without
+  letting every namespace write to all of them. The ExportedServices
+  resource of a partition is named after the partition.
+  The LinkedServicesResolved condition of TerminatingGateway lists the
+  linked services that aren't registered in Consul.
+
+  If -enable-ingress-gateway-services is set, each IngressGateway gets a
+  service of the same name exposing a port named <protocol>-<port> for
+  each of its listeners, selecting the pods with the label
+  ingress-gateway-name=<name>. The ports follow the listeners, and the
+  service is deleted with the resource. Its type is
+  -ingress-gateway-service-type unless the resource has the
+  consul.hashicorp.com/ingress-gateway-service-type annotation.
+
+  If -enable-namespace-provisioning is set with namespace mirroring, the
+  mirrored Consul namespace of each Kubernetes namespace is created as
+  soon as the Kubernetes namespace is, rather than when its first service
+  or config entry is written, so that intentions and config entries can
+  be applied to it before its workloads start. The namespaces get
+  -consul-cross-namespace-acl-policy and the policies and roles of
+  -consul-namespace-default-policy and -consul-namespace-default-role as
+  their ACL defaults, which are also added to the namespaces that other
+  components created from Kubernetes. Namespaces created outside of
+  Kubernetes aren't changed, and Consul namespaces aren't deleted with
+  their Kubernetes namespace. The system namespaces aren't mirrored.
+
+`
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{"foo"},
+			ExpErr: "Should have no non-flag arguments.",
+		},
+		{
+			Flags:  []string{"-log-level", "invalid"},
+			ExpErr: "Unknown log level: invalid",
+		},
+		{
+			Flags:  []string{"-consul-write-qps", "5", "-consul-write-burst", "0"},
+			ExpErr: "-consul-write-burst must be at least 1 if -consul-write-qps is set",
+		},
+		{
+			Flags:  []string{"-webhook-listen", ":8080", "-webhook-tls-cert-file", "cert.pem"},
+			ExpErr: "-webhook-tls-cert-file and -webhook-tls-key-file must be set if -webhook-listen is set",
+		},
+		{
+			Flags:  []string{"-allow-consul-namespace-target", "team-a"},
+			ExpErr: `Invalid -allow-consul-namespace-target: "team-a" must be of the form <kubernetes namespace>=<consul namespace or partition>`,
+		},
+		{
+			Flags:  []string{"-allow-consul-partition-target", "=ap1"},
+			ExpErr: `Invalid -allow-consul-partition-target: "=ap1" must be of the form <kubernetes namespace>=<consul namespace or partition>`,
+		},
+		{
+			Flags:  []string{"-enable-leader-election"},
+			ExpErr: "-leader-election-namespace must be set if -enable-leader-election is set",
+		},
+		{
+			Flags:  []string{"-enable-leader-election", "-leader-election-namespace", "default", "-leader-election-lock-type", "endpoints"},
+			ExpErr: `-leader-election-lock-type must be "leases" or "configmaps"`,
+		},
+		{
+			Flags:  []string{"-enable-pprof"},
+			ExpErr: "-metrics-listen must be set if -enable-pprof is set",
+		},
+		{
+			Flags:  []string{"-enable-mesh-gateway-janitor", "-mesh-gateway-service-name", ""},
+			ExpErr: "-mesh-gateway-service-name must be set if -enable-mesh-gateway-janitor is set",
+		},
+		{
+			Flags:  []string{"-enable-gateway-controller", "-enable-namespaces"},
+			ExpErr: "-enable-gateway-controller isn't supported with Consul namespaces or admin partitions",
+		},
+		{
+			Flags:  []string{"-enable-namespace-provisioning", "-enable-namespaces"},
+			ExpErr: "-enable-namespace-provisioning requires -enable-namespaces and -enable-k8s-namespace-mirroring",
+		},
+		{
+			Flags:  []string{"-ingress-gateway-service-type", "ExternalName"},
+			ExpErr: `-ingress-gateway-service-type must be one of "LoadBalancer", "NodePort" or "ClusterIP", not "ExternalName"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI: ui,
+			}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/leader"
+	"github.com/hashicorp/consul-k8s/helper/metrics"
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// runner is a controller run by the manager, e.g. a
+// *helpercontroller.Controller.
+type runner interface {
+	// Run runs the controller until stopCh is closed.
+	Run(stopCh <-chan struct{})
+	// HasSynced is true once the controller's cache has synced.
+	HasSynced() bool
+}
+
+// manager runs all the controllers of the command in a single process. If
+// leader election is enabled, they only run in the replica holding the
+// leader lock, so that the command can run with several replicas serving
+// the webhooks while a single one writes to Consul.
+type manager struct {
+	log hclog.Logger
+
+	// controllers are the controllers keyed by the resource they
+	// reconcile.
+	controllers map[string]runner
+
+	// leaseDuration, renewDeadline and retryPeriod configure the leader
+	// election. See leader.Config.
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	// leading is 1 once the controllers have been started.
+	leading int32
+	wg      sync.WaitGroup
+}
+
+// start starts the controllers. They run until stopCh is closed, and
+// doneCh receives a value whenever one of them exits.
+func (m *manager) start(stopCh <-chan struct{}, doneCh chan<- struct{}) {
+	atomic.StoreInt32(&m.leading, 1)
+	for _, ctl := range m.controllers {
+		ctl := ctl
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			ctl.Run(stopCh)
+			doneCh <- struct{}{}
+		}()
+	}
+}
+
+// startWithLeaderElection starts the controllers once lock is acquired.
+// They run until stopCh is closed or the lock is lost, in which case doneCh
+// receives a value so that the command exits rather than running next to
+// the new leader.
+func (m *manager) startWithLeaderElection(lock resourcelock.Interface, stopCh <-chan struct{}, doneCh chan<- struct{}) error {
+	return leader.Start(leader.Config{
+		Log:           m.log,
+		Lock:          lock,
+		LeaseDuration: m.leaseDuration,
+		RenewDeadline: m.renewDeadline,
+		RetryPeriod:   m.retryPeriod,
+		OnStartedLeading: func(leadingStopCh <-chan struct{}) {
+			m.log.Info("starting controllers")
+			m.start(leadingStopCh, doneCh)
+		},
+		OnStoppedLeading: func() {
+			doneCh <- struct{}{}
+		},
+	}, stopCh)
+}
+
+// wait blocks until all the controllers have exited.
+func (m *manager) wait() {
+	m.wg.Wait()
+}
+
+func (m *manager) isLeading() bool {
+	return atomic.LoadInt32(&m.leading) == 1
+}
+
+// healthHandler serves the liveness probe on /live and /healthz and the
+// readiness probe on /ready and /readyz. Replicas waiting for the leader
+// lock are ready since they serve the webhooks; the leader is ready once
+// the caches of all controllers have synced.
+func (m *manager) healthHandler() http.Handler {
+	live := func(rw http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(rw, "ok")
+	}
+	ready := func(rw http.ResponseWriter, req *http.Request) {
+		if unsynced := m.unsynced(); len(unsynced) > 0 {
+			http.Error(rw, "caches not synced: "+strings.Join(unsynced, ", "), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(rw, "ok")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live", live)
+	mux.HandleFunc("/healthz", live)
+	mux.HandleFunc("/ready", ready)
+	mux.HandleFunc("/readyz", ready)
+	return mux
+}
+
+// unsynced returns the sorted resources of the controllers whose cache
+// hasn't synced yet, if the controllers are running.
+func (m *manager) unsynced() []string {
+	if !m.isLeading() {
+		return nil
+	}
+	var unsynced []string
+	for resource, ctl := range m.controllers {
+		if !ctl.HasSynced() {
+			unsynced = append(unsynced, resource)
+		}
+	}
+	sort.Strings(unsynced)
+	return unsynced
+}
+
+// metricsHandler serves the Prometheus metrics of the process on
+// /metrics: whether it's the leader, whether the cache of each controller
+// has synced, and the shared metrics of the reconciles and Consul API
+// requests.
+func (m *manager) metricsHandler() (http.Handler, error) {
+	collectors := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "consul_k8s_controller_leader",
+			Help: "Whether this replica runs the controllers: 1 if it holds the leader lock or leader election is disabled.",
+		}, func() float64 { return boolGauge(m.isLeading()) }),
+	}
+	for resource, ctl := range m.controllers {
+		ctl := ctl
+		collectors = append(collectors, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "consul_k8s_controller_cache_synced",
+			Help:        "Whether the cache of the controller of the resource has synced.",
+			ConstLabels: prometheus.Labels{"resource": resource},
+		}, func() float64 { return boolGauge(ctl.HasSynced()) }))
+	}
+	return metrics.Handler(collectors...)
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
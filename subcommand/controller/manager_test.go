@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+// stubRunner is a runner recording whether it runs.
+type stubRunner struct {
+	synced  int32
+	running int32
+}
+
+func (r *stubRunner) Run(stopCh <-chan struct{}) {
+	atomic.StoreInt32(&r.running, 1)
+	<-stopCh
+	atomic.StoreInt32(&r.running, 0)
+}
+
+func (r *stubRunner) HasSynced() bool { return atomic.LoadInt32(&r.synced) == 1 }
+
+func (r *stubRunner) isRunning() bool { return atomic.LoadInt32(&r.running) == 1 }
+
+func TestManager_Readiness(t *testing.T) {
+	synced, unsynced := &stubRunner{synced: 1}, &stubRunner{}
+	mgr := &manager{
+		log:         hclog.NewNullLogger(),
+		controllers: map[string]runner{"mesh": synced, "servicedefaults": unsynced},
+	}
+	handler := mgr.healthHandler()
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	// Replicas waiting for the leader lock are ready.
+	require.Equal(t, http.StatusOK, get("/readyz").Code)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	mgr.start(stopCh, make(chan struct{}, 2))
+	rec := get("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "caches not synced: servicedefaults\n", rec.Body.String())
+	require.Equal(t, http.StatusServiceUnavailable, get("/ready").Code)
+	require.Equal(t, http.StatusOK, get("/healthz").Code)
+	require.Equal(t, http.StatusOK, get("/live").Code)
+
+	atomic.StoreInt32(&unsynced.synced, 1)
+	require.Equal(t, http.StatusOK, get("/readyz").Code)
+	require.Equal(t, http.StatusOK, get("/ready").Code)
+}
+
+func TestManager_Metrics(t *testing.T) {
+	mgr := &manager{
+		log:         hclog.NewNullLogger(),
+		controllers: map[string]runner{"mesh": &stubRunner{synced: 1}, "servicedefaults": &stubRunner{}},
+	}
+	handler, err := mgr.metricsHandler()
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	require.Contains(t, body, "consul_k8s_controller_leader 0\n")
+	require.Contains(t, body, `consul_k8s_controller_cache_synced{resource="mesh"} 1`+"\n")
+	require.Contains(t, body, `consul_k8s_controller_cache_synced{resource="servicedefaults"} 0`+"\n")
+	require.Contains(t, body, "go_goroutines")
+}
+
+func TestManager_LeaderElection(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	newManager := func(identity string) (*manager, *stubRunner) {
+		ctl := &stubRunner{}
+		mgr := &manager{
+			log:           hclog.NewNullLogger(),
+			controllers:   map[string]runner{"mesh": ctl},
+			leaseDuration: 2 * time.Second,
+			renewDeadline: time.Second,
+			retryPeriod:   100 * time.Millisecond,
+		}
+		lock, err := resourcelock.New(resourcelock.ConfigMapsResourceLock, "default", "consul-k8s-controller-leader",
+			kubeClient.CoreV1(), kubeClient.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity, EventRecorder: record.NewFakeRecorder(10)})
+		require.NoError(t, err)
+		stopCh := make(chan struct{})
+		t.Cleanup(func() { close(stopCh) })
+		require.NoError(t, mgr.startWithLeaderElection(lock, stopCh, make(chan struct{}, 2)))
+		return mgr, ctl
+	}
+
+	leader, leaderCtl := newManager("replica-1")
+	require.Eventually(t, leaderCtl.isRunning, 5*time.Second, 50*time.Millisecond)
+	require.True(t, leader.isLeading())
+
+	// The controllers of the other replica don't run while the lock is held.
+	standby, standbyCtl := newManager("replica-2")
+	time.Sleep(500 * time.Millisecond)
+	require.False(t, standby.isLeading())
+	require.False(t, standbyCtl.isRunning())
+
+	lock, err := kubeClient.CoreV1().ConfigMaps("default").Get("consul-k8s-controller-leader", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Contains(t, lock.Annotations[resourcelock.LeaderElectionRecordAnnotationKey], `"holderIdentity":"replica-1"`)
+}
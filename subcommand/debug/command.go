@@ -0,0 +1,409 @@
+package debug
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/envoy"
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command collects the state of the Consul installation into a tarball for
+// support cases.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagOutput        string
+	flagProxies       []string
+	flagSince         time.Duration
+	flagDuration      time.Duration
+	flagInterval      time.Duration
+	flagKubectlBinary string
+
+	helm          *helm.Client
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+	forward       portforward.Forwarder
+	// logs returns the logs of container of the pod name in namespace. It
+	// defaults to reading them with kubeClient and is only set by tests,
+	// since the fake client can't read logs.
+	logs func(namespace, name, container string, since time.Duration) ([]byte, error)
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagOutput, "output", "",
+		"Path of the tarball. Defaults to consul-k8s-debug-<timestamp>.tar.gz in the current directory.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagProxies), "proxy",
+		"Injected pod to collect the Envoy config dump and metrics of, as <namespace>/<pod> or <pod> for the "+
+			"pods of the namespace of the release. May be specified multiple times.")
+	c.flags.DurationVar(&c.flagSince, "since", time.Hour,
+		"How far back to collect the logs of the Consul pods.")
+	c.flags.DurationVar(&c.flagDuration, "duration", 0,
+		"How long to capture snapshots of the metrics of the -proxy pods for. Defaults to a single snapshot.")
+	c.flags.DurationVar(&c.flagInterval, "interval", 30*time.Second,
+		"How often to capture snapshots of the metrics during -duration.")
+	c.flags.StringVar(&c.flagKubectlBinary, "kubectl", "kubectl",
+		"Path of the kubectl binary, used to port-forward to the Envoy admin API.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagDuration > 0 && c.flagInterval <= 0 {
+		c.UI.Error("-interval must be positive")
+		return 1
+	}
+	if c.flagOutput == "" {
+		c.flagOutput = fmt.Sprintf("consul-k8s-debug-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+	}
+
+	if c.kubeClient == nil || c.dynamicClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		if c.kubeClient == nil {
+			c.kubeClient, err = kubernetes.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+		if c.dynamicClient == nil {
+			c.dynamicClient, err = dynamic.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+	}
+	if c.helm == nil {
+		c.helm = &helm.Client{KubeConfig: c.k8s.KubeConfig()}
+	}
+	if c.forward == nil {
+		c.forward = portforward.Kubectl(c.flagKubectlBinary, c.k8s.KubeConfig())
+	}
+	if c.logs == nil {
+		c.logs = func(namespace, name, container string, since time.Duration) ([]byte, error) {
+			seconds := int64(since.Seconds())
+			return c.kubeClient.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{
+				Container:    container,
+				SinceSeconds: &seconds,
+			}).Do().Raw()
+		}
+	}
+
+	release, err := c.helm.Release()
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+
+	file, err := os.Create(c.flagOutput)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error creating the tarball: %s", err))
+		return 1
+	}
+	defer file.Close()
+	b := newBundle(file)
+
+	c.UI.Output(fmt.Sprintf("==> Collecting release %q in namespace %q", release.Name, release.Namespace))
+	c.collectRelease(b, release)
+	c.UI.Output("==> Collecting the pods and their logs")
+	c.collectPods(b, release)
+	c.UI.Output("==> Collecting the custom resources")
+	c.collectCustomResources(b)
+	if len(c.flagProxies) > 0 {
+		c.UI.Output("==> Collecting the Envoy sidecars")
+		c.collectProxies(b, release.Namespace)
+	}
+
+	if len(b.errors) > 0 {
+		b.add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n"))
+	}
+	if err := b.close(); err != nil {
+		c.UI.Error(fmt.Sprintf("Error writing the tarball: %s", err))
+		return 1
+	}
+	for _, e := range b.errors {
+		c.UI.Error(" ✗ " + e)
+	}
+	c.UI.Output(fmt.Sprintf("\nCollected %d files into %s. Secrets were redacted, but review the tarball before sharing it.",
+		b.files, c.flagOutput))
+	return 0
+}
+
+func (c *Command) collectRelease(b *bundle, release helm.Release) {
+	b.addJSON("helm/release.json", release)
+	details, err := c.helm.Get(release.Name, release.Namespace)
+	if err != nil {
+		b.errorf("getting the release: %s", err)
+		return
+	}
+	b.addJSON("helm/values.json", details.Config)
+}
+
+// collectPods collects the pods of the release with their status, the
+// logs of their containers, and the events of the namespace.
+func (c *Command) collectPods(b *bundle, release helm.Release) {
+	core := c.kubeClient.CoreV1()
+	pods, err := core.Pods(release.Namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=consul,release=%s", release.Name),
+	})
+	if err != nil {
+		b.errorf("listing the pods: %s", err)
+		return
+	}
+	b.addJSON("pods.json", pods)
+	for _, pod := range pods.Items {
+		containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for _, container := range containers {
+			logs, err := c.logs(pod.Namespace, pod.Name, container.Name, c.flagSince)
+			if err != nil {
+				b.errorf("reading the logs of pod %s, container %s: %s", pod.Name, container.Name, err)
+				continue
+			}
+			b.add(path.Join("logs", pod.Name, container.Name+".log"), logs)
+		}
+	}
+
+	events, err := core.Events(release.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		b.errorf("listing the events: %s", err)
+		return
+	}
+	b.addJSON("events.json", events)
+}
+
+// collectCustomResources collects the resources of the consul.hashicorp.com
+// CRDs, whose status is the state of their config entries in Consul.
+func (c *Command) collectCustomResources(b *bundle) {
+	crds, err := c.dynamicClient.Resource(helm.CRDResource).List(metav1.ListOptions{})
+	if err != nil {
+		b.errorf("listing the CRDs: %s", err)
+		return
+	}
+	for _, crd := range crds.Items {
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		if group != helm.CRDGroup {
+			continue
+		}
+		plural, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "plural")
+		version, _, _ := unstructured.NestedString(crd.Object, "spec", "version")
+		if versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions"); version == "" && len(versions) > 0 {
+			if v, ok := versions[0].(map[string]interface{}); ok {
+				version, _ = v["name"].(string)
+			}
+		}
+		resources, err := c.dynamicClient.Resource(schema.GroupVersionResource{
+			Group:    group,
+			Version:  version,
+			Resource: plural,
+		}).List(metav1.ListOptions{})
+		if err != nil {
+			b.errorf("listing the %s: %s", plural, err)
+			continue
+		}
+		b.addJSON(path.Join("crds", plural+".json"), resources)
+	}
+}
+
+// proxy is an injected pod whose Envoy sidecar is collected.
+type proxy struct {
+	namespace, name string
+	admin           *envoy.Admin
+}
+
+// collectProxies collects the config dumps of the -proxy pods, and
+// snapshots of their metrics every -interval during -duration.
+func (c *Command) collectProxies(b *bundle, defaultNamespace string) {
+	var proxies []proxy
+	for _, flag := range c.flagProxies {
+		p := proxy{namespace: defaultNamespace, name: flag}
+		if i := strings.Index(flag, "/"); i >= 0 {
+			p.namespace, p.name = flag[:i], flag[i+1:]
+		}
+		addr, closeForward, err := c.forward(p.namespace, p.name, portforward.EnvoyAdminPort)
+		if err != nil {
+			b.errorf("connecting to the Envoy admin API of pod %s/%s: %s", p.namespace, p.name, err)
+			continue
+		}
+		defer closeForward()
+		p.admin = &envoy.Admin{Addr: addr}
+
+		dump, err := p.admin.ConfigDump()
+		if err != nil {
+			b.errorf("reading the config dump of pod %s/%s: %s", p.namespace, p.name, err)
+			continue
+		}
+		b.add(path.Join("envoy", p.namespace, p.name, "config_dump.json"), dump)
+		proxies = append(proxies, p)
+	}
+	if len(proxies) == 0 {
+		return
+	}
+
+	if c.flagDuration > 0 {
+		c.UI.Output(fmt.Sprintf("    Capturing metrics every %s for %s", c.flagInterval, c.flagDuration))
+	}
+	deadline := time.Now().Add(c.flagDuration)
+	for snapshot := 0; ; snapshot++ {
+		timestamp := time.Now().UTC().Format("20060102T150405Z")
+		for _, p := range proxies {
+			metrics, err := p.admin.Metrics()
+			if err != nil {
+				b.errorf("reading the metrics of pod %s/%s: %s", p.namespace, p.name, err)
+				continue
+			}
+			b.add(path.Join("envoy", p.namespace, p.name, "metrics", fmt.Sprintf("%03d-%s.prom", snapshot, timestamp)), metrics)
+		}
+		if time.Now().Add(c.flagInterval).After(deadline) {
+			return
+		}
+		time.Sleep(c.flagInterval)
+	}
+}
+
+// bundle writes the files of the tarball, redacting their secrets.
+type bundle struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+	// files is the number of files written.
+	files int
+	// errors are the errors collecting the files, written to errors.txt so
+	// that the bundle is still useful when some files can't be collected.
+	errors []string
+}
+
+func newBundle(file *os.File) *bundle {
+	gz := gzip.NewWriter(file)
+	return &bundle{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (b *bundle) errorf(format string, args ...interface{}) {
+	b.errors = append(b.errors, fmt.Sprintf(format, args...))
+}
+
+func (b *bundle) add(name string, data []byte) {
+	data = redact(data)
+	err := b.tw.WriteHeader(&tar.Header{
+		Name:    path.Join("consul-k8s-debug", name),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err == nil {
+		_, err = b.tw.Write(data)
+	}
+	if err != nil {
+		b.errorf("writing %s: %s", name, err)
+		return
+	}
+	b.files++
+}
+
+func (b *bundle) addJSON(name string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.errorf("encoding %s: %s", name, err)
+		return
+	}
+	b.add(name, data)
+}
+
+func (b *bundle) close() error {
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	return b.gz.Close()
+}
+
+var (
+	// secretFields match the JSON fields holding secrets, e.g. the ACL
+	// tokens and gossip keys of the extraConfig values or the private keys
+	// of the config dumps.
+	secretFields = regexp.MustCompile(`(?i)("[\w.-]*(token|password|secret_?id|encrypt|private_?key|license)"\s*:\s*)"[^"]*"`)
+	// secretArgs match the secrets of flags and environment variables in
+	// logs and pod specs, e.g. -token=... or CONSUL_HTTP_TOKEN=....
+	secretArgs = regexp.MustCompile(`(?i)\b([\w-]*(token|password|encrypt))=[^\s"]+`)
+)
+
+// redact replaces the secrets of data with "<redacted>".
+func redact(data []byte) []byte {
+	data = secretFields.ReplaceAll(data, []byte(`$1"<redacted>"`))
+	return secretArgs.ReplaceAll(data, []byte(`$1=<redacted>`))
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Collect a debug bundle of Consul on Kubernetes"
+const help = `
+Usage: consul-k8s debug [options]
+
+  Collects the state of the installation of the Consul Helm chart into a
+  tarball for support cases:
+
+    - the Helm release and its values
+    - the Consul pods, the logs of their containers during -since, and the
+      events of their namespace
+    - the consul.hashicorp.com custom resources, whose status is the state
+      of their config entries in Consul
+    - the Envoy config dumps and metrics of the -proxy pods, read with
+      kubectl port-forward. The metrics are captured every -interval
+      during -duration, or once if -duration isn't set
+
+  The ACL tokens, passwords, gossip keys and private keys found in the
+  files are redacted. Kubernetes secrets are never collected.
+
+      $ consul-k8s debug -proxy web/web-6d4f8c9b7-xk2lp -duration 5m -interval 30s
+
+`
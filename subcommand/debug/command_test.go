@@ -0,0 +1,221 @@
+package debug
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{"foo"},
+			expErr: "Should have no non-flag arguments.",
+		},
+		{
+			flags:  []string{"-duration", "1m", "-interval", "0s"},
+			expErr: "-interval must be positive",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.flags))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun_Bundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debug")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "bundle.tar.gz")
+
+	envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config_dump":
+			fmt.Fprint(w, `{"configs":[{"@type":"type.googleapis.com/envoy.admin.v3.SecretsConfigDump","private_key":"abc"}]}`)
+		case "/stats/prometheus":
+			fmt.Fprint(w, "envoy_cluster_upstream_cx_active{envoy_cluster_name=\"db\"} 1\n")
+		}
+	}))
+	defer envoy.Close()
+
+	client, err := helm.NewTestClient(nil, helm.TestRelease{
+		Name:      "consul",
+		Namespace: "mesh",
+		Chart:     "consul-0.24.1",
+		Values:    `{"server":{"extraConfig":"{}"},"global":{"acls":{"bootstrapToken":{"secretName":"token"}}},"replicationToken":"secret"}`,
+	})
+	require.NoError(t, err)
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+		kubeClient: fake.NewSimpleClientset(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "consul-server-0",
+				Namespace: "mesh",
+				Labels:    map[string]string{"app": "consul", "release": "consul"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "consul"}, {Name: "broken"}}},
+		}),
+		dynamicClient: &fakeDynamicClient{resources: map[string][]unstructured.Unstructured{
+			"customresourcedefinitions": {
+				crd("servicedefaults", helm.CRDGroup),
+				crd("certificates", "cert-manager.io"),
+			},
+			"servicedefaults": {{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "web"},
+				"status":   map[string]interface{}{"conditions": []interface{}{map[string]interface{}{"type": "Synced", "status": "True"}}},
+			}}},
+		}},
+		helm: client,
+		forward: func(namespace, name string, remotePort int) (string, func(), error) {
+			if name == "missing" {
+				return "", nil, errors.New("pod not found")
+			}
+			require.Equal(t, "web", namespace)
+			return strings.TrimPrefix(envoy.URL, "http://"), func() {}, nil
+		},
+		logs: func(namespace, name, container string, since time.Duration) ([]byte, error) {
+			require.Equal(t, time.Hour, since)
+			if container == "broken" {
+				return nil, errors.New("container is waiting to start")
+			}
+			return []byte("agent: started -token=abcd CONSUL_HTTP_TOKEN=efgh\n"), nil
+		},
+	}
+
+	flags := []string{"-output", output, "-proxy", "web/web-0", "-proxy", "missing", "-duration", "50ms", "-interval", "20ms"}
+	require.Equal(t, 0, cmd.Run(flags), ui.ErrorWriter.String())
+	require.Contains(t, ui.ErrorWriter.String(), " ✗ reading the logs of pod consul-server-0, container broken: container is waiting to start")
+	require.Contains(t, ui.ErrorWriter.String(), " ✗ connecting to the Envoy admin API of pod mesh/missing: pod not found")
+
+	files := readTarball(t, output)
+	var names []string
+	var snapshots int
+	for name := range files {
+		// The number of snapshots depends on the timing of the test.
+		if strings.Contains(name, "/metrics/") {
+			snapshots++
+			continue
+		}
+		names = append(names, name)
+	}
+	require.True(t, snapshots >= 2, "%d snapshots", snapshots)
+	sort.Strings(names)
+	require.Equal(t, []string{
+		"consul-k8s-debug/crds/servicedefaults.json",
+		"consul-k8s-debug/envoy/web/web-0/config_dump.json",
+		"consul-k8s-debug/errors.txt",
+		"consul-k8s-debug/events.json",
+		"consul-k8s-debug/helm/release.json",
+		"consul-k8s-debug/helm/values.json",
+		"consul-k8s-debug/logs/consul-server-0/consul.log",
+		"consul-k8s-debug/pods.json",
+	}, names)
+
+	require.Equal(t, "agent: started -token=<redacted> CONSUL_HTTP_TOKEN=<redacted>\n", files["consul-k8s-debug/logs/consul-server-0/consul.log"])
+	require.Contains(t, files["consul-k8s-debug/helm/values.json"], `"replicationToken": "<redacted>"`)
+	require.Contains(t, files["consul-k8s-debug/helm/values.json"], `"secretName": "token"`)
+	require.Contains(t, files["consul-k8s-debug/envoy/web/web-0/config_dump.json"], `"private_key":"<redacted>"`)
+	require.Contains(t, files["consul-k8s-debug/crds/servicedefaults.json"], `"type": "Synced"`)
+	require.Contains(t, files["consul-k8s-debug/envoy/web/web-0/metrics/000"], "envoy_cluster_upstream_cx_active")
+}
+
+func TestRedact(t *testing.T) {
+	cases := map[string]string{
+		`{"acl": {"tokens": {"agent": "secret"}}}`:   `{"acl": {"tokens": {"agent": "secret"}}}`,
+		`{"agent_token": "secret", "name": "web"}`:   `{"agent_token": "<redacted>", "name": "web"}`,
+		`{"SecretID":"secret","AccessorID":"id"}`:    `{"SecretID":"<redacted>","AccessorID":"id"}`,
+		`{"encrypt": "key", "private_key": "key"}`:   `{"encrypt": "<redacted>", "private_key": "<redacted>"}`,
+		`consul-k8s acl-init -token-file=/consul/x`:  `consul-k8s acl-init -token-file=/consul/x`,
+		`-encrypt=key -acl-token=secret -log=debug`:  `-encrypt=<redacted> -acl-token=<redacted> -log=debug`,
+		`{"enterpriseLicense": {"secretName": "l"}}`: `{"enterpriseLicense": {"secretName": "l"}}`,
+	}
+	for in, exp := range cases {
+		require.Equal(t, exp, string(redact([]byte(in))), in)
+	}
+}
+
+// readTarball returns the contents of the files of the tarball path. The
+// timestamps of the metrics snapshots are trimmed from their names.
+func readTarball(t *testing.T, path string) map[string]string {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		name := header.Name
+		if i := strings.Index(name, "/metrics/"); i >= 0 {
+			name = name[:i+len("/metrics/")+3]
+		}
+		files[name] = string(data)
+	}
+	return files
+}
+
+func crd(plural, group string) unstructured.Unstructured {
+	return unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": plural + "." + group},
+		"spec": map[string]interface{}{
+			"group":    group,
+			"names":    map[string]interface{}{"plural": plural},
+			"versions": []interface{}{map[string]interface{}{"name": "v1alpha1"}},
+		},
+	}}
+}
+
+// fakeDynamicClient is a dynamic client listing resources. The fake dynamic
+// client of client-go can't list resources.
+type fakeDynamicClient struct {
+	dynamic.Interface
+	resources map[string][]unstructured.Unstructured
+}
+
+func (f *fakeDynamicClient) Resource(resource schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &fakeResource{items: f.resources[resource.Resource]}
+}
+
+type fakeResource struct {
+	dynamic.NamespaceableResourceInterface
+	items []unstructured.Unstructured
+}
+
+func (f *fakeResource) List(opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	return &unstructured.UnstructuredList{Items: f.items}, nil
+}
@@ -7,7 +7,6 @@ import (
 	"github.com/hashicorp/consul-k8s/subcommand"
 	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/command/flags"
-	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	v1 "k8s.io/api/batch/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
@@ -15,18 +14,27 @@ import (
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 )
 
-// Command is the command for deleting completed jobs.
+// Command is the command for deleting completed jobs, or with -selector the
+// finished resources whose TTL has expired.
 type Command struct {
 	UI cli.Ui
 
 	flags         *flag.FlagSet
+	configFile    *k8sflags.ConfigFileFlags
 	k8s           *k8sflags.K8SFlags
+	logging       *k8sflags.LogFlags
 	flagNamespace string
 	flagTimeout   string
+	flagSelector  string
+	flagKinds     string
+	flagTTL       string
+	flagDryRun    bool
 
 	once      sync.Once
 	help      string
@@ -34,6 +42,9 @@ type Command struct {
 
 	// retryDuration is how often we'll retry deletion.
 	retryDuration time.Duration
+
+	// sigCh receives a signal when the command should stop.
+	sigCh chan os.Signal
 }
 
 func (c *Command) init() {
@@ -44,17 +55,40 @@ func (c *Command) init() {
 		"Name of Kubernetes namespace where the job is deployed")
 	c.flags.StringVar(&c.flagTimeout, "timeout", "30m",
 		"How long we'll wait for the job to complete before timing out, e.g. 1ms, 2s, 3m")
+	c.flags.StringVar(&c.flagSelector, "selector", "",
+		"Label selector of the resources to delete once their -ttl has expired, instead of the job "+
+			"given as argument, e.g. app=consul,component=server-acl-init.")
+	c.flags.StringVar(&c.flagKinds, "kinds", kindJobs,
+		fmt.Sprintf("Comma-separated kinds of the resources deleted with -selector: %q, %q or %q.",
+			kindJobs, kindSecrets, kindPods))
+	c.flags.StringVar(&c.flagTTL, "ttl", "24h",
+		"How long the resources matching -selector are kept once finished: after completing or "+
+			"failing for jobs and pods, and after being created for secrets, e.g. 30m, 12h.")
+	c.flags.BoolVar(&c.flagDryRun, "dry-run", false,
+		"If true, the resources matching -selector that would be deleted are output but not deleted.")
 	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
 	c.help = flags.Usage(help, c.flags)
 
 	// Default retry to 1s. This is exposed for setting in tests.
 	if c.retryDuration == 0 {
 		c.retryDuration = 1 * time.Second
 	}
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
 }
 
 // Run will attempt to delete the job once it succeeds. If the job hits its
-// backoff limit, it will give up deleting it.
+// backoff limit, it will give up deleting it. With -selector, it instead
+// deletes the matching resources whose TTL has expired and exits.
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
 
@@ -62,22 +96,47 @@ func (c *Command) Run(args []string) int {
 	if err := c.flags.Parse(args); err != nil {
 		return 1
 	}
-	if len(c.flags.Args()) != 1 {
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if c.flagSelector != "" && len(c.flags.Args()) != 0 {
+		c.UI.Error("Must not have args if -selector is set.")
+		return 1
+	}
+	if c.flagSelector == "" && len(c.flags.Args()) != 1 {
 		c.UI.Error("Must have one arg: the job name to delete.")
 		return 1
 	}
-	jobName := c.flags.Args()[0]
 	if c.flagNamespace == "" {
 		c.UI.Error("Must set flag -k8s-namespace")
 		return 1
 	}
+	kinds, err := parseKinds(c.flagKinds)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Invalid -kinds: %s", err))
+		return 1
+	}
+	ttl, err := time.ParseDuration(c.flagTTL)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("%q is not a valid ttl: %s", c.flagTTL, err))
+		return 1
+	}
 	timeout, err := time.ParseDuration(c.flagTimeout)
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("%q is not a valid timeout: %s", c.flagTimeout, err))
 		return 1
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	// The context will only ever be intentionally ended by the timeout.
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	// The context is ended by the timeout or once the command is
+	// interrupted.
+	ctx, cancelSignal := subcommand.WithSignal(context.Background(), c.sigCh)
+	defer cancelSignal()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// c.k8sclient might already be set in a test.
@@ -95,17 +154,18 @@ func (c *Command) Run(args []string) int {
 		}
 	}
 
-	logger := hclog.New(&hclog.LoggerOptions{
-		Level:  hclog.Info,
-		Output: os.Stderr,
-	})
+	if c.flagSelector != "" {
+		return c.reap(logger.With("selector", c.flagSelector), kinds, ttl)
+	}
 
 	// Wait for job to complete.
-	logger.Info(fmt.Sprintf("waiting for job %q to complete successfully", jobName))
+	jobName := c.flags.Args()[0]
+	logger = logger.With("job", jobName)
+	logger.Info("waiting for job to complete successfully")
 	for {
 		job, err := c.k8sClient.BatchV1().Jobs(c.flagNamespace).Get(jobName, metav1.GetOptions{})
 		if k8serrors.IsNotFound(err) {
-			logger.Info(fmt.Sprintf("job %q does not exist, no need to delete", jobName))
+			logger.Info("job does not exist, no need to delete")
 			return 0
 		}
 		if err != nil {
@@ -121,26 +181,30 @@ func (c *Command) Run(args []string) int {
 		// If its reached its backoff limit then it will never complete.
 		for _, condition := range job.Status.Conditions {
 			if condition.Type == v1.JobFailed && condition.Reason == "BackoffLimitExceeded" {
-				logger.Warn(fmt.Sprintf("job %q has reached its backoff limit and will never complete", jobName))
+				logger.Warn("job has reached its backoff limit and will never complete")
 				return 1
 			}
 		}
 
-		logger.Info(fmt.Sprintf("job %q has not yet succeeded, waiting %v", jobName, c.retryDuration))
+		logger.Info("job has not yet succeeded, waiting", "retry", c.retryDuration)
 		// Wait on either the retry duration (in which case we continue) or the
 		// overall command timeout.
 		select {
 		case <-time.After(c.retryDuration):
 			continue
 		case <-ctx.Done():
-			logger.Warn(fmt.Sprintf("timeout %q has been reached, exiting without deleting job", timeout))
+			if ctx.Err() == context.Canceled {
+				logger.Warn("interrupted, exiting without deleting job")
+				return 1
+			}
+			logger.Warn("timeout has been reached, exiting without deleting job", "timeout", timeout)
 			return 1
 		}
 	}
 
 	// Here we know the job has succeeded. We can delete it and then delete
 	// ourselves.
-	logger.Info(fmt.Sprintf("job %q has succeeded, deleting", jobName))
+	logger.Info("job has succeeded, deleting")
 	propagationPolicy := metav1.DeletePropagationForeground
 	err = c.k8sClient.BatchV1().Jobs(c.flagNamespace).Delete(jobName, &metav1.DeleteOptions{
 		// Needed so that the underlying pods are also deleted.
@@ -151,7 +215,7 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
-	logger.Info(fmt.Sprintf("Deleted job %q successfully", jobName))
+	logger.Info("deleted job successfully")
 	return 0
 }
 
@@ -167,4 +231,9 @@ Usage: consul-k8s delete-completed-job [name] [options]
 
   Waits for job to complete, then deletes it. If the job reaches its
   backoff limit then the command will exit.
+
+  With -selector and no job name, deletes the resources of -kinds in
+  -k8s-namespace that match the label selector and have been finished for
+  longer than -ttl, e.g. leftover jobs and bootstrap secrets, then exits.
+  With -dry-run, the resources are output instead of deleted.
 `
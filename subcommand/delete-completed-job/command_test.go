@@ -4,10 +4,14 @@ import (
 	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/require"
 	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"math/rand"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -30,6 +34,18 @@ func TestRun_ArgValidation(t *testing.T) {
 			[]string{"-k8s-namespace=", "job-name"},
 			"Must set flag -k8s-namespace",
 		},
+		{
+			[]string{"-selector=app=consul", "job-name"},
+			"Must not have args if -selector is set.",
+		},
+		{
+			[]string{"-k8s-namespace=default", "-selector=app=consul", "-kinds=jobs,services"},
+			`Invalid -kinds: unknown kind "services", must be "jobs", "secrets" or "pods"`,
+		},
+		{
+			[]string{"-k8s-namespace=default", "-selector=app=consul", "-ttl=1d"},
+			`"1d" is not a valid ttl`,
+		},
 		{
 			[]string{"-k8s-namespace=default", "-timeout=10jd", "job-name"},
 			"\"10jd\" is not a valid timeout: time: unknown unit jd in duration 10jd",
@@ -228,8 +244,157 @@ func TestRun_Timeout(t *testing.T) {
 	case <-time.After(2 * time.Second):
 		require.FailNow("command did not exit after 2s")
 	}
+}
+
+// Test that the command exits without deleting the job once it's
+// interrupted.
+func TestRun_Interrupt(t *testing.T) {
+	t.Parallel()
+	require := require.New(t)
+	ns := "default"
+	jobName := "job"
+	k8s := fake.NewSimpleClientset()
+	_, err := k8s.BatchV1().Jobs(ns).Create(&batch.Job{
+		ObjectMeta: meta.ObjectMeta{
+			Name: jobName,
+		},
+		Status: batch.JobStatus{
+			Active: 1,
+		},
+	})
+	require.NoError(err)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:            ui,
+		k8sClient:     k8s,
+		retryDuration: 100 * time.Millisecond,
+	}
+	cmd.init()
+
+	done := make(chan int, 1)
+	go func() {
+		done <- cmd.Run([]string{"-k8s-namespace", ns, jobName})
+	}()
+	cmd.sigCh <- os.Interrupt
+	select {
+	case code := <-done:
+		require.Equal(1, code)
+	case <-time.After(2 * time.Second):
+		require.FailNow("command did not exit after interrupt")
+	}
+	_, err = k8s.BatchV1().Jobs(ns).Get(jobName, meta.GetOptions{})
+	require.NoError(err)
 
 	// The job should not have been deleted.
 	_, err = k8s.BatchV1().Jobs(ns).Get(jobName, meta.GetOptions{})
 	require.NoError(err)
 }
+
+// Test that with -selector the finished resources matching the selector
+// are deleted once their TTL has expired.
+func TestRun_Reap(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		Flags     []string
+		ExpDelete []string
+		ExpOutput []string
+	}{
+		"jobs": {
+			ExpDelete: []string{"job/succeeded", "job/failed"},
+		},
+		"all kinds": {
+			Flags:     []string{"-kinds=jobs,pods,secrets"},
+			ExpDelete: []string{"job/succeeded", "job/failed", "pod/succeeded", "secret/old"},
+		},
+		"longer ttl": {
+			Flags:     []string{"-kinds=jobs,secrets", "-ttl=24h"},
+			ExpDelete: []string{"secret/old"},
+		},
+		"shorter ttl": {
+			Flags:     []string{"-kinds=secrets", "-ttl=30m"},
+			ExpDelete: []string{"secret/old", "secret/new"},
+		},
+		"dry run": {
+			Flags: []string{"-kinds=jobs,pods", "-dry-run"},
+			ExpOutput: []string{
+				"would delete job default/succeeded, finished 2h0m0s ago",
+				"would delete job default/failed, finished 3h0m0s ago",
+				"would delete pod default/succeeded, finished 2h0m0s ago",
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			ns := "default"
+			now := time.Now()
+			ago := func(d time.Duration) meta.Time { return meta.NewTime(now.Add(-d)) }
+			labels := map[string]string{"app": "consul"}
+			objectMeta := func(name string, created time.Duration) meta.ObjectMeta {
+				return meta.ObjectMeta{Name: name, Namespace: ns, Labels: labels, CreationTimestamp: ago(created)}
+			}
+			completed := ago(2 * time.Hour)
+			other := objectMeta("other", 5*time.Hour)
+			other.Labels = map[string]string{"app": "other"}
+			k8s := fake.NewSimpleClientset(
+				&batch.Job{
+					ObjectMeta: objectMeta("succeeded", 3*time.Hour),
+					Status:     batch.JobStatus{Succeeded: 1, CompletionTime: &completed},
+				},
+				&batch.Job{
+					ObjectMeta: objectMeta("failed", 4*time.Hour),
+					Status: batch.JobStatus{Failed: 1, Conditions: []batch.JobCondition{
+						{Type: batch.JobFailed, Status: corev1.ConditionTrue, LastTransitionTime: ago(3 * time.Hour)},
+					}},
+				},
+				&batch.Job{
+					ObjectMeta: objectMeta("running", 5*time.Hour),
+					Status:     batch.JobStatus{Active: 1},
+				},
+				&batch.Job{
+					ObjectMeta: other,
+					Status:     batch.JobStatus{Succeeded: 1},
+				},
+				&corev1.Pod{
+					ObjectMeta: objectMeta("succeeded", 3*time.Hour),
+					Status: corev1.PodStatus{
+						Phase: corev1.PodSucceeded,
+						ContainerStatuses: []corev1.ContainerStatus{
+							{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: completed}}},
+						},
+					},
+				},
+				&corev1.Pod{
+					ObjectMeta: objectMeta("running", 3*time.Hour),
+					Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+				},
+				&corev1.Secret{ObjectMeta: objectMeta("old", 25*time.Hour)},
+				&corev1.Secret{ObjectMeta: objectMeta("new", time.Hour)},
+			)
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				k8sClient: k8s,
+			}
+			cmd.init()
+			responseCode := cmd.Run(append([]string{"-k8s-namespace", ns, "-selector", "app=consul", "-ttl=90m"}, c.Flags...))
+			require.Equal(0, responseCode, ui.ErrorWriter.String())
+
+			var actDelete []string
+			for _, action := range k8s.Actions() {
+				if action.GetVerb() == "delete" {
+					actDelete = append(actDelete, strings.TrimSuffix(action.GetResource().Resource, "s")+"/"+
+						action.(k8stesting.DeleteAction).GetName())
+				}
+			}
+			require.Equal(c.ExpDelete, actDelete)
+			var expOutput string
+			for _, line := range c.ExpOutput {
+				expOutput += line + "\n"
+			}
+			require.Equal(expOutput, ui.OutputWriter.String())
+		})
+	}
+}
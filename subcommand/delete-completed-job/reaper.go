@@ -0,0 +1,173 @@
+package deletecompletedjob
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The kinds of resources of -kinds.
+const (
+	kindJobs    = "jobs"
+	kindSecrets = "secrets"
+	kindPods    = "pods"
+)
+
+// reapable is a resource that can be deleted once it's been finished for
+// longer than -ttl.
+type reapable struct {
+	kind     string
+	name     string
+	finished time.Time
+}
+
+// parseKinds returns the kinds of the comma-separated list s, sorted and
+// without duplicates.
+func parseKinds(s string) ([]string, error) {
+	seen := make(map[string]bool)
+	var kinds []string
+	for _, kind := range strings.Split(s, ",") {
+		kind = strings.TrimSpace(kind)
+		switch kind {
+		case kindJobs, kindSecrets, kindPods:
+		default:
+			return nil, fmt.Errorf("unknown kind %q, must be %q, %q or %q", kind, kindJobs, kindSecrets, kindPods)
+		}
+		if !seen[kind] {
+			seen[kind] = true
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds, nil
+}
+
+// reap deletes the resources of kinds in -k8s-namespace that match
+// -selector and have been finished for longer than ttl. With -dry-run, it
+// only outputs them. It returns the exit code of the command.
+func (c *Command) reap(logger hclog.Logger, kinds []string, ttl time.Duration) int {
+	now := time.Now()
+	code := 0
+	for _, kind := range kinds {
+		resources, err := c.listReapable(kind)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error listing %s: %s", kind, err))
+			code = 1
+			continue
+		}
+		for _, r := range resources {
+			age := now.Sub(r.finished)
+			if age < ttl {
+				logger.Debug("resource has not reached its ttl", "kind", r.kind, "name", r.name, "age", age)
+				continue
+			}
+			age = age.Round(time.Second)
+			if c.flagDryRun {
+				c.UI.Output(fmt.Sprintf("would delete %s %s/%s, finished %s ago", r.kind, c.flagNamespace, r.name, age))
+				continue
+			}
+			if err := c.delete(r); err != nil && !k8serrors.IsNotFound(err) {
+				c.UI.Error(fmt.Sprintf("unable to delete %s %q: %s", r.kind, r.name, err))
+				code = 1
+				continue
+			}
+			logger.Info("deleted resource", "kind", r.kind, "name", r.name, "age", age)
+		}
+	}
+	return code
+}
+
+// listReapable returns the finished resources of kind that match
+// -selector. The Jobs are finished once they've succeeded or failed, the
+// Pods once they're in the Succeeded or Failed phase and the Secrets once
+// they're created.
+func (c *Command) listReapable(kind string) ([]reapable, error) {
+	opts := metav1.ListOptions{LabelSelector: c.flagSelector}
+	var resources []reapable
+	switch kind {
+	case kindJobs:
+		list, err := c.k8sClient.BatchV1().Jobs(c.flagNamespace).List(opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, job := range list.Items {
+			if finished, ok := jobFinished(job); ok {
+				resources = append(resources, reapable{kind: "job", name: job.Name, finished: finished})
+			}
+		}
+	case kindPods:
+		list, err := c.k8sClient.CoreV1().Pods(c.flagNamespace).List(opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range list.Items {
+			if finished, ok := podFinished(pod); ok {
+				resources = append(resources, reapable{kind: "pod", name: pod.Name, finished: finished})
+			}
+		}
+	case kindSecrets:
+		list, err := c.k8sClient.CoreV1().Secrets(c.flagNamespace).List(opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, secret := range list.Items {
+			resources = append(resources, reapable{kind: "secret", name: secret.Name, finished: secret.CreationTimestamp.Time})
+		}
+	}
+	return resources, nil
+}
+
+// delete deletes r.
+func (c *Command) delete(r reapable) error {
+	switch r.kind {
+	case "job":
+		propagationPolicy := metav1.DeletePropagationForeground
+		return c.k8sClient.BatchV1().Jobs(c.flagNamespace).Delete(r.name, &metav1.DeleteOptions{
+			// Needed so that the underlying pods are also deleted.
+			PropagationPolicy: &propagationPolicy,
+		})
+	case "pod":
+		return c.k8sClient.CoreV1().Pods(c.flagNamespace).Delete(r.name, &metav1.DeleteOptions{})
+	default:
+		return c.k8sClient.CoreV1().Secrets(c.flagNamespace).Delete(r.name, &metav1.DeleteOptions{})
+	}
+}
+
+// jobFinished returns when job completed or failed, and false if it's still
+// running.
+func jobFinished(job batch.Job) (time.Time, bool) {
+	if job.Status.Succeeded > 0 {
+		if job.Status.CompletionTime != nil {
+			return job.Status.CompletionTime.Time, true
+		}
+		return job.CreationTimestamp.Time, true
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batch.JobFailed && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// podFinished returns when the last container of pod terminated, and false
+// if pod is still pending or running.
+func podFinished(pod corev1.Pod) (time.Time, bool) {
+	if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+		return time.Time{}, false
+	}
+	finished := pod.CreationTimestamp.Time
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil && status.State.Terminated.FinishedAt.After(finished) {
+			finished = status.State.Terminated.FinishedAt.Time
+		}
+	}
+	return finished, true
+}
@@ -0,0 +1,196 @@
+package distributeca
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command distributes the server CA certificate to its consumers.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+
+	flagK8sNamespace   string
+	flagCASecretName   string
+	flagCASecretKey    string
+	flagWebhookConfigs []string
+	flagSecrets        []string
+	flagDeployments    []string
+	flagDaemonSets     []string
+
+	clientset kubernetes.Interface
+
+	// sigCh receives a signal when the command should stop.
+	sigCh chan os.Signal
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of the Kubernetes namespace of the CA secret and of the secrets, Deployments and DaemonSets to update.")
+	c.flags.StringVar(&c.flagCASecretName, "ca-secret-name", "",
+		"Name of the Kubernetes secret holding the server CA certificate.")
+	c.flags.StringVar(&c.flagCASecretKey, "ca-secret-key", "tls.crt",
+		"Key of the secret's data holding the PEM-encoded CA certificate.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagWebhookConfigs), "webhook-config",
+		"Name of a MutatingWebhookConfiguration whose caBundle is set to the CA certificate. "+
+			"May be specified multiple times.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagSecrets), "secret",
+		"Name of a secret the CA certificate is copied to, under the -ca-secret-key key, "+
+			"e.g. the secret mounted by the client DaemonSet. May be specified multiple times.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagDeployments), "deployment",
+		fmt.Sprintf("Name of a Deployment whose pods are rolled when the CA certificate changes by updating "+
+			"the %q annotation of its pod template. May be specified multiple times.", annotationCAChecksum))
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagDaemonSets), "daemonset",
+		fmt.Sprintf("Name of a DaemonSet whose pods are rolled when the CA certificate changes by updating "+
+			"the %q annotation of its pod template. May be specified multiple times.", annotationCAChecksum))
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+
+	ctl := &controller.Controller{
+		Log: logger.Named("controller"),
+		Resource: &CAResource{
+			Log:            logger.Named("distribute"),
+			Client:         c.clientset,
+			Namespace:      c.flagK8sNamespace,
+			SecretName:     c.flagCASecretName,
+			SecretKey:      c.flagCASecretKey,
+			WebhookConfigs: c.flagWebhookConfigs,
+			Secrets:        c.flagSecrets,
+			Deployments:    c.flagDeployments,
+			DaemonSets:     c.flagDaemonSets,
+		},
+	}
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ctl.Run(ctx.Done())
+	}()
+
+	select {
+	// Unexpected exit
+	case <-doneCh:
+		return 1
+
+	// Interrupted, gracefully exit
+	case <-c.sigCh:
+		cancelF()
+		<-doneCh
+		return 0
+	}
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagK8sNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagCASecretName == "" {
+		return errors.New("-ca-secret-name must be set")
+	}
+	if c.flagCASecretKey == "" {
+		return errors.New("-ca-secret-key must be set")
+	}
+	for _, name := range c.flagSecrets {
+		if name == c.flagCASecretName {
+			return errors.New("-secret cannot be the CA secret")
+		}
+	}
+	if len(c.flagWebhookConfigs) == 0 && len(c.flagSecrets) == 0 &&
+		len(c.flagDeployments) == 0 && len(c.flagDaemonSets) == 0 {
+		return errors.New("at least one of -webhook-config, -secret, -deployment or -daemonset must be set")
+	}
+	return nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Distribute the server CA certificate to its consumers"
+const help = `
+Usage: consul-k8s distribute-ca [options]
+
+  Watches the Kubernetes secret holding the server CA certificate and,
+  whenever it changes, distributes the certificate to its consumers so
+  that the CA can be rotated without manually restarting components:
+
+    - the caBundle of each -webhook-config is set to the certificate
+    - the certificate is copied to each -secret
+    - the pods of each -deployment and -daemonset are rolled by updating
+      the consul.hashicorp.com/ca-checksum annotation of their pod template
+
+`
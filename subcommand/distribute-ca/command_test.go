@@ -0,0 +1,167 @@
+package distributeca
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const ns = "default"
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{},
+			ExpErr: "-k8s-namespace must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns},
+			ExpErr: "-ca-secret-name must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-ca-secret-name", "ca", "-ca-secret-key", ""},
+			ExpErr: "-ca-secret-key must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-ca-secret-name", "ca", "-secret", "ca"},
+			ExpErr: "-secret cannot be the CA secret",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-ca-secret-name", "ca"},
+			ExpErr: "at least one of -webhook-config, -secret, -deployment or -daemonset must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-ca-secret-name", "ca", "-deployment", "foo", "-log-level", "invalid"},
+			ExpErr: "Unknown log level: invalid",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				clientset: fake.NewSimpleClientset(),
+			}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+// Test that the CA certificate is distributed to all consumers and
+// distributed again when the CA secret is updated.
+func TestRun(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset(
+		&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-ca-cert", Namespace: ns},
+			Data:       map[string][]byte{"tls.crt": []byte("ca-1")},
+		},
+		&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: ns},
+			Data:       map[string][]byte{"other": []byte("value")},
+		},
+		&admissionv1beta1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-connect-injector-cfg"},
+			Webhooks: []admissionv1beta1.MutatingWebhook{
+				{Name: "consul-connect-injector.consul.hashicorp.com"},
+			},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-connect-injector-webhook-deployment", Namespace: ns},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul", Namespace: ns},
+		},
+	)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	cmd.once.Do(cmd.init)
+
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-k8s-namespace", ns,
+			"-ca-secret-name", "consul-ca-cert",
+			"-webhook-config", "consul-connect-injector-cfg",
+			"-secret", "consul-client-ca",
+			"-secret", "existing",
+			"-deployment", "consul-connect-injector-webhook-deployment",
+			"-daemonset", "consul",
+		})
+	}()
+
+	requireDistributed := func(r *retry.R, caCert, checksum string) {
+		config, err := k8s.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get("consul-connect-injector-cfg", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.Equal(r, caCert, string(config.Webhooks[0].ClientConfig.CABundle))
+
+		for _, name := range []string{"consul-client-ca", "existing"} {
+			secret, err := k8s.CoreV1().Secrets(ns).Get(name, metav1.GetOptions{})
+			require.NoError(r, err)
+			require.Equal(r, caCert, string(secret.Data["tls.crt"]))
+		}
+
+		deployment, err := k8s.AppsV1().Deployments(ns).Get("consul-connect-injector-webhook-deployment", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.Equal(r, checksum, deployment.Spec.Template.Annotations[annotationCAChecksum])
+		daemonSet, err := k8s.AppsV1().DaemonSets(ns).Get("consul", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.Equal(r, checksum, daemonSet.Spec.Template.Annotations[annotationCAChecksum])
+	}
+
+	// The checksums are the SHA-256 of the CA certificates.
+	retry.Run(t, func(r *retry.R) {
+		requireDistributed(r, "ca-1", "aa1088bfa911416714f914ee6b78ab4b18e711772f810d9b51f663128d1b6612")
+	})
+	existing, err := k8s.CoreV1().Secrets(ns).Get("existing", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "value", string(existing.Data["other"]))
+
+	// Rotate the CA.
+	_, err = k8s.CoreV1().Secrets(ns).Update(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "consul-ca-cert", Namespace: ns},
+		Data:       map[string][]byte{"tls.crt": []byte("ca-2")},
+	})
+	require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		requireDistributed(r, "ca-2", "b8f22cae1f0914d8bfd0ade373478167bbb2032169835091227353dfcd8678ef")
+	})
+
+	cmd.sigCh <- os.Interrupt
+	select {
+	case exitCode := <-exitCh:
+		require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not exit after interrupt")
+	}
+}
+
+func TestSetChecksum(t *testing.T) {
+	t.Parallel()
+	var template apiv1.PodTemplateSpec
+	require.True(t, setChecksum(&template, "abc"))
+	require.Equal(t, "abc", template.Annotations[annotationCAChecksum])
+	require.False(t, setChecksum(&template, "abc"))
+	require.True(t, setChecksum(&template, "def"))
+	require.Equal(t, "def", template.Annotations[annotationCAChecksum])
+}
@@ -0,0 +1,234 @@
+package distributeca
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// annotationCAChecksum is the pod template annotation holding the checksum
+// of the CA certificate. Changing it rolls the pods.
+const annotationCAChecksum = "consul.hashicorp.com/ca-checksum"
+
+// CAResource implements controller.Resource to distribute the CA certificate
+// of the server CA secret to its consumers whenever the secret changes.
+type CAResource struct {
+	Log    hclog.Logger
+	Client kubernetes.Interface
+
+	// Namespace is the namespace of the CA secret, the secrets,
+	// Deployments and DaemonSets.
+	Namespace string
+
+	// SecretName and SecretKey are the name of the CA secret and the key
+	// of its data holding the PEM-encoded CA certificate.
+	SecretName string
+	SecretKey  string
+
+	// WebhookConfigs are the names of the MutatingWebhookConfigurations
+	// whose caBundle is updated.
+	WebhookConfigs []string
+
+	// Secrets are the names of the secrets the CA certificate is copied
+	// to, under the same key.
+	Secrets []string
+
+	// Deployments and DaemonSets are the names of the workloads that are
+	// rolled when the CA certificate changes.
+	Deployments []string
+	DaemonSets  []string
+}
+
+// Informer implements the controller.Resource interface.
+func (r *CAResource) Informer() cache.SharedIndexInformer {
+	selector := fields.OneTermEqualSelector("metadata.name", r.SecretName).String()
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = selector
+				return r.Client.CoreV1().Secrets(r.Namespace).List(options)
+			},
+
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				return r.Client.CoreV1().Secrets(r.Namespace).Watch(options)
+			},
+		},
+		&apiv1.Secret{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+// Upsert implements the controller.Resource interface.
+func (r *CAResource) Upsert(key string, raw interface{}) error {
+	// We expect the CA secret. If it isn't, just ignore it.
+	secret, ok := raw.(*apiv1.Secret)
+	if !ok || secret.Name != r.SecretName {
+		return nil
+	}
+	caCert := secret.Data[r.SecretKey]
+	if len(caCert) == 0 {
+		r.Log.Warn("CA secret has no CA certificate", "key", key, "secret-key", r.SecretKey)
+		return nil
+	}
+	checksum := sha256.Sum256(caCert)
+	return r.distribute(caCert, hex.EncodeToString(checksum[:]))
+}
+
+// Delete implements the controller.Resource interface. The consumers keep
+// the last CA certificate until the secret is recreated.
+func (r *CAResource) Delete(key string) error {
+	r.Log.Warn("CA secret was deleted, keeping the distributed CA certificate", "key", key)
+	return nil
+}
+
+// distribute updates every consumer that isn't up to date with caCert.
+// It continues on errors so that one failing consumer doesn't block the
+// others and returns all errors so that the secret is retried.
+func (r *CAResource) distribute(caCert []byte, checksum string) error {
+	var result error
+	for _, name := range r.WebhookConfigs {
+		if err := r.updateWebhookConfig(name, caCert); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	for _, name := range r.Secrets {
+		if err := r.updateSecret(name, caCert); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	for _, name := range r.Deployments {
+		if err := r.rollDeployment(name, checksum); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	for _, name := range r.DaemonSets {
+		if err := r.rollDaemonSet(name, checksum); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
+
+// updateWebhookConfig sets the caBundle of every webhook of the
+// MutatingWebhookConfiguration.
+func (r *CAResource) updateWebhookConfig(name string, caCert []byte) error {
+	configs := r.Client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+	config, err := configs.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting MutatingWebhookConfiguration %q: %s", name, err)
+	}
+	updated := false
+	for i := range config.Webhooks {
+		if string(config.Webhooks[i].ClientConfig.CABundle) != string(caCert) {
+			config.Webhooks[i].ClientConfig.CABundle = caCert
+			updated = true
+		}
+	}
+	if !updated {
+		return nil
+	}
+	if _, err := configs.Update(config); err != nil {
+		return fmt.Errorf("updating MutatingWebhookConfiguration %q: %s", name, err)
+	}
+	r.Log.Info("Updated caBundle", "mutatingwebhookconfiguration", name)
+	return nil
+}
+
+// updateSecret copies caCert to the secret, creating it if it doesn't exist.
+func (r *CAResource) updateSecret(name string, caCert []byte) error {
+	secrets := r.Client.CoreV1().Secrets(r.Namespace)
+	secret, err := secrets.Get(name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = secrets.Create(&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Data: map[string][]byte{
+				r.SecretKey: caCert,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("creating secret %q: %s", name, err)
+		}
+		r.Log.Info("Created secret with CA certificate", "secret", name)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting secret %q: %s", name, err)
+	}
+
+	if string(secret.Data[r.SecretKey]) == string(caCert) {
+		return nil
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[r.SecretKey] = caCert
+	if _, err := secrets.Update(secret); err != nil {
+		return fmt.Errorf("updating secret %q: %s", name, err)
+	}
+	r.Log.Info("Updated CA certificate", "secret", name)
+	return nil
+}
+
+// rollDeployment sets the checksum annotation of the Deployment's pod
+// template, which rolls its pods if the checksum changed.
+func (r *CAResource) rollDeployment(name, checksum string) error {
+	deployments := r.Client.AppsV1().Deployments(r.Namespace)
+	deployment, err := deployments.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting Deployment %q: %s", name, err)
+	}
+	if !setChecksum(&deployment.Spec.Template, checksum) {
+		return nil
+	}
+	if _, err := deployments.Update(deployment); err != nil {
+		return fmt.Errorf("updating Deployment %q: %s", name, err)
+	}
+	r.Log.Info("Rolling pods for new CA certificate", "deployment", name)
+	return nil
+}
+
+// rollDaemonSet sets the checksum annotation of the DaemonSet's pod
+// template, which rolls its pods if the checksum changed.
+func (r *CAResource) rollDaemonSet(name, checksum string) error {
+	daemonSets := r.Client.AppsV1().DaemonSets(r.Namespace)
+	daemonSet, err := daemonSets.Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting DaemonSet %q: %s", name, err)
+	}
+	if !setChecksum(&daemonSet.Spec.Template, checksum) {
+		return nil
+	}
+	if _, err := daemonSets.Update(daemonSet); err != nil {
+		return fmt.Errorf("updating DaemonSet %q: %s", name, err)
+	}
+	r.Log.Info("Rolling pods for new CA certificate", "daemonset", name)
+	return nil
+}
+
+// setChecksum sets the checksum annotation of the pod template. It returns
+// false if the annotation already had the checksum.
+func setChecksum(template *apiv1.PodTemplateSpec, checksum string) bool {
+	if template.Annotations[annotationCAChecksum] == checksum {
+		return false
+	}
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[annotationCAChecksum] = checksum
+	return true
+}
@@ -0,0 +1,110 @@
+package exporttrustbundle
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/cert"
+	"github.com/hashicorp/consul/api"
+)
+
+// jwk is a JSON Web Key of a SPIFFE bundle as specified in
+// https://github.com/spiffe/spiffe/blob/master/standards/SPIFFE_Trust_Domain_and_Bundle.md.
+type jwk struct {
+	Use string   `json:"use"`
+	Kty string   `json:"kty"`
+	Kid string   `json:"kid,omitempty"`
+	Crv string   `json:"crv,omitempty"`
+	X   string   `json:"x,omitempty"`
+	Y   string   `json:"y,omitempty"`
+	N   string   `json:"n,omitempty"`
+	E   string   `json:"e,omitempty"`
+	X5c []string `json:"x5c"`
+}
+
+// spiffeBundle is a SPIFFE trust bundle in the JWK Set format.
+type spiffeBundle struct {
+	Keys        []jwk  `json:"keys"`
+	Sequence    uint64 `json:"spiffe_sequence,omitempty"`
+	RefreshHint int64  `json:"spiffe_refresh_hint,omitempty"`
+}
+
+// x509SVIDUse is the JWK "use" of keys that verify X.509-SVIDs.
+const x509SVIDUse = "x509-svid"
+
+// spiffeBundleJSON returns the SPIFFE trust bundle of all the Connect CA
+// roots, including inactive ones so that certificates signed by a root
+// that is being rotated out keep being trusted. sequence is the
+// bundle's spiffe_sequence and refreshHint its spiffe_refresh_hint.
+func spiffeBundleJSON(roots []*api.CARoot, sequence uint64, refreshHint time.Duration) ([]byte, error) {
+	bundle := spiffeBundle{
+		Keys:        []jwk{},
+		Sequence:    sequence,
+		RefreshHint: int64(refreshHint / time.Second),
+	}
+	for _, root := range roots {
+		key, err := rootJWK(root)
+		if err != nil {
+			return nil, fmt.Errorf("root %q: %s", root.ID, err)
+		}
+		bundle.Keys = append(bundle.Keys, key)
+	}
+	return json.MarshalIndent(bundle, "", "  ")
+}
+
+// rootJWK returns the JWK of a Connect CA root's public key.
+func rootJWK(root *api.CARoot) (jwk, error) {
+	caCert, err := cert.ParseCert([]byte(root.RootCertPEM))
+	if err != nil {
+		return jwk{}, err
+	}
+
+	key := jwk{
+		Use: x509SVIDUse,
+		Kid: root.ID,
+		X5c: []string{base64.StdEncoding.EncodeToString(caCert.Raw)},
+	}
+	switch pub := caCert.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = pub.Curve.Params().Name
+		key.X = base64URL(pub.X, size)
+		key.Y = base64URL(pub.Y, size)
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = base64URL(pub.N, 0)
+		key.E = base64URL(big.NewInt(int64(pub.E)), 0)
+	default:
+		return jwk{}, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return key, nil
+}
+
+// rootsPEM returns the PEM-encoded certificates of all the roots.
+func rootsPEM(roots []*api.CARoot) []byte {
+	var buf bytes.Buffer
+	for _, root := range roots {
+		buf.WriteString(root.RootCertPEM)
+		if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// base64URL returns the unpadded base64url encoding of the big-endian
+// bytes of i, left-padded with zeros to size bytes.
+func base64URL(i *big.Int, size int) string {
+	b := i.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,299 @@
+package exporttrustbundle
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// configMapBundleKey is the key of the ConfigMap's data that holds
+	// the SPIFFE trust bundle.
+	configMapBundleKey = "bundle.spiffe"
+
+	// configMapPEMKey is the key of the ConfigMap's data that holds
+	// the PEM-encoded roots for consumers that don't support SPIFFE
+	// bundles.
+	configMapPEMKey = "bundle.pem"
+
+	// configMapTrustDomainKey is the key of the ConfigMap's data that
+	// holds the mesh's trust domain.
+	configMapTrustDomainKey = "trust-domain"
+)
+
+// Command exports the Connect CA roots as a SPIFFE trust bundle.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+
+	flagConfigMapName string
+	flagK8sNamespace  string
+	flagWatch         bool
+	flagRefreshHint   time.Duration
+
+	consulClient *api.Client
+	clientset    kubernetes.Interface
+
+	// sigCh receives a signal when the command should stop watching.
+	sigCh chan os.Signal
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagConfigMapName, "configmap-name", "",
+		"Name of the ConfigMap to write the trust bundle to.")
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of the Kubernetes namespace of the ConfigMap.")
+	c.flags.BoolVar(&c.flagWatch, "watch", false,
+		"Keep running and update the ConfigMap whenever the Connect CA roots change. "+
+			"If false, the command exits once the ConfigMap has been written.")
+	c.flags.DurationVar(&c.flagRefreshHint, "refresh-hint", 5*time.Minute,
+		"The bundle's spiffe_refresh_hint, i.e. how often consumers should check for an updated bundle.")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		var err error
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
+		c.consulClient, err = consul.NewClient(cfg)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	retryBackoff := backoff.NewExponentialBackOff()
+	retryBackoff.MaxInterval = 1 * time.Minute
+	retryBackoff.MaxElapsedTime = 0
+
+	var index uint64
+	for {
+		newIndex, err := c.exportBundle(ctx, logger, index)
+		if ctx.Err() != nil {
+			return 0
+		}
+		if err == nil {
+			if !c.flagWatch {
+				c.UI.Info(fmt.Sprintf("Successfully wrote trust bundle to ConfigMap %q", c.flagConfigMapName))
+				return 0
+			}
+			index = newIndex
+			retryBackoff.Reset()
+			continue
+		}
+
+		logger.Error("Error exporting trust bundle", "err", err)
+		select {
+		case <-time.After(retryBackoff.NextBackOff()):
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagConfigMapName == "" {
+		return errors.New("-configmap-name must be set")
+	}
+	if c.flagK8sNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagRefreshHint < time.Second {
+		return errors.New("-refresh-hint must be at least 1s")
+	}
+	return nil
+}
+
+// exportBundle writes the trust bundle of the Connect CA roots to the
+// ConfigMap once they change after index. It returns the index of the
+// roots. If index is 0, it doesn't block. The ConfigMap is only updated
+// if its data changed.
+func (c *Command) exportBundle(ctx context.Context, logger hclog.Logger, index uint64) (uint64, error) {
+	opts := &api.QueryOptions{WaitIndex: index}
+	roots, meta, err := c.consulClient.Connect().CARoots(opts.WithContext(ctx))
+	if err != nil {
+		return index, fmt.Errorf("retrieving Connect CA roots: %s", err)
+	}
+	newIndex := meta.LastIndex
+	if newIndex < index {
+		// Reset the index if it went backwards so that
+		// we don't block forever.
+		newIndex = 0
+	}
+	if len(roots.Roots) == 0 {
+		return index, errors.New("there are no Connect CA roots")
+	}
+
+	bundle, err := spiffeBundleJSON(roots.Roots, meta.LastIndex, c.flagRefreshHint)
+	if err != nil {
+		return index, err
+	}
+	data := map[string]string{
+		configMapBundleKey:      string(bundle),
+		configMapPEMKey:         string(rootsPEM(roots.Roots)),
+		configMapTrustDomainKey: roots.TrustDomain,
+	}
+	updated, err := c.writeConfigMap(data)
+	if err != nil {
+		return index, err
+	}
+	if updated {
+		logger.Info("Updated trust bundle", "configmap", c.flagConfigMapName, "roots", len(roots.Roots), "index", meta.LastIndex)
+	}
+	return newIndex, nil
+}
+
+// writeConfigMap creates the ConfigMap or replaces its data. It returns
+// false if the ConfigMap already had the same data.
+func (c *Command) writeConfigMap(data map[string]string) (bool, error) {
+	configMaps := c.clientset.CoreV1().ConfigMaps(c.flagK8sNamespace)
+	configMap, err := configMaps.Get(c.flagConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = configMaps.Create(&apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: c.flagConfigMapName,
+			},
+			Data: data,
+		})
+		if err != nil {
+			return false, fmt.Errorf("creating ConfigMap %q: %s", c.flagConfigMapName, err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("getting ConfigMap %q: %s", c.flagConfigMapName, err)
+	}
+
+	if equalData(configMap.Data, data) {
+		return false, nil
+	}
+	configMap.Data = data
+	if _, err := configMaps.Update(configMap); err != nil {
+		return false, fmt.Errorf("updating ConfigMap %q: %s", c.flagConfigMapName, err)
+	}
+	return true, nil
+}
+
+func equalData(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Export the Connect CA roots as a SPIFFE trust bundle"
+const help = `
+Usage: consul-k8s export-trust-bundle [options]
+
+  Exports the Connect CA roots as a SPIFFE trust bundle to a ConfigMap
+  so that external SPIFFE-aware systems can federate trust with the
+  Consul service mesh. The ConfigMap has these keys:
+
+    bundle.spiffe  the roots as a SPIFFE bundle in the JWK Set format
+    bundle.pem     the PEM-encoded roots
+    trust-domain   the mesh's trust domain
+
+  If -watch is set, the command keeps running and updates the ConfigMap
+  whenever the roots change.
+
+`
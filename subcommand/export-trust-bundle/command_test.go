@@ -0,0 +1,240 @@
+package exporttrustbundle
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/cert"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{},
+			ExpErr: "-configmap-name must be set",
+		},
+		{
+			Flags:  []string{"-configmap-name", "bundle"},
+			ExpErr: "-k8s-namespace must be set",
+		},
+		{
+			Flags:  []string{"-configmap-name", "bundle", "-k8s-namespace", "default", "-refresh-hint", "10ms"},
+			ExpErr: "-refresh-hint must be at least 1s",
+		},
+		{
+			Flags:  []string{"-configmap-name", "bundle", "-k8s-namespace", "default", "-log-level", "invalid"},
+			ExpErr: "Unknown log level: invalid",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI: ui,
+			}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+// Test that the trust bundle is written to the ConfigMap and, in watch
+// mode, updated when the roots change.
+func TestRun_Watch(t *testing.T) {
+	t.Parallel()
+	root1 := generateRoot(t, "root-1")
+	root2 := generateRoot(t, "root-2")
+
+	consul := &fakeConsul{roots: []*api.CARoot{root1}, index: 1, changed: make(chan struct{})}
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
+
+	ui := cli.NewMockUi()
+	k8s := fake.NewSimpleClientset()
+	cmd := Command{
+		UI:           ui,
+		clientset:    k8s,
+		consulClient: consulClient,
+	}
+	cmd.once.Do(cmd.init)
+
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-configmap-name", "consul-trust-bundle",
+			"-k8s-namespace", "default",
+			"-watch",
+		})
+	}()
+
+	requireBundle := func(r *retry.R, roots ...*api.CARoot) {
+		configMap, err := k8s.CoreV1().ConfigMaps("default").Get("consul-trust-bundle", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.Equal(r, "11111111-2222-3333-4444-555555555555.consul", configMap.Data[configMapTrustDomainKey])
+		require.Equal(r, string(rootsPEM(roots)), configMap.Data[configMapPEMKey])
+
+		var bundle spiffeBundle
+		require.NoError(r, json.Unmarshal([]byte(configMap.Data[configMapBundleKey]), &bundle))
+		require.Len(r, bundle.Keys, len(roots))
+		for i, root := range roots {
+			require.Equal(r, root.ID, bundle.Keys[i].Kid)
+		}
+	}
+	retry.Run(t, func(r *retry.R) {
+		requireBundle(r, root1)
+	})
+
+	// Rotate the CA. Both roots are trusted while the old one is
+	// being rotated out.
+	consul.setRoots(root1, root2)
+	retry.Run(t, func(r *retry.R) {
+		requireBundle(r, root1, root2)
+	})
+
+	cmd.sigCh <- os.Interrupt
+	select {
+	case exitCode := <-exitCh:
+		require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not exit after interrupt")
+	}
+}
+
+func TestRootJWK(t *testing.T) {
+	t.Parallel()
+
+	t.Run("EC", func(t *testing.T) {
+		root := generateRoot(t, "ec")
+		key, err := rootJWK(root)
+		require.NoError(t, err)
+		require.Equal(t, "x509-svid", key.Use)
+		require.Equal(t, "EC", key.Kty)
+		require.Equal(t, "P-256", key.Crv)
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		require.NoError(t, err)
+		require.Len(t, x, 32)
+		y, err := base64.RawURLEncoding.DecodeString(key.Y)
+		require.NoError(t, err)
+		require.Len(t, y, 32)
+		requireX5c(t, root, key)
+	})
+
+	t.Run("RSA", func(t *testing.T) {
+		pk, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "rsa"},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &pk.PublicKey, pk)
+		require.NoError(t, err)
+		root := &api.CARoot{
+			ID:          "rsa",
+			RootCertPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		}
+
+		key, err := rootJWK(root)
+		require.NoError(t, err)
+		require.Equal(t, "RSA", key.Kty)
+		require.Equal(t, "AQAB", key.E)
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		require.NoError(t, err)
+		require.Equal(t, pk.N.Bytes(), n)
+		requireX5c(t, root, key)
+	})
+
+	t.Run("invalid certificate", func(t *testing.T) {
+		_, err := rootJWK(&api.CARoot{ID: "invalid", RootCertPEM: "invalid"})
+		require.Error(t, err)
+	})
+}
+
+func requireX5c(t *testing.T, root *api.CARoot, key jwk) {
+	require.Len(t, key.X5c, 1)
+	der, err := base64.StdEncoding.DecodeString(key.X5c[0])
+	require.NoError(t, err)
+	block, _ := pem.Decode([]byte(root.RootCertPEM))
+	require.True(t, bytes.Equal(block.Bytes, der))
+}
+
+func generateRoot(t *testing.T, id string) *api.CARoot {
+	_, _, caPem, _, err := cert.GenerateCA("Consul CA - " + id)
+	require.NoError(t, err)
+	return &api.CARoot{ID: id, Name: id, RootCertPEM: caPem}
+}
+
+// fakeConsul fakes Consul's Connect CA roots endpoint. Blocking queries
+// return once the roots change or after a short wait.
+type fakeConsul struct {
+	lock    sync.Mutex
+	roots   []*api.CARoot
+	index   uint64
+	changed chan struct{}
+}
+
+func (f *fakeConsul) setRoots(roots ...*api.CARoot) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.roots = roots
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/connect/ca/roots" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	f.lock.Lock()
+	changed := f.changed
+	blocking := waitIndex >= f.index
+	f.lock.Unlock()
+	if blocking {
+		select {
+		case <-changed:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	json.NewEncoder(w).Encode(api.CARootList{
+		TrustDomain: "11111111-2222-3333-4444-555555555555.consul",
+		Roots:       f.roots,
+	})
+}
@@ -0,0 +1,430 @@
+package federationinit
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Keys of the data of the federation secret.
+const (
+	// secretCACertKey holds the CA certificate of the servers, with which
+	// the secondary datacenters issue the certificates of their servers.
+	secretCACertKey = "caCert"
+	// secretCAKeyKey holds the private key of the CA of the servers.
+	secretCAKeyKey = "caKey"
+	// secretGossipKey holds the gossip encryption key.
+	secretGossipKey = "gossipEncryptionKey"
+	// secretReplicationTokenKey holds the ACL replication token.
+	secretReplicationTokenKey = "replicationToken"
+	// secretServerConfigKey holds the configuration of the servers of the
+	// secondary datacenters, i.e. the primary datacenter and the
+	// addresses of its mesh gateways.
+	secretServerConfigKey = "serverConfigJSON"
+)
+
+// Command configures the primary datacenter for WAN federation through
+// mesh gateways and writes the secret the secondary datacenters need to
+// join it.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+
+	flagK8sNamespace           string
+	flagSecretName             string
+	flagServerCACertFile       string
+	flagServerCAKeyFile        string
+	flagGossipKeySecretName    string
+	flagGossipKeySecretKey     string
+	flagReplicationTokenSecret string
+	flagMeshGatewayServiceName string
+	flagMeshGatewayMode        string
+	flagTimeout                time.Duration
+
+	consulClient  *api.Client
+	clientset     kubernetes.Interface
+	retryDuration time.Duration
+
+	once sync.Once
+	help string
+}
+
+// serverConfig is the configuration of the servers of the secondary
+// datacenters.
+type serverConfig struct {
+	PrimaryDatacenter string   `json:"primary_datacenter"`
+	PrimaryGateways   []string `json:"primary_gateways"`
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of the Kubernetes namespace of the secrets.")
+	c.flags.StringVar(&c.flagSecretName, "secret-name", "",
+		"Name of the Kubernetes secret to write the federation material to.")
+	c.flags.StringVar(&c.flagServerCACertFile, "server-ca-cert-file", "",
+		"Path to the CA certificate of the servers.")
+	c.flags.StringVar(&c.flagServerCAKeyFile, "server-ca-key-file", "",
+		"Path to the private key of the CA of the servers.")
+	c.flags.StringVar(&c.flagGossipKeySecretName, "gossip-key-secret-name", "",
+		"Name of the Kubernetes secret holding the gossip encryption key, if gossip encryption is enabled.")
+	c.flags.StringVar(&c.flagGossipKeySecretKey, "gossip-key-secret-key", "key",
+		"Key of the data of -gossip-key-secret-name holding the gossip encryption key.")
+	c.flags.StringVar(&c.flagReplicationTokenSecret, "acl-replication-token-secret-name", "",
+		"Name of the Kubernetes secret holding the ACL replication token in its \"token\" key, "+
+			"e.g. the secret created by server-acl-init with -create-acl-replication-token, if ACLs are enabled.")
+	c.flags.StringVar(&c.flagMeshGatewayServiceName, "mesh-gateway-service-name", "mesh-gateway",
+		"Name of the service of the mesh gateways of the primary datacenter.")
+	c.flags.StringVar(&c.flagMeshGatewayMode, "mesh-gateway-mode", string(api.MeshGatewayModeLocal),
+		"Mesh gateway mode of the global proxy-defaults config entry, \"local\" or \"remote\".")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Minute,
+		"How long to wait for the mesh gateways to be registered and healthy. Defaults to 10m.")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	if c.retryDuration == 0 {
+		c.retryDuration = 1 * time.Second
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		var err error
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
+		c.consulClient, err = consul.NewClient(cfg)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	// Read the files and secrets first so that a misconfiguration fails
+	// before Consul is configured.
+	data, err := c.secretData()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading the federation material: %s", err))
+		return 1
+	}
+	datacenter, err := c.primaryDatacenter()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error checking the primary datacenter: %s", err))
+		return 1
+	}
+	if err := c.writeProxyDefaults(logger); err != nil {
+		c.UI.Error(fmt.Sprintf("Error writing the proxy-defaults config entry: %s", err))
+		return 1
+	}
+
+	var gateways []string
+	retryBackoff := backoff.NewExponentialBackOff()
+	retryBackoff.InitialInterval = c.retryDuration
+	retryBackoff.MaxInterval = 30 * time.Second
+	retryBackoff.MaxElapsedTime = c.flagTimeout
+	err = backoff.Retry(func() error {
+		var err error
+		gateways, err = c.primaryGateways()
+		if err != nil {
+			logger.Info("Waiting for the mesh gateways", "reason", err)
+		}
+		return err
+	}, retryBackoff)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error getting the addresses of the mesh gateways: %s", err))
+		return 1
+	}
+
+	config, err := json.Marshal(serverConfig{PrimaryDatacenter: datacenter, PrimaryGateways: gateways})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error encoding the server configuration: %s", err))
+		return 1
+	}
+	data[secretServerConfigKey] = config
+	if err := c.writeSecret(data); err != nil {
+		c.UI.Error(fmt.Sprintf("Error writing the federation secret: %s", err))
+		return 1
+	}
+	c.UI.Info(fmt.Sprintf("Successfully wrote the federation secret %q with primary gateways %v", c.flagSecretName, gateways))
+	return 0
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagK8sNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagSecretName == "" {
+		return errors.New("-secret-name must be set")
+	}
+	if c.flagServerCACertFile == "" || c.flagServerCAKeyFile == "" {
+		return errors.New("-server-ca-cert-file and -server-ca-key-file must be set")
+	}
+	if c.flagMeshGatewayServiceName == "" {
+		return errors.New("-mesh-gateway-service-name must be set")
+	}
+	switch api.MeshGatewayMode(c.flagMeshGatewayMode) {
+	case api.MeshGatewayModeLocal, api.MeshGatewayModeRemote:
+	default:
+		return fmt.Errorf("-mesh-gateway-mode must be %q or %q, not %q",
+			api.MeshGatewayModeLocal, api.MeshGatewayModeRemote, c.flagMeshGatewayMode)
+	}
+	if c.flagTimeout <= 0 {
+		return errors.New("-timeout must be greater than 0")
+	}
+	return nil
+}
+
+// secretData returns the data of the federation secret read from the CA
+// files and the gossip and replication token secrets.
+func (c *Command) secretData() (map[string][]byte, error) {
+	data := make(map[string][]byte)
+	for key, path := range map[string]string{
+		secretCACertKey: c.flagServerCACertFile,
+		secretCAKeyKey:  c.flagServerCAKeyFile,
+	} {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(contents) == 0 {
+			return nil, fmt.Errorf("file %q is empty", path)
+		}
+		data[key] = contents
+	}
+	if c.flagGossipKeySecretName != "" {
+		key, err := c.secretValue(c.flagGossipKeySecretName, c.flagGossipKeySecretKey)
+		if err != nil {
+			return nil, err
+		}
+		data[secretGossipKey] = key
+	}
+	if c.flagReplicationTokenSecret != "" {
+		token, err := c.secretValue(c.flagReplicationTokenSecret, "token")
+		if err != nil {
+			return nil, err
+		}
+		data[secretReplicationTokenKey] = token
+	}
+	return data, nil
+}
+
+// secretValue returns the value of the key of the secret.
+func (c *Command) secretValue(name, key string) ([]byte, error) {
+	secret, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting secret %q: %s", name, err)
+	}
+	value := secret.Data[key]
+	if len(value) == 0 {
+		return nil, fmt.Errorf("secret %q does not have data key %q", name, key)
+	}
+	return value, nil
+}
+
+// primaryDatacenter returns the datacenter of the agent, which must be the
+// primary datacenter.
+func (c *Command) primaryDatacenter() (string, error) {
+	self, err := c.consulClient.Agent().Self()
+	if err != nil {
+		return "", fmt.Errorf("reading the agent configuration: %s", err)
+	}
+	datacenter, _ := self["Config"]["Datacenter"].(string)
+	if datacenter == "" {
+		return "", errors.New("the agent configuration has no datacenter")
+	}
+	primary, _ := self["Config"]["PrimaryDatacenter"].(string)
+	if primary != "" && primary != datacenter {
+		return "", fmt.Errorf("datacenter %q isn't the primary datacenter %q, federation-init must run in the primary datacenter",
+			datacenter, primary)
+	}
+	return datacenter, nil
+}
+
+// writeProxyDefaults sets the mesh gateway mode of the global
+// proxy-defaults config entry, keeping its other fields.
+func (c *Command) writeProxyDefaults(logger hclog.Logger) error {
+	mode := api.MeshGatewayMode(c.flagMeshGatewayMode)
+	entry := &api.ProxyConfigEntry{Kind: api.ProxyDefaults, Name: api.ProxyConfigGlobal}
+	existing, _, err := c.consulClient.ConfigEntries().Get(api.ProxyDefaults, api.ProxyConfigGlobal, nil)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	if existing != nil {
+		proxyDefaults, ok := existing.(*api.ProxyConfigEntry)
+		if !ok {
+			return fmt.Errorf("unexpected config entry type %T", existing)
+		}
+		if proxyDefaults.MeshGateway.Mode == mode {
+			return nil
+		}
+		entry = proxyDefaults
+	}
+	entry.MeshGateway.Mode = mode
+	if _, _, err := c.consulClient.ConfigEntries().Set(entry, nil); err != nil {
+		return err
+	}
+	logger.Info("Set the mesh gateway mode of the proxy-defaults config entry", "mode", mode)
+	return nil
+}
+
+// primaryGateways returns the sorted WAN addresses of the healthy mesh
+// gateways of the datacenter.
+func (c *Command) primaryGateways() ([]string, error) {
+	entries, _, err := c.consulClient.Health().Service(c.flagMeshGatewayServiceName, "", true, nil)
+	if err != nil {
+		return nil, fmt.Errorf("listing the instances of service %q: %s", c.flagMeshGatewayServiceName, err)
+	}
+	seen := make(map[string]bool)
+	var gateways []string
+	for _, entry := range entries {
+		wan, ok := entry.Service.TaggedAddresses["wan"]
+		if !ok || wan.Address == "" {
+			continue
+		}
+		addr := net.JoinHostPort(wan.Address, strconv.Itoa(wan.Port))
+		if !seen[addr] {
+			seen[addr] = true
+			gateways = append(gateways, addr)
+		}
+	}
+	if len(gateways) == 0 {
+		return nil, fmt.Errorf("service %q has no healthy instances with a WAN address", c.flagMeshGatewayServiceName)
+	}
+	sort.Strings(gateways)
+	return gateways, nil
+}
+
+// writeSecret creates the federation secret or replaces its data.
+func (c *Command) writeSecret(data map[string][]byte) error {
+	secrets := c.clientset.CoreV1().Secrets(c.flagK8sNamespace)
+	secret, err := secrets.Get(c.flagSecretName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = secrets.Create(&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: c.flagSecretName,
+			},
+			Data: data,
+		})
+		if err != nil {
+			return fmt.Errorf("creating secret %q: %s", c.flagSecretName, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("getting secret %q: %s", c.flagSecretName, err)
+	}
+	secret.Data = data
+	if _, err := secrets.Update(secret); err != nil {
+		return fmt.Errorf("updating secret %q: %s", c.flagSecretName, err)
+	}
+	return nil
+}
+
+// isNotFound returns true if the error is Consul's response to reading
+// a config entry that doesn't exist.
+func isNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "Unexpected response code: 404")
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Configure WAN federation through mesh gateways"
+const help = `
+Usage: consul-k8s federation-init [options]
+
+  Configures the primary datacenter for WAN federation through mesh
+  gateways and writes the Kubernetes secret -secret-name that the
+  secondary datacenters need to join it. This command is expected to run
+  as a job in the primary datacenter, whose servers must be started with
+  connect { enable_mesh_gateway_wan_federation = true }.
+
+  The command sets the mesh gateway mode of the global proxy-defaults
+  config entry to -mesh-gateway-mode, then waits for the mesh gateways of
+  -mesh-gateway-service-name to be healthy, e.g. registered by
+  register-mesh-gateway, and writes the secret with these keys:
+
+    caCert               the CA certificate of -server-ca-cert-file
+    caKey                the CA key of -server-ca-key-file
+    gossipEncryptionKey  the key of -gossip-key-secret-name, if set
+    replicationToken     the token of -acl-replication-token-secret-name,
+                         if set
+    serverConfigJSON     the primary_datacenter and primary_gateways
+                         configuration of the secondary servers, with the
+                         WAN addresses of the mesh gateways
+
+  The secret is copied to the secondary datacenters, whose servers load
+  serverConfigJSON as a configuration file. Re-running the command updates
+  the secret, e.g. when the addresses of the mesh gateways change.
+
+`
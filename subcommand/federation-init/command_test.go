@@ -0,0 +1,256 @@
+package federationinit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const ns = "default"
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{},
+			ExpErr: "-k8s-namespace must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns},
+			ExpErr: "-secret-name must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-secret-name", "consul-federation"},
+			ExpErr: "-server-ca-cert-file and -server-ca-key-file must be set",
+		},
+		{
+			Flags: []string{"-k8s-namespace", ns, "-secret-name", "consul-federation",
+				"-server-ca-cert-file", "ca.pem", "-server-ca-key-file", "ca-key.pem", "-mesh-gateway-mode", "none"},
+			ExpErr: `-mesh-gateway-mode must be "local" or "remote", not "none"`,
+		},
+		{
+			Flags: []string{"-k8s-namespace", ns, "-secret-name", "consul-federation",
+				"-server-ca-cert-file", "ca.pem", "-server-ca-key-file", "ca-key.pem", "-timeout", "0s"},
+			ExpErr: "-timeout must be greater than 0",
+		},
+		{
+			Flags: []string{"-k8s-namespace", ns, "-secret-name", "consul-federation",
+				"-server-ca-cert-file", "ca.pem", "-server-ca-key-file", "ca-key.pem", "-log-level", "invalid"},
+			ExpErr: "Unknown log level: invalid",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				clientset: fake.NewSimpleClientset(),
+			}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+// Test that the mesh gateway mode is set, keeping the other proxy
+// defaults, and that the secret is written once the gateways are healthy.
+func TestRun(t *testing.T) {
+	t.Parallel()
+	caCert, caKey := writeCA(t)
+	consul := &fakeConsul{
+		datacenter:    "dc1",
+		proxyDefaults: &api.ProxyConfigEntry{Kind: api.ProxyDefaults, Name: api.ProxyConfigGlobal, Config: map[string]interface{}{"protocol": "http"}},
+		gateways:      []api.ServiceAddress{{Address: "34.1.1.2", Port: 443}, {Address: "34.1.1.1", Port: 443}},
+		// The gateways aren't healthy yet the first time they're listed.
+		unhealthyLists: 1,
+	}
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
+	k8s := fake.NewSimpleClientset(
+		&apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "consul-gossip-encryption-key", Namespace: ns}, Data: map[string][]byte{"key": []byte("GOSSIP")}},
+		&apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "consul-acl-replication-acl-token", Namespace: ns}, Data: map[string][]byte{"token": []byte("REPLICATION")}},
+	)
+
+	args := []string{
+		"-k8s-namespace", ns,
+		"-secret-name", "consul-federation",
+		"-server-ca-cert-file", caCert,
+		"-server-ca-key-file", caKey,
+		"-gossip-key-secret-name", "consul-gossip-encryption-key",
+		"-acl-replication-token-secret-name", "consul-acl-replication-acl-token",
+	}
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, clientset: k8s, consulClient: consulClient, retryDuration: 10 * time.Millisecond}
+	require.Equal(t, 0, cmd.Run(args), ui.ErrorWriter.String())
+
+	entry := consul.getProxyDefaults()
+	require.Equal(t, api.MeshGatewayModeLocal, entry.MeshGateway.Mode)
+	require.Equal(t, map[string]interface{}{"protocol": "http"}, entry.Config)
+
+	secret, err := k8s.CoreV1().Secrets(ns).Get("consul-federation", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "CA", string(secret.Data[secretCACertKey]))
+	require.Equal(t, "CA-KEY", string(secret.Data[secretCAKeyKey]))
+	require.Equal(t, "GOSSIP", string(secret.Data[secretGossipKey]))
+	require.Equal(t, "REPLICATION", string(secret.Data[secretReplicationTokenKey]))
+	var config serverConfig
+	require.NoError(t, json.Unmarshal(secret.Data[secretServerConfigKey], &config))
+	require.Equal(t, serverConfig{
+		PrimaryDatacenter: "dc1",
+		PrimaryGateways:   []string{"34.1.1.1:443", "34.1.1.2:443"},
+	}, config)
+
+	// Re-running the command updates the secret with the new addresses.
+	consul.setGateways(api.ServiceAddress{Address: "34.1.1.3", Port: 8443})
+	ui = cli.NewMockUi()
+	cmd = Command{UI: ui, clientset: k8s, consulClient: consulClient, retryDuration: 10 * time.Millisecond}
+	require.Equal(t, 0, cmd.Run(args), ui.ErrorWriter.String())
+	secret, err = k8s.CoreV1().Secrets(ns).Get("consul-federation", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"primary_datacenter":"dc1","primary_gateways":["34.1.1.3:8443"]}`, string(secret.Data[secretServerConfigKey]))
+}
+
+func TestRun_Errors(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		consul *fakeConsul
+		flags  []string
+		expErr string
+	}{
+		"secondary datacenter": {
+			consul: &fakeConsul{datacenter: "dc2", primaryDatacenter: "dc1"},
+			expErr: `datacenter "dc2" isn't the primary datacenter "dc1"`,
+		},
+		"missing gossip key secret": {
+			consul: &fakeConsul{datacenter: "dc1"},
+			flags:  []string{"-gossip-key-secret-name", "consul-gossip-encryption-key"},
+			expErr: `Error reading the federation material: getting secret "consul-gossip-encryption-key"`,
+		},
+		"no healthy gateways": {
+			consul: &fakeConsul{datacenter: "dc1"},
+			flags:  []string{"-timeout", "50ms"},
+			expErr: `service "mesh-gateway" has no healthy instances with a WAN address`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			caCert, caKey := writeCA(t)
+			server := httptest.NewServer(c.consul)
+			defer server.Close()
+			consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+			require.NoError(t, err)
+
+			k8s := fake.NewSimpleClientset()
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui, clientset: k8s, consulClient: consulClient, retryDuration: 10 * time.Millisecond}
+			args := append([]string{
+				"-k8s-namespace", ns,
+				"-secret-name", "consul-federation",
+				"-server-ca-cert-file", caCert,
+				"-server-ca-key-file", caKey,
+			}, c.flags...)
+			require.Equal(t, 1, cmd.Run(args))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+			_, err = k8s.CoreV1().Secrets(ns).Get("consul-federation", metav1.GetOptions{})
+			require.Error(t, err)
+		})
+	}
+}
+
+// writeCA writes the CA files of the servers and returns their paths.
+func writeCA(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "federation-init")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	caCert := filepath.Join(dir, "ca.pem")
+	caKey := filepath.Join(dir, "ca-key.pem")
+	require.NoError(t, ioutil.WriteFile(caCert, []byte("CA"), 0600))
+	require.NoError(t, ioutil.WriteFile(caKey, []byte("CA-KEY"), 0600))
+	return caCert, caKey
+}
+
+// fakeConsul is a Consul HTTP API serving the agent configuration, the
+// proxy-defaults config entry and the health of the mesh gateways.
+type fakeConsul struct {
+	datacenter        string
+	primaryDatacenter string
+
+	lock           sync.Mutex
+	proxyDefaults  *api.ProxyConfigEntry
+	gateways       []api.ServiceAddress
+	unhealthyLists int
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch {
+	case r.URL.Path == "/v1/agent/self":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"Config": map[string]interface{}{"Datacenter": f.datacenter, "PrimaryDatacenter": f.primaryDatacenter},
+		})
+	case r.URL.Path == "/v1/config/proxy-defaults/global" && r.Method == http.MethodGet:
+		if f.proxyDefaults == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(f.proxyDefaults)
+	case r.URL.Path == "/v1/config" && r.Method == http.MethodPut:
+		entry := &api.ProxyConfigEntry{}
+		if err := json.NewDecoder(r.Body).Decode(entry); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.proxyDefaults = entry
+		w.Write([]byte("true"))
+	case r.URL.Path == "/v1/health/service/mesh-gateway":
+		entries := []*api.ServiceEntry{}
+		if f.unhealthyLists > 0 {
+			f.unhealthyLists--
+		} else {
+			for _, wan := range f.gateways {
+				entries = append(entries, &api.ServiceEntry{
+					Node:    &api.Node{Node: "node"},
+					Service: &api.AgentService{Service: "mesh-gateway", TaggedAddresses: map[string]api.ServiceAddress{"wan": wan}},
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(entries)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeConsul) getProxyDefaults() *api.ProxyConfigEntry {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.proxyDefaults
+}
+
+func (f *fakeConsul) setGateways(gateways ...api.ServiceAddress) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.gateways = gateways
+}
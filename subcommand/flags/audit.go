@@ -0,0 +1,32 @@
+package flags
+
+import (
+	"flag"
+
+	"github.com/hashicorp/consul-k8s/helper/audit"
+	"github.com/hashicorp/go-hclog"
+)
+
+// AuditFlags are the flags of the commands writing to Consul to audit
+// their writes.
+type AuditFlags struct {
+	file string
+}
+
+func (f *AuditFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.StringVar(&f.file, "audit-log-file", "",
+		"Optional file to append the writes to Consul to, one JSON object per write with the "+
+			"component and the Kubernetes object that caused it, e.g. /dev/stdout. If blank, "+
+			"the writes aren't audited.")
+	return fs
+}
+
+// Start starts audit logging the writes of component if -audit-log-file
+// is set. The returned function stops it.
+func (f *AuditFlags) Start(logger hclog.Logger, component string) (func(), error) {
+	if f.file == "" {
+		return func() {}, nil
+	}
+	return audit.Start(logger, f.file, component)
+}
@@ -0,0 +1,137 @@
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/hashicorp/hcl"
+)
+
+const configFileFlag = "config-file"
+
+// ConfigFileFlags are the flags of the commands reading the values of their
+// other flags from a configuration file, so that they can be reviewed as a
+// document rather than as a list of arguments.
+type ConfigFileFlags struct {
+	file string
+}
+
+func (f *ConfigFileFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.StringVar(&f.file, configFileFlag, "",
+		"Optional HCL (.hcl) or YAML (.yaml, .yml or .json) file with the values of the other "+
+			"flags, keyed by their names without the dash, e.g. log-level = \"debug\". The values "+
+			"of the flags that can be repeated are lists. The flags on the command line take "+
+			"precedence over the file. Unknown keys are errors.")
+	return fs
+}
+
+// Apply sets the flags of fs that weren't set on the command line to their
+// values in -config-file, if it's set. It must be called after fs is parsed.
+// It returns an error if the file can't be read, has keys that aren't flags
+// of fs or has invalid values.
+func (f *ConfigFileFlags) Apply(fs *flag.FlagSet) error {
+	if f.file == "" {
+		return nil
+	}
+	values, err := readConfigFile(f.file)
+	if err != nil {
+		return fmt.Errorf("Error reading -%s %s: %s", configFileFlag, f.file, err)
+	}
+	if err := setFlags(fs, values); err != nil {
+		return fmt.Errorf("Error in -%s %s: %s", configFileFlag, f.file, err)
+	}
+	return nil
+}
+
+// readConfigFile returns the values of the HCL or YAML file at path,
+// depending on its extension.
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	switch filepath.Ext(path) {
+	case ".hcl":
+		err = hcl.Decode(&values, string(data))
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("unknown format, the extension must be .hcl, .yaml, .yml or .json")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// setFlags sets the flags of fs that weren't set to their values.
+func setFlags(fs *flag.FlagSet, values map[string]interface{}) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// Sort the keys so that the errors are the same for the same file.
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var unknown []string
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown flags: %s", strings.Join(unknown, ", "))
+	}
+
+	for _, name := range names {
+		if name == configFileFlag {
+			return fmt.Errorf("-%s can't be set in the file", configFileFlag)
+		}
+		if set[name] {
+			continue
+		}
+		elems, ok := values[name].([]interface{})
+		if !ok {
+			elems = []interface{}{values[name]}
+		}
+		for _, elem := range elems {
+			value, err := flagValue(elem)
+			if err != nil {
+				return fmt.Errorf("invalid value of %s: %s", name, err)
+			}
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid value %q of %s: %s", value, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flagValue returns the flag value of a value of the file.
+func flagValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("must be a string, number, boolean or list of them, not %T", value)
+	}
+}
@@ -0,0 +1,157 @@
+package flags
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigFileFlags_Apply(t *testing.T) {
+	cases := map[string]struct {
+		file   string
+		data   string
+		args   []string
+		exp    testConfigFlags
+		expErr string
+	}{
+		"no file": {
+			exp: testConfigFlags{name: "default", port: 8080},
+		},
+		"hcl": {
+			file: "config.hcl",
+			data: `
+name = "web"
+port = 9090
+enabled = true
+timeout = "5s"
+namespace = ["foo", "bar"]
+`,
+			exp: testConfigFlags{name: "web", port: 9090, enabled: true, timeout: 5 * time.Second,
+				namespaces: []string{"foo", "bar"}},
+		},
+		"yaml": {
+			file: "config.yaml",
+			data: `
+name: web
+port: 9090
+enabled: true
+timeout: 5s
+namespace:
+- foo
+`,
+			exp: testConfigFlags{name: "web", port: 9090, enabled: true, timeout: 5 * time.Second,
+				namespaces: []string{"foo"}},
+		},
+		"json": {
+			file: "config.json",
+			data: `{"name": "web", "port": 9090}`,
+			exp:  testConfigFlags{name: "web", port: 9090},
+		},
+		"flags take precedence": {
+			file: "config.hcl",
+			data: `
+name = "web"
+port = 9090
+namespace = ["foo"]
+`,
+			args: []string{"-name", "api", "-namespace", "baz"},
+			exp:  testConfigFlags{name: "api", port: 9090, namespaces: []string{"baz"}},
+		},
+		"unknown keys": {
+			file:   "config.hcl",
+			data:   "name = \"web\"\nnmae = \"web\"\nprot = 9090\n",
+			expErr: "unknown flags: nmae, prot",
+		},
+		"invalid value": {
+			file:   "config.yaml",
+			data:   "port: eighty\n",
+			expErr: `invalid value "eighty" of port`,
+		},
+		"block": {
+			file:   "config.hcl",
+			data:   "name { value = \"web\" }\n",
+			expErr: "invalid value of name: must be a string, number, boolean or list of them",
+		},
+		"config file": {
+			file:   "config.hcl",
+			data:   "config-file = \"other.hcl\"\n",
+			expErr: "-config-file can't be set in the file",
+		},
+		"unknown format": {
+			file:   "config.toml",
+			data:   "name = \"web\"\n",
+			expErr: "unknown format",
+		},
+		"invalid hcl": {
+			file:   "config.hcl",
+			data:   "name = [\n",
+			expErr: "Error reading -config-file",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var f testConfigFlags
+			configFile := &ConfigFileFlags{}
+			fs := f.flagSet()
+			flags.Merge(fs, configFile.Flags())
+
+			args := c.args
+			if c.file != "" {
+				dir, err := ioutil.TempDir("", "config")
+				require.NoError(t, err)
+				defer os.RemoveAll(dir)
+				path := filepath.Join(dir, c.file)
+				require.NoError(t, ioutil.WriteFile(path, []byte(c.data), 0600))
+				args = append([]string{"-config-file", path}, args...)
+			}
+			require.NoError(t, fs.Parse(args))
+
+			err := configFile.Apply(fs)
+			if c.expErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.exp, f)
+		})
+	}
+}
+
+// Test that the error of an unreadable file has the flag and the path once,
+// so that the commands can report it as is.
+func TestConfigFileFlags_Apply_UnreadableFile(t *testing.T) {
+	var f testConfigFlags
+	configFile := &ConfigFileFlags{}
+	fs := f.flagSet()
+	flags.Merge(fs, configFile.Flags())
+	require.NoError(t, fs.Parse([]string{"-config-file", "/nonexistent/config.hcl"}))
+
+	err := configFile.Apply(fs)
+	require.EqualError(t, err, "Error reading -config-file /nonexistent/config.hcl: "+
+		"open /nonexistent/config.hcl: no such file or directory")
+}
+
+type testConfigFlags struct {
+	name       string
+	port       int
+	enabled    bool
+	timeout    time.Duration
+	namespaces []string
+}
+
+func (f *testConfigFlags) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.StringVar(&f.name, "name", "default", "")
+	fs.IntVar(&f.port, "port", 8080, "")
+	fs.BoolVar(&f.enabled, "enabled", false, "")
+	fs.DurationVar(&f.timeout, "timeout", 0, "")
+	fs.Var((*flags.AppendSliceValue)(&f.namespaces), "namespace", "")
+	return fs
+}
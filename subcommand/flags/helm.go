@@ -0,0 +1,76 @@
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul/command/flags"
+)
+
+// HelmFlags are the flags of the commands installing or upgrading the
+// Consul Helm chart: the chart and its values.
+type HelmFlags struct {
+	chart       string
+	valuesFiles []string
+	set         []string
+}
+
+func (f *HelmFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.StringVar(&f.chart, "chart", "",
+		fmt.Sprintf("Path of a chart archive or directory to use instead of the Consul chart %s "+
+			"embedded in consul-k8s.", helm.ChartVersion))
+	fs.Var((*flags.AppendSliceValue)(&f.valuesFiles), "f",
+		"Path of a values file of the chart. May be specified multiple times, the last file taking precedence.")
+	fs.Var((*flags.AppendSliceValue)(&f.set), "set",
+		"Value of the chart, in the form key=value, e.g. global.name=consul. May be specified multiple times. "+
+			"Takes precedence over the values files.")
+	return fs
+}
+
+func (f *HelmFlags) Chart() string {
+	return f.chart
+}
+
+func (f *HelmFlags) ValuesFiles() []string {
+	return f.valuesFiles
+}
+
+func (f *HelmFlags) Set() []string {
+	return f.set
+}
+
+// ReadValues returns the contents of the values files. It fails if they
+// can't be read or if a -set value isn't of the form key=value, so that
+// commands fail before making changes.
+func (f *HelmFlags) ReadValues() ([]string, error) {
+	for _, value := range f.set {
+		if !strings.Contains(value, "=") {
+			return nil, fmt.Errorf("-set %q must be of the form key=value", value)
+		}
+	}
+	values := make([]string, len(f.valuesFiles))
+	for i, file := range f.valuesFiles {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading values file: %s", err)
+		}
+		values[i] = string(content)
+	}
+	return values, nil
+}
+
+// ReleaseOptions returns the options of the release name in namespace with
+// the chart and values of the flags.
+func (f *HelmFlags) ReleaseOptions(name, namespace string) helm.ReleaseOptions {
+	return helm.ReleaseOptions{
+		Name:        name,
+		Namespace:   namespace,
+		Chart:       f.chart,
+		ValuesFiles: f.valuesFiles,
+		Set:         f.set,
+	}
+}
@@ -0,0 +1,75 @@
+package flags
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/consul-k8s/helper/leader"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+// LeaderElectionFlags are the flags of the commands that can run with
+// several replicas of which only the one holding the leader lock writes to
+// Consul.
+type LeaderElectionFlags struct {
+	// DefaultID and DefaultLockType are the defaults of -leader-election-id
+	// and -leader-election-lock-type.
+	DefaultID       string
+	DefaultLockType string
+
+	enabled   bool
+	namespace string
+	id        string
+	lockType  string
+}
+
+func (f *LeaderElectionFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.BoolVar(&f.enabled, "enable-leader-election", false,
+		"If true, only the replica holding the leader lock writes to Consul, so that the command can "+
+			"run with several replicas that take over within the lease duration of 15s if the leader fails.")
+	fs.StringVar(&f.namespace, "leader-election-namespace", "",
+		"Kubernetes namespace of the leader lock. Required if -enable-leader-election is set.")
+	fs.StringVar(&f.id, "leader-election-id", f.DefaultID,
+		"Name of the leader lock.")
+	fs.StringVar(&f.lockType, "leader-election-lock-type", f.DefaultLockType,
+		fmt.Sprintf("Kind of the leader lock: %q for a coordination.k8s.io/v1 Lease or %q for a ConfigMap.",
+			leader.LeasesLock, leader.ConfigMapsLock))
+	return fs
+}
+
+// Enabled returns whether -enable-leader-election is set.
+func (f *LeaderElectionFlags) Enabled() bool {
+	return f.enabled
+}
+
+// Validate returns an error if the flags are invalid.
+func (f *LeaderElectionFlags) Validate() error {
+	if !f.enabled {
+		return nil
+	}
+	if f.namespace == "" {
+		return errors.New("-leader-election-namespace must be set if -enable-leader-election is set")
+	}
+	if f.lockType != leader.LeasesLock && f.lockType != leader.ConfigMapsLock {
+		return fmt.Errorf("-leader-election-lock-type must be %q or %q", leader.LeasesLock, leader.ConfigMapsLock)
+	}
+	return nil
+}
+
+// Lock returns the leader lock of the flags. The identity of the replica is
+// its hostname, i.e. the name of its pod.
+func (f *LeaderElectionFlags) Lock(kubeClient kubernetes.Interface, recorder record.EventRecorder) (resourcelock.Interface, error) {
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("getting the leader election identity: %s", err)
+	}
+	return leader.NewLock(f.lockType, f.namespace, f.id, kubeClient, resourcelock.ResourceLockConfig{
+		Identity:      identity,
+		EventRecorder: recorder,
+	})
+}
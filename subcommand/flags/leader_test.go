@@ -0,0 +1,44 @@
+package flags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaderElectionFlags_Validate(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		expErr string
+	}{
+		"disabled": {},
+		"leases": {
+			args: []string{"-enable-leader-election", "-leader-election-namespace", "default"},
+		},
+		"configmaps": {
+			args: []string{"-enable-leader-election", "-leader-election-namespace", "default",
+				"-leader-election-lock-type", "configmaps"},
+		},
+		"no namespace": {
+			args:   []string{"-enable-leader-election"},
+			expErr: "-leader-election-namespace must be set if -enable-leader-election is set",
+		},
+		"unknown lock type": {
+			args: []string{"-enable-leader-election", "-leader-election-namespace", "default",
+				"-leader-election-lock-type", "endpoints"},
+			expErr: `-leader-election-lock-type must be "leases" or "configmaps"`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := &LeaderElectionFlags{DefaultID: "leader", DefaultLockType: "leases"}
+			require.NoError(t, f.Flags().Parse(c.args))
+			err := f.Validate()
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
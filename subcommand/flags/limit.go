@@ -0,0 +1,39 @@
+package flags
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/hashicorp/consul-k8s/helper/consul"
+)
+
+// WriteLimitFlags are the flags of the commands writing to Consul to limit
+// the rate of their writes.
+type WriteLimitFlags struct {
+	qps   float64
+	burst int
+}
+
+func (f *WriteLimitFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.Float64Var(&f.qps, "consul-write-qps", 0,
+		"Maximum rate of the writes to Consul, in writes per second, e.g. to protect the servers "+
+			"during a full resync or a mass churn of pods. The writes above it wait for their turn, "+
+			"which consul_k8s_consul_write_queue_depth reports. If 0, the writes aren't limited.")
+	fs.IntVar(&f.burst, "consul-write-burst", 10,
+		"Number of writes to Consul allowed at once above -consul-write-qps.")
+	return fs
+}
+
+// Limit limits the writes of the Consul clients to the rate of the flags.
+// It returns an error if they're invalid.
+func (f *WriteLimitFlags) Limit() error {
+	if f.qps < 0 {
+		return errors.New("-consul-write-qps must not be negative")
+	}
+	if f.qps > 0 && f.burst < 1 {
+		return errors.New("-consul-write-burst must be at least 1 if -consul-write-qps is set")
+	}
+	consul.LimitWrites(f.qps, f.burst)
+	return nil
+}
@@ -0,0 +1,46 @@
+package flags
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// LogFlags are the logging flags of the commands running in the cluster,
+// so that they all log the same way.
+type LogFlags struct {
+	level string
+	json  bool
+}
+
+func (f *LogFlags) Flags() *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.StringVar(&f.level, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+	fs.BoolVar(&f.json, "log-json", false,
+		"If true, the logs are written as JSON objects, one per line, e.g. for log "+
+			"aggregators to filter them on their fields.")
+	return fs
+}
+
+// Level returns the value of -log-level.
+func (f *LogFlags) Level() string {
+	return f.level
+}
+
+// Logger returns the logger of the flags, writing to stderr. It returns an
+// error if -log-level is invalid.
+func (f *LogFlags) Logger() (hclog.Logger, error) {
+	level := hclog.LevelFromString(f.level)
+	if level == hclog.NoLevel {
+		return nil, fmt.Errorf("Unknown log level: %s", f.level)
+	}
+	return hclog.New(&hclog.LoggerOptions{
+		Level:      level,
+		JSONFormat: f.json,
+		Output:     os.Stderr,
+	}), nil
+}
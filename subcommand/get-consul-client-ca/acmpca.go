@@ -0,0 +1,64 @@
+package getconsulclientca
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acmpca"
+	"github.com/aws/aws-sdk-go/service/acmpca/acmpcaiface"
+)
+
+// awsPCACAChain returns the CA certificate chain of the -aws-pca-arn
+// ACM Private CA. It's used when Consul's Connect CA provider is ACM PCA.
+// The chain starts with the private CA's certificate followed by the
+// certificates of its issuers, if any.
+func (c *Command) awsPCACAChain() (string, error) {
+	client, err := c.awsPCAClient()
+	if err != nil {
+		return "", err
+	}
+	out, err := client.GetCertificateAuthorityCertificate(&acmpca.GetCertificateAuthorityCertificateInput{
+		CertificateAuthorityArn: aws.String(c.flagAWSPCAARN),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	caCert := strings.TrimSpace(aws.StringValue(out.Certificate))
+	if caCert == "" {
+		return "", fmt.Errorf("ACM PCA %q has no CA certificate", c.flagAWSPCAARN)
+	}
+	chain := caCert + "\n"
+	if issuers := strings.TrimSpace(aws.StringValue(out.CertificateChain)); issuers != "" {
+		chain += issuers + "\n"
+	}
+	return chain, nil
+}
+
+// awsPCAClient returns the ACM PCA client. The credentials are loaded
+// from AWS's default credential chain, which includes the web identity
+// token that IAM roles for service accounts provide. The region is
+// -aws-region if set and the CA's region otherwise.
+func (c *Command) awsPCAClient() (acmpcaiface.ACMPCAAPI, error) {
+	if c.pcaClient != nil {
+		return c.pcaClient, nil
+	}
+
+	region := c.flagAWSRegion
+	if region == "" {
+		caARN, err := arn.Parse(c.flagAWSPCAARN)
+		if err != nil {
+			return nil, fmt.Errorf("parsing -aws-pca-arn: %s", err)
+		}
+		region = caARN.Region
+	}
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, err
+	}
+	c.pcaClient = acmpca.New(sess)
+	return c.pcaClient, nil
+}
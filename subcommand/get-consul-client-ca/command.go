@@ -4,19 +4,29 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/aws/aws-sdk-go/service/acmpca/acmpcaiface"
 	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul-k8s/helper/acl"
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul-k8s/version"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/command/flags"
 	"github.com/hashicorp/go-discover"
 	discoverk8s "github.com/hashicorp/go-discover/provider/k8s"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
 	"github.com/mitchellh/cli"
+	"k8s.io/client-go/kubernetes"
 )
 
 // get-consul-client-ca command talks to the Consul servers
@@ -25,29 +35,75 @@ import (
 type Command struct {
 	UI cli.Ui
 
-	flags *flag.FlagSet
+	flags      *flag.FlagSet
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+	configFile *k8sflags.ConfigFileFlags
 
 	flagOutputFile      string
-	flagServerAddr      string
+	flagOutputSecret    string
+	flagK8sNamespace    string
+	flagWatch           bool
+	flagServerAddrs     []string
 	flagServerPort      string
 	flagCAFile          string
 	flagTLSServerName   string
 	flagPollingInterval time.Duration
-	flagLogLevel        string
+
+	flagOutputNamespaces        []string
+	flagOutputNamespaceSelector string
+
+	flagVaultAddr           string
+	flagVaultCAFile         string
+	flagVaultNamespace      string
+	flagVaultPKIPath        string
+	flagVaultAuthMethodPath string
+	flagVaultRole           string
+	flagVaultTokenFile      string
+
+	flagAWSPCAARN string
+	flagAWSRegion string
 
 	once sync.Once
 	help string
 
 	providers map[string]discover.Provider
+
+	// clientset is only used if -output-secret is set.
+	// It can be set by tests.
+	clientset kubernetes.Interface
+
+	// pcaClient is only used if -aws-pca-arn is set.
+	// It can be set by tests.
+	pcaClient acmpcaiface.ACMPCAAPI
+
+	// sigCh receives a signal when the command should stop watching.
+	sigCh chan os.Signal
 }
 
 func (c *Command) init() {
 	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
 	c.flags.StringVar(&c.flagOutputFile, "output-file", "",
 		"The file path for writing the Consul client's CA certificate.")
-	c.flags.StringVar(&c.flagServerAddr, "server-addr", "",
-		"The address of the Consul server or the cloud auto-join string. The server must be running with TLS enabled. "+
-			"This value is required.")
+	c.flags.StringVar(&c.flagOutputSecret, "output-secret", "",
+		"The name of the Kubernetes secret to write the Consul client's CA certificate to. "+
+			"The certificate is stored under the \""+secretKey+"\" key.")
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"The Kubernetes namespace of the secret set by -output-secret.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagOutputNamespaces), "output-namespace",
+		"Additional Kubernetes namespace to write the secret set by -output-secret to, e.g. namespaces "+
+			"running meshed workloads or webhooks. May be specified multiple times.")
+	c.flags.StringVar(&c.flagOutputNamespaceSelector, "output-namespace-selector", "",
+		"Label selector of additional Kubernetes namespaces to write the secret set by -output-secret to. "+
+			"The namespaces are listed whenever the secret is written.")
+	c.flags.BoolVar(&c.flagWatch, "watch", false,
+		"Keep running and update the outputs whenever the active root CA changes. "+
+			"If false, the command exits once the CA certificate has been written.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagServerAddrs), "server-addr",
+		"The address of a Consul server or a cloud auto-join string, like the agent's -retry-join. The servers must be running with TLS enabled. "+
+			"May be specified multiple times. The servers are tried in order, with all servers discovered by a cloud auto-join string "+
+			"tried in the order they are discovered. Addresses without a port use -server-port. "+
+			"This value is required unless the CA is retrieved from Vault or ACM Private CA.")
 	c.flags.StringVar(&c.flagServerPort, "server-port", "443", "The HTTPS port of the Consul server.")
 	c.flags.StringVar(&c.flagCAFile, "ca-file", "",
 		"The path to the CA file to use when making requests to the Consul server. This can also be provided via the CONSUL_CACERT environment variable instead if preferred. "+
@@ -55,11 +111,45 @@ func (c *Command) init() {
 	c.flags.StringVar(&c.flagTLSServerName, "tls-server-name", "",
 		"The server name to set as the SNI header when sending HTTPS requests to Consul. This can also be provided via the CONSUL_TLS_SERVER_NAME environment variable instead if preferred. "+
 			"If both values are present, the flag value will be used.")
-	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
-		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
-			"\"debug\", \"info\", \"warn\", and \"error\".")
-
+	c.flags.DurationVar(&c.flagPollingInterval, "polling-interval", 1*time.Minute,
+		"How often to check for CA changes in -watch mode if the CA is retrieved from a provider "+
+			"that doesn't support blocking queries, e.g. Vault or ACM Private CA.")
+	c.flags.StringVar(&c.flagVaultAddr, "vault-addr", "",
+		"The address of Vault. If set, the CA chain is retrieved from the Vault PKI secrets engine "+
+			"set by -vault-pki-path instead of the Consul servers. Use this if Consul's Connect CA provider is Vault.")
+	c.flags.StringVar(&c.flagVaultCAFile, "vault-ca-file", "",
+		"The path to the CA file to use when making requests to Vault.")
+	c.flags.StringVar(&c.flagVaultNamespace, "vault-namespace", "",
+		"The Vault Enterprise namespace of the PKI secrets engine and the auth method.")
+	c.flags.StringVar(&c.flagVaultPKIPath, "vault-pki-path", "",
+		"The path of the Vault PKI secrets engine Consul's Connect CA uses, e.g. its intermediate_pki_path.")
+	c.flags.StringVar(&c.flagVaultAuthMethodPath, "vault-auth-method-path", "kubernetes",
+		"The path of the Vault Kubernetes auth method.")
+	c.flags.StringVar(&c.flagVaultRole, "vault-role", "",
+		"The Vault Kubernetes auth method role to log in with.")
+	c.flags.StringVar(&c.flagVaultTokenFile, "vault-token-file", acl.DefaultBearerTokenFile,
+		"The path to the service account token to log in to Vault with.")
+	c.flags.StringVar(&c.flagAWSPCAARN, "aws-pca-arn", "",
+		"The ARN of the ACM Private CA. If set, the CA chain is retrieved from ACM Private CA "+
+			"instead of the Consul servers. Use this if Consul's Connect CA provider is ACM Private CA. "+
+			"AWS credentials are loaded from the default credential chain, e.g. IAM roles for service accounts.")
+	c.flags.StringVar(&c.flagAWSRegion, "aws-region", "",
+		"The AWS region of the ACM Private CA. Defaults to the region of -aws-pca-arn.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
 	c.help = flags.Usage(help, c.flags)
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
 }
 
 func (c *Command) Run(args []string) int {
@@ -67,44 +157,79 @@ func (c *Command) Run(args []string) int {
 	if err := c.flags.Parse(args); err != nil {
 		return 1
 	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 	if len(c.flags.Args()) > 0 {
 		c.UI.Error(fmt.Sprintf("Should have no non-flag arguments."))
 		return 1
 	}
 
-	if c.flagOutputFile == "" {
-		c.UI.Error(fmt.Sprintf("-output-file must be set"))
+	if c.flagOutputFile == "" && c.flagOutputSecret == "" {
+		c.UI.Error(fmt.Sprintf("-output-file or -output-secret must be set"))
 		return 1
 	}
 
-	if c.flagServerAddr == "" {
+	if c.flagOutputSecret == "" && (len(c.flagOutputNamespaces) > 0 || c.flagOutputNamespaceSelector != "") {
+		c.UI.Error(fmt.Sprintf("-output-secret must be set if -output-namespace or -output-namespace-selector is set"))
+		return 1
+	}
+
+	if c.flagVaultAddr != "" && c.flagAWSPCAARN != "" {
+		c.UI.Error(fmt.Sprintf("-vault-addr and -aws-pca-arn cannot both be set"))
+		return 1
+	}
+	if c.flagVaultAddr != "" {
+		if c.flagVaultPKIPath == "" || c.flagVaultRole == "" {
+			c.UI.Error(fmt.Sprintf("-vault-pki-path and -vault-role must be set if -vault-addr is set"))
+			return 1
+		}
+	} else if len(c.flagServerAddrs) == 0 && c.flagAWSPCAARN == "" {
 		c.UI.Error(fmt.Sprintf("-server-addr must be set"))
 		return 1
 	}
 
-	// create a logger
-	level := hclog.LevelFromString(c.flagLogLevel)
-	if level == hclog.NoLevel {
-		c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
+	if c.flagWatch && c.flagPollingInterval <= 0 {
+		c.UI.Error(fmt.Sprintf("-polling-interval must be greater than 0"))
 		return 1
 	}
-	logger := hclog.New(&hclog.LoggerOptions{
-		Level:  level,
-		Output: os.Stderr,
-	})
 
-	// create Consul client
-	consulClient, err := c.consulClient(logger)
+	// create a logger
+	logger, err := c.logging.Logger()
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+		c.UI.Error(err.Error())
 		return 1
 	}
 
+	// Create the Kubernetes clientset
+	if c.flagOutputSecret != "" && c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	if c.flagVaultAddr != "" {
+		return c.runProvider(logger.Named("vault"), c.vaultCAChain)
+	}
+	if c.flagAWSPCAARN != "" {
+		return c.runProvider(logger.Named("aws-pca"), c.awsPCACAChain)
+	}
+
 	// Get the active CA root from Consul
 	// Wait until it gets a successful response
+	var consulClient *api.Client
 	var activeRoot string
+	var index uint64
 	backoff.Retry(func() error {
-		caRoots, _, err := consulClient.Agent().ConnectCARoots(nil)
+		client, caRoots, meta, err := c.connect(logger)
 		if err != nil {
 			logger.Error("Error retrieving CA roots from Consul", "err", err)
 			return err
@@ -116,21 +241,96 @@ func (c *Command) Run(args []string) int {
 			return err
 		}
 
+		consulClient = client
+		index = meta.LastIndex
 		return nil
 	}, backoff.NewConstantBackOff(1*time.Second))
 
-	err = ioutil.WriteFile(c.flagOutputFile, []byte(activeRoot), 0644)
-	if err != nil {
-		c.UI.Error(fmt.Sprintf("Error writing CA file: %s", err))
+	if err := c.writeOutputs(activeRoot); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if !c.flagWatch {
+		return 0
+	}
+	c.watch(logger, consulClient, activeRoot, index)
+	return 0
+}
+
+// runProvider is Run for CA providers other than the Consul servers. It
+// retrieves the CA certificate with fetch until it succeeds and writes it
+// to the outputs. In -watch mode, it then polls for changes.
+func (c *Command) runProvider(logger hclog.Logger, fetch func() (string, error)) int {
+	var activeRoot string
+	backoff.Retry(func() error {
+		var err error
+		activeRoot, err = fetch()
+		if err != nil {
+			logger.Error("Error retrieving CA certificate", "err", err)
+		}
+		return err
+	}, backoff.NewConstantBackOff(1*time.Second))
+
+	if err := c.writeOutputs(activeRoot); err != nil {
+		c.UI.Error(err.Error())
 		return 1
 	}
 
-	c.UI.Info(fmt.Sprintf("Successfully wrote Consul client CA to: %s", c.flagOutputFile))
+	if !c.flagWatch {
+		return 0
+	}
+	c.pollCA(logger, fetch, activeRoot)
 	return 0
 }
 
-// consulClient returns a Consul API client.
-func (c *Command) consulClient(logger hclog.Logger) (*api.Client, error) {
+// writeOutputs writes the CA certificate to the file set by -output-file
+// and to the secret set by -output-secret.
+func (c *Command) writeOutputs(activeRoot string) error {
+	if c.flagOutputFile != "" {
+		err := ioutil.WriteFile(c.flagOutputFile, []byte(activeRoot), 0644)
+		if err != nil {
+			return fmt.Errorf("Error writing CA file: %s", err)
+		}
+		c.UI.Info(fmt.Sprintf("Successfully wrote Consul client CA to: %s", c.flagOutputFile))
+	}
+	if c.flagOutputSecret != "" {
+		if err := c.writeSecret(activeRoot); err != nil {
+			return fmt.Errorf("Error writing CA secret: %s", err)
+		}
+		c.UI.Info(fmt.Sprintf("Successfully wrote Consul client CA to secret: %s", c.flagOutputSecret))
+	}
+	return nil
+}
+
+// connect returns a client for the first Consul server from -server-addr
+// that responds, along with the CA roots it returned. The server addresses are
+// resolved again on every call so that servers with changing IPs are found.
+func (c *Command) connect(logger hclog.Logger) (*api.Client, *api.CARootList, *api.QueryMeta, error) {
+	addrs, err := c.consulServerAddrs(logger)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var result error
+	for _, addr := range addrs {
+		client, err := c.consulClient(addr)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("initializing Consul client: %s", err)
+		}
+		caRoots, meta, err := client.Agent().ConnectCARoots(nil)
+		if err != nil {
+			logger.Debug("Error retrieving CA roots from server", "server", addr, "err", err)
+			result = multierror.Append(result, fmt.Errorf("%s: %s", addr, err))
+			continue
+		}
+		return client, caRoots, meta, nil
+	}
+	return nil, nil, nil, result
+}
+
+// consulClient returns a Consul API client for the server at addr.
+func (c *Command) consulClient(addr string) (*api.Client, error) {
 	// Create default Consul config.
 	// This will also read any environment variables.
 	cfg := api.DefaultConfig()
@@ -138,14 +338,7 @@ func (c *Command) consulClient(logger hclog.Logger) (*api.Client, error) {
 	// change the scheme to HTTPS
 	// since we don't want to send unencrypted requests
 	cfg.Scheme = "https"
-
-	addr, err := c.consulServerAddr(logger)
-	if err != nil {
-		return nil, err
-	}
-	if addr != "" {
-		cfg.Address = addr
-	}
+	cfg.Address = addr
 
 	// Set the CA file and TLS server name if the flag is provided.
 	// This will overwrite any env variables values for these flags.
@@ -156,52 +349,66 @@ func (c *Command) consulClient(logger hclog.Logger) (*api.Client, error) {
 		cfg.TLSConfig.Address = c.flagTLSServerName
 	}
 
-	return api.NewClient(cfg)
+	return consul.NewClient(cfg)
 }
 
-// consulServerAddr returns the consul server address
-// as a string in the <server_ip_or_dns_name>:<server_port> format.
+// consulServerAddrs returns the consul server addresses
+// in the <server_ip_or_dns_name>:<server_port> format
+// in the order of the -server-addr flags.
 //
-// 1. If the server address is a cloud auto-join URL,
-//    it calls go-discover library to discover server addresses,
-//    picks the first address from the list and uses the provided port.
-// 2. Otherwise, it uses the address provided by the -server-addr
-//    and the -server-port flags.
-func (c *Command) consulServerAddr(logger hclog.Logger) (string, error) {
-
-	// First, check if the server address is a cloud auto-join string.
-	// If not, return serverAddr:serverPort set by the provided flags.
-	if !strings.Contains(c.flagServerAddr, "provider=") {
-		return fmt.Sprintf("%s:%s", c.flagServerAddr, c.flagServerPort), nil
-	}
+// 1. If a server address is a cloud auto-join URL,
+//    it calls go-discover library to discover server addresses
+//    and uses the provided port for each of them.
+// 2. Otherwise, it uses the address and its port, if it has one,
+//    or the -server-port flag.
+func (c *Command) consulServerAddrs(logger hclog.Logger) ([]string, error) {
+	var addrs []string
+	for _, serverAddr := range c.flagServerAddrs {
+		// First, check if the server address is a cloud auto-join string.
+		// If not, use it with the -server-port if it doesn't have a port.
+		if !strings.Contains(serverAddr, "provider=") {
+			if _, _, err := net.SplitHostPort(serverAddr); err == nil {
+				addrs = append(addrs, serverAddr)
+			} else {
+				addrs = append(addrs, net.JoinHostPort(serverAddr, c.flagServerPort))
+			}
+			continue
+		}
 
-	// If it's a cloud-auto join string, discover server addresses through the cloud provider.
-	// This code was adapted from
-	// https://github.com/hashicorp/consul/blob/c5fe112e59f6e8b03159ec8f2dbe7f4a026ce823/agent/retry_join.go#L55-L89.
-	disco, err := c.newDiscover()
-	if err != nil {
-		return "", err
-	}
-	logger.Debug("using cloud auto-join", "server-addr", c.flagServerAddr)
-	servers, err := disco.Addrs(c.flagServerAddr, logger.StandardLogger(&hclog.StandardLoggerOptions{
-		InferLevels: true,
-	}))
-	if err != nil {
-		return "", err
+		// If it's a cloud-auto join string, discover server addresses through the cloud provider.
+		// This code was adapted from
+		// https://github.com/hashicorp/consul/blob/c5fe112e59f6e8b03159ec8f2dbe7f4a026ce823/agent/retry_join.go#L55-L89.
+		disco, err := c.newDiscover()
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("using cloud auto-join", "server-addr", serverAddr)
+		servers, err := disco.Addrs(serverAddr, logger.StandardLogger(&hclog.StandardLoggerOptions{
+			InferLevels: true,
+		}))
+		if err != nil {
+			// Other servers may still be reachable.
+			logger.Error("Error discovering servers", "server-addr", serverAddr, "err", err)
+			continue
+		}
+		logger.Debug("discovered servers", "servers", strings.Join(servers, " "))
+
+		// Use the provided port for every server,
+		// ignoring the port since we need to use HTTP API
+		// and don't care about the RPC port.
+		for _, server := range servers {
+			if host, _, err := net.SplitHostPort(server); err == nil {
+				server = host
+			}
+			addrs = append(addrs, net.JoinHostPort(server, c.flagServerPort))
+		}
 	}
 
-	// check if we discovered any servers
-	if len(servers) == 0 {
-		return "", fmt.Errorf("could not discover any Consul servers with %q", c.flagServerAddr)
+	// check if we found any servers
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("could not discover any Consul servers with %q", strings.Join(c.flagServerAddrs, " "))
 	}
-
-	logger.Debug("discovered servers", "servers", strings.Join(servers, " "))
-
-	// Pick the first server from the list,
-	// ignoring the port since we need to use HTTP API
-	// and don't care about the RPC port.
-	firstServer := strings.SplitN(servers[0], ":", 2)[0]
-	return fmt.Sprintf("%s:%s", firstServer, c.flagServerPort), nil
+	return addrs, nil
 }
 
 // newDiscover initializes the new Discover object
@@ -258,6 +465,15 @@ const help = `
 Usage: consul-k8s get-consul-client-ca [options]
 
   Retrieve Consul client CA certificate by continuously polling
-  Consul servers and save it at the provided file location.
+  Consul servers and save it at the provided file location
+  and/or in the provided Kubernetes secret.
+
+  If -watch is set, the command keeps running and updates the
+  certificate whenever the active root CA changes.
+
+  If -vault-addr is set, the CA chain is retrieved from the Vault
+  PKI secrets engine set by -vault-pki-path instead, after logging
+  in with the Kubernetes auth method. If -aws-pca-arn is set, the
+  CA chain is retrieved from ACM Private CA.
 
 `
@@ -1,23 +1,35 @@
 package getconsulclientca
 
 import (
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/acmpca"
+	"github.com/aws/aws-sdk-go/service/acmpca/acmpcaiface"
 	"github.com/hashicorp/consul-k8s/helper/cert"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/freeport"
 	"github.com/hashicorp/consul/sdk/testutil"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-discover"
+	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestRun_FlagsValidation(t *testing.T) {
@@ -28,7 +40,7 @@ func TestRun_FlagsValidation(t *testing.T) {
 	}{
 		{
 			flags:  []string{},
-			expErr: "-output-file must be set",
+			expErr: "-output-file or -output-secret must be set",
 		},
 		{
 			flags: []string{
@@ -44,6 +56,38 @@ func TestRun_FlagsValidation(t *testing.T) {
 			},
 			expErr: "Unknown log level: invalid-log-level",
 		},
+		{
+			flags: []string{
+				"-output-file=output.pem",
+				"-vault-addr=https://vault:8200",
+			},
+			expErr: "-vault-pki-path and -vault-role must be set if -vault-addr is set",
+		},
+		{
+			flags: []string{
+				"-output-file=output.pem",
+				"-server-addr=foo.com",
+				"-watch",
+				"-polling-interval=0s",
+			},
+			expErr: "-polling-interval must be greater than 0",
+		},
+		{
+			flags: []string{
+				"-output-file=output.pem",
+				"-vault-addr=https://vault:8200",
+				"-aws-pca-arn=arn:aws:acm-pca:us-west-2:123456789012:certificate-authority/abc",
+			},
+			expErr: "-vault-addr and -aws-pca-arn cannot both be set",
+		},
+		{
+			flags: []string{
+				"-output-file=output.pem",
+				"-server-addr=foo.com",
+				"-output-namespace=apps",
+			},
+			expErr: "-output-secret must be set if -output-namespace or -output-namespace-selector is set",
+		},
 	}
 
 	for _, c := range cases {
@@ -363,6 +407,525 @@ func TestRun_WithProvider(t *testing.T) {
 	require.Equal(t, expectedCARoot, string(actualCARoot))
 }
 
+// Test that the servers are tried in order until one responds.
+func TestRun_TriesServersInOrder(t *testing.T) {
+	t.Parallel()
+	consulServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(api.CARootList{
+			Roots: []*api.CARoot{{ID: "root", RootCertPEM: "root", Active: true}},
+		})
+	}))
+	defer consulServer.Close()
+
+	caFile, err := ioutil.TempFile("", "ca")
+	require.NoError(t, err)
+	defer os.Remove(caFile.Name())
+	err = pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: consulServer.Certificate().Raw})
+	require.NoError(t, err)
+	outputFile, err := ioutil.TempFile("", "ca")
+	require.NoError(t, err)
+	defer os.Remove(outputFile.Name())
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+	}
+	unavailablePort := freeport.MustTake(1)[0]
+	exitCode := cmd.Run([]string{
+		"-server-addr", fmt.Sprintf("127.0.0.1:%d", unavailablePort),
+		"-server-addr", strings.TrimPrefix(consulServer.URL, "https://"),
+		"-ca-file", caFile.Name(),
+		"-output-file", outputFile.Name(),
+	})
+	require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+	actualCARoot, err := ioutil.ReadFile(outputFile.Name())
+	require.NoError(t, err)
+	require.Equal(t, "root", string(actualCARoot))
+}
+
+func TestConsulServerAddrs(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		serverAddrs []string
+		expAddrs    []string
+		expErr      string
+	}{
+		"address without port": {
+			serverAddrs: []string{"consul.example.com"},
+			expAddrs:    []string{"consul.example.com:8501"},
+		},
+		"address with port": {
+			serverAddrs: []string{"consul.example.com:443"},
+			expAddrs:    []string{"consul.example.com:443"},
+		},
+		"IPv6 address": {
+			serverAddrs: []string{"::1"},
+			expAddrs:    []string{"[::1]:8501"},
+		},
+		"cloud auto-join": {
+			serverAddrs: []string{"provider=fake address=10.0.0.1:8300"},
+			expAddrs:    []string{"10.0.0.1:8501"},
+		},
+		"multiple addresses in order": {
+			serverAddrs: []string{"consul.example.com", "provider=fake address=10.0.0.1", "10.0.0.2"},
+			expAddrs:    []string{"consul.example.com:8501", "10.0.0.1:8501", "10.0.0.2:8501"},
+		},
+		"no servers discovered": {
+			serverAddrs: []string{"provider=fake"},
+			expErr:      `could not discover any Consul servers with "provider=fake"`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			cmd := Command{
+				flagServerAddrs: c.serverAddrs,
+				flagServerPort:  "8501",
+				providers:       map[string]discover.Provider{"fake": &fakeProvider{}},
+			}
+			addrs, err := cmd.consulServerAddrs(hclog.NewNullLogger())
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expAddrs, addrs)
+		})
+	}
+}
+
+// Test that in watch mode we write the CA to the output secret
+// and update it when the active root changes.
+func TestRun_WatchUpdatesSecret(t *testing.T) {
+	t.Parallel()
+
+	// Fake the CA roots endpoint. Blocking queries return once the
+	// active root is rotated.
+	var lock sync.Mutex
+	activeRoot := "root-1"
+	var index uint64 = 1
+	rotated := make(chan struct{})
+	consulServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/agent/connect/ca/roots" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		lock.Lock()
+		blocking := waitIndex >= index
+		lock.Unlock()
+		if blocking {
+			select {
+			case <-rotated:
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+		json.NewEncoder(w).Encode(api.CARootList{
+			Roots: []*api.CARoot{
+				{ID: "inactive", RootCertPEM: "inactive-root"},
+				{ID: activeRoot, RootCertPEM: activeRoot, Active: true},
+			},
+		})
+	}))
+	defer consulServer.Close()
+
+	caFile, err := ioutil.TempFile("", "ca")
+	require.NoError(t, err)
+	defer os.Remove(caFile.Name())
+	err = pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: consulServer.Certificate().Raw})
+	require.NoError(t, err)
+
+	ui := cli.NewMockUi()
+	k8s := fake.NewSimpleClientset()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	cmd.once.Do(cmd.init)
+
+	serverURL := strings.TrimPrefix(consulServer.URL, "https://")
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-server-addr", strings.Split(serverURL, ":")[0],
+			"-server-port", strings.Split(serverURL, ":")[1],
+			"-ca-file", caFile.Name(),
+			"-output-secret", "consul-client-ca",
+			"-k8s-namespace", "default",
+			"-watch",
+		})
+	}()
+
+	requireSecret := func(r *retry.R, expected string) {
+		secret, err := k8s.CoreV1().Secrets("default").Get("consul-client-ca", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.Equal(r, expected, string(secret.Data[secretKey]))
+	}
+	retry.Run(t, func(r *retry.R) {
+		requireSecret(r, "root-1")
+	})
+
+	lock.Lock()
+	activeRoot = "root-2"
+	index = 2
+	lock.Unlock()
+	close(rotated)
+
+	retry.Run(t, func(r *retry.R) {
+		requireSecret(r, "root-2")
+	})
+
+	cmd.sigCh <- os.Interrupt
+	select {
+	case exitCode := <-exitCh:
+		require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not exit after interrupt")
+	}
+}
+
+func TestWriteSecret(t *testing.T) {
+	t.Parallel()
+	cases := map[string]*apiv1.Secret{
+		"secret does not exist": nil,
+		"secret exists": {
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "consul-client-ca",
+				Namespace: "default",
+			},
+			Data: map[string][]byte{
+				secretKey: []byte("old-root"),
+				"other":   []byte("value"),
+			},
+		},
+	}
+
+	for name, existing := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8s := fake.NewSimpleClientset()
+			if existing != nil {
+				_, err := k8s.CoreV1().Secrets("default").Create(existing)
+				require.NoError(t, err)
+			}
+			cmd := Command{
+				clientset:        k8s,
+				flagOutputSecret: "consul-client-ca",
+				flagK8sNamespace: "default",
+			}
+
+			require.NoError(t, cmd.writeSecret("new-root"))
+			secret, err := k8s.CoreV1().Secrets("default").Get("consul-client-ca", metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, "new-root", string(secret.Data[secretKey]))
+			if existing != nil {
+				require.Equal(t, "value", string(secret.Data["other"]))
+			}
+		})
+	}
+}
+
+func TestRun_Vault(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		chain    string
+		ca       string
+		expected string
+	}{
+		"intermediate PKI returns its chain": {
+			chain:    "intermediate\nroot",
+			ca:       "intermediate",
+			expected: "intermediate\nroot\n",
+		},
+		"root PKI has no chain": {
+			chain:    "",
+			ca:       "root\n",
+			expected: "root\n",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			vault := newFakeVault(t, c.chain, c.ca)
+			defer vault.server.Close()
+
+			outputFile, err := ioutil.TempFile("", "ca")
+			require.NoError(t, err)
+			defer os.Remove(outputFile.Name())
+
+			vaultFlags, cleanup := vault.flags(t)
+			defer cleanup()
+
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			exitCode := cmd.Run(append(vaultFlags,
+				"-output-file", outputFile.Name(),
+			))
+			require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+			actual, err := ioutil.ReadFile(outputFile.Name())
+			require.NoError(t, err)
+			require.Equal(t, c.expected, string(actual))
+		})
+	}
+}
+
+// Test that in watch mode we poll Vault for changes of the CA chain.
+func TestRun_VaultWatch(t *testing.T) {
+	t.Parallel()
+	vault := newFakeVault(t, "intermediate-1\nroot\n", "intermediate-1\n")
+	defer vault.server.Close()
+
+	ui := cli.NewMockUi()
+	k8s := fake.NewSimpleClientset()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	cmd.once.Do(cmd.init)
+
+	vaultFlags, cleanup := vault.flags(t)
+	defer cleanup()
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run(append(vaultFlags,
+			"-output-secret", "consul-client-ca",
+			"-k8s-namespace", "default",
+			"-watch",
+			"-polling-interval", "10ms",
+		))
+	}()
+
+	requireSecret := func(r *retry.R, expected string) {
+		secret, err := k8s.CoreV1().Secrets("default").Get("consul-client-ca", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.Equal(r, expected, string(secret.Data[secretKey]))
+	}
+	retry.Run(t, func(r *retry.R) {
+		requireSecret(r, "intermediate-1\nroot\n")
+	})
+
+	vault.setChain("intermediate-2\nroot\n")
+	retry.Run(t, func(r *retry.R) {
+		requireSecret(r, "intermediate-2\nroot\n")
+	})
+
+	cmd.sigCh <- os.Interrupt
+	select {
+	case exitCode := <-exitCh:
+		require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not exit after interrupt")
+	}
+}
+
+func TestRun_AWSPCA(t *testing.T) {
+	t.Parallel()
+	const caARN = "arn:aws:acm-pca:us-west-2:123456789012:certificate-authority/abc"
+	cases := map[string]struct {
+		certificate *string
+		chain       *string
+		expected    string
+	}{
+		"root CA": {
+			certificate: aws.String("root"),
+			chain:       nil,
+			expected:    "root\n",
+		},
+		"subordinate CA": {
+			certificate: aws.String("subordinate\n"),
+			chain:       aws.String("intermediate\nroot\n"),
+			expected:    "subordinate\nintermediate\nroot\n",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			outputFile, err := ioutil.TempFile("", "ca")
+			require.NoError(t, err)
+			defer os.Remove(outputFile.Name())
+
+			pca := &fakePCA{arn: caARN, certificate: c.certificate, chain: c.chain}
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				pcaClient: pca,
+			}
+			exitCode := cmd.Run([]string{
+				"-aws-pca-arn", caARN,
+				"-output-file", outputFile.Name(),
+			})
+			require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+
+			actual, err := ioutil.ReadFile(outputFile.Name())
+			require.NoError(t, err)
+			require.Equal(t, c.expected, string(actual))
+		})
+	}
+}
+
+// fakePCA fakes the ACM PCA API for a single CA.
+type fakePCA struct {
+	acmpcaiface.ACMPCAAPI
+
+	arn         string
+	certificate *string
+	chain       *string
+}
+
+func (p *fakePCA) GetCertificateAuthorityCertificate(input *acmpca.GetCertificateAuthorityCertificateInput) (*acmpca.GetCertificateAuthorityCertificateOutput, error) {
+	if aws.StringValue(input.CertificateAuthorityArn) != p.arn {
+		return nil, fmt.Errorf("unknown CA %q", aws.StringValue(input.CertificateAuthorityArn))
+	}
+	return &acmpca.GetCertificateAuthorityCertificateOutput{
+		Certificate:      p.certificate,
+		CertificateChain: p.chain,
+	}, nil
+}
+
+// fakeVault fakes the Vault Kubernetes auth method
+// and PKI secrets engine endpoints.
+type fakeVault struct {
+	server    *httptest.Server
+	tokenFile string
+
+	lock  sync.Mutex
+	chain string
+	ca    string
+}
+
+func newFakeVault(t *testing.T, chain, ca string) *fakeVault {
+	v := &fakeVault{chain: chain, ca: ca}
+	v.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if r.Method != http.MethodPost || body["role"] != "consul-client" || body["jwt"] != "service-account-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"auth": {"client_token": "vault-token"}}`)
+		case "/v1/connect-intermediate/cert/ca_chain", "/v1/connect-intermediate/cert/ca":
+			if r.Header.Get("X-Vault-Token") != "vault-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			v.lock.Lock()
+			cert := v.ca
+			if strings.HasSuffix(r.URL.Path, "ca_chain") {
+				cert = v.chain
+			}
+			v.lock.Unlock()
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]string{"certificate": cert},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return v
+}
+
+func (v *fakeVault) setChain(chain string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.chain = chain
+}
+
+// flags returns the command's flags to use the fake Vault.
+// It writes the Vault server's CA and the service account token
+// to temporary files. Note that it's the responsibility of the caller
+// to remove them by calling the returned function.
+func (v *fakeVault) flags(t *testing.T) ([]string, func()) {
+	caFile, err := ioutil.TempFile("", "vault-ca")
+	require.NoError(t, err)
+	err = pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: v.server.Certificate().Raw})
+	require.NoError(t, err)
+	tokenFile, err := ioutil.TempFile("", "token")
+	require.NoError(t, err)
+	_, err = tokenFile.WriteString("service-account-token\n")
+	require.NoError(t, err)
+	cleanupFunc := func() {
+		os.Remove(caFile.Name())
+		os.Remove(tokenFile.Name())
+	}
+
+	return []string{
+		"-vault-addr", v.server.URL,
+		"-vault-ca-file", caFile.Name(),
+		"-vault-pki-path", "connect-intermediate",
+		"-vault-role", "consul-client",
+		"-vault-token-file", tokenFile.Name(),
+	}, cleanupFunc
+}
+
+// Test that the secret is replicated to the -output-namespace
+// namespaces and the namespaces matching -output-namespace-selector.
+func TestWriteSecret_OutputNamespaces(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		k8sNamespace  string
+		namespaces    []string
+		selector      string
+		expNamespaces []string
+	}{
+		"only -k8s-namespace": {
+			k8sNamespace:  "default",
+			expNamespaces: []string{"default"},
+		},
+		"-output-namespace": {
+			k8sNamespace:  "default",
+			namespaces:    []string{"apps", "default", "webhooks"},
+			expNamespaces: []string{"default", "apps", "webhooks"},
+		},
+		"-output-namespace-selector": {
+			selector:      "mesh=true",
+			expNamespaces: []string{"mesh-a", "mesh-b"},
+		},
+		"all": {
+			k8sNamespace:  "default",
+			namespaces:    []string{"apps"},
+			selector:      "mesh=true",
+			expNamespaces: []string{"default", "apps", "mesh-a", "mesh-b"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8s := fake.NewSimpleClientset(
+				&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "mesh-a", Labels: map[string]string{"mesh": "true"}}},
+				&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "mesh-b", Labels: map[string]string{"mesh": "true"}}},
+				&apiv1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+			)
+			cmd := Command{
+				clientset:                   k8s,
+				flagOutputSecret:            "consul-client-ca",
+				flagK8sNamespace:            c.k8sNamespace,
+				flagOutputNamespaces:        c.namespaces,
+				flagOutputNamespaceSelector: c.selector,
+			}
+
+			namespaces, err := cmd.outputNamespaces()
+			require.NoError(t, err)
+			require.ElementsMatch(t, c.expNamespaces, namespaces)
+
+			require.NoError(t, cmd.writeSecret("root"))
+			for _, ns := range c.expNamespaces {
+				secret, err := k8s.CoreV1().Secrets(ns).Get("consul-client-ca", metav1.GetOptions{})
+				require.NoError(t, err, ns)
+				require.Equal(t, "root", string(secret.Data[secretKey]))
+			}
+			_, err = k8s.CoreV1().Secrets("other").Get("consul-client-ca", metav1.GetOptions{})
+			require.Error(t, err)
+		})
+	}
+}
+
 // generateCA generates Consul CA
 // and returns cert and key as pem strings.
 func generateCA(t *testing.T) (caPem, keyPem string) {
@@ -424,6 +987,9 @@ type fakeProvider struct {
 
 func (p *fakeProvider) Addrs(args map[string]string, l *log.Logger) ([]string, error) {
 	p.addrsNumCalls++
+	if args["address"] == "" {
+		return nil, nil
+	}
 	return []string{args["address"]}, nil
 }
 
@@ -0,0 +1,142 @@
+package getconsulclientca
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/fips"
+)
+
+// vaultCAChain returns the CA certificate chain of the -vault-pki-path
+// secrets engine. It's used when Consul's Connect CA provider is Vault since
+// the agents then trust the Vault PKI's chain. If the PKI is an intermediate,
+// the chain includes the intermediate and its issuers. If it's a root, which
+// has no chain, its CA certificate is returned.
+func (c *Command) vaultCAChain() (string, error) {
+	client, err := c.vaultHTTPClient()
+	if err != nil {
+		return "", err
+	}
+	token, err := c.vaultLogin(client)
+	if err != nil {
+		return "", fmt.Errorf("logging in to Vault: %s", err)
+	}
+
+	chain, err := c.vaultPKICert(client, token, "ca_chain")
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(chain) == "" {
+		chain, err = c.vaultPKICert(client, token, "ca")
+		if err != nil {
+			return "", err
+		}
+	}
+	if strings.TrimSpace(chain) == "" {
+		return "", fmt.Errorf("Vault PKI %q has no CA certificate", c.flagVaultPKIPath)
+	}
+	if !strings.HasSuffix(chain, "\n") {
+		chain += "\n"
+	}
+	return chain, nil
+}
+
+// vaultLogin logs in to Vault with the Kubernetes auth method using the
+// service account token in -vault-token-file and returns the client token.
+func (c *Command) vaultLogin(client *http.Client) (string, error) {
+	jwt, err := ioutil.ReadFile(c.flagVaultTokenFile)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string]string{
+		"role": c.flagVaultRole,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		Auth *struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	path := fmt.Sprintf("auth/%s/login", strings.Trim(c.flagVaultAuthMethodPath, "/"))
+	if err := c.vaultRequest(client, http.MethodPost, path, "", body, &resp); err != nil {
+		return "", err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", fmt.Errorf("login response has no client token")
+	}
+	return resp.Auth.ClientToken, nil
+}
+
+// vaultPKICert returns the PEM-encoded certificate stored under
+// cert/<serial> in the -vault-pki-path secrets engine.
+func (c *Command) vaultPKICert(client *http.Client, token, serial string) (string, error) {
+	var resp struct {
+		Data struct {
+			Certificate string `json:"certificate"`
+		} `json:"data"`
+	}
+	path := fmt.Sprintf("%s/cert/%s", strings.Trim(c.flagVaultPKIPath, "/"), serial)
+	if err := c.vaultRequest(client, http.MethodGet, path, token, nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.Data.Certificate, nil
+}
+
+// vaultRequest sends a request to the Vault API at /v1/<path>
+// and decodes the JSON response into out.
+func (c *Command) vaultRequest(client *http.Client, method, path, token string, body []byte, out interface{}) error {
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(c.flagVaultAddr, "/"), path)
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.flagVaultNamespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.flagVaultNamespace)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected response code from %s %s: %d: %s", method, url, resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// vaultHTTPClient returns the client for requests to Vault.
+// It trusts -vault-ca-file if set and the system roots otherwise.
+func (c *Command) vaultHTTPClient() (*http.Client, error) {
+	tlsConfig := fips.Constrain(&tls.Config{})
+	if c.flagVaultCAFile != "" {
+		caPEM, err := ioutil.ReadFile(c.flagVaultCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %q", c.flagVaultCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
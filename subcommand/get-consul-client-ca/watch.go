@@ -0,0 +1,219 @@
+package getconsulclientca
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// secretKey is the key of the -output-secret secret's data
+// that holds the CA certificate.
+const secretKey = "tls.crt"
+
+// watch blocks on the CA roots endpoint and rewrites the outputs
+// whenever the active root changes until the command is interrupted.
+// activeRoot and index are the root that was last written and the
+// index it was retrieved at.
+//
+// On errors, the Consul client is recreated for the first server that
+// responds so that a rotated -ca-file is picked up and servers that
+// went away or changed IPs are skipped.
+func (c *Command) watch(logger hclog.Logger, consulClient *api.Client, activeRoot string, index uint64) {
+	ctx, cancel := c.interruptContext()
+	defer cancel()
+
+	retryBackoff := backoff.NewExponentialBackOff()
+	retryBackoff.MaxInterval = 1 * time.Minute
+	retryBackoff.MaxElapsedTime = 0
+
+	logger.Info("Watching for CA root changes")
+	for {
+		newRoot, newIndex, err := c.blockingActiveRoot(ctx, consulClient, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && newRoot != activeRoot {
+			logger.Info("Active CA root changed, updating outputs", "index", newIndex)
+			err = c.writeOutputs(newRoot)
+			if err == nil {
+				activeRoot = newRoot
+			}
+		}
+		if err == nil {
+			index = newIndex
+			retryBackoff.Reset()
+			continue
+		}
+
+		logger.Error("Error watching CA roots", "err", err)
+		select {
+		case <-time.After(retryBackoff.NextBackOff()):
+		case <-ctx.Done():
+			return
+		}
+		if newClient, _, _, err := c.connect(logger); err != nil {
+			logger.Error("Error connecting to Consul servers", "err", err)
+		} else {
+			consulClient = newClient
+		}
+	}
+}
+
+// pollCA is used instead of watch for CA providers that don't support
+// blocking queries. It fetches the CA certificate every -polling-interval
+// and rewrites the outputs whenever it changes until the command is
+// interrupted. activeRoot is the certificate that was last written.
+func (c *Command) pollCA(logger hclog.Logger, fetch func() (string, error), activeRoot string) {
+	ctx, cancel := c.interruptContext()
+	defer cancel()
+
+	logger.Info("Polling for CA certificate changes", "interval", c.flagPollingInterval)
+	for {
+		select {
+		case <-time.After(c.flagPollingInterval):
+		case <-ctx.Done():
+			return
+		}
+
+		newRoot, err := fetch()
+		if err != nil {
+			logger.Error("Error retrieving CA certificate", "err", err)
+			continue
+		}
+		if newRoot == activeRoot {
+			continue
+		}
+		logger.Info("CA certificate changed, updating outputs")
+		if err := c.writeOutputs(newRoot); err != nil {
+			logger.Error("Error updating outputs", "err", err)
+			continue
+		}
+		activeRoot = newRoot
+	}
+}
+
+// interruptContext returns a context that is cancelled
+// once the command receives a signal on sigCh.
+func (c *Command) interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-c.sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// blockingActiveRoot returns the active root once the CA roots change
+// after index, or once the blocking query times out.
+func (c *Command) blockingActiveRoot(ctx context.Context, consulClient *api.Client, index uint64) (string, uint64, error) {
+	opts := &api.QueryOptions{WaitIndex: index}
+	caRoots, meta, err := consulClient.Agent().ConnectCARoots(opts.WithContext(ctx))
+	if err != nil {
+		return "", index, err
+	}
+	activeRoot, err := getActiveRoot(caRoots)
+	if err != nil {
+		return "", index, err
+	}
+
+	// Reset the index if it went backwards, e.g. because the servers
+	// were restored from a snapshot, so that we don't block forever.
+	newIndex := meta.LastIndex
+	if newIndex < index {
+		newIndex = 0
+	}
+	return activeRoot, newIndex, nil
+}
+
+// writeSecret creates or updates the -output-secret secret with the CA
+// certificate in each of the outputNamespaces.
+func (c *Command) writeSecret(activeRoot string) error {
+	namespaces, err := c.outputNamespaces()
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, ns := range namespaces {
+		if err := c.writeSecretInNamespace(ns, activeRoot); err != nil {
+			result = multierror.Append(result, fmt.Errorf("namespace %q: %s", ns, err))
+		}
+	}
+	return result
+}
+
+// outputNamespaces returns the namespaces to write the -output-secret secret
+// to: -k8s-namespace, each -output-namespace and the namespaces matching
+// -output-namespace-selector. -k8s-namespace is only left out if it's not
+// set and other namespaces are.
+func (c *Command) outputNamespaces() ([]string, error) {
+	var namespaces []string
+	seen := make(map[string]bool)
+	add := func(ns string) {
+		if !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+
+	if c.flagK8sNamespace != "" {
+		add(c.flagK8sNamespace)
+	}
+	for _, ns := range c.flagOutputNamespaces {
+		add(ns)
+	}
+	if c.flagOutputNamespaceSelector != "" {
+		list, err := c.clientset.CoreV1().Namespaces().List(metav1.ListOptions{
+			LabelSelector: c.flagOutputNamespaceSelector,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing namespaces: %s", err)
+		}
+		for _, ns := range list.Items {
+			add(ns.Name)
+		}
+	}
+	if len(namespaces) == 0 {
+		add(c.flagK8sNamespace)
+	}
+	return namespaces, nil
+}
+
+// writeSecretInNamespace creates or updates the -output-secret secret
+// in namespace ns.
+func (c *Command) writeSecretInNamespace(ns, activeRoot string) error {
+	secrets := c.clientset.CoreV1().Secrets(ns)
+	secret, err := secrets.Get(c.flagOutputSecret, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = secrets.Create(&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: c.flagOutputSecret,
+			},
+			Data: map[string][]byte{
+				secretKey: []byte(activeRoot),
+			},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[secretKey] = []byte(activeRoot)
+	_, err = secrets.Update(secret)
+	return err
+}
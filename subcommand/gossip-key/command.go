@@ -0,0 +1,209 @@
+package gossipkey
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/helper/gossip"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command generates the gossip encryption key secret and rotates the key.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+
+	flagK8sNamespace string
+	flagSecretName   string
+	flagSecretKey    string
+	flagRotate       bool
+
+	consulClient *api.Client
+	clientset    kubernetes.Interface
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of the Kubernetes namespace of the secret.")
+	c.flags.StringVar(&c.flagSecretName, "secret-name", "",
+		"Name of the Kubernetes secret holding the gossip encryption key.")
+	c.flags.StringVar(&c.flagSecretKey, "secret-key", "key",
+		"Key of the secret's data holding the gossip encryption key.")
+	c.flags.BoolVar(&c.flagRotate, "rotate", false,
+		"If true, rotates the gossip encryption key of the running agents. "+
+			"If false, generates the secret if it doesn't exist.")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	if !c.flagRotate {
+		if err := c.generate(logger); err != nil {
+			c.UI.Error(fmt.Sprintf("Error generating gossip encryption key: %s", err))
+			return 1
+		}
+		return 0
+	}
+
+	if c.consulClient == nil {
+		var err error
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
+		c.consulClient, err = consul.NewClient(cfg)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+	if err := c.rotate(logger); err != nil {
+		c.UI.Error(fmt.Sprintf("Error rotating gossip encryption key: %s", err))
+		return 1
+	}
+	c.UI.Info("Successfully rotated the gossip encryption key")
+	return 0
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagK8sNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagSecretName == "" {
+		return errors.New("-secret-name must be set")
+	}
+	if c.flagSecretKey == "" {
+		return errors.New("-secret-key must be set")
+	}
+	return nil
+}
+
+// generate creates the secret with a new key unless it already exists.
+func (c *Command) generate(logger hclog.Logger) error {
+	secrets := c.clientset.CoreV1().Secrets(c.flagK8sNamespace)
+	secret, err := secrets.Get(c.flagSecretName, metav1.GetOptions{})
+	if err == nil {
+		if len(secret.Data[c.flagSecretKey]) == 0 {
+			return fmt.Errorf("secret %q exists but has no %q key", c.flagSecretName, c.flagSecretKey)
+		}
+		logger.Info("Secret already exists", "secret", c.flagSecretName)
+		return nil
+	}
+	if !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("getting secret %q: %s", c.flagSecretName, err)
+	}
+
+	key, err := gossip.GenerateKey()
+	if err != nil {
+		return err
+	}
+	_, err = secrets.Create(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: c.flagSecretName,
+		},
+		Data: map[string][]byte{
+			c.flagSecretKey: []byte(key),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating secret %q: %s", c.flagSecretName, err)
+	}
+	logger.Info("Created secret with a new gossip encryption key", "secret", c.flagSecretName)
+	return nil
+}
+
+// rotate rotates the agents' gossip encryption key. Since the secret
+// records the progress, re-running the command after a failure resumes the
+// rotation instead of starting a new one.
+func (c *Command) rotate(logger hclog.Logger) error {
+	rotation := &gossip.Rotation{
+		Secrets:    c.clientset.CoreV1().Secrets(c.flagK8sNamespace),
+		SecretName: c.flagSecretName,
+		SecretKey:  c.flagSecretKey,
+		Operator:   c.consulClient.Operator(),
+		Progress:   logger.Info,
+	}
+	return rotation.Run()
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Generate and rotate the gossip encryption key"
+const help = `
+Usage: consul-k8s gossip-key [options]
+
+  Creates the Kubernetes secret -secret-name with a new gossip encryption
+  key unless it already exists.
+
+  If -rotate is set, rotates the gossip encryption key of the running
+  agents instead: a new key is installed on all agents, then used as the
+  primary key, then the old key is removed. The secret is updated at each
+  step so that a failed rotation can be resumed by re-running the command.
+
+`
@@ -0,0 +1,181 @@
+package gossipkey
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/gossip"
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	ns         = "default"
+	secretName = "consul-gossip-encryption-key"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{},
+			ExpErr: "-k8s-namespace must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns},
+			ExpErr: "-secret-name must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-secret-name", secretName, "-secret-key", ""},
+			ExpErr: "-secret-key must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-secret-name", secretName, "-log-level", "invalid"},
+			ExpErr: "Unknown log level: invalid",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				clientset: fake.NewSimpleClientset(),
+			}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+func TestRun_Generate(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset()
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, clientset: k8s}
+	responseCode := cmd.Run([]string{"-k8s-namespace", ns, "-secret-name", secretName})
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+
+	key := getSecret(t, k8s).Data["key"]
+	decoded, err := base64.StdEncoding.DecodeString(string(key))
+	require.NoError(t, err)
+	require.Len(t, decoded, 32)
+
+	// Re-running the command must keep the existing key.
+	cmd = Command{UI: ui, clientset: k8s}
+	responseCode = cmd.Run([]string{"-k8s-namespace", ns, "-secret-name", secretName})
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+	require.Equal(t, key, getSecret(t, k8s).Data["key"])
+}
+
+func TestRun_Rotate(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		// secretData is the initial data of the secret.
+		secretData map[string][]byte
+		// keyring is the initial keyring of the agents.
+		keyring []string
+		// expNewKey is the key the agents should use after the rotation.
+		// If empty, a new key must have been generated.
+		expNewKey string
+	}{
+		"new rotation": {
+			secretData: map[string][]byte{"key": []byte("old")},
+			keyring:    []string{"old"},
+		},
+		"resume after storing the new key": {
+			secretData: map[string][]byte{"key": []byte("old"), "key-next": []byte("new")},
+			keyring:    []string{"old"},
+			expNewKey:  "new",
+		},
+		"resume after installing the new key": {
+			secretData: map[string][]byte{"key": []byte("old"), "key-next": []byte("new")},
+			keyring:    []string{"old", "new"},
+			expNewKey:  "new",
+		},
+		"resume after using the new key": {
+			secretData: map[string][]byte{"key": []byte("new"), "key-previous": []byte("old")},
+			keyring:    []string{"new", "old"},
+			expNewKey:  "new",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8s := fake.NewSimpleClientset(&apiv1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: ns},
+				Data:       c.secretData,
+			})
+			keyring := gossip.NewTestKeyring(c.keyring...)
+			server := httptest.NewServer(keyring)
+			defer server.Close()
+			consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+			require.NoError(t, err)
+
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui, clientset: k8s, consulClient: consulClient}
+			responseCode := cmd.Run([]string{"-k8s-namespace", ns, "-secret-name", secretName, "-rotate"})
+			require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+
+			data := getSecret(t, k8s).Data
+			newKey := string(data["key"])
+			if c.expNewKey != "" {
+				require.Equal(t, c.expNewKey, newKey)
+			} else {
+				require.NotEqual(t, "old", newKey)
+			}
+			require.NotContains(t, data, "key-next")
+			require.NotContains(t, data, "key-previous")
+
+			keys, primary := keyring.State()
+			require.Equal(t, []string{newKey}, keys)
+			require.Equal(t, newKey, primary)
+		})
+	}
+}
+
+// Test that the rotation stops before switching keys if the new key
+// couldn't be installed on all nodes.
+func TestRun_RotateNotInstalledOnAllNodes(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: ns},
+		Data:       map[string][]byte{"key": []byte("old")},
+	})
+	keyring := gossip.NewTestKeyring("old")
+	keyring.MissingNodes = 1
+	server := httptest.NewServer(keyring)
+	defer server.Close()
+	consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, clientset: k8s, consulClient: consulClient}
+	responseCode := cmd.Run([]string{"-k8s-namespace", ns, "-secret-name", secretName, "-rotate"})
+	require.Equal(t, 1, responseCode)
+	require.Contains(t, ui.ErrorWriter.String(), "new key is installed on 2 of 3 nodes")
+
+	// The old key is still used and the new key is stored so that
+	// the rotation can be resumed.
+	_, primary := keyring.State()
+	require.Equal(t, "old", primary)
+	data := getSecret(t, k8s).Data
+	require.Equal(t, "old", string(data["key"]))
+	require.NotEmpty(t, data["key-next"])
+}
+
+func getSecret(t *testing.T, k8s kubernetes.Interface) *apiv1.Secret {
+	secret, err := k8s.CoreV1().Secrets(ns).Get(secretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	return secret
+}
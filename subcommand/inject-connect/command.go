@@ -8,21 +8,30 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/deckarep/golang-set"
 	"github.com/hashicorp/consul-k8s/connect-inject"
 	"github.com/hashicorp/consul-k8s/helper/cert"
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/helper/fips"
+	"github.com/hashicorp/consul-k8s/helper/metrics"
+	"github.com/hashicorp/consul-k8s/helper/tracing"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/command/flags"
-	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 )
 
 type arrayFlags []string
@@ -36,14 +45,22 @@ type Command struct {
 	UI cli.Ui
 
 	flagListen               string
+	flagMetricsAddr          string // Address to serve the Prometheus metrics on
+	flagEnablePprof          bool   // Serve the pprof profiles on -metrics-addr
+	flagTracingEndpoint      string // OTLP/HTTP endpoint to export the traces to
+	flagEmitEvents           bool   // Record the failures to inject as events
 	flagAutoName             string // MutatingWebhookConfiguration for updating
 	flagAutoHosts            string // SANs for the auto-generated TLS cert.
 	flagCertFile             string // TLS cert for listening (PEM)
 	flagKeyFile              string // TLS cert private key (PEM)
+	flagCertManager          bool   // Whether the TLS cert is managed by cert-manager
 	flagDefaultInject        bool   // True to inject by default
+	flagDenyInvalidAnnos     bool   // Reject the pods with invalid annotations
 	flagConsulImage          string // Docker image for Consul
 	flagEnvoyImage           string // Docker image for Envoy
 	flagConsulK8sImage       string // Docker image for consul-k8s
+	flagLifecycleSidecarUID  int64  // User the lifecycle sidecar runs as
+	flagLifecycleSidecarGID  int64  // Group the lifecycle sidecar runs as
 	flagACLAuthMethod        string // Auth Method to use for ACLs, if enabled
 	flagWriteServiceDefaults bool   // True to enable central config injection
 	flagDefaultProtocol      string // Default protocol for use with central config
@@ -58,12 +75,19 @@ type Command struct {
 	flagK8SNSMirroringPrefix       string   // Prefix added to Consul namespaces created when mirroring
 	flagCrossNamespaceACLPolicy    string   // The name of the ACL policy to add to every created namespace if ACLs are enabled
 
-	flagSet *flag.FlagSet
-	http    *flags.HTTPFlags
+	flagSet    *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	logging    *k8sflags.LogFlags
+	audit      *k8sflags.AuditFlags
+	writeLimit *k8sflags.WriteLimitFlags
 
 	consulClient *api.Client
 	clientset    kubernetes.Interface
 
+	// sigCh receives a signal when the command should stop.
+	sigCh chan os.Signal
+
 	once sync.Once
 	help string
 	cert atomic.Value
@@ -71,8 +95,23 @@ type Command struct {
 
 func (c *Command) init() {
 	c.flagSet = flag.NewFlagSet("", flag.ContinueOnError)
-	c.flagSet.StringVar(&c.flagListen, "listen", ":8080", "Address to bind listener to.")
+	c.flagSet.StringVar(&c.flagListen, "listen", ":8080",
+		"Address to serve the webhook and the /live and /ready endpoints on. /health/ready is an alias of /ready.")
+	c.flagSet.StringVar(&c.flagMetricsAddr, "metrics-addr", "",
+		"Address to serve the Prometheus metrics on at /metrics, e.g. \":9102\". If blank, metrics are disabled.")
+	c.flagSet.BoolVar(&c.flagEnablePprof, "enable-pprof", false,
+		"If true, the pprof profiles are served on /debug/pprof/ on -metrics-addr, e.g. to profile the memory "+
+			"and CPU usage in place. Requires -metrics-addr.")
+	c.flagSet.StringVar(&c.flagTracingEndpoint, "tracing-otlp-endpoint", "",
+		"OTLP/HTTP endpoint of an OpenTelemetry collector to export the traces of the admission requests and "+
+			"their Consul API requests to, e.g. \"http://otel-collector:4318\". If blank, tracing is disabled.")
+	c.flagSet.BoolVar(&c.flagEmitEvents, "emit-events", false,
+		"If true, the failures to inject the pods are recorded as events on their controllers, e.g. their "+
+			"ReplicaSets, with the reason ConnectInjectionFailed. Requires permission to create events.")
 	c.flagSet.BoolVar(&c.flagDefaultInject, "default-inject", true, "Inject by default.")
+	c.flagSet.BoolVar(&c.flagDenyInvalidAnnos, "deny-invalid-annotations", false,
+		"If true, the annotation webhook served on /mutate-annotations rejects the pods with invalid "+
+			"consul.hashicorp.com annotation values. Otherwise it only warns about them.")
 	c.flagSet.StringVar(&c.flagAutoName, "tls-auto", "",
 		"MutatingWebhookConfiguration name. If specified, will auto generate cert bundle.")
 	c.flagSet.StringVar(&c.flagAutoHosts, "tls-auto-hosts", "",
@@ -81,12 +120,20 @@ func (c *Command) init() {
 		"PEM-encoded TLS certificate to serve. If blank, will generate random cert.")
 	c.flagSet.StringVar(&c.flagKeyFile, "tls-key-file", "",
 		"PEM-encoded TLS private key to serve. If blank, will generate random cert.")
+	c.flagSet.BoolVar(&c.flagCertManager, "tls-cert-manager", false,
+		"If true, the TLS cert is managed by cert-manager and is loaded from -tls-cert-file and -tls-key-file, "+
+			"which should be mounted from the cert-manager Certificate's secret. The MutatingWebhookConfiguration's "+
+			"caBundle is left to cert-manager's CA injector and no cert is generated.")
 	c.flagSet.StringVar(&c.flagConsulImage, "consul-image", connectinject.DefaultConsulImage,
 		"Docker image for Consul. Defaults to consul:1.7.1.")
 	c.flagSet.StringVar(&c.flagEnvoyImage, "envoy-image", connectinject.DefaultEnvoyImage,
 		"Docker image for Envoy. Defaults to envoyproxy/envoy-alpine:v1.13.0.")
 	c.flagSet.StringVar(&c.flagConsulK8sImage, "consul-k8s-image", "",
 		"Docker image for consul-k8s. Used for the connect sidecar.")
+	c.flagSet.Int64Var(&c.flagLifecycleSidecarUID, "lifecycle-sidecar-uid", connectinject.DefaultLifecycleSidecarUID,
+		"User ID the lifecycle sidecar container runs as. Must not be 0.")
+	c.flagSet.Int64Var(&c.flagLifecycleSidecarGID, "lifecycle-sidecar-gid", connectinject.DefaultLifecycleSidecarGID,
+		"Group ID the lifecycle sidecar container runs as. Must not be 0.")
 	c.flagSet.StringVar(&c.flagACLAuthMethod, "acl-auth-method", "",
 		"The name of the Kubernetes Auth Method to use for connectInjection if ACLs are enabled.")
 	c.flagSet.BoolVar(&c.flagWriteServiceDefaults, "enable-central-config", false,
@@ -115,8 +162,23 @@ func (c *Command) init() {
 	c.http = &flags.HTTPFlags{}
 	flags.Merge(c.flagSet, c.http.ClientFlags())
 	flags.Merge(c.flagSet, c.http.ServerFlags())
-
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flagSet, c.logging.Flags())
+	c.audit = &k8sflags.AuditFlags{}
+	flags.Merge(c.flagSet, c.audit.Flags())
+	c.writeLimit = &k8sflags.WriteLimitFlags{}
+	flags.Merge(c.flagSet, c.writeLimit.Flags())
+
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flagSet, c.configFile.Flags())
 	c.help = flags.Usage(help, c.flagSet)
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
 }
 
 func (c *Command) Run(args []string) int {
@@ -124,12 +186,53 @@ func (c *Command) Run(args []string) int {
 	if err := c.flagSet.Parse(args); err != nil {
 		return 1
 	}
+	if err := c.configFile.Apply(c.flagSet); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	// Validate flags.
 	if c.flagConsulK8sImage == "" {
 		c.UI.Error("-consul-k8s-image must be set")
 		return 1
 	}
+	if c.flagLifecycleSidecarUID <= 0 || c.flagLifecycleSidecarGID <= 0 {
+		c.UI.Error("-lifecycle-sidecar-uid and -lifecycle-sidecar-gid must be greater than 0")
+		return 1
+	}
+	if c.flagEnablePprof && c.flagMetricsAddr == "" {
+		c.UI.Error("-metrics-addr must be set if -enable-pprof is set")
+		return 1
+	}
+	if c.flagCertManager {
+		if c.flagCertFile == "" || c.flagKeyFile == "" {
+			c.UI.Error("-tls-cert-file and -tls-key-file must be set if -tls-cert-manager is set")
+			return 1
+		}
+		if c.flagAutoName != "" || c.flagAutoHosts != "" {
+			// cert-manager's CA injector patches the caBundle so we
+			// must not overwrite it.
+			c.UI.Error("-tls-auto and -tls-auto-hosts cannot be set if -tls-cert-manager is set")
+			return 1
+		}
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	stopAudit, err := c.audit.Start(logger.Named("audit"), "consul-k8s-inject-connect")
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error opening the audit log: %s", err))
+		return 1
+	}
+	defer stopAudit()
+	if err := c.writeLimit.Limit(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	// We must have an in-cluster K8S client
 	if c.clientset == nil {
@@ -166,33 +269,38 @@ func (c *Command) Run(args []string) int {
 	// Set up Consul client
 	if c.consulClient == nil {
 		var err error
-		c.consulClient, err = api.NewClient(cfg)
+		c.consulClient, err = consul.NewClient(cfg)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 			return 1
 		}
 	}
 
-	// Determine where to source the certificates from
-	var certSource cert.Source = &cert.GenSource{
-		Name:  "Connect Inject",
-		Hosts: strings.Split(c.flagAutoHosts, ","),
+	if c.flagMetricsAddr != "" {
+		server, err := metrics.ListenAndServe(logger.Named("metrics"), c.flagMetricsAddr, c.flagEnablePprof)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error serving metrics: %s", err))
+			return 1
+		}
+		defer server.Close()
 	}
-	if c.flagCertFile != "" {
-		certSource = &cert.DiskSource{
-			CertPath: c.flagCertFile,
-			KeyPath:  c.flagKeyFile,
+	if c.flagTracingEndpoint != "" {
+		stop, err := tracing.Start(logger.Named("tracing"), c.flagTracingEndpoint, "consul-k8s-inject-connect")
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error starting tracing: %s", err))
+			return 1
 		}
+		defer stop()
 	}
 
 	// Create the certificate notifier so we can update for certificates,
 	// then start all the background routines for updating certificates.
 	certCh := make(chan cert.Bundle)
-	certNotify := &cert.Notify{Ch: certCh, Source: certSource}
-	defer certNotify.Stop()
-	go certNotify.Start(context.Background())
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
+	certNotify := &cert.Notify{Ch: certCh, Source: c.certSource()}
+	defer certNotify.Stop()
+	go certNotify.Start(ctx)
 	go c.certWatcher(ctx, certCh, c.clientset)
 
 	// Convert allow/deny lists to sets
@@ -205,12 +313,21 @@ func (c *Command) Run(args []string) int {
 		denySet.Add(deny)
 	}
 
+	var recorder record.EventRecorder
+	if c.flagEmitEvents {
+		var stopRecorder func()
+		recorder, stopRecorder = subcommand.EventRecorder(c.clientset, "consul-k8s-inject-connect")
+		defer stopRecorder()
+	}
+
 	// Build the HTTP handler and server
 	injector := connectinject.Handler{
 		ConsulClient:               c.consulClient,
 		ImageConsul:                c.flagConsulImage,
 		ImageEnvoy:                 c.flagEnvoyImage,
 		ImageConsulK8S:             c.flagConsulK8sImage,
+		LifecycleSidecarUID:        c.flagLifecycleSidecarUID,
+		LifecycleSidecarGID:        c.flagLifecycleSidecarGID,
 		RequireAnnotation:          !c.flagDefaultInject,
 		AuthMethod:                 c.flagACLAuthMethod,
 		WriteServiceDefaults:       c.flagWriteServiceDefaults,
@@ -223,25 +340,70 @@ func (c *Command) Run(args []string) int {
 		EnableK8SNSMirroring:       c.flagEnableK8SNSMirroring,
 		K8SNSMirroringPrefix:       c.flagK8SNSMirroringPrefix,
 		CrossNamespaceACLPolicy:    c.flagCrossNamespaceACLPolicy,
-		Log:                        hclog.Default().Named("handler"),
+		EventRecorder:              recorder,
+		Log:                        logger.Named("handler"),
 	}
 	mux := http.NewServeMux()
+	annotationWebhook := connectinject.AnnotationWebhook{
+		Log:         logger.Named("annotations"),
+		DenyInvalid: c.flagDenyInvalidAnnos,
+	}
 	mux.HandleFunc("/mutate", injector.Handle)
+	mux.HandleFunc("/mutate-annotations", annotationWebhook.Handle)
+	mux.HandleFunc("/live", c.handleLive)
+	mux.HandleFunc("/ready", c.handleReady)
 	mux.HandleFunc("/health/ready", c.handleReady)
 	var handler http.Handler = mux
 	server := &http.Server{
 		Addr:      c.flagListen,
 		Handler:   handler,
-		TLSConfig: &tls.Config{GetCertificate: c.getCertificate},
+		TLSConfig: fips.Constrain(&tls.Config{GetCertificate: c.getCertificate}),
 	}
 
-	c.UI.Info(fmt.Sprintf("Listening on %q...", c.flagListen))
-	if err := server.ListenAndServeTLS("", ""); err != nil {
+	errCh := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", c.flagListen)
+		errCh <- server.ListenAndServeTLS("", "")
+	}()
+
+	select {
+	// Unexpected exit
+	case err := <-errCh:
 		c.UI.Error(fmt.Sprintf("Error listening: %s", err))
 		return 1
+
+	// Interrupted, gracefully exit once the in-flight admission requests
+	// are answered so that the pods being created aren't rejected.
+	case <-c.sigCh:
+		logger.Info("shutting down")
+		if err := subcommand.Shutdown(server); err != nil {
+			logger.Error("error shutting down", "err", err)
+			return 1
+		}
+		return 0
 	}
+}
 
-	return 0
+// certSource returns where to source the TLS certificates from. Certs
+// managed by cert-manager are loaded from disk like any other provided
+// cert. They are reloaded when cert-manager renews them since the
+// kubelet updates the mounted secret.
+func (c *Command) certSource() cert.Source {
+	if c.flagCertManager || c.flagCertFile != "" {
+		return &cert.DiskSource{
+			CertPath: c.flagCertFile,
+			KeyPath:  c.flagKeyFile,
+		}
+	}
+	return &cert.GenSource{
+		Name:  "Connect Inject",
+		Hosts: strings.Split(c.flagAutoHosts, ","),
+	}
+}
+
+// handleLive reports that the process is running.
+func (c *Command) handleLive(rw http.ResponseWriter, req *http.Request) {
+	rw.WriteHeader(204)
 }
 
 func (c *Command) handleReady(rw http.ResponseWriter, req *http.Request) {
@@ -324,4 +486,12 @@ Usage: consul-k8s inject-connect [options]
   Run the admission webhook server for injecting the Consul Connect
   proxy sidecar. The sidecar uses Envoy by default.
 
+  The consul.hashicorp.com annotations of pods are checked by the webhook
+  served on /mutate-annotations, which can be registered for all the pods
+  of the cluster, including those that aren't injected. It warns about the
+  unknown annotations, suggesting the annotation they're likely a typo of,
+  and about the deprecated ones and the invalid values, and normalizes the
+  valid values, e.g. "True" to "true". The pods with invalid values are
+  rejected if -deny-invalid-annotations is set.
+
 `
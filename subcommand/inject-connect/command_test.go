@@ -1,8 +1,12 @@
 package connectinject
 
 import (
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/consul-k8s/helper/cert"
 	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/require"
 	"k8s.io/client-go/kubernetes/fake"
@@ -18,6 +22,35 @@ func TestRun_FlagValidation(t *testing.T) {
 			flags:  []string{},
 			expErr: "-consul-k8s-image must be set",
 		},
+		{
+			flags:  []string{"-consul-k8s-image", "foo", "-lifecycle-sidecar-uid", "0"},
+			expErr: "-lifecycle-sidecar-uid and -lifecycle-sidecar-gid must be greater than 0",
+		},
+		{
+			flags:  []string{"-consul-k8s-image", "foo", "-tls-cert-manager", "-tls-cert-file", "tls.crt"},
+			expErr: "-tls-cert-file and -tls-key-file must be set if -tls-cert-manager is set",
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-tls-cert-manager", "-tls-cert-file", "tls.crt",
+				"-tls-key-file", "tls.key", "-tls-auto", "consul-connect-injector-cfg"},
+			expErr: "-tls-auto and -tls-auto-hosts cannot be set if -tls-cert-manager is set",
+		},
+		{
+			flags:  []string{"-consul-k8s-image", "foo", "-enable-pprof"},
+			expErr: "-metrics-addr must be set if -enable-pprof is set",
+		},
+		{
+			flags:  []string{"-consul-k8s-image", "foo", "-log-level", "invalid"},
+			expErr: "Unknown log level: invalid",
+		},
+		{
+			flags:  []string{"-consul-k8s-image", "foo", "-audit-log-file", "/nonexistent/audit.log"},
+			expErr: "Error opening the audit log: open /nonexistent/audit.log",
+		},
+		{
+			flags:  []string{"-consul-k8s-image", "foo", "-consul-write-qps", "-1"},
+			expErr: "-consul-write-qps must not be negative",
+		},
 		{
 			flags:  []string{"-consul-k8s-image", "foo", "-ca-file", "bar"},
 			expErr: "Error reading Consul's CA cert file \"bar\"",
@@ -38,3 +71,108 @@ func TestRun_FlagValidation(t *testing.T) {
 		})
 	}
 }
+
+// Test that the flags are read from -config-file, with the flags on the
+// command line taking precedence.
+func TestRun_ConfigFile(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		flags  []string
+		expErr string
+	}{
+		{
+			name:   "values",
+			config: "consul-k8s-image = \"foo\"\nenable-pprof = true\n",
+			expErr: "-metrics-addr must be set if -enable-pprof is set",
+		},
+		{
+			name:   "flags take precedence",
+			config: "consul-k8s-image = \"foo\"\nlog-level = \"debug\"\n",
+			flags:  []string{"-log-level", "invalid"},
+			expErr: "Unknown log level: invalid",
+		},
+		{
+			name:   "unknown keys",
+			config: "consul-k8s-image = \"foo\"\nconsul-k8s-imgae = \"bar\"\n",
+			expErr: "unknown flags: consul-k8s-imgae",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "config*.hcl")
+			require.NoError(t, err)
+			defer os.Remove(file.Name())
+			_, err = file.WriteString(c.config)
+			require.NoError(t, err)
+			require.NoError(t, file.Close())
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				clientset: fake.NewSimpleClientset(),
+			}
+			code := cmd.Run(append([]string{"-config-file", file.Name()}, c.flags...))
+			require.Equal(t, 1, code)
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestCertSource(t *testing.T) {
+	cases := map[string]struct {
+		flags   []string
+		expDisk bool
+	}{
+		"generated": {
+			flags:   []string{"-tls-auto-hosts", "foo.svc"},
+			expDisk: false,
+		},
+		"files": {
+			flags:   []string{"-tls-cert-file", "tls.crt", "-tls-key-file", "tls.key"},
+			expDisk: true,
+		},
+		"cert-manager": {
+			flags:   []string{"-tls-cert-manager", "-tls-cert-file", "tls.crt", "-tls-key-file", "tls.key"},
+			expDisk: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			cmd := Command{}
+			cmd.once.Do(cmd.init)
+			require.NoError(t, cmd.flagSet.Parse(c.flags))
+
+			source := cmd.certSource()
+			if c.expDisk {
+				require.Equal(t, &cert.DiskSource{CertPath: "tls.crt", KeyPath: "tls.key"}, source)
+			} else {
+				require.IsType(t, &cert.GenSource{}, source)
+			}
+		})
+	}
+}
+
+// Test that the command exits successfully once it's interrupted.
+func TestRun_Interrupt(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: fake.NewSimpleClientset(),
+	}
+	cmd.init()
+
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{"-consul-k8s-image", "foo", "-listen", "127.0.0.1:0"})
+	}()
+	cmd.sigCh <- os.Interrupt
+	select {
+	case code := <-exitCh:
+		require.Equal(t, 0, code, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not exit after interrupt")
+	}
+}
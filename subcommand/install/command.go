@@ -0,0 +1,311 @@
+package install
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// pvcSelector selects the persistent volume claims of the Consul servers,
+// which keep their data after the release is uninstalled.
+const pvcSelector = "app=consul"
+
+// Command installs the Consul Helm chart.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+	chart      *k8sflags.HelmFlags
+
+	flagName        string
+	flagNamespace   string
+	flagPreset      string
+	flagAutoApprove bool
+	flagDryRun      bool
+	flagTimeout     time.Duration
+
+	helm          *helm.Client
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagName, "name", "consul",
+		"Name of the Helm release.")
+	c.flags.StringVar(&c.flagNamespace, "namespace", "consul",
+		"Kubernetes namespace to install Consul in. It's created if it doesn't exist.")
+	c.flags.StringVar(&c.flagPreset, "preset", "",
+		fmt.Sprintf("Preset of the values of the chart: %s. The values files and -set flags take "+
+			"precedence over it.", strings.Join(presetNames(), ", ")))
+	c.flags.BoolVar(&c.flagAutoApprove, "auto-approve", false,
+		"If true, installs without asking for confirmation.")
+	c.flags.BoolVar(&c.flagDryRun, "dry-run", false,
+		"If true, only runs the pre-flight checks and shows the installation, without installing.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Minute,
+		"How long to wait for the Consul pods to be ready.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	c.chart = &k8sflags.HelmFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, c.chart.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if _, ok := presets[c.flagPreset]; c.flagPreset != "" && !ok {
+		c.UI.Error(fmt.Sprintf("-preset must be one of %s, not %q", strings.Join(presetNames(), ", "), c.flagPreset))
+		return 1
+	}
+	values, err := c.chart.ReadValues()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.kubeClient == nil || c.dynamicClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		if c.kubeClient == nil {
+			c.kubeClient, err = kubernetes.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+		if c.dynamicClient == nil {
+			c.dynamicClient, err = dynamic.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+	}
+	if c.helm == nil {
+		c.helm = &helm.Client{KubeConfig: c.k8s.KubeConfig()}
+	}
+
+	c.UI.Output("==> Running pre-flight checks")
+	checks := []struct {
+		ok    string
+		check func() error
+	}{
+		{"No existing Consul installations found", c.checkReleases},
+		{fmt.Sprintf("No persistent volume claims of a previous installation found in namespace %q", c.flagNamespace), c.checkPVCs},
+		{"No consul.hashicorp.com CRDs outside of Helm found", c.checkCRDs},
+	}
+	for _, check := range checks {
+		if err := check.check(); err != nil {
+			c.UI.Error(" ✗ " + err.Error())
+			return 1
+		}
+		c.UI.Output(" ✓ " + check.ok)
+	}
+
+	chart := c.chart.Chart()
+	if chart == "" {
+		chart = fmt.Sprintf("consul %s (embedded)", helm.ChartVersion)
+	}
+	c.UI.Output("\n==> Installation summary")
+	c.UI.Output(fmt.Sprintf("    Release:   %s", c.flagName))
+	c.UI.Output(fmt.Sprintf("    Namespace: %s", c.flagNamespace))
+	c.UI.Output(fmt.Sprintf("    Chart:     %s", chart))
+	if c.flagPreset != "" {
+		c.UI.Output(fmt.Sprintf("    Preset:    %s", c.flagPreset))
+		c.UI.Output(fmt.Sprintf("\n    Values of preset %s:\n%s", c.flagPreset, subcommand.Indent(strings.TrimSpace(presets[c.flagPreset]))))
+	}
+	for i, file := range c.chart.ValuesFiles() {
+		c.UI.Output(fmt.Sprintf("\n    Values of %s:\n%s", file, subcommand.Indent(values[i])))
+	}
+	if len(c.chart.Set()) > 0 {
+		c.UI.Output(fmt.Sprintf("\n    Values set with -set:\n%s", subcommand.Indent(strings.Join(c.chart.Set(), "\n"))))
+	}
+	if c.flagPreset == "" && len(values) == 0 && len(c.chart.Set()) == 0 {
+		c.UI.Output("    Values:    the defaults of the chart")
+	}
+
+	if c.flagDryRun {
+		c.UI.Output("\nDry run complete. No changes were made.")
+		return 0
+	}
+	if !c.flagAutoApprove {
+		ok, err := subcommand.Confirm(c.UI, "\nProceed with the installation?")
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading the confirmation: %s", err))
+			return 1
+		}
+		if !ok {
+			c.UI.Output("Installation cancelled.")
+			return 1
+		}
+	}
+
+	c.UI.Output(fmt.Sprintf("\n==> Installing Consul, waiting up to %s for the pods to be ready", c.flagTimeout))
+	opts := c.chart.ReleaseOptions(c.flagName, c.flagNamespace)
+	opts.Timeout = c.flagTimeout
+	if c.flagPreset != "" {
+		file, err := presetFile(c.flagPreset)
+		if err != nil {
+			c.UI.Error(" ✗ " + err.Error())
+			return 1
+		}
+		defer os.Remove(file)
+		opts.ValuesFiles = append([]string{file}, opts.ValuesFiles...)
+	}
+	notes, err := c.helm.Install(opts)
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	if notes != "" {
+		c.UI.Output(subcommand.Indent(strings.TrimSpace(notes)))
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ Consul installed in namespace %q", c.flagNamespace))
+	return 0
+}
+
+// presetFile writes the values of preset to a temporary values file and
+// returns its path.
+func presetFile(preset string) (string, error) {
+	file, err := ioutil.TempFile("", "consul-k8s-preset-*.yaml")
+	if err != nil {
+		return "", fmt.Errorf("creating the values file of the preset: %s", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(presets[preset]); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("writing the values file of the preset: %s", err)
+	}
+	return file.Name(), nil
+}
+
+// checkReleases fails if the Consul chart is already installed. Only one
+// installation per cluster is supported, e.g. since the CRDs and webhooks
+// are cluster-wide.
+func (c *Command) checkReleases() error {
+	releases, err := c.helm.Releases()
+	if err != nil {
+		return fmt.Errorf("listing the Helm releases: %s", err)
+	}
+	if len(releases) > 0 {
+		r := releases[0]
+		return fmt.Errorf("found an existing installation of Consul: release %q in namespace %q (chart %s, status %s). "+
+			"Upgrade or uninstall it instead", r.Name, r.Namespace, r.Chart, r.Status)
+	}
+	return nil
+}
+
+// checkPVCs fails if the persistent volume claims of the servers of a
+// previous installation are left in the namespace, since the new servers
+// would start with the data of the old ones, e.g. their ACL bootstrap
+// state.
+func (c *Command) checkPVCs() error {
+	pvcs, err := c.kubeClient.CoreV1().PersistentVolumeClaims(c.flagNamespace).List(metav1.ListOptions{LabelSelector: pvcSelector})
+	if err != nil {
+		return fmt.Errorf("listing the persistent volume claims: %s", err)
+	}
+	if len(pvcs.Items) > 0 {
+		var names []string
+		for _, pvc := range pvcs.Items {
+			names = append(names, pvc.Name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("found persistent volume claims of a previous installation in namespace %q: %s. "+
+			"Delete them before installing", c.flagNamespace, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// checkCRDs fails if CRDs of consul.hashicorp.com that Helm doesn't manage
+// exist, e.g. applied with kubectl, since Helm won't install the chart's
+// CRDs over them.
+func (c *Command) checkCRDs() error {
+	crds, err := c.dynamicClient.Resource(helm.CRDResource).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("listing the CRDs: %s", err)
+	}
+	var names []string
+	for _, crd := range crds.Items {
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		if group == helm.CRDGroup && crd.GetLabels()[helm.ManagedByLabel] != "Helm" {
+			names = append(names, crd.GetName())
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return fmt.Errorf("found CRDs of %s not managed by Helm: %s. Delete them before installing",
+			helm.CRDGroup, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Install Consul on Kubernetes"
+const help = `
+Usage: consul-k8s install [options]
+
+  Installs Consul on Kubernetes with the Consul Helm chart, without having
+  to install Helm. The chart is embedded in consul-k8s unless -chart is
+  set, and the release is stored in the cluster like Helm does, so that it
+  can also be managed with the helm binary.
+
+  The pre-flight checks fail if Consul is already installed in the
+  cluster, if the persistent volume claims of the servers of a previous
+  installation are left in the namespace, or if CRDs of
+  consul.hashicorp.com not managed by Helm exist, since each of them would
+  break the installation.
+
+  The values of the chart are set with -f values files and -set flags, as
+  with Helm, on top of the values of the -preset if set:
+
+    demo           A single server, the UI and Connect, to try out Consul.
+    secure         ACLs, TLS and metrics enabled, with three servers.
+    observability  The metrics of Consul and of the sidecars exported to a
+                   Prometheus server and shown in the UI.
+
+  The installation is shown and must be confirmed unless -auto-approve is
+  set, and the command waits for the Consul pods to be ready.
+
+`
@@ -0,0 +1,251 @@
+package install
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{"foo"},
+			ExpErr: "Should have no non-flag arguments.",
+		},
+		{
+			Flags:  []string{"-set", "global.name"},
+			ExpErr: `-set "global.name" must be of the form key=value`,
+		},
+		{
+			Flags:  []string{"-preset", "prod"},
+			ExpErr: `-preset must be one of demo, observability, secure, not "prod"`,
+		},
+		{
+			Flags:  []string{"-f", "/does/not/exist.yaml"},
+			ExpErr: "Error reading values file: open /does/not/exist.yaml: no such file or directory",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.Flags))
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+func TestRun_PreflightChecks(t *testing.T) {
+	cases := map[string]struct {
+		releases []helm.TestRelease
+		objects  []runtime.Object
+		crds     []unstructured.Unstructured
+		expErr   string
+	}{
+		"existing installation": {
+			releases: []helm.TestRelease{{Name: "consul", Namespace: "mesh", Chart: "consul-0.24.1"}},
+			expErr:   `found an existing installation of Consul: release "consul" in namespace "mesh" (chart consul-0.24.1, status deployed)`,
+		},
+		"leftover PVCs": {
+			objects: []runtime.Object{
+				pvc("data-consul-consul-server-1", "app", "consul"),
+				pvc("data-consul-consul-server-0", "app", "consul"),
+				pvc("postgres", "app", "postgres"),
+			},
+			expErr: `found persistent volume claims of a previous installation in namespace "consul": ` +
+				"data-consul-consul-server-0, data-consul-consul-server-1",
+		},
+		"CRDs outside of Helm": {
+			crds: []unstructured.Unstructured{
+				helm.NewTestCRD("servicedefaults.consul.hashicorp.com", helm.CRDGroup, ""),
+				helm.NewTestCRD("proxydefaults.consul.hashicorp.com", helm.CRDGroup, "Helm"),
+				helm.NewTestCRD("certificates.cert-manager.io", "cert-manager.io", ""),
+			},
+			expErr: "found CRDs of consul.hashicorp.com not managed by Helm: servicedefaults.consul.hashicorp.com",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset(c.objects...)
+			client, err := helm.NewTestClient(kubeClient, c.releases...)
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:            ui,
+				kubeClient:    kubeClient,
+				dynamicClient: &helm.TestCRDClient{CRDs: c.crds},
+				helm:          client,
+			}
+			require.Equal(t, 1, cmd.Run([]string{"-auto-approve"}))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+			releases, err := client.Releases()
+			require.NoError(t, err)
+			require.Len(t, releases, len(c.releases), "nothing is installed")
+		})
+	}
+}
+
+func TestRun_Install(t *testing.T) {
+	dir, err := ioutil.TempDir("", "install")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	valuesFile := filepath.Join(dir, "values.yaml")
+	require.NoError(t, ioutil.WriteFile(valuesFile, []byte("global:\n  name: consul\n"), 0644))
+
+	cases := map[string]struct {
+		flags      []string
+		input      string
+		expCode    int
+		expInstall bool
+		expOutput  string
+	}{
+		"dry run": {
+			flags:     []string{"-dry-run"},
+			expCode:   0,
+			expOutput: "Dry run complete. No changes were made.",
+		},
+		"confirmed": {
+			flags:      []string{},
+			input:      "yes\n",
+			expCode:    0,
+			expInstall: true,
+			expOutput:  `Consul installed in namespace "consul"`,
+		},
+		"cancelled": {
+			flags:     []string{},
+			input:     "n\n",
+			expCode:   1,
+			expOutput: "Installation cancelled.",
+		},
+		"auto-approve": {
+			flags:      []string{"-auto-approve"},
+			expCode:    0,
+			expInstall: true,
+			expOutput:  `Consul installed in namespace "consul"`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			client, err := helm.NewTestClient(kubeClient)
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			ui.InputReader = strings.NewReader(c.input)
+			cmd := Command{
+				UI:            ui,
+				kubeClient:    kubeClient,
+				dynamicClient: &helm.TestCRDClient{},
+				helm:          client,
+			}
+			flags := append(c.flags, "-f", valuesFile, "-set", "server.replicas=3")
+			require.Equal(t, c.expCode, cmd.Run(flags), ui.ErrorWriter.String())
+			output := ui.OutputWriter.String()
+			require.Contains(t, output, c.expOutput)
+			require.Contains(t, output, "      global:\n        name: consul\n")
+			require.Contains(t, output, "      server.replicas=3\n")
+			require.Contains(t, output, "Chart:     consul "+helm.ChartVersion+" (embedded)\n")
+
+			releases, err := client.Releases()
+			require.NoError(t, err)
+			if !c.expInstall {
+				require.Empty(t, releases)
+				return
+			}
+			require.Contains(t, output, "Thank you for installing HashiCorp Consul!")
+			require.Len(t, releases, 1)
+			require.Equal(t, "consul", releases[0].Namespace)
+			require.Equal(t, "consul-"+helm.ChartVersion, releases[0].Chart)
+			release, err := client.Get("consul", "consul")
+			require.NoError(t, err)
+			require.Equal(t, map[string]interface{}{
+				"global": map[string]interface{}{"name": "consul"},
+				"server": map[string]interface{}{"replicas": int64(3)},
+			}, release.Config)
+			_, err = kubeClient.CoreV1().Namespaces().Get("consul", metav1.GetOptions{})
+			require.NoError(t, err, "the namespace is created")
+		})
+	}
+}
+
+func TestRun_Preset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "install")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	valuesFile := filepath.Join(dir, "values.yaml")
+	require.NoError(t, ioutil.WriteFile(valuesFile, []byte("server:\n  replicas: 5\n  bootstrapExpect: 5\n"), 0644))
+	// The values file of the preset is written to TMPDIR.
+	tmpDir := filepath.Join(dir, "tmp")
+	require.NoError(t, os.Mkdir(tmpDir, 0755))
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	require.NoError(t, os.Setenv("TMPDIR", tmpDir))
+
+	kubeClient := fake.NewSimpleClientset()
+	client, err := helm.NewTestClient(kubeClient)
+	require.NoError(t, err)
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:            ui,
+		kubeClient:    kubeClient,
+		dynamicClient: &helm.TestCRDClient{},
+		helm:          client,
+	}
+	require.Equal(t, 0, cmd.Run([]string{"-preset", "secure", "-auto-approve", "-f", valuesFile}), ui.ErrorWriter.String())
+	output := ui.OutputWriter.String()
+	require.Contains(t, output, "    Preset:    secure\n")
+	require.Contains(t, output, "    Values of preset secure:\n      global:\n        name: consul\n")
+
+	// The values files take precedence over the preset.
+	release, err := client.Get("consul", "consul")
+	require.NoError(t, err)
+	server, _, _ := unstructured.NestedMap(release.Config, "server")
+	require.Equal(t, map[string]interface{}{"replicas": float64(5), "bootstrapExpect": float64(5)}, server)
+	acls, _, _ := unstructured.NestedBool(release.Config, "global", "acls", "manageSystemACLs")
+	require.True(t, acls)
+	tmpFiles, err := ioutil.ReadDir(tmpDir)
+	require.NoError(t, err)
+	require.Empty(t, tmpFiles, "the values file of the preset is removed")
+}
+
+// Test that the presets install with the embedded chart.
+func TestRun_Presets(t *testing.T) {
+	for _, preset := range presetNames() {
+		t.Run(preset, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			client, err := helm.NewTestClient(kubeClient)
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:            ui,
+				kubeClient:    kubeClient,
+				dynamicClient: &helm.TestCRDClient{},
+				helm:          client,
+			}
+			require.Equal(t, 0, cmd.Run([]string{"-preset", preset, "-auto-approve"}), ui.ErrorWriter.String())
+		})
+	}
+}
+
+func pvc(name, labelKey, labelValue string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: "consul",
+		Labels:    map[string]string{labelKey: labelValue},
+	}}
+}
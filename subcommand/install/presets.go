@@ -0,0 +1,77 @@
+package install
+
+import "sort"
+
+// presets are the values of the chart of the -preset flag. They are passed
+// to Helm before the values files, so that the values files and the -set
+// flags take precedence over them.
+var presets = map[string]string{
+	// demo is a minimal installation with a single server, for trying out
+	// Consul on a local cluster such as kind or minikube.
+	"demo": `global:
+  name: consul
+server:
+  replicas: 1
+  bootstrapExpect: 1
+connectInject:
+  enabled: true
+controller:
+  enabled: true
+ui:
+  enabled: true
+`,
+	// secure is an installation with ACLs, TLS and metrics enabled. The
+	// metrics of the agents are left disabled since the chart doesn't
+	// serve them when TLS disables the HTTP port.
+	"secure": `global:
+  name: consul
+  acls:
+    manageSystemACLs: true
+  tls:
+    enabled: true
+    enableAutoEncrypt: true
+    verify: true
+  metrics:
+    enabled: true
+server:
+  replicas: 3
+  bootstrapExpect: 3
+connectInject:
+  enabled: true
+controller:
+  enabled: true
+`,
+	// observability is an installation exporting the metrics of Consul and
+	// of the sidecars to a Prometheus server installed with the chart, and
+	// showing them in the UI.
+	"observability": `global:
+  name: consul
+  metrics:
+    enabled: true
+    enableAgentMetrics: true
+connectInject:
+  enabled: true
+  metrics:
+    defaultEnabled: true
+    defaultEnableMerging: true
+controller:
+  enabled: true
+prometheus:
+  enabled: true
+ui:
+  enabled: true
+  metrics:
+    enabled: true
+    provider: prometheus
+`,
+}
+
+// presetNames returns the sorted names of the presets.
+func presetNames() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,46 @@
+package subcommand
+
+import (
+	"net"
+	"strings"
+)
+
+// bracketIPv6 returns addr with its host in brackets if the host is an
+// unbracketed IPv6 address. This is the case for addresses built by
+// appending a port to the host IP, e.g. "$(HOST_IP):8500", on IPv6 and
+// dual-stack clusters, which the Consul API client can't parse. Since
+// "fd00::1:8500" is ambiguous, an unbracketed address is assumed to end in
+// a port whenever the rest of it is a valid IPv6 address. Any scheme is
+// preserved and all other addresses are returned unchanged.
+func bracketIPv6(addr string) string {
+	var scheme string
+	if i := strings.Index(addr, "://"); i >= 0 {
+		scheme, addr = addr[:i+3], addr[i+3:]
+	}
+	if strings.HasPrefix(addr, "[") || strings.Count(addr, ":") < 2 {
+		return scheme + addr
+	}
+
+	if i := strings.LastIndex(addr, ":"); i > 0 {
+		host, port := addr[:i], addr[i+1:]
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil && isPort(port) {
+			return scheme + net.JoinHostPort(host, port)
+		}
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		return scheme + "[" + addr + "]"
+	}
+	return scheme + addr
+}
+
+func isPort(s string) bool {
+	if s == "" || len(s) > 5 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -1,50 +1,195 @@
 package subcommand
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
+	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul-k8s/helper/acl"
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/helper/metrics"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/consul/command/services"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
-	"github.com/prometheus/common/log"
+	"github.com/radovskyb/watcher"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 type Command struct {
 	UI cli.Ui
 
-	http              *flags.HTTPFlags
-	flagServiceConfig string
-	flagConsulBinary  string
-	flagSyncPeriod    time.Duration
-	flagSet           *flag.FlagSet
-	flagLogLevel      string
+	http               *flags.HTTPFlags
+	logging            *k8sflags.LogFlags
+	flagServiceConfigs []string
+	flagConsulBinary   string
+	flagSyncPeriod     time.Duration
+	flagMaxSyncPeriod  time.Duration
+	flagSet            *flag.FlagSet
+	configFile         *k8sflags.ConfigFileFlags
 
-	consulCommand []string
+	// Flags to support logging in with an auth method.
+	flagAuthMethod          string
+	flagAuthMethodNamespace string
+	flagBearerTokenFile     string
+	flagTokenSinkFile       string
+	flagLoginMeta           map[string]string
+	flagTokenCheckPeriod    time.Duration
+
+	// Flags to support the registration circuit breaker and surfacing
+	// persistent failures as events on our pod.
+	flagFailureThreshold int
+	flagEmitPodEvents    bool
+	flagPodName          string
+	flagPodNamespace     string
+
+	flagHealthListen    string
+	flagLifecycleListen string
+
+	// Flags to support serving merged Envoy and service metrics.
+	flagMergedMetricsListen string
+	flagServiceMetricsURL   string
+	flagEnvoyMetricsURL     string
+	flagEnvoyMetricsAllow   string
+	flagEnvoyMetricsDrop    string
+	flagMergedMetricsLabels map[string]string
+
+	// Flags to serve the metrics and profiles of the sidecar itself.
+	flagMetricsAddr string
+	flagEnablePprof bool
+
+	// Flags to support draining Envoy on shutdown.
+	flagEnvoyAdminAddr    string
+	flagDrainTimeout      time.Duration
+	flagDrainCompleteFile string
+
+	consulClient *api.Client
+	clientset    kubernetes.Interface
+
+	// envoyMetricsAllow and envoyMetricsDrop are the compiled
+	// -envoy-metrics-allow and -envoy-metrics-drop regular expressions.
+	envoyMetricsAllow *regexp.Regexp
+	envoyMetricsDrop  *regexp.Regexp
+
+	// registrations track the services of each service config file. Each
+	// file is registered, watched and re-registered independently.
+	registrations []*registration
+
+	// configPollInterval is how often the service config files are checked
+	// for changes. It defaults to 1s and is exposed for setting in tests.
+	configPollInterval time.Duration
+
+	// aclToken is the *api.ACLToken acquired through the auth method.
+	// tokenFromLogin is true if the sidecar created it by logging in.
+	aclToken       atomic.Value
+	tokenFromLogin bool
+
+	// drainPollInterval is how often Envoy's active connections are checked
+	// while draining. It defaults to 1s and is exposed for setting in tests.
+	drainPollInterval time.Duration
 
 	once  sync.Once
 	help  string
 	sigCh chan os.Signal
+
+	// quitCh receives a value when the application asks the sidecar to
+	// shut down through the /quitquitquit lifecycle endpoint.
+	quitCh chan struct{}
 }
 
 func (c *Command) init() {
 	c.flagSet = flag.NewFlagSet("", flag.ContinueOnError)
-	c.flagSet.StringVar(&c.flagServiceConfig, "service-config", "", "Path to the service config file")
+	c.flagSet.Var((*flags.AppendSliceValue)(&c.flagServiceConfigs), "service-config",
+		"Path to a service config file. May be specified multiple times, e.g. for pods "+
+			"running multiple services. Each file is synced independently.")
 	c.flagSet.StringVar(&c.flagConsulBinary, "consul-binary", "consul", "Path to a consul binary")
-	c.flagSet.DurationVar(&c.flagSyncPeriod, "sync-period", 10*time.Second, "Time between syncing the service registration. Defaults to 10s.")
-	c.flagSet.StringVar(&c.flagLogLevel, "log-level", "info",
-		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
-			"\"debug\", \"info\", \"warn\", and \"error\". Defaults to info.")
+	c.flagSet.DurationVar(&c.flagSyncPeriod, "sync-period", 10*time.Second,
+		"Initial time between retries when the service registration could not be synced. "+
+			"The time doubles with every failed attempt, with jitter, up to -max-sync-period. Defaults to 10s.")
+	c.flagSet.DurationVar(&c.flagMaxSyncPeriod, "max-sync-period", 5*time.Minute,
+		"Maximum time between retries when the service registration could not be synced. Defaults to 5m.")
+	c.flagSet.IntVar(&c.flagFailureThreshold, "failure-threshold", 5,
+		"Number of consecutive failed sync attempts after which the circuit breaker opens. While "+
+			"open, the failure is reported by the readiness endpoint and syncing is only retried "+
+			"every -max-sync-period. Defaults to 5.")
+	c.flagSet.BoolVar(&c.flagEmitPodEvents, "emit-pod-events", false,
+		"Create events on the pod when the circuit breaker opens and closes. Requires "+
+			"-pod-name and -pod-namespace, and permission to create events.")
+	c.flagSet.StringVar(&c.flagPodName, "pod-name", "", "Name of the pod the sidecar runs in.")
+	c.flagSet.StringVar(&c.flagPodNamespace, "pod-namespace", "", "Namespace of the pod the sidecar runs in.")
+	c.flagSet.StringVar(&c.flagAuthMethod, "auth-method", "",
+		"The name of the Kubernetes auth method to log in with. If set, the sidecar "+
+			"acquires its own ACL token, writes it to -token-sink-file and logs in again "+
+			"before the token expires.")
+	c.flagSet.StringVar(&c.flagAuthMethodNamespace, "auth-method-namespace", "",
+		"[Enterprise Only] The Consul namespace the auth method is defined in.")
+	c.flagSet.StringVar(&c.flagBearerTokenFile, "bearer-token-file", acl.DefaultBearerTokenFile,
+		"Path to the Kubernetes service account token to log in with.")
+	c.flagSet.StringVar(&c.flagTokenSinkFile, "token-sink-file", "",
+		"Path to the file the ACL token is written to. Must be set if -auth-method is set.")
+	c.flagSet.Var((*flags.FlagMapValue)(&c.flagLoginMeta), "login-meta",
+		"Metadata to set on the token created by logging in, formatted as key=value. "+
+			"May be specified multiple times.")
+	c.flagSet.DurationVar(&c.flagTokenCheckPeriod, "token-check-period", 1*time.Minute,
+		"Time between checking whether the ACL token is still valid if -auth-method is set. Defaults to 1m.")
+	c.flagSet.StringVar(&c.flagHealthListen, "health-listen", "",
+		"Address to serve the /live and /ready endpoints, and their /health/live and /health/ready aliases, "+
+			"on, e.g. \":21000\". "+
+			"If blank, the health endpoints are disabled.")
+	c.flagSet.StringVar(&c.flagLifecycleListen, "lifecycle-listen", "",
+		"Address to serve the /ready and /quitquitquit lifecycle endpoints for the application on, "+
+			"e.g. \"127.0.0.1:21001\". If blank, the lifecycle endpoints are disabled.")
+	c.flagSet.StringVar(&c.flagMergedMetricsListen, "merged-metrics-listen", "",
+		"Address to serve the merged Envoy and service metrics on at /metrics, e.g. \":20100\". "+
+			"If blank, metrics merging is disabled.")
+	c.flagSet.StringVar(&c.flagMetricsAddr, "metrics-addr", "",
+		"Address to serve the metrics of the sidecar itself on at /metrics, e.g. \":20101\". "+
+			"If blank, they aren't served.")
+	c.flagSet.BoolVar(&c.flagEnablePprof, "enable-pprof", false,
+		"If true, the pprof profiles are served on /debug/pprof/ on -metrics-addr, e.g. to profile the memory "+
+			"and CPU usage in place. Requires -metrics-addr.")
+	c.flagSet.StringVar(&c.flagServiceMetricsURL, "service-metrics-url", "",
+		"URL of the service's Prometheus metrics, e.g. \"http://127.0.0.1:8080/metrics\".")
+	c.flagSet.StringVar(&c.flagEnvoyMetricsURL, "envoy-metrics-url", "",
+		"URL of Envoy's Prometheus metrics, e.g. \"http://127.0.0.1:19000/stats/prometheus\".")
+	c.flagSet.StringVar(&c.flagEnvoyMetricsAllow, "envoy-metrics-allow", "",
+		"Regular expression matching the names of the Envoy metrics to merge. If blank, all "+
+			"Envoy metrics are merged.")
+	c.flagSet.StringVar(&c.flagEnvoyMetricsDrop, "envoy-metrics-drop", "",
+		"Regular expression matching the names of Envoy metrics to drop from the merged "+
+			"metrics, e.g. \"_bucket$\" to drop histograms. Applied after -envoy-metrics-allow.")
+	c.flagSet.Var((*flags.FlagMapValue)(&c.flagMergedMetricsLabels), "merged-metrics-label",
+		"Label to add to every merged metric, formatted as key=value. May be specified multiple times.")
+	c.flagSet.StringVar(&c.flagEnvoyAdminAddr, "envoy-admin-addr", "",
+		"Address of the Envoy admin API, e.g. \"127.0.0.1:19000\". If set, Envoy's listeners "+
+			"are drained when the sidecar receives SIGTERM, before the services are deregistered.")
+	c.flagSet.DurationVar(&c.flagDrainTimeout, "drain-timeout", 15*time.Second,
+		"Maximum time to wait for Envoy's active connections to finish when draining. Defaults to 15s.")
+	c.flagSet.StringVar(&c.flagDrainCompleteFile, "drain-complete-file", "",
+		"Optional file to write once the sidecar has finished draining and deregistering on SIGTERM.")
 
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flagSet, c.configFile.Flags())
 	c.help = flags.Usage(help, c.flagSet)
 	c.http = &flags.HTTPFlags{}
 	flags.Merge(c.flagSet, c.http.ClientFlags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flagSet, c.logging.Flags())
 	c.help = flags.Usage(help, c.flagSet)
 
 	// Wait on an interrupt to exit. This channel must be initialized before
@@ -52,13 +197,19 @@ func (c *Command) init() {
 	// is not defined.
 	if c.sigCh == nil {
 		c.sigCh = make(chan os.Signal, 1)
-		signal.Notify(c.sigCh, os.Interrupt)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+	if c.quitCh == nil {
+		c.quitCh = make(chan struct{}, 1)
 	}
 }
 
 // Run continually re-registers the service with Consul.
 // This is needed because if the Consul Client pod is restarted, it loses all
 // its service registrations.
+// All other duties of the sidecar, e.g. renewing the ACL token or serving
+// merged metrics, run as subsystems of the same process if enabled by
+// their flags.
 // This command expects to be run as a sidecar and to be injected by the
 // mutating webhook.
 func (c *Command) Run(args []string) int {
@@ -66,6 +217,10 @@ func (c *Command) Run(args []string) int {
 	if err := c.flagSet.Parse(args); err != nil {
 		return 1
 	}
+	if err := c.configFile.Apply(c.flagSet); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	err := c.validateFlags()
 	if err != nil {
@@ -73,53 +228,325 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
-	logger := hclog.New(&hclog.LoggerOptions{
-		Level:  hclog.LevelFromString(c.flagLogLevel),
-		Output: os.Stderr,
-	})
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error("Error: " + err.Error())
+		return 1
+	}
+
+	// On IPv6 clusters the agent address is built from an unbracketed host
+	// IP, so we fix it up before it's used by the API client and passed on
+	// to the consul binary.
+	addr := c.http.Addr()
+	if addr == "" {
+		addr = os.Getenv(api.HTTPAddrEnvName)
+	}
+	if bracketed := bracketIPv6(addr); bracketed != addr {
+		if err := c.flagSet.Set("http-addr", bracketed); err != nil {
+			c.UI.Error(fmt.Sprintf("Error setting -http-addr: %s", err))
+			return 1
+		}
+	}
 
 	// Log initial configuration
-	logger.Info("Command configuration", "service-config", c.flagServiceConfig,
+	logger.Info("Command configuration", "service-config", strings.Join(c.flagServiceConfigs, ","),
 		"consul-binary", c.flagConsulBinary,
 		"sync-period", c.flagSyncPeriod,
-		"log-level", c.flagLogLevel)
-
-	c.consulCommand = []string{"services", "register"}
-	c.consulCommand = append(c.consulCommand, c.parseConsulFlags()...)
-	c.consulCommand = append(c.consulCommand, c.flagServiceConfig)
-
-	// The main work loop. We continually re-register our service every
-	// syncPeriod. Consul is smart enough to know when the service hasn't changed
-	// and so won't update any indices. This means we won't be causing a lot
-	// of traffic within the cluster. We tolerate Consul Clients going down
-	// and will simply re-register once it's back up.
-	//
-	// The loop will only exit when the Pod is shut down and we receive a SIGINT.
+		"max-sync-period", c.flagMaxSyncPeriod,
+		"log-level", c.logging.Level())
+
+	c.registrations = nil
+	for _, path := range c.flagServiceConfigs {
+		r := &registration{path: path}
+		r.consulCommand = []string{"services", "register"}
+		r.consulCommand = append(r.consulCommand, c.parseConsulFlags()...)
+		r.consulCommand = append(r.consulCommand, path)
+
+		// Each file backs off on its own. The jitter keeps the sidecars of
+		// many pods from retrying in lockstep against a recovering agent.
+		r.backoff = backoff.NewExponentialBackOff()
+		r.backoff.InitialInterval = c.flagSyncPeriod
+		r.backoff.MaxInterval = c.flagMaxSyncPeriod
+		r.backoff.Multiplier = 2
+		r.backoff.MaxElapsedTime = 0
+		r.backoff.Reset()
+
+		r.services, err = services.ServicesFromFiles([]string{path})
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error parsing -service-config file %q: %s", path, err))
+			return 1
+		}
+		c.registrations = append(c.registrations, r)
+	}
+
+	if c.consulClient == nil {
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
+		c.consulClient, err = consul.NewClient(cfg)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating Consul API client: %s", err))
+			return 1
+		}
+	}
+
+	if c.flagEmitPodEvents && c.clientset == nil {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error loading in-cluster K8S config: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating K8S client: %s", err))
+			return 1
+		}
+	}
+
+	// Watch the service config files so that changes to the service
+	// definitions, e.g. new tags, meta or upstreams, are registered without
+	// restarting the pod. We set up the watchers before the first
+	// registration so that we can't miss a change.
+	pollInterval := c.configPollInterval
+	if pollInterval == 0 {
+		pollInterval = 1 * time.Second
+	}
+	for _, r := range c.registrations {
+		r.watcher = watcher.New()
+		defer r.watcher.Close()
+		r.watcher.SetMaxEvents(1)
+		r.watcher.FilterOps(watcher.Write, watcher.Create, watcher.Rename, watcher.Move)
+		if err := r.watcher.Add(r.path); err != nil {
+			c.UI.Error(fmt.Sprintf("Error watching -service-config file %q: %s", r.path, err))
+			return 1
+		}
+		go r.watcher.Start(pollInterval)
+		r.watcher.Wait()
+	}
+
+	// The endpoints are served right away so that the health probes pass
+	// while we acquire an ACL token.
+	sup := newSupervisor(logger)
+	sup.start(
+		subsystem{name: "health", enabled: c.flagHealthListen != "", run: c.serveHealth},
+		subsystem{name: "lifecycle", enabled: c.flagLifecycleListen != "", run: c.serveLifecycle},
+		subsystem{name: "metrics", enabled: c.flagMergedMetricsListen != "", run: c.serveMergedMetrics},
+		subsystem{name: "telemetry", enabled: c.flagMetricsAddr != "", run: c.serveMetrics},
+	)
+
+	// If we're responsible for our own ACL token, acquire it before
+	// registering and keep it valid for as long as we run.
+	if c.loginEnabled() && !c.acquireToken(logger) {
+		sup.stop()
+		return 0
+	}
+	sup.start(
+		subsystem{name: "token", enabled: c.loginEnabled(), run: c.watchToken},
+		subsystem{name: "registration", enabled: true, run: c.syncRegistrations},
+	)
+
+	// Run until we receive a SIGINT or SIGTERM or the application asks us
+	// to quit.
+	var sig os.Signal
+	select {
+	case sig = <-c.sigCh:
+	case <-c.quitCh:
+	}
+	sup.stop()
+
+	// The application is done, e.g. a Job has completed, so we take the
+	// pod out of service and stop Envoy so that the pod can complete.
+	if sig == nil {
+		logger.Info("quit requested, shutting down")
+		c.shutdown(logger)
+		if c.flagEnvoyAdminAddr != "" {
+			if err := c.quitEnvoy(); err != nil {
+				logger.Error("failed to stop Envoy", "envoy-admin-addr", c.flagEnvoyAdminAddr, "err", err)
+			}
+		}
+		return 0
+	}
+
+	// On SIGTERM the pod is being deleted, so we take it out of service
+	// gracefully. SIGINT exits immediately.
+	if sig == syscall.SIGTERM {
+		logger.Info("SIGTERM received, draining and shutting down")
+		c.shutdown(logger)
+		return 0
+	}
+	logger.Info("SIGINT received, shutting down")
+	return 0
+}
+
+// syncRegistrations syncs each service config file in its own goroutine
+// until ctx is cancelled.
+func (c *Command) syncRegistrations(ctx context.Context, logger hclog.Logger) {
+	var wg sync.WaitGroup
+	for _, r := range c.registrations {
+		wg.Add(1)
+		go func(r *registration) {
+			defer wg.Done()
+			c.syncRegistration(ctx, logger.With("service-config", r.path), r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// syncRegistration is the work loop for a single service config file. We
+// register its services and then watch the local agent with a blocking
+// query until our registration changes or goes away, e.g. because the Consul
+// client was restarted and lost its registrations. Only then do we
+// re-register. This keeps the sidecar idle while the registration is healthy
+// instead of re-registering on a timer. If registration fails, we retry with
+// exponential backoff and jitter, starting at syncPeriod, until the circuit
+// breaker opens after failureThreshold attempts. From then on we retry every
+// maxSyncPeriod. We tolerate Consul Clients going down and will simply
+// re-register once it's back up.
+//
+// The loop only exits when ctx is cancelled.
+func (c *Command) syncRegistration(ctx context.Context, logger hclog.Logger, r *registration) {
 	for {
-		cmd := exec.Command(c.flagConsulBinary, c.consulCommand...)
+		watchCtx, cancel := context.WithCancel(ctx)
+		cmd := exec.Command(c.flagConsulBinary, r.consulCommand...)
 
 		// Run the command and record the stdout and stderr output
+		var retryCh <-chan time.Time
+		var changedCh chan error
+		start := time.Now()
 		output, err := cmd.CombinedOutput()
+		metrics.ObserveOperation("register", start, err)
+		prevFailures := r.status.record(err)
 		if err != nil {
-			logger.Error("failed to sync service", "output", string(output), "err", err)
+			delay := r.backoff.NextBackOff()
+			if failures := prevFailures + 1; c.circuitOpen(failures) {
+				delay = c.flagMaxSyncPeriod
+				if !c.circuitOpen(prevFailures) {
+					logger.Warn("service registration keeps failing, opening circuit breaker", "failures", failures)
+					c.emitPodEvent(logger, corev1.EventTypeWarning, eventReasonRegistrationFailing,
+						fmt.Sprintf("Registering the services in %s failed %d times in a row: %s", r.path, failures, err))
+				}
+			}
+			logger.Error("failed to sync service", "output", string(output), "err", err, "retry-in", delay)
+			retryCh = time.After(delay)
 		} else {
+			r.backoff.Reset()
+			if c.circuitOpen(prevFailures) {
+				logger.Info("service registration recovered, closing circuit breaker")
+				c.emitPodEvent(logger, corev1.EventTypeNormal, eventReasonRegistrationRecovered,
+					fmt.Sprintf("Registered the services in %s after %d failed attempts", r.path, prevFailures))
+			}
 			logger.Info("successfully synced service", "output", string(output))
+			changedCh = make(chan error, 1)
+			go func(svcs []*api.AgentServiceRegistration) {
+				changedCh <- c.waitForChange(watchCtx, logger, svcs)
+			}(r.services)
 		}
 
-		// Re-loop after syncPeriod or exit if we receive an interrupt.
+		// Re-loop once the registration needs to be synced again or exit if
+		// we're shutting down.
 		select {
-		case <-time.After(c.flagSyncPeriod):
+		case <-retryCh:
+		case err := <-changedCh:
+			logger.Info("service registration needs to be synced", "reason", err)
+		case <-r.watcher.Event:
+			logger.Info("service config file has changed, reloading")
+			if err := c.reloadServices(logger, r); err != nil {
+				logger.Error("failed to reload service config file", "err", err)
+			}
+		case err := <-r.watcher.Error:
+			logger.Error("error watching service config file", "err", err)
+		case <-ctx.Done():
+			cancel()
+			return
+		}
+		cancel()
+	}
+}
+
+// reloadServices re-reads the service config file of r. Services that are
+// no longer defined in the file are deregistered from the local agent; new
+// and changed services will be registered by the work loop.
+func (c *Command) reloadServices(logger hclog.Logger, r *registration) error {
+	svcs, err := services.ServicesFromFiles([]string{r.path})
+	if err != nil {
+		return err
+	}
+
+	defined := make(map[string]struct{})
+	for _, svc := range svcs {
+		defined[svc.Namespace+"/"+serviceID(svc)] = struct{}{}
+	}
+	for _, svc := range r.services {
+		if _, ok := defined[svc.Namespace+"/"+serviceID(svc)]; ok {
 			continue
-		case <-c.sigCh:
-			log.Info("SIGINT received, shutting down")
-			return 0
 		}
+		args := []string{"services", "deregister"}
+		args = append(args, c.parseConsulFlags()...)
+		if svc.Namespace != "" {
+			args = append(args, "-namespace="+svc.Namespace)
+		}
+		args = append(args, "-id="+serviceID(svc))
+		output, err := exec.Command(c.flagConsulBinary, args...).CombinedOutput()
+		if err != nil {
+			logger.Error("failed to deregister removed service", "service-id", serviceID(svc), "output", string(output), "err", err)
+			continue
+		}
+		logger.Info("deregistered removed service", "service-id", serviceID(svc))
+	}
+
+	r.services = svcs
+	return nil
+}
+
+// waitForChange blocks until any of our services is missing from the local
+// agent or its definition has changed, or the agent can't be reached. It
+// returns an error describing why the services need to be re-registered.
+//
+// All services in the config file are registered together, so we only hold
+// a blocking query open on the first one, but we check that every service
+// exists before and after each blocking query.
+func (c *Command) waitForChange(ctx context.Context, logger hclog.Logger, svcs []*api.AgentServiceRegistration) error {
+	if len(svcs) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	watched := svcs[0]
+	var hash string
+	for {
+		for _, svc := range svcs {
+			opts := &api.QueryOptions{Namespace: svc.Namespace, Token: c.currentToken()}
+			if _, _, err := c.consulClient.Agent().Service(serviceID(svc), opts.WithContext(ctx)); err != nil {
+				return fmt.Errorf("getting service %q: %s", serviceID(svc), err)
+			}
+		}
+
+		opts := &api.QueryOptions{Namespace: watched.Namespace, WaitHash: hash, Token: c.currentToken()}
+		_, meta, err := c.consulClient.Agent().Service(serviceID(watched), opts.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("watching service %q: %s", serviceID(watched), err)
+		}
+
+		// The first time around we only learn the current hash.
+		if hash != "" && meta.LastContentHash != hash {
+			return fmt.Errorf("service %q has changed", serviceID(watched))
+		}
+		logger.Debug("service registration is unchanged", "service-id", serviceID(watched))
+		hash = meta.LastContentHash
 	}
 }
 
+// serviceID returns the ID the agent registers svc under. Like Consul,
+// it defaults to the service name if no ID is set.
+func serviceID(svc *api.AgentServiceRegistration) string {
+	if svc.ID != "" {
+		return svc.ID
+	}
+	return svc.Name
+}
+
 // validateFlags validates the flags and returns the logLevel.
 func (c *Command) validateFlags() error {
-	if c.flagServiceConfig == "" {
+	if len(c.flagServiceConfigs) == 0 {
 		return errors.New("-service-config must be set")
 	}
 	if c.flagConsulBinary == "" {
@@ -131,19 +558,56 @@ func (c *Command) validateFlags() error {
 		// to terminate the command gracefully with SIGINT.
 		return errors.New("-sync-period must be greater than 0")
 	}
+	if c.flagMaxSyncPeriod < c.flagSyncPeriod {
+		return errors.New("-max-sync-period must not be less than -sync-period")
+	}
+	if c.flagFailureThreshold <= 0 {
+		return errors.New("-failure-threshold must be greater than 0")
+	}
+	if c.flagEmitPodEvents && (c.flagPodName == "" || c.flagPodNamespace == "") {
+		return errors.New("-pod-name and -pod-namespace must be set if -emit-pod-events is set")
+	}
+
+	if c.flagDrainTimeout < 0 {
+		return errors.New("-drain-timeout must not be negative")
+	}
+	if c.flagAuthMethod != "" && c.flagTokenSinkFile == "" {
+		return errors.New("-token-sink-file must be set if -auth-method is set")
+	}
+	if c.flagAuthMethod != "" && c.flagTokenCheckPeriod <= 0 {
+		return errors.New("-token-check-period must be greater than 0")
+	}
 
-	_, err := os.Stat(c.flagServiceConfig)
-	if os.IsNotExist(err) {
-		err = fmt.Errorf("-service-config file %q not found", c.flagServiceConfig)
-		return fmt.Errorf("-service-config file %q not found", c.flagServiceConfig)
+	if c.flagMergedMetricsListen != "" && c.flagEnvoyMetricsURL == "" && c.flagServiceMetricsURL == "" {
+		return errors.New("-envoy-metrics-url or -service-metrics-url must be set if -merged-metrics-listen is set")
+	}
+	if c.flagEnablePprof && c.flagMetricsAddr == "" {
+		return errors.New("-metrics-addr must be set if -enable-pprof is set")
+	}
+	var err error
+	if c.flagEnvoyMetricsAllow != "" {
+		if c.envoyMetricsAllow, err = regexp.Compile(c.flagEnvoyMetricsAllow); err != nil {
+			return fmt.Errorf("-envoy-metrics-allow is invalid: %s", err)
+		}
+	}
+	if c.flagEnvoyMetricsDrop != "" {
+		if c.envoyMetricsDrop, err = regexp.Compile(c.flagEnvoyMetricsDrop); err != nil {
+			return fmt.Errorf("-envoy-metrics-drop is invalid: %s", err)
+		}
+	}
+
+	for _, path := range c.flagServiceConfigs {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return fmt.Errorf("-service-config file %q not found", path)
+		}
 	}
 	_, err = exec.LookPath(c.flagConsulBinary)
 	if err != nil {
 		return fmt.Errorf("-consul-binary %q not found: %s", c.flagConsulBinary, err)
 	}
-	logLevel := hclog.LevelFromString(c.flagLogLevel)
+	logLevel := hclog.LevelFromString(c.logging.Level())
 	if logLevel == hclog.NoLevel {
-		return fmt.Errorf("unknown log level: %s", c.flagLogLevel)
+		return fmt.Errorf("unknown log level: %s", c.logging.Level())
 	}
 
 	return nil
@@ -154,10 +618,18 @@ func (c *Command) validateFlags() error {
 func (c *Command) parseConsulFlags() []string {
 	var consulCommandFlags []string
 	c.http.ClientFlags().VisitAll(func(f *flag.Flag) {
+		// If we log in ourselves, the token is always read from the
+		// token sink file so that renewed tokens are picked up.
+		if c.loginEnabled() && (f.Name == "token" || f.Name == "token-file") {
+			return
+		}
 		if f.Value.String() != "" {
 			consulCommandFlags = append(consulCommandFlags, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
 		}
 	})
+	if c.loginEnabled() {
+		consulCommandFlags = append(consulCommandFlags, "-token-file="+c.flagTokenSinkFile)
+	}
 	return consulCommandFlags
 }
 
@@ -1,19 +1,32 @@
 package subcommand
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/cenkalti/backoff"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/freeport"
 	"github.com/hashicorp/consul/sdk/testutil"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
+	"github.com/radovskyb/watcher"
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestRun_Defaults(t *testing.T) {
@@ -21,7 +34,9 @@ func TestRun_Defaults(t *testing.T) {
 	var cmd Command
 	cmd.init()
 	require.Equal(t, 10*time.Second, cmd.flagSyncPeriod)
-	require.Equal(t, "info", cmd.flagLogLevel)
+	require.Equal(t, 5*time.Minute, cmd.flagMaxSyncPeriod)
+	require.Equal(t, 5, cmd.flagFailureThreshold)
+	require.Equal(t, "info", cmd.logging.Level())
 	require.Equal(t, "consul", cmd.flagConsulBinary)
 }
 
@@ -50,6 +65,74 @@ func TestRun_FlagValidation(t *testing.T) {
 			},
 			ExpErr: "-sync-period must be greater than 0",
 		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-consul-binary=consul",
+				"-sync-period=10s",
+				"-max-sync-period=5s",
+			},
+			ExpErr: "-max-sync-period must not be less than -sync-period",
+		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-consul-binary=consul",
+				"-failure-threshold=0",
+			},
+			ExpErr: "-failure-threshold must be greater than 0",
+		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-consul-binary=consul",
+				"-emit-pod-events",
+				"-pod-name=web",
+			},
+			ExpErr: "-pod-name and -pod-namespace must be set if -emit-pod-events is set",
+		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-consul-binary=consul",
+				"-auth-method=auth-method",
+			},
+			ExpErr: "-token-sink-file must be set if -auth-method is set",
+		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-consul-binary=consul",
+				"-auth-method=auth-method",
+				"-token-sink-file=/acl-token",
+				"-token-check-period=0s",
+			},
+			ExpErr: "-token-check-period must be greater than 0",
+		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-consul-binary=consul",
+				"-merged-metrics-listen=:20100",
+			},
+			ExpErr: "-envoy-metrics-url or -service-metrics-url must be set if -merged-metrics-listen is set",
+		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-consul-binary=consul",
+				"-enable-pprof",
+			},
+			ExpErr: "-metrics-addr must be set if -enable-pprof is set",
+		},
+		{
+			Flags: []string{
+				"-service-config=/config.hcl",
+				"-consul-binary=consul",
+				"-envoy-metrics-drop=(",
+			},
+			ExpErr: "-envoy-metrics-drop is invalid",
+		},
 	}
 
 	for _, c := range cases {
@@ -171,6 +254,7 @@ func TestRun_ServicesRegistration_ConsulDown(t *testing.T) {
 		"-http-addr", fmt.Sprintf("127.0.0.1:%d", randomPorts[1]),
 		"-service-config", configFile,
 		"-sync-period", "100ms",
+		"-max-sync-period", "100ms",
 	})
 	defer stopCommand(t, &cmd, exitChan)
 
@@ -208,6 +292,173 @@ func TestRun_ServicesRegistration_ConsulDown(t *testing.T) {
 	})
 }
 
+// Test that we register the services of every service config file.
+func TestRun_ServicesRegistration_MultipleServiceConfigs(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, configFile := createServicesTmpFile(t, servicesRegistration)
+	defer os.RemoveAll(tmpDir)
+	otherConfigFile := filepath.Join(tmpDir, "other.hcl")
+	require.NoError(t, ioutil.WriteFile(otherConfigFile, []byte(`
+services {
+	id   = "other-id"
+	name = "other"
+	port = 81
+}`), 0600))
+
+	a, err := testutil.NewTestServerT(t)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+	}
+
+	// Run async because we need to kill it when the test is over.
+	exitChan := runCommandAsynchronously(&cmd, []string{
+		"-http-addr", a.HTTPAddr,
+		"-service-config", configFile,
+		"-service-config", otherConfigFile,
+		"-sync-period", "100ms",
+	})
+	defer stopCommand(t, &cmd, exitChan)
+
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	timer := &retry.Timer{Timeout: 1 * time.Second, Wait: 100 * time.Millisecond}
+	retry.RunWith(timer, t, func(r *retry.R) {
+		svc, _, err := client.Agent().Service("service-id", nil)
+		require.NoError(r, err)
+		require.Equal(r, 80, svc.Port)
+
+		other, _, err := client.Agent().Service("other-id", nil)
+		require.NoError(r, err)
+		require.Equal(r, 81, other.Port)
+	})
+
+	// The other service is re-registered on its own when it goes away.
+	require.NoError(t, client.Agent().ServiceDeregister("other-id"))
+	retry.RunWith(timer, t, func(r *retry.R) {
+		other, _, err := client.Agent().Service("other-id", nil)
+		require.NoError(r, err)
+		require.Equal(r, 81, other.Port)
+	})
+}
+
+// Test that we re-register the services as soon as they're removed from the
+// agent rather than waiting for the sync period.
+func TestRun_ServicesRegistration_ReregistersOnChange(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, configFile := createServicesTmpFile(t, servicesRegistration)
+	defer os.RemoveAll(tmpDir)
+
+	a, err := testutil.NewTestServerT(t)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI: ui,
+	}
+
+	// Run async because we need to kill it when the test is over.
+	exitChan := runCommandAsynchronously(&cmd, []string{
+		"-http-addr", a.HTTPAddr,
+		"-service-config", configFile,
+		"-sync-period", "1h",
+	})
+	defer stopCommand(t, &cmd, exitChan)
+
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	timer := &retry.Timer{Timeout: 1 * time.Second, Wait: 100 * time.Millisecond}
+	retry.RunWith(timer, t, func(r *retry.R) {
+		svc, _, err := client.Agent().Service("service-id", nil)
+		require.NoError(r, err)
+		require.Equal(r, 80, svc.Port)
+	})
+
+	// Simulate the agent losing our registrations, e.g. after a restart.
+	require.NoError(t, client.Agent().ServiceDeregister("service-id"))
+	require.NoError(t, client.Agent().ServiceDeregister("service-id-sidecar-proxy"))
+
+	retry.RunWith(timer, t, func(r *retry.R) {
+		svc, _, err := client.Agent().Service("service-id", nil)
+		require.NoError(r, err)
+		require.Equal(r, 80, svc.Port)
+
+		svcProxy, _, err := client.Agent().Service("service-id-sidecar-proxy", nil)
+		require.NoError(r, err)
+		require.Equal(r, 2000, svcProxy.Port)
+	})
+}
+
+// Test that we register the new service definition when the service config
+// file changes.
+func TestRun_ServicesRegistration_ConfigFileChanged(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, configFile := createServicesTmpFile(t, servicesRegistration)
+	defer os.RemoveAll(tmpDir)
+
+	a, err := testutil.NewTestServerT(t)
+	require.NoError(t, err)
+	defer a.Stop()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:                 ui,
+		configPollInterval: 100 * time.Millisecond,
+	}
+
+	// Run async because we need to kill it when the test is over.
+	exitChan := runCommandAsynchronously(&cmd, []string{
+		"-http-addr", a.HTTPAddr,
+		"-service-config", configFile,
+		"-sync-period", "1h",
+	})
+	defer stopCommand(t, &cmd, exitChan)
+
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	timer := &retry.Timer{Timeout: 1 * time.Second, Wait: 100 * time.Millisecond}
+	retry.RunWith(timer, t, func(r *retry.R) {
+		svcProxy, _, err := client.Agent().Service("service-id-sidecar-proxy", nil)
+		require.NoError(r, err)
+		require.Equal(r, 2000, svcProxy.Port)
+	})
+
+	// Change the service port and drop the proxy.
+	err = ioutil.WriteFile(configFile, []byte(`
+services {
+	id   = "service-id"
+	name = "service"
+	port = 8080
+}`), 0600)
+	require.NoError(t, err)
+
+	timer = &retry.Timer{Timeout: 2 * time.Second, Wait: 100 * time.Millisecond}
+	retry.RunWith(timer, t, func(r *retry.R) {
+		svc, _, err := client.Agent().Service("service-id", nil)
+		require.NoError(r, err)
+		require.Equal(r, 8080, svc.Port)
+
+		_, _, err = client.Agent().Service("service-id-sidecar-proxy", nil)
+		require.Error(r, err)
+	})
+}
+
 // Test that we parse all flags and pass them down to the underlying Consul command.
 func TestRun_ConsulCommandFlags(t *testing.T) {
 	t.Parallel()
@@ -254,8 +505,461 @@ func TestRun_ConsulCommandFlags(t *testing.T) {
 	}
 	timer := &retry.Timer{Timeout: 1000 * time.Millisecond, Wait: 100 * time.Millisecond}
 	retry.RunWith(timer, t, func(r *retry.R) {
-		require.ElementsMatch(r, expectedCommand, cmd.consulCommand)
+		require.Len(r, cmd.registrations, 1)
+		require.ElementsMatch(r, expectedCommand, cmd.registrations[0].consulCommand)
+	})
+}
+
+// Test the readiness endpoint reports the agent's connectivity and the
+// outcome of the last sync.
+func TestHandleReady(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		AgentUp bool
+		SyncErr error
+		Synced  bool
+		ExpCode int
+	}{
+		"never synced": {
+			AgentUp: true,
+			ExpCode: http.StatusServiceUnavailable,
+		},
+		"synced": {
+			AgentUp: true,
+			Synced:  true,
+			ExpCode: http.StatusOK,
+		},
+		"last sync failed": {
+			AgentUp: true,
+			Synced:  true,
+			SyncErr: errors.New("exit status 1"),
+			ExpCode: http.StatusServiceUnavailable,
+		},
+		"agent down": {
+			AgentUp: false,
+			Synced:  true,
+			ExpCode: http.StatusServiceUnavailable,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !c.AgentUp {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				w.Write([]byte(`"127.0.0.1:8300"`))
+			}))
+			defer agent.Close()
+			client, err := api.NewClient(&api.Config{Address: agent.URL})
+			require.NoError(t, err)
+
+			// The second service config file is always synced so that we
+			// know a single failing file makes the sidecar unready.
+			reg := &registration{path: "/svc.hcl"}
+			synced := &registration{path: "/other.hcl"}
+			synced.status.record(nil)
+			cmd := Command{
+				consulClient:  client,
+				registrations: []*registration{reg, synced},
+			}
+			if c.Synced {
+				reg.status.record(nil)
+			}
+			if c.SyncErr != nil {
+				reg.status.record(c.SyncErr)
+			}
+
+			rec := httptest.NewRecorder()
+			cmd.handleReady(rec, httptest.NewRequest("GET", "/ready", nil))
+			require.Equal(t, c.ExpCode, rec.Code)
+
+			var resp healthResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			require.Equal(t, c.AgentUp, resp.AgentReachable)
+			require.Len(t, resp.ServiceConfigs, 2)
+			require.Equal(t, "/svc.hcl", resp.ServiceConfigs[0].Path)
+			require.Equal(t, c.Synced, resp.ServiceConfigs[0].LastSyncSuccess != nil)
+			if c.SyncErr != nil {
+				require.Equal(t, c.SyncErr.Error(), resp.ServiceConfigs[0].LastSyncError)
+			}
+			require.NotNil(t, resp.ServiceConfigs[1].LastSyncSuccess)
+		})
+	}
+}
+
+// Test that we fail Envoy's health checks, drain its listeners and wait
+// until there are no more active connections.
+func TestDrainEnvoy(t *testing.T) {
+	t.Parallel()
+
+	var lock sync.Mutex
+	var calls []string
+	active := 2
+	envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		if r.URL.Path != "/stats" {
+			return
+		}
+		// Every time the stats are read, one less connection is active.
+		fmt.Fprintf(w, `{"stats":[
+			{"name":"listener.0.0.0.0_20000.downstream_cx_active","value":%d},
+			{"name":"http.public_listener_http.downstream_cx_active","value":%d},
+			{"name":"listener.admin.downstream_cx_active","value":1}
+		]}`, active, active)
+		if active > 0 {
+			active--
+		}
+	}))
+	defer envoy.Close()
+
+	cmd := Command{
+		flagEnvoyAdminAddr: strings.TrimPrefix(envoy.URL, "http://"),
+		flagDrainTimeout:   5 * time.Second,
+		drainPollInterval:  10 * time.Millisecond,
+	}
+	require.NoError(t, cmd.drainEnvoy(hclog.NewNullLogger()))
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, []string{
+		"POST /healthcheck/fail",
+		"POST /drain_listeners",
+		"GET /stats",
+		"GET /stats",
+		"GET /stats",
+	}, calls)
+}
+
+// Test that we stop waiting for active connections after the drain timeout.
+func TestDrainEnvoy_Timeout(t *testing.T) {
+	t.Parallel()
+
+	envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"stats":[{"name":"listener.0.0.0.0_20000.downstream_cx_active","value":1}]}`))
+	}))
+	defer envoy.Close()
+
+	cmd := Command{
+		flagEnvoyAdminAddr: strings.TrimPrefix(envoy.URL, "http://"),
+		flagDrainTimeout:   100 * time.Millisecond,
+		drainPollInterval:  10 * time.Millisecond,
+	}
+	start := time.Now()
+	require.NoError(t, cmd.drainEnvoy(hclog.NewNullLogger()))
+	require.True(t, time.Since(start) < 1*time.Second)
+}
+
+// Test that the circuit breaker opens after -failure-threshold failed
+// attempts, which is reported by the sync status and a pod event, and closes
+// again once the services are registered.
+func TestSyncRegistration_CircuitBreaker(t *testing.T) {
+	t.Parallel()
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// The fake consul binary fails until the marker file exists.
+	marker := filepath.Join(tmpDir, "agent-up")
+	binary := filepath.Join(tmpDir, "consul")
+	require.NoError(t, ioutil.WriteFile(binary, []byte(fmt.Sprintf("#!/bin/sh\ntest -f %s\n", marker)), 0755))
+
+	// The fake agent reports that the service is unchanged.
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("X-Consul-ContentHash", "hash")
+		w.Write([]byte(`{"ID":"service-id","Service":"web"}`))
+	}))
+	defer agent.Close()
+	client, err := api.NewClient(&api.Config{Address: agent.URL})
+	require.NoError(t, err)
+
+	k8s := fake.NewSimpleClientset()
+	cmd := Command{
+		flagConsulBinary:     binary,
+		flagSyncPeriod:       time.Millisecond,
+		flagMaxSyncPeriod:    10 * time.Millisecond,
+		flagFailureThreshold: 3,
+		flagEmitPodEvents:    true,
+		flagPodName:          "web",
+		flagPodNamespace:     "default",
+		consulClient:         client,
+		clientset:            k8s,
+	}
+	r := &registration{
+		path:     "/svc.hcl",
+		services: []*api.AgentServiceRegistration{{ID: "service-id", Name: "web"}},
+		watcher:  watcher.New(),
+		backoff:  backoff.NewExponentialBackOff(),
+	}
+	r.backoff.InitialInterval = cmd.flagSyncPeriod
+	r.backoff.MaxInterval = cmd.flagMaxSyncPeriod
+	r.backoff.Reset()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cmd.syncRegistration(ctx, hclog.NewNullLogger(), r)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	eventReasons := func(rt *retry.R) []string {
+		events, err := k8s.CoreV1().Events("default").List(metav1.ListOptions{})
+		require.NoError(rt, err)
+		var reasons []string
+		for _, e := range events.Items {
+			require.Equal(rt, "web", e.InvolvedObject.Name)
+			reasons = append(reasons, e.Reason)
+		}
+		return reasons
+	}
+
+	retry.Run(t, func(rt *retry.R) {
+		require.True(rt, cmd.circuitOpen(r.status.failures()))
+		require.Equal(rt, []string{eventReasonRegistrationFailing}, eventReasons(rt))
+	})
+
+	require.NoError(t, ioutil.WriteFile(marker, nil, 0644))
+	retry.Run(t, func(rt *retry.R) {
+		require.Equal(rt, 0, r.status.failures())
+		require.ElementsMatch(rt, []string{eventReasonRegistrationFailing, eventReasonRegistrationRecovered}, eventReasons(rt))
+	})
+}
+
+// Test that the /ready lifecycle endpoint waits for the services to be
+// registered and for Envoy to be ready.
+func TestHandleAppReady(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		Synced     bool
+		EnvoyReady bool
+		ExpCode    int
+	}{
+		"ready": {
+			Synced:     true,
+			EnvoyReady: true,
+			ExpCode:    http.StatusOK,
+		},
+		"not synced": {
+			Synced:     false,
+			EnvoyReady: true,
+			ExpCode:    http.StatusServiceUnavailable,
+		},
+		"envoy not ready": {
+			Synced:     true,
+			EnvoyReady: false,
+			ExpCode:    http.StatusServiceUnavailable,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/ready", r.URL.Path)
+				if !c.EnvoyReady {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Write([]byte("LIVE"))
+			}))
+			defer envoy.Close()
+
+			reg := &registration{path: "/svc.hcl"}
+			if c.Synced {
+				reg.status.record(nil)
+			}
+			cmd := Command{
+				flagEnvoyAdminAddr: strings.TrimPrefix(envoy.URL, "http://"),
+				registrations:      []*registration{reg},
+			}
+
+			rec := httptest.NewRecorder()
+			cmd.handleAppReady(rec, httptest.NewRequest("GET", "/ready", nil))
+			require.Equal(t, c.ExpCode, rec.Code)
+		})
+	}
+}
+
+// Test that /quitquitquit only accepts POST requests and signals the
+// command to quit.
+func TestHandleQuit(t *testing.T) {
+	t.Parallel()
+	cmd := Command{quitCh: make(chan struct{}, 1)}
+
+	rec := httptest.NewRecorder()
+	cmd.handleQuit(rec, httptest.NewRequest("GET", "/quitquitquit", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	require.Len(t, cmd.quitCh, 0)
+
+	// Calling it twice must not block.
+	for i := 0; i < 2; i++ {
+		rec = httptest.NewRecorder()
+		cmd.handleQuit(rec, httptest.NewRequest("POST", "/quitquitquit", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	require.Len(t, cmd.quitCh, 1)
+}
+
+// Test that Envoy and service metrics are merged, Envoy metrics are
+// filtered and labels are added.
+func TestHandleMergedMetrics(t *testing.T) {
+	t.Parallel()
+	envoyMetrics := `# TYPE envoy_cluster_upstream_cx_total counter
+envoy_cluster_upstream_cx_total{envoy_cluster_name="db"} 3
+# TYPE envoy_cluster_upstream_rq_time histogram
+envoy_cluster_upstream_rq_time_bucket{envoy_cluster_name="db",le="0.5"} 1
+envoy_cluster_upstream_rq_time_bucket{envoy_cluster_name="db",le="+Inf"} 1
+envoy_cluster_upstream_rq_time_sum{envoy_cluster_name="db"} 0.1
+envoy_cluster_upstream_rq_time_count{envoy_cluster_name="db"} 1
+# TYPE envoy_server_uptime gauge
+envoy_server_uptime 10
+`
+	envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(envoyMetrics))
+	}))
+	defer envoy.Close()
+	svc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("# TYPE app_requests_total counter\napp_requests_total{pod=\"mine\"} 5\n"))
+	}))
+	defer svc.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+
+	t.Run("merged", func(t *testing.T) {
+		cmd := Command{
+			flagEnvoyMetricsURL:     envoy.URL,
+			flagServiceMetricsURL:   svc.URL,
+			flagMergedMetricsLabels: map[string]string{"pod": "web-1"},
+			envoyMetricsAllow:       regexp.MustCompile("^envoy_cluster_"),
+			envoyMetricsDrop:        regexp.MustCompile("_rq_time$"),
+		}
+		rec := httptest.NewRecorder()
+		cmd.handleMergedMetrics(hclog.NewNullLogger(), rec, httptest.NewRequest("GET", "/metrics", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, `# TYPE app_requests_total counter
+app_requests_total{pod="mine"} 5
+# HELP consul_merged_service_metrics_success Whether the service's metrics could be scraped.
+# TYPE consul_merged_service_metrics_success gauge
+consul_merged_service_metrics_success{pod="web-1"} 1
+# TYPE envoy_cluster_upstream_cx_total counter
+envoy_cluster_upstream_cx_total{envoy_cluster_name="db",pod="web-1"} 3
+`, rec.Body.String())
+	})
+
+	t.Run("service down", func(t *testing.T) {
+		cmd := Command{
+			flagEnvoyMetricsURL:   envoy.URL,
+			flagServiceMetricsURL: down.URL,
+		}
+		rec := httptest.NewRecorder()
+		cmd.handleMergedMetrics(hclog.NewNullLogger(), rec, httptest.NewRequest("GET", "/metrics", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), "consul_merged_service_metrics_success 0\n")
+		require.Contains(t, rec.Body.String(), "envoy_cluster_upstream_rq_time_bucket")
+	})
+
+	t.Run("envoy down", func(t *testing.T) {
+		cmd := Command{
+			flagEnvoyMetricsURL:   down.URL,
+			flagServiceMetricsURL: svc.URL,
+		}
+		rec := httptest.NewRecorder()
+		cmd.handleMergedMetrics(hclog.NewNullLogger(), rec, httptest.NewRequest("GET", "/metrics", nil))
+		require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+}
+
+// Test that the supervisor only runs enabled subsystems and that stop waits
+// for them to return.
+func TestSupervisor(t *testing.T) {
+	t.Parallel()
+	var lock sync.Mutex
+	var ran, stopped []string
+	run := func(name string) func(context.Context, hclog.Logger) {
+		return func(ctx context.Context, _ hclog.Logger) {
+			lock.Lock()
+			ran = append(ran, name)
+			lock.Unlock()
+			<-ctx.Done()
+			lock.Lock()
+			stopped = append(stopped, name)
+			lock.Unlock()
+		}
+	}
+
+	sup := newSupervisor(hclog.NewNullLogger())
+	sup.start(
+		subsystem{name: "enabled", enabled: true, run: run("enabled")},
+		subsystem{name: "disabled", enabled: false, run: run("disabled")},
+	)
+	sup.start(subsystem{name: "later", enabled: true, run: run("later")})
+	retry.Run(t, func(r *retry.R) {
+		lock.Lock()
+		defer lock.Unlock()
+		require.ElementsMatch(r, []string{"enabled", "later"}, ran)
 	})
+
+	sup.stop()
+	require.ElementsMatch(t, []string{"enabled", "later"}, stopped)
+}
+
+// Test that serveHTTP stops serving when its context is cancelled.
+func TestServeHTTP(t *testing.T) {
+	t.Parallel()
+	addr := fmt.Sprintf("127.0.0.1:%d", freeport.MustTake(1)[0])
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		serveHTTP(ctx, hclog.NewNullLogger(), addr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		close(done)
+	}()
+
+	retry.Run(t, func(r *retry.R) {
+		resp, err := http.Get("http://" + addr)
+		require.NoError(r, err)
+		resp.Body.Close()
+		require.Equal(r, http.StatusNoContent, resp.StatusCode)
+	})
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveHTTP did not return after its context was cancelled")
+	}
+	_, err := http.Get("http://" + addr)
+	require.Error(t, err)
+}
+
+func TestBracketIPv6(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"":                         "",
+		"127.0.0.1:8500":           "127.0.0.1:8500",
+		"https://10.0.0.1:8501":    "https://10.0.0.1:8501",
+		"consul.example.com:8500":  "consul.example.com:8500",
+		"[fd00::1]:8500":           "[fd00::1]:8500",
+		"fd00::1:8500":             "[fd00::1]:8500",
+		"https://fd00::1:8501":     "https://[fd00::1]:8501",
+		"fd00::1":                  "[fd00::1]",
+		"::1":                      "[::1]",
+		"unix:///var/consul.sock":  "unix:///var/consul.sock",
+		"2001:db8:0:0:0:0:2:1:443": "[2001:db8:0:0:0:0:2:1]:443",
+	}
+	for addr, exp := range cases {
+		t.Run(addr, func(t *testing.T) {
+			require.Equal(t, exp, bracketIPv6(addr))
+		})
+	}
 }
 
 // This function starts the command asynchronously and returns a non-blocking chan.
@@ -0,0 +1,136 @@
+package subcommand
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// activeConnectionsStat matches the Envoy stats counting the open downstream
+// connections of each listener. The admin listener is excluded since our
+// own requests to the admin API are counted there.
+var activeConnectionsStat = regexp.MustCompile(`^listener\.(.*)\.downstream_cx_active$`)
+
+// envoyStats is the JSON format of Envoy's /stats admin endpoint.
+type envoyStats struct {
+	Stats []struct {
+		Name  string      `json:"name"`
+		Value json.Number `json:"value"`
+	} `json:"stats"`
+}
+
+// shutdown gracefully takes the pod out of service before exiting. If
+// -envoy-admin-addr and -drain-timeout are set, Envoy is told to fail its health checks and
+// drain its listeners, and we wait up to -drain-timeout for in-flight
+// connections to finish. Then the services are deregistered and, if set,
+// -drain-complete-file is written so that Envoy's preStop hook can let
+// Envoy exit.
+func (c *Command) shutdown(logger hclog.Logger) {
+	if c.flagEnvoyAdminAddr != "" && c.flagDrainTimeout > 0 {
+		if err := c.drainEnvoy(logger); err != nil {
+			logger.Error("failed to drain Envoy", "envoy-admin-addr", c.flagEnvoyAdminAddr, "err", err)
+		}
+	}
+
+	for _, r := range c.registrations {
+		args := []string{"services", "deregister"}
+		args = append(args, c.parseConsulFlags()...)
+		args = append(args, r.path)
+		output, err := exec.Command(c.flagConsulBinary, args...).CombinedOutput()
+		if err != nil {
+			logger.Error("failed to deregister services", "service-config", r.path, "output", string(output), "err", err)
+		} else {
+			logger.Info("deregistered services", "service-config", r.path, "output", string(output))
+		}
+	}
+
+	if c.flagDrainCompleteFile != "" {
+		if err := ioutil.WriteFile(c.flagDrainCompleteFile, []byte(time.Now().Format(time.RFC3339)), 0644); err != nil {
+			logger.Error("failed to write drain complete file", "drain-complete-file", c.flagDrainCompleteFile, "err", err)
+		}
+	}
+}
+
+// drainEnvoy causes Envoy to fail its health checks and gracefully drain its
+// listeners, then waits until there are no more active connections or the
+// drain timeout is reached.
+func (c *Command) drainEnvoy(logger hclog.Logger) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, path := range []string{"/healthcheck/fail", "/drain_listeners?graceful"} {
+		if err := c.envoyAdminPost(client, path); err != nil {
+			return err
+		}
+	}
+	logger.Info("draining Envoy listeners", "drain-timeout", c.flagDrainTimeout)
+
+	pollInterval := c.drainPollInterval
+	if pollInterval == 0 {
+		pollInterval = 1 * time.Second
+	}
+	deadline := time.Now().Add(c.flagDrainTimeout)
+	for {
+		active, err := c.envoyActiveConnections(client)
+		if err != nil {
+			return err
+		}
+		if active == 0 {
+			logger.Info("Envoy has no more active connections")
+			return nil
+		}
+		if time.Now().After(deadline) {
+			logger.Warn("drain timeout reached, shutting down with active connections", "active-connections", active)
+			return nil
+		}
+		logger.Debug("waiting for active connections to finish", "active-connections", active)
+		time.Sleep(pollInterval)
+	}
+}
+
+func (c *Command) envoyAdminPost(client *http.Client, path string) error {
+	resp, err := client.Post(fmt.Sprintf("http://%s%s", c.flagEnvoyAdminAddr, path), "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code from Envoy %s: %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// envoyActiveConnections returns the number of active downstream
+// connections across all of Envoy's listeners.
+func (c *Command) envoyActiveConnections(client *http.Client) (int64, error) {
+	resp, err := client.Get(fmt.Sprintf("http://%s/stats?format=json&filter=downstream_cx_active", c.flagEnvoyAdminAddr))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected response code from Envoy /stats: %d", resp.StatusCode)
+	}
+
+	var stats envoyStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return 0, fmt.Errorf("decoding Envoy stats: %s", err)
+	}
+	var active int64
+	for _, stat := range stats.Stats {
+		m := activeConnectionsStat.FindStringSubmatch(stat.Name)
+		if m == nil || m[1] == "admin" {
+			continue
+		}
+		v, err := stat.Value.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("parsing Envoy stat %q: %s", stat.Name, err)
+		}
+		active += v
+	}
+	return active, nil
+}
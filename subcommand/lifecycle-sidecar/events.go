@@ -0,0 +1,60 @@
+package subcommand
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// eventReasonRegistrationFailing is the reason of the event emitted
+	// when the circuit breaker of a service config file opens.
+	eventReasonRegistrationFailing = "ConsulRegistrationFailing"
+
+	// eventReasonRegistrationRecovered is the reason of the event emitted
+	// when a service config file is registered again after its circuit
+	// breaker opened.
+	eventReasonRegistrationRecovered = "ConsulRegistrationRecovered"
+)
+
+// circuitOpen returns true once the number of consecutive failed sync
+// attempts has reached -failure-threshold. While open, we retry only every
+// -max-sync-period so that sidecars don't hammer a struggling agent.
+func (c *Command) circuitOpen(failures int) bool {
+	return failures >= c.flagFailureThreshold
+}
+
+// emitPodEvent creates an event on our pod if -emit-pod-events is set so
+// that persistent registration failures show up in `kubectl describe pod`.
+// Failing to create the event is only logged.
+func (c *Command) emitPodEvent(logger hclog.Logger, eventType, reason, message string) {
+	if !c.flagEmitPodEvents {
+		return
+	}
+	now := metav1.Now()
+	_, err := c.clientset.CoreV1().Events(c.flagPodNamespace).Create(&corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// This is the same naming scheme client-go's event recorder uses.
+			Name:      fmt.Sprintf("%s.%x", c.flagPodName, now.UnixNano()),
+			Namespace: c.flagPodNamespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       c.flagPodName,
+			Namespace:  c.flagPodNamespace,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: "consul-lifecycle-sidecar"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	})
+	if err != nil {
+		logger.Error("failed to create pod event", "reason", reason, "err", err)
+	}
+}
@@ -0,0 +1,128 @@
+package subcommand
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// syncStatus records the outcome of the most recent attempt to sync the
+// service registration so that it can be reported by the health endpoint.
+type syncStatus struct {
+	lock        sync.RWMutex
+	lastSuccess time.Time
+	lastErr     error
+
+	// consecutiveFailures is the number of sync attempts that failed since
+	// the last successful one.
+	consecutiveFailures int
+}
+
+// record stores the outcome of a sync attempt and returns the number of
+// consecutive failures before it.
+func (s *syncStatus) record(err error) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	prev := s.consecutiveFailures
+	s.lastErr = err
+	if err == nil {
+		s.lastSuccess = time.Now()
+		s.consecutiveFailures = 0
+	} else {
+		s.consecutiveFailures++
+	}
+	return prev
+}
+
+// failures returns the number of consecutive failed sync attempts.
+func (s *syncStatus) failures() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.consecutiveFailures
+}
+
+// get returns the time of the last successful sync and the error of the
+// last attempt, if it failed.
+func (s *syncStatus) get() (time.Time, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.lastSuccess, s.lastErr
+}
+
+// healthResponse is the body served by the readiness endpoint.
+type healthResponse struct {
+	AgentReachable bool                  `json:"agent_reachable"`
+	AgentError     string                `json:"agent_error,omitempty"`
+	ServiceConfigs []serviceConfigHealth `json:"service_configs"`
+}
+
+// serviceConfigHealth is the sync status of a single service config file.
+type serviceConfigHealth struct {
+	Path            string     `json:"path"`
+	LastSyncSuccess *time.Time `json:"last_sync_success,omitempty"`
+	LastSyncError   string     `json:"last_sync_error,omitempty"`
+
+	// ConsecutiveFailures is the number of failed sync attempts since the
+	// last successful one. CircuitOpen is true once it reaches
+	// -failure-threshold.
+	ConsecutiveFailures int  `json:"consecutive_failures"`
+	CircuitOpen         bool `json:"circuit_open"`
+}
+
+// serveHealth serves the health endpoints on -health-listen until ctx is
+// cancelled.
+func (c *Command) serveHealth(ctx context.Context, logger hclog.Logger) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live", c.handleLive)
+	mux.HandleFunc("/ready", c.handleReady)
+	mux.HandleFunc("/health/live", c.handleLive)
+	mux.HandleFunc("/health/ready", c.handleReady)
+	serveHTTP(ctx, logger, c.flagHealthListen, mux)
+}
+
+// handleLive reports that the sidecar is running. It never depends on the
+// Consul agent since restarting the sidecar can't fix an unavailable agent.
+func (c *Command) handleLive(rw http.ResponseWriter, req *http.Request) {
+	rw.WriteHeader(204)
+}
+
+// handleReady reports whether the local agent is reachable and the last
+// attempt to sync each service config file succeeded.
+func (c *Command) handleReady(rw http.ResponseWriter, req *http.Request) {
+	resp := healthResponse{ServiceConfigs: []serviceConfigHealth{}}
+	ready := true
+	for _, r := range c.registrations {
+		h := serviceConfigHealth{Path: r.path}
+		lastSuccess, lastErr := r.status.get()
+		if !lastSuccess.IsZero() {
+			h.LastSyncSuccess = &lastSuccess
+		} else {
+			ready = false
+		}
+		if lastErr != nil {
+			h.LastSyncError = lastErr.Error()
+			ready = false
+		}
+		h.ConsecutiveFailures = r.status.failures()
+		h.CircuitOpen = c.circuitOpen(h.ConsecutiveFailures)
+		resp.ServiceConfigs = append(resp.ServiceConfigs, h)
+	}
+	if _, err := c.consulClient.Status().Leader(); err != nil {
+		resp.AgentError = err.Error()
+		ready = false
+	} else {
+		resp.AgentReachable = true
+	}
+
+	code := http.StatusOK
+	if !ready {
+		code = http.StatusServiceUnavailable
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(resp)
+}
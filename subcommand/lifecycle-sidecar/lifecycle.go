@@ -0,0 +1,81 @@
+package subcommand
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// serveLifecycle serves the endpoints applications in the pod use to
+// coordinate with the sidecar on -lifecycle-listen until ctx is cancelled:
+//
+//	GET  /ready        returns 200 once the services are registered and
+//	                   Envoy is ready to accept traffic, and 503 otherwise.
+//	                   Applications can poll it before making requests
+//	                   through the proxy.
+//	POST /quitquitquit shuts down the sidecar and Envoy, e.g. once the
+//	                   workload of a Job has finished.
+func (c *Command) serveLifecycle(ctx context.Context, logger hclog.Logger) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ready", c.handleAppReady)
+	mux.HandleFunc("/quitquitquit", c.handleQuit)
+	serveHTTP(ctx, logger, c.flagLifecycleListen, mux)
+}
+
+func (c *Command) handleAppReady(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	for _, r := range c.registrations {
+		lastSuccess, lastErr := r.status.get()
+		if lastSuccess.IsZero() || lastErr != nil {
+			http.Error(rw, fmt.Sprintf("services in %q are not registered", r.path), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	if c.flagEnvoyAdminAddr != "" {
+		if err := c.envoyReady(); err != nil {
+			http.Error(rw, fmt.Sprintf("Envoy is not ready: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (c *Command) handleQuit(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The channel is buffered, so if a quit is already pending there is
+	// nothing more to do.
+	select {
+	case c.quitCh <- struct{}{}:
+	default:
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+// envoyReady returns an error if Envoy's /ready admin endpoint doesn't
+// report that Envoy is ready.
+func (c *Command) envoyReady() error {
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s/ready", c.flagEnvoyAdminAddr))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response code from Envoy /ready: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// quitEnvoy tells Envoy to exit so that the pod can complete.
+func (c *Command) quitEnvoy() error {
+	return c.envoyAdminPost(&http.Client{Timeout: 5 * time.Second}, "/quitquitquit")
+}
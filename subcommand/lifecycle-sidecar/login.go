@@ -0,0 +1,143 @@
+package subcommand
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/acl"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+)
+
+// loginEnabled returns true if the sidecar is responsible for acquiring
+// its own ACL token through an auth method.
+func (c *Command) loginEnabled() bool {
+	return c.flagAuthMethod != ""
+}
+
+// currentToken returns the secret ID of the token acquired through the auth
+// method. It returns an empty string if login is disabled, in which case
+// the token configured through the HTTP flags is used.
+func (c *Command) currentToken() string {
+	tok, ok := c.aclToken.Load().(*api.ACLToken)
+	if !ok || tok == nil {
+		return ""
+	}
+	return tok.SecretID
+}
+
+// acquireToken makes sure we have a valid ACL token before registering
+// any services. If the token sink file already contains a valid token,
+// e.g. one written by the init container, we use it. Otherwise we log in
+// with the auth method. It retries every sync period until it succeeds or
+// the command is interrupted. It returns false if it was interrupted.
+func (c *Command) acquireToken(logger hclog.Logger) bool {
+	for {
+		tok, err := c.existingToken()
+		if err != nil {
+			logger.Info("no valid token in token sink file, logging in", "token-sink-file", c.flagTokenSinkFile, "reason", err)
+			tok, err = c.login()
+			c.tokenFromLogin = err == nil
+		}
+		if err == nil {
+			c.aclToken.Store(tok)
+			logger.Info("acquired ACL token", "accessor-id", tok.AccessorID)
+			return true
+		}
+		logger.Error("failed to acquire ACL token", "auth-method", c.flagAuthMethod, "err", err)
+
+		select {
+		case <-time.After(c.flagSyncPeriod):
+		case sig := <-c.sigCh:
+			logger.Info("signal received, shutting down", "signal", sig)
+			return false
+		}
+	}
+}
+
+// watchToken checks the current token every token check period and logs in
+// again if the token is no longer valid or is close to expiring. When a
+// token this sidecar created by logging in is replaced, it is logged out.
+// It runs until ctx is cancelled.
+func (c *Command) watchToken(ctx context.Context, logger hclog.Logger) {
+	// If the token we started with was created by the init container it
+	// is still used by Envoy, so we never log it out.
+	created := c.tokenFromLogin
+	for {
+		select {
+		case <-time.After(c.flagTokenCheckPeriod):
+		case <-ctx.Done():
+			return
+		}
+
+		current := c.currentToken()
+		tok, _, err := c.consulClient.ACL().TokenReadSelf(&api.QueryOptions{Token: current})
+		if err == nil && !acl.TokenNeedsRenewal(tok, time.Now()) {
+			continue
+		}
+		if err != nil {
+			logger.Info("ACL token is no longer valid, logging in again", "err", err)
+		} else {
+			logger.Info("ACL token is about to expire, logging in again", "expiration-time", tok.ExpirationTime)
+		}
+
+		newTok, err := c.login()
+		if err != nil {
+			// We'll try again on the next check.
+			logger.Error("failed to renew ACL token", "auth-method", c.flagAuthMethod, "err", err)
+			continue
+		}
+		c.aclToken.Store(newTok)
+		logger.Info("renewed ACL token", "accessor-id", newTok.AccessorID)
+
+		if created {
+			if _, err := c.consulClient.ACL().Logout(&api.WriteOptions{Token: current}); err != nil {
+				logger.Warn("failed to log out previous ACL token", "err", err)
+			}
+		}
+		created = true
+	}
+}
+
+// existingToken reads the token from the token sink file and returns it
+// if it is valid and not about to expire.
+func (c *Command) existingToken() (*api.ACLToken, error) {
+	secret, err := ioutil.ReadFile(c.flagTokenSinkFile)
+	if err != nil {
+		return nil, err
+	}
+	tok, _, err := c.consulClient.ACL().TokenReadSelf(&api.QueryOptions{Token: strings.TrimSpace(string(secret))})
+	if err != nil {
+		return nil, err
+	}
+	if acl.TokenNeedsRenewal(tok, time.Now()) {
+		return nil, fmt.Errorf("token %q is about to expire", tok.AccessorID)
+	}
+	return tok, nil
+}
+
+// login logs in with the auth method using the bearer token and writes
+// the new token to the token sink file.
+func (c *Command) login() (*api.ACLToken, error) {
+	bearerToken, err := ioutil.ReadFile(c.flagBearerTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading bearer token file %q: %s", c.flagBearerTokenFile, err)
+	}
+
+	tok, _, err := c.consulClient.ACL().Login(&api.ACLLoginParams{
+		AuthMethod:  c.flagAuthMethod,
+		BearerToken: strings.TrimSpace(string(bearerToken)),
+		Meta:        c.flagLoginMeta,
+	}, &api.WriteOptions{Namespace: c.flagAuthMethodNamespace})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := acl.WriteFileAtomic(c.flagTokenSinkFile, []byte(tok.SecretID), 0444); err != nil {
+		return nil, fmt.Errorf("writing token to %q: %s", c.flagTokenSinkFile, err)
+	}
+	return tok, nil
+}
@@ -0,0 +1,171 @@
+package subcommand
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/metrics"
+	"github.com/hashicorp/go-hclog"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// serviceMetricsSuccess is the name of the gauge added to the merged
+// metrics that reports whether the service's metrics could be scraped. This
+// lets users alert on it since a failing service endpoint doesn't fail the
+// whole scrape.
+const serviceMetricsSuccess = "consul_merged_service_metrics_success"
+
+// serveMergedMetrics serves the merged Envoy and service metrics on
+// -merged-metrics-listen until ctx is cancelled.
+func (c *Command) serveMergedMetrics(ctx context.Context, logger hclog.Logger) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(rw http.ResponseWriter, req *http.Request) {
+		c.handleMergedMetrics(logger, rw, req)
+	})
+	serveHTTP(ctx, logger, c.flagMergedMetricsListen, mux)
+}
+
+// serveMetrics serves the metrics of the sidecar itself, e.g. the
+// durations of its registrations and Consul API requests, and the pprof
+// profiles if -enable-pprof is set, on -metrics-addr until ctx is
+// cancelled.
+func (c *Command) serveMetrics(ctx context.Context, logger hclog.Logger) {
+	handler, err := metrics.Handler()
+	if err != nil {
+		logger.Error("error registering metrics", "err", err)
+		return
+	}
+	if c.flagEnablePprof {
+		handler = metrics.Pprof(handler)
+	}
+	serveHTTP(ctx, logger, c.flagMetricsAddr, handler)
+}
+
+// handleMergedMetrics scrapes Envoy and the service and serves the union of
+// their metrics. Envoy's metric families are filtered by -envoy-metrics-allow
+// and -envoy-metrics-drop, e.g. to drop high-cardinality histograms, and the
+// -merged-metrics-label labels are added to every metric. If Envoy can't be
+// scraped the request fails. If the service can't be scraped, only Envoy's
+// metrics are served and serviceMetricsSuccess is set to 0.
+func (c *Command) handleMergedMetrics(logger hclog.Logger, rw http.ResponseWriter, req *http.Request) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	families := make(map[string]*dto.MetricFamily)
+
+	if c.flagEnvoyMetricsURL != "" {
+		envoy, err := scrapeMetrics(client, c.flagEnvoyMetricsURL)
+		if err != nil {
+			logger.Error("failed to scrape Envoy metrics", "url", c.flagEnvoyMetricsURL, "err", err)
+			http.Error(rw, fmt.Sprintf("scraping Envoy metrics: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+		for name, family := range envoy {
+			if keepMetric(name, c.envoyMetricsAllow, c.envoyMetricsDrop) {
+				families[name] = family
+			}
+		}
+	}
+
+	if c.flagServiceMetricsURL != "" {
+		success := 1.0
+		svc, err := scrapeMetrics(client, c.flagServiceMetricsURL)
+		if err != nil {
+			logger.Warn("failed to scrape service metrics", "url", c.flagServiceMetricsURL, "err", err)
+			success = 0
+		}
+		for name, family := range svc {
+			if _, ok := families[name]; ok {
+				logger.Debug("dropping service metric that conflicts with an Envoy metric", "name", name)
+				continue
+			}
+			families[name] = family
+		}
+		families[serviceMetricsSuccess] = gaugeFamily(serviceMetricsSuccess,
+			"Whether the service's metrics could be scraped.", success)
+	}
+
+	names := make([]string, 0, len(families))
+	for name, family := range families {
+		addLabels(family, c.flagMergedMetricsLabels)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rw.Header().Set("Content-Type", string(expfmt.FmtText))
+	for _, name := range names {
+		if _, err := expfmt.MetricFamilyToText(rw, families[name]); err != nil {
+			logger.Error("failed to write merged metrics", "err", err)
+			return
+		}
+	}
+}
+
+// scrapeMetrics fetches and parses the Prometheus text format metrics
+// served at url.
+func scrapeMetrics(client *http.Client, url string) (map[string]*dto.MetricFamily, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response code: %d", resp.StatusCode)
+	}
+
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// keepMetric returns true if the metric family name matches allow, if set,
+// and doesn't match drop, if set.
+func keepMetric(name string, allow, drop *regexp.Regexp) bool {
+	if allow != nil && !allow.MatchString(name) {
+		return false
+	}
+	if drop != nil && drop.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// addLabels adds labels to every metric of family. Labels the metric
+// already has are left alone so that we never produce duplicate label names.
+func addLabels(family *dto.MetricFamily, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, m := range family.Metric {
+		existing := make(map[string]struct{}, len(m.Label))
+		for _, l := range m.Label {
+			existing[l.GetName()] = struct{}{}
+		}
+		for _, k := range keys {
+			if _, ok := existing[k]; ok {
+				continue
+			}
+			name, value := k, labels[k]
+			m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+		}
+	}
+}
+
+// gaugeFamily returns a metric family with a single gauge.
+func gaugeFamily(name, help string, value float64) *dto.MetricFamily {
+	typ := dto.MetricType_GAUGE
+	return &dto.MetricFamily{
+		Name:   &name,
+		Help:   &help,
+		Type:   &typ,
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: &value}}},
+	}
+}
@@ -0,0 +1,31 @@
+package subcommand
+
+import (
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul/api"
+	"github.com/radovskyb/watcher"
+)
+
+// registration is the state of a single service config file. Every file
+// passed with -service-config is registered and re-registered on its own,
+// so that a failure to register one file doesn't affect the others.
+type registration struct {
+	// path is the path to the service config file.
+	path string
+
+	// consulCommand is the command used to register the services.
+	consulCommand []string
+
+	// services are the services defined in the service config file. They
+	// are used to watch the local agent for changes to our registrations.
+	services []*api.AgentServiceRegistration
+
+	// watcher watches the service config file for changes.
+	watcher *watcher.Watcher
+
+	// status is the outcome of the last attempt to register the services.
+	status syncStatus
+
+	// backoff is the delay between retries when registering fails.
+	backoff *backoff.ExponentialBackOff
+}
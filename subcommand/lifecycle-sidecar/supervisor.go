@@ -0,0 +1,88 @@
+package subcommand
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// subsystem is one of the duties of the sidecar, e.g. keeping the services
+// registered or serving merged metrics. Running all of them in a single
+// process lets pods run one sidecar container no matter which features they
+// use.
+type subsystem struct {
+	// name identifies the subsystem in logs.
+	name string
+
+	// enabled is true if the subsystem is enabled by the command's flags.
+	enabled bool
+
+	// run does the subsystem's work until ctx is cancelled.
+	run func(ctx context.Context, logger hclog.Logger)
+}
+
+// supervisor runs the enabled subsystems in the background and stops them
+// together.
+type supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	logger hclog.Logger
+	wg     sync.WaitGroup
+}
+
+func newSupervisor(logger hclog.Logger) *supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &supervisor{ctx: ctx, cancel: cancel, logger: logger}
+}
+
+// start runs each enabled subsystem in its own goroutine. It can be called
+// multiple times, e.g. to start subsystems that depend on the ACL token only
+// once it has been acquired.
+func (s *supervisor) start(subsystems ...subsystem) {
+	for _, sub := range subsystems {
+		if !sub.enabled {
+			continue
+		}
+		s.logger.Debug("starting subsystem", "subsystem", sub.name)
+		s.wg.Add(1)
+		go func(sub subsystem) {
+			defer s.wg.Done()
+			sub.run(s.ctx, s.logger.Named(sub.name))
+		}(sub)
+	}
+}
+
+// stop cancels all subsystems and waits for them to return.
+func (s *supervisor) stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// serveHTTP serves handler on addr until ctx is cancelled. It's the run
+// function of the subsystems serving HTTP endpoints.
+func serveHTTP(ctx context.Context, logger hclog.Logger, addr string, handler http.Handler) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+	logger.Info("serving endpoints", "listen", addr)
+
+	select {
+	case err := <-errCh:
+		if err != http.ErrServerClosed {
+			logger.Error("error serving endpoints", "listen", addr, "err", err)
+		}
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}
+}
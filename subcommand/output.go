@@ -0,0 +1,41 @@
+package subcommand
+
+import (
+	"bytes"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mitchellh/cli"
+)
+
+// Indent indents the lines of s for the output of the CLI commands, e.g.
+// values files or diffs under a heading.
+func Indent(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = "      " + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Table formats rows as a table with the column names header, indented
+// under a heading.
+func Table(header []string, rows [][]string) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 3, ' ', 0)
+	for _, row := range append([][]string{header}, rows...) {
+		w.Write([]byte("    " + strings.Join(row, "\t") + "\n"))
+	}
+	w.Flush()
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// Confirm asks question with ui and returns whether the answer is yes.
+func Confirm(ui cli.Ui, question string) (bool, error) {
+	answer, err := ui.Ask(question + " (y/N)")
+	if err != nil {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,36 @@
+package peering
+
+import (
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+)
+
+// Command is the parent of the commands managing the peerings of the
+// installation with other clusters.
+type Command struct {
+	UI cli.Ui
+}
+
+func (c *Command) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	return flags.Usage(help, nil)
+}
+
+const synopsis = "Manage the peerings of Consul with other clusters"
+const help = `
+Usage: consul-k8s peering <subcommand> [options] [args]
+
+  Manages the peerings of the Consul installation of the cluster with the
+  installations of other clusters, through the PeeringAcceptor and
+  PeeringDialer custom resources. Peering requires Consul 1.13 or later.
+
+  Peer the cluster with the cluster of a kubeconfig context:
+
+      $ consul-k8s peering establish -name dc1 -peer-context dc2
+
+  For more examples, ask for subcommand help or view the documentation.
+`
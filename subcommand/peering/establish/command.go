@@ -0,0 +1,312 @@
+package establish
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// tokenKey is the key of the peering token in its secrets.
+const tokenKey = "data"
+
+// Command peers the Consul installation of the cluster with the one of the
+// cluster of another kubeconfig context: a PeeringAcceptor generates the
+// peering token in the cluster, and a PeeringDialer uses it in the peer
+// cluster.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagName           string
+	flagPeerName       string
+	flagNamespace      string
+	flagPeerNamespace  string
+	flagPeerContext    string
+	flagPeerKubeConfig string
+	flagTimeout        time.Duration
+
+	kubeClient        kubernetes.Interface
+	dynamicClient     dynamic.Interface
+	peerKubeClient    kubernetes.Interface
+	peerDynamicClient dynamic.Interface
+
+	// pollInterval is how often the statuses of the peering resources are
+	// checked.
+	pollInterval time.Duration
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagName, "name", "",
+		"Name of the cluster, i.e. of the peering in the peer cluster. Required.")
+	c.flags.StringVar(&c.flagPeerName, "peer-name", "",
+		"Name of the peer cluster, i.e. of the peering in the cluster. Defaults to -peer-context.")
+	c.flags.StringVar(&c.flagNamespace, "namespace", "default",
+		"Kubernetes namespace of the PeeringAcceptor and of the peering token in the cluster.")
+	c.flags.StringVar(&c.flagPeerNamespace, "peer-namespace", "",
+		"Kubernetes namespace of the PeeringDialer and of the peering token in the peer cluster. Defaults to -namespace.")
+	c.flags.StringVar(&c.flagPeerContext, "peer-context", "",
+		"Kubeconfig context of the peer cluster. Required.")
+	c.flags.StringVar(&c.flagPeerKubeConfig, "peer-kubeconfig", "",
+		"Path of the kubeconfig of -peer-context. Defaults to -kubeconfig.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 2*time.Minute,
+		"How long to wait for each of the peering resources to be synced.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagName == "" {
+		c.UI.Error("-name must be set")
+		return 1
+	}
+	if c.flagPeerContext == "" {
+		c.UI.Error("-peer-context must be set")
+		return 1
+	}
+	if c.flagPeerName == "" {
+		c.flagPeerName = c.flagPeerContext
+	}
+	if c.flagPeerNamespace == "" {
+		c.flagPeerNamespace = c.flagNamespace
+	}
+	if c.flagPeerKubeConfig == "" {
+		c.flagPeerKubeConfig = c.k8s.KubeConfig()
+	}
+	if c.pollInterval == 0 {
+		c.pollInterval = 2 * time.Second
+	}
+
+	if c.kubeClient == nil || c.dynamicClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		if c.kubeClient, c.dynamicClient, err = clients(config); err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.peerKubeClient == nil || c.peerDynamicClient == nil {
+		config, err := subcommand.K8SContextConfig(c.flagPeerKubeConfig, c.flagPeerContext)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth of the peer cluster: %s", err))
+			return 1
+		}
+		if c.peerKubeClient, c.peerDynamicClient, err = clients(config); err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client of the peer cluster: %s", err))
+			return 1
+		}
+	}
+
+	// The secrets of the token have the same name in both clusters.
+	secret := &v1alpha1.PeerSecret{
+		Name:    fmt.Sprintf("peering-token-%s", c.flagPeerName),
+		Key:     tokenKey,
+		Backend: v1alpha1.SecretBackendKubernetes,
+	}
+
+	c.UI.Output(fmt.Sprintf("==> Generating the peering token of %s", c.flagPeerName))
+	acceptors := c.dynamicClient.Resource(v1alpha1.GroupVersion.WithResource(v1alpha1.PeeringAcceptorResource)).
+		Namespace(c.flagNamespace)
+	if err := apply(acceptors, "PeeringAcceptor", c.flagPeerName, c.flagNamespace, secret); err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error applying PeeringAcceptor %q: %s", c.flagPeerName, err))
+		return 1
+	}
+	_, err := c.waitForSync(acceptors, c.flagPeerName, func(ref *v1alpha1.SecretRefStatus) bool {
+		return ref.Matches(secret)
+	})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ PeeringAcceptor %q wasn't synced: %s", c.flagPeerName, err))
+		return 1
+	}
+	token, err := c.kubeClient.CoreV1().Secrets(c.flagNamespace).Get(secret.Name, metav1.GetOptions{})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error reading the peering token: %s", err))
+		return 1
+	}
+	if len(token.Data[secret.Key]) == 0 {
+		c.UI.Error(fmt.Sprintf(" ✗ Secret %q has no peering token", secret.Name))
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ PeeringAcceptor %s is synced, its token is stored in secret %s", c.flagPeerName, secret.Name))
+
+	c.UI.Output(fmt.Sprintf("\n==> Dialing %s from context %s", c.flagName, c.flagPeerContext))
+	copied, err := c.copyToken(secret, token.Data[secret.Key])
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error copying the peering token to the peer cluster: %s", err))
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ Copied the peering token to secret %s of namespace %s", secret.Name, c.flagPeerNamespace))
+	dialers := c.peerDynamicClient.Resource(v1alpha1.GroupVersion.WithResource(v1alpha1.PeeringDialerResource)).
+		Namespace(c.flagPeerNamespace)
+	if err := apply(dialers, "PeeringDialer", c.flagName, c.flagPeerNamespace, secret); err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error applying PeeringDialer %q: %s", c.flagName, err))
+		return 1
+	}
+	// The dialer must have used the copied token, not a previous one.
+	status, err := c.waitForSync(dialers, c.flagName, func(ref *v1alpha1.SecretRefStatus) bool {
+		return ref.Matches(secret) && ref.ResourceVersion == copied.ResourceVersion
+	})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ PeeringDialer %q wasn't synced: %s", c.flagName, err))
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ PeeringDialer %s is synced, the state of the peering is %s", c.flagName, status.State))
+	return 0
+}
+
+// copyToken writes the peering token to the secret of the peer cluster.
+func (c *Command) copyToken(secret *v1alpha1.PeerSecret, token []byte) (*corev1.Secret, error) {
+	secrets := c.peerKubeClient.CoreV1().Secrets(c.flagPeerNamespace)
+	existing, err := secrets.Get(secret.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return secrets.Create(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secret.Name, Namespace: c.flagPeerNamespace},
+			Data:       map[string][]byte{secret.Key: token},
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if existing.Data == nil {
+		existing.Data = make(map[string][]byte)
+	}
+	existing.Data[secret.Key] = token
+	return secrets.Update(existing)
+}
+
+// waitForSync waits until the Synced condition of the peering resource is
+// true for a secret ref accepted by matches, and returns its status. It
+// fails as soon as the condition is false.
+func (c *Command) waitForSync(client dynamic.ResourceInterface, name string, matches func(*v1alpha1.SecretRefStatus) bool) (*v1alpha1.PeeringStatus, error) {
+	var status v1alpha1.PeeringStatus
+	err := wait.PollImmediate(c.pollInterval, c.flagTimeout, func() (bool, error) {
+		obj, err := client.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		status = v1alpha1.PeeringStatus{}
+		raw, _, _ := unstructured.NestedMap(obj.Object, "status")
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, &status); err != nil {
+			return false, fmt.Errorf("reading the status: %s", err)
+		}
+		synced := status.GetCondition(v1alpha1.ConditionSynced)
+		if synced == nil {
+			return false, nil
+		}
+		if synced.Status == corev1.ConditionFalse {
+			return false, fmt.Errorf("%s: %s", synced.Reason, synced.Message)
+		}
+		return synced.Status == corev1.ConditionTrue && matches(status.SecretRef), nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return nil, errors.New("timed out, check that the controller of the installation is running")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// apply creates the peering resource of kind with the secret, or updates
+// the spec of the existing one.
+func apply(client dynamic.ResourceInterface, kind, name, namespace string, secret *v1alpha1.PeerSecret) error {
+	peer, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&v1alpha1.Peer{Secret: secret})
+	if err != nil {
+		return err
+	}
+	spec := map[string]interface{}{"peer": peer}
+	existing, err := client.Get(name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+		obj.SetAPIVersion(v1alpha1.GroupVersion.String())
+		obj.SetKind(kind)
+		obj.SetName(name)
+		obj.SetNamespace(namespace)
+		_, err = client.Create(obj, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	existing.Object["spec"] = spec
+	_, err = client.Update(existing, metav1.UpdateOptions{})
+	return err
+}
+
+func clients(config *rest.Config) (kubernetes.Interface, dynamic.Interface, error) {
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return kubeClient, dynamicClient, nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Peer the cluster with the cluster of a kubeconfig context"
+const help = `
+Usage: consul-k8s peering establish [options]
+
+  Peers the Consul installation of the cluster with the installation of the
+  cluster of -peer-context. The cluster accepts the peering: it applies a
+  PeeringAcceptor named -peer-name, whose controller generates the peering
+  token. The token is copied to the peer cluster, which dials the peering
+  with a PeeringDialer named -name. Both resources are waited for until
+  their controllers have synced them.
+
+  Running the command again updates the resources, e.g. to re-establish a
+  peering deleted from Consul.
+
+      $ consul-k8s peering establish -name dc1 -peer-context dc2
+
+`
@@ -0,0 +1,266 @@
+package establish
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	cases := map[string]struct {
+		args   []string
+		expErr string
+	}{
+		"no name": {
+			args:   []string{"-peer-context", "dc2"},
+			expErr: "-name must be set",
+		},
+		"no peer context": {
+			args:   []string{"-name", "dc1"},
+			expErr: "-peer-context must be set",
+		},
+		"arguments": {
+			args:   []string{"-name", "dc1", "-peer-context", "dc2", "dc3"},
+			expErr: "Should have no non-flag arguments.",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := &Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.args))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	cases := map[string]struct {
+		args         []string
+		acceptorErr  string
+		expCode      int
+		expNamespace string
+		expOutput    []string
+		expErr       string
+	}{
+		"established": {
+			args:         []string{"-name", "dc1", "-peer-context", "dc2"},
+			expNamespace: "default",
+			expOutput: []string{
+				"==> Generating the peering token of dc2",
+				" ✓ PeeringAcceptor dc2 is synced, its token is stored in secret peering-token-dc2",
+				"==> Dialing dc1 from context dc2",
+				" ✓ Copied the peering token to secret peering-token-dc2 of namespace default",
+				" ✓ PeeringDialer dc1 is synced, the state of the peering is ACTIVE",
+			},
+		},
+		"peer name and namespace": {
+			args:         []string{"-name", "dc1", "-peer-context", "gke-west", "-peer-name", "west", "-peer-namespace", "mesh"},
+			expNamespace: "mesh",
+			expOutput: []string{
+				" ✓ PeeringAcceptor west is synced, its token is stored in secret peering-token-west",
+				" ✓ Copied the peering token to secret peering-token-west of namespace mesh",
+				" ✓ PeeringDialer dc1 is synced, the state of the peering is ACTIVE",
+			},
+		},
+		"acceptor error": {
+			args:        []string{"-name", "dc1", "-peer-context", "dc2"},
+			acceptorErr: "peering requires Consul 1.13 or later",
+			expCode:     1,
+			expErr:      ` ✗ PeeringAcceptor "dc2" wasn't synced: ConsulError: peering requires Consul 1.13 or later`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			peerKubeClient := fake.NewSimpleClientset()
+			// The controllers are simulated when the resources are read.
+			acceptors := newFakeDynamicClient(func(obj *unstructured.Unstructured) {
+				secret := obj.Object["spec"].(map[string]interface{})["peer"].(map[string]interface{})["secret"].(map[string]interface{})
+				if c.acceptorErr != "" {
+					setStatus(t, obj, corev1.ConditionFalse, "ConsulError", c.acceptorErr, "", nil)
+					return
+				}
+				name := secret["name"].(string)
+				if _, err := kubeClient.CoreV1().Secrets(obj.GetNamespace()).Get(name, metav1.GetOptions{}); k8serrors.IsNotFound(err) {
+					_, err := kubeClient.CoreV1().Secrets(obj.GetNamespace()).Create(&corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: obj.GetNamespace()},
+						Data:       map[string][]byte{"data": []byte("token-" + obj.GetName())},
+					})
+					require.NoError(t, err)
+				}
+				setStatus(t, obj, corev1.ConditionTrue, "", "", "PENDING", secret)
+			})
+			dialers := newFakeDynamicClient(func(obj *unstructured.Unstructured) {
+				secret := obj.Object["spec"].(map[string]interface{})["peer"].(map[string]interface{})["secret"].(map[string]interface{})
+				token, err := peerKubeClient.CoreV1().Secrets(obj.GetNamespace()).Get(secret["name"].(string), metav1.GetOptions{})
+				require.NoError(t, err)
+				require.Equal(t, "token-"+strings.TrimPrefix(token.Name, "peering-token-"), string(token.Data["data"]))
+				setStatus(t, obj, corev1.ConditionTrue, "", "", "ACTIVE", secret)
+			})
+
+			ui := cli.NewMockUi()
+			cmd := &Command{
+				UI:                ui,
+				kubeClient:        kubeClient,
+				dynamicClient:     acceptors,
+				peerKubeClient:    peerKubeClient,
+				peerDynamicClient: dialers,
+				pollInterval:      time.Millisecond,
+			}
+			require.Equal(t, c.expCode, cmd.Run(c.args), ui.ErrorWriter.String())
+			output := ui.OutputWriter.String()
+			for _, line := range c.expOutput {
+				require.Contains(t, output, line)
+			}
+			if c.expErr != "" {
+				require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+				require.Empty(t, dialers.objects)
+				return
+			}
+			require.Len(t, acceptors.objects, 1)
+			require.Len(t, dialers.objects, 1)
+			for key := range dialers.objects {
+				require.Equal(t, c.expNamespace+"/dc1", key)
+			}
+		})
+	}
+}
+
+func TestRun_Timeout(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := &Command{
+		UI:                ui,
+		kubeClient:        fake.NewSimpleClientset(),
+		dynamicClient:     newFakeDynamicClient(func(*unstructured.Unstructured) {}),
+		peerKubeClient:    fake.NewSimpleClientset(),
+		peerDynamicClient: newFakeDynamicClient(func(*unstructured.Unstructured) {}),
+		pollInterval:      time.Millisecond,
+	}
+	require.Equal(t, 1, cmd.Run([]string{"-name", "dc1", "-peer-context", "dc2", "-timeout", "10ms"}))
+	require.Contains(t, ui.ErrorWriter.String(),
+		` ✗ PeeringAcceptor "dc2" wasn't synced: timed out, check that the controller of the installation is running`)
+}
+
+func TestRun_UpdatesExisting(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	peerKubeClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "peering-token-dc2", Namespace: "default"},
+		Data:       map[string][]byte{"data": []byte("old-token")},
+	})
+	existing := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{"peer": map[string]interface{}{"secret": map[string]interface{}{
+			"name": "old", "key": "data", "backend": "kubernetes",
+		}}},
+	}}
+	existing.SetName("dc2")
+	existing.SetNamespace("default")
+	acceptors := newFakeDynamicClient(func(obj *unstructured.Unstructured) {
+		secret := obj.Object["spec"].(map[string]interface{})["peer"].(map[string]interface{})["secret"].(map[string]interface{})
+		_, err := kubeClient.CoreV1().Secrets("default").Create(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: secret["name"].(string), Namespace: "default"},
+			Data:       map[string][]byte{"data": []byte("new-token")},
+		})
+		if !k8serrors.IsAlreadyExists(err) {
+			require.NoError(t, err)
+		}
+		setStatus(t, obj, corev1.ConditionTrue, "", "", "PENDING", secret)
+	}, existing)
+	dialers := newFakeDynamicClient(func(obj *unstructured.Unstructured) {
+		secret := obj.Object["spec"].(map[string]interface{})["peer"].(map[string]interface{})["secret"].(map[string]interface{})
+		setStatus(t, obj, corev1.ConditionTrue, "", "", "ACTIVE", secret)
+	})
+
+	ui := cli.NewMockUi()
+	cmd := &Command{
+		UI:                ui,
+		kubeClient:        kubeClient,
+		dynamicClient:     acceptors,
+		peerKubeClient:    peerKubeClient,
+		peerDynamicClient: dialers,
+		pollInterval:      time.Millisecond,
+	}
+	require.Equal(t, 0, cmd.Run([]string{"-name", "dc1", "-peer-context", "dc2"}), ui.ErrorWriter.String())
+	name, _, _ := unstructured.NestedString(acceptors.objects["default/dc2"].Object, "spec", "peer", "secret", "name")
+	require.Equal(t, "peering-token-dc2", name)
+	secret, err := peerKubeClient.CoreV1().Secrets("default").Get("peering-token-dc2", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "new-token", string(secret.Data["data"]))
+}
+
+// setStatus sets the Synced condition, the state and the secret ref of the
+// status of the peering resource obj.
+func setStatus(t *testing.T, obj *unstructured.Unstructured, synced corev1.ConditionStatus, reason, message, state string, secret map[string]interface{}) {
+	status := v1alpha1.PeeringStatus{State: state}
+	status.Conditions = []v1alpha1.Condition{{Type: v1alpha1.ConditionSynced, Status: synced, Reason: reason, Message: message}}
+	if secret != nil {
+		status.SecretRef = &v1alpha1.SecretRefStatus{PeerSecret: v1alpha1.PeerSecret{
+			Name:    secret["name"].(string),
+			Key:     secret["key"].(string),
+			Backend: secret["backend"].(string),
+		}}
+	}
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	require.NoError(t, err)
+	obj.Object["status"] = raw
+}
+
+// fakeDynamicClient stores the peering resources in memory, and calls
+// reconcile on the resources it returns to simulate their controller.
+type fakeDynamicClient struct {
+	dynamic.Interface
+	objects   map[string]*unstructured.Unstructured
+	reconcile func(obj *unstructured.Unstructured)
+}
+
+func newFakeDynamicClient(reconcile func(obj *unstructured.Unstructured), objects ...*unstructured.Unstructured) *fakeDynamicClient {
+	client := &fakeDynamicClient{objects: make(map[string]*unstructured.Unstructured), reconcile: reconcile}
+	for _, obj := range objects {
+		client.objects[obj.GetNamespace()+"/"+obj.GetName()] = obj
+	}
+	return client
+}
+
+func (f *fakeDynamicClient) Resource(schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &fakeResourceClient{client: f}
+}
+
+type fakeResourceClient struct {
+	dynamic.NamespaceableResourceInterface
+	client    *fakeDynamicClient
+	namespace string
+}
+
+func (f *fakeResourceClient) Namespace(ns string) dynamic.ResourceInterface {
+	return &fakeResourceClient{client: f.client, namespace: ns}
+}
+
+func (f *fakeResourceClient) Create(obj *unstructured.Unstructured, _ metav1.CreateOptions, _ ...string) (*unstructured.Unstructured, error) {
+	return f.Update(obj, metav1.UpdateOptions{})
+}
+
+func (f *fakeResourceClient) Update(obj *unstructured.Unstructured, _ metav1.UpdateOptions, _ ...string) (*unstructured.Unstructured, error) {
+	f.client.objects[f.namespace+"/"+obj.GetName()] = obj.DeepCopy()
+	return obj.DeepCopy(), nil
+}
+
+func (f *fakeResourceClient) Get(name string, _ metav1.GetOptions, _ ...string) (*unstructured.Unstructured, error) {
+	obj, ok := f.client.objects[f.namespace+"/"+name]
+	if !ok {
+		return nil, k8serrors.NewNotFound(schema.GroupResource{}, name)
+	}
+	f.client.reconcile(obj)
+	return obj.DeepCopy(), nil
+}
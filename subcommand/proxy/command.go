@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// injectStatusAnnotation is set to "injected" by the connect injector
+	// on the pods it injects the Envoy sidecar into.
+	injectStatusAnnotation = "consul.hashicorp.com/connect-inject-status"
+
+	// ServiceAnnotation is the name of the service of the injected pods.
+	ServiceAnnotation = "consul.hashicorp.com/connect-service"
+)
+
+// Injected is true if the Envoy sidecar was injected into pod.
+func Injected(pod corev1.Pod) bool {
+	return pod.Annotations[injectStatusAnnotation] == "injected"
+}
+
+// Command is the parent of the commands inspecting the Envoy sidecars.
+type Command struct {
+	UI cli.Ui
+}
+
+func (c *Command) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	return flags.Usage(help, nil)
+}
+
+const synopsis = "Inspect the Envoy sidecars of the injected pods"
+const help = `
+Usage: consul-k8s proxy <subcommand> [options] [args]
+
+  Inspects the Envoy sidecars injected into the pods by the connect
+  injector, through their admin API. The admin API only listens on
+  localhost in the pods, so it's reached with kubectl port-forward, and
+  the kubectl binary must be installed.
+
+  List the injected pods and whether their sidecar is synced with Consul:
+
+      $ consul-k8s proxy list
+
+  Show the configuration of the sidecar of a pod:
+
+      $ consul-k8s proxy read -namespace web web-6d4f8c9b7-xk2lp
+
+  Enable the debug logs of the sidecar of a pod:
+
+      $ consul-k8s proxy log-level -namespace web web-6d4f8c9b7-xk2lp debug
+
+  For more examples, ask for subcommand help or view the documentation.
+`
@@ -0,0 +1,171 @@
+package list
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/helper/envoy"
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul-k8s/subcommand/proxy"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command lists the pods with an injected Envoy sidecar.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagNamespace     string
+	flagKubectlBinary string
+
+	kubeClient kubernetes.Interface
+	forward    portforward.Forwarder
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagNamespace, "namespace", "",
+		"Kubernetes namespace of the pods. Defaults to all namespaces.")
+	c.flags.StringVar(&c.flagKubectlBinary, "kubectl", "kubectl",
+		"Path of the kubectl binary, used to port-forward to the Envoy admin API.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+
+	if c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.forward == nil {
+		c.forward = portforward.Kubectl(c.flagKubectlBinary, c.k8s.KubeConfig())
+	}
+
+	pods, err := c.kubeClient.CoreV1().Pods(c.flagNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error listing the pods: %s", err))
+		return 1
+	}
+	var injected []corev1.Pod
+	for _, pod := range pods.Items {
+		if proxy.Injected(pod) {
+			injected = append(injected, pod)
+		}
+	}
+	if len(injected) == 0 {
+		c.UI.Output("No pods with an injected sidecar found.")
+		return 0
+	}
+	sort.Slice(injected, func(i, j int) bool {
+		if injected[i].Namespace != injected[j].Namespace {
+			return injected[i].Namespace < injected[j].Namespace
+		}
+		return injected[i].Name < injected[j].Name
+	})
+
+	var rows [][]string
+	for _, pod := range injected {
+		rows = append(rows, []string{
+			pod.Namespace,
+			pod.Name,
+			pod.Annotations[proxy.ServiceAnnotation],
+			podStatus(pod),
+			c.syncStatus(pod),
+		})
+	}
+	c.UI.Output(subcommand.Table([]string{"NAMESPACE", "POD", "SERVICE", "STATUS", "SYNC"}, rows))
+	return 0
+}
+
+// syncStatus is whether the sidecar of pod is connected to its Consul
+// client, which sends it its configuration.
+func (c *Command) syncStatus(pod corev1.Pod) string {
+	if pod.Status.Phase != corev1.PodRunning {
+		return "-"
+	}
+	addr, closeForward, err := c.forward(pod.Namespace, pod.Name, portforward.EnvoyAdminPort)
+	if err != nil {
+		return "unknown: " + err.Error()
+	}
+	defer closeForward()
+	connected, err := (&envoy.Admin{Addr: addr}).Connected()
+	switch {
+	case err != nil:
+		return "unknown: " + err.Error()
+	case connected:
+		return "synced"
+	default:
+		return "not synced"
+	}
+}
+
+// podStatus is the phase of pod, and whether it's ready if it's running.
+func podStatus(pod corev1.Pod) string {
+	if pod.Status.Phase != corev1.PodRunning {
+		return string(pod.Status.Phase)
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			return "Running"
+		}
+	}
+	return "Running, not ready"
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "List the pods with an injected Envoy sidecar"
+const help = `
+Usage: consul-k8s proxy list [options]
+
+  Lists the pods with an Envoy sidecar injected by the connect injector,
+  with their service and status, and whether their sidecar is synced, i.e.
+  connected to the Consul client it gets its configuration from.
+
+  The sync status is read from the Envoy admin API of the running pods
+  with kubectl port-forward.
+
+`
@@ -0,0 +1,107 @@
+package list
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 1, cmd.Run([]string{"foo"}))
+	require.Contains(t, ui.ErrorWriter.String(), "Should have no non-flag arguments.")
+}
+
+func TestRun_NoPods(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, kubeClient: fake.NewSimpleClientset(pod("default", "postgres-0", corev1.PodRunning, true, false))}
+	require.Equal(t, 0, cmd.Run(nil))
+	require.Equal(t, "No pods with an injected sidecar found.\n", ui.OutputWriter.String())
+}
+
+func TestRun_List(t *testing.T) {
+	// The admin API of each pod is a server returning the connected state
+	// of the pod.
+	connected := map[string]string{"web-0": "1", "web-1": "0"}
+	objects := []runtime.Object{
+		pod("web", "web-1", corev1.PodRunning, false, true),
+		pod("web", "web-0", corev1.PodRunning, true, true),
+		pod("api", "api-0", corev1.PodRunning, true, true),
+		pod("api", "api-1", corev1.PodPending, false, true),
+		pod("default", "postgres-0", corev1.PodRunning, true, false),
+	}
+
+	cases := map[string]struct {
+		namespace string
+		expOutput string
+	}{
+		"all namespaces": {
+			expOutput: `    NAMESPACE   POD     SERVICE   STATUS               SYNC
+    api         api-0   api       Running              unknown: pod not found
+    api         api-1   api       Pending              -
+    web         web-0   web       Running              synced
+    web         web-1   web       Running, not ready   not synced
+`,
+		},
+		"namespace": {
+			namespace: "web",
+			expOutput: `    NAMESPACE   POD     SERVICE   STATUS               SYNC
+    web         web-0   web       Running              synced
+    web         web-1   web       Running, not ready   not synced
+`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:         ui,
+				kubeClient: fake.NewSimpleClientset(objects...),
+				forward: func(namespace, name string, remotePort int) (string, func(), error) {
+					require.Equal(t, 19000, remotePort)
+					state, ok := connected[name]
+					if !ok {
+						return "", nil, errors.New("pod not found")
+					}
+					server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						fmt.Fprintf(w, "control_plane.connected_state: %s\n", state)
+					}))
+					return strings.TrimPrefix(server.URL, "http://"), server.Close, nil
+				},
+			}
+			require.Equal(t, 0, cmd.Run([]string{"-namespace", c.namespace}), ui.ErrorWriter.String())
+			require.Equal(t, c.expOutput, ui.OutputWriter.String())
+		})
+	}
+}
+
+func pod(namespace, name string, phase corev1.PodPhase, ready, injected bool) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: map[string]string{},
+		},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+	if injected {
+		pod.Annotations["consul.hashicorp.com/connect-inject-status"] = "injected"
+		pod.Annotations["consul.hashicorp.com/connect-service"] = strings.Split(name, "-")[0]
+	}
+	if ready {
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	}
+	return pod
+}
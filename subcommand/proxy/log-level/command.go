@@ -0,0 +1,179 @@
+package loglevel
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/helper/envoy"
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul-k8s/subcommand/proxy"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// levels are the log levels of Envoy.
+var levels = []string{"trace", "debug", "info", "warning", "error", "critical", "off"}
+
+// Command reads or sets the levels of the loggers of the Envoy sidecar of a
+// pod.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagNamespace     string
+	flagLogger        string
+	flagKubectlBinary string
+
+	kubeClient kubernetes.Interface
+	forward    portforward.Forwarder
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagNamespace, "namespace", "default",
+		"Kubernetes namespace of the pod.")
+	c.flags.StringVar(&c.flagLogger, "logger", "",
+		"Name of the logger to read or set the level of, e.g. upstream. Defaults to all the loggers.")
+	c.flags.StringVar(&c.flagKubectlBinary, "kubectl", "kubectl",
+		"Path of the kubectl binary, used to port-forward to the Envoy admin API.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) < 1 || len(c.flags.Args()) > 2 {
+		c.UI.Error("Should have one or two arguments, the name of the pod and optionally the level to set.")
+		return 1
+	}
+	name, level := c.flags.Arg(0), c.flags.Arg(1)
+	if level != "" && !validLevel(level) {
+		c.UI.Error(fmt.Sprintf("The level must be one of %s, not %q", strings.Join(levels, ", "), level))
+		return 1
+	}
+
+	if c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.forward == nil {
+		c.forward = portforward.Kubectl(c.flagKubectlBinary, c.k8s.KubeConfig())
+	}
+
+	pod, err := c.kubeClient.CoreV1().Pods(c.flagNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error getting pod %q: %s", name, err))
+		return 1
+	}
+	if !proxy.Injected(*pod) {
+		c.UI.Error(fmt.Sprintf("Pod %q has no injected sidecar.", name))
+		return 1
+	}
+
+	addr, closeForward, err := c.forward(pod.Namespace, pod.Name, portforward.EnvoyAdminPort)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error connecting to the Envoy admin API: %s", err))
+		return 1
+	}
+	defer closeForward()
+	admin := &envoy.Admin{Addr: addr}
+
+	current, err := admin.LogLevels()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading the log levels: %s", err))
+		return 1
+	}
+	// Envoy ignores the unknown loggers, so they're checked beforehand.
+	if _, ok := current[c.flagLogger]; c.flagLogger != "" && !ok {
+		c.UI.Error(fmt.Sprintf("Envoy has no logger %q, see the loggers listed without -logger.", c.flagLogger))
+		return 1
+	}
+	if level != "" {
+		current, err = admin.SetLogLevel(c.flagLogger, level)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error setting the log level: %s", err))
+			return 1
+		}
+		if c.flagLogger == "" {
+			c.UI.Output(fmt.Sprintf(" ✓ Set the level of all the loggers of pod %q to %s", name, level))
+		} else {
+			c.UI.Output(fmt.Sprintf(" ✓ Set the level of logger %s of pod %q to %s", c.flagLogger, name, level))
+		}
+		c.UI.Output("    The level is reset when the sidecar restarts.\n")
+	}
+
+	var rows [][]string
+	for logger, l := range current {
+		if c.flagLogger == "" || logger == c.flagLogger {
+			rows = append(rows, []string{logger, l})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	c.UI.Output(subcommand.Table([]string{"LOGGER", "LEVEL"}, rows))
+	return 0
+}
+
+func validLevel(level string) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Read or set the log levels of the Envoy sidecar of a pod"
+const help = `
+Usage: consul-k8s proxy log-level [options] <pod> [level]
+
+  Prints the levels of the loggers of the Envoy sidecar of the pod or, if
+  level is set, sets the level of the loggers at runtime, e.g. to debug a
+  misbehaving sidecar without redeploying its pod. The level is one of
+  trace, debug, info, warning, error, critical and off, and is reset when
+  the sidecar restarts.
+
+  -logger restricts the command to a single logger. The levels are read
+  and set with the Envoy admin API, through kubectl port-forward.
+
+      $ consul-k8s proxy log-level -namespace web web-6d4f8c9b7-xk2lp debug
+      $ consul-k8s proxy log-level -logger upstream web-6d4f8c9b7-xk2lp trace
+
+`
@@ -0,0 +1,127 @@
+package loglevel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	cases := []struct {
+		args   []string
+		expErr string
+	}{
+		{
+			args:   nil,
+			expErr: "Should have one or two arguments, the name of the pod and optionally the level to set.",
+		},
+		{
+			args:   []string{"web-0", "debug", "foo"},
+			expErr: "Should have one or two arguments, the name of the pod and optionally the level to set.",
+		},
+		{
+			args:   []string{"web-0", "loud"},
+			expErr: `The level must be one of trace, debug, info, warning, error, critical, off, not "loud"`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.args))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun_LogLevel(t *testing.T) {
+	cases := map[string]struct {
+		args        []string
+		expCode     int
+		expRequests []string
+		expOutput   string
+		expErr      string
+	}{
+		"read": {
+			args:        []string{"web-0"},
+			expRequests: []string{""},
+			expOutput: "    LOGGER     LEVEL\n" +
+				"    admin      info\n" +
+				"    upstream   info\n",
+		},
+		"read logger": {
+			args:        []string{"-logger", "upstream", "web-0"},
+			expRequests: []string{""},
+			expOutput: "    LOGGER     LEVEL\n" +
+				"    upstream   info\n",
+		},
+		"set": {
+			args:        []string{"web-0", "debug"},
+			expRequests: []string{"", "level=debug"},
+			expOutput: " ✓ Set the level of all the loggers of pod \"web-0\" to debug\n" +
+				"    The level is reset when the sidecar restarts.\n\n" +
+				"    LOGGER     LEVEL\n" +
+				"    admin      debug\n" +
+				"    upstream   debug\n",
+		},
+		"set logger": {
+			args:        []string{"-logger", "upstream", "web-0", "trace"},
+			expRequests: []string{"", "upstream=trace"},
+			expOutput: " ✓ Set the level of logger upstream of pod \"web-0\" to trace\n" +
+				"    The level is reset when the sidecar restarts.\n\n" +
+				"    LOGGER     LEVEL\n" +
+				"    upstream   trace\n",
+		},
+		"unknown logger": {
+			args:        []string{"-logger", "http2", "web-0", "debug"},
+			expCode:     1,
+			expRequests: []string{""},
+			expErr:      `Envoy has no logger "http2", see the loggers listed without -logger.`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			levels := map[string]string{"admin": "info", "upstream": "info"}
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/logging", r.URL.Path)
+				requests = append(requests, r.URL.RawQuery)
+				for logger, level := range r.URL.Query() {
+					for name := range levels {
+						if logger == "level" || logger == name {
+							levels[name] = level[0]
+						}
+					}
+				}
+				fmt.Fprintf(w, "active loggers:\n  admin: %s\n  upstream: %s\n", levels["admin"], levels["upstream"])
+			}))
+			defer server.Close()
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI: ui,
+				kubeClient: fake.NewSimpleClientset(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Name:        "web-0",
+					Namespace:   "default",
+					Annotations: map[string]string{"consul.hashicorp.com/connect-inject-status": "injected"},
+				}}),
+				forward: func(namespace, name string, remotePort int) (string, func(), error) {
+					return strings.TrimPrefix(server.URL, "http://"), func() {}, nil
+				},
+			}
+			require.Equal(t, c.expCode, cmd.Run(c.args), ui.ErrorWriter.String())
+			require.Equal(t, c.expRequests, requests)
+			require.Equal(t, c.expOutput, ui.OutputWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package read
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/envoy"
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul-k8s/subcommand/proxy"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command shows the configuration of the Envoy sidecar of a pod.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagNamespace     string
+	flagJSON          bool
+	flagKubectlBinary string
+
+	kubeClient kubernetes.Interface
+	forward    portforward.Forwarder
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagNamespace, "namespace", "default",
+		"Kubernetes namespace of the pod.")
+	c.flags.BoolVar(&c.flagJSON, "json", false,
+		"If true, prints the raw config dump of Envoy as JSON.")
+	c.flags.StringVar(&c.flagKubectlBinary, "kubectl", "kubectl",
+		"Path of the kubectl binary, used to port-forward to the Envoy admin API.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) != 1 {
+		c.UI.Error("Should have exactly one argument, the name of the pod.")
+		return 1
+	}
+	name := c.flags.Arg(0)
+
+	if c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.forward == nil {
+		c.forward = portforward.Kubectl(c.flagKubectlBinary, c.k8s.KubeConfig())
+	}
+
+	pod, err := c.kubeClient.CoreV1().Pods(c.flagNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error getting pod %q: %s", name, err))
+		return 1
+	}
+	if !proxy.Injected(*pod) {
+		c.UI.Error(fmt.Sprintf("Pod %q has no injected sidecar.", name))
+		return 1
+	}
+
+	addr, closeForward, err := c.forward(pod.Namespace, pod.Name, portforward.EnvoyAdminPort)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error connecting to the Envoy admin API: %s", err))
+		return 1
+	}
+	defer closeForward()
+	admin := &envoy.Admin{Addr: addr}
+
+	if c.flagJSON {
+		dump, err := admin.ConfigDump()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading the config dump: %s", err))
+			return 1
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, dump, "", "  "); err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading the config dump: %s", err))
+			return 1
+		}
+		c.UI.Output(indented.String())
+		return 0
+	}
+
+	config, err := admin.Config()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading the config dump: %s", err))
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf("Envoy configuration of pod %s in namespace %s (service %s)",
+		pod.Name, pod.Namespace, pod.Annotations[proxy.ServiceAnnotation]))
+
+	var rows [][]string
+	for _, cluster := range config.Clusters {
+		rows = append(rows, []string{cluster.Name, cluster.Type, cluster.LastUpdated})
+	}
+	c.section("Clusters", []string{"NAME", "TYPE", "LAST UPDATED"}, rows)
+
+	rows = nil
+	for _, listener := range config.Listeners {
+		rows = append(rows, []string{listener.Name, listener.Address, listener.LastUpdated})
+	}
+	c.section("Listeners", []string{"NAME", "ADDRESS", "LAST UPDATED"}, rows)
+
+	rows = nil
+	for _, route := range config.Routes {
+		rows = append(rows, []string{route.Config, strings.Join(route.Domains, ","), route.Match, strings.Join(route.Clusters, ",")})
+	}
+	c.section("Routes", []string{"NAME", "DOMAINS", "MATCH", "CLUSTERS"}, rows)
+
+	rows = nil
+	for _, endpoint := range config.Endpoints {
+		rows = append(rows, []string{endpoint.Address, endpoint.Cluster, endpoint.Health})
+	}
+	c.section("Endpoints", []string{"ADDRESS", "CLUSTER", "HEALTH"}, rows)
+
+	rows = nil
+	for _, secret := range config.Secrets {
+		rows = append(rows, []string{secret.Name, secret.Kind, secret.LastUpdated})
+	}
+	for _, cert := range config.Certificates {
+		kind := "leaf certificate"
+		if cert.CA {
+			kind = "CA certificate"
+		}
+		rows = append(rows, []string{
+			strings.Join(cert.SubjectAltNames, ","),
+			kind,
+			fmt.Sprintf("serial %s, valid %s to %s", cert.SerialNumber,
+				cert.ValidFrom.Format(time.RFC3339), cert.ExpirationTime.Format(time.RFC3339)),
+		})
+	}
+	c.section("Secrets", []string{"NAME", "KIND", "DETAILS"}, rows)
+	return 0
+}
+
+func (c *Command) section(heading string, header []string, rows [][]string) {
+	c.UI.Output(fmt.Sprintf("\n==> %s", heading))
+	if len(rows) == 0 {
+		c.UI.Output("    None")
+		return
+	}
+	c.UI.Output(subcommand.Table(header, rows))
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Show the configuration of the Envoy sidecar of a pod"
+const help = `
+Usage: consul-k8s proxy read [options] <pod>
+
+  Shows the configuration of the Envoy sidecar of the pod: its clusters,
+  listeners, routes, the endpoints of the clusters with their health, and
+  its secrets and certificates with their validity. -json prints the raw
+  config dump instead.
+
+  The configuration is read from the Envoy admin API with kubectl
+  port-forward.
+
+      $ consul-k8s proxy read -namespace web web-6d4f8c9b7-xk2lp
+
+`
@@ -0,0 +1,147 @@
+package read
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const configDump = `{"configs":[` +
+	`{"@type":"type.googleapis.com/envoy.admin.v2alpha.ClustersConfigDump","dynamic_active_clusters":[{"cluster":{"name":"db","type":"EDS"},"last_updated":"2020-06-01T10:00:00Z"}]},` +
+	`{"@type":"type.googleapis.com/envoy.admin.v2alpha.ListenersConfigDump","dynamic_active_listeners":[{"listener":{"name":"db:127.0.0.1:5432","address":{"socket_address":{"address":"127.0.0.1","port_value":5432}}},"last_updated":"2020-06-01T10:00:00Z"}]},` +
+	`{"@type":"type.googleapis.com/envoy.admin.v2alpha.EndpointsConfigDump","dynamic_endpoint_configs":[{"endpoint_config":{"cluster_name":"db","endpoints":[{"lb_endpoints":[{"endpoint":{"address":{"socket_address":{"address":"10.0.0.7","port_value":20000}}},"health_status":"HEALTHY"}]}]}}]}` +
+	`]}`
+
+const certs = `{"certificates":[{"cert_chain":[{"serial_number":"2a","subject_alt_names":[{"uri":"spiffe://cluster.consul/ns/default/dc/dc1/svc/web"}],"valid_from":"2020-06-01T00:00:00Z","expiration_time":"2020-06-04T00:00:00Z"}]}]}`
+
+func TestRun_FlagValidation(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 1, cmd.Run(nil))
+	require.Contains(t, ui.ErrorWriter.String(), "Should have exactly one argument, the name of the pod.")
+}
+
+func TestRun_Errors(t *testing.T) {
+	cases := map[string]struct {
+		pod    string
+		expErr string
+	}{
+		"missing pod": {
+			pod:    "api-0",
+			expErr: `Error getting pod "api-0": pods "api-0" not found`,
+		},
+		"not injected": {
+			pod:    "postgres-0",
+			expErr: `Pod "postgres-0" has no injected sidecar.`,
+		},
+		"port-forward error": {
+			pod:    "web-0",
+			expErr: "Error connecting to the Envoy admin API: pod is not running",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:         ui,
+				kubeClient: fake.NewSimpleClientset(pod("web-0", true), pod("postgres-0", false)),
+				forward: func(namespace, name string, remotePort int) (string, func(), error) {
+					return "", nil, errors.New("pod is not running")
+				},
+			}
+			require.Equal(t, 1, cmd.Run([]string{"-namespace", "web", c.pod}))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun_Read(t *testing.T) {
+	cases := map[string]struct {
+		flags     []string
+		expOutput string
+	}{
+		"tables": {
+			expOutput: `Envoy configuration of pod web-0 in namespace web (service web)
+
+==> Clusters
+    NAME   TYPE   LAST UPDATED
+    db     EDS    2020-06-01T10:00:00Z
+
+==> Listeners
+    NAME                ADDRESS          LAST UPDATED
+    db:127.0.0.1:5432   127.0.0.1:5432   2020-06-01T10:00:00Z
+
+==> Routes
+    None
+
+==> Endpoints
+    ADDRESS          CLUSTER   HEALTH
+    10.0.0.7:20000   db        HEALTHY
+
+==> Secrets
+    NAME                                                KIND               DETAILS
+    spiffe://cluster.consul/ns/default/dc/dc1/svc/web   leaf certificate   serial 2a, valid 2020-06-01T00:00:00Z to 2020-06-04T00:00:00Z
+`,
+		},
+		"json": {
+			flags:     []string{"-json"},
+			expOutput: "{\n  \"configs\": [\n    {\n      \"@type\": \"type.googleapis.com/envoy.admin.v2alpha.ClustersConfigDump\",\n",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/config_dump":
+					fmt.Fprint(w, configDump)
+				case "/certs":
+					fmt.Fprint(w, certs)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+			closed := false
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:         ui,
+				kubeClient: fake.NewSimpleClientset(pod("web-0", true)),
+				forward: func(namespace, name string, remotePort int) (string, func(), error) {
+					require.Equal(t, "web", namespace)
+					require.Equal(t, "web-0", name)
+					return strings.TrimPrefix(server.URL, "http://"), func() { closed = true }, nil
+				},
+			}
+			require.Equal(t, 0, cmd.Run(append(c.flags, "-namespace", "web", "web-0")), ui.ErrorWriter.String())
+			if c.flags == nil {
+				require.Equal(t, c.expOutput, ui.OutputWriter.String())
+			} else {
+				require.True(t, strings.HasPrefix(ui.OutputWriter.String(), c.expOutput), ui.OutputWriter.String())
+			}
+			require.True(t, closed, "the port-forward is closed")
+		})
+	}
+}
+
+func pod(name string, injected bool) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "web"}}
+	if injected {
+		pod.Annotations = map[string]string{
+			"consul.hashicorp.com/connect-inject-status": "injected",
+			"consul.hashicorp.com/connect-service":       "web",
+		}
+	}
+	return pod
+}
@@ -0,0 +1,397 @@
+package registermeshgateway
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Sources of the WAN address of the mesh gateway.
+const (
+	// sourceService is the ingress IP or hostname of the LoadBalancer
+	// service of the gateway.
+	sourceService = "Service"
+	// sourceNodePort is the external IP of the node of the gateway, with
+	// the node port of its service.
+	sourceNodePort = "NodePort"
+	// sourceStatic is the address of -wan-address.
+	sourceStatic = "Static"
+)
+
+// Command registers the mesh gateway of the pod with the local Consul
+// agent, and keeps its WAN address up to date.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+	audit      *k8sflags.AuditFlags
+	writeLimit *k8sflags.WriteLimitFlags
+
+	flagServiceID    string
+	flagServiceName  string
+	flagAddress      string
+	flagPort         int
+	flagWANSource    string
+	flagWANAddress   string
+	flagWANPort      int
+	flagK8sNamespace string
+	flagK8sService   string
+	flagNodeName     string
+	flagPodName      string
+	flagSyncPeriod   time.Duration
+
+	consulClient *api.Client
+	clientset    kubernetes.Interface
+
+	sigCh chan os.Signal
+	once  sync.Once
+	help  string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagServiceID, "service-id", "",
+		"ID of the mesh gateway service, unique on the agent. Defaults to -pod-name.")
+	c.flags.StringVar(&c.flagServiceName, "service-name", "mesh-gateway",
+		"Name of the mesh gateway service.")
+	c.flags.StringVar(&c.flagAddress, "address", "",
+		"LAN address of the mesh gateway, e.g. the IP of the pod.")
+	c.flags.IntVar(&c.flagPort, "port", 8443,
+		"LAN port of the mesh gateway.")
+	c.flags.StringVar(&c.flagWANSource, "wan-address-source", sourceService,
+		fmt.Sprintf("Source of the WAN address of the mesh gateway: %q for the ingress of the LoadBalancer "+
+			"service -k8s-service, %q for the external IP of node -node-name with the node port of "+
+			"-k8s-service, or %q for -wan-address.", sourceService, sourceNodePort, sourceStatic))
+	c.flags.StringVar(&c.flagWANAddress, "wan-address", "",
+		fmt.Sprintf("WAN address of the mesh gateway if -wan-address-source is %q.", sourceStatic))
+	c.flags.IntVar(&c.flagWANPort, "wan-port", 443,
+		fmt.Sprintf("WAN port of the mesh gateway, unless -wan-address-source is %q.", sourceNodePort))
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Kubernetes namespace of -k8s-service and of the pod.")
+	c.flags.StringVar(&c.flagK8sService, "k8s-service", "",
+		"Name of the Kubernetes service exposing the mesh gateway.")
+	c.flags.StringVar(&c.flagNodeName, "node-name", "",
+		"Name of the Kubernetes node of the mesh gateway.")
+	c.flags.StringVar(&c.flagPodName, "pod-name", "",
+		"Name of the pod of the mesh gateway. If set, the service meta records the pod with -k8s-namespace, "+
+			"so that the controller deregisters the gateway if the pod disappears without deregistering it.")
+	c.flags.DurationVar(&c.flagSyncPeriod, "sync-period", 10*time.Second,
+		"Time between checks of the WAN address and of the registration. Defaults to 10s.")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.audit = &k8sflags.AuditFlags{}
+	flags.Merge(c.flags, c.audit.Flags())
+	c.writeLimit = &k8sflags.WriteLimitFlags{}
+	flags.Merge(c.flags, c.writeLimit.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// Wait on an interrupt to exit. This channel must be initialized before
+	// Run() is called so that there are no race conditions where the channel
+	// is not defined.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+}
+
+// Run registers the mesh gateway once its WAN address is known, then
+// updates the registration whenever the address changes or the agent lost
+// it, until it receives SIGINT or SIGTERM and deregisters the gateway.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	stopAudit, err := c.audit.Start(logger.Named("audit"), "consul-k8s-register-mesh-gateway")
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error opening the audit log: %s", err))
+		return 1
+	}
+	defer stopAudit()
+	if err := c.writeLimit.Limit(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.clientset == nil && c.flagWANSource != sourceStatic {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		var err error
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
+		c.consulClient, err = consul.NewClient(cfg)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	var registered *api.ServiceAddress
+	ticker := time.NewTicker(c.flagSyncPeriod)
+	defer ticker.Stop()
+	for {
+		if wan, err := c.sync(logger, registered); err != nil {
+			logger.Error("failed to sync the mesh gateway registration", "err", err)
+		} else {
+			registered = wan
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.sigCh:
+			if registered == nil {
+				return 0
+			}
+			if err := c.consulClient.Agent().ServiceDeregister(c.flagServiceID); err != nil {
+				logger.Error("failed to deregister the mesh gateway", "err", err)
+				return 1
+			}
+			logger.Info("deregistered the mesh gateway", "service-id", c.flagServiceID)
+			return 0
+		}
+	}
+}
+
+// sync registers the mesh gateway if its WAN address isn't the registered
+// one or the agent doesn't have the registration anymore, e.g. because it
+// restarted. It returns the registered WAN address.
+func (c *Command) sync(logger hclog.Logger, registered *api.ServiceAddress) (*api.ServiceAddress, error) {
+	wan, err := c.wanAddress()
+	if err != nil {
+		return registered, fmt.Errorf("getting the WAN address: %s", err)
+	}
+	if registered != nil && *registered == *wan {
+		service, _, err := c.consulClient.Agent().Service(c.flagServiceID, nil)
+		if err == nil && service != nil {
+			return registered, nil
+		}
+	}
+
+	var meta map[string]string
+	if c.flagPodName != "" {
+		meta = map[string]string{
+			"external-source": "kubernetes",
+			"external-k8s-ns": c.flagK8sNamespace,
+			"pod-name":        c.flagPodName,
+		}
+	}
+	lan := api.ServiceAddress{Address: c.flagAddress, Port: c.flagPort}
+	err = c.consulClient.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		Kind:    api.ServiceKindMeshGateway,
+		ID:      c.flagServiceID,
+		Name:    c.flagServiceName,
+		Address: c.flagAddress,
+		Port:    c.flagPort,
+		Meta:    meta,
+		TaggedAddresses: map[string]api.ServiceAddress{
+			"lan": lan,
+			"wan": *wan,
+		},
+		Check: &api.AgentServiceCheck{
+			Name:                           "Mesh Gateway Listening",
+			TCP:                            net.JoinHostPort(c.flagAddress, strconv.Itoa(c.flagPort)),
+			Interval:                       "10s",
+			DeregisterCriticalServiceAfter: "6h",
+		},
+	})
+	if err != nil {
+		return registered, fmt.Errorf("registering the mesh gateway: %s", err)
+	}
+	logger.Info("registered the mesh gateway", "service-id", c.flagServiceID,
+		"wan-address", wan.Address, "wan-port", wan.Port)
+	return wan, nil
+}
+
+// wanAddress returns the WAN address of the mesh gateway from the source
+// of -wan-address-source.
+func (c *Command) wanAddress() (*api.ServiceAddress, error) {
+	if c.flagWANSource == sourceStatic {
+		return &api.ServiceAddress{Address: c.flagWANAddress, Port: c.flagWANPort}, nil
+	}
+
+	svc, err := c.clientset.CoreV1().Services(c.flagK8sNamespace).Get(c.flagK8sService, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting service %s: %s", c.flagK8sService, err)
+	}
+	if c.flagWANSource == sourceService {
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			return nil, fmt.Errorf("service %s is of type %s, not LoadBalancer", c.flagK8sService, svc.Spec.Type)
+		}
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				return &api.ServiceAddress{Address: ingress.IP, Port: c.flagWANPort}, nil
+			}
+			if ingress.Hostname != "" {
+				return &api.ServiceAddress{Address: ingress.Hostname, Port: c.flagWANPort}, nil
+			}
+		}
+		return nil, fmt.Errorf("service %s has no ingress IP or hostname", c.flagK8sService)
+	}
+
+	nodePort := nodePortOf(svc, c.flagPort)
+	if nodePort == 0 {
+		return nil, fmt.Errorf("service %s has no node port", c.flagK8sService)
+	}
+	node, err := c.clientset.CoreV1().Nodes().Get(c.flagNodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting node %s: %s", c.flagNodeName, err)
+	}
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeExternalIP {
+			return &api.ServiceAddress{Address: addr.Address, Port: int(nodePort)}, nil
+		}
+	}
+	return nil, fmt.Errorf("node %s has no external IP", c.flagNodeName)
+}
+
+// nodePortOf returns the node port of the port of svc targeting port, or
+// of its first port if none does.
+func nodePortOf(svc *corev1.Service, port int) int32 {
+	for _, p := range svc.Spec.Ports {
+		if p.TargetPort.IntValue() == port {
+			return p.NodePort
+		}
+	}
+	if len(svc.Spec.Ports) > 0 {
+		return svc.Spec.Ports[0].NodePort
+	}
+	return 0
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagServiceID == "" {
+		c.flagServiceID = c.flagPodName
+	}
+	if c.flagServiceID == "" {
+		return errors.New("-service-id or -pod-name must be set")
+	}
+	if c.flagPodName != "" && c.flagK8sNamespace == "" {
+		return errors.New("-k8s-namespace must be set if -pod-name is set")
+	}
+	if c.flagAddress == "" {
+		return errors.New("-address must be set")
+	}
+	if c.flagSyncPeriod <= 0 {
+		return errors.New("-sync-period must be greater than 0")
+	}
+	switch c.flagWANSource {
+	case sourceStatic:
+		if c.flagWANAddress == "" {
+			return fmt.Errorf("-wan-address must be set if -wan-address-source is %q", sourceStatic)
+		}
+	case sourceService, sourceNodePort:
+		if c.flagK8sNamespace == "" || c.flagK8sService == "" {
+			return fmt.Errorf("-k8s-namespace and -k8s-service must be set if -wan-address-source is %q", c.flagWANSource)
+		}
+		if c.flagWANSource == sourceNodePort && c.flagNodeName == "" {
+			return fmt.Errorf("-node-name must be set if -wan-address-source is %q", sourceNodePort)
+		}
+	default:
+		return fmt.Errorf("-wan-address-source must be one of %q, %q or %q, not %q",
+			sourceService, sourceNodePort, sourceStatic, c.flagWANSource)
+	}
+	return nil
+}
+
+// interrupt sends os.Interrupt signal to the command
+// so it can exit gracefully. This function is needed for tests
+func (c *Command) interrupt() {
+	c.sigCh <- os.Interrupt
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Register a mesh gateway and keep its WAN address up to date"
+const help = `
+Usage: consul-k8s register-mesh-gateway [options]
+
+  Registers the mesh gateway of the pod with the local Consul agent, with
+  the WAN address of -wan-address-source:
+
+    Service - Ingress IP or hostname of the LoadBalancer service
+              -k8s-service, with -wan-port
+    NodePort - External IP of node -node-name, with the node port of
+               -k8s-service
+    Static - -wan-address, with -wan-port
+
+  The WAN address is checked every -sync-period and the gateway registered
+  again when it changes, e.g. when the load balancer is recreated, or when
+  the agent lost the registration. The gateway is deregistered on SIGINT or
+  SIGTERM. This command is expected to run as a sidecar of the mesh gateway.
+
+  Each replica of the gateway registers an instance of the service with the
+  agent of its node. With -pod-name, the ID of the instance defaults to the
+  name of the pod, which is unique, and the service meta records the pod,
+  so that the controller started with -enable-mesh-gateway-janitor
+  deregisters the instances of the pods deleted without deregistering them,
+  e.g. when their node fails:
+
+      $ consul-k8s register-mesh-gateway -pod-name $POD_NAME \
+          -k8s-namespace $POD_NAMESPACE -k8s-service mesh-gateway \
+          -address $POD_IP
+
+  If the mesh config entry peers through mesh gateways, the WAN address is
+  also the address Consul puts in the peering tokens, through which the
+  peers reach the gRPC port of the servers, so the controller doesn't
+  generate tokens until the gateway is registered and its check passes.
+`
@@ -0,0 +1,303 @@
+package registermeshgateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	cases := []struct {
+		args   []string
+		expErr string
+	}{
+		{
+			args:   []string{"-address", "10.0.0.1"},
+			expErr: "-service-id or -pod-name must be set",
+		},
+		{
+			args:   []string{"-pod-name", "mesh-gateway-0", "-address", "10.0.0.1"},
+			expErr: "-k8s-namespace must be set if -pod-name is set",
+		},
+		{
+			args:   []string{"-service-id", "mesh-gateway-0"},
+			expErr: "-address must be set",
+		},
+		{
+			args:   []string{"-service-id", "mesh-gateway-0", "-address", "10.0.0.1", "-wan-address-source", "NodeIP"},
+			expErr: `-wan-address-source must be one of "Service", "NodePort" or "Static", not "NodeIP"`,
+		},
+		{
+			args:   []string{"-service-id", "mesh-gateway-0", "-address", "10.0.0.1", "-wan-address-source", "Static"},
+			expErr: `-wan-address must be set if -wan-address-source is "Static"`,
+		},
+		{
+			args:   []string{"-service-id", "mesh-gateway-0", "-address", "10.0.0.1"},
+			expErr: `-k8s-namespace and -k8s-service must be set if -wan-address-source is "Service"`,
+		},
+		{
+			args: []string{"-service-id", "mesh-gateway-0", "-address", "10.0.0.1", "-wan-address-source", "NodePort",
+				"-k8s-namespace", "default", "-k8s-service", "mesh-gateway"},
+			expErr: `-node-name must be set if -wan-address-source is "NodePort"`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.args))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestCommand_WANAddress(t *testing.T) {
+	nodePortService := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh-gateway", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeNodePort,
+			Ports: []corev1.ServicePort{
+				{Name: "metrics", TargetPort: intstr.FromInt(20200), NodePort: 30200},
+				{Name: "gateway", TargetPort: intstr.FromInt(8443), NodePort: 30443},
+			},
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+			{Type: corev1.NodeExternalIP, Address: "34.1.2.3"},
+		}},
+	}
+	cases := map[string]struct {
+		source     string
+		objects    []runtime.Object
+		expAddress api.ServiceAddress
+		expErr     string
+	}{
+		"load balancer IP": {
+			source:     sourceService,
+			objects:    []runtime.Object{lbService("35.1.2.3", "")},
+			expAddress: api.ServiceAddress{Address: "35.1.2.3", Port: 443},
+		},
+		"load balancer hostname": {
+			source:     sourceService,
+			objects:    []runtime.Object{lbService("", "abc.elb.amazonaws.com")},
+			expAddress: api.ServiceAddress{Address: "abc.elb.amazonaws.com", Port: 443},
+		},
+		"load balancer pending": {
+			source:  sourceService,
+			objects: []runtime.Object{lbService("", "")},
+			expErr:  "service mesh-gateway has no ingress IP or hostname",
+		},
+		"not a load balancer": {
+			source:  sourceService,
+			objects: []runtime.Object{nodePortService},
+			expErr:  "service mesh-gateway is of type NodePort, not LoadBalancer",
+		},
+		"node port": {
+			source:     sourceNodePort,
+			objects:    []runtime.Object{nodePortService, node},
+			expAddress: api.ServiceAddress{Address: "34.1.2.3", Port: 30443},
+		},
+		"node without external IP": {
+			source: sourceNodePort,
+			objects: []runtime.Object{nodePortService, &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			}},
+			expErr: "node node-1 has no external IP",
+		},
+		"static": {
+			source:     sourceStatic,
+			expAddress: api.ServiceAddress{Address: "gateway.example.com", Port: 443},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			cmd := Command{
+				flagWANSource:    c.source,
+				flagWANAddress:   "gateway.example.com",
+				flagWANPort:      443,
+				flagPort:         8443,
+				flagK8sNamespace: "default",
+				flagK8sService:   "mesh-gateway",
+				flagNodeName:     "node-1",
+				clientset:        fake.NewSimpleClientset(c.objects...),
+			}
+			addr, err := cmd.wanAddress()
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expAddress, *addr)
+		})
+	}
+}
+
+// Test that the registration is updated when the load balancer address
+// changes or the agent loses it, and removed on exit.
+func TestRun_UpdatesRegistration(t *testing.T) {
+	agent := newFakeAgent()
+	server := httptest.NewServer(agent)
+	defer server.Close()
+	consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
+	k8s := fake.NewSimpleClientset(lbService("35.1.2.3", ""))
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, consulClient: consulClient, clientset: k8s}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-service-id", "mesh-gateway-0", "-address", "10.0.0.5",
+			"-k8s-namespace", "default", "-k8s-service", "mesh-gateway",
+			"-sync-period", "10ms",
+		})
+	}()
+
+	retry.Run(t, func(r *retry.R) {
+		registration := agent.service("mesh-gateway-0")
+		require.NotNil(r, registration)
+		require.Equal(r, api.ServiceKindMeshGateway, registration.Kind)
+		require.Equal(r, api.ServiceAddress{Address: "10.0.0.5", Port: 8443}, registration.TaggedAddresses["lan"])
+		require.Equal(r, api.ServiceAddress{Address: "35.1.2.3", Port: 443}, registration.TaggedAddresses["wan"])
+	})
+
+	// The load balancer is recreated with a new address.
+	_, err = k8s.CoreV1().Services("default").UpdateStatus(lbService("35.4.5.6", ""))
+	require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		registration := agent.service("mesh-gateway-0")
+		require.NotNil(r, registration)
+		require.Equal(r, api.ServiceAddress{Address: "35.4.5.6", Port: 443}, registration.TaggedAddresses["wan"])
+	})
+
+	// The agent restarts and loses the registration.
+	agent.reset()
+	retry.Run(t, func(r *retry.R) {
+		require.NotNil(r, agent.service("mesh-gateway-0"))
+	})
+
+	cmd.interrupt()
+	select {
+	case code := <-exitCh:
+		require.Equal(t, 0, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("command didn't exit")
+	}
+	require.Nil(t, agent.service("mesh-gateway-0"))
+}
+
+// Test that with -pod-name, the pod's replica is registered with the ID of
+// the pod and the meta of the pod.
+func TestRun_PodName(t *testing.T) {
+	agent := newFakeAgent()
+	server := httptest.NewServer(agent)
+	defer server.Close()
+	consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
+	k8s := fake.NewSimpleClientset(lbService("35.1.2.3", ""))
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, consulClient: consulClient, clientset: k8s}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-pod-name", "mesh-gateway-7d9f5", "-address", "10.0.0.5",
+			"-k8s-namespace", "default", "-k8s-service", "mesh-gateway",
+			"-sync-period", "10ms",
+		})
+	}()
+
+	retry.Run(t, func(r *retry.R) {
+		registration := agent.service("mesh-gateway-7d9f5")
+		require.NotNil(r, registration)
+		require.Equal(r, map[string]string{
+			"external-source": "kubernetes",
+			"external-k8s-ns": "default",
+			"pod-name":        "mesh-gateway-7d9f5",
+		}, registration.Meta)
+	})
+
+	cmd.interrupt()
+	select {
+	case code := <-exitCh:
+		require.Equal(t, 0, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("command didn't exit")
+	}
+	require.Nil(t, agent.service("mesh-gateway-7d9f5"))
+}
+
+func lbService(ip, hostname string) *corev1.Service {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh-gateway", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+	}
+	if ip != "" || hostname != "" {
+		svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: ip, Hostname: hostname}}
+	}
+	return svc
+}
+
+// fakeAgent fakes the service registration endpoints of a Consul agent.
+type fakeAgent struct {
+	lock     sync.Mutex
+	services map[string]*api.AgentServiceRegistration
+}
+
+func newFakeAgent() *fakeAgent {
+	return &fakeAgent{services: make(map[string]*api.AgentServiceRegistration)}
+}
+
+func (f *fakeAgent) service(id string) *api.AgentServiceRegistration {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.services[id]
+}
+
+func (f *fakeAgent) reset() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.services = make(map[string]*api.AgentServiceRegistration)
+}
+
+func (f *fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch {
+	case r.URL.Path == "/v1/agent/service/register":
+		var registration api.AgentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.services[registration.ID] = &registration
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		delete(f.services, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/"):
+		registration, ok := f.services[strings.TrimPrefix(r.URL.Path, "/v1/agent/service/")]
+		if !ok {
+			http.Error(w, "unknown service", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(&api.AgentService{ID: registration.ID, Service: registration.Name})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
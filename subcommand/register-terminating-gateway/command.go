@@ -0,0 +1,409 @@
+package registerterminatinggateway
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+)
+
+// serviceKindTerminatingGateway is the kind of the terminating gateway
+// services, which the Consul API client doesn't define.
+const serviceKindTerminatingGateway api.ServiceKind = "terminating-gateway"
+
+// Command registers the terminating gateway of the pod with the local
+// Consul agent and bootstraps the external services it links.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	logging    *k8sflags.LogFlags
+	audit      *k8sflags.AuditFlags
+	writeLimit *k8sflags.WriteLimitFlags
+
+	flagServiceID      string
+	flagServiceName    string
+	flagAddress        string
+	flagPort           int
+	flagLinkedServices []string
+	flagCheckLinked    bool
+	flagCheckTimeout   time.Duration
+	flagSyncPeriod     time.Duration
+
+	// linkedServices are the parsed -linked-service flags.
+	linkedServices []linkedService
+	// checkStatus is the status of the check of each linked service in
+	// its registration, keyed by the name of the service.
+	checkStatus map[string]string
+
+	consulClient *api.Client
+
+	sigCh chan os.Signal
+	once  sync.Once
+	help  string
+}
+
+// linkedService is an external service linked to the gateway.
+type linkedService struct {
+	name string
+	host string
+	port int
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagServiceID, "service-id", "",
+		"ID of the terminating gateway service, unique on the agent, e.g. the name of the pod.")
+	c.flags.StringVar(&c.flagServiceName, "service-name", "terminating-gateway",
+		"Name of the terminating gateway service.")
+	c.flags.StringVar(&c.flagAddress, "address", "",
+		"Address of the terminating gateway, e.g. the IP of the pod.")
+	c.flags.IntVar(&c.flagPort, "port", 8443,
+		"Port of the terminating gateway.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagLinkedServices), "linked-service",
+		"External service to register and link to the gateway, formatted as <name>=<host>:<port>, "+
+			"e.g. \"db=db.example.com:5432\". May be specified multiple times.")
+	c.flags.BoolVar(&c.flagCheckLinked, "check-linked-services", true,
+		"If true, the linked services are checked every -sync-period by connecting to their host and port, "+
+			"and the status of the checks is updated in the catalog. Disable it if consul-esm checks the external nodes.")
+	c.flags.DurationVar(&c.flagCheckTimeout, "linked-service-check-timeout", 5*time.Second,
+		"Timeout of the checks of the linked services. Defaults to 5s.")
+	c.flags.DurationVar(&c.flagSyncPeriod, "sync-period", 10*time.Second,
+		"Time between checks of the registration. Defaults to 10s.")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.audit = &k8sflags.AuditFlags{}
+	flags.Merge(c.flags, c.audit.Flags())
+	c.writeLimit = &k8sflags.WriteLimitFlags{}
+	flags.Merge(c.flags, c.writeLimit.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// Wait on an interrupt to exit. This channel must be initialized before
+	// Run() is called so that there are no race conditions where the channel
+	// is not defined.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+}
+
+// Run registers the linked services and links them to the gateway, then
+// keeps the gateway registered until it receives SIGINT or SIGTERM and
+// deregisters it. Failed steps are retried every -sync-period.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	stopAudit, err := c.audit.Start(logger.Named("audit"), "consul-k8s-register-terminating-gateway")
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error opening the audit log: %s", err))
+		return 1
+	}
+	defer stopAudit()
+	if err := c.writeLimit.Limit(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.consulClient == nil {
+		var err error
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
+		c.consulClient, err = consul.NewClient(cfg)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	linked := len(c.linkedServices) == 0
+	registered := false
+	c.checkStatus = make(map[string]string)
+	ticker := time.NewTicker(c.flagSyncPeriod)
+	defer ticker.Stop()
+	for {
+		if err := c.registerServices(logger); err != nil {
+			logger.Error("failed to register the external services", "err", err)
+		} else if !linked {
+			if err := c.linkServices(logger); err != nil {
+				logger.Error("failed to link the external services", "err", err)
+			} else {
+				linked = true
+			}
+		}
+		if err := c.syncGateway(logger); err != nil {
+			logger.Error("failed to sync the terminating gateway registration", "err", err)
+		} else {
+			registered = true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.sigCh:
+			if !registered {
+				return 0
+			}
+			if err := c.consulClient.Agent().ServiceDeregister(c.flagServiceID); err != nil {
+				logger.Error("failed to deregister the terminating gateway", "err", err)
+				return 1
+			}
+			logger.Info("deregistered the terminating gateway", "service-id", c.flagServiceID)
+			return 0
+		}
+	}
+}
+
+// syncGateway registers the gateway unless the agent already has its
+// registration.
+func (c *Command) syncGateway(logger hclog.Logger) error {
+	service, _, err := c.consulClient.Agent().Service(c.flagServiceID, nil)
+	if err == nil && service != nil {
+		return nil
+	}
+	err = c.consulClient.Agent().ServiceRegister(&api.AgentServiceRegistration{
+		Kind:    serviceKindTerminatingGateway,
+		ID:      c.flagServiceID,
+		Name:    c.flagServiceName,
+		Address: c.flagAddress,
+		Port:    c.flagPort,
+		Check: &api.AgentServiceCheck{
+			Name:                           "Terminating Gateway Listening",
+			TCP:                            net.JoinHostPort(c.flagAddress, strconv.Itoa(c.flagPort)),
+			Interval:                       "10s",
+			DeregisterCriticalServiceAfter: "6h",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("registering the terminating gateway: %s", err)
+	}
+	logger.Info("registered the terminating gateway", "service-id", c.flagServiceID)
+	return nil
+}
+
+// registerServices registers the linked services in the catalog, each on
+// an external node named after its host. If -check-linked-services is set,
+// the services are checked first, and only registered again when the
+// status of their check changed. Otherwise they're only registered once.
+//
+// The checks run in the pod of the gateway, so they report whether the
+// gateway can reach the services.
+func (c *Command) registerServices(logger hclog.Logger) error {
+	for _, service := range c.linkedServices {
+		addr := net.JoinHostPort(service.host, strconv.Itoa(service.port))
+		registration := &api.CatalogRegistration{
+			Node:     service.host,
+			Address:  service.host,
+			NodeMeta: map[string]string{"external-node": "true", "external-probe": "true"},
+			Service: &api.AgentService{
+				ID:      service.name,
+				Service: service.name,
+				Address: service.host,
+				Port:    service.port,
+			},
+		}
+		status := ""
+		if c.flagCheckLinked {
+			var output string
+			status, output = c.checkService(addr)
+			registration.Checks = api.HealthChecks{{
+				Node:       service.host,
+				CheckID:    "service:" + service.name,
+				Name:       "External Service Listening",
+				Status:     status,
+				Output:     output,
+				ServiceID:  service.name,
+				Type:       "tcp",
+				Definition: api.HealthCheckDefinition{TCP: addr},
+			}}
+		}
+		if previous, ok := c.checkStatus[service.name]; ok && previous == status {
+			continue
+		}
+		if _, err := c.consulClient.Catalog().Register(registration, nil); err != nil {
+			return fmt.Errorf("registering service %q: %s", service.name, err)
+		}
+		c.checkStatus[service.name] = status
+		logger.Info("registered the external service", "service", service.name, "node", service.host, "status", status)
+	}
+	return nil
+}
+
+// checkService connects to the address of a linked service and returns
+// the status and output of its check.
+func (c *Command) checkService(addr string) (string, string) {
+	conn, err := net.DialTimeout("tcp", addr, c.flagCheckTimeout)
+	if err != nil {
+		return api.HealthCritical, fmt.Sprintf("TCP connect %s: %s", addr, err)
+	}
+	conn.Close()
+	return api.HealthPassing, fmt.Sprintf("TCP connect %s: Success", addr)
+}
+
+// linkServices adds the linked services to the terminating-gateway config
+// entry of the gateway. The services the entry already links are kept.
+func (c *Command) linkServices(logger hclog.Logger) error {
+	entry, err := c.readConfigEntry()
+	if err != nil {
+		return fmt.Errorf("reading the terminating-gateway config entry: %s", err)
+	}
+	changed := false
+	for _, service := range c.linkedServices {
+		found := false
+		for _, existing := range entry.Services {
+			if existing.Name == service.name || existing.Name == "*" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			entry.Services = append(entry.Services, v1alpha1.LinkedService{Name: service.name})
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if _, _, err := c.consulClient.ConfigEntries().Set(entry, nil); err != nil {
+		return fmt.Errorf("writing the terminating-gateway config entry: %s", err)
+	}
+	logger.Info("linked the external services to the terminating gateway", "service-name", c.flagServiceName)
+	return nil
+}
+
+// readConfigEntry returns the terminating-gateway config entry of the
+// gateway, or a new one if it doesn't exist. The Consul API client doesn't
+// decode the kind, so the entries are listed with a raw query.
+func (c *Command) readConfigEntry() (*v1alpha1.TerminatingGatewayConfigEntry, error) {
+	var entries []*v1alpha1.TerminatingGatewayConfigEntry
+	if _, err := c.consulClient.Raw().Query("/v1/config/"+url.PathEscape(v1alpha1.TerminatingGatewayKind), &entries, nil); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Name == c.flagServiceName {
+			return entry, nil
+		}
+	}
+	return &v1alpha1.TerminatingGatewayConfigEntry{Kind: v1alpha1.TerminatingGatewayKind, Name: c.flagServiceName}, nil
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagServiceID == "" {
+		return errors.New("-service-id must be set")
+	}
+	if c.flagAddress == "" {
+		return errors.New("-address must be set")
+	}
+	if c.flagSyncPeriod <= 0 {
+		return errors.New("-sync-period must be greater than 0")
+	}
+	if c.flagCheckTimeout <= 0 {
+		return errors.New("-linked-service-check-timeout must be greater than 0")
+	}
+	c.linkedServices = nil
+	for _, s := range c.flagLinkedServices {
+		service, err := parseLinkedService(s)
+		if err != nil {
+			return err
+		}
+		c.linkedServices = append(c.linkedServices, service)
+	}
+	return nil
+}
+
+// parseLinkedService parses a -linked-service flag of the form
+// <name>=<host>:<port>.
+func parseLinkedService(s string) (linkedService, error) {
+	invalid := fmt.Errorf("-linked-service %q must be of the form <name>=<host>:<port>", s)
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return linkedService{}, invalid
+	}
+	host, portStr, err := net.SplitHostPort(parts[1])
+	if err != nil || host == "" {
+		return linkedService{}, invalid
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return linkedService{}, invalid
+	}
+	return linkedService{name: parts[0], host: host, port: port}, nil
+}
+
+// interrupt sends os.Interrupt signal to the command
+// so it can exit gracefully. This function is needed for tests
+func (c *Command) interrupt() {
+	c.sigCh <- os.Interrupt
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Register a terminating gateway and the external services it links"
+const help = `
+Usage: consul-k8s register-terminating-gateway [options]
+
+  Registers the terminating gateway of the pod with the local Consul agent,
+  and registers it again whenever the agent lost the registration, until it
+  receives SIGINT or SIGTERM and deregisters the gateway. This command is
+  expected to run as a sidecar of the terminating gateway.
+
+  Each -linked-service is registered in the catalog on an external node
+  named after its host, and added to the terminating-gateway config entry
+  of -service-name, keeping the services the entry already links:
+
+      $ consul-k8s register-terminating-gateway -service-id $POD_NAME \
+          -address $POD_IP -linked-service db=db.example.com:5432
+
+  Unless -check-linked-services is false, the command connects to the host
+  and port of each linked service every -sync-period and updates the status
+  of its check in the catalog, so that the health of the services reflects
+  whether the gateway can reach them.
+
+  The services can be managed with the Registration and TerminatingGateway
+  custom resources instead, in which case -linked-service must not be set
+  since the controller owns the config entry.
+`
@@ -0,0 +1,259 @@
+package registerterminatinggateway
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/api/v1alpha1"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	cases := []struct {
+		args   []string
+		expErr string
+	}{
+		{
+			args:   []string{"-address", "10.0.0.1"},
+			expErr: "-service-id must be set",
+		},
+		{
+			args:   []string{"-service-id", "terminating-gateway-0"},
+			expErr: "-address must be set",
+		},
+		{
+			args:   []string{"-service-id", "terminating-gateway-0", "-address", "10.0.0.1", "-linked-service", "db"},
+			expErr: `-linked-service "db" must be of the form <name>=<host>:<port>`,
+		},
+		{
+			args:   []string{"-service-id", "terminating-gateway-0", "-address", "10.0.0.1", "-linked-service", "db=db.example.com"},
+			expErr: `-linked-service "db=db.example.com" must be of the form <name>=<host>:<port>`,
+		},
+		{
+			args:   []string{"-service-id", "terminating-gateway-0", "-address", "10.0.0.1", "-linked-service", "db=db.example.com:http"},
+			expErr: `-linked-service "db=db.example.com:http" must be of the form <name>=<host>:<port>`,
+		},
+		{
+			args:   []string{"-service-id", "terminating-gateway-0", "-address", "10.0.0.1", "-linked-service-check-timeout", "0s"},
+			expErr: "-linked-service-check-timeout must be greater than 0",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.args))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	cases := map[string]struct {
+		existing    *v1alpha1.TerminatingGatewayConfigEntry
+		expServices []v1alpha1.LinkedService
+	}{
+		"new config entry": {
+			expServices: []v1alpha1.LinkedService{{Name: "db"}, {Name: "billing"}},
+		},
+		"existing config entry": {
+			existing: &v1alpha1.TerminatingGatewayConfigEntry{
+				Kind:     v1alpha1.TerminatingGatewayKind,
+				Name:     "terminating-gateway",
+				Services: []v1alpha1.LinkedService{{Name: "legacy", SNI: "legacy.example.com"}, {Name: "db", CAFile: "/ca.pem"}},
+			},
+			expServices: []v1alpha1.LinkedService{{Name: "legacy", SNI: "legacy.example.com"}, {Name: "db", CAFile: "/ca.pem"}, {Name: "billing"}},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			consul := newFakeConsul()
+			consul.entry = c.existing
+			server := httptest.NewServer(consul)
+			defer server.Close()
+			consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+			require.NoError(t, err)
+
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui, consulClient: consulClient}
+			exitCh := make(chan int, 1)
+			go func() {
+				exitCh <- cmd.Run([]string{
+					"-service-id", "terminating-gateway-0", "-address", "10.0.0.5",
+					"-linked-service", "db=db.example.com:5432", "-linked-service", "billing=10.1.0.1:443",
+					"-sync-period", "10ms", "-check-linked-services=false",
+				})
+			}()
+
+			retry.Run(t, func(r *retry.R) {
+				consul.lock.Lock()
+				defer consul.lock.Unlock()
+				gateway := consul.services["terminating-gateway-0"]
+				require.NotNil(r, gateway)
+				require.Equal(r, serviceKindTerminatingGateway, gateway.Kind)
+				require.Equal(r, "terminating-gateway", gateway.Name)
+				require.Equal(r, "10.0.0.5:8443", gateway.Check.TCP)
+				require.NotNil(r, consul.entry)
+				require.Equal(r, c.expServices, consul.entry.Services)
+			})
+
+			consul.lock.Lock()
+			require.Len(t, consul.catalog, 2)
+			db := consul.catalog["db"]
+			require.Equal(t, "db.example.com", db.Node)
+			require.Equal(t, "db.example.com", db.Address)
+			require.Equal(t, "true", db.NodeMeta["external-node"])
+			require.Equal(t, 5432, db.Service.Port)
+			require.Empty(t, db.Checks)
+			require.Equal(t, 443, consul.catalog["billing"].Service.Port)
+			require.Equal(t, 2, consul.catalogWrites)
+			consul.lock.Unlock()
+
+			cmd.interrupt()
+			select {
+			case code := <-exitCh:
+				require.Equal(t, 0, code)
+			case <-time.After(5 * time.Second):
+				t.Fatal("command didn't exit")
+			}
+			consul.lock.Lock()
+			defer consul.lock.Unlock()
+			require.Empty(t, consul.services)
+			require.Equal(t, 1, consul.entryWrites)
+		})
+	}
+}
+
+// Test that the checks of the linked services are registered, and
+// registered again when their status changes.
+func TestRun_CheckLinkedServices(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	consul := newFakeConsul()
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, consulClient: consulClient}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-service-id", "terminating-gateway-0", "-address", "10.0.0.5",
+			"-linked-service", "db=" + addr, "-sync-period", "10ms",
+		})
+	}()
+
+	requireCheck := func(r *retry.R, status string) {
+		consul.lock.Lock()
+		defer consul.lock.Unlock()
+		db := consul.catalog["db"]
+		require.NotNil(r, db)
+		require.Len(r, db.Checks, 1)
+		check := db.Checks[0]
+		require.Equal(r, "service:db", check.CheckID)
+		require.Equal(r, "db", check.ServiceID)
+		require.Equal(r, addr, check.Definition.TCP)
+		require.Equal(r, status, check.Status)
+	}
+	retry.Run(t, func(r *retry.R) {
+		requireCheck(r, api.HealthPassing)
+	})
+	consul.lock.Lock()
+	writes := consul.catalogWrites
+	consul.lock.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	consul.lock.Lock()
+	require.Equal(t, writes, consul.catalogWrites, "the service must only be registered again when its status changes")
+	consul.lock.Unlock()
+
+	listener.Close()
+	retry.Run(t, func(r *retry.R) {
+		requireCheck(r, api.HealthCritical)
+	})
+
+	cmd.interrupt()
+	select {
+	case code := <-exitCh:
+		require.Equal(t, 0, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("command didn't exit")
+	}
+}
+
+// fakeConsul fakes the endpoints of the Consul API the command uses.
+type fakeConsul struct {
+	lock          sync.Mutex
+	services      map[string]*api.AgentServiceRegistration
+	catalog       map[string]*api.CatalogRegistration
+	catalogWrites int
+	entry         *v1alpha1.TerminatingGatewayConfigEntry
+	entryWrites   int
+}
+
+func newFakeConsul() *fakeConsul {
+	return &fakeConsul{
+		services: make(map[string]*api.AgentServiceRegistration),
+		catalog:  make(map[string]*api.CatalogRegistration),
+	}
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch {
+	case r.URL.Path == "/v1/agent/service/register":
+		var registration api.AgentServiceRegistration
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.services[registration.ID] = &registration
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		delete(f.services, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/"):
+		registration, ok := f.services[strings.TrimPrefix(r.URL.Path, "/v1/agent/service/")]
+		if !ok {
+			http.Error(w, "unknown service", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(&api.AgentService{ID: registration.ID, Service: registration.Name})
+	case r.URL.Path == "/v1/catalog/register":
+		var registration api.CatalogRegistration
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.catalog[registration.Service.ID] = &registration
+		f.catalogWrites++
+		w.Write([]byte("true"))
+	case r.URL.Path == "/v1/config/terminating-gateway":
+		entries := []*v1alpha1.TerminatingGatewayConfigEntry{}
+		if f.entry != nil {
+			entries = append(entries, f.entry)
+		}
+		json.NewEncoder(w).Encode(entries)
+	case r.URL.Path == "/v1/config" && r.Method == http.MethodPut:
+		var entry v1alpha1.TerminatingGatewayConfigEntry
+		if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.entry = &entry
+		f.entryWrites++
+		w.Write([]byte("true"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
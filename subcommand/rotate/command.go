@@ -0,0 +1,36 @@
+package rotate
+
+import (
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+)
+
+// Command is the parent of the commands rotating the secrets of the
+// installation.
+type Command struct {
+	UI cli.Ui
+}
+
+func (c *Command) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	return flags.Usage(help, nil)
+}
+
+const synopsis = "Rotate the secrets of the installation"
+const help = `
+Usage: consul-k8s rotate <subcommand> [options] [args]
+
+  Rotates the secrets of the Consul installation without downtime, updating
+  both the running agents and the Kubernetes secrets the restarted agents
+  read them from.
+
+  Rotate the gossip encryption key:
+
+      $ consul-k8s rotate gossip-key
+
+  For more examples, ask for subcommand help or view the documentation.
+`
@@ -0,0 +1,198 @@
+package gossipkey
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/helper/gossip"
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul-k8s/subcommand/snapshot"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command rotates the gossip encryption key of the agents of the
+// installation, rolling back if the new key can't be used.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagToken         string
+	flagAutoApprove   bool
+	flagKubectlBinary string
+
+	helm       *helm.Client
+	kubeClient kubernetes.Interface
+	forward    portforward.Forwarder
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagToken, "token", "",
+		"ACL token of the rotation, with operator:write. Defaults to the bootstrap token of the installation when the chart manages the ACLs.")
+	c.flags.BoolVar(&c.flagAutoApprove, "auto-approve", false,
+		"If true, rotates the key without asking for confirmation.")
+	c.flags.StringVar(&c.flagKubectlBinary, "kubectl", "kubectl",
+		"Path of the kubectl binary, used to port-forward to the Consul server.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+
+	if c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.helm == nil {
+		c.helm = &helm.Client{KubeConfig: c.k8s.KubeConfig()}
+	}
+	if c.forward == nil {
+		c.forward = portforward.Kubectl(c.flagKubectlBinary, c.k8s.KubeConfig())
+	}
+
+	release, err := c.helm.Release()
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	values, err := c.helm.Values(release.Name, release.Namespace)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error getting the values of the release: %s", err))
+		return 1
+	}
+	secretName, secretKey := gossipSecret(release, values)
+	if secretName == "" {
+		c.UI.Error(" ✗ Gossip encryption isn't enabled in the values of the installation, set global.gossipEncryption")
+		return 1
+	}
+
+	server := &snapshot.Server{Helm: c.helm, KubeClient: c.kubeClient, Forward: c.forward, Token: c.flagToken}
+	client, _, closeForward, err := server.Connect()
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	defer closeForward()
+
+	if !c.flagAutoApprove {
+		c.UI.Output(fmt.Sprintf("The gossip encryption key of secret %s will be replaced by a new key on all the agents.", secretName))
+		ok, err := subcommand.Confirm(c.UI, "\nProceed with the rotation?")
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading the confirmation: %s", err))
+			return 1
+		}
+		if !ok {
+			c.UI.Output("Rotation cancelled.")
+			return 1
+		}
+	}
+
+	c.UI.Output(fmt.Sprintf("==> Rotating the gossip encryption key of secret %s", secretName))
+	rotation := &gossip.Rotation{
+		Secrets:    c.kubeClient.CoreV1().Secrets(release.Namespace),
+		SecretName: secretName,
+		SecretKey:  secretKey,
+		Operator:   client.Operator(),
+		Progress:   c.progress,
+		Rollback:   true,
+	}
+	if err := rotation.Run(); err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error rotating the gossip encryption key: %s", err))
+		return 1
+	}
+	c.UI.Output(" ✓ Rotated the gossip encryption key, the agents and the secret use the new key")
+	return 0
+}
+
+// progress prints a step of the rotation and its details.
+func (c *Command) progress(msg string, args ...interface{}) {
+	var details []string
+	for i := 0; i+1 < len(args); i += 2 {
+		details = append(details, fmt.Sprintf("%s=%v", args[i], args[i+1]))
+	}
+	if len(details) > 0 {
+		msg = fmt.Sprintf("%s (%s)", msg, strings.Join(details, ", "))
+	}
+	c.UI.Output(" - " + msg)
+}
+
+// gossipSecret returns the name and key of the secret of the gossip
+// encryption key of the release, or an empty name if gossip encryption
+// isn't enabled.
+func gossipSecret(release helm.Release, values map[string]interface{}) (string, string) {
+	name, _, _ := unstructured.NestedString(values, "global", "gossipEncryption", "secretName")
+	if name != "" {
+		key, _, _ := unstructured.NestedString(values, "global", "gossipEncryption", "secretKey")
+		if key == "" {
+			key = "key"
+		}
+		return name, key
+	}
+	if generate, _, _ := unstructured.NestedBool(values, "global", "gossipEncryption", "autoGenerate"); !generate {
+		return "", ""
+	}
+	return release.ResourcePrefix(values) + "-gossip-encryption-key", "key"
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Rotate the gossip encryption key of the agents"
+const help = `
+Usage: consul-k8s rotate gossip-key [options]
+
+  Rotates the gossip encryption key of the agents of the installation, set
+  by global.gossipEncryption, through a ready server reached with kubectl
+  port-forward. It must be confirmed unless -auto-approve is set.
+
+  A new key is installed on all the agents, then used as their primary key,
+  then the old key is removed. The secret of the key is updated at each
+  step, so the agents don't need to be restarted. If the new key can't be
+  installed on all the agents or used, it's removed again and the old key
+  is kept. If removing the old key fails, running the command again resumes
+  the rotation.
+
+      $ consul-k8s rotate gossip-key
+
+`
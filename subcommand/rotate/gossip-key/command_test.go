@@ -0,0 +1,149 @@
+package gossipkey
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/gossip"
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun(t *testing.T) {
+	cases := map[string]struct {
+		values       string
+		secret       string
+		secretKey    string
+		flags        []string
+		input        string
+		missingNodes int
+		expCode      int
+		expOutput    []string
+		expErr       string
+	}{
+		"secret of the values": {
+			values:    `{"global":{"gossipEncryption":{"secretName":"gossip","secretKey":"gossip-key"}}}`,
+			secret:    "gossip",
+			secretKey: "gossip-key",
+			flags:     []string{"-auto-approve"},
+			expOutput: []string{
+				"==> Rotating the gossip encryption key of secret gossip",
+				" - Installing new key",
+				" - Using new key",
+				" - Removing old key",
+				" ✓ Rotated the gossip encryption key, the agents and the secret use the new key",
+			},
+		},
+		"generated secret": {
+			values:    `{"global":{"name":"hashi","gossipEncryption":{"autoGenerate":true}}}`,
+			secret:    "hashi-gossip-encryption-key",
+			secretKey: "key",
+			input:     "yes\n",
+			expOutput: []string{
+				"The gossip encryption key of secret hashi-gossip-encryption-key will be replaced by a new key on all the agents.",
+				" ✓ Rotated the gossip encryption key, the agents and the secret use the new key",
+			},
+		},
+		"generated secret with fullname override": {
+			values:    `{"fullnameOverride":"override","global":{"name":"hashi","gossipEncryption":{"autoGenerate":true}}}`,
+			secret:    "override-gossip-encryption-key",
+			secretKey: "key",
+			flags:     []string{"-auto-approve"},
+			expOutput: []string{
+				"==> Rotating the gossip encryption key of secret override-gossip-encryption-key",
+				" ✓ Rotated the gossip encryption key, the agents and the secret use the new key",
+			},
+		},
+		"cancelled": {
+			values:    `{"global":{"gossipEncryption":{"autoGenerate":true}}}`,
+			secret:    "consul-consul-gossip-encryption-key",
+			secretKey: "key",
+			input:     "no\n",
+			expCode:   1,
+			expOutput: []string{"Rotation cancelled."},
+		},
+		"rolled back": {
+			values:       `{"global":{"gossipEncryption":{"autoGenerate":true}}}`,
+			secret:       "consul-consul-gossip-encryption-key",
+			secretKey:    "key",
+			flags:        []string{"-auto-approve"},
+			missingNodes: 1,
+			expCode:      1,
+			expOutput:    []string{" - Installing new key", " - Rolling back the rotation"},
+			expErr: ` ✗ Error rotating the gossip encryption key: new key is installed on 2 of 3 nodes ` +
+				`of the LAN keyring of datacenter "dc1", the rotation was rolled back`,
+		},
+		"gossip encryption disabled": {
+			values:  `{}`,
+			flags:   []string{"-auto-approve"},
+			expCode: 1,
+			expErr:  " ✗ Gossip encryption isn't enabled in the values of the installation, set global.gossipEncryption",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			keyring := gossip.NewTestKeyring("old")
+			keyring.MissingNodes = c.missingNodes
+			consul := httptest.NewServer(keyring)
+			defer consul.Close()
+
+			kubeClient := fake.NewSimpleClientset(
+				&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "consul-server-0",
+						Namespace: "mesh",
+						Labels:    map[string]string{"app": "consul", "release": "consul", "component": "server"},
+					},
+					Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+				},
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: c.secret, Namespace: "mesh"},
+					Data:       map[string][]byte{c.secretKey: []byte("old")},
+				},
+			)
+			client, err := helm.NewTestClient(nil, helm.TestRelease{
+				Name:      "consul",
+				Namespace: "mesh",
+				Chart:     "consul-0.24.1",
+				Values:    c.values,
+			})
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			ui.InputReader = strings.NewReader(c.input)
+			cmd := Command{
+				UI:         ui,
+				helm:       client,
+				kubeClient: kubeClient,
+				forward: func(namespace, name string, remotePort int) (string, func(), error) {
+					return consul.Listener.Addr().String(), func() {}, nil
+				},
+			}
+			require.Equal(t, c.expCode, cmd.Run(c.flags), ui.ErrorWriter.String())
+			output := ui.OutputWriter.String()
+			for _, line := range c.expOutput {
+				require.Contains(t, output, line)
+			}
+			if c.expErr != "" {
+				require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+			}
+
+			keys, primary := keyring.State()
+			if c.expCode != 0 {
+				require.Equal(t, []string{"old"}, keys)
+				require.Equal(t, "old", primary)
+				return
+			}
+			secret, err := kubeClient.CoreV1().Secrets("mesh").Get(c.secret, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, map[string][]byte{c.secretKey: []byte(primary)}, secret.Data)
+			require.Equal(t, []string{primary}, keys)
+			require.NotEqual(t, "old", primary)
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package sdsserver
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/hashicorp/consul-k8s/helper/sds"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Command serves the certificates of the Kubernetes TLS secrets of a
+// namespace to the Envoy proxies of the gateways over SDS.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+
+	flagListen       string
+	flagK8sNamespace string
+	flagWriteDir     string
+
+	clientset kubernetes.Interface
+
+	sigCh chan os.Signal
+	once  sync.Once
+	help  string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagListen, "listen", "127.0.0.1:8090",
+		"Address to serve the SDS API on. It serves private keys, so it should only be reachable by the gateway, "+
+			"e.g. on the loopback interface of the pod.")
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Kubernetes namespace of the TLS secrets to serve, e.g. the namespace of the gateway.")
+	c.flags.StringVar(&c.flagWriteDir, "write-dir", "",
+		"If set, the secrets are also written to files in this directory, one directory per secret, for the "+
+			"gateways configured with files, e.g. terminating gateways originating TLS to their linked services.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// Wait on an interrupt to exit. This channel must be initialized before
+	// Run() is called so that there are no race conditions where the channel
+	// is not defined.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+}
+
+// Run watches the TLS secrets of the namespace and serves their
+// certificates until it receives SIGINT or SIGTERM.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	listener, err := net.Listen("tcp", c.flagListen)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error listening on %s: %s", c.flagListen, err))
+		return 1
+	}
+
+	server := sds.NewServer(logger.Named("sds"))
+	informer := c.informer(server, logger)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go informer.Run(stopCh)
+
+	grpcServer := grpc.NewServer()
+	server.Register(grpcServer)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- grpcServer.Serve(listener)
+	}()
+	logger.Info("serving the TLS secrets over SDS", "listen", listener.Addr().String(), "namespace", c.flagK8sNamespace)
+
+	select {
+	case err := <-errCh:
+		c.UI.Error(fmt.Sprintf("Error serving SDS: %s", err))
+		return 1
+	case <-c.sigCh:
+		// The streams of Envoy never end, so they're closed rather than
+		// drained.
+		grpcServer.Stop()
+		return 0
+	}
+}
+
+// informer returns an informer of the TLS secrets of the namespace,
+// updating the certificates of the server.
+func (c *Command) informer(server *sds.Server, logger hclog.Logger) cache.SharedIndexInformer {
+	secrets := c.clientset.CoreV1().Secrets(c.flagK8sNamespace)
+	selector := fields.OneTermEqualSelector("type", string(corev1.SecretTypeTLS)).String()
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = selector
+				return secrets.List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				return secrets.Watch(options)
+			},
+		},
+		&corev1.Secret{},
+		0,
+		cache.Indexers{},
+	)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if secret, ok := obj.(*corev1.Secret); ok {
+				logger.Debug("secret added", "name", secret.Name)
+				server.Upsert(secret)
+				c.writeSecret(secret, logger)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if secret, ok := obj.(*corev1.Secret); ok {
+				logger.Debug("secret updated", "name", secret.Name)
+				server.Upsert(secret)
+				c.writeSecret(secret, logger)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if secret, ok := obj.(*corev1.Secret); ok {
+				logger.Debug("secret deleted", "name", secret.Name)
+				server.Delete(secret.Name)
+				if c.flagWriteDir != "" {
+					if err := os.RemoveAll(filepath.Join(c.flagWriteDir, secret.Name)); err != nil {
+						logger.Error("error removing secret files", "name", secret.Name, "err", err)
+					}
+				}
+			}
+		},
+	})
+	return informer
+}
+
+// secretFiles are the keys of the TLS secrets written to -write-dir.
+var secretFiles = []string{"ca.crt", corev1.TLSCertKey, corev1.TLSPrivateKeyKey}
+
+// writeSecret writes the keys of the secret to its directory of
+// -write-dir, if it's set. Each file is replaced atomically so that Envoy
+// never reads a partially written certificate.
+func (c *Command) writeSecret(secret *corev1.Secret, logger hclog.Logger) {
+	if c.flagWriteDir == "" {
+		return
+	}
+	dir := filepath.Join(c.flagWriteDir, secret.Name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logger.Error("error writing secret files", "name", secret.Name, "err", err)
+		return
+	}
+	for _, key := range secretFiles {
+		data, ok := secret.Data[key]
+		if !ok {
+			continue
+		}
+		path := filepath.Join(dir, key)
+		tmp := path + ".tmp"
+		err := ioutil.WriteFile(tmp, data, 0600)
+		if err == nil {
+			err = os.Rename(tmp, path)
+		}
+		if err != nil {
+			logger.Error("error writing secret file", "name", secret.Name, "file", key, "err", err)
+		}
+	}
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagK8sNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	return nil
+}
+
+// interrupt sends os.Interrupt signal to the command
+// so it can exit gracefully. This function is needed for tests
+func (c *Command) interrupt() {
+	c.sigCh <- os.Interrupt
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Serve the certificates of Kubernetes TLS secrets over SDS"
+const help = `
+Usage: consul-k8s sds-server [options]
+
+  Serves the certificates of the kubernetes.io/tls secrets of
+  -k8s-namespace, e.g. the secrets of cert-manager certificates, over the
+  secret discovery service (SDS) API of Envoy, until it receives SIGINT or
+  SIGTERM. This command is expected to run as a sidecar of the gateways.
+
+  Each secret is served as the SDS resource of the same name. The
+  certificates are pushed to Envoy whenever their secrets change, so
+  renewed certificates are used without restarting the gateways. Envoy
+  keeps the last certificate of a secret that's deleted.
+
+  Ingress gateways fetch their certificates from the server with the sds
+  TLS configuration of the IngressGateway resource, whose clusterName is
+  a static cluster of the gateway's Envoy bootstrap connecting to -listen
+  over HTTP/2, and whose certResource is the name of the secret:
+
+      $ consul-k8s sds-server -k8s-namespace $POD_NAMESPACE
+
+  Terminating gateways don't support SDS for their linked services, whose
+  certificates are files. With -write-dir set to a volume shared with the
+  gateway, the ca.crt, tls.crt and tls.key of each secret are also
+  written to files in the directory of the secret, and rewritten when it
+  changes. The tlsSecretName of a TerminatingGateway's service references
+  these files under /consul/terminating-gateway/tls, which must be
+  -write-dir:
+
+      $ consul-k8s sds-server -k8s-namespace $POD_NAMESPACE \
+          -write-dir /consul/terminating-gateway/tls
+
+  Envoy only reads the files when Consul configures the clusters of the
+  linked services, e.g. when the gateway restarts or the linked services
+  change, so renewed certificates aren't used before then.
+
+  The service account of the pod must be allowed to list and watch the
+  secrets of the namespace.
+`
@@ -0,0 +1,156 @@
+package sdsserver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/sds"
+	"github.com/hashicorp/consul/sdk/freeport"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	cases := []struct {
+		args   []string
+		expErr string
+	}{
+		{
+			args:   []string{"foo"},
+			expErr: "Should have no non-flag arguments.",
+		},
+		{
+			args:   []string{},
+			expErr: "-k8s-namespace must be set",
+		},
+		{
+			args:   []string{"-k8s-namespace", "default", "-log-level", "verbose"},
+			expErr: "Unknown log level: verbose",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui, clientset: fake.NewSimpleClientset()}
+			require.Equal(t, 1, cmd.Run(c.args))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+// Test that the certificates of the secrets are served, and served again
+// when the secrets are renewed.
+func TestRun(t *testing.T) {
+	k8s := fake.NewSimpleClientset(tlsSecret("gateway-cert", "CERT-1"))
+	addr := fmt.Sprintf("127.0.0.1:%d", freeport.MustTake(1)[0])
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, clientset: k8s}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{"-k8s-namespace", "default", "-listen", addr})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var client *sds.TestClient
+	retry.Run(t, func(r *retry.R) {
+		var err error
+		client, err = sds.NewTestClient(ctx, addr)
+		require.NoError(r, err)
+		require.NoError(r, client.Subscribe("gateway-cert"))
+		certs, err := client.Receive()
+		if err != nil {
+			client.Close()
+		}
+		require.NoError(r, err)
+		require.Equal(r, map[string]string{"gateway-cert": "CERT-1"}, certs)
+	})
+	defer client.Close()
+
+	// cert-manager renews the certificate.
+	require.NoError(t, client.Subscribe("gateway-cert"))
+	_, err := k8s.CoreV1().Secrets("default").Update(tlsSecret("gateway-cert", "CERT-2"))
+	require.NoError(t, err)
+	certs, err := client.Receive()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"gateway-cert": "CERT-2"}, certs)
+
+	cmd.interrupt()
+	select {
+	case code := <-exitCh:
+		require.Equal(t, 0, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("command didn't exit")
+	}
+}
+
+// Test that the secrets are written to -write-dir, and written again when
+// they're renewed.
+func TestRun_WriteDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sds-server")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	secret := tlsSecret("db-client", "CERT-1")
+	secret.Data["ca.crt"] = []byte("CA")
+	k8s := fake.NewSimpleClientset(secret)
+	addr := fmt.Sprintf("127.0.0.1:%d", freeport.MustTake(1)[0])
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, clientset: k8s}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{"-k8s-namespace", "default", "-listen", addr, "-write-dir", dir})
+	}()
+
+	readFile := func(r *retry.R, key string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "db-client", key))
+		require.NoError(r, err)
+		return string(data)
+	}
+	retry.Run(t, func(r *retry.R) {
+		require.Equal(r, "CA", readFile(r, "ca.crt"))
+		require.Equal(r, "CERT-1", readFile(r, "tls.crt"))
+		require.Equal(r, "KEY", readFile(r, "tls.key"))
+	})
+
+	secret.Data["tls.crt"] = []byte("CERT-2")
+	_, err = k8s.CoreV1().Secrets("default").Update(secret)
+	require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		require.Equal(r, "CERT-2", readFile(r, "tls.crt"))
+	})
+
+	// The files are removed with the secret.
+	require.NoError(t, k8s.CoreV1().Secrets("default").Delete("db-client", nil))
+	retry.Run(t, func(r *retry.R) {
+		_, err := os.Stat(filepath.Join(dir, "db-client"))
+		require.True(r, os.IsNotExist(err))
+	})
+
+	cmd.interrupt()
+	select {
+	case code := <-exitCh:
+		require.Equal(t, 0, code)
+	case <-time.After(5 * time.Second):
+		t.Fatal("command didn't exit")
+	}
+}
+
+func tlsSecret(name, cert string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte(cert),
+			corev1.TLSPrivateKeyKey: []byte("KEY"),
+		},
+	}
+}
@@ -7,10 +7,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/helper/metrics"
 	"github.com/hashicorp/consul-k8s/subcommand"
 	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/api"
@@ -26,7 +30,11 @@ type Command struct {
 	UI cli.Ui
 
 	flags                         *flag.FlagSet
+	configFile                    *k8sflags.ConfigFileFlags
 	k8s                           *k8sflags.K8SFlags
+	logging                       *k8sflags.LogFlags
+	audit                         *k8sflags.AuditFlags
+	writeLimit                    *k8sflags.WriteLimitFlags
 	flagResourcePrefix            string
 	flagK8sNamespace              string
 	flagAllowDNS                  bool
@@ -55,8 +63,9 @@ type Command struct {
 	flagEnableInjectK8SNSMirroring       bool   // Enables mirroring of k8s namespaces into Consul for Connect inject
 	flagInjectK8SNSMirroringPrefix       string // Prefix added to Consul namespaces created when mirroring injected services
 
-	flagLogLevel string
-	flagTimeout  time.Duration
+	flagTimeout time.Duration
+
+	flagMetricsAddr string // Address to serve the Prometheus metrics on
 
 	clientset kubernetes.Interface
 	// cmdTimeout is cancelled when the command timeout is reached.
@@ -66,6 +75,9 @@ type Command struct {
 	// Log
 	Log hclog.Logger
 
+	// sigCh receives a signal when the command should stop.
+	sigCh chan os.Signal
+
 	once sync.Once
 	help string
 }
@@ -129,14 +141,29 @@ func (c *Command) init() {
 		"Path to file containing ACL token to be used for ACL replication. If set, ACL replication is enabled.")
 	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Minute,
 		"How long we'll try to bootstrap ACLs for before timing out, e.g. 1ms, 2s, 3m")
-	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
-		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
-			"\"debug\", \"info\", \"warn\", and \"error\".")
+	c.flags.StringVar(&c.flagMetricsAddr, "metrics-addr", "",
+		"Address to serve the Prometheus metrics on at /metrics while the command runs, e.g. \":9102\". "+
+			"If blank, metrics are disabled.")
 
 	c.k8s = &k8sflags.K8SFlags{}
 	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.audit = &k8sflags.AuditFlags{}
+	flags.Merge(c.flags, c.audit.Flags())
+	c.writeLimit = &k8sflags.WriteLimitFlags{}
+	flags.Merge(c.flags, c.writeLimit.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
 	c.help = flags.Usage(help, c.flags)
 
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+
 	// Default retry to 1s. This is exposed for setting in tests.
 	if c.retryDuration == 0 {
 		c.retryDuration = 1 * time.Second
@@ -159,6 +186,10 @@ func (c *Command) Run(args []string) int {
 	if err := c.flags.Parse(args); err != nil {
 		return 1
 	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 	if len(c.flags.Args()) > 0 {
 		c.UI.Error("Should have no non-flag arguments.")
 		return 1
@@ -186,21 +217,41 @@ func (c *Command) Run(args []string) int {
 		aclReplicationToken = strings.TrimSpace(string(tokenBytes))
 	}
 
+	// The context is ended by the timeout or once the command is
+	// interrupted, e.g. when the job is deleted.
+	ctx, cancelSignal := subcommand.WithSignal(context.Background(), c.sigCh)
+	defer cancelSignal()
 	var cancel context.CancelFunc
-	c.cmdTimeout, cancel = context.WithTimeout(context.Background(), c.flagTimeout)
-	// The context will only ever be intentionally ended by the timeout.
+	c.cmdTimeout, cancel = context.WithTimeout(ctx, c.flagTimeout)
 	defer cancel()
 
 	// Configure our logger
-	level := hclog.LevelFromString(c.flagLogLevel)
-	if level == hclog.NoLevel {
-		c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
+	var err error
+	c.Log, err = c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
 		return 1
 	}
-	c.Log = hclog.New(&hclog.LoggerOptions{
-		Level:  level,
-		Output: os.Stderr,
-	})
+
+	stopAudit, err := c.audit.Start(c.Log.Named("audit"), "consul-k8s-server-acl-init")
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error opening the audit log: %s", err))
+		return 1
+	}
+	defer stopAudit()
+	if err := c.writeLimit.Limit(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.flagMetricsAddr != "" {
+		server, err := metrics.ListenAndServe(c.Log.Named("metrics"), c.flagMetricsAddr, false)
+		if err != nil {
+			c.Log.Error(fmt.Sprintf("Error serving metrics: %s", err))
+			return 1
+		}
+		defer server.Close()
+	}
 
 	// The ClientSet might already be set if we're in a test.
 	if c.clientset == nil {
@@ -254,8 +305,8 @@ func (c *Command) Run(args []string) int {
 	}
 
 	// For all of the next operations we'll need a Consul client.
-	serverAddr := fmt.Sprintf("%s:%d", c.flagServerAddresses[0], c.flagServerPort)
-	consulClient, err := api.NewClient(&api.Config{
+	serverAddr := c.serverAddr(c.flagServerAddresses[0])
+	consulClient, err := consul.NewClient(&api.Config{
 		Address: serverAddr,
 		Scheme:  scheme,
 		Token:   bootstrapToken,
@@ -479,10 +530,13 @@ func (c *Command) configureKubeClient() error {
 }
 
 // untilSucceeds runs op until it returns a nil error.
-// If c.cmdTimeout is cancelled it will exit.
+// If c.cmdTimeout is cancelled, because of the timeout or an interrupt, it
+// will exit.
 func (c *Command) untilSucceeds(opName string, op func() error) error {
 	for {
+		start := time.Now()
 		err := op()
+		metrics.ObserveOperation(opName, start, err)
 		if err == nil {
 			c.Log.Info(fmt.Sprintf("Success: %s", opName))
 			break
@@ -495,6 +549,9 @@ func (c *Command) untilSucceeds(opName string, op func() error) error {
 		case <-time.After(c.retryDuration):
 			continue
 		case <-c.cmdTimeout.Done():
+			if c.cmdTimeout.Err() == context.Canceled {
+				return errors.New("interrupted")
+			}
 			return errors.New("reached command timeout")
 		}
 	}
@@ -3,6 +3,7 @@ package serveraclinit
 import (
 	"errors"
 	"fmt"
+	"net"
 
 	"github.com/hashicorp/consul/api"
 	apiv1 "k8s.io/api/core/v1"
@@ -203,7 +204,7 @@ func (c *Command) createAuthMethodTmpl(authMethodName string) (api.ACLAuthMethod
 		Description: "Kubernetes AuthMethod",
 		Type:        "kubernetes",
 		Config: map[string]interface{}{
-			"Host":              fmt.Sprintf("https://%s:443", kubeSvc.Spec.ClusterIP),
+			"Host":              "https://" + net.JoinHostPort(kubeSvc.Spec.ClusterIP, "443"),
 			"CACert":            string(saSecret.Data["ca.crt"]),
 			"ServiceAccountJWT": string(saSecret.Data["token"]),
 		},
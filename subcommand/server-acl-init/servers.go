@@ -3,18 +3,28 @@ package serveraclinit
 import (
 	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 
+	"github.com/hashicorp/consul-k8s/helper/consul"
 	"github.com/hashicorp/consul/api"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// serverAddr returns the address of the HTTP API of the server at host, which
+// is a DNS name or an IP address. IPv6 addresses are bracketed, whether or
+// not they were in the flags.
+func (c *Command) serverAddr(host string) string {
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.FormatUint(uint64(c.flagServerPort), 10))
+}
+
 // bootstrapServers bootstraps ACLs and ensures each server has an ACL token.
 func (c *Command) bootstrapServers(bootTokenSecretName, scheme string) (string, error) {
 	// Pick the first server address to connect to for bootstrapping and set up connection.
-	firstServerAddr := fmt.Sprintf("%s:%d", c.flagServerAddresses[0], c.flagServerPort)
-	consulClient, err := api.NewClient(&api.Config{
+	firstServerAddr := c.serverAddr(c.flagServerAddresses[0])
+	consulClient, err := consul.NewClient(&api.Config{
 		Address: firstServerAddr,
 		Scheme:  scheme,
 		TLSConfig: api.TLSConfig{
@@ -79,7 +89,7 @@ func (c *Command) bootstrapServers(bootTokenSecretName, scheme string) (string,
 
 	// Override our original client with a new one that has the bootstrap token
 	// set.
-	consulClient, err = api.NewClient(&api.Config{
+	consulClient, err = consul.NewClient(&api.Config{
 		Address: firstServerAddr,
 		Scheme:  scheme,
 		Token:   string(bootstrapToken),
@@ -113,8 +123,8 @@ func (c *Command) setServerTokens(consulClient *api.Client, bootstrapToken, sche
 
 		// We create a new client for each server because we need to call each
 		// server specifically.
-		serverClient, err := api.NewClient(&api.Config{
-			Address: fmt.Sprintf("%s:%d", host, c.flagServerPort),
+		serverClient, err := consul.NewClient(&api.Config{
+			Address: c.serverAddr(host),
 			Scheme:  scheme,
 			Token:   bootstrapToken,
 			TLSConfig: api.TLSConfig{
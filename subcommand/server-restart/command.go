@@ -0,0 +1,215 @@
+package serverrestart
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command restarts the Consul servers one at a time when their config or
+// certificates change.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+
+	flagK8sNamespace      string
+	flagStatefulSet       string
+	flagSecrets           []string
+	flagConfigMaps        []string
+	flagPollInterval      time.Duration
+	flagHealthTimeout     time.Duration
+	flagStabilizationTime time.Duration
+
+	clientset    kubernetes.Interface
+	consulClient *api.Client
+
+	// retryDuration is how often the health of the servers and the
+	// recreated pods are checked. It's exposed for setting in tests.
+	retryDuration time.Duration
+
+	// sigCh receives a signal when the command should stop.
+	sigCh chan os.Signal
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of the Kubernetes namespace of the servers.")
+	c.flags.StringVar(&c.flagStatefulSet, "statefulset", "",
+		"Name of the StatefulSet of the servers.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagSecrets), "secret",
+		"Name of a secret with config or certificates of the servers, e.g. the server certificate. "+
+			"May be specified multiple times.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagConfigMaps), "configmap",
+		"Name of a ConfigMap with config of the servers. May be specified multiple times.")
+	c.flags.DurationVar(&c.flagPollInterval, "poll-interval", 30*time.Second,
+		"How often the secrets and ConfigMaps are checked for changes.")
+	c.flags.DurationVar(&c.flagHealthTimeout, "health-timeout", 10*time.Minute,
+		"How long to wait for the servers to be healthy, and for a restarted pod to be ready, "+
+			"before aborting the rollout until the next check.")
+	c.flags.DurationVar(&c.flagStabilizationTime, "stabilization-time", 10*time.Second,
+		"How long all the servers must have been healthy before the next one is restarted. "+
+			"Defaults to the server stabilization time of autopilot.")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	if c.retryDuration == 0 {
+		c.retryDuration = 1 * time.Second
+	}
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.consulClient == nil {
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
+		c.consulClient, err = consul.NewClient(cfg)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	ctx, cancel := subcommand.WithSignal(context.Background(), c.sigCh)
+	defer cancel()
+
+	for {
+		// A failed rollout is retried at the next check. The servers that
+		// were already restarted are skipped then.
+		if err := c.reconcile(ctx, logger); err != nil {
+			logger.Error("Error restarting the servers", "statefulset", c.flagStatefulSet, "err", err)
+		}
+		select {
+		case <-time.After(c.flagPollInterval):
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagK8sNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagStatefulSet == "" {
+		return errors.New("-statefulset must be set")
+	}
+	if len(c.flagSecrets) == 0 && len(c.flagConfigMaps) == 0 {
+		return errors.New("at least one -secret or -configmap must be set")
+	}
+	if c.flagPollInterval <= 0 {
+		return errors.New("-poll-interval must be positive")
+	}
+	if c.flagHealthTimeout <= 0 {
+		return errors.New("-health-timeout must be positive")
+	}
+	if c.flagStabilizationTime < 0 {
+		return errors.New("-stabilization-time must not be negative")
+	}
+	return nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Restart the Consul servers one at a time when their config changes"
+const help = `
+Usage: consul-k8s server-restart [options]
+
+  Watches the secrets -secret and ConfigMaps -configmap with the config and
+  certificates of the Consul servers, and restarts the server pods of the
+  StatefulSet -statefulset when their data changes, since the servers only
+  read them at startup. Updating the StatefulSet to restart them instead
+  doesn't wait for the servers to rejoin the cluster, which can lose quorum.
+
+  The pods are deleted one at a time, from the highest ordinal to the lowest
+  and the leader last. Before each deletion, autopilot must report all the
+  servers as healthy voters, stable for -stabilization-time, with the
+  failure tolerance expected for the number of replicas. After it, the
+  recreated pod must be ready and the servers healthy again. If this takes
+  longer than -health-timeout, the rollout stops and resumes at the next
+  check with the servers that weren't restarted. The servers are only
+  restarted while they're healthy, so a cluster that lost quorum must be
+  recovered by hand.
+
+  The checksum of the config is recorded in the annotation
+  consul.hashicorp.com/config-checksum of the StatefulSet and of each
+  restarted pod. The first time, the checksum is recorded without restarting
+  the servers.
+
+  The health of the servers is read from the autopilot API of -http-addr,
+  which needs a token with operator:read if ACLs are enabled.
+`
@@ -0,0 +1,377 @@
+package serverrestart
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const ns = "default"
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{"foo"},
+			ExpErr: "Should have no non-flag arguments.",
+		},
+		{
+			Flags:  []string{},
+			ExpErr: "-k8s-namespace must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace=default"},
+			ExpErr: "-statefulset must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace=default", "-statefulset=consul-server"},
+			ExpErr: "at least one -secret or -configmap must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace=default", "-statefulset=consul-server", "-secret=consul-server-cert", "-poll-interval=0s"},
+			ExpErr: "-poll-interval must be positive",
+		},
+		{
+			Flags:  []string{"-k8s-namespace=default", "-statefulset=consul-server", "-secret=consul-server-cert", "-health-timeout=0s"},
+			ExpErr: "-health-timeout must be positive",
+		},
+		{
+			Flags:  []string{"-k8s-namespace=default", "-statefulset=consul-server", "-configmap=consul-server-config", "-stabilization-time=-1s"},
+			ExpErr: "-stabilization-time must not be negative",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.Flags))
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+// Test that the first check records the checksum without restarting the
+// servers, that a change of the certificate restarts them one at a time
+// with the leader last, and that they aren't restarted again afterwards.
+func TestReconcile_RollingRestart(t *testing.T) {
+	t.Parallel()
+	k8s, deleted := newFakeK8s(3)
+	consul := &fakeConsul{servers: 3, leader: "consul-server-1", healthy: true}
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	cmd := newCommand(t, k8s, server.URL)
+
+	require.NoError(t, cmd.reconcile(context.Background(), hclog.NewNullLogger()))
+	checksum := stsChecksum(t, k8s)
+	require.NotEmpty(t, checksum)
+	require.Empty(t, deleted())
+
+	// The secret is changed.
+	secret, err := k8s.CoreV1().Secrets(ns).Get("consul-server-cert", metav1.GetOptions{})
+	require.NoError(t, err)
+	secret.Data["tls.crt"] = []byte("new-cert")
+	_, err = k8s.CoreV1().Secrets(ns).Update(secret)
+	require.NoError(t, err)
+
+	require.NoError(t, cmd.reconcile(context.Background(), hclog.NewNullLogger()))
+	require.Equal(t, []string{"consul-server-2", "consul-server-0", "consul-server-1"}, deleted())
+	newChecksum := stsChecksum(t, k8s)
+	require.NotEqual(t, checksum, newChecksum)
+	pods, err := k8s.CoreV1().Pods(ns).List(metav1.ListOptions{LabelSelector: "component=server"})
+	require.NoError(t, err)
+	require.Len(t, pods.Items, 3)
+	for _, pod := range pods.Items {
+		require.Equal(t, newChecksum, pod.Annotations[checksumAnnotation], pod.Name)
+	}
+
+	require.NoError(t, cmd.reconcile(context.Background(), hclog.NewNullLogger()))
+	require.Len(t, deleted(), 3)
+}
+
+// Test that the servers aren't restarted while the cluster is unhealthy,
+// and that an interrupted rollout resumes with the servers that weren't
+// restarted.
+func TestReconcile_Unhealthy(t *testing.T) {
+	t.Parallel()
+	k8s, deleted := newFakeK8s(3)
+	consul := &fakeConsul{servers: 3, leader: "consul-server-0", healthy: false}
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	cmd := newCommand(t, k8s, server.URL)
+
+	sts, err := k8s.AppsV1().StatefulSets(ns).Get("consul-server", metav1.GetOptions{})
+	require.NoError(t, err)
+	sts.Annotations = map[string]string{checksumAnnotation: "old"}
+	_, err = k8s.AppsV1().StatefulSets(ns).Update(sts)
+	require.NoError(t, err)
+	want, err := cmd.checksum()
+	require.NoError(t, err)
+	// consul-server-2 was restarted by a previous rollout.
+	pod, err := k8s.CoreV1().Pods(ns).Get("consul-server-2", metav1.GetOptions{})
+	require.NoError(t, err)
+	pod.Annotations = map[string]string{checksumAnnotation: want}
+	_, err = k8s.CoreV1().Pods(ns).Update(pod)
+	require.NoError(t, err)
+
+	err = cmd.reconcile(context.Background(), hclog.NewNullLogger())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out after 100ms waiting for the servers to be healthy: "+
+		"Unexpected response code: 429")
+	require.Empty(t, deleted())
+	require.Equal(t, "old", stsChecksum(t, k8s))
+
+	consul.setHealthy(true)
+	require.NoError(t, cmd.reconcile(context.Background(), hclog.NewNullLogger()))
+	require.Equal(t, []string{"consul-server-1", "consul-server-0"}, deleted())
+	require.Equal(t, want, stsChecksum(t, k8s))
+}
+
+func TestHealthy(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	server := func(name string, healthy, voter bool, stable time.Duration) api.ServerHealth {
+		return api.ServerHealth{Name: name, Healthy: healthy, Voter: voter, StableSince: now.Add(-stable)}
+	}
+	cases := map[string]struct {
+		reply    api.OperatorHealthReply
+		replicas int
+		expErr   string
+	}{
+		"healthy": {
+			reply: api.OperatorHealthReply{Healthy: true, FailureTolerance: 1, Servers: []api.ServerHealth{
+				server("consul-server-0", true, true, time.Minute),
+				server("consul-server-1", true, true, time.Minute),
+				server("consul-server-2", true, true, time.Minute),
+			}},
+			replicas: 3,
+		},
+		"single server": {
+			reply: api.OperatorHealthReply{Healthy: true, Servers: []api.ServerHealth{
+				server("consul-server-0", true, true, time.Minute),
+			}},
+			replicas: 1,
+		},
+		"unhealthy cluster": {
+			reply:    api.OperatorHealthReply{Healthy: false},
+			replicas: 3,
+			expErr:   "autopilot reports the cluster as unhealthy",
+		},
+		"unhealthy server": {
+			reply: api.OperatorHealthReply{Healthy: true, FailureTolerance: 1, Servers: []api.ServerHealth{
+				server("consul-server-0", true, true, time.Minute),
+				server("consul-server-1", false, true, time.Minute),
+			}},
+			replicas: 3,
+			expErr:   `server "consul-server-1" is unhealthy`,
+		},
+		"not stable": {
+			reply: api.OperatorHealthReply{Healthy: true, FailureTolerance: 1, Servers: []api.ServerHealth{
+				server("consul-server-0", true, true, time.Minute),
+				server("consul-server-1", true, true, 5*time.Second),
+			}},
+			replicas: 3,
+			expErr:   `server "consul-server-1" has only been stable for 5s`,
+		},
+		"missing voter": {
+			reply: api.OperatorHealthReply{Healthy: true, Servers: []api.ServerHealth{
+				server("consul-server-0", true, true, time.Minute),
+				server("consul-server-1", true, true, time.Minute),
+				server("consul-server-2", true, false, 0),
+			}},
+			replicas: 3,
+			expErr:   "2 of 3 servers are voters",
+		},
+		"failure tolerance": {
+			reply: api.OperatorHealthReply{Healthy: true, FailureTolerance: 1, Servers: []api.ServerHealth{
+				server("consul-server-0", true, true, time.Minute),
+				server("consul-server-1", true, true, time.Minute),
+				server("consul-server-2", true, true, time.Minute),
+				server("consul-server-3", true, true, time.Minute),
+				server("consul-server-4", true, true, time.Minute),
+			}},
+			replicas: 5,
+			expErr:   "the failure tolerance is 1, expected 2",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := healthy(&c.reply, c.replicas, 10*time.Second, now)
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRestartOrder(t *testing.T) {
+	t.Parallel()
+	var pods []corev1.Pod
+	for _, name := range []string{"consul-server-0", "consul-server-10", "consul-server-2", "consul-server-1"} {
+		pods = append(pods, corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	var names []string
+	for _, pod := range restartOrder(pods, "consul-server-2") {
+		names = append(names, pod.Name)
+	}
+	require.Equal(t, []string{"consul-server-10", "consul-server-1", "consul-server-0", "consul-server-2"}, names)
+}
+
+// newCommand returns a command initialized with the flags of the tests.
+func newCommand(t *testing.T, k8s *fake.Clientset, consulAddr string) *Command {
+	consulClient, err := api.NewClient(&api.Config{Address: consulAddr})
+	require.NoError(t, err)
+	cmd := &Command{
+		UI:            cli.NewMockUi(),
+		clientset:     k8s,
+		consulClient:  consulClient,
+		retryDuration: 10 * time.Millisecond,
+	}
+	cmd.once.Do(cmd.init)
+	require.NoError(t, cmd.validateFlags([]string{
+		"-k8s-namespace", ns,
+		"-statefulset", "consul-server",
+		"-secret", "consul-server-cert",
+		"-configmap", "consul-server-config",
+		"-health-timeout", "100ms",
+		"-stabilization-time", "0s",
+	}))
+	return cmd
+}
+
+// newFakeK8s returns a clientset with the StatefulSet of replicas servers,
+// their ready pods and their config. Like the StatefulSet controller, it
+// recreates the deleted pods with a new UID. It also returns a function
+// returning the names of the deleted pods in order.
+func newFakeK8s(replicas int32) (*fake.Clientset, func() []string) {
+	labels := map[string]string{"app": "consul", "component": "server"}
+	objects := []runtime.Object{
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-server", Namespace: ns},
+			Spec: appsv1.StatefulSetSpec{
+				Replicas: &replicas,
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-server-cert", Namespace: ns},
+			Data:       map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-server-config", Namespace: ns},
+			Data:       map[string]string{"server.json": `{"bootstrap_expect": 3}`},
+		},
+		// A client pod that must not be restarted.
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-abcde", Namespace: ns,
+				Labels: map[string]string{"app": "consul", "component": "client"}},
+		},
+	}
+	for i := int32(0); i < replicas; i++ {
+		objects = append(objects, serverPod(fmt.Sprintf("consul-server-%d", i), labels, 1))
+	}
+	k8s := fake.NewSimpleClientset(objects...)
+
+	var lock sync.Mutex
+	var deleted []string
+	generation := 1
+	k8s.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.DeleteAction).GetName()
+		lock.Lock()
+		defer lock.Unlock()
+		deleted = append(deleted, name)
+		generation++
+		// The pod is created once the default reactor deleted it, since the
+		// clientset is locked until then.
+		pod := serverPod(name, labels, generation)
+		go k8s.CoreV1().Pods(ns).Create(pod)
+		return false, nil, nil
+	})
+	return k8s, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), deleted...)
+	}
+}
+
+// serverPod returns the ready server pod name whose UID depends on
+// generation.
+func serverPod(name string, labels map[string]string, generation int) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    labels,
+			UID:       types.UID(fmt.Sprintf("%s-%d", name, generation)),
+		},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+	}
+}
+
+func stsChecksum(t *testing.T, k8s *fake.Clientset) string {
+	sts, err := k8s.AppsV1().StatefulSets(ns).Get("consul-server", metav1.GetOptions{})
+	require.NoError(t, err)
+	return sts.Annotations[checksumAnnotation]
+}
+
+// fakeConsul serves the autopilot health of servers named after the server
+// pods.
+type fakeConsul struct {
+	servers int
+	leader  string
+
+	lock    sync.Mutex
+	healthy bool
+}
+
+func (f *fakeConsul) setHealthy(healthy bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.healthy = healthy
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/operator/autopilot/health" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	reply := api.OperatorHealthReply{Healthy: f.healthy, FailureTolerance: (f.servers - 1) / 2}
+	for i := 0; i < f.servers; i++ {
+		name := fmt.Sprintf("consul-server-%d", i)
+		reply.Servers = append(reply.Servers, api.ServerHealth{
+			Name:        name,
+			Leader:      name == f.leader,
+			Healthy:     f.healthy,
+			Voter:       true,
+			StableSince: time.Now().Add(-time.Minute),
+		})
+	}
+	// Like Consul, the health is returned with a 429 if it's unhealthy.
+	if !f.healthy {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	json.NewEncoder(w).Encode(reply)
+}
@@ -0,0 +1,315 @@
+package serverrestart
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// checksumAnnotation is the checksum of the config the servers run with. It's
+// set on the StatefulSet once all the servers are restarted, and on each pod
+// once it's restarted so that an interrupted rollout resumes where it
+// stopped.
+const checksumAnnotation = "consul.hashicorp.com/config-checksum"
+
+// checksum returns the SHA-256 of the data of the secrets and config maps
+// of -secret and -configmap.
+func (c *Command) checksum() (string, error) {
+	h := sha256.New()
+	secrets := append([]string(nil), c.flagSecrets...)
+	sort.Strings(secrets)
+	for _, name := range secrets {
+		secret, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("getting secret %q: %s", name, err)
+		}
+		writeData(h, "secret/"+name, secret.Data)
+	}
+	configMaps := append([]string(nil), c.flagConfigMaps...)
+	sort.Strings(configMaps)
+	for _, name := range configMaps {
+		configMap, err := c.clientset.CoreV1().ConfigMaps(c.flagK8sNamespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("getting ConfigMap %q: %s", name, err)
+		}
+		data := make(map[string][]byte, len(configMap.Data))
+		for k, v := range configMap.Data {
+			data[k] = []byte(v)
+		}
+		writeData(h, "configmap/"+name, data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeData writes the data of the object id to w in the order of its keys.
+// The length of each value is written before it so that moving bytes between
+// values changes the checksum.
+func writeData(w io.Writer, id string, data map[string][]byte) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintf(w, "%s\n", id)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s %d\n", k, len(data[k]))
+		w.Write(data[k])
+	}
+}
+
+// reconcile restarts the servers if the checksum of their config differs
+// from the one of the StatefulSet, and records the new checksum once they're
+// all restarted. The first time, the checksum is recorded without restarting
+// the servers since they run with the current config.
+func (c *Command) reconcile(ctx context.Context, logger hclog.Logger) error {
+	want, err := c.checksum()
+	if err != nil {
+		return err
+	}
+	statefulSets := c.clientset.AppsV1().StatefulSets(c.flagK8sNamespace)
+	sts, err := statefulSets.Get(c.flagStatefulSet, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting StatefulSet %q: %s", c.flagStatefulSet, err)
+	}
+	have, ok := sts.Annotations[checksumAnnotation]
+	if ok && have == want {
+		return nil
+	}
+	if ok {
+		logger.Info("Server config changed, restarting the servers", "statefulset", c.flagStatefulSet, "checksum", want)
+		if err := c.restart(ctx, logger, sts, want); err != nil {
+			return err
+		}
+	}
+
+	// The StatefulSet is read again since it may have been updated during
+	// the rollout.
+	sts, err = statefulSets.Get(c.flagStatefulSet, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting StatefulSet %q: %s", c.flagStatefulSet, err)
+	}
+	if sts.Annotations == nil {
+		sts.Annotations = make(map[string]string)
+	}
+	sts.Annotations[checksumAnnotation] = want
+	if _, err := statefulSets.Update(sts); err != nil {
+		return fmt.Errorf("updating StatefulSet %q: %s", c.flagStatefulSet, err)
+	}
+	if ok {
+		logger.Info("Restarted all the servers", "statefulset", c.flagStatefulSet, "checksum", want)
+	} else {
+		logger.Info("Recorded the checksum of the server config", "statefulset", c.flagStatefulSet, "checksum", want)
+	}
+	return nil
+}
+
+// restart deletes the server pods that don't run with the config of
+// checksum one at a time, so that the StatefulSet recreates them. Before each
+// deletion, the cluster must be healthy and tolerate the loss of a server,
+// and after it, the recreated pod must be ready and the cluster healthy
+// again. The leader is restarted last so that there's a single leader
+// election.
+func (c *Command) restart(ctx context.Context, logger hclog.Logger, sts *appsv1.StatefulSet, checksum string) error {
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		return fmt.Errorf("parsing the selector of StatefulSet %q: %s", sts.Name, err)
+	}
+	list, err := c.clientset.CoreV1().Pods(c.flagK8sNamespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return fmt.Errorf("listing the server pods: %s", err)
+	}
+	replicas := 1
+	if sts.Spec.Replicas != nil {
+		replicas = int(*sts.Spec.Replicas)
+	}
+	if replicas < 3 {
+		logger.Warn("The servers can't tolerate the restart of a server without losing quorum", "replicas", replicas)
+	}
+
+	// The leader is only looked up once since it only changes if a server
+	// fails during the rollout, which then only costs another election.
+	health, err := c.waitHealthy(ctx, replicas)
+	if err != nil {
+		return err
+	}
+	pods := restartOrder(list.Items, leaderName(health))
+	for _, pod := range pods {
+		if pod.Annotations[checksumAnnotation] == checksum {
+			logger.Debug("Server already restarted", "pod", pod.Name)
+			continue
+		}
+		if _, err := c.waitHealthy(ctx, replicas); err != nil {
+			return err
+		}
+
+		logger.Info("Restarting server", "pod", pod.Name)
+		err := c.clientset.CoreV1().Pods(c.flagK8sNamespace).Delete(pod.Name, &metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &pod.UID},
+		})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("deleting pod %q: %s", pod.Name, err)
+		}
+		if err := c.waitRecreated(ctx, pod.Name, pod.UID, checksum); err != nil {
+			return err
+		}
+		if _, err := c.waitHealthy(ctx, replicas); err != nil {
+			return fmt.Errorf("after restarting %q: %s", pod.Name, err)
+		}
+		logger.Info("Restarted server", "pod", pod.Name)
+	}
+	return nil
+}
+
+// waitRecreated waits until the pod name with a UID other than uid is ready,
+// and annotates it with checksum.
+func (c *Command) waitRecreated(ctx context.Context, name string, uid types.UID, checksum string) error {
+	pods := c.clientset.CoreV1().Pods(c.flagK8sNamespace)
+	return c.poll(ctx, fmt.Sprintf("pod %q to be recreated and ready", name), func() error {
+		pod, err := pods.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pod.UID == uid {
+			return fmt.Errorf("pod %q is not deleted yet", name)
+		}
+		if !ready(*pod) {
+			return fmt.Errorf("pod %q is not ready", name)
+		}
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[checksumAnnotation] = checksum
+		_, err = pods.Update(pod)
+		return err
+	})
+}
+
+// waitHealthy waits until autopilot reports the cluster as healthy, and
+// returns its health.
+func (c *Command) waitHealthy(ctx context.Context, replicas int) (*api.OperatorHealthReply, error) {
+	var health *api.OperatorHealthReply
+	err := c.poll(ctx, "the servers to be healthy", func() error {
+		reply, err := c.consulClient.Operator().AutopilotServerHealth(nil)
+		if err != nil {
+			return err
+		}
+		if err := healthy(reply, replicas, c.flagStabilizationTime, time.Now()); err != nil {
+			return err
+		}
+		health = reply
+		return nil
+	})
+	return health, err
+}
+
+// healthy returns an error if the cluster of reply doesn't have replicas
+// healthy voters that have been stable for stabilization, or can't
+// tolerate the failures expected for its size. Servers that are still
+// joining count as unhealthy.
+func healthy(reply *api.OperatorHealthReply, replicas int, stabilization time.Duration, now time.Time) error {
+	if !reply.Healthy {
+		return fmt.Errorf("autopilot reports the cluster as unhealthy")
+	}
+	voters := 0
+	for _, server := range reply.Servers {
+		if !server.Healthy {
+			return fmt.Errorf("server %q is unhealthy", server.Name)
+		}
+		if !server.Voter {
+			continue
+		}
+		if stable := now.Sub(server.StableSince); stable < stabilization {
+			return fmt.Errorf("server %q has only been stable for %s", server.Name, stable.Round(time.Second))
+		}
+		voters++
+	}
+	if voters < replicas {
+		return fmt.Errorf("%d of %d servers are voters", voters, replicas)
+	}
+	if tolerance := (replicas - 1) / 2; reply.FailureTolerance < tolerance {
+		return fmt.Errorf("the failure tolerance is %d, expected %d", reply.FailureTolerance, tolerance)
+	}
+	return nil
+}
+
+// poll calls f every retryDuration until it returns nil, the command is
+// interrupted or -health-timeout elapses. The last error of f is returned
+// on timeout.
+func (c *Command) poll(ctx context.Context, what string, f func() error) error {
+	timeout := time.After(c.flagHealthTimeout)
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-time.After(c.retryDuration):
+		case <-timeout:
+			return fmt.Errorf("timed out after %s waiting for %s: %s", c.flagHealthTimeout, what, err)
+		case <-ctx.Done():
+			return fmt.Errorf("interrupted while waiting for %s", what)
+		}
+	}
+}
+
+// leaderName returns the node name of the leader of health, or "" if there's
+// none.
+func leaderName(health *api.OperatorHealthReply) string {
+	for _, server := range health.Servers {
+		if server.Leader {
+			return server.Name
+		}
+	}
+	return ""
+}
+
+// restartOrder returns pods from the highest ordinal to the lowest like a
+// StatefulSet rollout, except for the pod of the leader which comes last.
+// The node names of the servers are the names of their pods.
+func restartOrder(pods []corev1.Pod, leader string) []corev1.Pod {
+	sorted := append([]corev1.Pod(nil), pods...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if isLeader := sorted[i].Name == leader; isLeader != (sorted[j].Name == leader) {
+			return !isLeader
+		}
+		return ordinal(sorted[i].Name) > ordinal(sorted[j].Name)
+	})
+	return sorted
+}
+
+// ordinal returns the ordinal of the StatefulSet pod name, or -1 if it has
+// none.
+func ordinal(name string) int {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return -1
+	}
+	n, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func ready(pod corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -1,12 +1,20 @@
 package serviceaddress
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/cenkalti/backoff"
@@ -16,6 +24,7 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
@@ -23,17 +32,34 @@ import (
 type Command struct {
 	UI cli.Ui
 
-	flags    *flag.FlagSet
-	k8sFlags *k8sflags.K8SFlags
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8sFlags   *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
 
 	flagNamespace   string
 	flagServiceName string
 	flagOutputFile  string
+	flagConfigMap   string
+	flagSecret      string
+	flagPortName    string
+	flagWatch       bool
+	flagInterval    time.Duration
+	flagResolve     bool
+	flagAll         bool
+	flagFormat      string
 
 	retryDuration time.Duration
 	k8sClient     kubernetes.Interface
-	once          sync.Once
-	help          string
+	// lookupHost resolves the hostnames of -resolve-hostname. It's
+	// net.LookupHost if nil.
+	lookupHost func(host string) ([]string, error)
+
+	once sync.Once
+	help string
+
+	// sigCh receives a signal when the command should stop.
+	sigCh chan os.Signal
 }
 
 func (c *Command) init() {
@@ -44,20 +70,58 @@ func (c *Command) init() {
 		"Name of the service")
 	c.flags.StringVar(&c.flagOutputFile, "output-file", "",
 		"Path to file to write load balancer address")
+	c.flags.StringVar(&c.flagConfigMap, "output-configmap", "",
+		fmt.Sprintf("Name of a ConfigMap in -k8s-namespace to write the address and port to, under the keys %q and %q.",
+			addressKey, portKey))
+	c.flags.StringVar(&c.flagSecret, "output-secret", "",
+		fmt.Sprintf("Name of a Secret in -k8s-namespace to write the address and port to, under the keys %q and %q.",
+			addressKey, portKey))
+	c.flags.StringVar(&c.flagPortName, "port-name", "",
+		"Name of the service port to write to -output-configmap and -output-secret. Defaults to the first port.")
+	c.flags.BoolVar(&c.flagWatch, "watch", false,
+		"If true, keep running after writing the address and update the outputs whenever the address "+
+			"or port of the service changes.")
+	c.flags.DurationVar(&c.flagInterval, "watch-interval", 30*time.Second,
+		"Interval at which the service is polled in -watch mode.")
+	c.flags.BoolVar(&c.flagResolve, "resolve-hostname", false,
+		"If true, the hostnames of the load balancer are resolved to their IPs. The resolution is "+
+			"retried until it returns an IP, e.g. while the DNS record propagates.")
+	c.flags.BoolVar(&c.flagAll, "all-addresses", false,
+		"If true, all the ingress addresses of the load balancer are written instead of the first one.")
+	c.flags.StringVar(&c.flagFormat, "output-format", formatPlain,
+		fmt.Sprintf("Format of the addresses written: %q for one address per line, %q for a JSON array "+
+			"or %q for comma-separated addresses.", formatPlain, formatJSON, formatCSV))
 
 	c.k8sFlags = &k8sflags.K8SFlags{}
 	flags.Merge(c.flags, c.k8sFlags.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
 	c.help = flags.Usage(help, c.flags)
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
 }
 
 // Run waits until a Kubernetes service has an ingress address and then writes
-// it to an output file.
+// it to the outputs. With -watch, it then keeps polling the service and
+// rewrites the outputs whenever its address or port changes.
 func (c *Command) Run(args []string) int {
 	c.once.Do(c.init)
 	if err := c.validateFlags(args); err != nil {
 		c.UI.Error(err.Error())
 		return 1
 	}
+	log, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	if c.k8sClient == nil {
 		config, err := subcommand.K8SConfig(c.k8sFlags.KubeConfig())
@@ -74,66 +138,307 @@ func (c *Command) Run(args []string) int {
 	if c.retryDuration == 0 {
 		c.retryDuration = 1 * time.Second
 	}
-	log := hclog.New(&hclog.LoggerOptions{
-		Level:  hclog.Info,
-		Output: os.Stderr,
-	})
 
 	// Run until we get an address from the service.
-	var address string
+	ctx, cancel := subcommand.WithSignal(context.Background(), c.sigCh)
+	defer cancel()
+	var addr serviceAddr
 	var unretryableErr error
-	backoff.Retry(withErrLogger(log, func() error {
-		svc, err := c.k8sClient.CoreV1().Services(c.flagNamespace).Get(c.flagServiceName, metav1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("getting service %s: %s", c.flagServiceName, err)
-		}
-		switch svc.Spec.Type {
-		case v1.ServiceTypeClusterIP:
-			address = svc.Spec.ClusterIP
-			return nil
-		case v1.ServiceTypeNodePort:
-			unretryableErr = errors.New("services of type NodePort are not supported")
-			return nil
-		case v1.ServiceTypeExternalName:
-			unretryableErr = errors.New("services of type ExternalName are not supported")
-			return nil
-		case v1.ServiceTypeLoadBalancer:
-			for _, ingr := range svc.Status.LoadBalancer.Ingress {
-				if ingr.IP != "" {
-					address = ingr.IP
-					return nil
-				} else if ingr.Hostname != "" {
-					address = ingr.Hostname
-					return nil
-				}
-			}
-			return fmt.Errorf("service %s has no ingress IP or hostname", c.flagServiceName)
-		default:
-			unretryableErr = fmt.Errorf("unknown service type %q", svc.Spec.Type)
+	err = backoff.Retry(withErrLogger(log, func() error {
+		var err error
+		addr, err = c.serviceAddress()
+		if permanent, ok := err.(*backoff.PermanentError); ok {
+			unretryableErr = permanent.Err
 			return nil
 		}
-	}), backoff.NewConstantBackOff(c.retryDuration))
+		return err
+	}), backoff.WithContext(backoff.NewConstantBackOff(c.retryDuration), ctx))
 
+	if ctx.Err() != nil {
+		c.UI.Error("Interrupted waiting for the service address")
+		return 1
+	}
 	if unretryableErr != nil {
 		c.UI.Error(fmt.Sprintf("Unable to get service address: %s", unretryableErr.Error()))
 		return 1
 	}
+	if err := c.writeOutputs(addr); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	log.Info("address written successfully", "address", addr.address, "port", addr.port)
+	if !c.flagWatch {
+		return 0
+	}
 
-	// Write the address to file.
-	err := ioutil.WriteFile(c.flagOutputFile, []byte(address), 0600)
+	// In watch mode, the outputs are only rewritten when the address
+	// changes. Errors are logged and retried at the next poll since the
+	// outputs still have the last address.
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("shutting down")
+			return 0
+		case <-time.After(c.flagInterval):
+		}
+
+		newAddr, err := c.serviceAddress()
+		if permanent, ok := err.(*backoff.PermanentError); ok {
+			err = permanent.Err
+		}
+		if err != nil {
+			log.Error("unable to get service address", "err", err)
+			continue
+		}
+		if newAddr == addr {
+			continue
+		}
+		if err := c.writeOutputs(newAddr); err != nil {
+			log.Error(err.Error())
+			continue
+		}
+		log.Info("address updated", "address", newAddr.address, "port", newAddr.port,
+			"previous-address", addr.address, "previous-port", addr.port)
+		addr = newAddr
+	}
+}
+
+// serviceAddr is the address of the service and the port of -port-name.
+// The address is formatted with -output-format if there are several.
+type serviceAddr struct {
+	address string
+	port    int32
+}
+
+// serviceAddress returns the address of the service. The error is a
+// *backoff.PermanentError if the type of the service isn't supported.
+func (c *Command) serviceAddress() (serviceAddr, error) {
+	svc, err := c.k8sClient.CoreV1().Services(c.flagNamespace).Get(c.flagServiceName, metav1.GetOptions{})
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("Unable to write address to file: %s", err))
-		return 1
+		return serviceAddr{}, fmt.Errorf("getting service %s: %s", c.flagServiceName, err)
+	}
+	var addresses []string
+	switch svc.Spec.Type {
+	case v1.ServiceTypeClusterIP:
+		addresses = []string{svc.Spec.ClusterIP}
+	case v1.ServiceTypeNodePort:
+		return serviceAddr{}, backoff.Permanent(errors.New("services of type NodePort are not supported"))
+	case v1.ServiceTypeExternalName:
+		return serviceAddr{}, backoff.Permanent(errors.New("services of type ExternalName are not supported"))
+	case v1.ServiceTypeLoadBalancer:
+		addresses, err = c.ingressAddresses(svc.Status.LoadBalancer.Ingress)
+		if err != nil {
+			return serviceAddr{}, err
+		}
+		if len(addresses) == 0 {
+			return serviceAddr{}, fmt.Errorf("service %s has no ingress IP or hostname", c.flagServiceName)
+		}
+	default:
+		return serviceAddr{}, backoff.Permanent(fmt.Errorf("unknown service type %q", svc.Spec.Type))
+	}
+
+	if !c.flagAll {
+		addresses = addresses[:1]
+	}
+	addr := serviceAddr{address: c.formatAddresses(addresses)}
+	for _, port := range svc.Spec.Ports {
+		if c.flagPortName == "" || port.Name == c.flagPortName {
+			addr.port = port.Port
+			break
+		}
+	}
+	if c.flagPortName != "" && addr.port == 0 {
+		return serviceAddr{}, fmt.Errorf("service %s has no port named %q", c.flagServiceName, c.flagPortName)
 	}
+	return addr, nil
+}
 
-	c.UI.Info(fmt.Sprintf("Address %q written to %s successfully", address, c.flagOutputFile))
-	return 0
+// ingressAddresses returns the addresses of the ingresses, without
+// duplicates. The hostnames are resolved with -resolve-hostname, in which
+// case the error is that of the first hostname that can't be resolved.
+func (c *Command) ingressAddresses(ingresses []v1.LoadBalancerIngress) ([]string, error) {
+	var addresses []string
+	seen := make(map[string]bool)
+	add := func(address string) {
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+	for _, ingr := range ingresses {
+		switch {
+		case ingr.IP != "":
+			add(ingr.IP)
+		case ingr.Hostname != "" && c.flagResolve:
+			ips, err := c.resolve(ingr.Hostname)
+			if err != nil {
+				return nil, err
+			}
+			for _, ip := range ips {
+				add(ip)
+			}
+		case ingr.Hostname != "":
+			add(ingr.Hostname)
+		default:
+			continue
+		}
+		if !c.flagAll {
+			break
+		}
+	}
+	return addresses, nil
+}
+
+// resolve returns the IPs of host, sorted so that the outputs don't change
+// when the DNS server rotates them. It returns an error if host has no IP
+// yet.
+func (c *Command) resolve(host string) ([]string, error) {
+	lookupHost := c.lookupHost
+	if lookupHost == nil {
+		lookupHost = net.LookupHost
+	}
+	ips, err := lookupHost(host)
+	if err != nil {
+		return nil, fmt.Errorf("resolving hostname %s: %s", host, err)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("resolving hostname %s: no IP", host)
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
+
+// formatAddresses returns addresses in the -output-format format.
+func (c *Command) formatAddresses(addresses []string) string {
+	switch c.flagFormat {
+	case formatJSON:
+		// Marshalling a slice of strings can't fail.
+		b, _ := json.Marshal(addresses)
+		return string(b)
+	case formatCSV:
+		return strings.Join(addresses, ",")
+	default:
+		return strings.Join(addresses, "\n")
+	}
+}
+
+// writeOutputs writes addr to the outputs that are set.
+func (c *Command) writeOutputs(addr serviceAddr) error {
+	if c.flagOutputFile != "" {
+		if err := ioutil.WriteFile(c.flagOutputFile, []byte(addr.address), 0600); err != nil {
+			return fmt.Errorf("Unable to write address to file: %s", err)
+		}
+	}
+	data := map[string]string{addressKey: addr.address}
+	if addr.port != 0 {
+		data[portKey] = strconv.Itoa(int(addr.port))
+	}
+	if c.flagConfigMap != "" {
+		if err := c.writeConfigMap(data); err != nil {
+			return fmt.Errorf("Unable to write address to ConfigMap: %s", err)
+		}
+	}
+	if c.flagSecret != "" {
+		if err := c.writeSecret(data); err != nil {
+			return fmt.Errorf("Unable to write address to Secret: %s", err)
+		}
+	}
+	return nil
+}
+
+// writeConfigMap creates the -output-configmap ConfigMap or sets the keys of
+// data in it. The other keys of the ConfigMap are kept.
+func (c *Command) writeConfigMap(data map[string]string) error {
+	configMaps := c.k8sClient.CoreV1().ConfigMaps(c.flagNamespace)
+	configMap, err := configMaps.Get(c.flagConfigMap, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: c.flagConfigMap,
+			},
+			Data: data,
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	updated := false
+	for k, v := range data {
+		if configMap.Data[k] != v {
+			configMap.Data[k] = v
+			updated = true
+		}
+	}
+	if _, ok := data[portKey]; !ok {
+		if _, ok := configMap.Data[portKey]; ok {
+			delete(configMap.Data, portKey)
+			updated = true
+		}
+	}
+	if !updated {
+		return nil
+	}
+	_, err = configMaps.Update(configMap)
+	return err
+}
+
+// writeSecret creates the -output-secret Secret or sets the keys of data in
+// it. The other keys of the Secret are kept.
+func (c *Command) writeSecret(data map[string]string) error {
+	secrets := c.k8sClient.CoreV1().Secrets(c.flagNamespace)
+	secret, err := secrets.Get(c.flagSecret, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		secret = &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: c.flagSecret,
+			},
+			Data: make(map[string][]byte),
+		}
+		for k, v := range data {
+			secret.Data[k] = []byte(v)
+		}
+		_, err = secrets.Create(secret)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	updated := false
+	for k, v := range data {
+		if string(secret.Data[k]) != v {
+			secret.Data[k] = []byte(v)
+			updated = true
+		}
+	}
+	if _, ok := data[portKey]; !ok {
+		if _, ok := secret.Data[portKey]; ok {
+			delete(secret.Data, portKey)
+			updated = true
+		}
+	}
+	if !updated {
+		return nil
+	}
+	_, err = secrets.Update(secret)
+	return err
 }
 
 func (c *Command) validateFlags(args []string) error {
 	if err := c.flags.Parse(args); err != nil {
 		return err
 	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
 	if len(c.flags.Args()) > 0 {
 		return errors.New("should have no non-flag arguments")
 	}
@@ -143,8 +448,14 @@ func (c *Command) validateFlags(args []string) error {
 	if c.flagServiceName == "" {
 		return errors.New("-name must be set")
 	}
-	if c.flagOutputFile == "" {
-		return errors.New("-output-file must be set")
+	if c.flagOutputFile == "" && c.flagConfigMap == "" && c.flagSecret == "" {
+		return errors.New("one of -output-file, -output-configmap or -output-secret must be set")
+	}
+	if c.flagFormat != formatPlain && c.flagFormat != formatJSON && c.flagFormat != formatCSV {
+		return fmt.Errorf("-output-format must be %q, %q or %q", formatPlain, formatJSON, formatCSV)
+	}
+	if c.flagWatch && c.flagInterval <= 0 {
+		return errors.New("-watch-interval must be positive")
 	}
 	return nil
 }
@@ -167,6 +478,20 @@ func (c *Command) Help() string {
 	return c.help
 }
 
+const (
+	// addressKey and portKey are the keys of the address and port in
+	// -output-configmap and -output-secret.
+	addressKey = "address"
+	portKey    = "port"
+)
+
+const (
+	// The values of -output-format.
+	formatPlain = "plain"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
 const synopsis = "Output Kubernetes Service address to file"
 const help = `
 Usage: consul-k8s service-address [options]
@@ -178,4 +503,17 @@ Usage: consul-k8s service-address [options]
     NodePort - Not supported
     LoadBalancer - Load balancer's IP or hostname
     ExternalName - Not Supported
+
+  With -resolve-hostname, a load balancer hostname is resolved to its IPs,
+  retrying until its DNS record exists. With -all-addresses, the addresses
+  of all the load balancer ingresses are written in the -output-format
+  format instead of the first one.
+
+  The address and the port of -port-name can also be written to the
+  ConfigMap -output-configmap or the Secret -output-secret. With -watch,
+  the command keeps polling the service every -watch-interval and rewrites
+  the outputs whenever its address or port changes, e.g. once a load
+  balancer gets a new IP, so that the components reading them don't need
+  the command to be re-run. This requires the get, create and update
+  permissions on the ConfigMap or Secret.
 `
@@ -3,6 +3,7 @@ package serviceaddress
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
@@ -22,6 +23,10 @@ func TestRun_FlagValidation(t *testing.T) {
 		Flags  []string
 		ExpErr string
 	}{
+		{
+			Flags:  []string{"-k8s-namespace=default", "-name=name", "-output-file=addr", "-output-format=yaml"},
+			ExpErr: `-output-format must be "plain", "json" or "csv"`,
+		},
 		{
 			Flags:  []string{},
 			ExpErr: "-k8s-namespace must be set",
@@ -32,7 +37,11 @@ func TestRun_FlagValidation(t *testing.T) {
 		},
 		{
 			Flags:  []string{"-k8s-namespace=default", "-name=name"},
-			ExpErr: "-output-file must be set",
+			ExpErr: "one of -output-file, -output-configmap or -output-secret must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace=default", "-name=name", "-output-configmap=addr", "-watch", "-watch-interval=0s"},
+			ExpErr: "-watch-interval must be positive",
 		},
 	}
 	for _, c := range cases {
@@ -263,6 +272,275 @@ func TestRun_FileWrittenAfterRetry(t *testing.T) {
 	}
 }
 
+// Test that the address and port are written to the ConfigMap and Secret
+// outputs, keeping their other keys.
+func TestRun_ConfigMapAndSecret(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		PortName string
+		ExpData  map[string]string
+		ExpErr   string
+	}{
+		"first port": {
+			ExpData: map[string]string{"address": "1.2.3.4", "port": "80", "other": "value"},
+		},
+		"named port": {
+			PortName: "https",
+			ExpData:  map[string]string{"address": "1.2.3.4", "port": "443", "other": "value"},
+		},
+		"unknown port": {
+			PortName: "grpc",
+			ExpErr:   `service service-name has no port named "grpc"`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8sNS := "default"
+			svc := kubeLoadBalancerSvc("service-name", "1.2.3.4", "")
+			svc.Namespace = k8sNS
+			svc.Spec.Ports = append(svc.Spec.Ports, v1.ServicePort{Name: "https", Port: 443})
+			k8s := fake.NewSimpleClientset(svc, &v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "address", Namespace: k8sNS},
+				Data:       map[string][]byte{"other": []byte("value")},
+			})
+
+			// The other key isn't set on the ConfigMap, which is created.
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:            ui,
+				k8sClient:     k8s,
+				retryDuration: 10 * time.Millisecond,
+				sigCh:         make(chan os.Signal, 1),
+			}
+			args := []string{
+				"-k8s-namespace", k8sNS,
+				"-name", "service-name",
+				"-output-configmap", "address",
+				"-output-secret", "address",
+			}
+			if c.PortName != "" {
+				args = append(args, "-port-name", c.PortName)
+			}
+			if c.ExpErr != "" {
+				// The port may be added later, so this is retried until
+				// interrupted.
+				go func() {
+					time.Sleep(100 * time.Millisecond)
+					cmd.sigCh <- os.Interrupt
+				}()
+				require.Equal(t, 1, cmd.Run(args))
+				require.Contains(t, ui.ErrorWriter.String(), "Interrupted waiting for the service address")
+				return
+			}
+			require.Equal(t, 0, cmd.Run(args), ui.ErrorWriter.String())
+
+			configMap, err := k8s.CoreV1().ConfigMaps(k8sNS).Get("address", metav1.GetOptions{})
+			require.NoError(t, err)
+			expConfigMap := map[string]string{}
+			for k, v := range c.ExpData {
+				if k != "other" {
+					expConfigMap[k] = v
+				}
+			}
+			require.Equal(t, expConfigMap, configMap.Data)
+
+			secret, err := k8s.CoreV1().Secrets(k8sNS).Get("address", metav1.GetOptions{})
+			require.NoError(t, err)
+			secretData := map[string]string{}
+			for k, v := range secret.Data {
+				secretData[k] = string(v)
+			}
+			require.Equal(t, c.ExpData, secretData)
+		})
+	}
+}
+
+// Test that in watch mode the outputs are updated when the address of the
+// service changes, and that the command exits cleanly on interrupt.
+func TestRun_Watch(t *testing.T) {
+	t.Parallel()
+	k8sNS := "default"
+	svc := kubeLoadBalancerSvc("service-name", "1.2.3.4", "")
+	svc.Namespace = k8sNS
+	k8s := fake.NewSimpleClientset(svc)
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	outputFile := filepath.Join(tmpDir, "address.txt")
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:            ui,
+		k8sClient:     k8s,
+		retryDuration: 10 * time.Millisecond,
+		sigCh:         make(chan os.Signal, 1),
+	}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-k8s-namespace", k8sNS,
+			"-name", "service-name",
+			"-output-file", outputFile,
+			"-output-configmap", "address",
+			"-watch",
+			"-watch-interval", "10ms",
+		})
+	}()
+
+	configMapData := func() map[string]string {
+		configMap, err := k8s.CoreV1().ConfigMaps(k8sNS).Get("address", metav1.GetOptions{})
+		if err != nil {
+			return nil
+		}
+		return configMap.Data
+	}
+	require.Eventually(t, func() bool {
+		return configMapData()["address"] == "1.2.3.4"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The load balancer gets a new address and port.
+	svc = kubeLoadBalancerSvc("service-name", "", "lb.example.com")
+	svc.Namespace = k8sNS
+	svc.Spec.Ports[0].Port = 8443
+	_, err = k8s.CoreV1().Services(k8sNS).Update(svc)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return configMapData()["address"] == "lb.example.com"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, map[string]string{"address": "lb.example.com", "port": "8443"}, configMapData())
+	actAddressBytes, err := ioutil.ReadFile(outputFile)
+	require.NoError(t, err)
+	require.Equal(t, "lb.example.com", string(actAddressBytes))
+
+	// The address is kept while the service has none.
+	svc.Status = v1.ServiceStatus{}
+	_, err = k8s.CoreV1().Services(k8sNS).Update(svc)
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, "lb.example.com", configMapData()["address"])
+
+	cmd.sigCh <- os.Interrupt
+	select {
+	case code := <-exitCh:
+		require.Equal(t, 0, code, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not exit after interrupt")
+	}
+}
+
+// Test the outputs of the load balancers with several ingresses and
+// hostnames.
+func TestRun_LoadBalancerIngresses(t *testing.T) {
+	t.Parallel()
+	ingresses := []v1.LoadBalancerIngress{
+		{Hostname: "lb.example.com"},
+		{IP: "1.2.3.4"},
+		{},
+		{Hostname: "lb-2.example.com"},
+		{IP: "5.6.7.8"},
+	}
+	cases := map[string]struct {
+		Flags      []string
+		ExpAddress string
+	}{
+		"first": {
+			ExpAddress: "lb.example.com",
+		},
+		"first resolved": {
+			Flags:      []string{"-resolve-hostname"},
+			ExpAddress: "10.0.0.1",
+		},
+		"all plain": {
+			Flags:      []string{"-all-addresses"},
+			ExpAddress: "lb.example.com\n1.2.3.4\nlb-2.example.com\n5.6.7.8",
+		},
+		"all csv": {
+			Flags:      []string{"-all-addresses", "-output-format=csv"},
+			ExpAddress: "lb.example.com,1.2.3.4,lb-2.example.com,5.6.7.8",
+		},
+		"all resolved json": {
+			Flags:      []string{"-all-addresses", "-resolve-hostname", "-output-format=json"},
+			ExpAddress: `["10.0.0.1","10.0.0.2","1.2.3.4","5.6.7.8"]`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8sNS := "default"
+			svc := kubeLoadBalancerSvc("service-name", "", "")
+			svc.Namespace = k8sNS
+			svc.Status.LoadBalancer.Ingress = ingresses
+			k8s := fake.NewSimpleClientset(svc)
+			tmpDir, err := ioutil.TempDir("", "")
+			require.NoError(t, err)
+			defer os.RemoveAll(tmpDir)
+			outputFile := filepath.Join(tmpDir, "address.txt")
+
+			// The IPs are sorted, and lb-2.example.com resolves to an IP of
+			// lb.example.com.
+			lookupHost := map[string][]string{
+				"lb.example.com":   {"10.0.0.2", "10.0.0.1"},
+				"lb-2.example.com": {"5.6.7.8"},
+			}
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				k8sClient: k8s,
+				lookupHost: func(host string) ([]string, error) {
+					return lookupHost[host], nil
+				},
+			}
+			responseCode := cmd.Run(append([]string{
+				"-k8s-namespace", k8sNS,
+				"-name", "service-name",
+				"-output-file", outputFile,
+			}, c.Flags...))
+			require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+			actAddressBytes, err := ioutil.ReadFile(outputFile)
+			require.NoError(t, err)
+			require.Equal(t, c.ExpAddress, string(actAddressBytes))
+		})
+	}
+}
+
+// Test that the hostname resolution is retried until the DNS record exists.
+func TestRun_ResolveHostnameRetry(t *testing.T) {
+	t.Parallel()
+	k8sNS := "default"
+	svc := kubeLoadBalancerSvc("service-name", "", "lb.example.com")
+	svc.Namespace = k8sNS
+	k8s := fake.NewSimpleClientset(svc)
+	tmpDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	outputFile := filepath.Join(tmpDir, "address.txt")
+
+	lookups := 0
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:            ui,
+		k8sClient:     k8s,
+		retryDuration: 10 * time.Millisecond,
+		lookupHost: func(host string) ([]string, error) {
+			lookups++
+			if lookups < 3 {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			return []string{"10.0.0.1"}, nil
+		},
+	}
+	responseCode := cmd.Run([]string{
+		"-k8s-namespace", k8sNS,
+		"-name", "service-name",
+		"-output-file", outputFile,
+		"-resolve-hostname",
+	})
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+	require.Equal(t, 3, lookups)
+	actAddressBytes, err := ioutil.ReadFile(outputFile)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", string(actAddressBytes))
+}
+
 func kubeLoadBalancerSvc(name string, ip string, hostname string) *v1.Service {
 	return &v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -0,0 +1,47 @@
+package subcommand
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ShutdownTimeout is how long the commands wait for their in-flight work,
+// e.g. the admission requests and the Consul writes, once they're stopped.
+// It's shorter than the default terminationGracePeriodSeconds of 30s so
+// that they exit before they're killed.
+const ShutdownTimeout = 20 * time.Second
+
+// WithSignal returns a copy of parent that's cancelled once sigCh receives
+// a signal, for the commands to stop their loops on SIGINT and SIGTERM.
+func WithSignal(parent context.Context, sigCh <-chan os.Signal) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Shutdown stops server from accepting requests and waits up to
+// ShutdownTimeout for its in-flight requests to complete.
+func Shutdown(server *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// Wait waits up to ShutdownTimeout for doneCh to be closed. It returns
+// false if it wasn't.
+func Wait(doneCh <-chan struct{}) bool {
+	select {
+	case <-doneCh:
+		return true
+	case <-time.After(ShutdownTimeout):
+		return false
+	}
+}
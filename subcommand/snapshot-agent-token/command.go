@@ -0,0 +1,296 @@
+package snapshotagenttoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/acl"
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+)
+
+// Command logs in with an auth method for the snapshot agent, writes the
+// token to a config file of the agent and logs in again before the token
+// expires.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	logging    *k8sflags.LogFlags
+
+	flagAuthMethod          string
+	flagAuthMethodNamespace string
+	flagBearerTokenFile     string
+	flagLoginMeta           map[string]string
+	flagAgentConfigFile     string
+	flagTokenCheckPeriod    time.Duration
+	flagInit                bool
+
+	consulClient *api.Client
+
+	// sigCh receives a signal when the command should stop.
+	sigCh chan os.Signal
+
+	once sync.Once
+	help string
+}
+
+// agentConfig is the config file of the snapshot agent written by the
+// command. The snapshot agent merges it with its other config files.
+type agentConfig struct {
+	SnapshotAgent struct {
+		Token string `json:"token"`
+	} `json:"snapshot_agent"`
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagAuthMethod, "auth-method", "",
+		"The name of the Kubernetes auth method to log in with.")
+	c.flags.StringVar(&c.flagAuthMethodNamespace, "auth-method-namespace", "",
+		"[Enterprise Only] The Consul namespace the auth method is defined in.")
+	c.flags.StringVar(&c.flagBearerTokenFile, "bearer-token-file", acl.DefaultBearerTokenFile,
+		"Path to the Kubernetes service account token to log in with.")
+	c.flags.Var((*flags.FlagMapValue)(&c.flagLoginMeta), "login-meta",
+		"Metadata to set on the token created by logging in, formatted as key=value. "+
+			"May be specified multiple times.")
+	c.flags.StringVar(&c.flagAgentConfigFile, "agent-config-file", "",
+		"Path to the snapshot agent config file the token is written to, as snapshot_agent.token.")
+	c.flags.DurationVar(&c.flagTokenCheckPeriod, "token-check-period", 1*time.Minute,
+		"Time between checking whether the token is still valid and not about to expire. Defaults to 1m.")
+	c.flags.BoolVar(&c.flagInit, "init", false,
+		"If true, exit once the token is written, e.g. in an init container so that the snapshot agent "+
+			"starts with a token. The sidecar then reuses the token until it needs renewal.")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// This channel must be initialized before Run() is called so that
+	// tests can interrupt the command.
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
+	}
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.consulClient == nil {
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
+		c.consulClient, err = consul.NewClient(cfg)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+			return 1
+		}
+	}
+
+	ctx, cancel := subcommand.WithSignal(context.Background(), c.sigCh)
+	defer cancel()
+
+	// The token is acquired before the snapshot agent starts, so the
+	// errors are retried until the command is interrupted.
+	tok, created, err := c.acquireToken(logger)
+	for err != nil {
+		logger.Error("Error acquiring ACL token", "auth-method", c.flagAuthMethod, "err", err)
+		select {
+		case <-time.After(c.flagTokenCheckPeriod):
+		case <-ctx.Done():
+			c.UI.Error("Interrupted before acquiring an ACL token")
+			return 1
+		}
+		tok, created, err = c.acquireToken(logger)
+	}
+	if c.flagInit {
+		c.UI.Info(fmt.Sprintf("Wrote ACL token %q to %q", tok.AccessorID, c.flagAgentConfigFile))
+		return 0
+	}
+
+	for {
+		select {
+		case <-time.After(c.flagTokenCheckPeriod):
+		case <-ctx.Done():
+			// Only the tokens created by the sidecar are logged out, since
+			// the one of the init container may still be in use if the
+			// sidecar restarts alone.
+			if created {
+				if _, err := c.consulClient.ACL().Logout(&api.WriteOptions{Token: tok.SecretID}); err != nil {
+					logger.Warn("Failed to log out ACL token", "err", err)
+				}
+			}
+			return 0
+		}
+
+		current, _, err := c.consulClient.ACL().TokenReadSelf(&api.QueryOptions{Token: tok.SecretID})
+		if err == nil && !acl.TokenNeedsRenewal(current, time.Now()) {
+			continue
+		}
+		if err != nil {
+			logger.Info("ACL token is no longer valid, logging in again", "err", err)
+		} else {
+			logger.Info("ACL token is about to expire, logging in again", "expiration-time", current.ExpirationTime)
+		}
+		newTok, err := c.login()
+		if err != nil {
+			// We'll try again on the next check, the snapshot agent still
+			// has the previous token until then.
+			logger.Error("Failed to renew ACL token", "auth-method", c.flagAuthMethod, "err", err)
+			continue
+		}
+		logger.Info("Renewed ACL token", "accessor-id", newTok.AccessorID)
+		// The previous token is left to expire rather than logged out, in
+		// case the snapshot agent hasn't reloaded the new one yet.
+		tok, created = newTok, true
+	}
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagAuthMethod == "" {
+		return errors.New("-auth-method must be set")
+	}
+	if c.flagAgentConfigFile == "" {
+		return errors.New("-agent-config-file must be set")
+	}
+	if c.flagTokenCheckPeriod <= 0 {
+		return errors.New("-token-check-period must be greater than 0")
+	}
+	return nil
+}
+
+// acquireToken returns the token of the agent config file if it's valid and
+// not about to expire, e.g. the one written by the init container.
+// Otherwise it logs in. It returns whether the token was created by
+// logging in.
+func (c *Command) acquireToken(logger hclog.Logger) (*api.ACLToken, bool, error) {
+	tok, err := c.existingToken()
+	if err == nil {
+		logger.Info("Using the ACL token of the agent config file", "accessor-id", tok.AccessorID)
+		return tok, false, nil
+	}
+	logger.Info("No valid ACL token in the agent config file, logging in", "agent-config-file", c.flagAgentConfigFile, "reason", err)
+	tok, err = c.login()
+	if err != nil {
+		return nil, false, err
+	}
+	logger.Info("Acquired ACL token", "accessor-id", tok.AccessorID)
+	return tok, true, nil
+}
+
+// existingToken reads the token of the agent config file and returns it if
+// it's valid and not about to expire.
+func (c *Command) existingToken() (*api.ACLToken, error) {
+	data, err := ioutil.ReadFile(c.flagAgentConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	var config agentConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parsing %q: %s", c.flagAgentConfigFile, err)
+	}
+	if config.SnapshotAgent.Token == "" {
+		return nil, fmt.Errorf("%q has no token", c.flagAgentConfigFile)
+	}
+	tok, _, err := c.consulClient.ACL().TokenReadSelf(&api.QueryOptions{Token: config.SnapshotAgent.Token})
+	if err != nil {
+		return nil, err
+	}
+	if acl.TokenNeedsRenewal(tok, time.Now()) {
+		return nil, fmt.Errorf("token %q is about to expire", tok.AccessorID)
+	}
+	return tok, nil
+}
+
+// login logs in with the auth method using the bearer token and writes the
+// new token to the agent config file.
+func (c *Command) login() (*api.ACLToken, error) {
+	bearerToken, err := ioutil.ReadFile(c.flagBearerTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading bearer token file %q: %s", c.flagBearerTokenFile, err)
+	}
+	tok, _, err := c.consulClient.ACL().Login(&api.ACLLoginParams{
+		AuthMethod:  c.flagAuthMethod,
+		BearerToken: strings.TrimSpace(string(bearerToken)),
+		Meta:        c.flagLoginMeta,
+	}, &api.WriteOptions{Namespace: c.flagAuthMethodNamespace})
+	if err != nil {
+		return nil, err
+	}
+
+	var config agentConfig
+	config.SnapshotAgent.Token = tok.SecretID
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := acl.WriteFileAtomic(c.flagAgentConfigFile, data, 0444); err != nil {
+		return nil, fmt.Errorf("writing token to %q: %s", c.flagAgentConfigFile, err)
+	}
+	return tok, nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Log in and renew the ACL token of the snapshot agent"
+const help = `
+Usage: consul-k8s snapshot-agent-token [options]
+
+  Logs in with the Kubernetes auth method -auth-method for the Consul
+  Enterprise snapshot agent, instead of giving it a static token from a
+  secret. The token is written as snapshot_agent.token to the config file
+  -agent-config-file, which is passed to the snapshot agent with its
+  -config-file flag.
+
+  Run with -init in an init container so that the snapshot agent starts
+  with a token, then without it in a sidecar. The sidecar checks the token
+  every -token-check-period, and logs in again and rewrites the config file
+  once the token is no longer valid or within the last third of its TTL,
+  so that auth methods with a MaxTokenTTL can be used. The sidecar logs out
+  the tokens it created when it's stopped.
+`
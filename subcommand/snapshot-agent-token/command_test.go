@@ -0,0 +1,228 @@
+package snapshotagenttoken
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{"foo"},
+			ExpErr: "Should have no non-flag arguments.",
+		},
+		{
+			Flags:  []string{},
+			ExpErr: "-auth-method must be set",
+		},
+		{
+			Flags:  []string{"-auth-method=consul-k8s-auth-method"},
+			ExpErr: "-agent-config-file must be set",
+		},
+		{
+			Flags:  []string{"-auth-method=consul-k8s-auth-method", "-agent-config-file=token.json", "-token-check-period=0s"},
+			ExpErr: "-token-check-period must be greater than 0",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.Flags))
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+// Test that -init logs in and writes the token to the agent config file,
+// and that the sidecar reuses it until it's about to expire.
+func TestRun_LoginAndRenewal(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsul(time.Minute)
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "token.json")
+	bearerTokenFile := filepath.Join(dir, "bearer-token")
+	require.NoError(t, ioutil.WriteFile(bearerTokenFile, []byte("service-account-jwt\n"), 0600))
+	args := []string{
+		"-http-addr", server.URL,
+		"-auth-method", "consul-k8s-auth-method",
+		"-bearer-token-file", bearerTokenFile,
+		"-agent-config-file", configFile,
+		"-login-meta", "pod=default/consul-snapshot-agent-0",
+	}
+
+	// The init container logs in.
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 0, cmd.Run(append(args, "-init")), ui.ErrorWriter.String())
+	require.Equal(t, "secret-1", configToken(t, configFile))
+	require.Equal(t, []string{"service-account-jwt"}, consul.bearerTokens())
+
+	// The sidecar starts with the token of the init container, and logs in
+	// again once it's in the last third of its TTL.
+	ui = cli.NewMockUi()
+	cmd = Command{UI: ui, sigCh: make(chan os.Signal, 1)}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run(append(args, "-token-check-period", "10ms"))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, "secret-1", configToken(t, configFile))
+	consul.expire("secret-1")
+	require.Eventually(t, func() bool {
+		return configToken(t, configFile) == "secret-2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The token created by the sidecar is logged out when it's stopped,
+	// but not the one of the init container.
+	cmd.sigCh <- os.Interrupt
+	select {
+	case code := <-exitCh:
+		require.Equal(t, 0, code, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not exit after interrupt")
+	}
+	require.Equal(t, []string{"secret-2"}, consul.loggedOut())
+}
+
+// Test that the login is retried until it succeeds, e.g. while the auth
+// method isn't created yet.
+func TestRun_LoginRetried(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsul(0)
+	consul.failLogins = 2
+	server := httptest.NewServer(consul)
+	defer server.Close()
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "token.json")
+	bearerTokenFile := filepath.Join(dir, "bearer-token")
+	require.NoError(t, ioutil.WriteFile(bearerTokenFile, []byte("service-account-jwt"), 0600))
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 0, cmd.Run([]string{
+		"-http-addr", server.URL,
+		"-auth-method", "consul-k8s-auth-method",
+		"-bearer-token-file", bearerTokenFile,
+		"-agent-config-file", configFile,
+		"-token-check-period", "10ms",
+		"-init",
+	}), ui.ErrorWriter.String())
+	require.Equal(t, "secret-3", configToken(t, configFile))
+}
+
+// fakeConsul serves the ACL login, logout and token self endpoints. The
+// tokens it creates are secret-1, secret-2, etc.
+type fakeConsul struct {
+	ttl        time.Duration
+	failLogins int
+
+	lock    sync.Mutex
+	logins  int
+	tokens  map[string]*api.ACLToken
+	bearers []string
+	logouts []string
+}
+
+func newFakeConsul(ttl time.Duration) *fakeConsul {
+	return &fakeConsul{ttl: ttl, tokens: make(map[string]*api.ACLToken)}
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	secret := r.Header.Get("X-Consul-Token")
+	switch r.URL.Path {
+	case "/v1/acl/login":
+		f.logins++
+		if f.logins <= f.failLogins {
+			http.Error(w, "auth method not found", http.StatusForbidden)
+			return
+		}
+		var params api.ACLLoginParams
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.bearers = append(f.bearers, params.BearerToken)
+		tok := &api.ACLToken{
+			AccessorID: fmt.Sprintf("accessor-%d", f.logins),
+			SecretID:   fmt.Sprintf("secret-%d", f.logins),
+			CreateTime: time.Now(),
+		}
+		if f.ttl > 0 {
+			exp := tok.CreateTime.Add(f.ttl)
+			tok.ExpirationTime = &exp
+		}
+		f.tokens[tok.SecretID] = tok
+		json.NewEncoder(w).Encode(tok)
+	case "/v1/acl/logout":
+		f.logouts = append(f.logouts, secret)
+		delete(f.tokens, secret)
+	case "/v1/acl/token/self":
+		tok, ok := f.tokens[secret]
+		if !ok {
+			http.Error(w, "ACL not found", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(tok)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// expire moves the expiration time of the token secret within the last
+// third of its TTL.
+func (f *fakeConsul) expire(secret string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	exp := time.Now().Add(f.ttl / 10)
+	f.tokens[secret].CreateTime = exp.Add(-f.ttl)
+	f.tokens[secret].ExpirationTime = &exp
+}
+
+func (f *fakeConsul) bearerTokens() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.bearers...)
+}
+
+func (f *fakeConsul) loggedOut() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string(nil), f.logouts...)
+}
+
+func configToken(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var config agentConfig
+	require.NoError(t, json.Unmarshal(data, &config))
+	return config.SnapshotAgent.Token
+}
+
+func tmpDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	return dir
+}
@@ -0,0 +1,41 @@
+package snapshot
+
+import (
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+)
+
+// Command is the parent of the commands saving and restoring snapshots of
+// the state of the Consul servers.
+type Command struct {
+	UI cli.Ui
+}
+
+func (c *Command) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	return flags.Usage(help, nil)
+}
+
+const synopsis = "Save and restore snapshots of the Consul servers"
+const help = `
+Usage: consul-k8s snapshot <subcommand> [options] [args]
+
+  Saves and restores snapshots of the state of the Consul servers of the
+  installation, e.g. for backups. The commands find a ready server, connect
+  to it with kubectl port-forward, and use the ACL bootstrap token of the
+  installation when ACLs are managed by the chart.
+
+  Save a snapshot:
+
+      $ consul-k8s snapshot save backup.snap
+
+  Restore a snapshot:
+
+      $ consul-k8s snapshot restore backup.snap
+
+  For more examples, ask for subcommand help or view the documentation.
+`
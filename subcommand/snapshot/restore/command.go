@@ -0,0 +1,146 @@
+package restore
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul-k8s/subcommand/snapshot"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command restores a snapshot of the state of the Consul servers from a
+// file.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagToken         string
+	flagAutoApprove   bool
+	flagKubectlBinary string
+
+	helm       *helm.Client
+	kubeClient kubernetes.Interface
+	forward    portforward.Forwarder
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagToken, "token", "",
+		"ACL token of the restore. Defaults to the bootstrap token of the installation when the chart manages the ACLs.")
+	c.flags.BoolVar(&c.flagAutoApprove, "auto-approve", false,
+		"If true, restores without asking for confirmation.")
+	c.flags.StringVar(&c.flagKubectlBinary, "kubectl", "kubectl",
+		"Path of the kubectl binary, used to port-forward to the Consul server.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) != 1 {
+		c.UI.Error("Should have exactly one argument, the path of the snapshot file.")
+		return 1
+	}
+	path := c.flags.Arg(0)
+	file, err := os.Open(path)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error opening the snapshot file: %s", err))
+		return 1
+	}
+	defer file.Close()
+
+	if c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.helm == nil {
+		c.helm = &helm.Client{KubeConfig: c.k8s.KubeConfig()}
+	}
+	if c.forward == nil {
+		c.forward = portforward.Kubectl(c.flagKubectlBinary, c.k8s.KubeConfig())
+	}
+
+	server := &snapshot.Server{Helm: c.helm, KubeClient: c.kubeClient, Forward: c.forward, Token: c.flagToken}
+	client, pod, closeForward, err := server.Connect()
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	defer closeForward()
+
+	if !c.flagAutoApprove {
+		c.UI.Output(fmt.Sprintf("The state of the Consul servers will be replaced by the snapshot %s.", path))
+		ok, err := subcommand.Confirm(c.UI, "\nProceed with the restore?")
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading the confirmation: %s", err))
+			return 1
+		}
+		if !ok {
+			c.UI.Output("Restore cancelled.")
+			return 1
+		}
+	}
+
+	if err := client.Snapshot().Restore(nil, file); err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error restoring the snapshot: %s", err))
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ Restored the snapshot %s through server %s", path, pod))
+	return 0
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Restore a snapshot of the Consul servers from a file"
+const help = `
+Usage: consul-k8s snapshot restore [options] <file>
+
+  Restores a snapshot of the state of the Consul servers, as saved by
+  consul-k8s snapshot save, replacing their current state. It must be
+  confirmed unless -auto-approve is set. The snapshot is restored through a
+  ready server, reached with kubectl port-forward.
+
+  The ACL tokens of the snapshot replace the current ones, so the tokens
+  stored in the secrets of the installation, such as its bootstrap token,
+  only keep working if the snapshot is one of the same installation.
+
+      $ consul-k8s snapshot restore backup.snap
+
+`
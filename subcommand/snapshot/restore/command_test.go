@@ -0,0 +1,129 @@
+package restore
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	cases := []struct {
+		args   []string
+		expErr string
+	}{
+		{
+			args:   nil,
+			expErr: "Should have exactly one argument, the path of the snapshot file.",
+		},
+		{
+			args:   []string{"/does/not/exist.snap"},
+			expErr: "Error opening the snapshot file: open /does/not/exist.snap: no such file or directory",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.args))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun_Restore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.snap")
+	require.NoError(t, ioutil.WriteFile(path, []byte("snapshot"), 0600))
+
+	cases := map[string]struct {
+		flags      []string
+		input      string
+		expCode    int
+		expRestore bool
+		expOutput  string
+	}{
+		"confirmed": {
+			input:      "yes\n",
+			expCode:    0,
+			expRestore: true,
+			expOutput:  " ✓ Restored the snapshot " + path + " through server consul-server-0",
+		},
+		"cancelled": {
+			input:     "no\n",
+			expCode:   1,
+			expOutput: "Restore cancelled.",
+		},
+		"auto-approve": {
+			flags:      []string{"-auto-approve"},
+			expCode:    0,
+			expRestore: true,
+			expOutput:  " ✓ Restored the snapshot " + path + " through server consul-server-0",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var restored string
+			consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPut, r.Method)
+				require.Equal(t, "/v1/snapshot", r.URL.Path)
+				require.Equal(t, "bootstrap", r.Header.Get("X-Consul-Token"))
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				restored = string(body)
+			}))
+			defer consul.Close()
+
+			client, err := helm.NewTestClient(nil, helm.TestRelease{
+				Name:      "consul",
+				Namespace: "mesh",
+				Chart:     "consul-0.24.1",
+				Values:    `{"global":{"acls":{"manageSystemACLs":true}}}`,
+			})
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			ui.InputReader = strings.NewReader(c.input)
+			cmd := Command{
+				UI:   ui,
+				helm: client,
+				kubeClient: fake.NewSimpleClientset(
+					&corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "consul-server-0",
+							Namespace: "mesh",
+							Labels:    map[string]string{"app": "consul", "release": "consul", "component": "server"},
+						},
+						Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+					},
+					&corev1.Secret{
+						ObjectMeta: metav1.ObjectMeta{Name: "consul-consul-bootstrap-acl-token", Namespace: "mesh"},
+						Data:       map[string][]byte{"token": []byte("bootstrap")},
+					},
+				),
+				forward: func(namespace, name string, remotePort int) (string, func(), error) {
+					return strings.TrimPrefix(consul.URL, "http://"), func() {}, nil
+				},
+			}
+			require.Equal(t, c.expCode, cmd.Run(append(c.flags, path)), ui.ErrorWriter.String())
+			require.Contains(t, ui.OutputWriter.String(), c.expOutput)
+			if c.expRestore {
+				require.Equal(t, "snapshot", restored)
+			} else {
+				require.Empty(t, restored)
+			}
+		})
+	}
+}
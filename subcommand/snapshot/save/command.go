@@ -0,0 +1,153 @@
+package save
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul-k8s/subcommand/snapshot"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command saves a snapshot of the state of the Consul servers to a file.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagToken         string
+	flagStale         bool
+	flagKubectlBinary string
+
+	helm       *helm.Client
+	kubeClient kubernetes.Interface
+	forward    portforward.Forwarder
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagToken, "token", "",
+		"ACL token of the snapshot. Defaults to the bootstrap token of the installation when the chart manages the ACLs.")
+	c.flags.BoolVar(&c.flagStale, "stale", false,
+		"If true, the server of the snapshot doesn't need to be the leader, e.g. when there is no leader.")
+	c.flags.StringVar(&c.flagKubectlBinary, "kubectl", "kubectl",
+		"Path of the kubectl binary, used to port-forward to the Consul server.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) != 1 {
+		c.UI.Error("Should have exactly one argument, the path of the snapshot file.")
+		return 1
+	}
+	path := c.flags.Arg(0)
+
+	if c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.helm == nil {
+		c.helm = &helm.Client{KubeConfig: c.k8s.KubeConfig()}
+	}
+	if c.forward == nil {
+		c.forward = portforward.Kubectl(c.flagKubectlBinary, c.k8s.KubeConfig())
+	}
+
+	server := &snapshot.Server{Helm: c.helm, KubeClient: c.kubeClient, Forward: c.forward, Token: c.flagToken}
+	client, pod, closeForward, err := server.Connect()
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	defer closeForward()
+
+	snap, meta, err := client.Snapshot().Save(&api.QueryOptions{AllowStale: c.flagStale})
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error saving the snapshot: %s", err))
+		return 1
+	}
+	defer snap.Close()
+
+	// The snapshot is written to a temporary file first so that a failure
+	// doesn't leave a truncated snapshot, or overwrite a previous one.
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error creating the snapshot file: %s", err))
+		return 1
+	}
+	defer os.Remove(file.Name())
+	size, err := io.Copy(file, snap)
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error writing the snapshot: %s", err))
+		return 1
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error writing the snapshot: %s", err))
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ Saved the snapshot of server %s at index %d to %s (%d bytes)",
+		pod, meta.LastIndex, path, size))
+	return 0
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Save a snapshot of the Consul servers to a file"
+const help = `
+Usage: consul-k8s snapshot save [options] <file>
+
+  Saves a snapshot of the state of the Consul servers of the installation,
+  i.e. their catalog, KV store, ACLs and config entries, to the file. The
+  snapshot is taken by the leader through a ready server, reached with
+  kubectl port-forward, and can be restored with consul-k8s snapshot
+  restore.
+
+      $ consul-k8s snapshot save backup.snap
+
+`
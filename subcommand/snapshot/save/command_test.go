@@ -0,0 +1,106 @@
+package save
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 1, cmd.Run(nil))
+	require.Contains(t, ui.ErrorWriter.String(), "Should have exactly one argument, the path of the snapshot file.")
+}
+
+func TestRun_Save(t *testing.T) {
+	cases := map[string]struct {
+		status    int
+		expCode   int
+		expOutput string
+		expErr    string
+		expFile   string
+	}{
+		"saved": {
+			status:    http.StatusOK,
+			expCode:   0,
+			expOutput: " ✓ Saved the snapshot of server consul-server-0 at index 42 to ",
+			expFile:   "snapshot",
+		},
+		"error": {
+			status:  http.StatusInternalServerError,
+			expCode: 1,
+			expErr:  " ✗ Error saving the snapshot: Unexpected response code: 500 (No cluster leader)",
+			expFile: "previous",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "snapshot")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "backup.snap")
+			require.NoError(t, ioutil.WriteFile(path, []byte("previous"), 0600))
+
+			consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/v1/snapshot", r.URL.Path)
+				require.Equal(t, "", r.URL.Query().Get("stale"))
+				if c.status != http.StatusOK {
+					w.WriteHeader(c.status)
+					fmt.Fprint(w, "No cluster leader")
+					return
+				}
+				w.Header().Set("X-Consul-Index", "42")
+				fmt.Fprint(w, "snapshot")
+			}))
+			defer consul.Close()
+
+			client, err := helm.NewTestClient(nil, helm.TestRelease{
+				Name:      "consul",
+				Namespace: "mesh",
+				Chart:     "consul-0.24.1",
+				Values:    `{}`,
+			})
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:   ui,
+				helm: client,
+				kubeClient: fake.NewSimpleClientset(&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "consul-server-0",
+						Namespace: "mesh",
+						Labels:    map[string]string{"app": "consul", "release": "consul", "component": "server"},
+					},
+					Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+				}),
+				forward: func(namespace, name string, remotePort int) (string, func(), error) {
+					return strings.TrimPrefix(consul.URL, "http://"), func() {}, nil
+				},
+			}
+			require.Equal(t, c.expCode, cmd.Run([]string{path}), ui.ErrorWriter.String())
+			require.Contains(t, ui.OutputWriter.String(), c.expOutput)
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+
+			content, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, c.expFile, string(content))
+			files, err := ioutil.ReadDir(dir)
+			require.NoError(t, err)
+			require.Len(t, files, 1, "the temporary file is removed")
+		})
+	}
+}
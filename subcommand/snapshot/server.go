@@ -0,0 +1,109 @@
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Ports of the HTTP API of the Consul servers.
+const (
+	httpPort  = 8500
+	httpsPort = 8501
+)
+
+// Server connects to a Consul server of the installation of the Consul Helm
+// chart.
+type Server struct {
+	Helm       *helm.Client
+	KubeClient kubernetes.Interface
+	Forward    portforward.Forwarder
+	// Token is the ACL token of the requests. Defaults to the bootstrap
+	// token of the installation when the chart manages the ACLs.
+	Token string
+}
+
+// Connect returns a client of the API of a ready server of the
+// installation, the name of its pod, and the function closing the
+// connection. The client uses HTTPS with the CA of the installation when
+// TLS is enabled.
+func (s *Server) Connect() (*api.Client, string, func(), error) {
+	release, err := s.Helm.Release()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	values, err := s.Helm.Values(release.Name, release.Namespace)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("getting the values of the release: %s", err)
+	}
+	prefix := release.ResourcePrefix(values)
+	tls, _, _ := unstructured.NestedBool(values, "global", "tls", "enabled")
+	acls, _, _ := unstructured.NestedBool(values, "global", "acls", "manageSystemACLs")
+
+	pods, err := s.KubeClient.CoreV1().Pods(release.Namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=consul,release=%s,component=server", release.Name),
+	})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("listing the server pods: %s", err)
+	}
+	var pod string
+	for _, p := range pods.Items {
+		if ready(p) {
+			pod = p.Name
+			break
+		}
+	}
+	if pod == "" {
+		return nil, "", nil, errors.New("no ready Consul server found")
+	}
+
+	config := api.DefaultNonPooledConfig()
+	config.Token = s.Token
+	secrets := s.KubeClient.CoreV1().Secrets(release.Namespace)
+	if config.Token == "" && acls {
+		secret, err := secrets.Get(helm.BootstrapTokenSecretName(prefix), metav1.GetOptions{})
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("reading the bootstrap token: %s", err)
+		}
+		config.Token = string(secret.Data["token"])
+	}
+	port := httpPort
+	if tls {
+		secret, err := secrets.Get(prefix+"-ca-cert", metav1.GetOptions{})
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("reading the CA certificate: %s", err)
+		}
+		config.Scheme = "https"
+		config.TLSConfig.CAPem = secret.Data[corev1.TLSCertKey]
+		port = httpsPort
+	}
+
+	addr, closeForward, err := s.Forward(release.Namespace, pod, port)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("connecting to server %s: %s", pod, err)
+	}
+	config.Address = addr
+	client, err := consul.NewClient(config)
+	if err != nil {
+		closeForward()
+		return nil, "", nil, fmt.Errorf("initializing the Consul client: %s", err)
+	}
+	return client, pod, closeForward, nil
+}
+
+func ready(pod corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package snapshot
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestServer_Connect(t *testing.T) {
+	cases := map[string]struct {
+		values   string
+		token    string
+		tls      bool
+		objects  []runtime.Object
+		expPod   string
+		expPort  int
+		expToken string
+		expErr   string
+	}{
+		"defaults": {
+			values:  `{}`,
+			objects: []runtime.Object{serverPod("consul-server-0", false), serverPod("consul-server-1", true)},
+			expPod:  "consul-server-1",
+			expPort: 8500,
+		},
+		"ACLs and TLS": {
+			values: `{"global":{"name":"hashi","acls":{"manageSystemACLs":true},"tls":{"enabled":true}}}`,
+			tls:    true,
+			objects: []runtime.Object{
+				serverPod("consul-server-0", true),
+				secret("hashi-bootstrap-acl-token", "token", "bootstrap"),
+			},
+			expPod:   "consul-server-0",
+			expPort:  8501,
+			expToken: "bootstrap",
+		},
+		"fullname override": {
+			values: `{"fullnameOverride":"override","global":{"name":"hashi","acls":{"manageSystemACLs":true}}}`,
+			objects: []runtime.Object{
+				serverPod("consul-server-0", true),
+				secret("override-bootstrap-acl-token", "token", "bootstrap"),
+			},
+			expPod:   "consul-server-0",
+			expPort:  8500,
+			expToken: "bootstrap",
+		},
+		"token flag": {
+			values:   `{"global":{"acls":{"manageSystemACLs":true}}}`,
+			token:    "operator",
+			objects:  []runtime.Object{serverPod("consul-server-0", true)},
+			expPod:   "consul-server-0",
+			expPort:  8500,
+			expToken: "operator",
+		},
+		"no ready server": {
+			values:  `{}`,
+			objects: []runtime.Object{serverPod("consul-server-0", false)},
+			expErr:  "no ready Consul server found",
+		},
+		"missing bootstrap token": {
+			values:  `{"global":{"acls":{"manageSystemACLs":true}}}`,
+			objects: []runtime.Object{serverPod("consul-server-0", true)},
+			expErr:  `reading the bootstrap token: secrets "consul-consul-bootstrap-acl-token" not found`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			var token string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				token = r.Header.Get("X-Consul-Token")
+				fmt.Fprint(w, `"10.0.0.1:8300"`)
+			})
+			consul := httptest.NewUnstartedServer(handler)
+			if c.tls {
+				consul.StartTLS()
+				ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: consul.Certificate().Raw})
+				c.objects = append(c.objects, secret("hashi-ca-cert", "tls.crt", string(ca)))
+			} else {
+				consul.Start()
+			}
+			defer consul.Close()
+
+			var forwarded []interface{}
+			helmClient, err := helm.NewTestClient(nil, helm.TestRelease{
+				Name:      "consul",
+				Namespace: "mesh",
+				Chart:     "consul-0.24.1",
+				Values:    c.values,
+			})
+			require.NoError(t, err)
+			server := &Server{
+				Helm:       helmClient,
+				KubeClient: fake.NewSimpleClientset(c.objects...),
+				Forward: func(namespace, name string, remotePort int) (string, func(), error) {
+					forwarded = []interface{}{namespace, name, remotePort}
+					return consul.Listener.Addr().String(), func() {}, nil
+				},
+				Token: c.token,
+			}
+			client, pod, closeForward, err := server.Connect()
+			if c.expErr != "" {
+				require.EqualError(t, err, c.expErr)
+				require.Nil(t, forwarded)
+				return
+			}
+			require.NoError(t, err)
+			defer closeForward()
+			require.Equal(t, c.expPod, pod)
+			require.Equal(t, []interface{}{"mesh", c.expPod, c.expPort}, forwarded)
+
+			leader, err := client.Status().Leader()
+			require.NoError(t, err)
+			require.Equal(t, "10.0.0.1:8300", leader)
+			require.Equal(t, c.expToken, token)
+		})
+	}
+}
+
+func serverPod(name string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "mesh",
+			Labels:    map[string]string{"app": "consul", "release": "consul", "component": "server"},
+		},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}},
+	}
+}
+
+func secret(name, key, value string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "mesh"},
+		Data:       map[string][]byte{key: []byte(value)},
+	}
+}
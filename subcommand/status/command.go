@@ -0,0 +1,264 @@
+package status
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command reports the status of the installation of the Consul Helm chart.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagSince time.Duration
+
+	helm       *helm.Client
+	kubeClient kubernetes.Interface
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.DurationVar(&c.flagSince, "since", time.Hour,
+		"How far back to report the failures of the Consul pods.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+
+	if c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.helm == nil {
+		c.helm = &helm.Client{KubeConfig: c.k8s.KubeConfig()}
+	}
+
+	release, err := c.helm.Release()
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	details, err := c.helm.Get(release.Name, release.Namespace)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error getting the release: %s", err))
+		return 1
+	}
+	// The values are the ones set by the user, and the chart disables ACLs
+	// and TLS by default.
+	acls, _, _ := unstructured.NestedBool(details.Config, "global", "acls", "manageSystemACLs")
+	tls, _, _ := unstructured.NestedBool(details.Config, "global", "tls", "enabled")
+
+	c.UI.Output("==> Consul installation")
+	c.UI.Output(fmt.Sprintf("    Release:   %s in namespace %s (revision %d, status %s)",
+		release.Name, release.Namespace, release.Revision, release.Status))
+	c.UI.Output(fmt.Sprintf("    Chart:     %s", release.ChartVersion()))
+	c.UI.Output(fmt.Sprintf("    Consul:    %s", release.AppVersion))
+	c.UI.Output(fmt.Sprintf("    ACLs:      %s", enabled(acls)))
+	c.UI.Output(fmt.Sprintf("    TLS:       %s", enabled(tls)))
+
+	c.UI.Output("\n==> Health")
+	if err := c.health(release); err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+
+	c.UI.Output(fmt.Sprintf("\n==> Failures in the last %s", c.flagSince))
+	failures, err := c.failures(release, time.Now().Add(-c.flagSince))
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	if len(failures) == 0 {
+		c.UI.Output("    No failures")
+	}
+	for _, failure := range failures {
+		c.UI.Error(" ✗ " + failure)
+	}
+	return 0
+}
+
+// selector selects the resources of component of release, as labeled by
+// the chart.
+func selector(release helm.Release, component string) metav1.ListOptions {
+	s := fmt.Sprintf("app=consul,release=%s", release.Name)
+	if component != "" {
+		s += ",component=" + component
+	}
+	return metav1.ListOptions{LabelSelector: s}
+}
+
+// health reports how many pods of the servers and clients of release are
+// ready.
+func (c *Command) health(release helm.Release) error {
+	apps := c.kubeClient.AppsV1()
+	servers, err := apps.StatefulSets(release.Namespace).List(selector(release, "server"))
+	if err != nil {
+		return fmt.Errorf("listing the stateful sets: %s", err)
+	}
+	var ready, desired int32
+	for _, set := range servers.Items {
+		ready += set.Status.ReadyReplicas
+		if set.Spec.Replicas != nil {
+			desired += *set.Spec.Replicas
+		}
+	}
+	c.readiness("Servers", len(servers.Items) > 0, ready, desired)
+
+	clients, err := apps.DaemonSets(release.Namespace).List(selector(release, "client"))
+	if err != nil {
+		return fmt.Errorf("listing the daemon sets: %s", err)
+	}
+	ready, desired = 0, 0
+	for _, set := range clients.Items {
+		ready += set.Status.NumberReady
+		desired += set.Status.DesiredNumberScheduled
+	}
+	c.readiness("Clients", len(clients.Items) > 0, ready, desired)
+	return nil
+}
+
+func (c *Command) readiness(name string, deployed bool, ready, desired int32) {
+	switch {
+	case !deployed:
+		c.UI.Output(fmt.Sprintf("    %s: not deployed by the release", name))
+	case ready < desired:
+		c.UI.Error(fmt.Sprintf(" ✗ %s: %d/%d ready", name, ready, desired))
+	default:
+		c.UI.Output(fmt.Sprintf(" ✓ %s: %d/%d ready", name, ready, desired))
+	}
+}
+
+// failures returns the failures since since of the pods of release: the
+// containers waiting to restart or that terminated with an error, and the
+// warning events of the pods.
+func (c *Command) failures(release helm.Release, since time.Time) ([]string, error) {
+	core := c.kubeClient.CoreV1()
+	pods, err := core.Pods(release.Namespace).List(selector(release, ""))
+	if err != nil {
+		return nil, fmt.Errorf("listing the pods: %s", err)
+	}
+	var failures []string
+	podNames := make(map[string]bool)
+	for _, pod := range pods.Items {
+		podNames[pod.Name] = true
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			if failure := containerFailure(status, since); failure != "" {
+				failures = append(failures, fmt.Sprintf("Pod %s, container %s: %s", pod.Name, status.Name, failure))
+			}
+		}
+	}
+
+	events, err := core.Events(release.Namespace).List(metav1.ListOptions{FieldSelector: "type=Warning"})
+	if err != nil {
+		return nil, fmt.Errorf("listing the events: %s", err)
+	}
+	for _, event := range events.Items {
+		if event.Type != corev1.EventTypeWarning || event.InvolvedObject.Kind != "Pod" ||
+			!podNames[event.InvolvedObject.Name] || event.LastTimestamp.Time.Before(since) {
+			continue
+		}
+		failure := fmt.Sprintf("Pod %s: %s: %s", event.InvolvedObject.Name, event.Reason, strings.TrimSpace(event.Message))
+		if event.Count > 1 {
+			failure += fmt.Sprintf(" (%d times)", event.Count)
+		}
+		failures = append(failures, failure)
+	}
+	return failures, nil
+}
+
+// containerFailure describes the failure of the container of status, or is
+// empty if it didn't fail since since.
+func containerFailure(status corev1.ContainerStatus, since time.Time) string {
+	var failure string
+	if waiting := status.State.Waiting; waiting != nil && waiting.Reason != "" &&
+		waiting.Reason != "ContainerCreating" && waiting.Reason != "PodInitializing" {
+		failure = waiting.Reason
+	}
+	if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 {
+		failure = exit(terminated)
+	}
+	if last := status.LastTerminationState.Terminated; last != nil && last.ExitCode != 0 && !last.FinishedAt.Time.Before(since) {
+		if failure == "" {
+			failure = "restarted"
+		}
+		failure += ", last exit: " + exit(last)
+	}
+	if failure != "" && status.RestartCount > 0 {
+		failure += fmt.Sprintf(" (%d restarts)", status.RestartCount)
+	}
+	return failure
+}
+
+func exit(state *corev1.ContainerStateTerminated) string {
+	return fmt.Sprintf("%s (exit code %d)", state.Reason, state.ExitCode)
+}
+
+func enabled(b bool) string {
+	if b {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Show the status of Consul on Kubernetes"
+const help = `
+Usage: consul-k8s status [options]
+
+  Shows the status of the installation of the Consul Helm chart: the
+  versions of the chart and of Consul, whether ACLs and TLS are enabled,
+  how many servers and clients are ready, and the recent failures of the
+  Consul pods, i.e. the containers crashing or that failed and the warning
+  events of the pods. The helm binary (Helm 3) must be installed.
+
+`
@@ -0,0 +1,179 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 1, cmd.Run([]string{"foo"}))
+	require.Contains(t, ui.ErrorWriter.String(), "Should have no non-flag arguments.")
+}
+
+func TestRun_NoInstallation(t *testing.T) {
+	client, err := helm.NewTestClient(nil)
+	require.NoError(t, err)
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:         ui,
+		kubeClient: fake.NewSimpleClientset(),
+		helm:       client,
+	}
+	require.Equal(t, 1, cmd.Run(nil))
+	require.Contains(t, ui.ErrorWriter.String(), "no installation of Consul found")
+}
+
+func TestRun_Status(t *testing.T) {
+	now := time.Now()
+	crashing := pod("consul-server-0", corev1.ContainerStatus{
+		Name:         "consul",
+		RestartCount: 4,
+		State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			Reason:     "Error",
+			ExitCode:   1,
+			FinishedAt: metav1.NewTime(now.Add(-time.Minute)),
+		}},
+	})
+	recovered := pod("consul-client-abcde", corev1.ContainerStatus{
+		Name:         "consul",
+		RestartCount: 1,
+		State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+		LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			Reason:     "OOMKilled",
+			ExitCode:   137,
+			FinishedAt: metav1.NewTime(now.Add(-2 * time.Hour)),
+		}},
+	})
+
+	cases := map[string]struct {
+		values    string
+		objects   []runtime.Object
+		expOutput []string
+		expErrors []string
+	}{
+		"healthy": {
+			values: `{"global":{"acls":{"manageSystemACLs":true}}}`,
+			objects: []runtime.Object{
+				statefulSet("consul-server", 3, 3),
+				daemonSet("consul-client", 2, 2),
+				recovered,
+			},
+			expOutput: []string{
+				"Release:   consul in namespace mesh (revision 2, status deployed)",
+				"Chart:     0.24.1",
+				"Consul:    1.8.0",
+				"ACLs:      enabled",
+				"TLS:       disabled",
+				" ✓ Servers: 3/3 ready",
+				" ✓ Clients: 2/2 ready",
+				"==> Failures in the last 1h0m0s\n    No failures",
+			},
+		},
+		"unhealthy": {
+			values: `{"global":{"tls":{"enabled":true}}}`,
+			objects: []runtime.Object{
+				statefulSet("consul-server", 2, 3),
+				crashing,
+				&corev1.Event{
+					ObjectMeta:     metav1.ObjectMeta{Name: "consul-server-0.1", Namespace: "mesh"},
+					InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "consul-server-0"},
+					Type:           corev1.EventTypeWarning,
+					Reason:         "Unhealthy",
+					Message:        "Readiness probe failed",
+					Count:          12,
+					LastTimestamp:  metav1.NewTime(now.Add(-time.Minute)),
+				},
+				&corev1.Event{
+					ObjectMeta:     metav1.ObjectMeta{Name: "postgres-0.1", Namespace: "mesh"},
+					InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "postgres-0"},
+					Type:           corev1.EventTypeWarning,
+					Reason:         "Unhealthy",
+					Message:        "Liveness probe failed",
+					LastTimestamp:  metav1.NewTime(now.Add(-time.Minute)),
+				},
+			},
+			expOutput: []string{
+				"ACLs:      disabled",
+				"TLS:       enabled",
+				"Clients: not deployed by the release",
+			},
+			expErrors: []string{
+				" ✗ Servers: 2/3 ready",
+				" ✗ Pod consul-server-0, container consul: CrashLoopBackOff, last exit: Error (exit code 1) (4 restarts)",
+				" ✗ Pod consul-server-0: Unhealthy: Readiness probe failed (12 times)",
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, err := helm.NewTestClient(nil, helm.TestRelease{
+				Name:       "consul",
+				Namespace:  "mesh",
+				Revision:   2,
+				Chart:      "consul-0.24.1",
+				AppVersion: "1.8.0",
+				Values:     c.values,
+			})
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:         ui,
+				kubeClient: fake.NewSimpleClientset(c.objects...),
+				helm:       client,
+			}
+
+			require.Equal(t, 0, cmd.Run(nil), ui.ErrorWriter.String())
+			output := ui.OutputWriter.String()
+			for _, exp := range c.expOutput {
+				require.Contains(t, output, exp)
+			}
+			errs := ui.ErrorWriter.String()
+			for _, exp := range c.expErrors {
+				require.Contains(t, errs, exp)
+			}
+			require.NotContains(t, errs, "postgres")
+			if len(c.expErrors) == 0 {
+				require.Empty(t, errs)
+			}
+		})
+	}
+}
+
+func labels(component string) map[string]string {
+	return map[string]string{"app": "consul", "release": "consul", "component": component}
+}
+
+func statefulSet(name string, ready, replicas int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "mesh", Labels: labels("server")},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status:     appsv1.StatefulSetStatus{ReadyReplicas: ready},
+	}
+}
+
+func daemonSet(name string, ready, desired int32) *appsv1.DaemonSet {
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "mesh", Labels: labels("client")},
+		Status:     appsv1.DaemonSetStatus{NumberReady: ready, DesiredNumberScheduled: desired},
+	}
+}
+
+func pod(name string, status corev1.ContainerStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "mesh", Labels: labels("server")},
+		Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{status}},
+	}
+}
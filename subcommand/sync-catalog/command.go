@@ -8,12 +8,16 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/deckarep/golang-set"
 	catalogtoconsul "github.com/hashicorp/consul-k8s/catalog/to-consul"
 	catalogtok8s "github.com/hashicorp/consul-k8s/catalog/to-k8s"
+	"github.com/hashicorp/consul-k8s/helper/consul"
 	"github.com/hashicorp/consul-k8s/helper/controller"
+	"github.com/hashicorp/consul-k8s/helper/leader"
+	"github.com/hashicorp/consul-k8s/helper/metrics"
 	"github.com/hashicorp/consul-k8s/subcommand"
 	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
 	"github.com/hashicorp/consul/api"
@@ -23,6 +27,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/record"
 )
 
 // Command is the command for syncing the K8S and Consul service
@@ -31,9 +36,17 @@ type Command struct {
 	UI cli.Ui
 
 	flags                     *flag.FlagSet
+	configFile                *k8sflags.ConfigFileFlags
 	http                      *flags.HTTPFlags
 	k8s                       *k8sflags.K8SFlags
+	logging                   *k8sflags.LogFlags
+	audit                     *k8sflags.AuditFlags
+	writeLimit                *k8sflags.WriteLimitFlags
+	leaderElection            *k8sflags.LeaderElectionFlags
 	flagListen                string
+	flagMetricsAddr           string
+	flagEnablePprof           bool
+	flagEmitEvents            bool
 	flagToConsul              bool
 	flagToK8S                 bool
 	flagConsulDomain          string
@@ -47,7 +60,6 @@ type Command struct {
 	flagSyncClusterIPServices bool
 	flagNodePortSyncType      string
 	flagAddK8SNamespaceSuffix bool
-	flagLogLevel              string
 
 	// Flags to support namespaces
 	flagEnableNamespaces           bool     // Use namespacing on all components
@@ -58,8 +70,8 @@ type Command struct {
 	flagK8SNSMirroringPrefix       string   // Prefix added to Consul namespaces created when mirroring
 	flagCrossNamespaceACLPolicy    string   // The name of the ACL policy to add to every created namespace if ACLs are enabled
 
-	consulClient *api.Client
-	clientset    kubernetes.Interface
+	consulClient  *api.Client
+	clientset     kubernetes.Interface
 
 	once   sync.Once
 	sigCh  chan os.Signal
@@ -69,7 +81,16 @@ type Command struct {
 
 func (c *Command) init() {
 	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
-	c.flags.StringVar(&c.flagListen, "listen", ":8080", "Address to bind listener to.")
+	c.flags.StringVar(&c.flagListen, "listen", ":8080",
+		"Address to serve the /live and /ready endpoints on. /health/ready is an alias of /ready.")
+	c.flags.StringVar(&c.flagMetricsAddr, "metrics-addr", "",
+		"Address to serve the Prometheus metrics on at /metrics, e.g. \":9102\". If blank, metrics are disabled.")
+	c.flags.BoolVar(&c.flagEnablePprof, "enable-pprof", false,
+		"If true, the pprof profiles are served on /debug/pprof/ on -metrics-addr, e.g. to profile the memory "+
+			"and CPU usage in place. Requires -metrics-addr.")
+	c.flags.BoolVar(&c.flagEmitEvents, "emit-events", false,
+		"If true, the failures to register the services in Consul are recorded as events on their "+
+			"Kubernetes Services with the reason ConsulSyncFailed. Requires permission to create events.")
 	c.flags.BoolVar(&c.flagToConsul, "to-consul", true,
 		"If true, K8S services will be synced to Consul.")
 	c.flags.BoolVar(&c.flagToK8S, "to-k8s", true,
@@ -109,9 +130,6 @@ func (c *Command) init() {
 		"If true, Kubernetes namespace will be appended to service names synced to Consul separated by a dash. "+
 			"If false, no suffix will be appended to the service names in Consul. "+
 			"If the service name annotation is provided, the suffix is not appended.")
-	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
-		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
-			"\"debug\", \"info\", \"warn\", and \"error\".")
 	c.flags.Var((*flags.AppendSliceValue)(&c.flagAllowK8sNamespacesList), "allow-k8s-namespace",
 		"K8s namespaces to explicitly allow. May be specified multiple times.")
 	c.flags.Var((*flags.AppendSliceValue)(&c.flagDenyK8sNamespacesList), "deny-k8s-namespace",
@@ -134,7 +152,20 @@ func (c *Command) init() {
 	flags.Merge(c.flags, c.http.ClientFlags())
 	flags.Merge(c.flags, c.http.ServerFlags())
 	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.audit = &k8sflags.AuditFlags{}
+	flags.Merge(c.flags, c.audit.Flags())
+	c.writeLimit = &k8sflags.WriteLimitFlags{}
+	flags.Merge(c.flags, c.writeLimit.Flags())
+	c.leaderElection = &k8sflags.LeaderElectionFlags{
+		DefaultID:       "consul-k8s-sync-catalog-leader",
+		DefaultLockType: leader.LeasesLock,
+	}
+	flags.Merge(c.flags, c.leaderElection.Flags())
 
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
 	c.help = flags.Usage(help, c.flags)
 
 	// Wait on an interrupt to exit. This channel must be initialized before
@@ -142,7 +173,7 @@ func (c *Command) init() {
 	// is not defined.
 	if c.sigCh == nil {
 		c.sigCh = make(chan os.Signal, 1)
-		signal.Notify(c.sigCh, os.Interrupt)
+		signal.Notify(c.sigCh, os.Interrupt, syscall.SIGTERM)
 	}
 }
 
@@ -151,10 +182,22 @@ func (c *Command) Run(args []string) int {
 	if err := c.flags.Parse(args); err != nil {
 		return 1
 	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 	if len(c.flags.Args()) > 0 {
 		c.UI.Error(fmt.Sprintf("Should have no non-flag arguments."))
 		return 1
 	}
+	if c.flagEnablePprof && c.flagMetricsAddr == "" {
+		c.UI.Error("-metrics-addr must be set if -enable-pprof is set")
+		return 1
+	}
+	if err := c.leaderElection.Validate(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
 
 	// Create the k8s clientset
 	if c.clientset == nil {
@@ -173,8 +216,10 @@ func (c *Command) Run(args []string) int {
 
 	// Setup Consul client
 	if c.consulClient == nil {
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
 		var err error
-		c.consulClient, err = c.http.APIClient()
+		c.consulClient, err = consul.NewClient(cfg)
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
 			return 1
@@ -183,15 +228,32 @@ func (c *Command) Run(args []string) int {
 
 	// Set up logging
 	if c.logger == nil {
-		level := hclog.LevelFromString(c.flagLogLevel)
-		if level == hclog.NoLevel {
-			c.UI.Error(fmt.Sprintf("Unknown log level: %s", c.flagLogLevel))
+		var err error
+		c.logger, err = c.logging.Logger()
+		if err != nil {
+			c.UI.Error(err.Error())
 			return 1
 		}
-		c.logger = hclog.New(&hclog.LoggerOptions{
-			Level:  level,
-			Output: os.Stderr,
-		})
+	}
+
+	stopAudit, err := c.audit.Start(c.logger.Named("audit"), "consul-k8s-sync-catalog")
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error opening the audit log: %s", err))
+		return 1
+	}
+	defer stopAudit()
+	if err := c.writeLimit.Limit(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.flagMetricsAddr != "" {
+		server, err := metrics.ListenAndServe(c.logger, c.flagMetricsAddr, c.flagEnablePprof)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error serving metrics: %s", err))
+			return 1
+		}
+		defer server.Close()
 	}
 
 	// Get the sync interval
@@ -218,9 +280,62 @@ func (c *Command) Run(args []string) int {
 
 	// Create the context we'll use to cancel everything
 	ctx, cancelF := context.WithCancel(context.Background())
+	defer cancelF()
+
+	var recorder record.EventRecorder
+	if c.flagEmitEvents {
+		var stopRecorder func()
+		recorder, stopRecorder = subcommand.EventRecorder(c.clientset, "consul-k8s-sync-catalog")
+		defer stopRecorder()
+	}
+
+	// Start healthcheck handler. The replicas waiting for the leader lock
+	// serve it too.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live", c.handleLive)
+	mux.HandleFunc("/ready", c.handleReady)
+	mux.HandleFunc("/health/ready", c.handleReady)
+	server := &http.Server{Addr: c.flagListen, Handler: mux}
+	defer server.Close()
+	go func() {
+		c.logger.Info("listening", "addr", c.flagListen)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			c.logger.Error("error listening", "addr", c.flagListen, "err", err)
+		}
+	}()
+
+	// If leader election is enabled, only sync once this replica holds the
+	// leader lock, and exit once it's lost so that the syncs don't run next
+	// to the new leader's.
+	var lostLeaderCh chan struct{}
+	if c.leaderElection.Enabled() {
+		lock, err := c.leaderElection.Lock(c.clientset, recorder)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error creating the leader lock: %s", err))
+			return 1
+		}
+		leadingCh := make(chan struct{})
+		lostLeaderCh = make(chan struct{})
+		err = leader.Start(leader.Config{
+			Log:              c.logger.Named("leader"),
+			Lock:             lock,
+			OnStartedLeading: func(<-chan struct{}) { close(leadingCh) },
+			OnStoppedLeading: func() { close(lostLeaderCh) },
+		}, ctx.Done())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error starting the leader election: %s", err))
+			return 1
+		}
+		select {
+		case <-leadingCh:
+		case <-c.sigCh:
+			c.logger.Info("shutting down")
+			return 0
+		}
+	}
 
 	// Start the K8S-to-Consul syncer
-	var toConsulCh chan struct{}
+	var toConsulCh, syncerCh chan struct{}
 	if c.flagToConsul {
 		// If namespaces are enabled we need to use a new Consul API endpoint
 		// to list node services. This endpoint is only available in Consul
@@ -246,12 +361,18 @@ func (c *Command) Run(args []string) int {
 			ServicePollPeriod:        syncInterval * 2,
 			ConsulK8STag:             c.flagConsulK8STag,
 			ConsulNodeServicesClient: svcsClient,
+			EventRecorder:            recorder,
 		}
-		go syncer.Run(ctx)
+		syncerCh = make(chan struct{})
+		go func() {
+			defer close(syncerCh)
+			syncer.Run(ctx)
+		}()
 
 		// Build the controller and start it
 		ctl := &controller.Controller{
-			Log: c.logger.Named("to-consul/controller"),
+			Log:  c.logger.Named("to-consul/controller"),
+			Name: "to-consul",
 			Resource: &catalogtoconsul.ServiceResource{
 				Log:                        c.logger.Named("to-consul/source"),
 				Client:                     c.clientset,
@@ -300,6 +421,7 @@ func (c *Command) Run(args []string) int {
 		// Build the controller and start it
 		ctl := &controller.Controller{
 			Log:      c.logger.Named("to-k8s/controller"),
+			Name:     "to-k8s",
 			Resource: sink,
 		}
 
@@ -310,18 +432,6 @@ func (c *Command) Run(args []string) int {
 		}()
 	}
 
-	// Start healthcheck handler
-	go func() {
-		mux := http.NewServeMux()
-		mux.HandleFunc("/health/ready", c.handleReady)
-		var handler http.Handler = mux
-
-		c.UI.Info(fmt.Sprintf("Listening on %q...", c.flagListen))
-		if err := http.ListenAndServe(c.flagListen, handler); err != nil {
-			c.UI.Error(fmt.Sprintf("Error listening: %s", err))
-		}
-	}()
-
 	select {
 	// Unexpected exit
 	case <-toConsulCh:
@@ -339,8 +449,26 @@ func (c *Command) Run(args []string) int {
 		}
 		return 1
 
-	// Interrupted, gracefully exit
+	// Lost the leader lock, exit once the in-flight writes to Consul are
+	// done so that the pod is restarted as a standby.
+	case <-lostLeaderCh:
+		cancelF()
+		if toConsulCh != nil {
+			<-toConsulCh
+		}
+		if toK8SCh != nil {
+			<-toK8SCh
+		}
+		if syncerCh != nil {
+			subcommand.Wait(syncerCh)
+		}
+		return 1
+
+	// Interrupted, gracefully exit once the in-flight writes to Consul
+	// are done. The synced services aren't deregistered since the next
+	// sync-catalog syncs them again.
 	case <-c.sigCh:
+		c.logger.Info("shutting down")
 		cancelF()
 		if toConsulCh != nil {
 			<-toConsulCh
@@ -348,16 +476,27 @@ func (c *Command) Run(args []string) int {
 		if toK8SCh != nil {
 			<-toK8SCh
 		}
+		if syncerCh != nil && !subcommand.Wait(syncerCh) {
+			c.logger.Error("timed out waiting for the sync to Consul to stop")
+			return 1
+		}
 		return 0
 	}
 }
 
+// handleLive reports that the process is running. Like the lifecycle
+// sidecar's, it doesn't depend on Consul since a restart can't fix an
+// unavailable cluster.
+func (c *Command) handleLive(rw http.ResponseWriter, req *http.Request) {
+	rw.WriteHeader(204)
+}
+
 func (c *Command) handleReady(rw http.ResponseWriter, req *http.Request) {
 	// The main readiness check is whether sync can talk to
 	// the consul cluster, in this case querying for the leader
 	_, err := c.consulClient.Status().Leader()
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("[GET /health/ready] Error getting leader status: %s", err))
+		c.logger.Error("error getting leader status", "path", req.URL.Path, "err", err)
 		rw.WriteHeader(500)
 		return
 	}
@@ -385,4 +524,11 @@ Usage: consul-k8s sync-catalog [options]
   services, and allows external services to discover and communicate with
   K8S services.
 
+  If -enable-leader-election is set, only the replica holding the Lease
+  -leader-election-id in -leader-election-namespace syncs, so that
+  sync-catalog can run with several replicas. The others serve the health
+  endpoints and take over once the leader stops renewing the Lease. A
+  replica that loses it exits. consul_k8s_leader_election_leader reports
+  the leader on -metrics-addr.
+
 `
@@ -13,6 +13,7 @@ import (
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 )
 
 // Test that the default consul service is synced to k8s
@@ -320,6 +321,59 @@ func TestRun_ToConsulAllowDenyLists(t *testing.T) {
 	}
 }
 
+// Test that a replica waiting for the leader lock doesn't sync.
+func TestRun_LeaderElection_Standby(t *testing.T) {
+	t.Parallel()
+
+	k8s, testServer := completeSetup(t)
+	defer testServer.Stop()
+
+	// The lock is held by another replica.
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := k8s.CoreV1().ConfigMaps(metav1.NamespaceDefault).Create(&apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "consul-k8s-sync-catalog-leader",
+			Annotations: map[string]string{
+				resourcelock.LeaderElectionRecordAnnotationKey: `{"holderIdentity":"sync-catalog-0","leaseDurationSeconds":3600,` +
+					`"acquireTime":"` + now + `","renewTime":"` + now + `","leaderTransitions":0}`,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+		logger: hclog.New(&hclog.LoggerOptions{
+			Name:  t.Name(),
+			Level: hclog.Debug,
+		}),
+	}
+	exitChan := runCommandAsynchronously(&cmd, []string{
+		"-http-addr", testServer.HTTPAddr,
+		"-enable-leader-election",
+		"-leader-election-namespace", metav1.NamespaceDefault,
+		"-leader-election-lock-type", "configmaps",
+	})
+	defer stopCommand(t, &cmd, exitChan)
+
+	// The leader would sync the consul service to Kubernetes.
+	time.Sleep(2 * time.Second)
+	serviceList, err := k8s.CoreV1().Services(metav1.NamespaceDefault).List(metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, serviceList.Items)
+	require.Empty(t, exitChan)
+}
+
+func TestRun_LeaderElection_FlagValidation(t *testing.T) {
+	t.Parallel()
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 1, cmd.Run([]string{"-enable-leader-election"}))
+	require.Contains(t, ui.ErrorWriter.String(), "-leader-election-namespace must be set if -enable-leader-election is set")
+}
+
 // Test that when flags are changed and the command re-run, old services
 // are deleted and new services are created where expected.
 func TestRun_ToConsulChangingFlags(t *testing.T) {
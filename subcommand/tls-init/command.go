@@ -0,0 +1,371 @@
+package tlsinit
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/cert"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// tls-init command generates or loads a CA and issues the Consul servers'
+// TLS certificate, storing both as Kubernetes secrets.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+	logging    *k8sflags.LogFlags
+
+	flagK8sNamespace          string
+	flagResourcePrefix        string
+	flagCACertFile            string
+	flagCAKeyFile             string
+	flagDomain                string
+	flagDatacenter            string
+	flagAdditionalDNSNames    []string
+	flagAdditionalIPs         []string
+	flagServerCertExpiry      time.Duration
+	flagServerCertRenewBefore time.Duration
+
+	// clientset can be set by tests.
+	clientset kubernetes.Interface
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of Kubernetes namespace where the servers are deployed and the secrets are stored.")
+	c.flags.StringVar(&c.flagResourcePrefix, "resource-prefix", "",
+		"Prefix to use for the Kubernetes secrets and the servers' service, e.g. \"consul\" "+
+			"if the servers' service is \"consul-server\".")
+	c.flags.StringVar(&c.flagCACertFile, "ca-cert-file", "",
+		"Path to a PEM-encoded CA certificate to issue the server certificate with. "+
+			"If not set, the CA stored in the CA secrets is used, or a new CA is generated if they don't exist.")
+	c.flags.StringVar(&c.flagCAKeyFile, "ca-key-file", "",
+		"Path to the PEM-encoded private key of -ca-cert-file.")
+	c.flags.StringVar(&c.flagDomain, "domain", "consul",
+		"Consul's DNS domain.")
+	c.flags.StringVar(&c.flagDatacenter, "datacenter", "dc1",
+		"Name of the datacenter of the servers.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagAdditionalDNSNames), "additional-dnsname",
+		"Additional DNS name to add to the server certificate's SANs. May be specified multiple times.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagAdditionalIPs), "additional-ipaddress",
+		"Additional IP address to add to the server certificate's SANs. May be specified multiple times.")
+	c.flags.DurationVar(&c.flagServerCertExpiry, "server-cert-expiry", 365*24*time.Hour,
+		"How long the server certificate is valid for.")
+	c.flags.DurationVar(&c.flagServerCertRenewBefore, "server-cert-renew-before", 30*24*time.Hour,
+		"The existing server certificate is reissued if it expires within this duration.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.logging = &k8sflags.LogFlags{}
+	flags.Merge(c.flags, c.logging.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+// Run ensures that the CA and server certificate secrets exist and that the
+// server certificate is valid for the expected SANs and is not about to
+// expire. It's meant to be re-run, e.g. on every Helm upgrade, so that the
+// server certificate is rotated before it expires.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.validateFlags(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	logger, err := c.logging.Logger()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	caCert, caSigner, err := c.ensureCA(logger)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error setting up CA: %s", err))
+		return 1
+	}
+
+	if err := c.ensureServerCert(logger, caCert, caSigner); err != nil {
+		c.UI.Error(fmt.Sprintf("Error setting up server certificate: %s", err))
+		return 1
+	}
+
+	c.UI.Info(fmt.Sprintf("Server certificate is stored in secret %q", c.serverCertSecretName()))
+	return 0
+}
+
+func (c *Command) validateFlags(args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		return err
+	}
+	if len(c.flags.Args()) > 0 {
+		return errors.New("Should have no non-flag arguments.")
+	}
+	if c.flagK8sNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagResourcePrefix == "" {
+		return errors.New("-resource-prefix must be set")
+	}
+	if (c.flagCACertFile == "") != (c.flagCAKeyFile == "") {
+		return errors.New("-ca-cert-file and -ca-key-file must be set together")
+	}
+	for _, ip := range c.flagAdditionalIPs {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("-additional-ipaddress %q is not a valid IP address", ip)
+		}
+	}
+	if c.flagServerCertExpiry <= c.flagServerCertRenewBefore {
+		return errors.New("-server-cert-expiry must be greater than -server-cert-renew-before")
+	}
+	return nil
+}
+
+// ensureCA returns the CA to issue the server certificate with.
+// The CA is loaded from -ca-cert-file and -ca-key-file if set, otherwise
+// from the CA secrets. If those don't exist, a new CA is generated and
+// stored in them. The CA certificate secret is always kept up to date
+// so that clients can use it to verify the servers.
+func (c *Command) ensureCA(logger hclog.Logger) (*x509.Certificate, crypto.Signer, error) {
+	var caCertPEM, caKeyPEM []byte
+	if c.flagCACertFile != "" {
+		var err error
+		caCertPEM, err = ioutil.ReadFile(c.flagCACertFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		caKeyPEM, err = ioutil.ReadFile(c.flagCAKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		logger.Info("Using CA from files", "ca-cert-file", c.flagCACertFile)
+	} else {
+		var err error
+		caCertPEM, err = c.secretData(c.caCertSecretName(), apiv1.TLSCertKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		caKeyPEM, err = c.secretData(c.caKeySecretName(), apiv1.TLSPrivateKeyKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if caCertPEM == nil || caKeyPEM == nil {
+			logger.Info("Generating CA")
+			_, keyPEM, certPEM, _, err := cert.GenerateCA("Consul Agent CA")
+			if err != nil {
+				return nil, nil, err
+			}
+			caCertPEM, caKeyPEM = []byte(certPEM), []byte(keyPEM)
+			err = c.writeSecret(c.caKeySecretName(), apiv1.SecretTypeOpaque, map[string][]byte{
+				apiv1.TLSPrivateKeyKey: caKeyPEM,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+		} else {
+			logger.Info("Using CA from secrets", "secret", c.caCertSecretName())
+		}
+	}
+
+	caCert, err := cert.ParseCert(caCertPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing CA certificate: %s", err)
+	}
+	caSigner, err := cert.ParseSigner(caKeyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing CA key: %s", err)
+	}
+
+	err = c.writeSecret(c.caCertSecretName(), apiv1.SecretTypeOpaque, map[string][]byte{
+		apiv1.TLSCertKey: caCertPEM,
+	})
+	return caCert, caSigner, err
+}
+
+// ensureServerCert issues a new server certificate unless the existing one
+// is signed by caCert, has all the expected SANs and doesn't expire within
+// -server-cert-renew-before.
+func (c *Command) ensureServerCert(logger hclog.Logger, caCert *x509.Certificate, caSigner crypto.Signer) error {
+	hosts := c.serverCertHosts()
+	existingPEM, err := c.secretData(c.serverCertSecretName(), apiv1.TLSCertKey)
+	if err != nil {
+		return err
+	}
+	if existingPEM != nil {
+		reason := c.reissueReason(existingPEM, caCert, hosts)
+		if reason == "" {
+			logger.Info("Existing server certificate is valid", "secret", c.serverCertSecretName())
+			return nil
+		}
+		logger.Info("Reissuing server certificate", "reason", reason)
+	} else {
+		logger.Info("Issuing server certificate")
+	}
+
+	certPEM, keyPEM, err := cert.GenerateServerCert(c.serverName(), c.flagServerCertExpiry, caCert, caSigner, hosts)
+	if err != nil {
+		return err
+	}
+	return c.writeSecret(c.serverCertSecretName(), apiv1.SecretTypeTLS, map[string][]byte{
+		apiv1.TLSCertKey:       []byte(certPEM),
+		apiv1.TLSPrivateKeyKey: []byte(keyPEM),
+	})
+}
+
+// reissueReason returns why the existing server certificate needs to be
+// reissued or an empty string if it can be kept.
+func (c *Command) reissueReason(existingPEM []byte, caCert *x509.Certificate, hosts []string) string {
+	existing, err := cert.ParseCert(existingPEM)
+	if err != nil {
+		return fmt.Sprintf("existing certificate is invalid: %s", err)
+	}
+	if err := existing.CheckSignatureFrom(caCert); err != nil {
+		return "existing certificate is not signed by the CA"
+	}
+	if time.Until(existing.NotAfter) < c.flagServerCertRenewBefore {
+		return fmt.Sprintf("existing certificate expires at %s", existing.NotAfter.Format(time.RFC3339))
+	}
+	for _, h := range hosts {
+		if err := existing.VerifyHostname(h); err != nil {
+			return fmt.Sprintf("existing certificate is not valid for %q", h)
+		}
+	}
+	return ""
+}
+
+// serverCertHosts returns the SANs of the server certificate. The wildcard
+// names match the DNS names of the statefulset's pods, e.g.
+// consul-server-0.consul-server.default.svc.
+func (c *Command) serverCertHosts() []string {
+	svc := fmt.Sprintf("%s-server", c.flagResourcePrefix)
+	hosts := []string{
+		c.serverName(),
+		"localhost",
+		"127.0.0.1",
+		"::1",
+		svc,
+		fmt.Sprintf("%s.%s", svc, c.flagK8sNamespace),
+		fmt.Sprintf("%s.%s.svc", svc, c.flagK8sNamespace),
+		fmt.Sprintf("*.%s", svc),
+		fmt.Sprintf("*.%s.%s", svc, c.flagK8sNamespace),
+		fmt.Sprintf("*.%s.%s.svc", svc, c.flagK8sNamespace),
+	}
+	hosts = append(hosts, c.flagAdditionalDNSNames...)
+	return append(hosts, c.flagAdditionalIPs...)
+}
+
+// serverName is the name Consul agents verify the servers'
+// certificates against if verify_server_hostname is enabled.
+func (c *Command) serverName() string {
+	return fmt.Sprintf("server.%s.%s", c.flagDatacenter, c.flagDomain)
+}
+
+func (c *Command) caCertSecretName() string {
+	return fmt.Sprintf("%s-ca-cert", c.flagResourcePrefix)
+}
+
+func (c *Command) caKeySecretName() string {
+	return fmt.Sprintf("%s-ca-key", c.flagResourcePrefix)
+}
+
+func (c *Command) serverCertSecretName() string {
+	return fmt.Sprintf("%s-server-cert", c.flagResourcePrefix)
+}
+
+// secretData returns the value of key in the secret with the given name,
+// or nil if the secret or the key doesn't exist.
+func (c *Command) secretData(name, key string) ([]byte, error) {
+	secret, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting secret %q: %s", name, err)
+	}
+	return secret.Data[key], nil
+}
+
+// writeSecret creates the secret with the given name or replaces
+// its data if it already exists.
+func (c *Command) writeSecret(name string, secretType apiv1.SecretType, data map[string][]byte) error {
+	secrets := c.clientset.CoreV1().Secrets(c.flagK8sNamespace)
+	secret, err := secrets.Get(name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = secrets.Create(&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Type: secretType,
+			Data: data,
+		})
+	} else if err == nil {
+		secret.Data = data
+		_, err = secrets.Update(secret)
+	}
+	if err != nil {
+		return fmt.Errorf("writing secret %q: %s", name, err)
+	}
+	return nil
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Generate the Consul servers' TLS certificates"
+const help = `
+Usage: consul-k8s tls-init [options]
+
+  Generates a CA, or loads it from -ca-cert-file and -ca-key-file, and
+  issues a TLS certificate for the Consul servers. The CA and the server
+  certificate are stored as Kubernetes secrets:
+
+    <prefix>-ca-cert      CA certificate
+    <prefix>-ca-key       CA private key, if the CA was generated
+    <prefix>-server-cert  server certificate and private key
+
+  On re-runs, the server certificate is reissued if it expires within
+  -server-cert-renew-before, isn't signed by the CA or is missing SANs.
+
+`
@@ -0,0 +1,199 @@
+package tlsinit
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/cert"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const ns = "default"
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		Flags  []string
+		ExpErr string
+	}{
+		{
+			Flags:  []string{},
+			ExpErr: "-k8s-namespace must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns},
+			ExpErr: "-resource-prefix must be set",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-resource-prefix", "consul", "-ca-cert-file", "ca.pem"},
+			ExpErr: "-ca-cert-file and -ca-key-file must be set together",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-resource-prefix", "consul", "-additional-ipaddress", "foo"},
+			ExpErr: "-additional-ipaddress \"foo\" is not a valid IP address",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-resource-prefix", "consul", "-server-cert-expiry", "24h"},
+			ExpErr: "-server-cert-expiry must be greater than -server-cert-renew-before",
+		},
+		{
+			Flags:  []string{"-k8s-namespace", ns, "-resource-prefix", "consul", "-log-level", "invalid"},
+			ExpErr: "Unknown log level: invalid",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ExpErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:        ui,
+				clientset: fake.NewSimpleClientset(),
+			}
+			responseCode := cmd.Run(c.Flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.ExpErr)
+		})
+	}
+}
+
+// Test that the CA and the server certificate are generated
+// and stored in secrets.
+func TestRun_GeneratesCAAndServerCert(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset()
+	runCommand(t, k8s, "-additional-dnsname", "consul.example.com", "-additional-ipaddress", "10.0.0.1")
+
+	caCert := getSecret(t, k8s, "consul-ca-cert")
+	require.NotEmpty(t, caCert.Data[apiv1.TLSCertKey])
+	caKey := getSecret(t, k8s, "consul-ca-key")
+	require.NotEmpty(t, caKey.Data[apiv1.TLSPrivateKeyKey])
+
+	serverCert := getSecret(t, k8s, "consul-server-cert")
+	require.Equal(t, apiv1.SecretTypeTLS, serverCert.Type)
+	require.NotEmpty(t, serverCert.Data[apiv1.TLSPrivateKeyKey])
+
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(caCert.Data[apiv1.TLSCertKey]))
+	leaf, err := cert.ParseCert(serverCert.Data[apiv1.TLSCertKey])
+	require.NoError(t, err)
+	require.Contains(t, leaf.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	for _, host := range []string{
+		"server.dc1.consul",
+		"consul-server-0.consul-server.default.svc",
+		"consul-server.default",
+		"consul.example.com",
+		"10.0.0.1",
+		"127.0.0.1",
+		"::1",
+	} {
+		_, err := leaf.Verify(x509.VerifyOptions{
+			DNSName:   host,
+			Roots:     pool,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		require.NoError(t, err, host)
+	}
+}
+
+// Test that re-running the command keeps the CA and a valid server
+// certificate, and reissues the server certificate if it expires
+// soon or is missing SANs.
+func TestRun_Rerun(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		SecondRunFlags []string
+		ExpReissue     bool
+	}{
+		"same flags": {
+			SecondRunFlags: nil,
+			ExpReissue:     false,
+		},
+		"certificate expires soon": {
+			SecondRunFlags: []string{"-server-cert-expiry", "10000h", "-server-cert-renew-before", "9000h"},
+			ExpReissue:     true,
+		},
+		"new SAN": {
+			SecondRunFlags: []string{"-additional-dnsname", "consul.example.com"},
+			ExpReissue:     true,
+		},
+		"new datacenter": {
+			SecondRunFlags: []string{"-datacenter", "dc2"},
+			ExpReissue:     true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8s := fake.NewSimpleClientset()
+			runCommand(t, k8s)
+			caCert := getSecret(t, k8s, "consul-ca-cert").Data[apiv1.TLSCertKey]
+			serverCert := getSecret(t, k8s, "consul-server-cert").Data[apiv1.TLSCertKey]
+
+			runCommand(t, k8s, c.SecondRunFlags...)
+			require.Equal(t, caCert, getSecret(t, k8s, "consul-ca-cert").Data[apiv1.TLSCertKey])
+			newServerCert := getSecret(t, k8s, "consul-server-cert").Data[apiv1.TLSCertKey]
+			if c.ExpReissue {
+				require.NotEqual(t, serverCert, newServerCert)
+			} else {
+				require.Equal(t, serverCert, newServerCert)
+			}
+		})
+	}
+}
+
+// Test that a CA provided by files is used and that the existing server
+// certificate is reissued if it isn't signed by it.
+func TestRun_CAFromFiles(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset()
+	runCommand(t, k8s)
+
+	_, keyPEM, caPEM, _, err := cert.GenerateCA("Consul Agent CA - Test")
+	require.NoError(t, err)
+	caFile := writeTempFile(t, caPEM)
+	defer os.Remove(caFile)
+	keyFile := writeTempFile(t, keyPEM)
+	defer os.Remove(keyFile)
+
+	runCommand(t, k8s, "-ca-cert-file", caFile, "-ca-key-file", keyFile)
+	require.Equal(t, caPEM, string(getSecret(t, k8s, "consul-ca-cert").Data[apiv1.TLSCertKey]))
+
+	caCert, err := cert.ParseCert([]byte(caPEM))
+	require.NoError(t, err)
+	leaf, err := cert.ParseCert(getSecret(t, k8s, "consul-server-cert").Data[apiv1.TLSCertKey])
+	require.NoError(t, err)
+	require.NoError(t, leaf.CheckSignatureFrom(caCert))
+}
+
+func runCommand(t *testing.T, k8s kubernetes.Interface, flags ...string) {
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+	}
+	args := append([]string{"-k8s-namespace", ns, "-resource-prefix", "consul"}, flags...)
+	responseCode := cmd.Run(args)
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+}
+
+func getSecret(t *testing.T, k8s kubernetes.Interface, name string) *apiv1.Secret {
+	secret, err := k8s.CoreV1().Secrets(ns).Get(name, metav1.GetOptions{})
+	require.NoError(t, err)
+	return secret
+}
+
+func writeTempFile(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "tls-init")
+	require.NoError(t, err)
+	_, err = f.WriteString(contents)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	return f.Name()
+}
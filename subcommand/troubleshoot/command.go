@@ -0,0 +1,40 @@
+package troubleshoot
+
+import (
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+)
+
+// Command is the parent of the commands troubleshooting the connectivity
+// of the injected pods.
+type Command struct {
+	UI cli.Ui
+}
+
+func (c *Command) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	return flags.Usage(help, nil)
+}
+
+const synopsis = "Troubleshoot the connectivity of the injected pods"
+const help = `
+Usage: consul-k8s troubleshoot <subcommand> [options] [args]
+
+  Troubleshoots the connectivity of the pods injected by the connect
+  injector to their upstreams, from the configuration of their Envoy
+  sidecar read with kubectl port-forward and from Consul.
+
+  Show the upstreams of a pod and whether their sidecar knows about them:
+
+      $ consul-k8s troubleshoot upstreams -namespace web web-6d4f8c9b7-xk2lp
+
+  Diagnose why a pod can't connect to an upstream:
+
+      $ consul-k8s troubleshoot proxy -namespace web -upstream db web-6d4f8c9b7-xk2lp
+
+  For more examples, ask for subcommand help or view the documentation.
+`
@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/helper/envoy"
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul-k8s/subcommand/proxy"
+	"github.com/hashicorp/consul-k8s/subcommand/troubleshoot"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command diagnoses the connectivity of an injected pod to its upstreams.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagNamespace     string
+	flagUpstream      string
+	flagKubectlBinary string
+
+	kubeClient   kubernetes.Interface
+	forward      portforward.Forwarder
+	consulClient *api.Client
+
+	// now is the time the certificates must be valid at. It defaults to
+	// time.Now and is only set by tests.
+	now func() time.Time
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagNamespace, "namespace", "default",
+		"Kubernetes namespace of the pod.")
+	c.flags.StringVar(&c.flagUpstream, "upstream", "",
+		"Name of the upstream to diagnose, as in the consul.hashicorp.com/connect-service-upstreams annotation. "+
+			"Defaults to all the upstreams of the pod.")
+	c.flags.StringVar(&c.flagKubectlBinary, "kubectl", "kubectl",
+		"Path of the kubectl binary, used to port-forward to the Envoy admin API.")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) != 1 {
+		c.UI.Error("Should have exactly one argument, the name of the pod.")
+		return 1
+	}
+	if c.now == nil {
+		c.now = time.Now
+	}
+
+	if c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.forward == nil {
+		c.forward = portforward.Kubectl(c.flagKubectlBinary, c.k8s.KubeConfig())
+	}
+	if c.consulClient == nil {
+		var err error
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
+		c.consulClient, err = consul.NewClient(cfg)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Consul client: %s", err))
+			return 1
+		}
+	}
+
+	pod, config, err := troubleshoot.EnvoyConfig(c.kubeClient, c.forward, c.flagNamespace, c.flags.Arg(0))
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	service := pod.Annotations[proxy.ServiceAnnotation]
+
+	var upstreams []troubleshoot.Upstream
+	for _, upstream := range troubleshoot.Upstreams(*pod) {
+		if c.flagUpstream == "" || upstream.Name() == c.flagUpstream || upstream.Service == c.flagUpstream {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	if len(upstreams) == 0 {
+		if c.flagUpstream != "" {
+			c.UI.Error(fmt.Sprintf(" ✗ Upstream %q is not in the %s annotation of pod %q",
+				c.flagUpstream, troubleshoot.UpstreamsAnnotation, pod.Name))
+			return 1
+		}
+		c.UI.Output(fmt.Sprintf("Pod %q has no upstreams.", pod.Name))
+		return 0
+	}
+
+	ok := c.checkCertificates(config, service)
+	for _, upstream := range upstreams {
+		c.UI.Output(fmt.Sprintf("\n==> Upstream %s", upstream.Name()))
+		if !c.checkUpstream(config, service, upstream) {
+			ok = false
+		}
+	}
+	if !ok {
+		c.UI.Output("\nFound problems, see the checks marked with ✗.")
+		return 1
+	}
+	c.UI.Output("\nNo problems found.")
+	return 0
+}
+
+// checkCertificates checks that the certificates of the sidecar of service
+// are valid, i.e. that it can connect to its upstreams with mTLS.
+func (c *Command) checkCertificates(config *envoy.Config, service string) bool {
+	c.UI.Output(fmt.Sprintf("==> Certificates of %s", service))
+	now := c.now()
+	var leaf bool
+	ok := true
+	for _, cert := range config.Certificates {
+		name := "The CA certificate"
+		if !cert.CA {
+			name = "The leaf certificate"
+			leaf = true
+		}
+		if !cert.Valid(now) {
+			c.UI.Error(fmt.Sprintf(" ✗ %s (serial %s) is only valid from %s to %s", name, cert.SerialNumber,
+				cert.ValidFrom.Format(time.RFC3339), cert.ExpirationTime.Format(time.RFC3339)))
+			ok = false
+			continue
+		}
+		c.UI.Output(fmt.Sprintf(" ✓ %s (serial %s) is valid until %s", name, cert.SerialNumber,
+			cert.ExpirationTime.Format(time.RFC3339)))
+		if !cert.CA && !hasService(cert, service) {
+			c.UI.Error(fmt.Sprintf(" ✗ The leaf certificate is not for service %s: %s", service,
+				strings.Join(cert.SubjectAltNames, ", ")))
+			ok = false
+		}
+	}
+	if !leaf {
+		c.UI.Error(" ✗ The sidecar has no leaf certificate, it can't connect to its upstreams")
+		ok = false
+	}
+	return ok
+}
+
+// checkUpstream checks that the sidecar of service knows about upstream,
+// that upstream has healthy endpoints, and that the intentions allow
+// service to connect to it.
+func (c *Command) checkUpstream(config *envoy.Config, service string, upstream troubleshoot.Upstream) bool {
+	ok := true
+	if listener := upstream.Listener(config); listener != nil {
+		c.UI.Output(fmt.Sprintf(" ✓ The sidecar listens on %s for the upstream", listener.Address))
+	} else {
+		c.UI.Error(fmt.Sprintf(" ✗ The sidecar has no listener on port %s for the upstream, check the annotation "+
+			"and that the sidecar is synced with consul-k8s proxy list", upstream.LocalPort))
+		ok = false
+	}
+	if upstream.PreparedQuery != "" {
+		c.UI.Output("    Skipping the endpoints and intentions of the prepared query upstream")
+		return ok
+	}
+
+	cluster := upstream.Cluster(config)
+	if cluster == nil {
+		c.UI.Error(fmt.Sprintf(" ✗ The sidecar has no cluster for service %s, check that the service is "+
+			"registered in Consul", upstream.Service))
+		return false
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ The sidecar has the cluster %s", cluster.Name))
+
+	healthy, total := troubleshoot.Endpoints(config, cluster.Name)
+	switch {
+	case total == 0:
+		c.UI.Error(fmt.Sprintf(" ✗ The cluster has no endpoints, check that the instances of %s are "+
+			"registered with a sidecar", upstream.Service))
+		ok = false
+	case healthy == 0:
+		c.UI.Error(fmt.Sprintf(" ✗ None of the %d endpoints of the cluster is healthy, check the health checks "+
+			"of %s", total, upstream.Service))
+		ok = false
+	default:
+		c.UI.Output(fmt.Sprintf(" ✓ %d/%d endpoints of the cluster are healthy", healthy, total))
+	}
+
+	destination := upstream.Service
+	if upstream.Namespace != "" {
+		destination = upstream.Namespace + "/" + upstream.Service
+	}
+	allowed, _, err := c.consulClient.Connect().IntentionCheck(&api.IntentionCheck{
+		Source:      service,
+		Destination: destination,
+		SourceType:  api.IntentionSourceConsul,
+	}, &api.QueryOptions{Datacenter: upstream.Datacenter})
+	switch {
+	case err != nil:
+		c.UI.Error(fmt.Sprintf(" ✗ Error checking the intentions, check the -http-addr and -token flags: %s", err))
+		ok = false
+	case !allowed:
+		c.UI.Error(fmt.Sprintf(" ✗ The intentions deny the connections from %s to %s", service, destination))
+		ok = false
+	default:
+		c.UI.Output(fmt.Sprintf(" ✓ The intentions allow the connections from %s to %s", service, destination))
+	}
+	return ok
+}
+
+// hasService is true if cert is the leaf certificate of service, i.e. its
+// SPIFFE ID ends with /svc/<service>.
+func hasService(cert envoy.Certificate, service string) bool {
+	for _, san := range cert.SubjectAltNames {
+		if strings.HasSuffix(san, "/svc/"+service) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Diagnose the connectivity of an injected pod to its upstreams"
+const help = `
+Usage: consul-k8s troubleshoot proxy [options] <pod>
+
+  Diagnoses why the pod can't connect to its upstreams, or to the upstream
+  of -upstream. For each upstream, it checks that:
+
+    - the Envoy sidecar of the pod listens on the local port of the
+      upstream and has a cluster for its service
+    - the cluster has healthy endpoints
+    - the intentions of Consul allow the connections to the service
+
+  It also checks that the certificates of the sidecar are valid and that
+  the leaf certificate is the one of the service of the pod.
+
+  The configuration of the sidecar is read with kubectl port-forward, and
+  the intentions with the Consul API of the -http-addr flag, e.g. a local
+  port forwarded to the Consul servers. The command exits with 1 if it
+  finds problems.
+
+      $ consul-k8s troubleshoot proxy -namespace web -upstream db web-6d4f8c9b7-xk2lp
+
+`
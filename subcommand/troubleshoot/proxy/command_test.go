@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const configDump = `{"configs":[` +
+	`{"@type":"type.googleapis.com/envoy.admin.v3.ClustersConfigDump","dynamic_active_clusters":[` +
+	`{"cluster":{"name":"db.default.dc1.internal.cluster.consul","type":"EDS"}},` +
+	`{"cluster":{"name":"cache.default.dc1.internal.cluster.consul","type":"EDS"}}]},` +
+	`{"@type":"type.googleapis.com/envoy.admin.v3.ListenersConfigDump","dynamic_listeners":[` +
+	`{"name":"db:127.0.0.1:5432","active_state":{"listener":{"name":"db:127.0.0.1:5432","address":{"socket_address":{"address":"127.0.0.1","port_value":5432}}}}},` +
+	`{"name":"cache:127.0.0.1:6379","active_state":{"listener":{"name":"cache:127.0.0.1:6379","address":{"socket_address":{"address":"127.0.0.1","port_value":6379}}}}}]},` +
+	`{"@type":"type.googleapis.com/envoy.admin.v3.EndpointsConfigDump","dynamic_endpoint_configs":[` +
+	`{"endpoint_config":{"cluster_name":"db.default.dc1.internal.cluster.consul","endpoints":[{"lb_endpoints":[{"endpoint":{"address":{"socket_address":{"address":"10.0.0.7","port_value":20000}}},"health_status":"HEALTHY"}]}]}},` +
+	`{"endpoint_config":{"cluster_name":"cache.default.dc1.internal.cluster.consul","endpoints":[{"lb_endpoints":[{"endpoint":{"address":{"socket_address":{"address":"10.0.0.9","port_value":20000}}},"health_status":"UNHEALTHY"}]}]}}]}` +
+	`]}`
+
+const certs = `{"certificates":[{` +
+	`"ca_cert":[{"serial_number":"7","valid_from":"2020-01-01T00:00:00Z","expiration_time":"2030-01-01T00:00:00Z"}],` +
+	`"cert_chain":[{"serial_number":"2a","subject_alt_names":[{"uri":"spiffe://cluster.consul/ns/default/dc/dc1/svc/web"}],"valid_from":"2020-06-01T00:00:00Z","expiration_time":"2020-06-04T00:00:00Z"}]` +
+	`}]}`
+
+func TestRun_FlagValidation(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 1, cmd.Run(nil))
+	require.Contains(t, ui.ErrorWriter.String(), "Should have exactly one argument, the name of the pod.")
+}
+
+func TestRun_Diagnosis(t *testing.T) {
+	cases := map[string]struct {
+		upstream  string
+		now       time.Time
+		expCode   int
+		expOutput []string
+		expErrors []string
+	}{
+		"no problems": {
+			upstream: "db",
+			now:      time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC),
+			expCode:  0,
+			expOutput: []string{
+				" ✓ The CA certificate (serial 7) is valid until 2030-01-01T00:00:00Z",
+				" ✓ The leaf certificate (serial 2a) is valid until 2020-06-04T00:00:00Z",
+				"==> Upstream db\n" +
+					" ✓ The sidecar listens on 127.0.0.1:5432 for the upstream\n" +
+					" ✓ The sidecar has the cluster db.default.dc1.internal.cluster.consul\n" +
+					" ✓ 1/1 endpoints of the cluster are healthy\n" +
+					" ✓ The intentions allow the connections from web to db\n",
+				"No problems found.",
+			},
+		},
+		"problems": {
+			now:     time.Date(2020, 6, 5, 0, 0, 0, 0, time.UTC),
+			expCode: 1,
+			expOutput: []string{
+				"==> Upstream cache\n ✓ The sidecar listens on 127.0.0.1:6379 for the upstream\n",
+				"Found problems, see the checks marked with ✗.",
+			},
+			expErrors: []string{
+				" ✗ The leaf certificate (serial 2a) is only valid from 2020-06-01T00:00:00Z to 2020-06-04T00:00:00Z",
+				" ✗ None of the 1 endpoints of the cluster is healthy, check the health checks of cache",
+				" ✗ The intentions deny the connections from web to cache",
+				" ✗ The sidecar has no listener on port 5672 for the upstream",
+				" ✗ The sidecar has no cluster for service queue, check that the service is registered in Consul",
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/config_dump":
+					fmt.Fprint(w, configDump)
+				case "/certs":
+					fmt.Fprint(w, certs)
+				}
+			}))
+			defer envoy.Close()
+			consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/v1/connect/intentions/check", r.URL.Path)
+				require.Equal(t, "web", r.URL.Query().Get("source"))
+				fmt.Fprintf(w, `{"Allowed": %t}`, r.URL.Query().Get("destination") == "db")
+			}))
+			defer consul.Close()
+			consulClient, err := api.NewClient(&api.Config{Address: consul.URL})
+			require.NoError(t, err)
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI: ui,
+				kubeClient: fake.NewSimpleClientset(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "web",
+					Annotations: map[string]string{
+						"consul.hashicorp.com/connect-inject-status":     "injected",
+						"consul.hashicorp.com/connect-service":           "web",
+						"consul.hashicorp.com/connect-service-upstreams": "db:5432,cache:6379,queue:5672",
+					},
+				}}),
+				forward: func(namespace, name string, remotePort int) (string, func(), error) {
+					return strings.TrimPrefix(envoy.URL, "http://"), func() {}, nil
+				},
+				consulClient: consulClient,
+				now:          func() time.Time { return c.now },
+			}
+			args := []string{"-namespace", "web", "web-0"}
+			if c.upstream != "" {
+				args = append([]string{"-upstream", c.upstream}, args...)
+			}
+			require.Equal(t, c.expCode, cmd.Run(args), ui.ErrorWriter.String())
+			output := ui.OutputWriter.String()
+			for _, exp := range c.expOutput {
+				require.Contains(t, output, exp)
+			}
+			errs := ui.ErrorWriter.String()
+			for _, exp := range c.expErrors {
+				require.Contains(t, errs, exp)
+			}
+			if len(c.expErrors) == 0 {
+				require.Empty(t, errs)
+			}
+		})
+	}
+}
+
+func TestRun_UnknownUpstream(t *testing.T) {
+	ui := cli.NewMockUi()
+	consulClient, err := api.NewClient(api.DefaultConfig())
+	require.NoError(t, err)
+	envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"configs":[],"certificates":[]}`)
+	}))
+	defer envoy.Close()
+	cmd := Command{
+		UI: ui,
+		kubeClient: fake.NewSimpleClientset(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-0",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"consul.hashicorp.com/connect-inject-status":     "injected",
+				"consul.hashicorp.com/connect-service-upstreams": "db:5432",
+			},
+		}}),
+		forward: func(namespace, name string, remotePort int) (string, func(), error) {
+			return strings.TrimPrefix(envoy.URL, "http://"), func() {}, nil
+		},
+		consulClient: consulClient,
+	}
+	require.Equal(t, 1, cmd.Run([]string{"-upstream", "cache", "web-0"}))
+	require.Contains(t, ui.ErrorWriter.String(),
+		` ✗ Upstream "cache" is not in the consul.hashicorp.com/connect-service-upstreams annotation of pod "web-0"`)
+}
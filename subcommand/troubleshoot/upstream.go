@@ -0,0 +1,153 @@
+package troubleshoot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-k8s/helper/envoy"
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul-k8s/subcommand/proxy"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// UpstreamsAnnotation is the annotation of the upstreams of the injected
+// pods, read by the connect injector.
+const UpstreamsAnnotation = "consul.hashicorp.com/connect-service-upstreams"
+
+// Upstream is an upstream of an injected pod.
+type Upstream struct {
+	// Service is the name of the upstream service, empty for prepared
+	// queries.
+	Service string
+	// Namespace is the Consul namespace of the service, if set.
+	Namespace string
+	// Datacenter is the datacenter of the service, if set.
+	Datacenter string
+	// PreparedQuery is the name of the prepared query of the upstream, if
+	// it's one.
+	PreparedQuery string
+	// LocalPort is the port the sidecar listens on for the upstream.
+	LocalPort string
+}
+
+// Name is the name of the upstream in the annotation.
+func (u Upstream) Name() string {
+	switch {
+	case u.PreparedQuery != "":
+		return "prepared_query:" + u.PreparedQuery
+	case u.Namespace != "":
+		return u.Service + "." + u.Namespace
+	}
+	return u.Service
+}
+
+// Upstreams returns the upstreams of pod, parsed as the connect injector
+// does, i.e. service[.namespace]:port[:datacenter] or
+// prepared_query:name:port.
+func Upstreams(pod corev1.Pod) []Upstream {
+	var upstreams []Upstream
+	raw := pod.Annotations[UpstreamsAnnotation]
+	if raw == "" {
+		return nil
+	}
+	for _, raw := range strings.Split(raw, ",") {
+		parts := strings.SplitN(strings.TrimSpace(raw), ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		var upstream Upstream
+		if parts[0] == "prepared_query" {
+			if len(parts) < 3 {
+				continue
+			}
+			upstream.PreparedQuery = strings.TrimSpace(parts[1])
+			upstream.LocalPort = strings.TrimSpace(parts[2])
+		} else {
+			pieces := strings.SplitN(strings.TrimSpace(parts[0]), ".", 2)
+			upstream.Service = pieces[0]
+			if len(pieces) > 1 {
+				upstream.Namespace = pieces[1]
+			}
+			upstream.LocalPort = strings.TrimSpace(parts[1])
+			if len(parts) > 2 {
+				upstream.Datacenter = strings.TrimSpace(parts[2])
+			}
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams
+}
+
+// Listener returns the listener of the upstream in config, i.e. the
+// listener on its local port, or nil if there's none.
+func (u Upstream) Listener(config *envoy.Config) *envoy.Listener {
+	for i, listener := range config.Listeners {
+		if strings.HasSuffix(listener.Address, ":"+u.LocalPort) {
+			return &config.Listeners[i]
+		}
+	}
+	return nil
+}
+
+// Cluster returns the cluster of the upstream in config, or nil if there's
+// none. Consul names the clusters of the upstream services
+// <service>.<namespace>.<datacenter>.internal.<trust domain>.consul.
+func (u Upstream) Cluster(config *envoy.Config) *envoy.Cluster {
+	if u.PreparedQuery != "" {
+		return nil
+	}
+	namespace := u.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	for i, cluster := range config.Clusters {
+		parts := strings.Split(cluster.Name, ".")
+		if len(parts) < 4 || parts[0] != u.Service || parts[1] != namespace || parts[3] != "internal" {
+			continue
+		}
+		if u.Datacenter != "" && parts[2] != u.Datacenter {
+			continue
+		}
+		return &config.Clusters[i]
+	}
+	return nil
+}
+
+// Endpoints returns the number of healthy endpoints of cluster in config,
+// and the total number of its endpoints.
+func Endpoints(config *envoy.Config, cluster string) (healthy, total int) {
+	for _, endpoint := range config.Endpoints {
+		if endpoint.Cluster != cluster {
+			continue
+		}
+		total++
+		if endpoint.Health == "HEALTHY" {
+			healthy++
+		}
+	}
+	return healthy, total
+}
+
+// EnvoyConfig returns the injected pod name in namespace and the
+// configuration of its sidecar.
+func EnvoyConfig(kubeClient kubernetes.Interface, forward portforward.Forwarder, namespace, name string) (*corev1.Pod, *envoy.Config, error) {
+	pod, err := kubeClient.CoreV1().Pods(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting pod %q: %s", name, err)
+	}
+	if !proxy.Injected(*pod) {
+		return nil, nil, fmt.Errorf("pod %q has no injected sidecar", name)
+	}
+	addr, closeForward, err := forward(pod.Namespace, pod.Name, portforward.EnvoyAdminPort)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to the Envoy admin API: %s", err)
+	}
+	defer closeForward()
+	config, err := (&envoy.Admin{Addr: addr}).Config()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading the configuration of Envoy: %s", err)
+	}
+	return pod, config, nil
+}
@@ -0,0 +1,74 @@
+package troubleshoot
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/envoy"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpstreams(t *testing.T) {
+	cases := map[string]struct {
+		annotation string
+		exp        []Upstream
+	}{
+		"none": {},
+		"services": {
+			annotation: "db:5432, api.payments:8080:dc2",
+			exp: []Upstream{
+				{Service: "db", LocalPort: "5432"},
+				{Service: "api", Namespace: "payments", Datacenter: "dc2", LocalPort: "8080"},
+			},
+		},
+		"prepared query": {
+			annotation: "prepared_query:geo-db:5432",
+			exp:        []Upstream{{PreparedQuery: "geo-db", LocalPort: "5432"}},
+		},
+		"invalid": {
+			annotation: "db,prepared_query:geo-db",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{UpstreamsAnnotation: c.annotation},
+			}}
+			require.Equal(t, c.exp, Upstreams(pod))
+		})
+	}
+}
+
+func TestUpstream_Lookups(t *testing.T) {
+	config := &envoy.Config{
+		Clusters: []envoy.Cluster{
+			{Name: "local_app"},
+			{Name: "db.default.dc1.internal.11111111-2222-3333-4444-555555555555.consul"},
+			{Name: "api.payments.dc2.internal.11111111-2222-3333-4444-555555555555.consul"},
+		},
+		Listeners: []envoy.Listener{
+			{Name: "public_listener:10.0.0.5:20000", Address: "10.0.0.5:20000"},
+			{Name: "db:127.0.0.1:5432", Address: "127.0.0.1:5432"},
+		},
+		Endpoints: []envoy.Endpoint{
+			{Cluster: "db.default.dc1.internal.11111111-2222-3333-4444-555555555555.consul", Health: "HEALTHY"},
+			{Cluster: "db.default.dc1.internal.11111111-2222-3333-4444-555555555555.consul", Health: "UNHEALTHY"},
+			{Cluster: "local_app", Health: "HEALTHY"},
+		},
+	}
+
+	db := Upstream{Service: "db", LocalPort: "5432"}
+	require.Equal(t, "db:127.0.0.1:5432", db.Listener(config).Name)
+	require.Equal(t, "db.default.dc1.internal.11111111-2222-3333-4444-555555555555.consul", db.Cluster(config).Name)
+	healthy, total := Endpoints(config, db.Cluster(config).Name)
+	require.Equal(t, 1, healthy)
+	require.Equal(t, 2, total)
+
+	api := Upstream{Service: "api", Namespace: "payments", Datacenter: "dc2", LocalPort: "8080"}
+	require.Nil(t, api.Listener(config))
+	require.Equal(t, "api.payments.dc2.internal.11111111-2222-3333-4444-555555555555.consul", api.Cluster(config).Name)
+	require.Nil(t, Upstream{Service: "api", Datacenter: "dc1"}.Cluster(config))
+	require.Nil(t, Upstream{PreparedQuery: "db"}.Cluster(config))
+}
@@ -0,0 +1,130 @@
+package upstreams
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/helper/portforward"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul-k8s/subcommand/troubleshoot"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command lists the upstreams of an injected pod and whether its sidecar
+// knows about them.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagNamespace     string
+	flagKubectlBinary string
+
+	kubeClient kubernetes.Interface
+	forward    portforward.Forwarder
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagNamespace, "namespace", "default",
+		"Kubernetes namespace of the pod.")
+	c.flags.StringVar(&c.flagKubectlBinary, "kubectl", "kubectl",
+		"Path of the kubectl binary, used to port-forward to the Envoy admin API.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) != 1 {
+		c.UI.Error("Should have exactly one argument, the name of the pod.")
+		return 1
+	}
+
+	if c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+	if c.forward == nil {
+		c.forward = portforward.Kubectl(c.flagKubectlBinary, c.k8s.KubeConfig())
+	}
+
+	pod, config, err := troubleshoot.EnvoyConfig(c.kubeClient, c.forward, c.flagNamespace, c.flags.Arg(0))
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	upstreams := troubleshoot.Upstreams(*pod)
+	if len(upstreams) == 0 {
+		c.UI.Output(fmt.Sprintf("Pod %q has no upstreams.", pod.Name))
+		return 0
+	}
+
+	var rows [][]string
+	for _, upstream := range upstreams {
+		listener := "missing"
+		if l := upstream.Listener(config); l != nil {
+			listener = l.Name
+		}
+		cluster, endpoints := "missing", "-"
+		if upstream.PreparedQuery != "" {
+			cluster = "prepared query"
+		}
+		if cl := upstream.Cluster(config); cl != nil {
+			cluster = cl.Name
+			healthy, total := troubleshoot.Endpoints(config, cl.Name)
+			endpoints = fmt.Sprintf("%d/%d healthy", healthy, total)
+		}
+		rows = append(rows, []string{upstream.Name(), upstream.LocalPort, listener, cluster, endpoints})
+	}
+	c.UI.Output(subcommand.Table([]string{"UPSTREAM", "PORT", "LISTENER", "CLUSTER", "ENDPOINTS"}, rows))
+	return 0
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "List the upstreams of an injected pod"
+const help = `
+Usage: consul-k8s troubleshoot upstreams [options] <pod>
+
+  Lists the upstreams of the consul.hashicorp.com/connect-service-upstreams
+  annotation of the pod, with the listener and cluster of each upstream in
+  its Envoy sidecar and how many of the endpoints of the cluster are
+  healthy. An upstream missing from the sidecar is usually registered with
+  a typo, or not yet synced to the sidecar.
+
+  Use consul-k8s troubleshoot proxy to diagnose an upstream.
+
+`
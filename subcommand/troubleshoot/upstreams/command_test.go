@@ -0,0 +1,82 @@
+package upstreams
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const configDump = `{"configs":[` +
+	`{"@type":"type.googleapis.com/envoy.admin.v3.ClustersConfigDump","dynamic_active_clusters":[{"cluster":{"name":"db.default.dc1.internal.cluster.consul","type":"EDS"}}]},` +
+	`{"@type":"type.googleapis.com/envoy.admin.v3.ListenersConfigDump","dynamic_listeners":[{"name":"db:127.0.0.1:5432","active_state":{"listener":{"name":"db:127.0.0.1:5432","address":{"socket_address":{"address":"127.0.0.1","port_value":5432}}}}}]},` +
+	`{"@type":"type.googleapis.com/envoy.admin.v3.EndpointsConfigDump","dynamic_endpoint_configs":[{"endpoint_config":{"cluster_name":"db.default.dc1.internal.cluster.consul","endpoints":[{"lb_endpoints":[` +
+	`{"endpoint":{"address":{"socket_address":{"address":"10.0.0.7","port_value":20000}}},"health_status":"HEALTHY"},` +
+	`{"endpoint":{"address":{"socket_address":{"address":"10.0.0.8","port_value":20000}}},"health_status":"UNHEALTHY"}]}]}}]}` +
+	`]}`
+
+func TestRun_FlagValidation(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 1, cmd.Run(nil))
+	require.Contains(t, ui.ErrorWriter.String(), "Should have exactly one argument, the name of the pod.")
+}
+
+func TestRun_Upstreams(t *testing.T) {
+	cases := map[string]struct {
+		annotation string
+		expOutput  string
+	}{
+		"no upstreams": {
+			expOutput: "Pod \"web-0\" has no upstreams.\n",
+		},
+		"upstreams": {
+			annotation: "db:5432,cache:6379,prepared_query:geo:8080",
+			expOutput: `    UPSTREAM             PORT   LISTENER            CLUSTER                                  ENDPOINTS
+    db                   5432   db:127.0.0.1:5432   db.default.dc1.internal.cluster.consul   1/2 healthy
+    cache                6379   missing             missing                                  -
+    prepared_query:geo   8080   missing             prepared query                           -
+`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/config_dump":
+					fmt.Fprint(w, configDump)
+				case "/certs":
+					fmt.Fprint(w, `{"certificates":[]}`)
+				}
+			}))
+			defer server.Close()
+
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI: ui,
+				kubeClient: fake.NewSimpleClientset(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Name:      "web-0",
+					Namespace: "default",
+					Annotations: map[string]string{
+						"consul.hashicorp.com/connect-inject-status":     "injected",
+						"consul.hashicorp.com/connect-service":           "web",
+						"consul.hashicorp.com/connect-service-upstreams": c.annotation,
+					},
+				}}),
+				forward: func(namespace, name string, remotePort int) (string, func(), error) {
+					return strings.TrimPrefix(server.URL, "http://"), func() {}, nil
+				},
+			}
+			require.Equal(t, 0, cmd.Run([]string{"web-0"}), ui.ErrorWriter.String())
+			require.Equal(t, c.expOutput, ui.OutputWriter.String())
+		})
+	}
+}
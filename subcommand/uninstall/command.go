@@ -0,0 +1,443 @@
+package uninstall
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command uninstalls the Consul Helm chart and deletes the resources it
+// leaves behind.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	http       *flags.HTTPFlags
+	k8s        *k8sflags.K8SFlags
+
+	flagResourcePrefix string
+	flagAutoApprove    bool
+	flagDeleteCRDs     bool
+	flagCleanupACLs    bool
+	flagTimeout        time.Duration
+
+	helm          *helm.Client
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+	consulClient  *api.Client
+
+	// pollInterval is how often the deletion of the CRDs is checked.
+	pollInterval time.Duration
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagResourcePrefix, "resource-prefix", "",
+		"Prefix of the names of the resources of the release. Defaults to the fullnameOverride value of the "+
+			"release if it's set, then to its global.name value, and to <release>-consul otherwise.")
+	c.flags.BoolVar(&c.flagAutoApprove, "auto-approve", false,
+		"If true, uninstalls without asking for confirmation.")
+	c.flags.BoolVar(&c.flagDeleteCRDs, "delete-crds", false,
+		"If true, also deletes the consul.hashicorp.com CRDs, and with them all their custom resources.")
+	c.flags.BoolVar(&c.flagCleanupACLs, "cleanup-acls", false,
+		"If true, deletes the ACL tokens of the Kubernetes secrets of the release and its auth method from "+
+			"Consul, e.g. when the servers run outside of Kubernetes. The bootstrap token is used unless -token "+
+			"is set.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 5*time.Minute,
+		"How long to wait for the CRDs to be deleted.")
+
+	c.http = &flags.HTTPFlags{}
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+// leftovers are the resources of a release that Helm doesn't delete.
+type leftovers struct {
+	pvcs    []string
+	secrets []string
+	// tokenSecrets are the secrets holding the ACL tokens of the
+	// components, except the bootstrap token, which -cleanup-acls
+	// deletes from Consul.
+	tokenSecrets       []string
+	mutatingWebhooks   []string
+	validatingWebhooks []string
+	crds               []string
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.pollInterval == 0 {
+		c.pollInterval = 2 * time.Second
+	}
+
+	if c.kubeClient == nil || c.dynamicClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		if c.kubeClient == nil {
+			c.kubeClient, err = kubernetes.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+		if c.dynamicClient == nil {
+			c.dynamicClient, err = dynamic.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+	}
+	if c.helm == nil {
+		c.helm = &helm.Client{KubeConfig: c.k8s.KubeConfig()}
+	}
+
+	c.UI.Output("==> Finding the Consul installation")
+	release, err := c.helm.Release()
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ Found release %q in namespace %q (chart %s, status %s)",
+		release.Name, release.Namespace, release.Chart, release.Status))
+	prefix := c.flagResourcePrefix
+	if prefix == "" {
+		values, err := c.helm.Values(release.Name, release.Namespace)
+		if err != nil {
+			c.UI.Error(" ✗ " + err.Error())
+			return 1
+		}
+		prefix = release.ResourcePrefix(values)
+	}
+	found, err := c.findLeftovers(release, prefix)
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+
+	c.UI.Output("\n==> Resources to delete")
+	c.UI.Output(fmt.Sprintf("    Helm release %s in namespace %s", release.Name, release.Namespace))
+	for _, group := range []struct {
+		kind  string
+		names []string
+	}{
+		{"PersistentVolumeClaim", found.pvcs},
+		{"Secret", found.secrets},
+		{"MutatingWebhookConfiguration", found.mutatingWebhooks},
+		{"ValidatingWebhookConfiguration", found.validatingWebhooks},
+		{"CustomResourceDefinition", found.crds},
+	} {
+		for _, name := range group.names {
+			c.UI.Output(fmt.Sprintf("    %s %s", group.kind, name))
+		}
+	}
+	if c.flagCleanupACLs {
+		c.UI.Output(fmt.Sprintf("    The Consul ACL tokens of %d secrets and the auth method %s",
+			len(found.tokenSecrets), authMethodName(prefix)))
+	}
+	if len(found.pvcs) > 0 {
+		c.UI.Output("\n    Deleting the persistent volume claims deletes the data of the Consul servers.")
+	}
+	if len(found.crds) > 0 {
+		c.UI.Output("    Deleting the CRDs deletes all the custom resources of consul.hashicorp.com.")
+	}
+
+	if !c.flagAutoApprove {
+		ok, err := subcommand.Confirm(c.UI, "\nProceed with the uninstallation?")
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading the confirmation: %s", err))
+			return 1
+		}
+		if !ok {
+			c.UI.Output("Uninstallation cancelled.")
+			return 1
+		}
+	}
+
+	// The ACL tokens are deleted first since they're read from the secrets,
+	// and the CRDs before the release so that the controller is still
+	// running to remove the finalizers of their custom resources.
+	if c.flagCleanupACLs {
+		c.UI.Output("\n==> Deleting the ACL tokens from Consul")
+		if err := c.cleanupACLs(release.Namespace, prefix, found.tokenSecrets); err != nil {
+			c.UI.Error(" ✗ " + err.Error())
+			return 1
+		}
+		c.UI.Output(" ✓ ACL tokens deleted")
+	}
+	if len(found.crds) > 0 {
+		c.UI.Output(fmt.Sprintf("\n==> Deleting the CRDs, waiting up to %s", c.flagTimeout))
+		if err := c.deleteCRDs(found.crds); err != nil {
+			c.UI.Error(" ✗ " + err.Error())
+			return 1
+		}
+		c.UI.Output(" ✓ CRDs deleted")
+	}
+
+	c.UI.Output("\n==> Uninstalling the Helm release")
+	if err := c.helm.Uninstall(release.Name, release.Namespace); err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ Release %q uninstalled", release.Name))
+
+	c.UI.Output("\n==> Deleting the resources left by Helm")
+	if err := c.deleteLeftovers(release.Namespace, found); err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	c.UI.Output(" ✓ Resources deleted")
+	c.UI.Output(fmt.Sprintf("\nConsul uninstalled from namespace %q.", release.Namespace))
+	return 0
+}
+
+// findLeftovers returns the resources of release that Helm doesn't delete:
+// the persistent volume claims of the servers, the secrets created by the
+// ACL and TLS init jobs, the webhook configurations whose caBundle is
+// patched at runtime, and the CRDs if -delete-crds is set.
+func (c *Command) findLeftovers(release helm.Release, prefix string) (*leftovers, error) {
+	var found leftovers
+	pvcs, err := c.kubeClient.CoreV1().PersistentVolumeClaims(release.Namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("app=consul,release=%s", release.Name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing the persistent volume claims: %s", err)
+	}
+	for _, pvc := range pvcs.Items {
+		found.pvcs = append(found.pvcs, pvc.Name)
+	}
+
+	secrets, err := c.kubeClient.CoreV1().Secrets(release.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing the secrets: %s", err)
+	}
+	for _, secret := range secrets.Items {
+		name := secret.Name
+		if !strings.HasPrefix(name, prefix+"-") {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, "-acl-token"):
+			found.secrets = append(found.secrets, name)
+			if name != helm.BootstrapTokenSecretName(prefix) {
+				found.tokenSecrets = append(found.tokenSecrets, name)
+			}
+		case name == prefix+"-ca-cert", name == prefix+"-ca-key", name == prefix+"-server-cert":
+			found.secrets = append(found.secrets, name)
+		}
+	}
+
+	mutating, err := c.kubeClient.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing the mutating webhook configurations: %s", err)
+	}
+	for _, config := range mutating.Items {
+		if strings.HasPrefix(config.Name, prefix+"-") {
+			found.mutatingWebhooks = append(found.mutatingWebhooks, config.Name)
+		}
+	}
+	validating, err := c.kubeClient.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing the validating webhook configurations: %s", err)
+	}
+	for _, config := range validating.Items {
+		if strings.HasPrefix(config.Name, prefix+"-") {
+			found.validatingWebhooks = append(found.validatingWebhooks, config.Name)
+		}
+	}
+
+	if c.flagDeleteCRDs {
+		crds, err := c.dynamicClient.Resource(helm.CRDResource).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("listing the CRDs: %s", err)
+		}
+		for _, crd := range crds.Items {
+			if group, _, _ := unstructured.NestedString(crd.Object, "spec", "group"); group == helm.CRDGroup {
+				found.crds = append(found.crds, crd.GetName())
+			}
+		}
+	}
+
+	for _, names := range [][]string{found.pvcs, found.secrets, found.tokenSecrets, found.mutatingWebhooks, found.validatingWebhooks, found.crds} {
+		sort.Strings(names)
+	}
+	return &found, nil
+}
+
+// cleanupACLs deletes the ACL tokens of tokenSecrets and the auth method
+// of the release from Consul. Deleting the auth method also deletes the
+// tokens of the pods that logged in with it.
+func (c *Command) cleanupACLs(namespace, prefix string, tokenSecrets []string) error {
+	secrets := c.kubeClient.CoreV1().Secrets(namespace)
+	if c.consulClient == nil {
+		config := api.DefaultConfig()
+		c.http.MergeOntoConfig(config)
+		if config.Token == "" {
+			bootstrap, err := secrets.Get(helm.BootstrapTokenSecretName(prefix), metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("reading the bootstrap token: %s", err)
+			}
+			config.Token = string(bootstrap.Data["token"])
+		}
+		var err error
+		c.consulClient, err = consul.NewClient(config)
+		if err != nil {
+			return fmt.Errorf("connecting to Consul: %s", err)
+		}
+	}
+
+	acl := c.consulClient.ACL()
+	for _, name := range tokenSecrets {
+		secret, err := secrets.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("reading secret %q: %s", name, err)
+		}
+		// The accessor ID of the token is needed to delete it.
+		token, _, err := acl.TokenReadSelf(&api.QueryOptions{Token: string(secret.Data["token"])})
+		if isACLNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading the ACL token of secret %q: %s", name, err)
+		}
+		if _, err := acl.TokenDelete(token.AccessorID, nil); err != nil {
+			return fmt.Errorf("deleting the ACL token of secret %q: %s", name, err)
+		}
+	}
+	if _, err := acl.AuthMethodDelete(authMethodName(prefix), nil); err != nil {
+		return fmt.Errorf("deleting the auth method: %s", err)
+	}
+	return nil
+}
+
+// deleteCRDs deletes the CRDs named names and waits until they're gone.
+func (c *Command) deleteCRDs(names []string) error {
+	crds := c.dynamicClient.Resource(helm.CRDResource)
+	for _, name := range names {
+		if err := crds.Delete(name, &metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("deleting CRD %q: %s", name, err)
+		}
+	}
+	err := wait.PollImmediate(c.pollInterval, c.flagTimeout, func() (bool, error) {
+		for _, name := range names {
+			_, err := crds.Get(name, metav1.GetOptions{})
+			if err == nil {
+				return false, nil
+			}
+			if !k8serrors.IsNotFound(err) {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for the CRDs to be deleted, e.g. for the finalizers of their resources: %s", err)
+	}
+	return nil
+}
+
+// deleteLeftovers deletes the leftovers of the release. Resources that are
+// already gone, e.g. webhook configurations deleted by Helm, are ignored.
+func (c *Command) deleteLeftovers(namespace string, found *leftovers) error {
+	core := c.kubeClient.CoreV1()
+	admission := c.kubeClient.AdmissionregistrationV1beta1()
+	for _, group := range []struct {
+		kind   string
+		names  []string
+		delete func(name string, opts *metav1.DeleteOptions) error
+	}{
+		{"persistent volume claim", found.pvcs, core.PersistentVolumeClaims(namespace).Delete},
+		{"secret", found.secrets, core.Secrets(namespace).Delete},
+		{"mutating webhook configuration", found.mutatingWebhooks, admission.MutatingWebhookConfigurations().Delete},
+		{"validating webhook configuration", found.validatingWebhooks, admission.ValidatingWebhookConfigurations().Delete},
+	} {
+		for _, name := range group.names {
+			if err := group.delete(name, &metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+				return fmt.Errorf("deleting %s %q: %s", group.kind, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// authMethodName is the name of the auth method created by
+// server-acl-init.
+func authMethodName(prefix string) string {
+	return prefix + "-k8s-auth-method"
+}
+
+// isACLNotFound is true if err is the error of Consul for tokens that
+// don't exist, e.g. since they were already deleted.
+func isACLNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "ACL not found")
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Uninstall Consul from Kubernetes"
+const help = `
+Usage: consul-k8s uninstall [options]
+
+  Uninstalls the Helm release of Consul and deletes the resources that
+  Helm leaves behind, which would otherwise break a new installation:
+
+    - the persistent volume claims of the Consul servers, and with them
+      their data
+    - the secrets of the ACL tokens and TLS certificates created by the
+      ACL and TLS init jobs, e.g. the bootstrap token
+    - the webhook configurations of the release
+
+  The resources are listed and the uninstallation must be confirmed
+  unless -auto-approve is set.
+
+  If -delete-crds is set, the consul.hashicorp.com CRDs are deleted too,
+  before the release so that the controller removes the config entries of
+  their custom resources from Consul. If -cleanup-acls is set, the ACL
+  tokens of the secrets and the auth method of the release are deleted
+  from Consul, e.g. when the servers run outside of Kubernetes and keep
+  their ACLs after the uninstallation.
+
+`
@@ -0,0 +1,281 @@
+package uninstall
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var release = helm.TestRelease{Name: "consul", Namespace: "mesh", Chart: "consul-0.24.1", Values: `{"global":{"name":"consul"}}`}
+
+func TestRun_FlagValidation(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 1, cmd.Run([]string{"foo"}))
+	require.Contains(t, ui.ErrorWriter.String(), "Should have no non-flag arguments.")
+}
+
+func TestRun_NoInstallation(t *testing.T) {
+	client, err := helm.NewTestClient(nil)
+	require.NoError(t, err)
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:            ui,
+		kubeClient:    fake.NewSimpleClientset(),
+		dynamicClient: &helm.TestCRDClient{},
+		helm:          client,
+	}
+	require.Equal(t, 1, cmd.Run([]string{"-auto-approve"}))
+	require.Contains(t, ui.ErrorWriter.String(), "no installation of Consul found")
+}
+
+func TestRun_Uninstall(t *testing.T) {
+	leftovers := []string{
+		"PersistentVolumeClaim data-mesh-consul-server-0",
+		"Secret consul-bootstrap-acl-token",
+		"Secret consul-ca-cert",
+		"Secret consul-client-acl-token",
+		"MutatingWebhookConfiguration consul-connect-injector-cfg",
+	}
+	cases := map[string]struct {
+		flags        []string
+		input        string
+		expCode      int
+		expUninstall bool
+		expOutput    []string
+	}{
+		"cancelled": {
+			input:     "no\n",
+			expCode:   1,
+			expOutput: append(leftovers, "Uninstallation cancelled."),
+		},
+		"confirmed": {
+			input:        "yes\n",
+			expCode:      0,
+			expUninstall: true,
+			expOutput:    append(leftovers, `Consul uninstalled from namespace "mesh"`),
+		},
+		"auto-approve": {
+			flags:        []string{"-auto-approve"},
+			expCode:      0,
+			expUninstall: true,
+			expOutput:    append(leftovers, `Consul uninstalled from namespace "mesh"`),
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			ui.InputReader = strings.NewReader(c.input)
+			kubeClient := fake.NewSimpleClientset(
+				pvc("data-mesh-consul-server-0", "consul"),
+				pvc("data-other-consul-server-0", "other"),
+				secret("consul-bootstrap-acl-token", "bootstrap"),
+				secret("consul-client-acl-token", "client"),
+				secret("consul-ca-cert", ""),
+				secret("consul-gossip-key", ""),
+				secret("postgres-acl-token", ""),
+				&admissionv1beta1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "consul-connect-injector-cfg"}},
+				&admissionv1beta1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "istio-sidecar-injector"}},
+			)
+			client, err := helm.NewTestClient(kubeClient, release)
+			require.NoError(t, err)
+			cmd := Command{
+				UI:            ui,
+				kubeClient:    kubeClient,
+				dynamicClient: &helm.TestCRDClient{},
+				helm:          client,
+			}
+
+			require.Equal(t, c.expCode, cmd.Run(c.flags), ui.ErrorWriter.String())
+			output := ui.OutputWriter.String()
+			for _, exp := range c.expOutput {
+				require.Contains(t, output, exp)
+			}
+			// Resources of other releases are kept.
+			for _, kept := range []string{"data-other-consul-server-0", "consul-gossip-key", "postgres-acl-token", "istio-sidecar-injector"} {
+				require.NotContains(t, output, kept)
+			}
+
+			remaining := names(t, kubeClient)
+			releases, err := client.Releases()
+			require.NoError(t, err)
+			if c.expUninstall {
+				require.Empty(t, releases)
+				require.Equal(t, []string{
+					"PersistentVolumeClaim data-other-consul-server-0",
+					"Secret consul-gossip-key",
+					"Secret postgres-acl-token",
+					"MutatingWebhookConfiguration istio-sidecar-injector",
+				}, remaining)
+			} else {
+				require.Len(t, releases, 1)
+				require.Len(t, remaining, 9)
+			}
+		})
+	}
+}
+
+func TestRun_DeleteCRDs(t *testing.T) {
+	client, err := helm.NewTestClient(nil, release)
+	require.NoError(t, err)
+	ui := cli.NewMockUi()
+	var deleted []string
+	crds := &helm.TestCRDClient{
+		CRDs: []unstructured.Unstructured{
+			helm.NewTestCRD("servicedefaults.consul.hashicorp.com", helm.CRDGroup, ""),
+			helm.NewTestCRD("certificates.cert-manager.io", "cert-manager.io", ""),
+		},
+		OnDelete: func(name string) {
+			// The CRDs are deleted while the release is still installed.
+			releases, err := client.Releases()
+			require.NoError(t, err)
+			require.Len(t, releases, 1)
+			deleted = append(deleted, name)
+		},
+	}
+	cmd := Command{
+		UI:            ui,
+		kubeClient:    fake.NewSimpleClientset(),
+		dynamicClient: crds,
+		helm:          client,
+		pollInterval:  10 * time.Millisecond,
+	}
+
+	require.Equal(t, 0, cmd.Run([]string{"-auto-approve", "-delete-crds"}), ui.ErrorWriter.String())
+	require.Contains(t, ui.OutputWriter.String(), "CustomResourceDefinition servicedefaults.consul.hashicorp.com")
+	require.NotContains(t, ui.OutputWriter.String(), "certificates.cert-manager.io")
+	require.Equal(t, []string{"servicedefaults.consul.hashicorp.com"}, deleted)
+	releases, err := client.Releases()
+	require.NoError(t, err)
+	require.Empty(t, releases)
+	require.Equal(t, "certificates.cert-manager.io", crds.CRDs[0].GetName())
+}
+
+// Test that the resources are found with the fullnameOverride of the
+// release, which takes precedence over global.name like in the chart.
+func TestRun_FullnameOverride(t *testing.T) {
+	ui := cli.NewMockUi()
+	kubeClient := fake.NewSimpleClientset(
+		secret("hashi-bootstrap-acl-token", "bootstrap"),
+		secret("consul-ca-cert", ""),
+	)
+	client, err := helm.NewTestClient(kubeClient, helm.TestRelease{
+		Name:      "prod",
+		Namespace: "mesh",
+		Chart:     "consul-0.24.1",
+		Values:    `{"fullnameOverride":"hashi","global":{"name":"consul"}}`,
+	})
+	require.NoError(t, err)
+	cmd := Command{
+		UI:            ui,
+		kubeClient:    kubeClient,
+		dynamicClient: &helm.TestCRDClient{},
+		helm:          client,
+	}
+
+	require.Equal(t, 0, cmd.Run([]string{"-auto-approve"}), ui.ErrorWriter.String())
+	require.Contains(t, ui.OutputWriter.String(), "Secret hashi-bootstrap-acl-token")
+	require.Equal(t, []string{"Secret consul-ca-cert"}, names(t, kubeClient))
+}
+
+func TestRun_CleanupACLs(t *testing.T) {
+	var lock sync.Mutex
+	var deleted []string
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		token := r.Header.Get("X-Consul-Token")
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/v1/acl/token/self":
+			if token == "deleted" {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, "ACL not found")
+				return
+			}
+			fmt.Fprintf(w, `{"AccessorID":"%s-accessor","SecretID":"%s"}`, token, token)
+		case r.Method == "DELETE":
+			require.Equal(t, "bootstrap", token)
+			deleted = append(deleted, r.URL.Path)
+			fmt.Fprint(w, "true")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer consul.Close()
+
+	ui := cli.NewMockUi()
+	kubeClient := fake.NewSimpleClientset(
+		secret("prod-consul-bootstrap-acl-token", "bootstrap"),
+		secret("prod-consul-client-acl-token", "client"),
+		secret("prod-consul-mesh-gateway-acl-token", "deleted"),
+	)
+	client, err := helm.NewTestClient(kubeClient, helm.TestRelease{Name: "prod", Namespace: "mesh", Chart: "consul-0.24.1"})
+	require.NoError(t, err)
+	cmd := Command{
+		UI:            ui,
+		kubeClient:    kubeClient,
+		dynamicClient: &helm.TestCRDClient{},
+		helm:          client,
+	}
+
+	require.Equal(t, 0, cmd.Run([]string{"-auto-approve", "-cleanup-acls", "-http-addr", consul.URL}), ui.ErrorWriter.String())
+	require.Contains(t, ui.OutputWriter.String(), "The Consul ACL tokens of 2 secrets and the auth method prod-consul-k8s-auth-method")
+	require.Equal(t, []string{
+		"/v1/acl/token/client-accessor",
+		"/v1/acl/auth-method/prod-consul-k8s-auth-method",
+	}, deleted)
+	require.Empty(t, names(t, kubeClient))
+}
+
+// names returns the kinds and names of the resources that an uninstallation
+// may delete.
+func names(t *testing.T, client kubernetes.Interface) []string {
+	var names []string
+	pvcs, err := client.CoreV1().PersistentVolumeClaims("mesh").List(metav1.ListOptions{})
+	require.NoError(t, err)
+	for _, pvc := range pvcs.Items {
+		names = append(names, "PersistentVolumeClaim "+pvc.Name)
+	}
+	secrets, err := client.CoreV1().Secrets("mesh").List(metav1.ListOptions{})
+	require.NoError(t, err)
+	for _, secret := range secrets.Items {
+		names = append(names, "Secret "+secret.Name)
+	}
+	webhooks, err := client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().List(metav1.ListOptions{})
+	require.NoError(t, err)
+	for _, webhook := range webhooks.Items {
+		names = append(names, "MutatingWebhookConfiguration "+webhook.Name)
+	}
+	return names
+}
+
+func pvc(name, release string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: "mesh",
+		Labels:    map[string]string{"app": "consul", "release": release},
+	}}
+}
+
+func secret(name, token string) runtime.Object {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "mesh"},
+		Data:       map[string][]byte{"token": []byte(token)},
+	}
+}
@@ -0,0 +1,259 @@
+package upgrade
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	goversion "github.com/hashicorp/go-version"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// minKubernetesVersion is the oldest version of Kubernetes supported by
+	// the chart.
+	minKubernetesVersion = "1.16.0"
+	// pspRemovedKubernetesVersion is the first version of Kubernetes not
+	// serving pod security policies anymore.
+	pspRemovedKubernetesVersion = "1.25.0"
+	// maxConsulMinorJump is the number of minor versions of Consul an
+	// upgrade can move the servers by, e.g. from 1.8 to 1.10.
+	maxConsulMinorJump = 2
+	// podSecurityLabel is the label of the namespaces setting the level of
+	// the Pod Security admission enforced in them.
+	podSecurityLabel = "pod-security.kubernetes.io/enforce"
+)
+
+// deprecatedValues are the values of the chart that are deprecated, and the
+// values replacing them, if any.
+var deprecatedValues = []struct {
+	path        string
+	replacement string
+}{
+	{"global.bootstrapACLs", "global.acls.manageSystemACLs"},
+	{"server.enterpriseLicense", "global.enterpriseLicense"},
+	{"connectInject.imageEnvoy", "global.imageEnvoy"},
+	{"meshGateway.imageEnvoy", "global.imageEnvoy"},
+	{"connectInject.centralConfig", ""},
+}
+
+// preflight runs the pre-flight checks of the upgrade of release from the
+// current to the proposed values and resources. Each check returns the
+// message of its success, or the problems it found.
+type preflight struct {
+	release  helm.Release
+	current  *helm.ReleaseDetails
+	proposed *helm.ReleaseDetails
+
+	kubeClient      kubernetes.Interface
+	dynamicClient   dynamic.Interface
+	discoveryClient discovery.ServerVersionInterface
+}
+
+// checkKubernetesVersion fails if the version of Kubernetes isn't supported
+// by the chart, or if the values enable pod security policies and
+// Kubernetes doesn't serve them anymore.
+func (p *preflight) checkKubernetesVersion() (string, []string, error) {
+	info, err := p.discoveryClient.ServerVersion()
+	if err != nil {
+		return "", nil, fmt.Errorf("getting the version of Kubernetes: %s", err)
+	}
+	v, err := parseVersion(info.GitVersion)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing the version of Kubernetes: %s", err)
+	}
+	var problems []string
+	if v.LessThan(goversion.Must(goversion.NewVersion(minKubernetesVersion))) {
+		problems = append(problems, fmt.Sprintf(
+			"Kubernetes %s isn't supported, the chart requires Kubernetes %s or later. Upgrade Kubernetes first",
+			info.GitVersion, minKubernetesVersion))
+	}
+	psp, _, _ := unstructured.NestedBool(p.proposed.Config, "global", "enablePodSecurityPolicies")
+	if psp && !v.LessThan(goversion.Must(goversion.NewVersion(pspRemovedKubernetesVersion))) {
+		problems = append(problems, fmt.Sprintf(
+			"global.enablePodSecurityPolicies is set but Kubernetes %s doesn't serve pod security policies. "+
+				"Unset it and use the Pod Security admission instead", info.GitVersion))
+	}
+	return fmt.Sprintf("Kubernetes %s is supported by the chart", info.GitVersion), problems, nil
+}
+
+// checkCRDs fails if the stored versions of the existing CRDs of
+// consul.hashicorp.com aren't served by the CRDs of the chart anymore,
+// since the custom resources stored in them would become unreadable.
+func (p *preflight) checkCRDs() (string, []string, error) {
+	resources, err := helm.ParseManifest(p.proposed.Manifest)
+	if err != nil {
+		return "", nil, err
+	}
+	served := make(map[string]map[string]bool)
+	for _, resource := range resources {
+		if resource.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		versions := make(map[string]bool)
+		if version, ok, _ := unstructured.NestedString(resource.Object, "spec", "version"); ok {
+			versions[version] = true
+		}
+		list, _, _ := unstructured.NestedSlice(resource.Object, "spec", "versions")
+		for _, item := range list {
+			version, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if isServed, ok := version["served"].(bool); !ok || isServed {
+				versions[fmt.Sprint(version["name"])] = true
+			}
+		}
+		served[resource.GetName()] = versions
+	}
+
+	crds, err := p.dynamicClient.Resource(helm.CRDResource).List(metav1.ListOptions{})
+	if err != nil {
+		return "", nil, fmt.Errorf("listing the CRDs: %s", err)
+	}
+	var problems []string
+	for _, crd := range crds.Items {
+		group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+		versions, ok := served[crd.GetName()]
+		if group != helm.CRDGroup || !ok {
+			continue
+		}
+		stored, _, _ := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+		for _, version := range stored {
+			if !versions[version] {
+				problems = append(problems, fmt.Sprintf(
+					"CRD %s stores resources in version %s, which the chart doesn't serve anymore. "+
+						"Migrate the resources to a served version and remove %s from its stored versions",
+					crd.GetName(), version, version))
+			}
+		}
+	}
+	return "The stored versions of the CRDs are served by the chart", problems, nil
+}
+
+// checkDeprecatedValues fails if the values of the upgrade set deprecated
+// values.
+func (p *preflight) checkDeprecatedValues() (string, []string, error) {
+	var problems []string
+	for _, value := range deprecatedValues {
+		if _, ok, _ := unstructured.NestedFieldNoCopy(p.proposed.Config, strings.Split(value.path, ".")...); !ok {
+			continue
+		}
+		if value.replacement == "" {
+			problems = append(problems, fmt.Sprintf("The value %s is deprecated and has no effect. Remove it", value.path))
+		} else {
+			problems = append(problems, fmt.Sprintf("The value %s is deprecated. Set %s instead", value.path, value.replacement))
+		}
+	}
+	return "No deprecated values are set", problems, nil
+}
+
+// checkPodSecurity fails if the Pod Security admission of the namespace of
+// the release rejects the pods of the chart: the clients use host ports and
+// host paths, which the baseline level forbids, and the chart's pods don't
+// satisfy the restricted level.
+func (p *preflight) checkPodSecurity() (string, []string, error) {
+	ns, err := p.kubeClient.CoreV1().Namespaces().Get(p.release.Namespace, metav1.GetOptions{})
+	if err != nil {
+		return "", nil, fmt.Errorf("getting namespace %q: %s", p.release.Namespace, err)
+	}
+	switch ns.Labels[podSecurityLabel] {
+	case "restricted":
+		return "", []string{fmt.Sprintf(
+			"Namespace %q enforces the restricted Pod Security level, which the pods of the chart don't satisfy. "+
+				"Label it with %s=privileged", p.release.Namespace, podSecurityLabel)}, nil
+	case "baseline":
+		resources, err := helm.ParseManifest(p.proposed.Manifest)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, resource := range resources {
+			if resource.GetKind() == "DaemonSet" {
+				return "", []string{fmt.Sprintf(
+					"Namespace %q enforces the baseline Pod Security level, which forbids the host ports and paths "+
+						"of daemon set %s. Label it with %s=privileged", p.release.Namespace, resource.GetName(), podSecurityLabel)}, nil
+			}
+		}
+	}
+	return fmt.Sprintf("The Pod Security admission of namespace %q allows the pods of the chart", p.release.Namespace), nil, nil
+}
+
+// checkConsulVersion fails if the upgrade moves the servers by more than
+// maxConsulMinorJump minor versions of Consul, or downgrades them.
+func (p *preflight) checkConsulVersion() (string, []string, error) {
+	current, err := serverVersion(p.current.Manifest)
+	if err != nil {
+		return "", nil, err
+	}
+	proposed, err := serverVersion(p.proposed.Manifest)
+	if err != nil {
+		return "", nil, err
+	}
+	// The servers may be disabled or their image pinned by digest.
+	if current == nil || proposed == nil {
+		return "The version of the Consul servers can't be compared, skipped", nil, nil
+	}
+	upgrade := fmt.Sprintf("%s to %s", current.Original(), proposed.Original())
+	if proposed.LessThan(current) {
+		return "", []string{fmt.Sprintf(
+			"Downgrading the Consul servers from %s isn't supported", upgrade)}, nil
+	}
+	currentSegments, proposedSegments := current.Segments(), proposed.Segments()
+	jump := (proposedSegments[0]-currentSegments[0])*100 + proposedSegments[1] - currentSegments[1]
+	if jump > maxConsulMinorJump {
+		return "", []string{fmt.Sprintf(
+			"Upgrading the Consul servers from %s skips more than %d minor versions. Upgrade through the intermediate versions",
+			upgrade, maxConsulMinorJump)}, nil
+	}
+	return fmt.Sprintf("Upgrading the Consul servers from %s is supported", upgrade), nil, nil
+}
+
+// serverVersion returns the version of Consul of the image of the servers
+// of manifest, or nil if there are none or their image has no version.
+func serverVersion(manifest string) (*goversion.Version, error) {
+	resources, err := helm.ParseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	for _, resource := range resources {
+		if resource.GetKind() != "StatefulSet" {
+			continue
+		}
+		containers, _, _ := unstructured.NestedSlice(resource.Object, "spec", "template", "spec", "containers")
+		for _, item := range containers {
+			container, ok := item.(map[string]interface{})
+			if !ok || container["name"] != "consul" {
+				continue
+			}
+			image := fmt.Sprint(container["image"])
+			i := strings.LastIndex(image, ":")
+			if strings.Contains(image, "@") || i <= strings.LastIndex(image, "/") {
+				return nil, nil
+			}
+			v, err := parseVersion(image[i+1:])
+			if err != nil {
+				return nil, nil
+			}
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+// parseVersion parses the version v without its prerelease and metadata,
+// e.g. v1.18.3-gke.100 as 1.18.3.
+func parseVersion(v string) (*goversion.Version, error) {
+	parsed, err := goversion.NewVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, s := range parsed.Segments() {
+		segments = append(segments, fmt.Sprint(s))
+	}
+	return goversion.NewVersion(strings.Join(segments, "."))
+}
@@ -0,0 +1,269 @@
+package upgrade
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Command upgrades the installation of the Consul Helm chart.
+type Command struct {
+	UI cli.Ui
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+	chart      *k8sflags.HelmFlags
+
+	flagAutoApprove bool
+	flagDryRun      bool
+	flagCheckOnly   bool
+	flagTimeout     time.Duration
+
+	helm            *helm.Client
+	kubeClient      kubernetes.Interface
+	dynamicClient   dynamic.Interface
+	discoveryClient discovery.ServerVersionInterface
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.BoolVar(&c.flagAutoApprove, "auto-approve", false,
+		"If true, upgrades without asking for confirmation.")
+	c.flags.BoolVar(&c.flagDryRun, "dry-run", false,
+		"If true, only shows the changes of the upgrade, without upgrading.")
+	c.flags.BoolVar(&c.flagCheckOnly, "check-only", false,
+		"If true, only runs the pre-flight checks of the upgrade, without showing its changes or upgrading.")
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Minute,
+		"How long to wait for the Consul pods to be ready.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	c.chart = &k8sflags.HelmFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	flags.Merge(c.flags, c.chart.Flags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if _, err := c.chart.ReadValues(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if c.helm == nil {
+		c.helm = &helm.Client{KubeConfig: c.k8s.KubeConfig()}
+	}
+
+	c.UI.Output("==> Finding the Consul installation")
+	release, err := c.helm.Release()
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ Found release %q in namespace %q (chart %s, status %s)",
+		release.Name, release.Namespace, release.Chart, release.Status))
+
+	opts := c.chart.ReleaseOptions(release.Name, release.Namespace)
+	current, err := c.helm.Get(release.Name, release.Namespace)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error getting the release: %s", err))
+		return 1
+	}
+	proposed, err := c.helm.UpgradeDryRun(opts)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error rendering the upgrade: %s", err))
+		return 1
+	}
+
+	if c.flagCheckOnly {
+		return c.preflight(release, current, proposed)
+	}
+
+	valuesDiff, err := helm.DiffValues(current.Config, proposed.Config)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error comparing the values: %s", err))
+		return 1
+	}
+	c.UI.Output("\n==> Changes of the values")
+	if valuesDiff == "" {
+		c.UI.Output("    No changes")
+	} else {
+		c.UI.Output(subcommand.Indent(valuesDiff))
+	}
+
+	changes, err := helm.DiffManifests(current.Manifest, proposed.Manifest)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf(" ✗ Error comparing the resources: %s", err))
+		return 1
+	}
+	c.UI.Output("\n==> Changes of the resources")
+	if len(changes) == 0 {
+		c.UI.Output("    No changes")
+	}
+	for _, change := range changes {
+		c.UI.Output(fmt.Sprintf("    %s %s", change.Op, change.Resource))
+		// Only the diffs of changed resources are shown since the others
+		// are entirely added or removed.
+		if change.Op == helm.Changed {
+			c.UI.Output(subcommand.Indent(change.Diff))
+		}
+	}
+
+	if valuesDiff == "" && len(changes) == 0 {
+		c.UI.Output("\nConsul is up to date. No changes were made.")
+		return 0
+	}
+	if c.flagDryRun {
+		c.UI.Output("\nDry run complete. No changes were made.")
+		return 0
+	}
+	if !c.flagAutoApprove {
+		ok, err := subcommand.Confirm(c.UI, "\nProceed with the upgrade?")
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading the confirmation: %s", err))
+			return 1
+		}
+		if !ok {
+			c.UI.Output("Upgrade cancelled.")
+			return 1
+		}
+	}
+
+	c.UI.Output(fmt.Sprintf("\n==> Upgrading Consul, waiting up to %s for the pods to be ready", c.flagTimeout))
+	opts.Timeout = c.flagTimeout
+	notes, err := c.helm.Upgrade(opts)
+	if err != nil {
+		c.UI.Error(" ✗ " + err.Error())
+		return 1
+	}
+	if notes != "" {
+		c.UI.Output(subcommand.Indent(strings.TrimSpace(notes)))
+	}
+	c.UI.Output(fmt.Sprintf(" ✓ Consul upgraded in namespace %q", release.Namespace))
+	return 0
+}
+
+// preflight runs the pre-flight checks of the upgrade of release from
+// current to proposed, and returns the exit code of the command.
+func (c *Command) preflight(release helm.Release, current, proposed *helm.ReleaseDetails) int {
+	if c.kubeClient == nil || c.dynamicClient == nil || c.discoveryClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		if c.kubeClient == nil {
+			c.kubeClient, err = kubernetes.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+		if c.dynamicClient == nil {
+			c.dynamicClient, err = dynamic.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+		if c.discoveryClient == nil {
+			c.discoveryClient = c.kubeClient.Discovery()
+		}
+	}
+
+	p := &preflight{
+		release:         release,
+		current:         current,
+		proposed:        proposed,
+		kubeClient:      c.kubeClient,
+		dynamicClient:   c.dynamicClient,
+		discoveryClient: c.discoveryClient,
+	}
+	c.UI.Output("\n==> Running pre-flight checks")
+	checks := []func() (string, []string, error){
+		p.checkKubernetesVersion,
+		p.checkCRDs,
+		p.checkDeprecatedValues,
+		p.checkPodSecurity,
+		p.checkConsulVersion,
+	}
+	failed := false
+	for _, check := range checks {
+		ok, problems, err := check()
+		if err != nil {
+			c.UI.Error(" ✗ " + err.Error())
+			return 1
+		}
+		for _, problem := range problems {
+			c.UI.Error(" ✗ " + problem)
+		}
+		if len(problems) == 0 {
+			c.UI.Output(" ✓ " + ok)
+		}
+		failed = failed || len(problems) > 0
+	}
+	if failed {
+		c.UI.Error("\nPre-flight checks failed, fix the problems marked with ✗ before upgrading.")
+		return 1
+	}
+	c.UI.Output("\nPre-flight checks passed. No changes were made.")
+	return 0
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+const synopsis = "Upgrade Consul on Kubernetes"
+const help = `
+Usage: consul-k8s upgrade [options]
+
+  Upgrades the installation of Consul with the Consul Helm chart, e.g. as
+  installed by consul-k8s install, to the chart and values of the flags.
+  The chart is the one embedded in consul-k8s unless -chart is set.
+
+  As with helm upgrade, the values of the installation not set with -f
+  values files or -set flags are reset to the defaults of the chart.
+  Before upgrading, the command shows the diff of the current and
+  proposed values, and the resources that would be added (+), removed (-)
+  or changed (~) with their diff. The upgrade must be confirmed unless
+  -auto-approve is set, and the command waits for the Consul pods to be
+  ready. -dry-run only shows the changes.
+
+  -check-only only runs the pre-flight checks of the upgrade, which fail if
+  the version of Kubernetes isn't supported by the chart, if the chart
+  doesn't serve the stored versions of the CRDs anymore, if deprecated
+  values are set, if the Pod Security admission of the namespace rejects
+  the pods of the chart, or if the upgrade skips more than two minor
+  versions of Consul.
+
+`
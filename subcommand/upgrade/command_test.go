@@ -0,0 +1,230 @@
+package upgrade
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	require.Equal(t, 1, cmd.Run([]string{"-set", "server.replicas"}))
+	require.Contains(t, ui.ErrorWriter.String(), `-set "server.replicas" must be of the form key=value`)
+}
+
+func TestRun_NoInstallation(t *testing.T) {
+	client, err := helm.NewTestClient(nil)
+	require.NoError(t, err)
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, helm: client}
+	require.Equal(t, 1, cmd.Run(nil))
+	require.Contains(t, ui.ErrorWriter.String(), "no installation of Consul found")
+}
+
+func TestRun_Upgrade(t *testing.T) {
+	cases := map[string]struct {
+		flags      []string
+		input      string
+		expCode    int
+		expUpgrade bool
+		expOutput  []string
+	}{
+		"dry run": {
+			flags:   []string{"-dry-run", "-set", "server.replicas=5", "-set", "controller.enabled=true"},
+			expCode: 0,
+			expOutput: []string{
+				"      -  replicas: 3\n      +  replicas: 5\n",
+				"    + Deployment consul-consul-controller\n",
+				"    ~ StatefulSet consul-consul-server\n",
+				"Dry run complete. No changes were made.",
+			},
+		},
+		"up to date": {
+			flags:     []string{"-set", "server.replicas=3"},
+			expCode:   0,
+			expOutput: []string{"Consul is up to date. No changes were made."},
+		},
+		"cancelled": {
+			flags:     []string{"-set", "server.replicas=5"},
+			input:     "no\n",
+			expCode:   1,
+			expOutput: []string{"Upgrade cancelled."},
+		},
+		"confirmed": {
+			flags:      []string{"-set", "server.replicas=5"},
+			input:      "y\n",
+			expCode:    0,
+			expUpgrade: true,
+			expOutput:  []string{`Consul upgraded in namespace "mesh"`},
+		},
+		"auto-approve": {
+			flags:      []string{"-auto-approve", "-set", "server.replicas=5"},
+			expCode:    0,
+			expUpgrade: true,
+			expOutput:  []string{`Consul upgraded in namespace "mesh"`},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, err := helm.NewTestClient(fake.NewSimpleClientset())
+			require.NoError(t, err)
+			_, err = client.Install(helm.ReleaseOptions{Name: "consul", Namespace: "mesh", Set: []string{"server.replicas=3"}})
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			ui.InputReader = strings.NewReader(c.input)
+			cmd := Command{UI: ui, helm: client}
+
+			require.Equal(t, c.expCode, cmd.Run(c.flags), ui.ErrorWriter.String())
+			output := ui.OutputWriter.String()
+			for _, exp := range c.expOutput {
+				require.Contains(t, output, exp)
+			}
+			release, err := client.Release()
+			require.NoError(t, err)
+			if !c.expUpgrade {
+				require.Equal(t, 1, release.Revision)
+				return
+			}
+			require.Equal(t, 2, release.Revision)
+			upgraded, err := client.Get("consul", "mesh")
+			require.NoError(t, err)
+			require.Equal(t, map[string]interface{}{"server": map[string]interface{}{"replicas": int64(5)}}, upgraded.Config)
+		})
+	}
+}
+
+func TestRun_CheckOnly(t *testing.T) {
+	// The manifest of the installation only has the servers the versions of
+	// Consul are compared with.
+	currentManifest := `---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: consul-server
+spec:
+  template:
+    spec:
+      containers:
+      - name: consul
+        image: hashicorp/consul:1.8.0
+`
+
+	cases := map[string]struct {
+		kubernetesVersion string
+		values            []string
+		namespaceLabels   map[string]string
+		storedVersions    []interface{}
+		consulVersion     string
+		expCode           int
+		expOutput         []string
+		expErrors         []string
+	}{
+		"passed": {
+			kubernetesVersion: "v1.18.3",
+			values:            []string{"global.acls.manageSystemACLs=true"},
+			namespaceLabels:   map[string]string{"pod-security.kubernetes.io/enforce": "privileged"},
+			storedVersions:    []interface{}{"v1alpha1"},
+			consulVersion:     "1.9.1",
+			expCode:           0,
+			expOutput: []string{
+				" ✓ Kubernetes v1.18.3 is supported by the chart\n" +
+					" ✓ The stored versions of the CRDs are served by the chart\n" +
+					" ✓ No deprecated values are set\n" +
+					` ✓ The Pod Security admission of namespace "mesh" allows the pods of the chart` + "\n" +
+					" ✓ Upgrading the Consul servers from 1.8.0 to 1.9.1 is supported\n",
+				"Pre-flight checks passed. No changes were made.",
+			},
+		},
+		"failed": {
+			kubernetesVersion: "v1.25.2-gke.100",
+			values: []string{
+				"global.enablePodSecurityPolicies=true",
+				"connectInject.centralConfig.enabled=true",
+			},
+			namespaceLabels: map[string]string{"pod-security.kubernetes.io/enforce": "baseline"},
+			storedVersions:  []interface{}{"v1alpha0", "v1alpha1"},
+			consulVersion:   "1.11.0",
+			expCode:         1,
+			expErrors: []string{
+				" ✗ global.enablePodSecurityPolicies is set but Kubernetes v1.25.2-gke.100 doesn't serve pod security policies. Unset it and use the Pod Security admission instead\n",
+				" ✗ CRD servicedefaults.consul.hashicorp.com stores resources in version v1alpha0, which the chart doesn't serve anymore. " +
+					"Migrate the resources to a served version and remove v1alpha0 from its stored versions\n",
+				" ✗ The value connectInject.centralConfig is deprecated and has no effect. Remove it\n",
+				` ✗ Namespace "mesh" enforces the baseline Pod Security level, which forbids the host ports and paths of daemon set consul-consul. ` +
+					"Label it with pod-security.kubernetes.io/enforce=privileged\n",
+				" ✗ Upgrading the Consul servers from 1.8.0 to 1.11.0 skips more than 2 minor versions. Upgrade through the intermediate versions\n",
+				"Pre-flight checks failed, fix the problems marked with ✗ before upgrading.",
+			},
+		},
+		"old Kubernetes": {
+			kubernetesVersion: "v1.15.3",
+			storedVersions:    []interface{}{"v1alpha1"},
+			consulVersion:     "1.8.0",
+			expCode:           1,
+			expErrors: []string{
+				" ✗ Kubernetes v1.15.3 isn't supported, the chart requires Kubernetes 1.16.0 or later. Upgrade Kubernetes first\n",
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, err := helm.NewTestClient(nil, helm.TestRelease{
+				Name:      "consul",
+				Namespace: "mesh",
+				Chart:     "consul-0.24.1",
+				Manifest:  currentManifest,
+			})
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:   ui,
+				helm: client,
+				kubeClient: fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+					Name:   "mesh",
+					Labels: c.namespaceLabels,
+				}}),
+				dynamicClient: &helm.TestCRDClient{CRDs: []unstructured.Unstructured{{Object: map[string]interface{}{
+					"metadata": map[string]interface{}{"name": "servicedefaults.consul.hashicorp.com"},
+					"spec":     map[string]interface{}{"group": "consul.hashicorp.com"},
+					"status":   map[string]interface{}{"storedVersions": c.storedVersions},
+				}}}},
+				discoveryClient: &fakediscovery.FakeDiscovery{
+					Fake:               &k8stesting.Fake{},
+					FakedServerVersion: &version.Info{GitVersion: c.kubernetesVersion},
+				},
+			}
+
+			// The CRDs are only rendered with the controller.
+			flags := []string{"-check-only", "-set", "controller.enabled=true", "-set", "global.image=hashicorp/consul:" + c.consulVersion}
+			for _, value := range c.values {
+				flags = append(flags, "-set", value)
+			}
+			require.Equal(t, c.expCode, cmd.Run(flags), ui.ErrorWriter.String())
+			output := ui.OutputWriter.String()
+			for _, exp := range c.expOutput {
+				require.Contains(t, output, exp)
+			}
+			require.NotContains(t, output, "==> Changes of the values")
+			errs := ui.ErrorWriter.String()
+			for _, exp := range c.expErrors {
+				require.Contains(t, errs, exp)
+			}
+			release, err := client.Release()
+			require.NoError(t, err)
+			require.Equal(t, 1, release.Revision, "only dry runs are allowed")
+		})
+	}
+}
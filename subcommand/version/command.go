@@ -1,25 +1,312 @@
 package version
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/hashicorp/consul-k8s/helper/consul"
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul-k8s/subcommand"
+	k8sflags "github.com/hashicorp/consul-k8s/subcommand/flags"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/command/flags"
 	"github.com/mitchellh/cli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
+// Command prints the version of the CLI and of the installation of the
+// Consul Helm chart.
 type Command struct {
 	UI      cli.Ui
 	Version string
+	// GitCommit and BuildDate are those of the build of the CLI. They're
+	// empty for the builds without them.
+	GitCommit string
+	BuildDate string
+
+	flags      *flag.FlagSet
+	configFile *k8sflags.ConfigFileFlags
+	k8s        *k8sflags.K8SFlags
+	http       *flags.HTTPFlags
+
+	flagOutput string
+	flagConsul bool
+
+	helm         *helm.Client
+	kubeClient   kubernetes.Interface
+	consulClient *api.Client
+
+	once sync.Once
+	help string
+}
+
+// versions is the versions reported by the command. The versions of the
+// installation are empty when they are unknown.
+type versions struct {
+	CLI           string          `json:"cli"`
+	GitCommit     string          `json:"gitCommit,omitempty"`
+	BuildDate     string          `json:"buildDate,omitempty"`
+	GoVersion     string          `json:"goVersion"`
+	Chart         string          `json:"chart,omitempty"`
+	ControlPlane  string          `json:"controlPlane,omitempty"`
+	Consul        string          `json:"consul,omitempty"`
+	ConsulAgent   string          `json:"consulAgent,omitempty"`
+	ConsulServers []serverVersion `json:"consulServers,omitempty"`
+	// Unsupported is true if the versions aren't supported together, i.e.
+	// if there are compatibility warnings.
+	Unsupported bool     `json:"unsupported,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// serverVersion is the version of a Consul server, as probed with -consul.
+type serverVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
 }
 
-func (c *Command) Run(_ []string) int {
-	c.UI.Output(fmt.Sprintf("consul-k8s %s", c.Version))
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagOutput, "o", "text",
+		"Output format, text or json.")
+	c.flags.BoolVar(&c.flagConsul, "consul", false,
+		"If true, the versions of the Consul agent and of the Consul servers are probed with the Consul "+
+			"HTTP API and checked too.")
+
+	c.k8s = &k8sflags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	c.configFile = &k8sflags.ConfigFileFlags{}
+	flags.Merge(c.flags, c.configFile.Flags())
+	c.help = flags.Usage(help, c.flags)
+}
+
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if err := c.configFile.Apply(c.flags); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagOutput != "text" && c.flagOutput != "json" {
+		c.UI.Error(fmt.Sprintf("-o must be text or json, not %q", c.flagOutput))
+		return 1
+	}
+
+	v := versions{
+		CLI:       c.Version,
+		GitCommit: c.GitCommit,
+		BuildDate: c.BuildDate,
+		GoVersion: runtime.Version(),
+	}
+	// The CLI is usable without an installation, so failing to inspect it
+	// is only a warning.
+	var compatibilityWarnings []string
+	if err := c.installed(&v); err != nil {
+		v.Warnings = append(v.Warnings, fmt.Sprintf("Could not inspect the installation: %s", err))
+	} else {
+		compatibilityWarnings = checkCompatibility(v)
+	}
+	if c.flagConsul {
+		if err := c.probe(&v); err != nil {
+			v.Warnings = append(v.Warnings, fmt.Sprintf("Could not probe Consul: %s", err))
+		} else {
+			compatibilityWarnings = append(compatibilityWarnings, checkConsulVersions(v)...)
+		}
+	}
+	v.Unsupported = len(compatibilityWarnings) > 0
+	v.Warnings = append(v.Warnings, compatibilityWarnings...)
+
+	if c.flagOutput == "json" {
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error encoding the versions: %s", err))
+			return 1
+		}
+		c.UI.Output(string(out))
+		return 0
+	}
+	c.UI.Output(fmt.Sprintf("consul-k8s %s", v.CLI))
+	if v.GitCommit != "" {
+		c.UI.Output(fmt.Sprintf("    Git commit:     %s", v.GitCommit))
+	}
+	if v.BuildDate != "" {
+		c.UI.Output(fmt.Sprintf("    Build date:     %s", v.BuildDate))
+	}
+	c.UI.Output(fmt.Sprintf("    Go version:     %s", v.GoVersion))
+	if v.Chart != "" {
+		c.UI.Output(fmt.Sprintf("    Chart:          %s", v.Chart))
+		c.UI.Output(fmt.Sprintf("    Control plane:  %s", unknown(v.ControlPlane)))
+		c.UI.Output(fmt.Sprintf("    Consul:         %s", unknown(v.Consul)))
+	}
+	if v.ConsulAgent != "" {
+		c.UI.Output(fmt.Sprintf("    Consul agent:   %s", v.ConsulAgent))
+	}
+	for _, server := range v.ConsulServers {
+		c.UI.Output(fmt.Sprintf("    Consul server:  %s (%s)", server.Version, server.Name))
+	}
+	for _, warning := range v.Warnings {
+		c.UI.Warn(" ! " + warning)
+	}
 	return 0
 }
 
+// installed sets the versions of the installation in v. The version of the
+// control plane is the tag of the consul-k8s image of the deployments of the
+// release, and the version of Consul the tag of the image of the servers,
+// or the app version of the chart when the servers aren't installed.
+func (c *Command) installed(v *versions) error {
+	if c.kubeClient == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			return fmt.Errorf("retrieving Kubernetes auth: %s", err)
+		}
+		c.kubeClient, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("initializing Kubernetes client: %s", err)
+		}
+	}
+	if c.helm == nil {
+		c.helm = &helm.Client{KubeConfig: c.k8s.KubeConfig()}
+	}
+
+	release, err := c.helm.Release()
+	if err != nil {
+		return err
+	}
+	v.Chart = release.ChartVersion()
+	v.Consul = release.AppVersion
+
+	apps := c.kubeClient.AppsV1()
+	selector := metav1.ListOptions{LabelSelector: fmt.Sprintf("app=consul,release=%s", release.Name)}
+	deployments, err := apps.Deployments(release.Namespace).List(selector)
+	if err != nil {
+		return fmt.Errorf("listing the deployments: %s", err)
+	}
+	for _, deployment := range deployments.Items {
+		for _, container := range deployment.Spec.Template.Spec.Containers {
+			if repository, tag := imageTag(container); repository == "consul-k8s" && tag != "" {
+				v.ControlPlane = tag
+			}
+		}
+	}
+
+	selector.LabelSelector += ",component=server"
+	servers, err := apps.StatefulSets(release.Namespace).List(selector)
+	if err != nil {
+		return fmt.Errorf("listing the stateful sets: %s", err)
+	}
+	// The image of the servers may be consul or consul-enterprise, or a
+	// mirror of them.
+	for _, set := range servers.Items {
+		for _, container := range set.Spec.Template.Spec.Containers {
+			if _, tag := imageTag(container); container.Name == "consul" && tag != "" {
+				v.Consul = tag
+			}
+		}
+	}
+	return nil
+}
+
+// probe sets the versions of the Consul agent of -http-addr and of the
+// Consul servers of its datacenter in v. The versions of the servers are
+// those of the build tags of their LAN members.
+func (c *Command) probe(v *versions) error {
+	if c.consulClient == nil {
+		cfg := api.DefaultConfig()
+		c.http.MergeOntoConfig(cfg)
+		var err error
+		c.consulClient, err = consul.NewClient(cfg)
+		if err != nil {
+			return fmt.Errorf("creating the Consul client: %s", err)
+		}
+	}
+
+	self, err := c.consulClient.Agent().Self()
+	if err != nil {
+		return fmt.Errorf("getting the agent configuration: %s", err)
+	}
+	if version, ok := self["Config"]["Version"].(string); ok {
+		v.ConsulAgent = version
+	}
+
+	members, err := c.consulClient.Agent().Members(false)
+	if err != nil {
+		return fmt.Errorf("listing the members: %s", err)
+	}
+	for _, member := range members {
+		if member.Tags["role"] != "consul" {
+			continue
+		}
+		// The build tag is the version followed by the git commit, such as
+		// 1.8.4:12b16df3.
+		version := member.Tags["build"]
+		if i := strings.Index(version, ":"); i >= 0 {
+			version = version[:i]
+		}
+		v.ConsulServers = append(v.ConsulServers, serverVersion{Name: member.Name, Version: version})
+	}
+	sort.Slice(v.ConsulServers, func(i, j int) bool {
+		return v.ConsulServers[i].Name < v.ConsulServers[j].Name
+	})
+	return nil
+}
+
+// imageTag returns the tag of the image of container. It returns the name
+// of the repository of the image, such as consul-k8s for
+// hashicorp/consul-k8s, and "" as tag when the image has no tag or is pinned
+// by digest.
+func imageTag(container corev1.Container) (repository, tag string) {
+	image := container.Image
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	} else if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image, tag = image[:i], image[i+1:]
+	}
+	return image[strings.LastIndex(image, "/")+1:], tag
+}
+
+func unknown(version string) string {
+	if version == "" {
+		return "unknown"
+	}
+	return version
+}
+
 func (c *Command) Synopsis() string {
-	return "Prints the version"
+	return synopsis
 }
 
 func (c *Command) Help() string {
-	return ""
+	c.once.Do(c.init)
+	return c.help
 }
+
+const synopsis = "Prints the versions of the CLI and of the installation"
+const help = `
+Usage: consul-k8s version [options]
+
+  Prints the version of the CLI and, when Consul is installed, the versions
+  of the Helm chart, of the consul-k8s control plane and of the Consul
+  servers. It warns about the combinations of versions that aren't supported
+  together.
+
+  The versions of the installation are omitted when it can't be inspected.
+  With -consul, the versions of the Consul agent of -http-addr and of the
+  Consul servers it knows about are probed and checked too. The -o json
+  output has "unsupported": true if any check fails.
+`
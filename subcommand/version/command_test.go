@@ -0,0 +1,309 @@
+package version
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	goruntime "runtime"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/helper/helm"
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{"foo"},
+			expErr: "Should have no non-flag arguments.",
+		},
+		{
+			flags:  []string{"-o", "yaml"},
+			expErr: `-o must be text or json, not "yaml"`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			require.Equal(t, 1, cmd.Run(c.flags))
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun_NoInstallation(t *testing.T) {
+	client, err := helm.NewTestClient(nil)
+	require.NoError(t, err)
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:         ui,
+		Version:    "0.13.0-dev",
+		kubeClient: fake.NewSimpleClientset(),
+		helm:       client,
+	}
+	require.Equal(t, 0, cmd.Run(nil))
+	require.Equal(t, "consul-k8s 0.13.0-dev\n    Go version:     "+goruntime.Version()+"\n", ui.OutputWriter.String())
+	require.Equal(t, " ! Could not inspect the installation: no installation of Consul found\n", ui.ErrorWriter.String())
+}
+
+func TestRun_Versions(t *testing.T) {
+	cases := map[string]struct {
+		objects   []runtime.Object
+		flags     []string
+		expOutput string
+		expErrors string
+	}{
+		"compatible": {
+			objects: []runtime.Object{
+				deployment("consul-connect-injector-webhook-deployment", "hashicorp/consul-k8s:0.13.0"),
+				statefulSet("hashicorp/consul-enterprise:1.7.4-ent"),
+			},
+			expOutput: "consul-k8s 0.13.0-dev\n" +
+				"    Go version:     " + goruntime.Version() + "\n" +
+				"    Chart:          0.19.0\n" +
+				"    Control plane:  0.13.0\n" +
+				"    Consul:         1.7.4-ent\n",
+		},
+		"unsupported": {
+			objects: []runtime.Object{
+				deployment("consul-connect-injector-webhook-deployment", "registry.local:5000/hashicorp/consul-k8s:0.12.0"),
+				statefulSet("hashicorp/consul:1.5.3"),
+			},
+			expOutput: "consul-k8s 0.13.0-dev\n" +
+				"    Go version:     " + goruntime.Version() + "\n" +
+				"    Chart:          0.19.0\n" +
+				"    Control plane:  0.12.0\n" +
+				"    Consul:         1.5.3\n",
+			expErrors: " ! The CLI (0.13.0-dev) and the control plane (0.12.0) have different minor versions, use the CLI matching the control plane\n" +
+				" ! consul-k8s 0.12.0 supports the versions ~> 0.18.0 of the Helm chart, not 0.19.0\n" +
+				" ! consul-k8s 0.12.0 supports the versions >= 1.6.0 of Consul, not 1.5.3\n",
+		},
+		"unknown control plane": {
+			objects: []runtime.Object{
+				statefulSet("hashicorp/consul:1.7.2"),
+			},
+			expOutput: "consul-k8s 0.13.0-dev\n" +
+				"    Go version:     " + goruntime.Version() + "\n" +
+				"    Chart:          0.19.0\n" +
+				"    Control plane:  unknown\n" +
+				"    Consul:         1.7.2\n",
+		},
+		"json": {
+			objects: []runtime.Object{
+				deployment("consul-sync-catalog", "hashicorp/consul-k8s:0.99.0"),
+			},
+			flags: []string{"-o", "json"},
+			expOutput: `{
+  "cli": "0.13.0-dev",
+  "goVersion": "` + goruntime.Version() + `",
+  "chart": "0.19.0",
+  "controlPlane": "0.99.0",
+  "consul": "1.7.2",
+  "unsupported": true,
+  "warnings": [
+    "The CLI (0.13.0-dev) and the control plane (0.99.0) have different minor versions, use the CLI matching the control plane",
+    "The compatibility of consul-k8s 0.99.0 is unknown to this CLI"
+  ]
+}
+`,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			client, err := helm.NewTestClient(nil, helm.TestRelease{
+				Name:       "consul",
+				Namespace:  "mesh",
+				Chart:      "consul-0.19.0",
+				AppVersion: "1.7.2",
+			})
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:         ui,
+				Version:    "0.13.0-dev",
+				kubeClient: fake.NewSimpleClientset(c.objects...),
+				helm:       client,
+			}
+			require.Equal(t, 0, cmd.Run(c.flags))
+			require.Equal(t, c.expOutput, ui.OutputWriter.String())
+			require.Equal(t, c.expErrors, ui.ErrorWriter.String())
+		})
+	}
+}
+
+func TestRun_BuildMetadata(t *testing.T) {
+	client, err := helm.NewTestClient(nil)
+	require.NoError(t, err)
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:         ui,
+		Version:    "0.13.0",
+		GitCommit:  "3762b70",
+		BuildDate:  "2020-08-01T10:00:00Z",
+		kubeClient: fake.NewSimpleClientset(),
+		helm:       client,
+	}
+	require.Equal(t, 0, cmd.Run(nil))
+	require.Equal(t, "consul-k8s 0.13.0\n"+
+		"    Git commit:     3762b70\n"+
+		"    Build date:     2020-08-01T10:00:00Z\n"+
+		"    Go version:     "+goruntime.Version()+"\n", ui.OutputWriter.String())
+}
+
+// Test that the versions of the Consul agent and servers are probed and
+// checked against those supported by the control plane, or by the CLI if
+// it's not installed.
+func TestRun_ConsulVersions(t *testing.T) {
+	cases := map[string]struct {
+		installed      bool
+		agent          string
+		servers        map[string]string
+		expUnsupported bool
+		expWarnings    []string
+	}{
+		"supported": {
+			installed: true,
+			agent:     "1.7.4",
+			servers:   map[string]string{"consul-server-0": "1.7.4:12b16df3", "consul-server-1": "1.7.4:12b16df3"},
+		},
+		"unsupported agent": {
+			installed:      true,
+			agent:          "1.6.2",
+			servers:        map[string]string{"consul-server-0": "1.7.4:12b16df3"},
+			expUnsupported: true,
+			expWarnings:    []string{"consul-k8s 0.13.0 supports the versions >= 1.7.0 of Consul, not 1.6.2 (agent)"},
+		},
+		"upgrading servers": {
+			agent:          "1.7.4",
+			servers:        map[string]string{"consul-server-0": "1.6.2:e4f2c3a1", "consul-server-1": "1.7.4:12b16df3"},
+			expUnsupported: true,
+			expWarnings: []string{
+				"Could not inspect the installation: no installation of Consul found",
+				"consul-k8s 0.13.0-dev supports the versions >= 1.7.0 of Consul, not 1.6.2 (server consul-server-0)",
+				"The Consul servers run different versions (1.6.2, 1.7.4), finish upgrading them",
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v1/agent/self":
+					fmt.Fprintf(w, `{"Config": {"Version": %q}}`, c.agent)
+				case "/v1/agent/members":
+					var members []*api.AgentMember
+					for name, build := range c.servers {
+						members = append(members, &api.AgentMember{Name: name, Tags: map[string]string{"role": "consul", "build": build}})
+					}
+					members = append(members, &api.AgentMember{Name: "client", Tags: map[string]string{"role": "node", "build": "1.5.0:0"}})
+					require.NoError(t, json.NewEncoder(w).Encode(members))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+			consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+			require.NoError(t, err)
+
+			var releases []helm.TestRelease
+			var objects []runtime.Object
+			if c.installed {
+				releases = append(releases, helm.TestRelease{Name: "consul", Namespace: "mesh", Chart: "consul-0.19.0", AppVersion: "1.7.4"})
+				objects = append(objects, deployment("consul-connect-injector-webhook-deployment", "hashicorp/consul-k8s:0.13.0"))
+			}
+			helmClient, err := helm.NewTestClient(nil, releases...)
+			require.NoError(t, err)
+			ui := cli.NewMockUi()
+			cmd := Command{
+				UI:           ui,
+				Version:      "0.13.0-dev",
+				kubeClient:   fake.NewSimpleClientset(objects...),
+				consulClient: consulClient,
+				helm:         helmClient,
+			}
+			require.Equal(t, 0, cmd.Run([]string{"-consul", "-o", "json"}))
+			var v versions
+			require.NoError(t, json.Unmarshal(ui.OutputWriter.Bytes(), &v))
+			require.Equal(t, c.agent, v.ConsulAgent)
+			require.Len(t, v.ConsulServers, len(c.servers))
+			for _, server := range v.ConsulServers {
+				require.Equal(t, c.servers[server.Name][:5], server.Version)
+			}
+			require.Equal(t, c.expUnsupported, v.Unsupported)
+			require.Equal(t, c.expWarnings, v.Warnings)
+		})
+	}
+}
+
+func TestRun_ConsulUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	consulClient, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
+
+	client, err := helm.NewTestClient(nil)
+	require.NoError(t, err)
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:           ui,
+		Version:      "0.13.0-dev",
+		kubeClient:   fake.NewSimpleClientset(),
+		consulClient: consulClient,
+		helm:         client,
+	}
+	require.Equal(t, 0, cmd.Run([]string{"-consul"}))
+	require.Contains(t, ui.ErrorWriter.String(), " ! Could not probe Consul: getting the agent configuration: ")
+}
+
+func TestImageTag(t *testing.T) {
+	cases := map[string][2]string{
+		"hashicorp/consul-k8s:0.18.1":                  {"consul-k8s", "0.18.1"},
+		"localhost:5000/consul-k8s:0.18.1":             {"consul-k8s", "0.18.1"},
+		"localhost:5000/consul-k8s":                    {"consul-k8s", ""},
+		"consul:1.8.4":                                 {"consul", "1.8.4"},
+		"hashicorp/consul-k8s@sha256:0123456789abcdef": {"consul-k8s", ""},
+	}
+	for image, exp := range cases {
+		repository, tag := imageTag(corev1.Container{Image: image})
+		require.Equal(t, exp, [2]string{repository, tag}, image)
+	}
+}
+
+func deployment(name, image string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "mesh",
+			Labels:    map[string]string{"app": "consul", "release": "consul"},
+		},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "sidecar-injector", Image: image}},
+		}}},
+	}
+}
+
+func statefulSet(image string) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "consul-server",
+			Namespace: "mesh",
+			Labels:    map[string]string{"app": "consul", "release": "consul", "component": "server"},
+		},
+		Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "consul", Image: image}},
+		}}},
+	}
+}
@@ -0,0 +1,143 @@
+package version
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	goversion "github.com/hashicorp/go-version"
+)
+
+// compatibility is the versions of the Helm chart and of Consul supported by
+// a minor version of consul-k8s.
+type compatibility struct {
+	ConsulK8s string
+	Chart     string
+	Consul    string
+}
+
+// compatibilities is the compatibility matrix of consul-k8s. It must be
+// updated with each minor release of consul-k8s or of the Helm chart.
+var compatibilities = []compatibility{
+	{ConsulK8s: "0.12", Chart: "~> 0.18.0", Consul: ">= 1.6.0"},
+	{ConsulK8s: "0.13", Chart: "~> 0.19.0", Consul: ">= 1.7.0"},
+	{ConsulK8s: "0.14", Chart: "~> 0.20.0", Consul: ">= 1.7.0"},
+	{ConsulK8s: "0.15", Chart: "~> 0.21.0", Consul: ">= 1.7.0"},
+	{ConsulK8s: "0.16", Chart: "~> 0.22.0", Consul: ">= 1.8.0"},
+	{ConsulK8s: "0.17", Chart: "~> 0.23.0", Consul: ">= 1.8.0"},
+	{ConsulK8s: "0.18", Chart: "~> 0.24.0", Consul: ">= 1.8.0"},
+}
+
+// checkCompatibility returns the warnings about the unsupported combinations
+// of the versions of v. The versions that are unknown aren't checked.
+func checkCompatibility(v versions) []string {
+	var warnings []string
+	cli, _ := parse(v.CLI)
+	controlPlane, err := parse(v.ControlPlane)
+	if err != nil {
+		return nil
+	}
+	if cli != nil && minor(cli) != minor(controlPlane) {
+		warnings = append(warnings, fmt.Sprintf(
+			"The CLI (%s) and the control plane (%s) have different minor versions, use the CLI matching the control plane",
+			v.CLI, v.ControlPlane))
+	}
+
+	var supported *compatibility
+	for i := range compatibilities {
+		if compatibilities[i].ConsulK8s == minor(controlPlane) {
+			supported = &compatibilities[i]
+		}
+	}
+	if supported == nil {
+		return append(warnings, fmt.Sprintf(
+			"The compatibility of consul-k8s %s is unknown to this CLI", v.ControlPlane))
+	}
+	if chart, err := parse(v.Chart); err == nil && !satisfies(chart, supported.Chart) {
+		warnings = append(warnings, fmt.Sprintf(
+			"consul-k8s %s supports the versions %s of the Helm chart, not %s",
+			v.ControlPlane, supported.Chart, v.Chart))
+	}
+	if consul, err := parse(v.Consul); err == nil && !satisfies(consul, supported.Consul) {
+		warnings = append(warnings, fmt.Sprintf(
+			"consul-k8s %s supports the versions %s of Consul, not %s",
+			v.ControlPlane, supported.Consul, v.Consul))
+	}
+	return warnings
+}
+
+// checkConsulVersions returns the warnings about the versions of the
+// Consul agent and servers probed with -consul that aren't supported by the
+// control plane, or by the CLI if the version of the control plane is
+// unknown, and about servers running different versions.
+func checkConsulVersions(v versions) []string {
+	consulK8s := v.ControlPlane
+	if _, err := parse(consulK8s); err != nil {
+		consulK8s = v.CLI
+	}
+	parsed, err := parse(consulK8s)
+	if err != nil {
+		return nil
+	}
+	var supported *compatibility
+	for i := range compatibilities {
+		if compatibilities[i].ConsulK8s == minor(parsed) {
+			supported = &compatibilities[i]
+		}
+	}
+
+	var warnings []string
+	check := func(what, version string) {
+		if consul, err := parse(version); supported != nil && err == nil && !satisfies(consul, supported.Consul) {
+			warnings = append(warnings, fmt.Sprintf(
+				"consul-k8s %s supports the versions %s of Consul, not %s (%s)",
+				consulK8s, supported.Consul, version, what))
+		}
+	}
+	check("agent", v.ConsulAgent)
+	seen := make(map[string]bool)
+	var serverVersions []string
+	for _, server := range v.ConsulServers {
+		check("server "+server.Name, server.Version)
+		if !seen[server.Version] {
+			seen[server.Version] = true
+			serverVersions = append(serverVersions, server.Version)
+		}
+	}
+	if len(serverVersions) > 1 {
+		sort.Strings(serverVersions)
+		warnings = append(warnings, fmt.Sprintf(
+			"The Consul servers run different versions (%s), finish upgrading them",
+			strings.Join(serverVersions, ", ")))
+	}
+	return warnings
+}
+
+// parse parses the version v without its prerelease and metadata, so that
+// development and enterprise versions, such as 0.13.0-dev or 1.8.4-ent, are
+// checked as the versions they are built from.
+func parse(v string) (*goversion.Version, error) {
+	parsed, err := goversion.NewVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, s := range parsed.Segments() {
+		segments = append(segments, fmt.Sprint(s))
+	}
+	return goversion.NewVersion(strings.Join(segments, "."))
+}
+
+// minor returns the major and minor version of v, such as 0.13.
+func minor(v *goversion.Version) string {
+	segments := v.Segments()
+	return fmt.Sprintf("%d.%d", segments[0], segments[1])
+}
+
+func satisfies(v *goversion.Version, constraint string) bool {
+	constraints, err := goversion.NewConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	return constraints.Check(v)
+}
@@ -6,9 +6,11 @@ import (
 )
 
 var (
-	// The git commit that was compiled. These will be filled in by the compiler.
+	// The git commit that was compiled and the UTC date of the build. These
+	// will be filled in by the compiler.
 	GitCommit   string
 	GitDescribe string
+	BuildDate   string
 
 	// The main version number that is being run at the moment.
 	//